@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"memory-tools/internal/protocol"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// benchSeedLimit caps how many documents are preloaded for get and query benchmarks.
+const benchSeedLimit = 1000
+
+// benchResult holds the measurements of a benchmark run.
+type benchResult struct {
+	latencies []time.Duration
+	errors    int64
+	elapsed   time.Duration
+}
+
+// handleBench handles the "bench" command. It runs N operations against a
+// throwaway collection, optionally over several connections, and reports
+// throughput and latency percentiles. The collection is deleted afterwards.
+func (c *cli) handleBench(args string) error {
+	usage := errors.New("usage: bench <set|get|query> <n> [concurrency]")
+	parts := strings.Fields(args)
+	if len(parts) < 2 || len(parts) > 3 {
+		return usage
+	}
+	op := parts[0]
+	if op != "set" && op != "get" && op != "query" {
+		return usage
+	}
+	n, err := strconv.Atoi(parts[1])
+	if err != nil || n <= 0 {
+		return fmt.Errorf("invalid operation count '%s': must be a positive integer", parts[1])
+	}
+	concurrency := 1
+	if len(parts) == 3 {
+		concurrency, err = strconv.Atoi(parts[2])
+		if err != nil || concurrency <= 0 {
+			return fmt.Errorf("invalid concurrency '%s': must be a positive integer", parts[2])
+		}
+	}
+	concurrency = min(concurrency, n)
+	if c.inTransaction {
+		return errors.New("bench cannot run inside a transaction")
+	}
+
+	collName := fmt.Sprintf("bench_%d", time.Now().UnixNano())
+	if err := c.benchExec(func(w *bytes.Buffer) { protocol.WriteCollectionCreateCommand(w, collName) }); err != nil {
+		return fmt.Errorf("could not create benchmark collection: %w", err)
+	}
+	defer func() {
+		if err := c.benchExec(func(w *bytes.Buffer) { protocol.WriteCollectionDeleteCommand(w, collName) }); err != nil {
+			fmt.Println(colorErr("Could not delete benchmark collection '", collName, "': ", err))
+		}
+	}()
+
+	seedCount := 0
+	if op != "set" {
+		seedCount = min(n, benchSeedLimit)
+		fmt.Println(colorInfo("Seeding ", seedCount, " documents into '", collName, "'..."))
+		for i := range seedCount {
+			key, doc := benchDocument(i)
+			if err := c.benchExec(func(w *bytes.Buffer) { protocol.WriteCollectionItemSetCommand(w, collName, key, doc, 0) }); err != nil {
+				return fmt.Errorf("could not seed benchmark collection: %w", err)
+			}
+		}
+	}
+
+	conns, err := c.benchConnections(concurrency)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, conn := range conns {
+			if conn != c.conn {
+				conn.Close()
+			}
+		}
+	}()
+
+	fmt.Println(colorInfo("Running ", n, " '", op, "' operations with concurrency ", concurrency, "..."))
+	result := runBench(conns, n, func(w *bytes.Buffer, i int) {
+		switch op {
+		case "set":
+			key, doc := benchDocument(i)
+			protocol.WriteCollectionItemSetCommand(w, collName, key, doc, 0)
+		case "get":
+			key, _ := benchDocument(i % seedCount)
+			protocol.WriteCollectionItemGetCommand(w, collName, key, nil)
+		case "query":
+			query := fmt.Sprintf(`{"filter":{"field":"seq","op":"=","value":%d}}`, i%seedCount)
+			protocol.WriteCollectionQueryCommand(w, collName, []byte(query))
+		}
+	})
+
+	printBenchResult(op, n, concurrency, result)
+	return nil
+}
+
+// benchDocument returns the key and document used for the i-th benchmark item.
+func benchDocument(i int) (string, []byte) {
+	return fmt.Sprintf("bench-%d", i), fmt.Appendf(nil, `{"seq":%d,"payload":"memory-tools-benchmark"}`, i)
+}
+
+// benchExec sends a single command on the main connection and fails on a non-OK status.
+func (c *cli) benchExec(write func(w *bytes.Buffer)) error {
+	var cmdBuf bytes.Buffer
+	write(&cmdBuf)
+	if _, err := c.conn.Write(cmdBuf.Bytes()); err != nil {
+		return err
+	}
+	status, msg, _, err := c.readRawResponse()
+	if err != nil {
+		return err
+	}
+	if status != protocol.StatusOk {
+		return fmt.Errorf("%s: %s", getStatusString(status), msg)
+	}
+	return nil
+}
+
+// benchConnections returns one authenticated connection per worker.
+// A single worker reuses the current connection; more workers dial new ones.
+func (c *cli) benchConnections(concurrency int) ([]net.Conn, error) {
+	if concurrency == 1 {
+		return []net.Conn{c.conn}, nil
+	}
+	if c.dial == nil {
+		return nil, errors.New("concurrent benchmarks are not supported by this connection")
+	}
+
+	conns := make([]net.Conn, 0, concurrency)
+	for range concurrency {
+		conn, err := c.openConnection()
+		if err != nil {
+			closeAll(conns)
+			return nil, fmt.Errorf("could not open benchmark connection: %w", err)
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// openConnection dials a new connection to the server and logs it in as the current session.
+func (c *cli) openConnection() (net.Conn, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	if c.password == "" && c.apiKey == "" {
+		// Logged in by client certificate, which every new connection presents as well.
+		return conn, nil
+	}
+
+	var cmdBuf bytes.Buffer
+	if c.apiKey != "" {
+		protocol.WriteAuthenticateTokenCommand(&cmdBuf, c.apiKey)
+	} else {
+		protocol.WriteAuthenticateCommand(&cmdBuf, c.currentUser, c.password)
+	}
+	if _, err := conn.Write(cmdBuf.Bytes()); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("authentication failed: %w", err)
+	}
+	status, msg, _, err := readResponseFrom(conn)
+	if err != nil || status != protocol.StatusOk {
+		conn.Close()
+		return nil, fmt.Errorf("authentication failed: %s %v", msg, err)
+	}
+	return conn, nil
+}
+
+// closeAll closes every connection in conns.
+func closeAll(conns []net.Conn) {
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// runBench distributes n operations over the connections and times each round trip.
+func runBench(conns []net.Conn, n int, write func(w *bytes.Buffer, i int)) benchResult {
+	var next atomic.Int64
+	var errCount atomic.Int64
+	perWorker := make([][]time.Duration, len(conns))
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w, conn := range conns {
+		wg.Add(1)
+		go func(w int, conn net.Conn) {
+			defer wg.Done()
+			var cmdBuf bytes.Buffer
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= n {
+					return
+				}
+				cmdBuf.Reset()
+				write(&cmdBuf, i)
+
+				opStart := time.Now()
+				if _, err := conn.Write(cmdBuf.Bytes()); err != nil {
+					errCount.Add(1)
+					continue
+				}
+				status, _, _, err := readResponseFrom(conn)
+				perWorker[w] = append(perWorker[w], time.Since(opStart))
+				if err != nil || status != protocol.StatusOk {
+					errCount.Add(1)
+				}
+			}
+		}(w, conn)
+	}
+	wg.Wait()
+
+	result := benchResult{errors: errCount.Load(), elapsed: time.Since(start)}
+	for _, l := range perWorker {
+		result.latencies = append(result.latencies, l...)
+	}
+	sort.Slice(result.latencies, func(i, j int) bool { return result.latencies[i] < result.latencies[j] })
+	return result
+}
+
+// percentile returns the p-th percentile of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * p / 100)
+	return sorted[idx]
+}
+
+// printBenchResult renders the benchmark summary as a table.
+func printBenchResult(op string, n, concurrency int, r benchResult) {
+	opsPerSec := float64(n) / r.elapsed.Seconds()
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Operation", "Ops", "Concurrency", "Total Time", "Ops/sec", "p50", "p95", "p99", "Errors"})
+	table.Append([]string{
+		op,
+		strconv.Itoa(n),
+		strconv.Itoa(concurrency),
+		r.elapsed.Round(time.Millisecond).String(),
+		strconv.FormatFloat(opsPerSec, 'f', 1, 64),
+		percentile(r.latencies, 50).String(),
+		percentile(r.latencies, 95).String(),
+		percentile(r.latencies, 99).String(),
+		strconv.FormatInt(r.errors, 10),
+	})
+	table.Render()
+	fmt.Println("---")
+}
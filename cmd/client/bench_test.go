@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"memory-tools/internal/handler"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// discardPersister is a store.CollectionPersister that keeps nothing on disk.
+type discardPersister struct{}
+
+func (discardPersister) SaveCollectionData(string, store.DataStore) error          { return nil }
+func (discardPersister) DeleteCollectionFile(string) error                         { return nil }
+func (discardPersister) RenameCollectionFile(string, string) error                 { return nil }
+func (discardPersister) CopyCollectionFile(string, string) error                   { return nil }
+func (discardPersister) TruncateCollectionFile(string) error                       { return nil }
+func (discardPersister) WriteColdItems(string, map[string][]byte) error            { return nil }
+func (discardPersister) ScanColdDocuments(string, func(map[string]any) bool) error { return nil }
+
+type noActivity struct{}
+
+func (noActivity) UpdateActivity() {}
+
+// startTestServer serves in-process connections, each logged in as root, and returns a dialer for it.
+func startTestServer(t *testing.T) (*store.CollectionManager, func() (net.Conn, error)) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	cm := store.NewCollectionManager(discardPersister{}, 4)
+	tm := store.NewTransactionManager(cm)
+	mainStore := store.NewInMemStoreWithShards(4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				h := handler.GetConnectionHandlerFromPool(nil, mainStore, cm, nil, tm, noActivity{}, conn)
+				h.IsAuthenticated, h.IsRoot, h.AuthenticatedUser = true, true, "root"
+				h.HandleConnection(conn)
+				handler.PutConnectionHandlerToPool(h)
+			}()
+		}
+	}()
+	return cm, func() (net.Conn, error) { return net.Dial("tcp", listener.Addr().String()) }
+}
+
+func TestBenchAgainstInProcessServer(t *testing.T) {
+	cm, dial := startTestServer(t)
+	conn, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := newCLI(conn)
+	c.dial = dial
+
+	for _, args := range []string{"set 50 4", "get 50", "query 20 2"} {
+		if err := c.handleBench(args); err != nil {
+			t.Fatalf("bench %s: %v", args, err)
+		}
+	}
+	for _, name := range cm.ListCollections() {
+		if strings.HasPrefix(name, "bench_") {
+			t.Errorf("benchmark collection %q was not deleted", name)
+		}
+	}
+
+	conns, err := c.benchConnections(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeAll(conns)
+	if err := c.benchExec(func(w *bytes.Buffer) { protocol.WriteCollectionCreateCommand(w, "bench_direct") }); err != nil {
+		t.Fatal(err)
+	}
+	result := runBench(conns, 30, func(w *bytes.Buffer, i int) {
+		key, doc := benchDocument(i)
+		protocol.WriteCollectionItemSetCommand(w, "bench_direct", key, doc, 0)
+	})
+	if result.errors != 0 {
+		t.Errorf("errors = %d, want 0", result.errors)
+	}
+	if len(result.latencies) != 30 {
+		t.Fatalf("measured %d latencies, want 30", len(result.latencies))
+	}
+	p50, p99 := percentile(result.latencies, 50), percentile(result.latencies, 99)
+	if p50 <= 0 || p50 > p99 || p99 > result.elapsed {
+		t.Errorf("p50 = %v, p99 = %v, elapsed = %v: want 0 < p50 <= p99 <= elapsed", p50, p99, result.elapsed)
+	}
+	if result.elapsed <= 0 || result.elapsed > time.Minute {
+		t.Errorf("elapsed = %v", result.elapsed)
+	}
+	if got := cm.GetCollection("bench_direct").Size(); got != 30 {
+		t.Errorf("collection holds %d documents, want 30", got)
+	}
+}
+
+func TestBenchRejectsBadArguments(t *testing.T) {
+	c := newCLI(nil)
+	for _, args := range []string{"", "delete 10", "set", "set -1", "set 10 0", "set 10 2 3"} {
+		if err := c.handleBench(args); err == nil {
+			t.Errorf("bench %q: want an error", args)
+		}
+	}
+}
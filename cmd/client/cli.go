@@ -21,14 +21,22 @@ type command struct {
 
 type cli struct {
 	conn              net.Conn
+	dial              func() (net.Conn, error)
 	rl                *readline.Instance
 	rlConfig          *readline.Config
 	isAuthenticated   bool
 	currentUser       string
+	password          string
+	apiKey            string
+	certUser          string // Common name of the client certificate, which the server may log in as.
 	commands          map[string]command
 	multiWordCommands []string
 	connMutex         sync.Mutex
 	inTransaction     bool
+
+	// requestMu is held while a command runs so the heartbeat never interleaves with it.
+	requestMu   sync.Mutex
+	lastRequest time.Time
 }
 
 // newCLI creates a new command-line interface instance.
@@ -53,7 +61,7 @@ func newCLI(conn net.Conn) *cli {
 }
 
 // run starts the main CLI loop and handles initial login.
-func (c *cli) run(user, pass *string) error {
+func (c *cli) run(user, pass, apiKey *string) error {
 	c.rlConfig = &readline.Config{
 		Prompt:          "> ",
 		HistoryFile:     "/tmp/readline_history.tmp",
@@ -74,6 +82,21 @@ func (c *cli) run(user, pass *string) error {
 		if err := c.handleLogin(fmt.Sprintf("%s %s", *user, *pass)); err != nil {
 			fmt.Println(colorErr("Automatic login failed. Please login manually."))
 		}
+	} else if *apiKey != "" {
+		fmt.Println(colorInfo("Attempting automatic login with API key"))
+		if err := c.handleLogin(*apiKey); err != nil {
+			fmt.Println(colorErr("Automatic login failed. Please login manually."))
+		}
+	}
+
+	if !c.isAuthenticated && c.certUser != "" {
+		// The server checks the certificate during the handshake; commands are refused if it
+		// does not map to a user, and "login" remains available.
+		fmt.Println(colorInfo("Using client certificate identity ", c.certUser))
+		c.isAuthenticated = true
+		c.currentUser = c.certUser
+		c.rlConfig.AutoComplete = c.getCompleter()
+		c.rl.SetConfig(c.rlConfig)
 	}
 
 	if !c.isAuthenticated {
@@ -128,7 +151,11 @@ func (c *cli) mainLoop() error {
 		}
 
 		startTime := time.Now()
-		if err := handler.handler(c, args); err != nil {
+		c.requestMu.Lock()
+		err = handler.handler(c, args)
+		c.lastRequest = time.Now()
+		c.requestMu.Unlock()
+		if err != nil {
 			if errors.Is(err, io.EOF) {
 				break
 			}
@@ -26,36 +26,123 @@ func (c *cli) getCompleter() readline.AutoCompleter {
 			readline.PcItem("create"),
 			readline.PcItem("update"),
 			readline.PcItem("delete"),
+			readline.PcItem("export"),
+			readline.PcItem("import", readline.PcItemDynamic(c.fetchJSONFileNames)),
+			readline.PcItem("roles"),
+		),
+		readline.PcItem("role",
+			readline.PcItem("create"),
+			readline.PcItem("update"),
+			readline.PcItem("delete"),
 		),
 		readline.PcItem("update", readline.PcItem("password")),
-		readline.PcItem("backup"),
-		readline.PcItem("restore"),
+		readline.PcItem("procedure",
+			readline.PcItem("put"),
+			readline.PcItem("delete"),
+			readline.PcItem("list"),
+			readline.PcItem("call"),
+		),
+		readline.PcItem("apikey",
+			readline.PcItem("create"),
+			readline.PcItem("revoke"),
+			readline.PcItem("list"),
+		),
+		readline.PcItem("backup", readline.PcItem("list"), readline.PcItem("delete")),
+		readline.PcItem("restore", readline.PcItem("collection")),
+		readline.PcItem("compact",
+			readline.PcItem("all"),
+			readline.PcItem("status"),
+		),
+		readline.PcItem("memory", readline.PcItem("stats")),
+		readline.PcItem("stats"),
+		readline.PcItem("slow", readline.PcItem("queries")),
+		readline.PcItem("subscribe", readline.PcItemDynamic(c.fetchCollectionNames)),
+		readline.PcItem("change", readline.PcItem("feed", readline.PcItem("ack"))),
 		readline.PcItem("set"),
 		readline.PcItem("get"),
+		readline.PcItem("ping"),
 		readline.PcItem("collection",
 			readline.PcItem("create"),
 			readline.PcItem("delete", readline.PcItemDynamic(c.fetchCollectionNames)),
+			readline.PcItem("rename", readline.PcItemDynamic(c.fetchCollectionNames)),
+			readline.PcItem("copy", readline.PcItemDynamic(c.fetchCollectionNames)),
+			readline.PcItem("truncate", readline.PcItemDynamic(c.fetchCollectionNames)),
 			readline.PcItem("list"),
+			readline.PcItem("top",
+				readline.PcItem("largest", readline.PcItemDynamic(c.fetchCollectionNames)),
+			),
+			readline.PcItem("stats", readline.PcItemDynamic(c.fetchCollectionNames)),
+			readline.PcItem("reload", readline.PcItemDynamic(c.fetchCollectionNames)),
+			readline.PcItem("compression", readline.PcItemDynamic(c.fetchCollectionNames,
+				readline.PcItem("on"),
+				readline.PcItem("off"),
+			)),
+			readline.PcItem("default",
+				readline.PcItem("ttl", readline.PcItemDynamic(c.fetchCollectionNames,
+					readline.PcItem("off"),
+				)),
+			),
+			readline.PcItem("schema", readline.PcItemDynamic(c.fetchCollectionNames,
+				readline.PcItem("off"),
+			)),
+			readline.PcItem("file",
+				readline.PcItem("compression", readline.PcItemDynamic(c.fetchCollectionNames,
+					readline.PcItem("none"),
+					readline.PcItem("gzip"),
+				)),
+			),
+			readline.PcItem("export", readline.PcItemDynamic(c.fetchCollectionNames,
+				readline.PcItem("json"),
+				readline.PcItem("csv"),
+			)),
+			readline.PcItem("import", readline.PcItemDynamic(c.fetchCollectionNames,
+				readline.PcItemDynamic(c.fetchJSONFileNames),
+			)),
 			readline.PcItem("index",
 				readline.PcItem("create", readline.PcItemDynamic(c.fetchCollectionNames)),
 				readline.PcItem("delete", readline.PcItemDynamic(c.fetchCollectionNames)),
 				readline.PcItem("list", readline.PcItemDynamic(c.fetchCollectionNames)),
+				readline.PcItem("audit", readline.PcItemDynamic(c.fetchCollectionNames)),
+				readline.PcItem("disable", readline.PcItemDynamic(c.fetchCollectionNames)),
+				readline.PcItem("enable", readline.PcItemDynamic(c.fetchCollectionNames)),
 			),
 			readline.PcItem("item",
 				readline.PcItem("get", readline.PcItemDynamic(c.fetchCollectionNames)),
 				readline.PcItem("set", readline.PcItemDynamic(c.fetchCollectionNames)),
 				readline.PcItem("delete", readline.PcItemDynamic(c.fetchCollectionNames)),
+				readline.PcItem("pop", readline.PcItemDynamic(c.fetchCollectionNames)),
+				readline.PcItem("exists", readline.PcItemDynamic(c.fetchCollectionNames)),
+				readline.PcItem("keys", readline.PcItemDynamic(c.fetchCollectionNames)),
+				readline.PcItem("ttl", readline.PcItemDynamic(c.fetchCollectionNames)),
+				readline.PcItem("touch", readline.PcItemDynamic(c.fetchCollectionNames)),
+				readline.PcItem("increment", readline.PcItemDynamic(c.fetchCollectionNames)),
 				readline.PcItem("update", readline.PcItemDynamic(c.fetchCollectionNames)),
+				readline.PcItem("update if", readline.PcItemDynamic(c.fetchCollectionNames)),
+				readline.PcItem("update version", readline.PcItemDynamic(c.fetchCollectionNames)),
+				readline.PcItem("upsert", readline.PcItemDynamic(c.fetchCollectionNames)),
+				readline.PcItem("replace", readline.PcItemDynamic(c.fetchCollectionNames)),
+				readline.PcItem("replace version", readline.PcItemDynamic(c.fetchCollectionNames)),
 				readline.PcItem("list", readline.PcItemDynamic(c.fetchCollectionNames)),
+				readline.PcItem("diff", readline.PcItemDynamic(c.fetchCollectionNames)),
 				readline.PcItem("set many", readline.PcItemDynamic(c.fetchCollectionNames, readline.PcItemDynamic(c.fetchJSONFileNames))),
+				readline.PcItem("get many", readline.PcItemDynamic(c.fetchCollectionNames, readline.PcItemDynamic(c.fetchJSONFileNames))),
 				readline.PcItem("delete many", readline.PcItemDynamic(c.fetchCollectionNames, readline.PcItemDynamic(c.fetchJSONFileNames))),
 				readline.PcItem("update many", readline.PcItemDynamic(c.fetchCollectionNames, readline.PcItemDynamic(c.fetchJSONFileNames))),
+				readline.PcItem("merge where", readline.PcItemDynamic(c.fetchCollectionNames)),
 			),
 			readline.PcItem("query", readline.PcItemDynamic(c.fetchCollectionNames, readline.PcItemDynamic(c.fetchJSONFileNames))),
 		),
+		readline.PcItem("bench",
+			readline.PcItem("set"),
+			readline.PcItem("get"),
+			readline.PcItem("query"),
+		),
 		readline.PcItem("begin"),
 		readline.PcItem("commit"),
 		readline.PcItem("rollback"),
+		readline.PcItem("transaction",
+			readline.PcItem("status"),
+		),
 		readline.PcItem("clear"),
 		readline.PcItem("help"),
 		readline.PcItem("exit"),
@@ -70,7 +157,7 @@ func (c *cli) fetchCollectionNames(line string) []string {
 	const maxRetries = 3
 	for attempt := 1; attempt <= maxRetries; attempt++ {
 		var cmdBuf bytes.Buffer
-		if err := protocol.WriteCollectionListCommand(&cmdBuf); err != nil {
+		if err := protocol.WriteCollectionListCommand(&cmdBuf, "", 0, 0); err != nil {
 			continue
 		}
 		if _, err := c.conn.Write(cmdBuf.Bytes()); err != nil {
@@ -1,17 +1,24 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"memory-tools/internal/protocol"
+	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	stdjson "encoding/json"
+
 	"github.com/olekukonko/tablewriter"
 )
 
@@ -19,7 +26,7 @@ import (
 func (c *cli) getCommands() map[string]command {
 	return map[string]command{
 		// Authentication
-		"login": {help: "login <username> <password> - Authenticate to the server", handler: (*cli).handleLogin, category: "Authentication"},
+		"login": {help: "login <username> <password> | login <api_key> - Authenticate to the server", handler: (*cli).handleLogin, category: "Authentication"},
 		"help":  {help: "help - Shows this help message", handler: (*cli).handleHelp, category: "Authentication"},
 		"exit":  {help: "exit - Exits the client", handler: (*cli).handleExit, category: "Authentication"},
 		"clear": {help: "clear - Clears the screen", handler: (*cli).handleClear, category: "Authentication"},
@@ -28,41 +35,99 @@ func (c *cli) getCommands() map[string]command {
 		"user create":     {help: "user create <user> <pass> <perms_json|path> - Create a new user", handler: (*cli).handleUserCreate, category: "User Management"},
 		"user update":     {help: "user update <user> <perms_json|path> - Update a user's permissions", handler: (*cli).handleUserUpdate, category: "User Management"},
 		"user delete":     {help: "user delete <username> - Delete a user", handler: (*cli).handleUserDelete, category: "User Management"},
+		"user export":     {help: "user export [file.json] - Exports all users with their password hashes, optionally to json/<file> (root@localhost only)", handler: (*cli).handleUserExport, category: "User Management"},
+		"user import":     {help: "user import <users_json|path> [overwrite] - Imports exported users, replacing existing ones only with overwrite (root@localhost only)", handler: (*cli).handleUserImport, category: "User Management"},
 		"update password": {help: "update password <user> <new_pass> - Change a user's password", handler: (*cli).handleChangePassword, category: "User Management"},
+		"user roles":      {help: "user roles <user> [role ...] - Set the roles of a user; no roles removes them all", handler: (*cli).handleUserSetRoles, category: "User Management"},
+		"role create":     {help: "role create <role> <perms_json|path> - Create a named role with a set of permissions", handler: (*cli).handleRoleCreate, category: "User Management"},
+		"role update":     {help: "role update <role> <perms_json|path> - Replace a role's permissions", handler: (*cli).handleRoleUpdate, category: "User Management"},
+		"role delete":     {help: "role delete <role> - Delete a role and remove it from its users", handler: (*cli).handleRoleDelete, category: "User Management"},
+		"apikey create":   {help: "apikey create <user> [expires=<RFC3339|duration>] [perms_json|path] - Create an API key for a user (root only)", handler: (*cli).handleAPIKeyCreate, category: "User Management"},
+		"apikey revoke":   {help: "apikey revoke <key_id> - Revoke an API key (root only)", handler: (*cli).handleAPIKeyRevoke, category: "User Management"},
+		"apikey list":     {help: "apikey list - List API keys without their secrets (root only)", handler: (*cli).handleAPIKeyList, category: "User Management"},
 
 		// Transactions
-		"begin":    {help: "begin - Starts a new transaction", handler: (*cli).handleBegin, category: "Transactions"},
-		"commit":   {help: "commit - Commits the current transaction", handler: (*cli).handleCommit, category: "Transactions"},
-		"rollback": {help: "rollback - Rolls back the current transaction", handler: (*cli).handleRollback, category: "Transactions"},
+		"begin":              {help: "begin - Starts a new transaction", handler: (*cli).handleBegin, category: "Transactions"},
+		"commit":             {help: "commit - Commits the current transaction", handler: (*cli).handleCommit, category: "Transactions"},
+		"rollback":           {help: "rollback - Rolls back the current transaction", handler: (*cli).handleRollback, category: "Transactions"},
+		"transaction status": {help: "transaction status - Shows the operations queued in the current transaction", handler: (*cli).handleTransactionStatus, category: "Transactions"},
+
+		// Stored Procedures
+		"procedure put":    {help: "procedure put <name> <definition_json|path> - Creates or replaces a stored procedure", handler: (*cli).handleProcedurePut, category: "Stored Procedures"},
+		"procedure delete": {help: "procedure delete <name> - Deletes a stored procedure", handler: (*cli).handleProcedureDelete, category: "Stored Procedures"},
+		"procedure list":   {help: "procedure list - Lists the stored procedures with their definitions", handler: (*cli).handleProcedureList, category: "Stored Procedures"},
+		"procedure call":   {help: "procedure call <name> [params_json|path] - Runs a procedure's steps and commits their writes atomically", handler: (*cli).handleProcedureCall, category: "Stored Procedures"},
 
 		// Server Operations (Root only)
-		"backup":  {help: "backup - Triggers a manual server backup (root only)", handler: (*cli).handleBackup, category: "Server Operations"},
-		"restore": {help: "restore <backup_name> - Restores from a backup (root only)", handler: (*cli).handleRestore, category: "Server Operations"},
-		"set":     {help: "set <key> <value_json> [ttl] - Set a key in the main store (root only)", handler: (*cli).handleMainSet, category: "Server Operations"},
-		"get":     {help: "get <key> - Get a key from the main store (root only)", handler: (*cli).handleMainGet, category: "Server Operations"},
+		"backup":             {help: "backup - Triggers a manual server backup (root only)", handler: (*cli).handleBackup, category: "Server Operations"},
+		"backup list":        {help: "backup list - Lists the stored backups with their size and verification status (root@localhost only)", handler: (*cli).handleBackupList, category: "Server Operations"},
+		"backup delete":      {help: "backup delete <backup_name> - Deletes a stored backup (root@localhost only)", handler: (*cli).handleBackupDelete, category: "Server Operations"},
+		"restore":            {help: "restore <backup_name> [--dry-run] - Restores from a backup, or only validates it with --dry-run (root only)", handler: (*cli).handleRestore, category: "Server Operations"},
+		"restore collection": {help: "restore collection <backup_name> <collection_name> - Restores a single collection from a backup (root@localhost only)", handler: (*cli).handleRestoreCollection, category: "Server Operations"},
+		"compact all":        {help: "compact all - Compacts every collection file in the background and returns a job id (root only)", handler: (*cli).handleCompactAll, category: "Server Operations"},
+		"compact status":     {help: "compact status <job_id> - Shows the progress of a compaction job (root only)", handler: (*cli).handleCompactStatus, category: "Server Operations"},
+		"memory stats":       {help: "memory stats - Shows each collection's approximate RAM usage against the memory cap (root only)", handler: (*cli).handleMemoryStats, category: "Server Operations"},
+		"stats":              {help: "stats - Shows server metrics: item and index counts, WAL size, last backup, connections and Go runtime memory (root only)", handler: (*cli).handleStats, category: "Server Operations"},
+		"slow queries":       {help: "slow queries [limit] - Lists the queries kept by the slow query log, slowest first (root only)", handler: (*cli).handleSlowQueries, category: "Server Operations"},
+		"set":                {help: "set <key> <value_json> [ttl] - Set a key in the main store (root only)", handler: (*cli).handleMainSet, category: "Server Operations"},
+		"get":                {help: "get <key> - Get a key from the main store (root only)", handler: (*cli).handleMainGet, category: "Server Operations"},
+		"bench":              {help: "bench <set|get|query> <n> [concurrency] - Measures latency and throughput against a throwaway collection", handler: (*cli).handleBench, category: "Server Operations"},
+		"subscribe":          {help: "subscribe <coll> [prefix=<p>] [values] - Prints the changes made to a collection as they happen, until Ctrl+C", handler: (*cli).handleSubscribe, category: "Server Operations"},
+		"change feed":        {help: "change feed <consumer> [collection=<c>] [from=<offset|earliest|latest>] - Prints the changes logged in the WAL and follows new ones, until Ctrl+C (root only)", handler: (*cli).handleChangeFeed, category: "Server Operations"},
+		"change feed ack":    {help: "change feed ack <consumer> <offset> - Records the offset a change feed consumer has processed, where its feed resumes (root only)", handler: (*cli).handleChangeFeedAck, category: "Server Operations"},
+		"ping":               {help: "ping - Checks that the server responds and shows the round-trip time and server clock", handler: (*cli).handlePing, category: "Server Operations"},
 
 		// Collection Management
-		"collection create": {help: "collection create <name> - Creates a new collection", handler: (*cli).handleCollectionCreate, category: "Collection Management"},
-		"collection delete": {help: "collection delete <name> - Deletes a collection", handler: (*cli).handleCollectionDelete, category: "Collection Management"},
-		"collection list":   {help: "collection list - Lists all available collections", handler: (*cli).handleCollectionList, category: "Collection Management"},
+		"collection create":           {help: "collection create <name> - Creates a new collection", handler: (*cli).handleCollectionCreate, category: "Collection Management"},
+		"collection delete":           {help: "collection delete <name> - Deletes a collection", handler: (*cli).handleCollectionDelete, category: "Collection Management"},
+		"collection rename":           {help: "collection rename <name> <new_name> - Renames a collection, keeping its items, indexes and settings", handler: (*cli).handleCollectionRename, category: "Collection Management"},
+		"collection copy":             {help: "collection copy <name> <new_name> [force] - Copies a collection with its indexes; force replaces a non-empty destination", handler: (*cli).handleCollectionCopy, category: "Collection Management"},
+		"collection truncate":         {help: "collection truncate <name> - Removes every item of a collection, keeping its indexes", handler: (*cli).handleCollectionTruncate, category: "Collection Management"},
+		"collection list":             {help: "collection list [prefix=<p>] [limit=<n>] [offset=<n>] - Lists accessible collections, sorted and paginated", handler: (*cli).handleCollectionList, category: "Collection Management"},
+		"collection top largest":      {help: "collection top largest <coll> <n> - Lists the n largest documents by stored size", handler: (*cli).handleTopLargest, category: "Collection Management"},
+		"collection stats":            {help: "collection stats <name> - Shows item count and read/write counters, total and over the last minute", handler: (*cli).handleCollectionStats, category: "Collection Management"},
+		"collection reload":           {help: "collection reload <name> - Reloads a collection from its file on disk (root only)", handler: (*cli).handleCollectionReload, category: "Collection Management"},
+		"collection compression":      {help: "collection compression <coll> <on|off> - Stores the collection's values compressed in RAM", handler: (*cli).handleCollectionCompression, category: "Collection Management"},
+		"collection default ttl":      {help: "collection default ttl <coll> <seconds|off> - Sets the expiry of items set without a TTL", handler: (*cli).handleCollectionDefaultTTL, category: "Collection Management"},
+		"collection schema":           {help: "collection schema <coll> <json|off> - Validates documents written to the collection against a schema", handler: (*cli).handleCollectionSchema, category: "Collection Management"},
+		"collection file compression": {help: "collection file compression <coll> <none|gzip> - Compresses the values of the collection's data file on disk", handler: (*cli).handleCollectionFileCompression, category: "Collection Management"},
+		"collection export":           {help: "collection export <coll> <json|csv> [fields=<path,path>] [file] - Exports every item as NDJSON or CSV, optionally to json/<file>", handler: (*cli).handleCollectionExport, category: "Collection Management"},
+		"collection import":           {help: "collection import <coll> <file> [batch=<n>] [skip=<n>] - Streams the NDJSON documents of json/<file> into a collection in batches", handler: (*cli).handleCollectionImport, category: "Collection Management"},
 
 		// Index Management
-		"collection index create": {help: "collection index create <coll> <field> - Creates an index on a field", handler: (*cli).handleIndexCreate, category: "Index Management"},
-		"collection index delete": {help: "collection index delete <coll> <field> - Deletes an index", handler: (*cli).handleIndexDelete, category: "Index Management"},
-		"collection index list":   {help: "collection index list <coll> - Lists indexes on a collection", handler: (*cli).handleIndexList, category: "Index Management"},
+		"collection index create":  {help: "collection index create <coll> <field> [case_insensitive] [unique] - Creates an index on a field, optionally matching strings regardless of case or rejecting duplicate values", handler: (*cli).handleIndexCreate, category: "Index Management"},
+		"collection index delete":  {help: "collection index delete <coll> <field> - Deletes an index", handler: (*cli).handleIndexDelete, category: "Index Management"},
+		"collection index disable": {help: "collection index disable <coll> <field> - Pauses index maintenance without deleting it", handler: (*cli).handleIndexDisable, category: "Index Management"},
+		"collection index enable":  {help: "collection index enable <coll> <field> - Re-enables and backfills a disabled index", handler: (*cli).handleIndexEnable, category: "Index Management"},
+		"collection index list":    {help: "collection index list <coll> - Lists indexes on a collection", handler: (*cli).handleIndexList, category: "Index Management"},
+		"collection index audit":   {help: "collection index audit <coll> - Reports each index's field coverage and flags orphaned indexes", handler: (*cli).handleIndexAudit, category: "Index Management"},
 
 		// Item Operations
-		"collection item set":         {help: "collection item set <coll> [<key>] <value_json|path> [ttl] - Sets an item", handler: (*cli).handleItemSet, category: "Item Operations"},
-		"collection item get":         {help: "collection item get <coll> <key> - Gets an item from a collection", handler: (*cli).handleItemGet, category: "Item Operations"},
-		"collection item delete":      {help: "collection item delete <coll> <key> - Deletes an item from a collection", handler: (*cli).handleItemDelete, category: "Item Operations"},
-		"collection item update":      {help: "collection item update <coll> <key> <patch_json|path> - Updates an item", handler: (*cli).handleItemUpdate, category: "Item Operations"},
-		"collection item list":        {help: "collection item list <coll> - Lists all items in a collection (root only)", handler: (*cli).handleItemList, category: "Item Operations"},
-		"collection item set many":    {help: "collection item set many <coll> <json_array|path> - Sets multiple items", handler: (*cli).handleItemSetMany, category: "Item Operations"},
-		"collection item update many": {help: "collection item update many <coll> <patch_json_array|path> - Updates multiple items", handler: (*cli).handleItemUpdateMany, category: "Item Operations"},
-		"collection item delete many": {help: "collection item delete many <coll> <keys_json_array|path> - Deletes multiple items", handler: (*cli).handleItemDeleteMany, category: "Item Operations"},
+		"collection item set":             {help: "collection item set <coll> [<key>] <value_json|path> [ttl] - Sets an item", handler: (*cli).handleItemSet, category: "Item Operations"},
+		"collection item get":             {help: "collection item get <coll> <key> [fields=<path,path>] - Gets an item, optionally only the given fields", handler: (*cli).handleItemGet, category: "Item Operations"},
+		"collection item delete":          {help: "collection item delete <coll> <key> - Deletes an item from a collection", handler: (*cli).handleItemDelete, category: "Item Operations"},
+		"collection item exists":          {help: "collection item exists <coll> <key> - Checks whether an item exists without fetching it", handler: (*cli).handleItemExists, category: "Item Operations"},
+		"collection item keys":            {help: "collection item keys <coll> [prefix=<p>] [after=<cursor>] [limit=<n>] [cold=true] - Lists keys with a prefix in order, a page at a time", handler: (*cli).handleItemKeys, category: "Item Operations"},
+		"collection item ttl":             {help: "collection item ttl <coll> <key> - Shows the seconds an item has left to live (-1 if it never expires)", handler: (*cli).handleItemTTL, category: "Item Operations"},
+		"collection item touch":           {help: "collection item touch <coll> <key> <ttl_seconds> - Restarts an item's TTL without rewriting it (0 removes the expiry)", handler: (*cli).handleItemTouch, category: "Item Operations"},
+		"collection item pop":             {help: "collection item pop <coll> <key> - Atomically gets and deletes an item", handler: (*cli).handleItemPop, category: "Item Operations"},
+		"collection item update":          {help: "collection item update <coll> <key> <patch_json|path> - Updates an item", handler: (*cli).handleItemUpdate, category: "Item Operations"},
+		"collection item update if":       {help: "collection item update if <coll> <key> <condition_json|path> <patch_json|path> - Updates an item only if it matches the condition", handler: (*cli).handleItemUpdateIf, category: "Item Operations"},
+		"collection item update version":  {help: "collection item update version <coll> <key> <version> <patch_json|path> - Updates an item only if it is still at the given version", handler: (*cli).handleItemUpdateVersion, category: "Item Operations"},
+		"collection item upsert":          {help: "collection item upsert <coll> <key> <patch_json|path> - Updates an item, creating it if missing", handler: (*cli).handleItemUpsert, category: "Item Operations"},
+		"collection item increment":       {help: "collection item increment <coll> <key> <field> [delta] - Atomically adds delta (default 1) to a numeric field", handler: (*cli).handleItemIncrement, category: "Item Operations"},
+		"collection item replace":         {help: "collection item replace <coll> <key> <value_json|path> - Replaces an existing item's whole document", handler: (*cli).handleItemReplace, category: "Item Operations"},
+		"collection item replace version": {help: "collection item replace version <coll> <key> <version> <value_json|path> - Replaces an item only if it is still at the given version", handler: (*cli).handleItemReplaceVersion, category: "Item Operations"},
+		"collection item list":            {help: "collection item list <coll> - Lists all items in a collection (root only)", handler: (*cli).handleItemList, category: "Item Operations"},
+		"collection item set many":        {help: "collection item set many <coll> <json_array|path> [ttl=<seconds>] [minimal] - Sets multiple items; minimal returns only their keys", handler: (*cli).handleItemSetMany, category: "Item Operations"},
+		"collection item update many":     {help: "collection item update many <coll> <patch_json_array|path> - Updates multiple items", handler: (*cli).handleItemUpdateMany, category: "Item Operations"},
+		"collection item get many":        {help: "collection item get many <coll> <keys_json_array|path> - Gets multiple items in one round trip", handler: (*cli).handleItemGetMany, category: "Item Operations"},
+		"collection item delete many":     {help: "collection item delete many <coll> <keys_json_array|path> - Deletes multiple items", handler: (*cli).handleItemDeleteMany, category: "Item Operations"},
+		"collection item merge where":     {help: "collection item merge where <coll> <filter_json|path> <patch_json|path> - Deep-merges the patch into every item matching the filter", handler: (*cli).handleItemMergeWhere, category: "Item Operations"},
+		"collection item diff":            {help: "collection item diff <coll> <key_a> <key_b|document_json|path> - Shows the differences between two items", handler: (*cli).handleItemDiff, category: "Item Operations"},
 
 		// Query
-		"collection query": {help: "collection query <coll> <query_json|path> - Performs a complex query", handler: (*cli).handleQuery, category: "Query"},
+		"collection query": {help: "collection query <coll> <query_json|path> - Performs a complex query (filter ops: =, !=, >, >=, <, <=, like, regex, in, not in, between, is null, is not null, contains, size)", handler: (*cli).handleQuery, category: "Query"},
 	}
 }
 
@@ -78,7 +143,7 @@ func (c *cli) handleBegin(args string) error {
 	if _, err := c.conn.Write(cmdBuf.Bytes()); err != nil {
 		return fmt.Errorf("could not send begin command: %w", err)
 	}
-	status, msg, _, err := c.readRawResponse()
+	status, msg, dataBytes, err := c.readRawResponse()
 	if err != nil {
 		return err
 	}
@@ -90,6 +155,12 @@ func (c *cli) handleBegin(args string) error {
 	if status == protocol.StatusOk {
 		c.inTransaction = true
 		fmt.Println(colorOK("√ Transaction started."))
+		var started struct {
+			ExpiresAt string `json:"expires_at"`
+		}
+		if json.Unmarshal(dataBytes, &started) == nil && started.ExpiresAt != "" {
+			fmt.Println(colorInfo("It expires at " + started.ExpiresAt + " unless committed or rolled back."))
+		}
 	}
 	return nil
 }
@@ -153,18 +224,101 @@ func (c *cli) handleRollback(args string) error {
 	return nil
 }
 
+// handleTransactionStatus handles the "transaction status" command.
+func (c *cli) handleTransactionStatus(args string) error {
+	if !c.inTransaction {
+		return errors.New("no transaction is in progress")
+	}
+	var cmdBuf bytes.Buffer
+	if err := protocol.WriteTransactionStatusCommand(&cmdBuf); err != nil {
+		return fmt.Errorf("could not build transaction status command: %w", err)
+	}
+	if _, err := c.conn.Write(cmdBuf.Bytes()); err != nil {
+		return fmt.Errorf("could not send transaction status command: %w", err)
+	}
+	return c.readResponse("transaction status")
+}
+
+// handleProcedurePut handles the "procedure put" command.
+func (c *cli) handleProcedurePut(args string) error {
+	parts := strings.SplitN(strings.TrimSpace(args), " ", 2)
+	if len(parts) < 2 {
+		return errors.New("usage: procedure put <name> <definition_json|path>")
+	}
+	jsonPayload, err := c.getJSONPayload(parts[1])
+	if err != nil {
+		return err
+	}
+	if !json.Valid(jsonPayload) {
+		return errors.New("invalid procedure definition JSON format")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteProcedurePutCommand(&cmdBuf, parts[0], jsonPayload)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("procedure put")
+}
+
+// handleProcedureDelete handles the "procedure delete" command.
+func (c *cli) handleProcedureDelete(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) != 1 {
+		return errors.New("usage: procedure delete <name>")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteProcedureDeleteCommand(&cmdBuf, parts[0])
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("procedure delete")
+}
+
+// handleProcedureList handles the "procedure list" command.
+func (c *cli) handleProcedureList(args string) error {
+	var cmdBuf bytes.Buffer
+	protocol.WriteProcedureListCommand(&cmdBuf)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("procedure list")
+}
+
+// handleProcedureCall handles the "procedure call" command. A procedure without parameters
+// needs no params JSON.
+func (c *cli) handleProcedureCall(args string) error {
+	parts := strings.SplitN(strings.TrimSpace(args), " ", 2)
+	if parts[0] == "" {
+		return errors.New("usage: procedure call <name> [params_json|path]")
+	}
+	var paramsJSON []byte
+	if len(parts) == 2 {
+		var err error
+		if paramsJSON, err = c.getJSONPayload(parts[1]); err != nil {
+			return err
+		}
+		if !json.Valid(paramsJSON) {
+			return errors.New("invalid params JSON format")
+		}
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCallProcedureCommand(&cmdBuf, parts[0], paramsJSON)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("procedure call")
+}
+
 // handleLogin handles the "login" command to authenticate the user.
 func (c *cli) handleLogin(args string) error {
-	if c.isAuthenticated {
+	if c.isAuthenticated && c.currentUser != c.certUser {
 		return errors.New("you are already logged in")
 	}
 	parts := strings.Fields(args)
-	if len(parts) != 2 {
-		return errors.New("usage: login <username> <password>")
-	}
-	username, password := parts[0], parts[1]
 	var cmdBuf bytes.Buffer
-	protocol.WriteAuthenticateCommand(&cmdBuf, username, password)
+	var username, password, apiKey string
+	switch len(parts) {
+	case 1:
+		apiKey = parts[0]
+		protocol.WriteAuthenticateTokenCommand(&cmdBuf, apiKey)
+	case 2:
+		username, password = parts[0], parts[1]
+		protocol.WriteAuthenticateCommand(&cmdBuf, username, password)
+	default:
+		return errors.New("usage: login <username> <password> | login <api_key>")
+	}
 	if _, err := c.conn.Write(cmdBuf.Bytes()); err != nil {
 		return fmt.Errorf("could not send login command: %w", err)
 	}
@@ -178,8 +332,15 @@ func (c *cli) handleLogin(args string) error {
 	table.Render()
 	fmt.Println("---")
 	if status == protocol.StatusOk {
+		if apiKey != "" {
+			// The server names the key's user in its reply: "OK: Authenticated as '<user>'."
+			username = strings.TrimSuffix(strings.TrimPrefix(msg, "OK: Authenticated as '"), "'.")
+		}
 		c.isAuthenticated = true
 		c.currentUser = username
+		c.password = password
+		c.apiKey = apiKey
+		c.certUser = ""
 		c.rlConfig.AutoComplete = c.getCompleter()
 		c.rl.SetConfig(c.rlConfig)
 		fmt.Printf(colorOK("√ Login successful. Welcome, %s!\n"), c.currentUser)
@@ -290,6 +451,181 @@ func (c *cli) handleUserDelete(args string) error {
 	return c.readResponse("user delete")
 }
 
+// handleUserExport handles the "user export" command.
+// With a .json file name, the exported users are saved under the json directory instead of printed.
+func (c *cli) handleUserExport(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) > 1 || (len(parts) == 1 && !strings.HasSuffix(parts[0], ".json")) {
+		return errors.New("usage: user export [file.json]")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteExportUsersCommand(&cmdBuf)
+	c.conn.Write(cmdBuf.Bytes())
+	if len(parts) == 0 {
+		return c.readResponse("user export")
+	}
+
+	status, msg, dataBytes, err := c.readRawResponse()
+	if err != nil {
+		return err
+	}
+	if status != protocol.StatusOk {
+		return fmt.Errorf("%s: %s", getStatusString(status), msg)
+	}
+	filePath := filepath.Join("json", parts[0])
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for '%s': %w", filePath, err)
+	}
+	if err := os.WriteFile(filePath, dataBytes, 0600); err != nil {
+		return fmt.Errorf("failed to write '%s': %w", filePath, err)
+	}
+	fmt.Printf("%s Saved to %s\n", msg, filePath)
+	return nil
+}
+
+// handleUserImport handles the "user import" command.
+func (c *cli) handleUserImport(args string) error {
+	args = strings.TrimSpace(args)
+	overwrite := false
+	if rest, found := strings.CutSuffix(args, " overwrite"); found {
+		args, overwrite = strings.TrimSpace(rest), true
+	}
+	if args == "" {
+		return errors.New("usage: user import <users_json|path> [overwrite]")
+	}
+	jsonPayload, err := c.getJSONPayload(args)
+	if err != nil {
+		return err
+	}
+	if !json.Valid(jsonPayload) {
+		return errors.New("invalid users JSON format")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteImportUsersCommand(&cmdBuf, overwrite, jsonPayload)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("user import")
+}
+
+// handleUserSetRoles handles the "user roles" command.
+func (c *cli) handleUserSetRoles(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) < 1 {
+		return errors.New("usage: user roles <username> [role ...]")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteUserSetRolesCommand(&cmdBuf, parts[0], parts[1:])
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("user roles")
+}
+
+// handleRoleCreate handles the "role create" command.
+func (c *cli) handleRoleCreate(args string) error {
+	parts := strings.SplitN(args, " ", 2)
+	if len(parts) < 2 {
+		return errors.New("usage: role create <role> <permissions_json|path>")
+	}
+	jsonPayload, err := c.getJSONPayload(parts[1])
+	if err != nil {
+		return err
+	}
+	if !json.Valid(jsonPayload) {
+		return errors.New("invalid permissions JSON format")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteRoleCreateCommand(&cmdBuf, parts[0], jsonPayload)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("role create")
+}
+
+// handleRoleUpdate handles the "role update" command.
+func (c *cli) handleRoleUpdate(args string) error {
+	parts := strings.SplitN(args, " ", 2)
+	if len(parts) < 2 {
+		return errors.New("usage: role update <role> <permissions_json|path>")
+	}
+	jsonPayload, err := c.getJSONPayload(parts[1])
+	if err != nil {
+		return err
+	}
+	if !json.Valid(jsonPayload) {
+		return errors.New("invalid permissions JSON format")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteRoleUpdateCommand(&cmdBuf, parts[0], jsonPayload)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("role update")
+}
+
+// handleRoleDelete handles the "role delete" command.
+func (c *cli) handleRoleDelete(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) != 1 {
+		return errors.New("usage: role delete <role>")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteRoleDeleteCommand(&cmdBuf, parts[0])
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("role delete")
+}
+
+// handleAPIKeyCreate handles the "apikey create" command.
+// A duration expiry, such as 720h, is turned into a time counted from now.
+func (c *cli) handleAPIKeyCreate(args string) error {
+	parts := strings.SplitN(strings.TrimSpace(args), " ", 3)
+	if parts[0] == "" {
+		return errors.New("usage: apikey create <username> [expires=<RFC3339|duration>] [permissions_json|path]")
+	}
+	username, rest := parts[0], parts[1:]
+	var expiresAt string
+	if len(rest) > 0 {
+		if value, found := strings.CutPrefix(rest[0], "expires="); found {
+			if d, err := time.ParseDuration(value); err == nil {
+				expiresAt = time.Now().Add(d).UTC().Format(time.RFC3339)
+			} else if _, err := time.Parse(time.RFC3339, value); err == nil {
+				expiresAt = value
+			} else {
+				return fmt.Errorf("invalid expiry '%s': use an RFC3339 time or a duration such as 720h", value)
+			}
+			rest = rest[1:]
+		}
+	}
+	var permissionsJSON []byte
+	if len(rest) > 0 {
+		jsonArg := strings.Join(rest, " ")
+		var err error
+		if permissionsJSON, err = c.getJSONPayload(jsonArg); err != nil {
+			return err
+		}
+		if !json.Valid(permissionsJSON) {
+			return errors.New("invalid permissions JSON format")
+		}
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteAPIKeyCreateCommand(&cmdBuf, username, expiresAt, permissionsJSON)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("apikey create")
+}
+
+// handleAPIKeyRevoke handles the "apikey revoke" command.
+func (c *cli) handleAPIKeyRevoke(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) != 1 {
+		return errors.New("usage: apikey revoke <key_id>")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteAPIKeyRevokeCommand(&cmdBuf, parts[0])
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("apikey revoke")
+}
+
+// handleAPIKeyList handles the "apikey list" command.
+func (c *cli) handleAPIKeyList(args string) error {
+	var cmdBuf bytes.Buffer
+	protocol.WriteAPIKeyListCommand(&cmdBuf)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("apikey list")
+}
+
 // handleChangePassword handles the "update password" command.
 func (c *cli) handleChangePassword(args string) error {
 	parts := strings.Fields(args)
@@ -310,11 +646,47 @@ func (c *cli) handleBackup(args string) error {
 	return c.readResponse("backup")
 }
 
-// handleRestore handles the "restore" command.
+// handleBackupList handles the "backup list" command.
+func (c *cli) handleBackupList(args string) error {
+	var cmdBuf bytes.Buffer
+	protocol.WriteBackupListCommand(&cmdBuf)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("backup list")
+}
+
+// handleBackupDelete handles the "backup delete" command.
+func (c *cli) handleBackupDelete(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) != 1 {
+		return errors.New("usage: backup delete <backup_name>")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteBackupDeleteCommand(&cmdBuf, parts[0])
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("backup delete")
+}
+
+// handleRestore handles the "restore" command. With --dry-run the backup is only validated;
+// otherwise the restore is confirmed first, as it replaces all current data.
 func (c *cli) handleRestore(args string) error {
 	parts := strings.Fields(args)
+	if len(parts) == 2 && parts[1] == "--dry-run" {
+		var cmdBuf bytes.Buffer
+		protocol.WriteRestoreValidateCommand(&cmdBuf, parts[0])
+		c.conn.Write(cmdBuf.Bytes())
+		return c.readResponse("restore --dry-run")
+	}
 	if len(parts) != 1 {
-		return errors.New("usage: restore <backup_name>")
+		return errors.New("usage: restore <backup_name> [--dry-run]")
+	}
+	fmt.Println(colorInfo("Are you sure you want to replace all current data with backup? (y/N): "), parts[0])
+	input, err := c.rl.Readline()
+	if err != nil {
+		return err
+	}
+	if strings.ToLower(strings.TrimSpace(input)) != "y" {
+		fmt.Println(colorInfo("Restore cancelled. Use 'restore <backup_name> --dry-run' to validate the backup first."))
+		return nil
 	}
 	var cmdBuf bytes.Buffer
 	protocol.WriteRestoreCommand(&cmdBuf, parts[0])
@@ -322,6 +694,263 @@ func (c *cli) handleRestore(args string) error {
 	return c.readResponse("restore")
 }
 
+// handleRestoreCollection handles the "restore collection" command.
+func (c *cli) handleRestoreCollection(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) != 2 {
+		return errors.New("usage: restore collection <backup_name> <collection_name>")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteRestoreCollectionCommand(&cmdBuf, parts[0], parts[1])
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("restore collection")
+}
+
+// handleCompactAll handles the "compact all" command.
+func (c *cli) handleCompactAll(args string) error {
+	var cmdBuf bytes.Buffer
+	protocol.WriteCompactAllCommand(&cmdBuf)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("compact all")
+}
+
+// handleCompactStatus handles the "compact status" command.
+func (c *cli) handleCompactStatus(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) != 1 {
+		return errors.New("usage: compact status <job_id>")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCompactStatusCommand(&cmdBuf, parts[0])
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("compact status")
+}
+
+// handleMemoryStats handles the "memory stats" command.
+func (c *cli) handleMemoryStats(args string) error {
+	var cmdBuf bytes.Buffer
+	protocol.WriteMemoryStatsCommand(&cmdBuf)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("memory stats")
+}
+
+// handleStats handles the "stats" command.
+func (c *cli) handleStats(args string) error {
+	var cmdBuf bytes.Buffer
+	protocol.WriteStatsCommand(&cmdBuf)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("stats")
+}
+
+// handleSlowQueries handles the "slow queries" command.
+func (c *cli) handleSlowQueries(args string) error {
+	var limit uint64
+	if parts := strings.Fields(args); len(parts) > 1 {
+		return errors.New("usage: slow queries [limit]")
+	} else if len(parts) == 1 {
+		var err error
+		if limit, err = strconv.ParseUint(parts[0], 10, 32); err != nil {
+			return fmt.Errorf("invalid limit '%s': must be a non-negative integer", parts[0])
+		}
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteSlowQueryListCommand(&cmdBuf, uint32(limit))
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("slow queries")
+}
+
+// handleSubscribe handles the "subscribe" command. The subscription gets a connection of its own,
+// as the server sends nothing but events on it, and ends when it is closed on Ctrl+C.
+func (c *cli) handleSubscribe(args string) error {
+	usage := errors.New("usage: subscribe <coll> [prefix=<p>] [values]")
+	parts := strings.Fields(args)
+	if len(parts) == 0 {
+		return usage
+	}
+	collName := parts[0]
+	var keyPrefix string
+	withValues := false
+	for _, part := range parts[1:] {
+		switch {
+		case part == "values":
+			withValues = true
+		case strings.HasPrefix(part, "prefix="):
+			keyPrefix = strings.TrimPrefix(part, "prefix=")
+		default:
+			return usage
+		}
+	}
+	if c.dial == nil {
+		return errors.New("subscriptions are not supported by this connection")
+	}
+
+	conn, err := c.openConnection()
+	if err != nil {
+		return fmt.Errorf("could not open subscription connection: %w", err)
+	}
+	defer conn.Close()
+
+	var cmdBuf bytes.Buffer
+	protocol.WriteSubscribeCommand(&cmdBuf, collName, keyPrefix, withValues)
+	if _, err := conn.Write(cmdBuf.Bytes()); err != nil {
+		return fmt.Errorf("could not send subscribe command: %w", err)
+	}
+
+	watch := closeOnInterrupt(conn)
+	defer watch.stop()
+	for {
+		status, msg, dataBytes, err := readResponseFrom(conn)
+		if err != nil {
+			if watch.interrupted.Load() {
+				fmt.Println(colorOK("Subscription ended."))
+				return nil
+			}
+			return err
+		}
+		if status != protocol.StatusPartial {
+			if status != protocol.StatusOk {
+				return fmt.Errorf("%s: %s", getStatusString(status), msg)
+			}
+			fmt.Println(colorOK(msg))
+			return nil
+		}
+		if len(dataBytes) == 0 {
+			fmt.Println(colorOK(msg + " (Ctrl+C to stop)"))
+			continue
+		}
+		fmt.Printf("%s\n", dataBytes)
+	}
+}
+
+// handleChangeFeed handles the "change feed" command. Like a subscription, the feed gets a
+// connection of its own, closed on Ctrl+C. Offsets are acknowledged with "change feed ack".
+func (c *cli) handleChangeFeed(args string) error {
+	usage := errors.New("usage: change feed <consumer> [collection=<c>] [from=<offset|earliest|latest>]")
+	parts := strings.Fields(args)
+	if len(parts) == 0 {
+		return usage
+	}
+	consumer := parts[0]
+	var collName, from string
+	for _, part := range parts[1:] {
+		switch {
+		case strings.HasPrefix(part, "collection="):
+			collName = strings.TrimPrefix(part, "collection=")
+		case strings.HasPrefix(part, "from="):
+			from = strings.TrimPrefix(part, "from=")
+		default:
+			return usage
+		}
+	}
+	if c.dial == nil {
+		return errors.New("change feeds are not supported by this connection")
+	}
+
+	conn, err := c.openConnection()
+	if err != nil {
+		return fmt.Errorf("could not open change feed connection: %w", err)
+	}
+	defer conn.Close()
+
+	var cmdBuf bytes.Buffer
+	protocol.WriteChangeFeedCommand(&cmdBuf, consumer, collName, from)
+	if _, err := conn.Write(cmdBuf.Bytes()); err != nil {
+		return fmt.Errorf("could not send change feed command: %w", err)
+	}
+
+	watch := closeOnInterrupt(conn)
+	defer watch.stop()
+	for {
+		status, msg, dataBytes, err := readResponseFrom(conn)
+		if err != nil {
+			if watch.interrupted.Load() {
+				fmt.Println(colorOK("Change feed ended."))
+				return nil
+			}
+			return err
+		}
+		if status != protocol.StatusPartial {
+			if status != protocol.StatusOk {
+				return fmt.Errorf("%s: %s", getStatusString(status), msg)
+			}
+			fmt.Println(colorOK(msg))
+			return nil
+		}
+		if len(dataBytes) == 0 {
+			fmt.Println(colorOK(msg + " (Ctrl+C to stop)"))
+			continue
+		}
+		fmt.Printf("%s\n", dataBytes)
+	}
+}
+
+// handleChangeFeedAck handles the "change feed ack" command.
+func (c *cli) handleChangeFeedAck(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) != 2 {
+		return errors.New("usage: change feed ack <consumer> <offset>")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteChangeFeedAckCommand(&cmdBuf, parts[0], parts[1])
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("change feed ack")
+}
+
+// interruptWatch closes a connection when the user presses Ctrl+C, ending a command that
+// streams until then.
+type interruptWatch struct {
+	interrupted atomic.Bool // Set once the connection was closed by Ctrl+C.
+	stopped     chan struct{}
+}
+
+// closeOnInterrupt closes conn on Ctrl+C until stop is called.
+func closeOnInterrupt(conn net.Conn) *interruptWatch {
+	w := &interruptWatch{stopped: make(chan struct{})}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		defer signal.Stop(interrupt)
+		select {
+		case <-interrupt:
+			w.interrupted.Store(true)
+			conn.Close()
+		case <-w.stopped:
+		}
+	}()
+	return w
+}
+
+func (w *interruptWatch) stop() {
+	close(w.stopped)
+}
+
+// handlePing handles the "ping" command.
+func (c *cli) handlePing(args string) error {
+	var cmdBuf bytes.Buffer
+	protocol.WritePingCommand(&cmdBuf)
+	start := time.Now()
+	if _, err := c.conn.Write(cmdBuf.Bytes()); err != nil {
+		return fmt.Errorf("could not send ping: %w", err)
+	}
+	status, msg, dataBytes, err := c.readRawResponse()
+	if err != nil {
+		return err
+	}
+	rtt := time.Since(start)
+	if status != protocol.StatusOk {
+		return fmt.Errorf("unexpected ping response: %s", msg)
+	}
+	var pong struct {
+		ServerTime string `json:"server_time"`
+	}
+	if len(dataBytes) > 0 && stdjson.Unmarshal(dataBytes, &pong) == nil && pong.ServerTime != "" {
+		fmt.Printf(colorOK("√ %s in %s (server time %s)\n"), msg, rtt.Round(time.Microsecond), pong.ServerTime)
+		return nil
+	}
+	fmt.Printf(colorOK("√ %s in %s\n"), msg, rtt.Round(time.Microsecond))
+	return nil
+}
+
 // handleMainSet handles the "set" command for the main store.
 func (c *cli) handleMainSet(args string) error {
 	parts := strings.SplitN(args, " ", 2)
@@ -395,14 +1024,382 @@ func (c *cli) handleCollectionDelete(args string) error {
 	return c.readResponse("collection delete")
 }
 
-// handleCollectionList handles the "collection list" command.
-func (c *cli) handleCollectionList(args string) error {
-	var cmdBuf bytes.Buffer
-	protocol.WriteCollectionListCommand(&cmdBuf)
+// handleCollectionRename handles the "collection rename" command.
+func (c *cli) handleCollectionRename(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) != 2 {
+		return errors.New("usage: collection rename <name> <new_name>")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionRenameCommand(&cmdBuf, parts[0], parts[1])
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection rename")
+}
+
+// handleCollectionCopy handles the "collection copy" command.
+func (c *cli) handleCollectionCopy(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) < 2 || len(parts) > 3 || (len(parts) == 3 && parts[2] != "force") {
+		return errors.New("usage: collection copy <name> <new_name> [force]")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionCopyCommand(&cmdBuf, parts[0], parts[1], len(parts) == 3)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection copy")
+}
+
+// handleCollectionTruncate handles the "collection truncate" command. Outside a transaction,
+// where it cannot be rolled back, it is confirmed first.
+func (c *cli) handleCollectionTruncate(args string) error {
+	parts := strings.Fields(args)
+	if len(parts) != 1 {
+		return errors.New("usage: collection truncate <name>")
+	}
+	if !c.inTransaction {
+		fmt.Println(colorInfo("Are you sure you want to remove every item of collection? (y/N): "), parts[0])
+		input, err := c.rl.Readline()
+		if err != nil {
+			return err
+		}
+		if strings.ToLower(strings.TrimSpace(input)) != "y" {
+			fmt.Println(colorInfo("Truncation cancelled."))
+			return nil
+		}
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionTruncateCommand(&cmdBuf, parts[0])
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection truncate")
+}
+
+// handleCollectionList handles the "collection list" command.
+func (c *cli) handleCollectionList(args string) error {
+	var prefix string
+	var limit, offset uint64
+	for _, opt := range strings.Fields(args) {
+		name, value, ok := strings.Cut(opt, "=")
+		if !ok {
+			return errors.New("usage: collection list [prefix=<p>] [limit=<n>] [offset=<n>]")
+		}
+		var err error
+		switch name {
+		case "prefix":
+			prefix = value
+		case "limit":
+			limit, err = strconv.ParseUint(value, 10, 32)
+		case "offset":
+			offset, err = strconv.ParseUint(value, 10, 32)
+		default:
+			return fmt.Errorf("unknown option '%s': expected prefix, limit or offset", name)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s '%s': must be a non-negative integer", name, value)
+		}
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionListCommand(&cmdBuf, prefix, uint32(limit), uint32(offset))
 	c.conn.Write(cmdBuf.Bytes())
 	return c.readResponse("collection list")
 }
 
+// handleTopLargest handles the "collection top largest" command.
+func (c *cli) handleTopLargest(args string) error {
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection top largest")
+	if err != nil {
+		return err
+	}
+	parts := strings.Fields(remainingArgs)
+	if len(parts) != 1 {
+		return errors.New("usage: collection top largest <collection> <n>")
+	}
+	n, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil || n == 0 {
+		return fmt.Errorf("invalid count '%s': must be a positive integer", parts[0])
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionTopLargestCommand(&cmdBuf, collName, uint32(n))
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection top largest")
+}
+
+// handleCollectionStats handles the "collection stats" command.
+func (c *cli) handleCollectionStats(args string) error {
+	collName, _, err := c.resolveCollectionName(args, "collection stats")
+	if err != nil {
+		return err
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionStatsCommand(&cmdBuf, collName)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection stats")
+}
+
+// handleCollectionReload handles the "collection reload" command.
+func (c *cli) handleCollectionReload(args string) error {
+	collName, _, err := c.resolveCollectionName(args, "collection reload")
+	if err != nil {
+		return err
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionReloadCommand(&cmdBuf, collName)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection reload")
+}
+
+// handleCollectionCompression handles the "collection compression" command.
+func (c *cli) handleCollectionCompression(args string) error {
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection compression")
+	if err != nil {
+		return err
+	}
+	parts := strings.Fields(remainingArgs)
+	if len(parts) != 1 || (parts[0] != "on" && parts[0] != "off") {
+		return errors.New("usage: collection compression <collection> <on|off>")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionSetCompressionCommand(&cmdBuf, collName, parts[0] == "on")
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection compression")
+}
+
+// handleCollectionFileCompression handles the "collection file compression" command.
+func (c *cli) handleCollectionFileCompression(args string) error {
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection file compression")
+	if err != nil {
+		return err
+	}
+	parts := strings.Fields(remainingArgs)
+	if len(parts) != 1 {
+		return errors.New("usage: collection file compression <collection> <none|gzip>")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionSetFileCompressionCommand(&cmdBuf, collName, parts[0])
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection file compression")
+}
+
+// handleCollectionDefaultTTL handles the "collection default ttl" command. "off" removes the default.
+func (c *cli) handleCollectionDefaultTTL(args string) error {
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection default ttl")
+	if err != nil {
+		return err
+	}
+	parts := strings.Fields(remainingArgs)
+	usage := errors.New("usage: collection default ttl <collection> <seconds|off>")
+	if len(parts) != 1 {
+		return usage
+	}
+	var ttl time.Duration
+	if parts[0] != "off" {
+		seconds, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || seconds <= 0 {
+			return usage
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionSetDefaultTTLCommand(&cmdBuf, collName, ttl)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection default ttl")
+}
+
+// handleCollectionSchema handles the "collection schema" command. "off" removes the schema.
+func (c *cli) handleCollectionSchema(args string) error {
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection schema")
+	if err != nil {
+		return err
+	}
+	schema := strings.TrimSpace(remainingArgs)
+	if schema == "" {
+		return errors.New("usage: collection schema <collection> <json|off>")
+	}
+	if schema == "off" {
+		schema = ""
+	} else if !json.Valid([]byte(schema)) {
+		return errors.New("invalid schema: must be a JSON object")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionSetSchemaCommand(&cmdBuf, collName, schema)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection schema")
+}
+
+// handleCollectionExport handles the "collection export" command.
+// The export is streamed to the terminal, or to a file under the json directory when one is named.
+func (c *cli) handleCollectionExport(args string) error {
+	const usage = "usage: collection export <collection> <json|csv> [fields=<path,path>] [file]"
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection export")
+	if err != nil {
+		return err
+	}
+	parts := strings.Fields(remainingArgs)
+	if len(parts) < 1 || len(parts) > 3 {
+		return errors.New(usage)
+	}
+	format := strings.ToLower(parts[0])
+	var fields []string
+	var fileName string
+	for _, part := range parts[1:] {
+		if list, ok := strings.CutPrefix(part, "fields="); ok {
+			if list == "" || fields != nil {
+				return errors.New(usage)
+			}
+			fields = strings.Split(list, ",")
+		} else if fileName == "" {
+			fileName = part
+		} else {
+			return errors.New(usage)
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	var filePath string
+	if fileName != "" {
+		filePath = filepath.Join("json", fileName)
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for '%s': %w", filePath, err)
+		}
+		file, err := os.Create(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to create '%s': %w", filePath, err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionExportCommand(&cmdBuf, collName, format, fields)
+	c.conn.Write(cmdBuf.Bytes())
+	status, msg, err := c.readStreamedResponse(out)
+	if err == nil && status != protocol.StatusOk {
+		err = fmt.Errorf("%s: %s", getStatusString(status), msg)
+	}
+	if err != nil {
+		if filePath != "" {
+			os.Remove(filePath)
+		}
+		return err
+	}
+	if filePath != "" {
+		fmt.Printf("%s Saved to %s\n", msg, filePath)
+	} else {
+		fmt.Println(colorOK(msg))
+	}
+	return nil
+}
+
+// handleCollectionImport handles the "collection import" command.
+// The file is read one line at a time, so it never has to fit in memory. skip resumes an
+// interrupted import from the "processed" count it reported.
+func (c *cli) handleCollectionImport(args string) error {
+	const usage = "usage: collection import <collection> <file> [batch=<n>] [skip=<n>]"
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection import")
+	if err != nil {
+		return err
+	}
+	parts := strings.Fields(remainingArgs)
+	if len(parts) < 1 || len(parts) > 3 {
+		return errors.New(usage)
+	}
+	var batchSize uint32
+	skip := 0
+	for _, part := range parts[1:] {
+		if value, ok := strings.CutPrefix(part, "batch="); ok {
+			n, err := strconv.ParseUint(value, 10, 32)
+			if err != nil || n == 0 {
+				return fmt.Errorf("invalid batch size '%s'", value)
+			}
+			batchSize = uint32(n)
+		} else if value, ok := strings.CutPrefix(part, "skip="); ok {
+			if skip, err = strconv.Atoi(value); err != nil || skip < 0 {
+				return fmt.Errorf("invalid skip count '%s'", value)
+			}
+		} else {
+			return errors.New(usage)
+		}
+	}
+
+	filePath := filepath.Join("json", parts[0])
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open '%s': %w", filePath, err)
+	}
+	defer file.Close()
+
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+
+	// Progress is read while the documents are still being sent, so neither side blocks on a
+	// full connection buffer.
+	type importResult struct {
+		status protocol.ResponseStatus
+		msg    string
+		data   []byte
+		err    error
+	}
+	done := make(chan importResult, 1)
+	go func() {
+		for {
+			status, msg, dataBytes, err := readResponseFrom(c.conn)
+			if err != nil || status != protocol.StatusPartial {
+				done <- importResult{status, msg, dataBytes, err}
+				return
+			}
+			var progress struct {
+				Inserted  int `json:"inserted"`
+				Skipped   int `json:"skipped"`
+				Failed    int `json:"failed"`
+				Processed int `json:"processed"`
+			}
+			if json.Unmarshal(dataBytes, &progress) == nil {
+				fmt.Printf("\rprocessed %d: %d inserted, %d skipped, %d failed", skip+progress.Processed, progress.Inserted, progress.Skipped, progress.Failed)
+			}
+		}
+	}()
+
+	w := bufio.NewWriter(c.conn)
+	sendErr := protocol.WriteCollectionImportCommand(w, collName, batchSize)
+	reader := bufio.NewReader(file)
+	for line := 0; sendErr == nil; {
+		doc, readErr := reader.ReadBytes('\n')
+		if doc = bytes.TrimSpace(doc); len(doc) > 0 {
+			if line >= skip {
+				sendErr = protocol.WriteImportDocument(w, doc)
+			}
+			line++
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			// The server still needs the end of the stream; the error is reported after its answer.
+			sendErr = fmt.Errorf("failed to read '%s': %w", filePath, readErr)
+			break
+		}
+	}
+	protocol.WriteImportEnd(w)
+	if err := w.Flush(); err != nil && sendErr == nil {
+		sendErr = fmt.Errorf("failed to send documents: %w", err)
+	}
+
+	result := <-done
+	fmt.Println()
+	if result.err != nil {
+		return result.err
+	}
+	if sendErr != nil {
+		return sendErr
+	}
+	if result.status != protocol.StatusOk {
+		return fmt.Errorf("%s: %s %s", getStatusString(result.status), result.msg, string(result.data))
+	}
+	fmt.Println(colorOK(result.msg))
+	if len(result.data) > 0 {
+		fmt.Println(string(result.data))
+	}
+	return nil
+}
+
 // handleIndexCreate handles the "collection index create" command.
 func (c *cli) handleIndexCreate(args string) error {
 	collName, remainingArgs, err := c.resolveCollectionName(args, "collection index create")
@@ -410,11 +1407,31 @@ func (c *cli) handleIndexCreate(args string) error {
 		return err
 	}
 	parts := strings.Fields(remainingArgs)
-	if len(parts) != 1 {
-		return errors.New("usage: collection index create <collection> <field_name>")
+	usage := errors.New("usage: collection index create <collection> <field_name> [case_insensitive] [unique]")
+	if len(parts) < 1 {
+		return usage
+	}
+	var options struct {
+		CaseInsensitive bool `json:"case_insensitive,omitempty"`
+		Unique          bool `json:"unique,omitempty"`
+	}
+	for _, option := range parts[1:] {
+		switch option {
+		case "case_insensitive":
+			options.CaseInsensitive = true
+		case "unique":
+			options.Unique = true
+		default:
+			return usage
+		}
 	}
 	var cmdBuf bytes.Buffer
-	protocol.WriteCollectionIndexCreateCommand(&cmdBuf, collName, parts[0])
+	if len(parts) > 1 {
+		optionsJSON, _ := json.Marshal(options)
+		protocol.WriteCollectionIndexCreateWithOptionsCommand(&cmdBuf, collName, parts[0], optionsJSON)
+	} else {
+		protocol.WriteCollectionIndexCreateCommand(&cmdBuf, collName, parts[0])
+	}
 	c.conn.Write(cmdBuf.Bytes())
 	return c.readResponse("collection index create")
 }
@@ -435,6 +1452,38 @@ func (c *cli) handleIndexDelete(args string) error {
 	return c.readResponse("collection index delete")
 }
 
+// handleIndexDisable handles the "collection index disable" command.
+func (c *cli) handleIndexDisable(args string) error {
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection index disable")
+	if err != nil {
+		return err
+	}
+	parts := strings.Fields(remainingArgs)
+	if len(parts) != 1 {
+		return errors.New("usage: collection index disable <collection> <field_name>")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionIndexDisableCommand(&cmdBuf, collName, parts[0])
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection index disable")
+}
+
+// handleIndexEnable handles the "collection index enable" command.
+func (c *cli) handleIndexEnable(args string) error {
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection index enable")
+	if err != nil {
+		return err
+	}
+	parts := strings.Fields(remainingArgs)
+	if len(parts) != 1 {
+		return errors.New("usage: collection index enable <collection> <field_name>")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionIndexEnableCommand(&cmdBuf, collName, parts[0])
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection index enable")
+}
+
 // handleIndexList handles the "collection index list" command.
 func (c *cli) handleIndexList(args string) error {
 	collName, _, err := c.resolveCollectionName(args, "collection index list")
@@ -447,6 +1496,18 @@ func (c *cli) handleIndexList(args string) error {
 	return c.readResponse("collection index list")
 }
 
+// handleIndexAudit handles the "collection index audit" command.
+func (c *cli) handleIndexAudit(args string) error {
+	collName, _, err := c.resolveCollectionName(args, "collection index audit")
+	if err != nil {
+		return err
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionIndexAuditCommand(&cmdBuf, collName)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection index audit")
+}
+
 // handleItemSet handles the "collection item set" command.
 func (c *cli) handleItemSet(args string) error {
 	collName, remainingArgs, err := c.resolveCollectionName(args, "collection item set")
@@ -515,11 +1576,19 @@ func (c *cli) handleItemGet(args string) error {
 		return err
 	}
 	parts := strings.Fields(remainingArgs)
-	if len(parts) != 1 {
-		return errors.New("usage: collection item get <collection> <key>")
+	if len(parts) < 1 || len(parts) > 2 {
+		return errors.New("usage: collection item get <collection> <key> [fields=<path,path>]")
+	}
+	var fields []string
+	if len(parts) == 2 {
+		list, ok := strings.CutPrefix(parts[1], "fields=")
+		if !ok || list == "" {
+			return errors.New("usage: collection item get <collection> <key> [fields=<path,path>]")
+		}
+		fields = strings.Split(list, ",")
 	}
 	var cmdBuf bytes.Buffer
-	protocol.WriteCollectionItemGetCommand(&cmdBuf, collName, parts[0])
+	protocol.WriteCollectionItemGetCommand(&cmdBuf, collName, parts[0], fields)
 	c.conn.Write(cmdBuf.Bytes())
 	return c.readResponse("collection item get")
 }
@@ -540,6 +1609,134 @@ func (c *cli) handleItemDelete(args string) error {
 	return c.readResponse("collection item delete")
 }
 
+// handleItemExists handles the "collection item exists" command.
+func (c *cli) handleItemExists(args string) error {
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection item exists")
+	if err != nil {
+		return err
+	}
+	parts := strings.Fields(remainingArgs)
+	if len(parts) != 1 {
+		return errors.New("usage: collection item exists <collection> <key>")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionItemExistsCommand(&cmdBuf, collName, parts[0])
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection item exists")
+}
+
+// handleItemKeys handles the "collection item keys" command.
+func (c *cli) handleItemKeys(args string) error {
+	const usage = "usage: collection item keys <collection> [prefix=<p>] [after=<cursor>] [limit=<n>] [cold=true]"
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection item keys")
+	if err != nil {
+		return err
+	}
+	var prefix, after string
+	var limit uint64
+	var includeCold bool
+	for _, opt := range strings.Fields(remainingArgs) {
+		name, value, ok := strings.Cut(opt, "=")
+		if !ok {
+			return errors.New(usage)
+		}
+		switch name {
+		case "prefix":
+			prefix = value
+		case "after":
+			after = value
+		case "limit":
+			if limit, err = strconv.ParseUint(value, 10, 32); err != nil {
+				return fmt.Errorf("invalid limit '%s': must be a non-negative integer", value)
+			}
+		case "cold":
+			if includeCold, err = strconv.ParseBool(value); err != nil {
+				return fmt.Errorf("invalid cold '%s': must be true or false", value)
+			}
+		default:
+			return fmt.Errorf("unknown option '%s': expected prefix, after, limit or cold", name)
+		}
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionKeyScanCommand(&cmdBuf, collName, prefix, after, int(limit), includeCold)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection item keys")
+}
+
+// handleItemTTL handles the "collection item ttl" command.
+func (c *cli) handleItemTTL(args string) error {
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection item ttl")
+	if err != nil {
+		return err
+	}
+	parts := strings.Fields(remainingArgs)
+	if len(parts) != 1 {
+		return errors.New("usage: collection item ttl <collection> <key>")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionItemTTLCommand(&cmdBuf, collName, parts[0])
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection item ttl")
+}
+
+// handleItemTouch handles the "collection item touch" command.
+func (c *cli) handleItemTouch(args string) error {
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection item touch")
+	if err != nil {
+		return err
+	}
+	parts := strings.Fields(remainingArgs)
+	if len(parts) != 2 {
+		return errors.New("usage: collection item touch <collection> <key> <ttl_seconds>")
+	}
+	ttlSeconds, err := strconv.Atoi(parts[1])
+	if err != nil || ttlSeconds < 0 {
+		return fmt.Errorf("invalid ttl '%s': must be a non-negative number of seconds", parts[1])
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionItemTouchCommand(&cmdBuf, collName, parts[0], time.Duration(ttlSeconds)*time.Second)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection item touch")
+}
+
+// handleItemIncrement handles the "collection item increment" command.
+func (c *cli) handleItemIncrement(args string) error {
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection item increment")
+	if err != nil {
+		return err
+	}
+	parts := strings.Fields(remainingArgs)
+	if len(parts) != 2 && len(parts) != 3 {
+		return errors.New("usage: collection item increment <collection> <key> <field> [delta]")
+	}
+	delta := 1.0
+	if len(parts) == 3 {
+		if delta, err = strconv.ParseFloat(parts[2], 64); err != nil {
+			return fmt.Errorf("invalid delta '%s': must be a number", parts[2])
+		}
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionItemIncrementCommand(&cmdBuf, collName, parts[0], parts[1], delta)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection item increment")
+}
+
+// handleItemPop handles the "collection item pop" command.
+func (c *cli) handleItemPop(args string) error {
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection item pop")
+	if err != nil {
+		return err
+	}
+	parts := strings.Fields(remainingArgs)
+	if len(parts) != 1 {
+		return errors.New("usage: collection item pop <collection> <key>")
+	}
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionItemGetAndDeleteCommand(&cmdBuf, collName, parts[0])
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection item pop")
+}
+
 // handleItemList handles the "collection item list" command.
 func (c *cli) handleItemList(args string) error {
 	collName, _, err := c.resolveCollectionName(args, "collection item list")
@@ -575,6 +1772,123 @@ func (c *cli) handleItemUpdate(args string) error {
 	return c.readResponse("collection item update")
 }
 
+// handleItemUpdateIf handles the "collection item update if" command.
+func (c *cli) handleItemUpdateIf(args string) error {
+	usage := errors.New("usage: collection item update if <coll> <key> <condition_json|path> <patch_json|path>")
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection item update if")
+	if err != nil {
+		return err
+	}
+	parts := strings.SplitN(remainingArgs, " ", 2)
+	if len(parts) != 2 {
+		return usage
+	}
+	key := parts[0]
+	conditionArg, patchArg, ok := splitFirstJSONArg(parts[1])
+	if !ok {
+		return usage
+	}
+
+	conditionPayload, err := c.getJSONPayload(conditionArg)
+	if err != nil {
+		return err
+	}
+	patchPayload, err := c.getJSONPayload(patchArg)
+	if err != nil {
+		return err
+	}
+
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionItemUpdateIfCommand(&cmdBuf, collName, key, conditionPayload, patchPayload)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection item update if")
+}
+
+// handleItemUpdateVersion handles the "collection item update version" command.
+func (c *cli) handleItemUpdateVersion(args string) error {
+	return c.sendVersionedWrite(args, "collection item update version", "patch_json", protocol.WriteCollectionItemUpdateIfMatchCommand)
+}
+
+// handleItemReplaceVersion handles the "collection item replace version" command.
+func (c *cli) handleItemReplaceVersion(args string) error {
+	return c.sendVersionedWrite(args, "collection item replace version", "value_json", protocol.WriteCollectionItemReplaceIfMatchCommand)
+}
+
+// sendVersionedWrite parses "<coll> <key> <version> <json|path>" and sends a write that only
+// applies if the item is still at that version.
+func (c *cli) sendVersionedWrite(args, name, valueName string, write func(w io.Writer, collectionName, key string, value []byte, version uint64) error) error {
+	usage := fmt.Errorf("usage: %s <coll> <key> <version> <%s|path>", name, valueName)
+	collName, remainingArgs, err := c.resolveCollectionName(args, name)
+	if err != nil {
+		return err
+	}
+	parts := strings.SplitN(remainingArgs, " ", 3)
+	if len(parts) != 3 {
+		return usage
+	}
+	key, versionArg, jsonArg := parts[0], parts[1], parts[2]
+	version, err := strconv.ParseUint(versionArg, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid version '%s': must be a non-negative integer", versionArg)
+	}
+
+	jsonPayload, err := c.getJSONPayload(jsonArg)
+	if err != nil {
+		return err
+	}
+
+	var cmdBuf bytes.Buffer
+	write(&cmdBuf, collName, key, jsonPayload, version)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse(name)
+}
+
+// handleItemUpsert handles the "collection item upsert" command.
+func (c *cli) handleItemUpsert(args string) error {
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection item upsert")
+	if err != nil {
+		return err
+	}
+	parts := strings.SplitN(remainingArgs, " ", 2)
+	if len(parts) != 2 {
+		return errors.New("usage: collection item upsert <coll> <key> <patch_json|path>")
+	}
+	key, jsonArg := parts[0], parts[1]
+
+	jsonPayload, err := c.getJSONPayload(jsonArg)
+	if err != nil {
+		return err
+	}
+
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionItemUpsertCommand(&cmdBuf, collName, key, jsonPayload)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection item upsert")
+}
+
+// handleItemReplace handles the "collection item replace" command.
+func (c *cli) handleItemReplace(args string) error {
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection item replace")
+	if err != nil {
+		return err
+	}
+	parts := strings.SplitN(remainingArgs, " ", 2)
+	if len(parts) != 2 {
+		return errors.New("usage: collection item replace <coll> <key> <value_json|path>")
+	}
+	key, jsonArg := parts[0], parts[1]
+
+	jsonPayload, err := c.getJSONPayload(jsonArg)
+	if err != nil {
+		return err
+	}
+
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionItemReplaceCommand(&cmdBuf, collName, key, jsonPayload)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection item replace")
+}
+
 // handleQuery handles the "collection query" command.
 func (c *cli) handleQuery(args string) error {
 	collName, remainingArgs, err := c.resolveCollectionName(args, "collection query")
@@ -590,10 +1904,28 @@ func (c *cli) handleQuery(args string) error {
 		return err
 	}
 
+	var options struct {
+		Stream bool `json:"stream"`
+	}
+	json.Unmarshal(jsonPayload, &options)
+
 	var cmdBuf bytes.Buffer
 	protocol.WriteCollectionQueryCommand(&cmdBuf, collName, jsonPayload)
 	c.conn.Write(cmdBuf.Bytes())
-	return c.readResponse("collection query")
+	if !options.Stream {
+		return c.readResponse("collection query")
+	}
+
+	// Streamed documents are printed one per line as they arrive.
+	status, msg, err := c.readStreamedResponse(&queryRowPrinter{out: os.Stdout})
+	if err != nil {
+		return err
+	}
+	if status != protocol.StatusOk {
+		return fmt.Errorf("%s: %s", getStatusString(status), msg)
+	}
+	fmt.Println(colorOK(msg))
+	return nil
 }
 
 // handleItemSetMany handles the "collection item set many" command.
@@ -602,14 +1934,52 @@ func (c *cli) handleItemSetMany(args string) error {
 	if err != nil {
 		return err
 	}
+	usage := errors.New("usage: collection item set many <coll> <json_array|path> [ttl=<seconds>] [minimal]")
+	minimal := false
+	var ttlSeconds int64
+	// The options follow the documents, in any order.
+	for {
+		remainingArgs = strings.TrimSpace(remainingArgs)
+		cut := strings.LastIndex(remainingArgs, " ")
+		if cut < 0 {
+			break
+		}
+		last := remainingArgs[cut+1:]
+		if last == "minimal" {
+			minimal = true
+		} else if value, ok := strings.CutPrefix(last, "ttl="); ok {
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || seconds <= 0 {
+				return usage
+			}
+			ttlSeconds = seconds
+		} else {
+			break
+		}
+		remainingArgs = remainingArgs[:cut]
+	}
 	if remainingArgs == "" {
-		return errors.New("usage: collection item set many <coll> <json_array|path>")
+		return usage
 	}
 
 	jsonPayload, err := c.getJSONPayload(remainingArgs)
 	if err != nil {
 		return err
 	}
+	if minimal || ttlSeconds > 0 {
+		if !json.Valid(jsonPayload) {
+			return errors.New("invalid JSON array format")
+		}
+		// With minimal, only the keys and skip counts come back instead of every stored document.
+		request := map[string]any{"items": stdjson.RawMessage(jsonPayload), "minimal_response": minimal}
+		if ttlSeconds > 0 {
+			request["ttl_seconds"] = ttlSeconds
+		}
+		jsonPayload, err = json.Marshal(request)
+		if err != nil {
+			return err
+		}
+	}
 
 	var cmdBuf bytes.Buffer
 	protocol.WriteCollectionItemSetManyCommand(&cmdBuf, collName, jsonPayload)
@@ -638,6 +2008,59 @@ func (c *cli) handleItemUpdateMany(args string) error {
 	return c.readResponse("collection item update many")
 }
 
+// handleItemMergeWhere handles the "collection item merge where" command.
+func (c *cli) handleItemMergeWhere(args string) error {
+	usage := errors.New("usage: collection item merge where <coll> <filter_json|path> <patch_json|path>")
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection item merge where")
+	if err != nil {
+		return err
+	}
+	filterArg, patchArg, ok := splitFirstJSONArg(remainingArgs)
+	if !ok {
+		return usage
+	}
+
+	filterPayload, err := c.getJSONPayload(filterArg)
+	if err != nil {
+		return err
+	}
+	patchPayload, err := c.getJSONPayload(patchArg)
+	if err != nil {
+		return err
+	}
+
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionItemMergeByQueryCommand(&cmdBuf, collName, filterPayload, patchPayload)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection item merge where")
+}
+
+// handleItemGetMany handles the "collection item get many" command.
+func (c *cli) handleItemGetMany(args string) error {
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection item get many")
+	if err != nil {
+		return err
+	}
+	if remainingArgs == "" {
+		return errors.New("usage: collection item get many <coll> <keys_json_array|path>")
+	}
+
+	jsonPayload, err := c.getJSONPayload(remainingArgs)
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	if err := json.Unmarshal(jsonPayload, &keys); err != nil {
+		return fmt.Errorf("invalid keys JSON array: %w", err)
+	}
+
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionItemGetManyCommand(&cmdBuf, collName, keys)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection item get many")
+}
+
 // handleItemDeleteMany handles the "collection item delete many" command.
 func (c *cli) handleItemDeleteMany(args string) error {
 	collName, remainingArgs, err := c.resolveCollectionName(args, "collection item delete many")
@@ -663,3 +2086,35 @@ func (c *cli) handleItemDeleteMany(args string) error {
 	c.conn.Write(cmdBuf.Bytes())
 	return c.readResponse("collection item delete many")
 }
+
+// handleItemDiff handles the "collection item diff" command.
+func (c *cli) handleItemDiff(args string) error {
+	collName, remainingArgs, err := c.resolveCollectionName(args, "collection item diff")
+	if err != nil {
+		return err
+	}
+	parts := strings.SplitN(remainingArgs, " ", 2)
+	if len(parts) != 2 {
+		return errors.New("usage: collection item diff <coll> <key_a> <key_b|document_json|path>")
+	}
+	keyA, target := parts[0], strings.TrimSpace(parts[1])
+
+	var keyB string
+	var document []byte
+	if strings.HasPrefix(target, "{") || strings.HasSuffix(target, ".json") {
+		document, err = c.getJSONPayload(target)
+		if err != nil {
+			return err
+		}
+		if !json.Valid(document) {
+			return errors.New("invalid JSON format for document")
+		}
+	} else {
+		keyB = target
+	}
+
+	var cmdBuf bytes.Buffer
+	protocol.WriteCollectionItemDiffCommand(&cmdBuf, collName, keyA, keyB, document)
+	c.conn.Write(cmdBuf.Bytes())
+	return c.readResponse("collection item diff")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"memory-tools/internal/protocol"
+	"time"
+)
+
+// startHeartbeat sends a PING whenever the connection has been idle for interval, so that
+// NAT gateways, load balancers and the server's idle timeout do not drop a quiet session.
+// It stops at the first failed ping; the next command then reports the broken connection.
+func (c *cli) startHeartbeat(interval time.Duration) {
+	c.requestMu.Lock()
+	c.lastRequest = time.Now()
+	c.requestMu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(max(interval/2, time.Millisecond))
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := c.pingIfIdle(interval); err != nil {
+				fmt.Println(colorErr("\nHeartbeat failed, the connection may have been lost: ", err))
+				return
+			}
+		}
+	}()
+}
+
+// pingIfIdle sends one PING if no command has run for interval. It skips the round when a
+// command is in flight.
+func (c *cli) pingIfIdle(interval time.Duration) error {
+	if !c.requestMu.TryLock() {
+		return nil
+	}
+	defer c.requestMu.Unlock()
+	if time.Since(c.lastRequest) < interval {
+		return nil
+	}
+
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	var cmdBuf bytes.Buffer
+	protocol.WritePingCommand(&cmdBuf)
+	if _, err := c.conn.Write(cmdBuf.Bytes()); err != nil {
+		return err
+	}
+	status, msg, _, err := readResponseFrom(c.conn)
+	if err != nil {
+		return err
+	}
+	if status != protocol.StatusOk {
+		return fmt.Errorf("unexpected ping response: %s", msg)
+	}
+	c.lastRequest = time.Now()
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"memory-tools/internal/handler"
+	"memory-tools/internal/protocol"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatKeepsIdleConnectionAlive(t *testing.T) {
+	const idleTimeout = 150 * time.Millisecond
+	handler.SetConnIdleTimeout(idleTimeout)
+	t.Cleanup(func() { handler.SetConnIdleTimeout(0) })
+	_, dial := startTestServer(t)
+
+	kept, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kept.Close()
+	dropped, err := dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dropped.Close()
+
+	c := newCLI(kept)
+	c.startHeartbeat(idleTimeout / 3)
+	time.Sleep(4 * idleTimeout)
+
+	c.requestMu.Lock()
+	defer c.requestMu.Unlock()
+	if err := ping(c); err != nil {
+		t.Fatalf("connection with a heartbeat was dropped: %v", err)
+	}
+	if err := ping(newCLI(dropped)); err == nil {
+		t.Fatal("connection without a heartbeat survived the idle timeout")
+	}
+}
+
+// ping sends a PING over the connection of c and waits for its response.
+func ping(c *cli) error {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	if err := protocol.WritePingCommand(c.conn); err != nil {
+		return err
+	}
+	_, _, _, err := readResponseFrom(c.conn)
+	return err
+}
@@ -6,6 +6,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"strings"
 )
@@ -15,6 +16,10 @@ func main() {
 
 	usernamePtr := flag.String("u", "", "Username for authentication")
 	passwordPtr := flag.String("p", "", "Password for authentication")
+	apiKeyPtr := flag.String("token", "", "API key for authentication, instead of a username and password")
+	certPtr := flag.String("cert", "", "Client certificate file, for servers that authenticate clients by certificate")
+	keyPtr := flag.String("key", "", "Private key file of the client certificate")
+	heartbeatPtr := flag.Duration("heartbeat", 0, "Send a PING after this much idle time to keep the connection alive (e.g. 30s; 0 disables)")
 	flag.Parse()
 
 	addr := "localhost:5876"
@@ -39,6 +44,15 @@ func main() {
 		RootCAs:    caCertPool,
 		ServerName: strings.Split(addr, ":")[0],
 	}
+	var certUser string
+	if *certPtr != "" || *keyPtr != "" {
+		clientCert, err := tls.LoadX509KeyPair(*certPtr, *keyPtr)
+		if err != nil {
+			log.Fatal(colorErr("Failed to load client certificate: ", err))
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+		certUser = clientCert.Leaf.Subject.CommonName
+	}
 
 	// Connect using TLS
 	conn, err := tls.Dial("tcp", addr, tlsConfig)
@@ -51,7 +65,14 @@ func main() {
 
 	// Initialize and run the client
 	client := newCLI(conn)
-	if err := client.run(usernamePtr, passwordPtr); err != nil {
+	client.certUser = certUser
+	client.dial = func() (net.Conn, error) {
+		return tls.Dial("tcp", addr, tlsConfig)
+	}
+	if *heartbeatPtr > 0 {
+		client.startHeartbeat(*heartbeatPtr)
+	}
+	if err := client.run(usernamePtr, passwordPtr, apiKeyPtr); err != nil {
 		log.Fatal(colorErr("Client error: %v", err))
 	}
 }
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"memory-tools/internal/protocol"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -73,6 +74,12 @@ func getStatusString(s protocol.ResponseStatus) string {
 		return "UNAUTHORIZED"
 	case protocol.StatusBadRequest:
 		return "BAD_REQUEST"
+	case protocol.StatusPartial:
+		return "PARTIAL"
+	case protocol.StatusConflict:
+		return "CONFLICT"
+	case protocol.StatusThrottled:
+		return "THROTTLED"
 	default:
 		return "UNKNOWN"
 	}
@@ -87,6 +94,27 @@ func (c *cli) getJSONPayload(payload string) ([]byte, error) {
 	return []byte(payload), nil
 }
 
+// splitFirstJSONArg splits args into a leading JSON value (or file path) and the rest.
+// A raw JSON object may contain spaces, so its end is found by decoding it.
+func splitFirstJSONArg(args string) (string, string, bool) {
+	args = strings.TrimSpace(args)
+	if strings.HasPrefix(args, "{") {
+		dec := stdjson.NewDecoder(strings.NewReader(args))
+		var first stdjson.RawMessage
+		if err := dec.Decode(&first); err != nil {
+			return "", "", false
+		}
+		end := int(dec.InputOffset())
+		rest := strings.TrimSpace(args[end:])
+		return args[:end], rest, rest != ""
+	}
+	parts := strings.SplitN(args, " ", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], strings.TrimSpace(parts[1]), true
+}
+
 // resolveCollectionName parses the command arguments to extract the collection name.
 func (c *cli) resolveCollectionName(args string, commandName string) (string, string, error) {
 	args = strings.TrimSpace(args)
@@ -124,7 +152,12 @@ func (c *cli) readResponse(lastCmd string) error {
 	}
 
 	switch lastCmd {
-	case "collection list", "collection index list", "collection item list", "collection query":
+	case "collection list", "collection index list", "collection item list", "collection query", "collection top largest":
+		if !json.Valid(dataBytes) {
+			// Non-JSON payloads (e.g. CSV query results) are printed verbatim.
+			fmt.Printf("  %s\n%s\n", colorInfo("Data:"), string(dataBytes))
+			break
+		}
 		if err := printDynamicTable(dataBytes); err != nil {
 			fmt.Println(colorErr("Could not render table, falling back to JSON view."))
 			var prettyJSON bytes.Buffer
@@ -268,19 +301,68 @@ func printDynamicTable(dataBytes []byte) error {
 func (c *cli) readRawResponse() (protocol.ResponseStatus, string, []byte, error) {
 	c.connMutex.Lock()
 	defer c.connMutex.Unlock()
+	status, msg, dataBytes, err := readResponseFrom(c.conn)
+	if status == protocol.StatusError && strings.HasPrefix(msg, "ERROR: Transaction expired") {
+		// The server aborted the transaction and the connection has left it.
+		c.inTransaction = false
+	}
+	return status, msg, dataBytes, err
+}
+
+// readStreamedResponse reads the responses of a streamed command, writing the data of every chunk
+// to w, and returns the status and message of the final response. The connection stays locked
+// until the stream ends so the heartbeat cannot read one of its chunks.
+func (c *cli) readStreamedResponse(w io.Writer) (protocol.ResponseStatus, string, error) {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+	for {
+		status, msg, dataBytes, err := readResponseFrom(c.conn)
+		if err != nil {
+			return status, msg, err
+		}
+		if _, err := w.Write(dataBytes); err != nil {
+			return status, msg, fmt.Errorf("failed to write streamed data: %w", err)
+		}
+		if status != protocol.StatusPartial {
+			return status, msg, nil
+		}
+	}
+}
+
+// queryRowPrinter writes the rows of a streamed query, which arrive as length-prefixed JSON
+// documents, to out as one document per line.
+type queryRowPrinter struct {
+	out io.Writer
+}
+
+func (p *queryRowPrinter) Write(chunk []byte) (int, error) {
+	r := bytes.NewReader(chunk)
+	for r.Len() > 0 {
+		row, err := protocol.ReadBytes(r)
+		if err != nil {
+			return 0, fmt.Errorf("malformed query row: %w", err)
+		}
+		if _, err := fmt.Fprintf(p.out, "%s\n", row); err != nil {
+			return 0, err
+		}
+	}
+	return len(chunk), nil
+}
 
+// readResponseFrom reads the status, message, and data of a single response from conn.
+func readResponseFrom(conn net.Conn) (protocol.ResponseStatus, string, []byte, error) {
 	statusByte := make([]byte, 1)
-	if _, err := io.ReadFull(c.conn, statusByte); err != nil {
+	if _, err := io.ReadFull(conn, statusByte); err != nil {
 		return 0, "", nil, fmt.Errorf("failed to read response status from server: %w", err)
 	}
 	status := protocol.ResponseStatus(statusByte[0])
 
-	msg, err := protocol.ReadString(c.conn)
+	msg, err := protocol.ReadString(conn)
 	if err != nil {
 		return status, "", nil, fmt.Errorf("failed to read response message from server: %w", err)
 	}
 
-	dataBytes, err := protocol.ReadBytes(c.conn)
+	dataBytes, err := protocol.ReadBytes(conn)
 	if err != nil {
 		return status, msg, nil, fmt.Errorf("failed to read response data from server: %w", err)
 	}
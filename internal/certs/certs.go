@@ -0,0 +1,117 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// EnsureSelfSigned generates a self-signed certificate and private key at certFile and
+// keyFile if neither exists yet. hosts become the certificate's subject alternative names;
+// entries that parse as IP addresses are added as IP SANs, the rest as DNS names.
+// It reports whether new files were written. Existing files are never overwritten.
+func EnsureSelfSigned(certFile, keyFile string, hosts []string, validity time.Duration) (bool, error) {
+	certExists, err := fileExists(certFile)
+	if err != nil {
+		return false, err
+	}
+	keyExists, err := fileExists(keyFile)
+	if err != nil {
+		return false, err
+	}
+	if certExists && keyExists {
+		return false, nil
+	}
+	if certExists || keyExists {
+		return false, fmt.Errorf("only one of certificate '%s' and key '%s' exists; refusing to overwrite it", certFile, keyFile)
+	}
+	if len(hosts) == 0 {
+		return false, errors.New("at least one host is required for a self-signed certificate")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return false, fmt.Errorf("failed to generate private key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return false, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	notBefore := time.Now().Add(-time.Minute)
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0], Organization: []string{"Memory Tools (self-signed)"}},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return false, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	if err := writePEM(keyFile, "PRIVATE KEY", keyBytes, 0600); err != nil {
+		return false, err
+	}
+	if err := writePEM(certFile, "CERTIFICATE", der, 0644); err != nil {
+		os.Remove(keyFile)
+		return false, err
+	}
+
+	slog.Info("Self-signed certificate generated", "cert", certFile, "key", keyFile, "hosts", hosts, "expires", template.NotAfter.Format(time.RFC3339))
+	return true, nil
+}
+
+// fileExists reports whether path exists.
+func fileExists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to check '%s': %w", path, err)
+}
+
+// writePEM writes a single PEM block to path, creating its directory if needed.
+func writePEM(path, blockType string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for '%s': %w", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return fmt.Errorf("failed to create '%s': %w", path, err)
+	}
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: data}); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write '%s': %w", path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,102 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnsureSelfSignedCreatesUsableFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "certificates", "server.crt")
+	keyFile := filepath.Join(dir, "certificates", "server.key")
+
+	generated, err := EnsureSelfSigned(certFile, keyFile, []string{"localhost", "127.0.0.1"}, 48*time.Hour)
+	if err != nil || !generated {
+		t.Fatalf("generated = %v, err = %v", generated, err)
+	}
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("key file mode = %v, want 0600", perm)
+	}
+
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("generated files do not load as a key pair: %v", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != "localhost" {
+		t.Errorf("DNS names = %v, want [localhost]", cert.DNSNames)
+	}
+	if len(cert.IPAddresses) != 1 || !cert.IPAddresses[0].Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("IP addresses = %v, want [127.0.0.1]", cert.IPAddresses)
+	}
+	if validity := cert.NotAfter.Sub(cert.NotBefore); validity != 48*time.Hour {
+		t.Errorf("validity = %v, want 48h", validity)
+	}
+
+	// A TLS server started with the files accepts clients that trust the certificate.
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.(*tls.Conn).Handshake()
+	}()
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "localhost"})
+	if err != nil {
+		t.Fatalf("TLS handshake with the generated certificate failed: %v", err)
+	}
+	conn.Close()
+}
+
+func TestEnsureSelfSignedKeepsExistingFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	if _, err := EnsureSelfSigned(certFile, keyFile, []string{"localhost"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(certFile)
+
+	generated, err := EnsureSelfSigned(certFile, keyFile, []string{"localhost"}, time.Hour)
+	if err != nil || generated {
+		t.Fatalf("second call: generated = %v, err = %v, want the files left alone", generated, err)
+	}
+	if after, _ := os.ReadFile(certFile); !bytes.Equal(before, after) {
+		t.Fatal("existing certificate was overwritten")
+	}
+
+	os.Remove(keyFile)
+	if _, err := EnsureSelfSigned(certFile, keyFile, []string{"localhost"}, time.Hour); err == nil {
+		t.Fatal("want an error when only the certificate exists")
+	}
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Fatal("a key was written next to an existing certificate")
+	}
+}
+
+func TestEnsureSelfSignedNeedsAHost(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := EnsureSelfSigned(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"), nil, time.Hour); err == nil {
+		t.Fatal("want an error without hosts")
+	}
+}
@@ -6,44 +6,120 @@ import (
 	"log/slog"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 // Config holds application-wide configuration.
 type Config struct {
-	Port                 string
-	ShutdownTimeout      time.Duration
-	SnapshotInterval     time.Duration
-	EnableSnapshots      bool
-	EnableWal            bool
-	TtlCleanInterval     time.Duration
-	BackupInterval       time.Duration
-	BackupRetention      time.Duration
-	NumShards            int
-	DefaultRootPassword  string
-	DefaultAdminPassword string
-	ColdStorageMonths    int
-	HotStorageCleanHours int
-	WorkerPoolSize       int
+	Port                   string
+	ShutdownTimeout        time.Duration
+	SnapshotInterval       time.Duration
+	EnableSnapshots        bool
+	EnableWal              bool
+	WalSyncPolicy          string
+	WalMaxBytes            int64
+	TtlCleanInterval       time.Duration
+	BackupInterval         time.Duration
+	BackupRetention        time.Duration
+	BackupIncremental      bool
+	BackupFullEvery        int
+	BackupDestination      string
+	BackupS3Endpoint       string
+	BackupS3Bucket         string
+	BackupS3Region         string
+	BackupS3AccessKey      string
+	BackupS3SecretKey      string
+	BackupS3Prefix         string
+	NumShards              int
+	DefaultRootPassword    string
+	DefaultAdminPassword   string
+	ColdStorageMonths      int
+	HotStorageCleanHours   int
+	WorkerPoolSize         int
+	MaxConnections         int
+	TxTimeout              time.Duration
+	TxGCInterval           time.Duration
+	ReadBufferSize         int
+	CollectionListLimit    int
+	MaxQueryResponseBytes  int
+	MaxConcurrentQueries   int
+	SlowQueryThreshold     time.Duration
+	SlowQueryLogSize       int
+	QueryQueueSize         int
+	RateLimit              float64
+	RateLimitBurst         int
+	RateLimitPerCollection bool
+	IndexCreatedTs         bool
+	SaveFailureLimit       int
+	ColdPromotionThreshold int
+	CollectionMaxBytes     int64
+	EvictionPolicy         string
+	RecoveryMode           string
+	EncryptionKey          string
+	EncryptionPreviousKeys []string
+	ConnIdleTimeout        time.Duration
+	CertFile               string
+	KeyFile                string
+	GenerateSelfSignedCert bool
+	CertHosts              []string
+	CertValidity           time.Duration
+	ClientAuth             string
+	ClientCAFile           string
+	ClientCertLogin        bool
 }
 
 // NewDefaultConfig creates a Config struct with sensible default values.
 func NewDefaultConfig() Config {
 	return Config{
-		Port:                 ":5876",
-		ShutdownTimeout:      10 * time.Second,
-		SnapshotInterval:     5 * time.Minute,
-		EnableSnapshots:      true,
-		EnableWal:            false,
-		TtlCleanInterval:     1 * time.Minute,
-		BackupInterval:       1 * time.Hour,
-		BackupRetention:      7 * 24 * time.Hour,
-		NumShards:            16,
-		DefaultRootPassword:  "rootpass",
-		DefaultAdminPassword: "adminpass",
-		ColdStorageMonths:    3,
-		HotStorageCleanHours: 24,
-		WorkerPoolSize:       100,
+		Port:                   ":5876",
+		ShutdownTimeout:        10 * time.Second,
+		SnapshotInterval:       5 * time.Minute,
+		EnableSnapshots:        true,
+		EnableWal:              false,
+		WalSyncPolicy:          "always",
+		WalMaxBytes:            0,
+		TtlCleanInterval:       1 * time.Minute,
+		BackupInterval:         1 * time.Hour,
+		BackupRetention:        7 * 24 * time.Hour,
+		BackupIncremental:      false,
+		BackupFullEvery:        24,
+		BackupDestination:      "local",
+		BackupS3Region:         "us-east-1",
+		NumShards:              16,
+		DefaultRootPassword:    "rootpass",
+		DefaultAdminPassword:   "adminpass",
+		ColdStorageMonths:      3,
+		HotStorageCleanHours:   24,
+		WorkerPoolSize:         100,
+		MaxConnections:         0,
+		TxTimeout:              5 * time.Minute,
+		TxGCInterval:           10 * time.Minute,
+		ReadBufferSize:         4096,
+		CollectionListLimit:    1000,
+		MaxQueryResponseBytes:  0,
+		MaxConcurrentQueries:   0,
+		SlowQueryThreshold:     0,
+		SlowQueryLogSize:       100,
+		QueryQueueSize:         0,
+		RateLimit:              0,
+		RateLimitBurst:         0,
+		RateLimitPerCollection: false,
+		IndexCreatedTs:         false,
+		SaveFailureLimit:       5,
+		ColdPromotionThreshold: 0,
+		CollectionMaxBytes:     0,
+		EvictionPolicy:         "lru",
+		RecoveryMode:           "best_effort",
+		ConnIdleTimeout:        0,
+		CertFile:               "certificates/server.crt",
+		KeyFile:                "certificates/server.key",
+		GenerateSelfSignedCert: false,
+		CertHosts:              []string{"localhost", "127.0.0.1"},
+		CertValidity:           365 * 24 * time.Hour,
+		ClientAuth:             "none",
+		ClientCAFile:           "",
+		ClientCertLogin:        false,
 	}
 }
 
@@ -115,6 +191,61 @@ func applyEnvConfig(cfg *Config) {
 		}
 	}
 
+	if backupIncrementalEnv := os.Getenv("MEMORYTOOLS_BACKUP_INCREMENTAL"); backupIncrementalEnv != "" {
+		if b, err := strconv.ParseBool(backupIncrementalEnv); err == nil {
+			cfg.BackupIncremental = b
+			slog.Info("Overriding BackupIncremental from environment", "value", b)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_BACKUP_INCREMENTAL env var, using default", "value", backupIncrementalEnv)
+		}
+	}
+
+	if backupFullEveryEnv := os.Getenv("MEMORYTOOLS_BACKUP_FULL_EVERY"); backupFullEveryEnv != "" {
+		if i, err := strconv.Atoi(backupFullEveryEnv); err == nil && i > 0 {
+			cfg.BackupFullEvery = i
+			slog.Info("Overriding BackupFullEvery from environment", "value", i)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_BACKUP_FULL_EVERY env var, using default", "value", backupFullEveryEnv)
+		}
+	}
+
+	if backupDestinationEnv := os.Getenv("MEMORYTOOLS_BACKUP_DESTINATION"); backupDestinationEnv != "" {
+		if destination := strings.ToLower(backupDestinationEnv); destination == "local" || destination == "s3" {
+			cfg.BackupDestination = destination
+			slog.Info("Overriding BackupDestination from environment", "value", destination)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_BACKUP_DESTINATION env var, using default", "value", backupDestinationEnv)
+		}
+	}
+
+	if s3EndpointEnv := os.Getenv("MEMORYTOOLS_BACKUP_S3_ENDPOINT"); s3EndpointEnv != "" {
+		cfg.BackupS3Endpoint = s3EndpointEnv
+	}
+	if s3BucketEnv := os.Getenv("MEMORYTOOLS_BACKUP_S3_BUCKET"); s3BucketEnv != "" {
+		cfg.BackupS3Bucket = s3BucketEnv
+	}
+	if s3RegionEnv := os.Getenv("MEMORYTOOLS_BACKUP_S3_REGION"); s3RegionEnv != "" {
+		cfg.BackupS3Region = s3RegionEnv
+	}
+	if s3AccessKeyEnv := os.Getenv("MEMORYTOOLS_BACKUP_S3_ACCESS_KEY"); s3AccessKeyEnv != "" {
+		cfg.BackupS3AccessKey = s3AccessKeyEnv
+	}
+	if s3SecretKeyEnv := os.Getenv("MEMORYTOOLS_BACKUP_S3_SECRET_KEY"); s3SecretKeyEnv != "" {
+		cfg.BackupS3SecretKey = s3SecretKeyEnv
+	}
+	if s3PrefixEnv := os.Getenv("MEMORYTOOLS_BACKUP_S3_PREFIX"); s3PrefixEnv != "" {
+		cfg.BackupS3Prefix = s3PrefixEnv
+	}
+
+	if maxConnectionsEnv := os.Getenv("MEMORYTOOLS_MAX_CONNECTIONS"); maxConnectionsEnv != "" {
+		if i, err := strconv.Atoi(maxConnectionsEnv); err == nil && i >= 0 {
+			cfg.MaxConnections = i
+			slog.Info("Overriding MaxConnections from environment", "value", i)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_MAX_CONNECTIONS env var, using default", "value", maxConnectionsEnv)
+		}
+	}
+
 	if enableWalEnv := os.Getenv("MEMORYTOOLS_ENABLE_WAL"); enableWalEnv != "" {
 		if b, err := strconv.ParseBool(enableWalEnv); err == nil {
 			cfg.EnableWal = b
@@ -124,11 +255,235 @@ func applyEnvConfig(cfg *Config) {
 		}
 	}
 
+	if walSyncEnv := os.Getenv("MEMORYTOOLS_WAL_SYNC"); walSyncEnv != "" {
+		if policy := strings.ToLower(walSyncEnv); policy == "always" || policy == "everysec" || policy == "no" {
+			cfg.WalSyncPolicy = policy
+			slog.Info("Overriding WalSyncPolicy from environment", "value", policy)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_WAL_SYNC env var, using default", "value", walSyncEnv)
+		}
+	}
+
+	if walMaxBytesEnv := os.Getenv("MEMORYTOOLS_WAL_MAX_BYTES"); walMaxBytesEnv != "" {
+		if i, err := strconv.ParseInt(walMaxBytesEnv, 10, 64); err == nil && i >= 0 {
+			cfg.WalMaxBytes = i
+			slog.Info("Overriding WalMaxBytes from environment", "value", i)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_WAL_MAX_BYTES env var, using default", "value", walMaxBytesEnv)
+		}
+	}
+
+	if readBufferEnv := os.Getenv("MEMORYTOOLS_READ_BUFFER_SIZE"); readBufferEnv != "" {
+		if i, err := strconv.Atoi(readBufferEnv); err == nil && i > 0 {
+			cfg.ReadBufferSize = i
+			slog.Info("Overriding ReadBufferSize from environment", "value", i)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_READ_BUFFER_SIZE env var, using default", "value", readBufferEnv)
+		}
+	}
+
+	if listLimitEnv := os.Getenv("MEMORYTOOLS_COLLECTION_LIST_LIMIT"); listLimitEnv != "" {
+		if i, err := strconv.Atoi(listLimitEnv); err == nil && i > 0 {
+			cfg.CollectionListLimit = i
+			slog.Info("Overriding CollectionListLimit from environment", "value", i)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_COLLECTION_LIST_LIMIT env var, using default", "value", listLimitEnv)
+		}
+	}
+
+	if maxQueryBytesEnv := os.Getenv("MEMORYTOOLS_MAX_QUERY_RESPONSE_BYTES"); maxQueryBytesEnv != "" {
+		if i, err := strconv.Atoi(maxQueryBytesEnv); err == nil && i >= 0 {
+			cfg.MaxQueryResponseBytes = i
+			slog.Info("Overriding MaxQueryResponseBytes from environment", "value", i)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_MAX_QUERY_RESPONSE_BYTES env var, using default", "value", maxQueryBytesEnv)
+		}
+	}
+
+	if slowLogSizeEnv := os.Getenv("MEMORYTOOLS_SLOW_QUERY_LOG_SIZE"); slowLogSizeEnv != "" {
+		if i, err := strconv.Atoi(slowLogSizeEnv); err == nil && i >= 0 {
+			cfg.SlowQueryLogSize = i
+			slog.Info("Overriding SlowQueryLogSize from environment", "value", i)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_SLOW_QUERY_LOG_SIZE env var, using default", "value", slowLogSizeEnv)
+		}
+	}
+
+	if maxQueriesEnv := os.Getenv("MEMORYTOOLS_MAX_CONCURRENT_QUERIES"); maxQueriesEnv != "" {
+		if i, err := strconv.Atoi(maxQueriesEnv); err == nil && i >= 0 {
+			cfg.MaxConcurrentQueries = i
+			slog.Info("Overriding MaxConcurrentQueries from environment", "value", i)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_MAX_CONCURRENT_QUERIES env var, using default", "value", maxQueriesEnv)
+		}
+	}
+
+	if queryQueueEnv := os.Getenv("MEMORYTOOLS_QUERY_QUEUE_SIZE"); queryQueueEnv != "" {
+		if i, err := strconv.Atoi(queryQueueEnv); err == nil && i >= 0 {
+			cfg.QueryQueueSize = i
+			slog.Info("Overriding QueryQueueSize from environment", "value", i)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_QUERY_QUEUE_SIZE env var, using default", "value", queryQueueEnv)
+		}
+	}
+
+	if rateLimitEnv := os.Getenv("MEMORYTOOLS_RATE_LIMIT"); rateLimitEnv != "" {
+		if f, err := strconv.ParseFloat(rateLimitEnv, 64); err == nil && f >= 0 {
+			cfg.RateLimit = f
+			slog.Info("Overriding RateLimit from environment", "value", f)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_RATE_LIMIT env var, using default", "value", rateLimitEnv)
+		}
+	}
+
+	if rateLimitBurstEnv := os.Getenv("MEMORYTOOLS_RATE_LIMIT_BURST"); rateLimitBurstEnv != "" {
+		if i, err := strconv.Atoi(rateLimitBurstEnv); err == nil && i >= 0 {
+			cfg.RateLimitBurst = i
+			slog.Info("Overriding RateLimitBurst from environment", "value", i)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_RATE_LIMIT_BURST env var, using default", "value", rateLimitBurstEnv)
+		}
+	}
+
+	if rateLimitPerCollectionEnv := os.Getenv("MEMORYTOOLS_RATE_LIMIT_PER_COLLECTION"); rateLimitPerCollectionEnv != "" {
+		if b, err := strconv.ParseBool(rateLimitPerCollectionEnv); err == nil {
+			cfg.RateLimitPerCollection = b
+			slog.Info("Overriding RateLimitPerCollection from environment", "value", b)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_RATE_LIMIT_PER_COLLECTION env var, using default", "value", rateLimitPerCollectionEnv)
+		}
+	}
+
+	if indexCreatedTsEnv := os.Getenv("MEMORYTOOLS_INDEX_CREATED_TS"); indexCreatedTsEnv != "" {
+		if b, err := strconv.ParseBool(indexCreatedTsEnv); err == nil {
+			cfg.IndexCreatedTs = b
+			slog.Info("Overriding IndexCreatedTs from environment", "value", b)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_INDEX_CREATED_TS env var, using default", "value", indexCreatedTsEnv)
+		}
+	}
+
+	if saveFailureEnv := os.Getenv("MEMORYTOOLS_SAVE_FAILURE_LIMIT"); saveFailureEnv != "" {
+		if i, err := strconv.Atoi(saveFailureEnv); err == nil && i >= 0 {
+			cfg.SaveFailureLimit = i
+			slog.Info("Overriding SaveFailureLimit from environment", "value", i)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_SAVE_FAILURE_LIMIT env var, using default", "value", saveFailureEnv)
+		}
+	}
+
+	if promotionEnv := os.Getenv("MEMORYTOOLS_COLD_PROMOTION_THRESHOLD"); promotionEnv != "" {
+		if i, err := strconv.Atoi(promotionEnv); err == nil && i >= 0 {
+			cfg.ColdPromotionThreshold = i
+			slog.Info("Overriding ColdPromotionThreshold from environment", "value", i)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_COLD_PROMOTION_THRESHOLD env var, using default", "value", promotionEnv)
+		}
+	}
+
+	if maxBytesEnv := os.Getenv("MEMORYTOOLS_COLLECTION_MAX_BYTES"); maxBytesEnv != "" {
+		if i, err := strconv.ParseInt(maxBytesEnv, 10, 64); err == nil && i >= 0 {
+			cfg.CollectionMaxBytes = i
+			slog.Info("Overriding CollectionMaxBytes from environment", "value", i)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_COLLECTION_MAX_BYTES env var, using default", "value", maxBytesEnv)
+		}
+	}
+
+	if policyEnv := os.Getenv("MEMORYTOOLS_EVICTION_POLICY"); policyEnv != "" {
+		if policy := strings.ToLower(policyEnv); policy == "lru" || policy == "lfu" {
+			cfg.EvictionPolicy = policy
+			slog.Info("Overriding EvictionPolicy from environment", "value", policy)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_EVICTION_POLICY env var, using default", "value", policyEnv)
+		}
+	}
+
+	if recoveryEnv := os.Getenv("MEMORYTOOLS_RECOVERY_MODE"); recoveryEnv != "" {
+		if mode := strings.ToLower(recoveryEnv); mode == "best_effort" || mode == "strict" {
+			cfg.RecoveryMode = mode
+			slog.Info("Overriding RecoveryMode from environment", "value", mode)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_RECOVERY_MODE env var, using default", "value", recoveryEnv)
+		}
+	}
+
+	if encryptionKeyEnv := os.Getenv("MEMORYTOOLS_ENCRYPTION_KEY"); encryptionKeyEnv != "" {
+		cfg.EncryptionKey = encryptionKeyEnv
+	}
+
+	if previousKeysEnv := os.Getenv("MEMORYTOOLS_ENCRYPTION_PREVIOUS_KEYS"); previousKeysEnv != "" {
+		for key := range strings.SplitSeq(previousKeysEnv, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				cfg.EncryptionPreviousKeys = append(cfg.EncryptionPreviousKeys, key)
+			}
+		}
+	}
+
+	if certFileEnv := os.Getenv("MEMORYTOOLS_TLS_CERT_FILE"); certFileEnv != "" {
+		cfg.CertFile = certFileEnv
+	}
+
+	if keyFileEnv := os.Getenv("MEMORYTOOLS_TLS_KEY_FILE"); keyFileEnv != "" {
+		cfg.KeyFile = keyFileEnv
+	}
+
+	if generateCertEnv := os.Getenv("MEMORYTOOLS_GENERATE_SELF_SIGNED_CERT"); generateCertEnv != "" {
+		if b, err := strconv.ParseBool(generateCertEnv); err == nil {
+			cfg.GenerateSelfSignedCert = b
+			slog.Info("Overriding GenerateSelfSignedCert from environment", "value", b)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_GENERATE_SELF_SIGNED_CERT env var, using default", "value", generateCertEnv)
+		}
+	}
+
+	if certHostsEnv := os.Getenv("MEMORYTOOLS_CERT_HOSTS"); certHostsEnv != "" {
+		var hosts []string
+		for host := range strings.SplitSeq(certHostsEnv, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				hosts = append(hosts, host)
+			}
+		}
+		if len(hosts) > 0 {
+			cfg.CertHosts = hosts
+			slog.Info("Overriding CertHosts from environment", "value", hosts)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_CERT_HOSTS env var, using default", "value", certHostsEnv)
+		}
+	}
+
+	if clientAuthEnv := os.Getenv("MEMORYTOOLS_TLS_CLIENT_AUTH"); clientAuthEnv != "" {
+		if mode := strings.ToLower(clientAuthEnv); mode == "none" || mode == "optional" || mode == "require" {
+			cfg.ClientAuth = mode
+			slog.Info("Overriding ClientAuth from environment", "value", mode)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_TLS_CLIENT_AUTH env var, using default", "value", clientAuthEnv)
+		}
+	}
+
+	if clientCAFileEnv := os.Getenv("MEMORYTOOLS_TLS_CLIENT_CA_FILE"); clientCAFileEnv != "" {
+		cfg.ClientCAFile = clientCAFileEnv
+	}
+
+	if clientCertLoginEnv := os.Getenv("MEMORYTOOLS_TLS_CLIENT_CERT_LOGIN"); clientCertLoginEnv != "" {
+		if b, err := strconv.ParseBool(clientCertLoginEnv); err == nil {
+			cfg.ClientCertLogin = b
+			slog.Info("Overriding ClientCertLogin from environment", "value", b)
+		} else {
+			slog.Warn("Invalid MEMORYTOOLS_TLS_CLIENT_CERT_LOGIN env var, using default", "value", clientCertLoginEnv)
+		}
+	}
+
 	overrideDuration("MEMORYTOOLS_SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout)
 	overrideDuration("MEMORYTOOLS_SNAPSHOT_INTERVAL", &cfg.SnapshotInterval)
 	overrideDuration("MEMORYTOOLS_TTL_CLEAN_INTERVAL", &cfg.TtlCleanInterval)
 	overrideDuration("MEMORYTOOLS_BACKUP_INTERVAL", &cfg.BackupInterval)
 	overrideDuration("MEMORYTOOLS_BACKUP_RETENTION", &cfg.BackupRetention)
+	overrideDuration("MEMORYTOOLS_TRANSACTION_TIMEOUT", &cfg.TxTimeout)
+	overrideDuration("MEMORYTOOLS_TRANSACTION_GC_INTERVAL", &cfg.TxGCInterval)
+	overrideDuration("MEMORYTOOLS_CERT_VALIDITY", &cfg.CertValidity)
+	overrideDuration("MEMORYTOOLS_CONN_IDLE_TIMEOUT", &cfg.ConnIdleTimeout)
+	overrideDuration("MEMORYTOOLS_SLOW_QUERY_THRESHOLD", &cfg.SlowQueryThreshold)
 }
 
 func overrideDuration(envKey string, target *time.Duration) {
@@ -0,0 +1,196 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// KeySize is the size of an AES-256 key in bytes.
+	KeySize = 32
+	// NonceSize is the size of a GCM nonce in bytes.
+	NonceSize = 12
+	// KeyIDSize is the size of a key ID in bytes.
+	KeyIDSize = 8
+)
+
+// Key is an AES-256-GCM key.
+type Key struct {
+	id   [KeyIDSize]byte
+	aead cipher.AEAD
+}
+
+// ID identifies the key without revealing it: the first bytes of its SHA-256 hash.
+// It is written next to encrypted data so the right key can be picked to decrypt it.
+func (k *Key) ID() [KeyIDSize]byte {
+	return k.id
+}
+
+// IDString returns the key ID hex-encoded.
+func (k *Key) IDString() string {
+	return hex.EncodeToString(k.id[:])
+}
+
+func newKey(raw []byte) (*Key, error) {
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", KeySize, len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	k := &Key{aead: aead}
+	copy(k.id[:], sum[:KeyIDSize])
+	return k, nil
+}
+
+// ParseKey decodes a key given as 64 hex characters or as standard base64.
+func ParseKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if raw, err := hex.DecodeString(encoded); err == nil && len(raw) == KeySize {
+		return raw, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes encoded as hex or base64", KeySize)
+	}
+	return raw, nil
+}
+
+// Keyring holds the key that encrypts new data and every key that may still be needed to
+// decrypt existing data. During a key rotation the old keys stay in the ring until all data
+// has been rewritten with the current one.
+type Keyring struct {
+	current *Key
+	keys    map[[KeyIDSize]byte]*Key
+}
+
+// NewKeyring builds a keyring from encoded keys. current encrypts new data; an empty current
+// leaves new data unencrypted while previous keys can still decrypt existing data.
+func NewKeyring(current string, previous []string) (*Keyring, error) {
+	kr := &Keyring{keys: make(map[[KeyIDSize]byte]*Key)}
+	add := func(encoded string) (*Key, error) {
+		raw, err := ParseKey(encoded)
+		if err != nil {
+			return nil, err
+		}
+		k, err := newKey(raw)
+		if err != nil {
+			return nil, err
+		}
+		kr.keys[k.id] = k
+		return k, nil
+	}
+	if current != "" {
+		k, err := add(current)
+		if err != nil {
+			return nil, fmt.Errorf("invalid current encryption key: %w", err)
+		}
+		kr.current = k
+	}
+	for i, encoded := range previous {
+		if _, err := add(encoded); err != nil {
+			return nil, fmt.Errorf("invalid previous encryption key #%d: %w", i+1, err)
+		}
+	}
+	return kr, nil
+}
+
+// Current returns the key that encrypts new data, or nil if new data is not encrypted.
+// It is safe to call on a nil keyring.
+func (kr *Keyring) Current() *Key {
+	if kr == nil {
+		return nil
+	}
+	return kr.current
+}
+
+// Key returns the key with the given ID. It is safe to call on a nil keyring.
+func (kr *Keyring) Key(id [KeyIDSize]byte) (*Key, error) {
+	if kr != nil {
+		if k, ok := kr.keys[id]; ok {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownKey, hex.EncodeToString(id[:]))
+}
+
+// KeyByString returns the key with the given hex-encoded ID.
+func (kr *Keyring) KeyByString(id string) (*Key, error) {
+	raw, err := hex.DecodeString(id)
+	if err != nil || len(raw) != KeyIDSize {
+		return nil, fmt.Errorf("malformed encryption key ID '%s'", id)
+	}
+	return kr.Key([KeyIDSize]byte(raw))
+}
+
+// ErrUnknownKey reports data encrypted with a key that is not in the keyring.
+var ErrUnknownKey = errors.New("data is encrypted with a key that is not configured")
+
+// NewNonce returns a random nonce.
+func NewNonce() ([]byte, error) {
+	nonce := make([]byte, NonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return nonce, nil
+}
+
+// deriveNonce returns the nonce of the index-th message sealed under base. Every message sealed
+// under one base nonce must use a different index.
+func deriveNonce(base []byte, index uint64) []byte {
+	nonce := make([]byte, NonceSize)
+	copy(nonce, base)
+	counter := binary.BigEndian.Uint64(nonce[NonceSize-8:]) ^ index
+	binary.BigEndian.PutUint64(nonce[NonceSize-8:], counter)
+	return nonce
+}
+
+// SealAt encrypts the index-th message of a sequence whose base nonce is base, typically the
+// records of a file whose header holds the base. additionalData is authenticated but not encrypted.
+func (k *Key) SealAt(base []byte, index uint64, plaintext, additionalData []byte) []byte {
+	return k.aead.Seal(nil, deriveNonce(base, index), plaintext, additionalData)
+}
+
+// OpenAt decrypts a message sealed with SealAt.
+func (k *Key) OpenAt(base []byte, index uint64, ciphertext, additionalData []byte) ([]byte, error) {
+	plaintext, err := k.aead.Open(nil, deriveNonce(base, index), ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+// Seal encrypts a standalone message under a fresh random nonce, which prefixes the result.
+func (k *Key) Seal(plaintext []byte) ([]byte, error) {
+	nonce, err := NewNonce()
+	if err != nil {
+		return nil, err
+	}
+	return k.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts a message sealed with Seal.
+func (k *Key) Open(sealed []byte) ([]byte, error) {
+	if len(sealed) < NonceSize {
+		return nil, errors.New("sealed message is too short")
+	}
+	plaintext, err := k.aead.Open(nil, sealed[:NonceSize], sealed[NonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,157 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+var (
+	testKey  = strings.Repeat("11", KeySize)
+	otherKey = strings.Repeat("22", KeySize)
+)
+
+func newTestKeyring(t *testing.T, current string, previous ...string) *Keyring {
+	t.Helper()
+	kr, err := NewKeyring(current, previous)
+	if err != nil {
+		t.Fatalf("building keyring: %v", err)
+	}
+	return kr
+}
+
+// encryptStream writes plaintext through a stream writer with the current key of kr.
+func encryptStream(t *testing.T, kr *Keyring, plaintext []byte) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	w, err := NewWriter(&out, kr.Current())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	kr := newTestKeyring(t, testKey)
+	// Several chunks, the last one partial.
+	plaintext := bytes.Repeat([]byte(`{"_id":"k","secret":"s3cr3t"}`), 3*streamChunkSize/29+1)
+
+	sealed := encryptStream(t, kr, plaintext)
+	if bytes.Contains(sealed, []byte("s3cr3t")) {
+		t.Fatal("encrypted stream contains the plaintext")
+	}
+	r, encrypted, err := NewReader(bytes.NewReader(sealed), kr)
+	if err != nil || !encrypted {
+		t.Fatalf("NewReader: encrypted=%v err=%v", encrypted, err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("decrypted %d bytes, want the %d bytes written", len(got), len(plaintext))
+	}
+}
+
+func TestUnencryptedStreamIsReadAsIs(t *testing.T) {
+	r, encrypted, err := NewReader(strings.NewReader("plain data"), newTestKeyring(t, testKey))
+	if err != nil || encrypted {
+		t.Fatalf("NewReader: encrypted=%v err=%v", encrypted, err)
+	}
+	if got, _ := io.ReadAll(r); string(got) != "plain data" {
+		t.Fatalf("read %q", got)
+	}
+}
+
+func TestEveryStreamAndMessageGetsAFreshNonce(t *testing.T) {
+	kr := newTestKeyring(t, testKey)
+	plaintext := []byte("same plaintext")
+
+	first, second := encryptStream(t, kr, plaintext), encryptStream(t, kr, plaintext)
+	if bytes.Equal(first[:streamHeaderSize], second[:streamHeaderSize]) {
+		t.Fatal("two streams share a base nonce")
+	}
+	a, err := kr.Current().Seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := kr.Current().Seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(a[:NonceSize], b[:NonceSize]) {
+		t.Fatal("two messages share a nonce")
+	}
+}
+
+func TestWrongKeyIsRejected(t *testing.T) {
+	sealed := encryptStream(t, newTestKeyring(t, testKey), []byte("secret"))
+
+	if _, _, err := NewReader(bytes.NewReader(sealed), newTestKeyring(t, otherKey)); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("reading with another key: err = %v, want ErrUnknownKey", err)
+	}
+	if _, _, err := NewReader(bytes.NewReader(sealed), nil); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("reading without keys: err = %v, want ErrUnknownKey", err)
+	}
+
+	message, err := newTestKeyring(t, testKey).Current().Seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTestKeyring(t, otherKey).Current().Open(message); err == nil {
+		t.Fatal("a message sealed with one key was opened with another")
+	}
+}
+
+func TestPreviousKeysStillDecrypt(t *testing.T) {
+	sealed := encryptStream(t, newTestKeyring(t, testKey), []byte("written before the rotation"))
+
+	rotated := newTestKeyring(t, otherKey, testKey)
+	r, _, err := NewReader(bytes.NewReader(sealed), rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || string(got) != "written before the rotation" {
+		t.Fatalf("read %q, err %v", got, err)
+	}
+}
+
+func TestTamperedOrTruncatedStreamFails(t *testing.T) {
+	kr := newTestKeyring(t, testKey)
+	sealed := encryptStream(t, kr, bytes.Repeat([]byte("x"), 2*streamChunkSize))
+
+	tampered := bytes.Clone(sealed)
+	tampered[len(tampered)-1] ^= 1
+	// The plaintext fills two chunks exactly, so the final chunk is empty and cutting it off
+	// leaves a stream that ends cleanly between chunks.
+	truncated := sealed[:len(sealed)-4-kr.Current().aead.Overhead()]
+	for name, data := range map[string][]byte{"tampered": tampered, "truncated": truncated} {
+		r, _, err := NewReader(bytes.NewReader(data), kr)
+		if err != nil {
+			t.Fatalf("%s: NewReader: %v", name, err)
+		}
+		if _, err := io.ReadAll(r); err == nil {
+			t.Errorf("%s stream was read without an error", name)
+		}
+	}
+}
+
+func TestParseKeyAcceptsHexAndBase64(t *testing.T) {
+	for _, encoded := range []string{testKey, "ERERERERERERERERERERERERERERERERERERERERERE="} {
+		raw, err := ParseKey(encoded)
+		if err != nil || !bytes.Equal(raw, bytes.Repeat([]byte{0x11}, KeySize)) {
+			t.Errorf("ParseKey(%q) = %x, %v", encoded, raw, err)
+		}
+	}
+	if _, err := ParseKey("abcd"); err == nil {
+		t.Error("a short key was accepted")
+	}
+}
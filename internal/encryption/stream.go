@@ -0,0 +1,175 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// streamMagic starts every encrypted stream. Unencrypted data files start with a little-endian
+// entry count, which never spells it out.
+var streamMagic = []byte("MTENC\x01")
+
+const (
+	// streamChunkSize is the plaintext size of every chunk but the last.
+	streamChunkSize = 64 * 1024
+	// streamHeaderSize is the size of the magic, key ID and base nonce that start a stream.
+	streamHeaderSize = 6 + KeyIDSize + NonceSize
+)
+
+// Chunks are sealed with the chunk index as nonce counter and a final-chunk flag as additional
+// data, so chunks cannot be reordered, dropped or the stream truncated without detection.
+var (
+	chunkNotFinal = []byte{0}
+	chunkFinal    = []byte{1}
+)
+
+// streamWriter encrypts a stream in chunks.
+type streamWriter struct {
+	w     io.Writer
+	key   *Key
+	nonce []byte
+	buf   []byte
+	index uint64
+	err   error
+}
+
+// NewWriter returns a writer that encrypts everything written to it with key and writes it to w,
+// starting with a header that holds the key ID and a random base nonce. Close must be called to
+// write the final chunk; it does not close w.
+func NewWriter(w io.Writer, key *Key) (io.WriteCloser, error) {
+	nonce, err := NewNonce()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, streamHeaderSize)
+	header = append(header, streamMagic...)
+	header = append(header, key.id[:]...)
+	header = append(header, nonce...)
+	if _, err := w.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write encryption header: %w", err)
+	}
+	return &streamWriter{w: w, key: key, nonce: nonce, buf: make([]byte, 0, streamChunkSize)}, nil
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if sw.err != nil {
+			return written, sw.err
+		}
+		n := min(len(p), streamChunkSize-len(sw.buf))
+		sw.buf = append(sw.buf, p[:n]...)
+		p = p[n:]
+		written += n
+		if len(sw.buf) == streamChunkSize {
+			sw.err = sw.flush(false)
+		}
+	}
+	return written, sw.err
+}
+
+// Close writes the final chunk, which may be empty.
+func (sw *streamWriter) Close() error {
+	if sw.err != nil {
+		return sw.err
+	}
+	sw.err = sw.flush(true)
+	if sw.err == nil {
+		sw.err = errors.New("encrypted stream is closed")
+		return nil
+	}
+	return sw.err
+}
+
+func (sw *streamWriter) flush(final bool) error {
+	flag := chunkNotFinal
+	if final {
+		flag = chunkFinal
+	}
+	sealed := sw.key.SealAt(sw.nonce, sw.index, sw.buf, flag)
+	sw.index++
+	sw.buf = sw.buf[:0]
+	if err := binary.Write(sw.w, binary.LittleEndian, uint32(len(sealed))); err != nil {
+		return fmt.Errorf("failed to write encrypted chunk: %w", err)
+	}
+	if _, err := sw.w.Write(sealed); err != nil {
+		return fmt.Errorf("failed to write encrypted chunk: %w", err)
+	}
+	return nil
+}
+
+// streamReader decrypts a stream written by a streamWriter.
+type streamReader struct {
+	r     io.Reader
+	key   *Key
+	nonce []byte
+	buf   []byte
+	index uint64
+	done  bool
+}
+
+// NewReader returns a reader of r's plaintext. Streams written by NewWriter are decrypted with
+// the key from keyring named in their header; any other stream is returned as is, so data
+// written before encryption was enabled stays readable. The second result reports whether r
+// was encrypted.
+func NewReader(r io.Reader, keyring *Keyring) (io.Reader, bool, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(streamMagic))
+	if err != nil || !bytes.Equal(magic, streamMagic) {
+		return br, false, nil
+	}
+	header := make([]byte, streamHeaderSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, true, fmt.Errorf("failed to read encryption header: %w", err)
+	}
+	key, err := keyring.Key([KeyIDSize]byte(header[len(streamMagic) : len(streamMagic)+KeyIDSize]))
+	if err != nil {
+		return nil, true, err
+	}
+	return &streamReader{r: br, key: key, nonce: header[len(streamMagic)+KeyIDSize:]}, true, nil
+}
+
+func (sr *streamReader) Read(p []byte) (int, error) {
+	for len(sr.buf) == 0 {
+		if sr.done {
+			return 0, io.EOF
+		}
+		if err := sr.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, sr.buf)
+	sr.buf = sr.buf[n:]
+	return n, nil
+}
+
+func (sr *streamReader) next() error {
+	var length uint32
+	if err := binary.Read(sr.r, binary.LittleEndian, &length); err != nil {
+		if err == io.EOF {
+			return fmt.Errorf("encrypted stream is truncated: %w", io.ErrUnexpectedEOF)
+		}
+		return err
+	}
+	if length > streamChunkSize+uint32(sr.key.aead.Overhead()) {
+		return fmt.Errorf("encrypted chunk length %d is corrupted", length)
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(sr.r, sealed); err != nil {
+		return fmt.Errorf("encrypted stream is truncated: %w", err)
+	}
+	plaintext, err := sr.key.OpenAt(sr.nonce, sr.index, sealed, chunkNotFinal)
+	if err != nil {
+		if plaintext, err = sr.key.OpenAt(sr.nonce, sr.index, sealed, chunkFinal); err != nil {
+			return err
+		}
+		sr.done = true
+	}
+	sr.index++
+	sr.buf = plaintext
+	return nil
+}
@@ -12,8 +12,12 @@ const (
 	ID = "_id"
 	// CREATED_AT is the field for the document's creation timestamp.
 	CREATED_AT = "created_at"
+	// CREATED_TS mirrors CREATED_AT as Unix epoch seconds, so age ranges use the numeric index tree.
+	CREATED_TS = "_created_ts"
 	// UPDATED_AT is the field for the last update timestamp.
 	UPDATED_AT = "updated_at"
+	// VERSION is the field counting the writes of a document, used for optimistic concurrency control.
+	VERSION = "_version"
 	// DELETED_FLAG is the boolean field that acts as a tombstone for soft deletes.
 	DELETED_FLAG = "_deleted"
 
@@ -25,14 +29,30 @@ const (
 	SystemCollectionName = "_system"
 	// UserPrefix is the prefix used for user document keys in the system collection.
 	UserPrefix = "user:"
+	// APIKeyPrefix is the prefix used for API key records in the system collection.
+	APIKeyPrefix = "apikey:"
+	// RolePrefix is the prefix used for role documents in the system collection.
+	RolePrefix = "role:"
+	// CollectionMetaPrefix is the prefix used for per-collection settings in the system collection.
+	CollectionMetaPrefix = "collection_meta:"
+	// ChangeFeedPrefix is the prefix used for the acknowledged offsets of change feed consumers in the system collection.
+	ChangeFeedPrefix = "change_feed:"
+	// ProcedurePrefix is the prefix used for stored procedure definitions in the system collection.
+	ProcedurePrefix = "procedure:"
 
 	// =========================================================================
 	// Permission Levels
 	// =========================================================================
 
-	// PermissionRead defines the read-only permission level.
+	// PermissionMetadata allows listing a collection and reading its stats and index definitions,
+	// but not its documents.
+	PermissionMetadata = "metadata"
+	// PermissionRead defines the read-only permission level. It includes metadata.
 	PermissionRead = "read"
-	// PermissionWrite defines the read and write permission level.
+	// PermissionAdmin allows creating and deleting the collection and managing its indexes and
+	// compression, without access to its documents. It includes metadata.
+	PermissionAdmin = "admin"
+	// PermissionWrite defines the read and write permission level. It includes every other level.
 	PermissionWrite = "write"
 
 	// =========================================================================
@@ -47,10 +67,14 @@ const (
 	OpLessThan           = "<"
 	OpLessThanOrEqual    = "<="
 	OpLike               = "like"
+	OpRegex              = "regex"
 	OpIn                 = "in"
+	OpNotIn              = "not in"
 	OpBetween            = "between"
 	OpIsNull             = "is null"
 	OpIsNotNull          = "is not null"
+	OpArrayContains      = "contains"
+	OpArraySize          = "size"
 
 	// --- Logical Operators ---
 	OpAnd = "and"
@@ -64,10 +88,28 @@ const (
 	AggMin   = "min"
 	AggMax   = "max"
 
+	AggCountDistinct  = "count_distinct"  // Number of different non-null values
+	AggStdDev         = "stddev"          // Population standard deviation
+	AggStdDevSample   = "stddev_sample"   // Sample standard deviation
+	AggVariance       = "variance"        // Population variance
+	AggVarianceSample = "variance_sample" // Sample variance
+
 	// --- Sort Directions ---
 	SortDesc = "desc"
 	SortAsc  = "asc"
 
+	// --- Result Formats ---
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+
+	// --- Lookup Types ---
+	LookupOne  = "one"  // Attach the first matching document, or null
+	LookupMany = "many" // Always attach an array of the matching documents
+
+	// --- Relative Values ---
+	// RelativeNow marks a filter value resolved to the current time plus an offset, e.g. {"$now": "-7d"}.
+	RelativeNow = "$now"
+
 	// =========================================================================
 	// Persistence Keywords
 	// =========================================================================
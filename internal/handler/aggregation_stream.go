@@ -0,0 +1,145 @@
+package handler
+
+import "memory-tools/internal/globalconst"
+
+// aggregateAccumulator keeps the running state of one aggregation for one group.
+type aggregateAccumulator struct {
+	count    int // documents that have the field (used by count)
+	numbers  int // numeric values seen (used by sum, avg, min, max)
+	sum      float64
+	min, max float64
+}
+
+// aggregationGroup holds the accumulators of a single GROUP BY bucket.
+type aggregationGroup struct {
+	size   int
+	values []any // The GROUP BY values of the bucket.
+	aggs   map[string]*aggregateAccumulator
+}
+
+// streamingAggregator computes COUNT, SUM, AVG, MIN, and MAX incrementally, one document
+// at a time, so matching documents never have to be materialized. Its results are the
+// same as performAggregations for the functions it supports.
+type streamingAggregator struct {
+	query  *Query
+	groups map[string]*aggregationGroup
+	total  int
+}
+
+// newStreamingAggregator returns an aggregator for the query, or nil if the query needs
+// the matching documents themselves (e.g. distinct or an unsupported function).
+func newStreamingAggregator(query *Query) *streamingAggregator {
+	if query.Distinct != "" {
+		return nil
+	}
+	if len(query.Aggregations) == 0 && len(query.GroupBy) == 0 && !query.Count {
+		return nil
+	}
+	for _, agg := range query.Aggregations {
+		switch agg.Func {
+		case globalconst.AggCount, globalconst.AggSum, globalconst.AggAvg, globalconst.AggMin, globalconst.AggMax:
+		default:
+			return nil
+		}
+	}
+	return &streamingAggregator{query: query, groups: make(map[string]*aggregationGroup)}
+}
+
+// add folds a matching document into the running aggregates.
+func (s *streamingAggregator) add(doc map[string]any) {
+	s.total++
+	if len(s.query.Aggregations) == 0 && len(s.query.GroupBy) == 0 {
+		return
+	}
+
+	groupKey := "_no_group_"
+	var groupValues []any
+	if len(s.query.GroupBy) > 0 {
+		groupKey, groupValues = groupKeyOf(doc, s.query.GroupBy)
+	}
+
+	group, ok := s.groups[groupKey]
+	if !ok {
+		group = &aggregationGroup{values: groupValues, aggs: make(map[string]*aggregateAccumulator, len(s.query.Aggregations))}
+		for aggName := range s.query.Aggregations {
+			group.aggs[aggName] = &aggregateAccumulator{}
+		}
+		s.groups[groupKey] = group
+	}
+	group.size++
+
+	for aggName, agg := range s.query.Aggregations {
+		acc := group.aggs[aggName]
+		val, ok := doc[agg.Field]
+		if !ok {
+			continue
+		}
+		acc.count++
+		num, isNumber := toFloat64(val)
+		if !isNumber {
+			continue
+		}
+		if acc.numbers == 0 || num < acc.min {
+			acc.min = num
+		}
+		if acc.numbers == 0 || num > acc.max {
+			acc.max = num
+		}
+		acc.numbers++
+		acc.sum += num
+	}
+}
+
+// result builds the final rows, applying HAVING, in the same shape as the materialized path.
+func (s *streamingAggregator) result(h *ConnectionHandler) any {
+	if len(s.query.Aggregations) == 0 && len(s.query.GroupBy) == 0 {
+		return map[string]int{globalconst.AggCount: s.total}
+	}
+
+	// Without GROUP BY an empty input still yields one row, as in performAggregations.
+	if len(s.query.GroupBy) == 0 && len(s.groups) == 0 {
+		group := &aggregationGroup{aggs: make(map[string]*aggregateAccumulator, len(s.query.Aggregations))}
+		for aggName := range s.query.Aggregations {
+			group.aggs[aggName] = &aggregateAccumulator{}
+		}
+		s.groups["_no_group_"] = group
+	}
+
+	var aggregatedResults []map[string]any
+	for _, group := range s.groups {
+		resultRow := make(map[string]any)
+		for i, field := range s.query.GroupBy {
+			resultRow[field] = group.values[i]
+		}
+
+		for aggName, agg := range s.query.Aggregations {
+			acc := group.aggs[aggName]
+			if agg.Func == globalconst.AggCount {
+				if agg.Field == "*" {
+					resultRow[aggName] = group.size
+				} else {
+					resultRow[aggName] = acc.count
+				}
+				continue
+			}
+			if acc.numbers == 0 {
+				continue
+			}
+			switch agg.Func {
+			case globalconst.AggSum:
+				resultRow[aggName] = acc.sum
+			case globalconst.AggAvg:
+				resultRow[aggName] = acc.sum / float64(acc.numbers)
+			case globalconst.AggMin:
+				resultRow[aggName] = acc.min
+			case globalconst.AggMax:
+				resultRow[aggName] = acc.max
+			}
+		}
+
+		if h.matchFilter(resultRow, s.query.Having) {
+			aggregatedResults = append(aggregatedResults, resultRow)
+		}
+	}
+	return aggregatedResults
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/protocol"
+	"slices"
+	"testing"
+)
+
+// aggregationDocs returns documents with a few groups, missing fields and non-numeric values.
+func aggregationDocs() []map[string]any {
+	regions := []string{"eu", "us", "apac"}
+	docs := make([]map[string]any, 0, 60)
+	for i := range 60 {
+		doc := map[string]any{"_id": fmt.Sprintf("d%02d", i), "region": regions[i%3], "tier": float64(i % 2)}
+		switch {
+		case i%7 == 0:
+			// No amount at all.
+		case i%11 == 0:
+			doc["amount"] = "n/a"
+		default:
+			doc["amount"] = float64(i*3 - 40)
+		}
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+// canonicalRows renders aggregation results as sorted JSON rows, so results produced in a
+// different group order compare equal.
+func canonicalRows(t *testing.T, result any) []string {
+	t.Helper()
+	raw, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []map[string]any
+	if err := json.Unmarshal(raw, &rows); err != nil {
+		var single map[string]any
+		if err := json.Unmarshal(raw, &single); err != nil {
+			t.Fatalf("unexpected aggregation result %s", raw)
+		}
+		rows = []map[string]any{single}
+	}
+	canonical := make([]string, 0, len(rows))
+	for _, row := range rows {
+		b, _ := json.Marshal(row)
+		canonical = append(canonical, string(b))
+	}
+	slices.Sort(canonical)
+	return canonical
+}
+
+func TestStreamingAggregationMatchesMaterialized(t *testing.T) {
+	queries := []string{
+		`{"aggregations":{"n":{"func":"count","field":"*"},"with_amount":{"func":"count","field":"amount"},"total":{"func":"sum","field":"amount"},"mean":{"func":"avg","field":"amount"},"low":{"func":"min","field":"amount"},"high":{"func":"max","field":"amount"}}}`,
+		`{"group_by":["region"],"aggregations":{"total":{"func":"sum","field":"amount"},"low":{"func":"min","field":"amount"},"n":{"func":"count","field":"*"}}}`,
+		`{"group_by":["region","tier"],"aggregations":{"mean":{"func":"avg","field":"amount"},"high":{"func":"max","field":"amount"}}}`,
+		`{"group_by":["region"],"aggregations":{"total":{"func":"sum","field":"amount"}},"having":{"field":"total","op":">","value":200}}`,
+		`{"aggregations":{"missing":{"func":"sum","field":"nope"},"n":{"func":"count","field":"nope"}}}`,
+		`{"group_by":["tier"]}`,
+	}
+	h := newTestEnv(t).handler()
+	docs := aggregationDocs()
+
+	for _, raw := range queries {
+		var query Query
+		if err := json.Unmarshal([]byte(raw), &query); err != nil {
+			t.Fatal(err)
+		}
+		streamAgg := newStreamingAggregator(&query)
+		if streamAgg == nil {
+			t.Fatalf("query %s cannot be streamed", raw)
+		}
+		var items []struct {
+			Key string
+			Val map[string]any
+		}
+		for _, doc := range docs {
+			streamAgg.add(doc)
+			items = append(items, struct {
+				Key string
+				Val map[string]any
+			}{Key: doc["_id"].(string), Val: doc})
+		}
+		materialized, err := h.performAggregations(items, &query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, want := canonicalRows(t, streamAgg.result(h)), canonicalRows(t, materialized)
+		if !slices.Equal(got, want) {
+			t.Errorf("query %s\nstreaming:    %v\nmaterialized: %v", raw, got, want)
+		}
+	}
+}
+
+func TestStreamingAggregatorRejectsUnsupportedQueries(t *testing.T) {
+	for _, raw := range []string{
+		`{"distinct":"region"}`,
+		`{"aggregations":{"d":{"func":"count_distinct","field":"region"}}}`,
+		`{"aggregations":{"s":{"func":"stddev","field":"amount"}}}`,
+		`{"filter":{"field":"region","op":"=","value":"eu"}}`,
+	} {
+		var query Query
+		if err := json.Unmarshal([]byte(raw), &query); err != nil {
+			t.Fatal(err)
+		}
+		if newStreamingAggregator(&query) != nil {
+			t.Errorf("query %s was accepted for streaming", raw)
+		}
+	}
+}
+
+func TestAggregationOverColdDataMatchesMaterialized(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("sales")
+	docs := aggregationDocs()
+	cold := make(map[string][]byte)
+	for i, doc := range docs {
+		value, _ := json.Marshal(doc)
+		if i%2 == 0 {
+			cold[doc["_id"].(string)] = value
+		} else {
+			env.setItem("sales", doc["_id"].(string), string(value))
+		}
+	}
+	if err := (&persistence.CollectionPersisterImpl{}).WriteColdItems("sales", cold); err != nil {
+		t.Fatalf("writing cold items: %v", err)
+	}
+
+	raw := `{"group_by":["region"],"aggregations":{"total":{"func":"sum","field":"amount"},"high":{"func":"max","field":"amount"},"n":{"func":"count","field":"*"}}}`
+	resp := env.run(env.handler().handleCollectionQuery, func(w io.Writer) error {
+		return protocol.WriteCollectionQueryCommand(w, "sales", []byte(raw))
+	})
+	expectStatus(t, resp, protocol.StatusOk)
+	var streamed any
+	if err := json.Unmarshal(resp.data, &streamed); err != nil {
+		t.Fatal(err)
+	}
+
+	var query Query
+	json.Unmarshal([]byte(raw), &query)
+	var items []struct {
+		Key string
+		Val map[string]any
+	}
+	for _, doc := range docs {
+		items = append(items, struct {
+			Key string
+			Val map[string]any
+		}{Key: doc["_id"].(string), Val: doc})
+	}
+	materialized, err := env.handler().performAggregations(items, &query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := canonicalRows(t, streamed), canonicalRows(t, materialized); !slices.Equal(got, want) {
+		t.Errorf("query over hot and cold data:\n got  %v\n want %v", got, want)
+	}
+}
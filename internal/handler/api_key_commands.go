@@ -0,0 +1,371 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"memory-tools/internal/wal"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// apiKeyTokenPrefix starts every API key handed to clients, which read "mt_<id>.<secret>".
+const apiKeyTokenPrefix = "mt_"
+
+// APIKeyCreated is the response data of API_KEY_CREATE. APIKey is only ever returned here.
+type APIKeyCreated struct {
+	ID        string `json:"id"`
+	APIKey    string `json:"api_key"`
+	Username  string `json:"username"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+// APIKeyStatus is an entry of the API_KEY_LIST response.
+type APIKeyStatus struct {
+	APIKeyInfo
+	Expired bool `json:"expired"`
+}
+
+// hashAPIKeySecret returns the hex SHA-256 of an API key secret. The secrets are random, so a
+// fast hash is enough and keeps token authentication cheap, unlike bcrypt for passwords.
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseAPIKey splits an API key into its ID and secret.
+func parseAPIKey(apiKey string) (id, secret string, ok bool) {
+	rest, found := strings.CutPrefix(apiKey, apiKeyTokenPrefix)
+	if !found {
+		return "", "", false
+	}
+	id, secret, found = strings.Cut(rest, ".")
+	return id, secret, found && id != "" && secret != ""
+}
+
+// expired reports whether the key's expiry has passed. Unparseable expiries count as expired.
+func (k APIKeyInfo) expired(now time.Time) bool {
+	if k.ExpiresAt == "" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, k.ExpiresAt)
+	return err != nil || !now.Before(expiresAt)
+}
+
+// deleteUserAPIKeys removes the API keys of a user, so a new user with the same name cannot
+// inherit them, and returns how many were removed.
+func deleteUserAPIKeys(sysCol store.DataStore, username string) int {
+	removed := 0
+	for key, value := range sysCol.GetAll() {
+		if !strings.HasPrefix(key, globalconst.APIKeyPrefix) {
+			continue
+		}
+		var keyInfo APIKeyInfo
+		if err := json.Unmarshal(value, &keyInfo); err == nil && keyInfo.Username == username {
+			sysCol.Delete(key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// handleAuthenticateToken processes the CmdAuthenticateToken command.
+// It is a read-only operation and does not write to the WAL. The connection is authenticated as the
+// key's user, with the key's permissions if it has its own and the user's, roles included, otherwise.
+func (h *ConnectionHandler) handleAuthenticateToken(r io.Reader, conn net.Conn) {
+	apiKey, err := protocol.ReadAuthenticateTokenCommand(r)
+	if err != nil {
+		slog.Error("Failed to read AUTH_TOKEN command", "remote_addr", conn.RemoteAddr().String(), "error", err)
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid AUTH_TOKEN command format", nil)
+		return
+	}
+
+	keyID, secret, ok := parseAPIKey(apiKey)
+	if !ok {
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "Authentication failed: Invalid API key.", nil)
+		return
+	}
+
+	sysCol := h.CollectionManager.GetCollection(globalconst.SystemCollectionName)
+	keyDataBytes, found := sysCol.Get(globalconst.APIKeyPrefix + keyID)
+	if !found {
+		slog.Warn("Authentication failed: API key not found", "key_id", keyID, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "Authentication failed: Invalid API key.", nil)
+		return
+	}
+	var keyInfo APIKeyInfo
+	if err := json.Unmarshal(keyDataBytes, &keyInfo); err != nil {
+		slog.Error("Failed to unmarshal API key during authentication", "key_id", keyID, "remote_addr", conn.RemoteAddr().String(), "error", err)
+		protocol.WriteResponse(conn, protocol.StatusError, "Authentication failed: Internal server error.", nil)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(hashAPIKeySecret(secret)), []byte(keyInfo.SecretHash)) != 1 {
+		slog.Warn("Authentication failed: Invalid API key secret", "key_id", keyID, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "Authentication failed: Invalid API key.", nil)
+		return
+	}
+	if keyInfo.expired(time.Now()) {
+		slog.Warn("Authentication failed: API key expired", "key_id", keyID, "username", keyInfo.Username, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "Authentication failed: API key has expired.", nil)
+		return
+	}
+
+	// The user must still exist: deleting a user revokes its keys.
+	userDataBytes, found := sysCol.Get(globalconst.UserPrefix + keyInfo.Username)
+	if !found {
+		slog.Warn("Authentication failed: API key user not found", "key_id", keyID, "username", keyInfo.Username, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "Authentication failed: Invalid API key.", nil)
+		return
+	}
+	var storedUserInfo UserInfo
+	if err := json.Unmarshal(userDataBytes, &storedUserInfo); err != nil {
+		slog.Error("Failed to unmarshal user info during token authentication", "username", keyInfo.Username, "remote_addr", conn.RemoteAddr().String(), "error", err)
+		protocol.WriteResponse(conn, protocol.StatusError, "Authentication failed: Internal server error.", nil)
+		return
+	}
+	if storedUserInfo.IsRoot {
+		slog.Warn("API key login attempt for root", "key_id", keyID, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "Authentication failed: API keys cannot authenticate root.", nil)
+		return
+	}
+
+	h.IsAuthenticated = true
+	h.AuthenticatedUser = keyInfo.Username
+	h.IsRoot = false
+	if keyInfo.Permissions != nil {
+		h.grantPermissions(keyInfo.Permissions, nil)
+	} else {
+		h.grantPermissions(storedUserInfo.Permissions, storedUserInfo.Roles)
+	}
+
+	slog.Info("User authenticated with API key", "username", keyInfo.Username, "key_id", keyID, "remote_addr", conn.RemoteAddr().String(), "client_cn", h.ClientCertCN)
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Authenticated as '%s'.", keyInfo.Username), nil)
+}
+
+// handleAPIKeyCreate processes the CmdAPIKeyCreate command. Only root can create API keys.
+// The key is generated here, so the command itself is not logged to the WAL; the new record is
+// logged as an API_KEY_PUT instead, which recovery replays to restore the same key.
+func (h *ConnectionHandler) handleAPIKeyCreate(r io.Reader, conn net.Conn) {
+	username, expiresAtStr, permissionsJSON, err := protocol.ReadAPIKeyCreateCommand(r)
+	if err != nil {
+		slog.Error("Failed to read API_KEY_CREATE command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid API_KEY_CREATE command format", nil)
+		return
+	}
+	if !h.IsRoot {
+		slog.Warn("Unauthorized API key creation attempt", "user", h.AuthenticatedUser, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Only root can create API keys.", nil)
+		return
+	}
+	if reason := h.CollectionManager.ReadOnlyReason(); reason != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("ERROR: Server is in read-only mode because saving data to disk keeps failing (%v). Writes are rejected until persistence recovers.", reason), nil)
+		return
+	}
+	if username == "" {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Username cannot be empty", nil)
+		return
+	}
+
+	now := time.Now().UTC()
+	keyInfo := APIKeyInfo{Username: username, CreatedAt: now.Format(time.RFC3339)}
+	if expiresAtStr != "" {
+		expiresAt, err := time.Parse(time.RFC3339, expiresAtStr)
+		if err != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Invalid expiry '%s': use an RFC3339 time", expiresAtStr), nil)
+			return
+		}
+		if !expiresAt.After(now) {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Expiry must be in the future", nil)
+			return
+		}
+		keyInfo.ExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+	}
+	if len(permissionsJSON) > 0 {
+		if err := json.Unmarshal(permissionsJSON, &keyInfo.Permissions); err != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Invalid permissions JSON format", nil)
+			return
+		}
+	}
+
+	sysCol := h.CollectionManager.GetCollection(globalconst.SystemCollectionName)
+	userDataBytes, found := sysCol.Get(globalconst.UserPrefix + username)
+	if !found {
+		protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("User '%s' not found", username), nil)
+		return
+	}
+	var userInfo UserInfo
+	if err := json.Unmarshal(userDataBytes, &userInfo); err != nil || userInfo.IsRoot {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "API keys cannot be created for root.", nil)
+		return
+	}
+
+	idBytes := make([]byte, 8)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to generate API key", nil)
+		return
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to generate API key", nil)
+		return
+	}
+	keyInfo.ID = hex.EncodeToString(idBytes)
+	secret := hex.EncodeToString(secretBytes)
+	keyInfo.SecretHash = hashAPIKeySecret(secret)
+
+	keyBytes, err := json.Marshal(keyInfo)
+	if err != nil {
+		slog.Error("Failed to serialize new API key", "username", username, "error", err)
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to serialize API key", nil)
+		return
+	}
+	if h.Wal != nil {
+		var payload bytes.Buffer
+		protocol.WriteString(&payload, keyInfo.ID)
+		protocol.WriteBytes(&payload, keyBytes)
+		if err := h.Wal.Write(wal.WalEntry{CommandType: protocol.CmdAPIKeyPut, Payload: payload.Bytes()}); err != nil {
+			slog.Error("CRITICAL: Failed to write API key to WAL", "error", err)
+			protocol.WriteResponse(conn, protocol.StatusError, "Internal server error: could not persist command", nil)
+			return
+		}
+	}
+	sysCol.Set(globalconst.APIKeyPrefix+keyInfo.ID, keyBytes, 0)
+	h.CollectionManager.EnqueueSaveTask(globalconst.SystemCollectionName, sysCol)
+
+	slog.Info("AUDIT: API key created", "admin_user", h.AuthenticatedUser, "username", username, "key_id", keyInfo.ID, "expires_at", keyInfo.ExpiresAt)
+	responseData, _ := json.Marshal(APIKeyCreated{
+		ID:        keyInfo.ID,
+		APIKey:    apiKeyTokenPrefix + keyInfo.ID + "." + secret,
+		Username:  username,
+		ExpiresAt: keyInfo.ExpiresAt,
+	})
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: API key '%s' created for user '%s'. Store it now: it cannot be shown again.", keyInfo.ID, username), responseData)
+}
+
+// HandleAPIKeyPut processes the CmdAPIKeyPut command. It is a write operation.
+// It stores an API key record as generated by API_KEY_CREATE; only root can send it directly.
+func (h *ConnectionHandler) HandleAPIKeyPut(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	keyID, keyJSON, err := protocol.ReadAPIKeyPutCommand(r)
+	if err != nil {
+		slog.Error("Failed to read API_KEY_PUT command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid API_KEY_PUT command format", nil)
+		}
+		return
+	}
+	if conn != nil && !h.IsRoot {
+		slog.Warn("Unauthorized API key put attempt", "user", h.AuthenticatedUser, "remote_addr", remoteAddr)
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Only root can store API keys.", nil)
+		return
+	}
+
+	var keyInfo APIKeyInfo
+	if err := json.Unmarshal(keyJSON, &keyInfo); err != nil || keyInfo.ID != keyID || keyInfo.SecretHash == "" || keyInfo.Username == "" {
+		slog.Error("Invalid API key record", "key_id", keyID, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Invalid API key record", nil)
+		}
+		return
+	}
+
+	sysCol := h.CollectionManager.GetCollection(globalconst.SystemCollectionName)
+	sysCol.Set(globalconst.APIKeyPrefix+keyID, keyJSON, 0)
+	h.CollectionManager.EnqueueSaveTask(globalconst.SystemCollectionName, sysCol)
+
+	slog.Info("API key stored", "admin_user", h.AuthenticatedUser, "username", keyInfo.Username, "key_id", keyID)
+	if conn != nil {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: API key '%s' stored.", keyID), nil)
+	}
+}
+
+// HandleAPIKeyRevoke processes the CmdAPIKeyRevoke command. It is a write operation.
+// Connections already authenticated with the key keep their session.
+func (h *ConnectionHandler) HandleAPIKeyRevoke(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	keyID, err := protocol.ReadAPIKeyRevokeCommand(r)
+	if err != nil {
+		slog.Error("Failed to read API_KEY_REVOKE command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid API_KEY_REVOKE command format", nil)
+		}
+		return
+	}
+	if conn != nil && !h.IsRoot {
+		slog.Warn("Unauthorized API key revoke attempt", "user", h.AuthenticatedUser, "remote_addr", remoteAddr)
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Only root can revoke API keys.", nil)
+		return
+	}
+
+	sysCol := h.CollectionManager.GetCollection(globalconst.SystemCollectionName)
+	keyKey := globalconst.APIKeyPrefix + keyID
+	if _, found := sysCol.Get(keyKey); !found {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("API key '%s' not found", keyID), nil)
+		}
+		return
+	}
+	sysCol.Delete(keyKey)
+	h.CollectionManager.EnqueueSaveTask(globalconst.SystemCollectionName, sysCol)
+
+	slog.Info("AUDIT: API key revoked", "admin_user", h.AuthenticatedUser, "key_id", keyID)
+	if conn != nil {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: API key '%s' revoked.", keyID), nil)
+	}
+}
+
+// handleAPIKeyList processes the CmdAPIKeyList command. It is a read-only operation for root.
+// Secret hashes are left out of the listing.
+func (h *ConnectionHandler) handleAPIKeyList(r io.Reader, conn net.Conn) {
+	if !h.IsRoot {
+		slog.Warn("Unauthorized API key list attempt", "user", h.AuthenticatedUser, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Only root can list API keys.", nil)
+		return
+	}
+
+	now := time.Now()
+	keys := make([]APIKeyStatus, 0)
+	for key, value := range h.CollectionManager.GetCollection(globalconst.SystemCollectionName).GetAll() {
+		if !strings.HasPrefix(key, globalconst.APIKeyPrefix) {
+			continue
+		}
+		var keyInfo APIKeyInfo
+		if err := json.Unmarshal(value, &keyInfo); err != nil {
+			slog.Warn("Skipping unreadable API key record", "key", key, "error", err)
+			continue
+		}
+		keyInfo.SecretHash = ""
+		keys = append(keys, APIKeyStatus{APIKeyInfo: keyInfo, Expired: keyInfo.expired(now)})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Username != keys[j].Username {
+			return keys[i].Username < keys[j].Username
+		}
+		return keys[i].CreatedAt < keys[j].CreatedAt
+	})
+
+	responseData, err := json.Marshal(keys)
+	if err != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to serialize API keys", nil)
+		return
+	}
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: %d API keys.", len(keys)), responseData)
+}
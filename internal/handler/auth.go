@@ -19,12 +19,15 @@ func (h *ConnectionHandler) hasPermission(collectionName string, requiredLevel s
 		return true
 	}
 
+	// Direct grants merged with the user's roles.
+	permissions := h.resolvedPermissions()
+
 	// Get the specific permission for the collection.
-	level, specificFound := h.Permissions[collectionName]
+	granted, specificFound := permissions[collectionName]
 
 	// If not found, check for wildcard permission.
 	if !specificFound {
-		level, specificFound = h.Permissions["*"]
+		granted, specificFound = permissions["*"]
 	}
 
 	// If still no permission is found, access is denied.
@@ -32,13 +35,36 @@ func (h *ConnectionHandler) hasPermission(collectionName string, requiredLevel s
 		return false
 	}
 
-	// "write" permission implies "read" permission.
-	if requiredLevel == globalconst.PermissionRead && level == globalconst.PermissionWrite {
-		return true
-	}
+	// A grant covers every level it includes, e.g. "write" covers all of them.
+	required := levelSet(requiredLevel)
+	return required != 0 && granted&required == required
+}
+
+// permissionSet is the set of permission levels a grant covers.
+type permissionSet uint8
+
+const (
+	permMetadata permissionSet = 1 << iota
+	permRead
+	permAdmin
+	permWrite
+)
 
-	// Direct match.
-	return level == requiredLevel
+// levelSet returns the levels covered by a permission level, or 0 for an unknown level.
+// "read" and "admin" both include "metadata"; "write" includes everything, which keeps
+// grants made before the finer levels existed working unchanged.
+func levelSet(level string) permissionSet {
+	switch level {
+	case globalconst.PermissionMetadata:
+		return permMetadata
+	case globalconst.PermissionRead:
+		return permMetadata | permRead
+	case globalconst.PermissionAdmin:
+		return permMetadata | permAdmin
+	case globalconst.PermissionWrite:
+		return permMetadata | permRead | permAdmin | permWrite
+	}
+	return 0
 }
 
 // handleAuthenticate processes the CmdAuthenticate command.
@@ -89,9 +115,9 @@ func (h *ConnectionHandler) handleAuthenticate(r io.Reader, conn net.Conn) {
 	h.IsAuthenticated = true
 	h.AuthenticatedUser = username
 	h.IsRoot = storedUserInfo.IsRoot
-	h.Permissions = storedUserInfo.Permissions
+	h.grantPermissions(storedUserInfo.Permissions, storedUserInfo.Roles)
 
-	slog.Info("User authenticated successfully", "username", username, "remote_addr", conn.RemoteAddr().String())
+	slog.Info("User authenticated successfully", "username", username, "remote_addr", conn.RemoteAddr().String(), "client_cn", h.ClientCertCN)
 	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Authenticated as '%s'.", username), nil)
 }
 
@@ -0,0 +1,450 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"memory-tools/internal/wal"
+	"net"
+	"time"
+
+	stdjson "encoding/json"
+)
+
+// changeFeedWriteTimeout ends a change feed whose consumer stops reading its records.
+const changeFeedWriteTimeout = 30 * time.Second
+
+// ChangeRecord is a change read from the WAL by the change feed.
+type ChangeRecord struct {
+	Offset     string             `json:"offset"` // Position after the change's WAL entry; acknowledging it resumes the feed after the change.
+	Time       string             `json:"time,omitempty"`
+	Command    string             `json:"command"`
+	Collection string             `json:"collection,omitempty"` // Empty for a full restore or a procedure call, which may change any collection.
+	Key        string             `json:"key,omitempty"`
+	Value      stdjson.RawMessage `json:"value,omitempty"` // The document, patch or arguments of the command.
+
+	newName string // Collection created by a rename or copy, which a feed of that collection follows too.
+}
+
+// changeFeedAck is the acknowledged offset of a consumer, kept in the system collection.
+type changeFeedAck struct {
+	Offset  string `json:"offset"`
+	AckedAt string `json:"acked_at"`
+}
+
+// jsonValue returns b as a raw JSON value, or nil if it is not valid JSON.
+func jsonValue(b []byte) stdjson.RawMessage {
+	if len(b) == 0 || !stdjson.Valid(b) {
+		return nil
+	}
+	return b
+}
+
+// marshalValue encodes the arguments of a command as a record value.
+func marshalValue(v any) stdjson.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// decodeChangeRecords returns the changes a WAL entry makes to collection data, without their
+// offset. Entries of other commands, such as user management, index and settings changes, and
+// writes to the system collection, have none. Only commands that succeeded are logged: a
+// transaction's writes are logged together when it commits, before its commit entry, which has
+// no changes of its own.
+func decodeChangeRecords(entry wal.WalEntry) ([]ChangeRecord, error) {
+	r := bytes.NewReader(entry.Payload)
+	var records []ChangeRecord
+	add := func(command, collectionName, key string, value stdjson.RawMessage) {
+		records = append(records, ChangeRecord{Command: command, Collection: collectionName, Key: key, Value: value})
+	}
+
+	switch entry.CommandType {
+	case protocol.CmdCollectionCreate:
+		collectionName, err := protocol.ReadCollectionCreateCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		add("collection_create", collectionName, "", nil)
+	case protocol.CmdCollectionDelete:
+		collectionName, err := protocol.ReadCollectionDeleteCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		add("collection_delete", collectionName, "", nil)
+	case protocol.CmdCollectionRename:
+		collectionName, newName, err := protocol.ReadCollectionRenameCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		add("collection_rename", collectionName, "", marshalValue(map[string]any{"new_name": newName}))
+		records[0].newName = newName
+	case protocol.CmdCollectionCopy:
+		collectionName, newName, force, err := protocol.ReadCollectionCopyCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		add("collection_copy", collectionName, "", marshalValue(map[string]any{"new_name": newName, "force": force}))
+		records[0].newName = newName
+	case protocol.CmdCollectionTruncate:
+		collectionName, err := protocol.ReadCollectionTruncateCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		add("truncate", collectionName, "", nil)
+	case protocol.CmdCollectionItemSet:
+		collectionName, key, value, _, err := protocol.ReadCollectionItemSetCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		add("set", collectionName, key, jsonValue(value))
+	case protocol.CmdCollectionItemSetMany:
+		collectionName, value, err := protocol.ReadCollectionItemSetManyCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		items, _, _, err := decodeSetManyPayload(value)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			key, _ := item[globalconst.ID].(string)
+			add("set", collectionName, key, marshalValue(item))
+		}
+	case protocol.CmdCollectionItemDelete:
+		collectionName, key, err := protocol.ReadCollectionItemDeleteCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		add("delete", collectionName, key, nil)
+	case protocol.CmdCollectionItemGetAndDelete:
+		collectionName, key, err := protocol.ReadCollectionItemGetAndDeleteCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		add("delete", collectionName, key, nil)
+	case protocol.CmdCollectionItemDeleteMany:
+		collectionName, keys, err := protocol.ReadCollectionItemDeleteManyCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			add("delete", collectionName, key, nil)
+		}
+	case protocol.CmdCollectionItemUpdate, protocol.CmdCollectionItemUpsert:
+		collectionName, key, patch, err := protocol.ReadCollectionItemUpdateCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		command := "update"
+		if entry.CommandType == protocol.CmdCollectionItemUpsert {
+			command = "upsert"
+		}
+		add(command, collectionName, key, jsonValue(patch))
+	case protocol.CmdCollectionItemUpdateIf:
+		collectionName, key, _, patch, err := protocol.ReadCollectionItemUpdateIfCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		add("update_if", collectionName, key, jsonValue(patch))
+	case protocol.CmdCollectionItemReplace:
+		collectionName, key, value, err := protocol.ReadCollectionItemReplaceCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		add("replace", collectionName, key, jsonValue(value))
+	case protocol.CmdCollectionItemUpdateMany:
+		collectionName, value, err := protocol.ReadCollectionItemUpdateManyCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		var payloads []updateManyPayload
+		if err := json.Unmarshal(value, &payloads); err != nil {
+			return nil, err
+		}
+		for _, payload := range payloads {
+			add("update", collectionName, payload.ID, marshalValue(payload.Patch))
+		}
+	case protocol.CmdCollectionItemMergeByQuery:
+		collectionName, filter, patch, err := protocol.ReadCollectionItemMergeByQueryCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		add("merge_by_query", collectionName, "", marshalValue(map[string]any{"filter": jsonValue(filter), "patch": jsonValue(patch)}))
+	case protocol.CmdCollectionItemIncrement:
+		collectionName, key, field, delta, err := protocol.ReadCollectionItemIncrementCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		add("increment", collectionName, key, marshalValue(map[string]any{"field": field, "delta": delta}))
+	case protocol.CmdCollectionItemTouch:
+		collectionName, key, ttl, err := protocol.ReadCollectionItemTouchCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		add("touch", collectionName, key, marshalValue(map[string]any{"ttl_seconds": int64(ttl.Seconds())}))
+	case protocol.CmdRestore:
+		backupName, err := protocol.ReadRestoreCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		add("restore", "", "", marshalValue(map[string]any{"backup": backupName}))
+	case protocol.CmdRestoreCollection:
+		backupName, collectionName, err := protocol.ReadRestoreCollectionCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		add("restore_collection", collectionName, "", marshalValue(map[string]any{"backup": backupName}))
+	case protocol.CmdCallProcedure:
+		// The writes of a call depend on the procedure's definition and the data it read, so only
+		// the call itself is known.
+		name, paramsJSON, err := protocol.ReadCallProcedureCommand(r)
+		if err != nil {
+			return nil, err
+		}
+		add("call_procedure", "", "", marshalValue(map[string]any{"procedure": name, "params": jsonValue(paramsJSON)}))
+	}
+
+	kept := records[:0]
+	for _, record := range records {
+		if record.Collection == globalconst.SystemCollectionName {
+			continue
+		}
+		if !entry.Timestamp.IsZero() {
+			record.Time = entry.Timestamp.UTC().Format(time.RFC3339Nano)
+		}
+		kept = append(kept, record)
+	}
+	return kept, nil
+}
+
+// affects reports whether a change concerns a collection. A full restore or a procedure call
+// concerns them all.
+func (c *ChangeRecord) affects(collectionName string) bool {
+	return c.Collection == "" || c.Collection == collectionName || c.newName == collectionName
+}
+
+// loadChangeFeedAck returns the offset a consumer last acknowledged, if any.
+func loadChangeFeedAck(cm *store.CollectionManager, consumer string) (string, bool) {
+	data, found := cm.GetCollection(globalconst.SystemCollectionName).Get(globalconst.ChangeFeedPrefix + consumer)
+	if !found {
+		return "", false
+	}
+	var ack changeFeedAck
+	if err := json.Unmarshal(data, &ack); err != nil || ack.Offset == "" {
+		return "", false
+	}
+	return ack.Offset, true
+}
+
+// storeChangeFeedAck records the offset a consumer has processed the feed up to. It is saved with
+// the system collection, without going through the WAL: an acknowledgement lost in a crash makes
+// the consumer receive some changes again.
+func storeChangeFeedAck(cm *store.CollectionManager, consumer, offset string) error {
+	if consumer == "" {
+		return errors.New("consumer name cannot be empty")
+	}
+	if _, err := wal.ParsePosition(offset); err != nil {
+		return err
+	}
+	data, err := json.Marshal(changeFeedAck{Offset: offset, AckedAt: time.Now().UTC().Format(time.RFC3339)})
+	if err != nil {
+		return err
+	}
+	systemCollection := cm.GetCollection(globalconst.SystemCollectionName)
+	systemCollection.Set(globalconst.ChangeFeedPrefix+consumer, data, 0)
+	cm.EnqueueSaveTask(globalconst.SystemCollectionName, systemCollection)
+	return nil
+}
+
+// changeFeedStart resolves where a consumer's feed starts: after its acknowledged offset, or at the
+// start of the WAL if it has none, for an empty from; at the oldest or the next entry for
+// "earliest" and "latest"; or at the offset given.
+func (h *ConnectionHandler) changeFeedStart(consumer, from string) (wal.Position, error) {
+	var pos wal.Position
+	switch from {
+	case "":
+		offset, found := loadChangeFeedAck(h.CollectionManager, consumer)
+		if !found {
+			pos = h.Wal.Start()
+			break
+		}
+		var err error
+		if pos, err = wal.ParsePosition(offset); err != nil {
+			return wal.Position{}, err
+		}
+	case "earliest":
+		pos = h.Wal.Start()
+	case "latest":
+		pos = h.Wal.End()
+	default:
+		var err error
+		if pos, err = wal.ParsePosition(from); err != nil {
+			return wal.Position{}, err
+		}
+	}
+	return pos, h.Wal.CheckPosition(pos)
+}
+
+// readChangeFeedAcks stores the acknowledgements a consumer sends while its feed streams, which
+// get no response. It closes done once the connection closes or sends any other command, which
+// ends the feed. It only uses cm, as the handler may be back in the pool by the time it returns.
+func readChangeFeedAcks(conn net.Conn, cm *store.CollectionManager, done chan<- struct{}) {
+	defer close(done)
+	cmdType := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(conn, cmdType); err != nil {
+			return
+		}
+		if protocol.CommandType(cmdType[0]) != protocol.CmdChangeFeedAck {
+			slog.Warn("Change feed ended: the consumer sent a command other than CHANGE_FEED_ACK", "command_type", cmdType[0], "remote_addr", conn.RemoteAddr().String())
+			return
+		}
+		consumer, offset, err := protocol.ReadChangeFeedAckCommand(conn)
+		if err != nil {
+			return
+		}
+		if err := storeChangeFeedAck(cm, consumer, offset); err != nil {
+			slog.Warn("Ignoring invalid change feed acknowledgement", "consumer", consumer, "offset", offset, "error", err)
+		}
+	}
+}
+
+// handleChangeFeed processes the CmdChangeFeed command. It is root-only.
+// It streams the changes logged in the WAL from the start offset on, in order, each one a
+// StatusPartial response holding a ChangeRecord, after a first StatusPartial response giving the
+// start offset. Once it has caught up it follows new writes as they are logged. The consumer
+// acknowledges the offset it has processed with CHANGE_FEED_ACK commands, on this connection or
+// any other, so a feed started without an offset resumes after it. The feed lasts until the
+// client closes the connection or the server shuts down, or ends with StatusNotFound if the
+// entries it has to read next were removed by a checkpoint. It returns whether the feed started,
+// in which case the connection must be closed afterwards.
+func (h *ConnectionHandler) handleChangeFeed(r io.Reader, conn net.Conn) bool {
+	consumer, collectionName, from, err := protocol.ReadChangeFeedCommand(r)
+	if err != nil {
+		slog.Error("Failed to read CHANGE_FEED command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid CHANGE_FEED command format", nil)
+		return false
+	}
+	if !h.IsRoot {
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Only root can read the change feed.", nil)
+		return false
+	}
+	if consumer == "" {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Consumer name cannot be empty", nil)
+		return false
+	}
+	if h.Wal == nil {
+		protocol.WriteResponse(conn, protocol.StatusError, "ERROR: The change feed is read from the WAL, which is disabled.", nil)
+		return false
+	}
+	if h.CurrentTransactionID != "" {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Cannot read the change feed inside a transaction. Commit or roll it back first.", nil)
+		return false
+	}
+	start, err := h.changeFeedStart(consumer, from)
+	if errors.Is(err, wal.ErrPositionUnavailable) {
+		protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: %v. Resynchronize the consumer and start from 'latest'.", err), nil)
+		return false
+	}
+	if err != nil {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, err.Error(), nil)
+		return false
+	}
+
+	send := func(status protocol.ResponseStatus, msg string, data []byte) error {
+		conn.SetWriteDeadline(time.Now().Add(changeFeedWriteTimeout))
+		return protocol.WriteResponse(conn, status, msg, data)
+	}
+	if err := send(protocol.StatusPartial, fmt.Sprintf("OK: Change feed of consumer '%s' started at offset %s", consumer, start), nil); err != nil {
+		return true
+	}
+	slog.Info("Change feed started", "consumer", consumer, "collection", collectionName, "offset", start.String(), "remote_addr", conn.RemoteAddr().String())
+
+	closed := make(chan struct{})
+	go readChangeFeedAcks(conn, h.CollectionManager, closed)
+	stop := make(chan struct{})
+	go func() {
+		drainCheck := time.NewTicker(drainPollInterval)
+		defer drainCheck.Stop()
+		defer close(stop)
+		for {
+			select {
+			case <-closed:
+				return
+			case <-drainCheck.C:
+				if isDraining() {
+					return
+				}
+			}
+		}
+	}()
+
+	var sendErr error
+	err = h.Wal.Follow(start, stop, func(entry wal.WalEntry, next wal.Position) error {
+		records, err := decodeChangeRecords(entry)
+		if err != nil {
+			slog.Warn("Skipping undecodable WAL entry in change feed", "consumer", consumer, "offset", next.String(), "command_type", entry.CommandType, "error", err)
+			return nil
+		}
+		for _, record := range records {
+			if collectionName != "" && !record.affects(collectionName) {
+				continue
+			}
+			record.Offset = next.String()
+			data, err := json.Marshal(record)
+			if err != nil {
+				slog.Warn("Failed to marshal change record", "consumer", consumer, "offset", record.Offset, "error", err)
+				continue
+			}
+			if sendErr = send(protocol.StatusPartial, "", data); sendErr != nil {
+				return sendErr
+			}
+		}
+		return nil
+	})
+
+	switch {
+	case err == nil:
+		if isDraining() {
+			send(protocol.StatusOk, "OK: Change feed ended: the server is shutting down.", nil)
+		}
+		slog.Info("Change feed ended", "consumer", consumer)
+	case sendErr != nil:
+		slog.Info("Change feed ended: failed to send change", "consumer", consumer, "error", sendErr)
+	case errors.Is(err, wal.ErrPositionUnavailable):
+		slog.Warn("Change feed ended: the consumer fell behind the WAL", "consumer", consumer, "error", err)
+		send(protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: %v. Resynchronize the consumer and start from 'latest'.", err), nil)
+	default:
+		slog.Error("Change feed failed", "consumer", consumer, "error", err)
+		send(protocol.StatusError, fmt.Sprintf("ERROR: Change feed failed: %v", err), nil)
+	}
+	return true
+}
+
+// handleChangeFeedAck processes the CmdChangeFeedAck command outside a change feed. It is root-only.
+// The next feed of the consumer started without an offset resumes after the one acknowledged.
+func (h *ConnectionHandler) handleChangeFeedAck(r io.Reader, conn net.Conn) {
+	consumer, offset, err := protocol.ReadChangeFeedAckCommand(r)
+	if err != nil {
+		slog.Error("Failed to read CHANGE_FEED_ACK command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid CHANGE_FEED_ACK command format", nil)
+		return
+	}
+	if !h.IsRoot {
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Only root can acknowledge change feed offsets.", nil)
+		return
+	}
+	if err := storeChangeFeedAck(h.CollectionManager, consumer, offset); err != nil {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Consumer '%s' acknowledged offset %s.", consumer, offset), nil)
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/wal"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// walChanges returns the change records of every entry of a WAL.
+func walChanges(t *testing.T, w *wal.WAL) []ChangeRecord {
+	t.Helper()
+	end := w.End()
+	stop := make(chan struct{})
+	var records []ChangeRecord
+	err := w.Follow(w.Start(), stop, func(entry wal.WalEntry, pos wal.Position) error {
+		changes, err := decodeChangeRecords(entry)
+		if err != nil {
+			return err
+		}
+		records = append(records, changes...)
+		if pos == end {
+			close(stop)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("reading the WAL: %v", err)
+	}
+	return records
+}
+
+func TestImportBatchesAreInTheChangeFeed(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	w, err := wal.New(filepath.Join(t.TempDir(), "wal.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Close() })
+	h := env.handler()
+	h.Wal = w
+
+	var cmd bytes.Buffer
+	protocol.WriteCollectionImportCommand(&cmd, "items", 2)
+	for _, doc := range []string{`{"_id":"a"}`, `{"_id":"b"}`, `{"_id":"c"}`} {
+		protocol.WriteImportDocument(&cmd, []byte(doc))
+	}
+	protocol.WriteImportEnd(&cmd)
+	cmd.Next(1)
+	h.handleCollectionImport(&cmd, &testConn{})
+
+	records := walChanges(t, w)
+	if len(records) != 3 {
+		t.Fatalf("change records = %+v, want one per imported document", records)
+	}
+	for i, key := range []string{"a", "b", "c"} {
+		if records[i].Command != "set" || records[i].Collection != "items" || records[i].Key != key {
+			t.Errorf("record %d = %+v, want a set of '%s'", i, records[i], key)
+		}
+	}
+}
+
+func TestMergeByQueryIsInTheChangeFeed(t *testing.T) {
+	var cmd bytes.Buffer
+	protocol.WriteCollectionItemMergeByQueryCommand(&cmd, "orders", []byte(`{"field":"status","op":"=","value":"shipped"}`), []byte(`{"reviewed":true}`))
+	records, err := decodeChangeRecords(wal.WalEntry{CommandType: protocol.CmdCollectionItemMergeByQuery, Payload: cmd.Bytes()[1:]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"filter":{"field":"status","op":"=","value":"shipped"},"patch":{"reviewed":true}}`
+	if len(records) != 1 || records[0].Command != "merge_by_query" || records[0].Collection != "orders" || string(records[0].Value) != want {
+		t.Fatalf("change records = %+v, want one merge_by_query with its filter and patch", records)
+	}
+}
+
+func TestOnlyAppliedWritesAreInTheChangeFeed(t *testing.T) {
+	env := newTestEnv(t)
+	w, err := wal.New(filepath.Join(t.TempDir(), "wal.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Close() })
+	h := env.handler()
+	h.Wal = w
+	conn := env.serve(h)
+
+	set := func(key, value string) func(io.Writer) error {
+		return func(w io.Writer) error {
+			return protocol.WriteCollectionItemSetCommand(w, "items", key, []byte(value), 0)
+		}
+	}
+	expectStatus(t, send(t, conn, func(w io.Writer) error { return protocol.WriteCollectionCreateCommand(w, "items") }), protocol.StatusOk)
+	expectStatus(t, send(t, conn, set("a", `{"n":1}`)), protocol.StatusOk)
+	if resp := send(t, conn, set("a", `{"n":2}`)); resp.status == protocol.StatusOk {
+		t.Fatalf("second SET of 'a' = %+v, want it rejected", resp)
+	}
+	if resp := send(t, conn, set("b", `{"n":`)); resp.status == protocol.StatusOk {
+		t.Fatalf("SET of invalid JSON = %+v, want it rejected", resp)
+	}
+
+	expectStatus(t, send(t, conn, protocol.WriteBeginCommand), protocol.StatusOk)
+	expectStatus(t, send(t, conn, set("c", `{"n":3}`)), protocol.StatusOk)
+	expectStatus(t, send(t, conn, protocol.WriteRollbackCommand), protocol.StatusOk)
+
+	expectStatus(t, send(t, conn, protocol.WriteBeginCommand), protocol.StatusOk)
+	expectStatus(t, send(t, conn, set("d", `{"n":4}`)), protocol.StatusOk)
+	expectStatus(t, send(t, conn, protocol.WriteCommitCommand), protocol.StatusOk)
+
+	records := walChanges(t, w)
+	var got []string
+	for _, record := range records {
+		got = append(got, record.Command+" "+record.Key)
+	}
+	want := []string{"collection_create ", "set a", "set d"}
+	if !slices.Equal(got, want) {
+		t.Errorf("change records = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// clientCertLogin authenticates connections as the user named by their client certificate.
+var clientCertLogin atomic.Bool
+
+// SetClientCertLogin sets whether a connection whose verified client certificate has a common
+// name matching an existing user is authenticated as that user without an AUTH command.
+func SetClientCertLogin(enabled bool) {
+	clientCertLogin.Store(enabled)
+}
+
+// identifyClientCert completes the TLS handshake and records the common name of the client
+// certificate, if the client presented one and the server verified it. The handshake is bounded
+// by the idle timeout, as a client that never sends it is idle all the same.
+func (h *ConnectionHandler) identifyClientCert(conn net.Conn) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	if idleTimeout := time.Duration(connIdleTimeout.Load()); idleTimeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(idleTimeout))
+		defer tlsConn.SetDeadline(time.Time{})
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		return nil
+	}
+	h.ClientCertCN = state.VerifiedChains[0][0].Subject.CommonName
+	if clientCertLogin.Load() && h.ClientCertCN != "" {
+		h.loginWithClientCert(conn)
+	}
+	return nil
+}
+
+// loginWithClientCert authenticates the connection as the user named by its client certificate.
+// If there is no such user the connection stays unauthenticated and can still use AUTH.
+// Root keeps its localhost-only restriction.
+func (h *ConnectionHandler) loginWithClientCert(conn net.Conn) {
+	username := h.ClientCertCN
+	userDataBytes, found := h.CollectionManager.GetCollection(globalconst.SystemCollectionName).Get(globalconst.UserPrefix + username)
+	if !found {
+		slog.Warn("Client certificate does not match any user", "client_cn", username, "remote_addr", conn.RemoteAddr().String())
+		return
+	}
+	var storedUserInfo UserInfo
+	if err := json.Unmarshal(userDataBytes, &storedUserInfo); err != nil {
+		slog.Error("Failed to unmarshal user info during certificate authentication", "username", username, "remote_addr", conn.RemoteAddr().String(), "error", err)
+		return
+	}
+	if storedUserInfo.IsRoot && !h.IsLocalhostConn {
+		slog.Warn("Root certificate login attempt from non-localhost", "username", username, "remote_addr", conn.RemoteAddr().String())
+		return
+	}
+
+	h.IsAuthenticated = true
+	h.AuthenticatedUser = username
+	h.IsRoot = storedUserInfo.IsRoot
+	h.grantPermissions(storedUserInfo.Permissions, storedUserInfo.Roles)
+	slog.Info("User authenticated by client certificate", "username", username, "remote_addr", conn.RemoteAddr().String())
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"log/slog"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/store"
+	"sync"
+	"sync/atomic"
+)
+
+// maxTrackedColdKeys bounds the number of cold keys whose read counts are tracked at once.
+const maxTrackedColdKeys = 10000
+
+// coldPromotionThreshold is the number of cold reads after which an item is loaded back into RAM.
+// Zero disables promotion.
+var coldPromotionThreshold atomic.Int64
+
+// SetColdPromotionThreshold sets how many cold reads of the same item promote it back to hot storage.
+// A value of 0 disables promotion.
+func SetColdPromotionThreshold(threshold int) {
+	coldPromotionThreshold.Store(int64(threshold))
+}
+
+// coldReads counts reads served from disk per collection and key. When the map is full, an
+// arbitrary entry is dropped to make room, so rarely read keys cannot grow it without bound.
+var coldReads = struct {
+	mu     sync.Mutex
+	counts map[string]int
+}{counts: make(map[string]int)}
+
+// recordColdRead counts one read of a cold item and reports whether it reached the promotion threshold.
+// The key's counter is reset once it does.
+func recordColdRead(collectionName, key string) bool {
+	threshold := int(coldPromotionThreshold.Load())
+	if threshold <= 0 {
+		return false
+	}
+	trackingKey := collectionName + "\x00" + key
+
+	coldReads.mu.Lock()
+	defer coldReads.mu.Unlock()
+	count, tracked := coldReads.counts[trackingKey]
+	if !tracked && len(coldReads.counts) >= maxTrackedColdKeys {
+		for evicted := range coldReads.counts {
+			delete(coldReads.counts, evicted)
+			break
+		}
+	}
+	count++
+	if count >= threshold {
+		delete(coldReads.counts, trackingKey)
+		return true
+	}
+	coldReads.counts[trackingKey] = count
+	return false
+}
+
+// promoteColdItem loads a frequently read cold item back into the collection's in-memory store.
+// The item is re-read under the file lock so a concurrent cold update or delete is not undone.
+// It is already in the collection file, so no save is needed, and it stays hot until the next
+// eviction run finds it older than the hot/cold threshold again. An item moved to disk with a TTL
+// keeps its expiry, and is left on disk if that has passed.
+func promoteColdItem(cm *store.CollectionManager, collectionName, key string) {
+	fileLock := cm.GetFileLock(collectionName)
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	colStore := cm.GetCollection(collectionName)
+	if _, inHot := colStore.Get(key); inHot {
+		return
+	}
+	value, found, err := persistence.GetColdItem(collectionName, key)
+	if err != nil || !found {
+		return
+	}
+	if !colStore.PromoteCold(key, value) {
+		return
+	}
+	slog.Debug("Promoted cold item to hot storage", "collection", collectionName, "key", key)
+}
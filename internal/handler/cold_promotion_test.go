@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"io"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/protocol"
+	"testing"
+)
+
+func TestGetReadsAndPromotesColdItem(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	persister := &persistence.CollectionPersisterImpl{}
+	if err := persister.WriteColdItems("items", map[string][]byte{"cold": []byte(`{"_id":"cold","a":1}`)}); err != nil {
+		t.Fatalf("writing cold item: %v", err)
+	}
+	SetColdPromotionThreshold(2)
+	t.Cleanup(func() { SetColdPromotionThreshold(0) })
+	h := env.handler()
+	get := func(w io.Writer) error { return protocol.WriteCollectionItemGetCommand(w, "items", "cold", nil) }
+
+	resp := env.run(h.handleCollectionItemGet, get)
+	expectStatus(t, resp, protocol.StatusOk)
+	if string(resp.data) != `{"_id":"cold","a":1}` {
+		t.Fatalf("data = %s, want the item on disk", resp.data)
+	}
+	if _, inHot := env.cm.GetCollection("items").Get("cold"); inHot {
+		t.Fatal("item promoted before reaching the threshold")
+	}
+
+	expectStatus(t, env.run(h.handleCollectionItemGet, get), protocol.StatusOk)
+	if _, inHot := env.cm.GetCollection("items").Get("cold"); !inHot {
+		t.Fatal("item not promoted after reaching the threshold")
+	}
+}
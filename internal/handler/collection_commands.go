@@ -1,14 +1,60 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"memory-tools/internal/globalconst"
+	"memory-tools/internal/persistence"
 	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
 	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
+// DefaultCollectionListLimit is the page size used when a collection list request does not set a limit.
+const DefaultCollectionListLimit = 1000
+
+var collectionListDefaultLimit atomic.Int64
+
+func init() {
+	collectionListDefaultLimit.Store(DefaultCollectionListLimit)
+}
+
+// SetCollectionListDefaultLimit sets the page size used when a collection list request does not set a limit.
+// Non-positive values are ignored.
+func SetCollectionListDefaultLimit(limit int) {
+	if limit > 0 {
+		collectionListDefaultLimit.Store(int64(limit))
+	}
+}
+
+// coldStorageMonths mirrors the server's hot/cold threshold so reloads split data like startup does.
+var coldStorageMonths atomic.Int64
+
+// SetColdStorageMonths sets the age in months after which reloaded documents are left on disk.
+// Zero disables hot/cold storage.
+func SetColdStorageMonths(months int) {
+	coldStorageMonths.Store(int64(months))
+}
+
+// hasColdData reports whether some documents of a collection may be held only on disk, either
+// because hot/cold storage is enabled or because the memory cap evicted them.
+func hasColdData(colStore store.DataStore) bool {
+	return !persistence.HotThreshold(int(coldStorageMonths.Load())).IsZero() || colStore.ColdKeyCount() > 0
+}
+
+// isColdRecord reports whether a record read from a collection file is the live copy of a
+// document rather than a stale copy of one held in memory.
+func isColdRecord(colStore store.DataStore, key string, doc map[string]any, hotThreshold time.Time) bool {
+	return colStore.IsColdKey(key) || persistence.IsColdDocument(doc, hotThreshold)
+}
+
 // HandleCollectionCreate processes the CmdCollectionCreate command. It is a write operation.
 func (h *ConnectionHandler) HandleCollectionCreate(r io.Reader, conn net.Conn) {
 	remoteAddr := "recovery"
@@ -32,9 +78,9 @@ func (h *ConnectionHandler) HandleCollectionCreate(r io.Reader, conn net.Conn) {
 	}
 
 	if conn != nil {
-		if !h.hasPermission(collectionName, globalconst.PermissionWrite) {
+		if !h.hasPermission(collectionName, globalconst.PermissionAdmin) {
 			slog.Warn("Unauthorized collection create attempt", "user", h.AuthenticatedUser, "collection", collectionName)
-			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have write permission for collection '%s'", collectionName), nil)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have admin permission for collection '%s'", collectionName), nil)
 			return
 		}
 	}
@@ -78,9 +124,9 @@ func (h *ConnectionHandler) HandleCollectionDelete(r io.Reader, conn net.Conn) {
 	}
 
 	if conn != nil {
-		if !h.hasPermission(collectionName, globalconst.PermissionWrite) {
+		if !h.hasPermission(collectionName, globalconst.PermissionAdmin) {
 			slog.Warn("Unauthorized collection delete attempt", "user", h.AuthenticatedUser, "collection", collectionName)
-			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have write permission for collection '%s'", collectionName), nil)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have admin permission for collection '%s'", collectionName), nil)
 			return
 		}
 	}
@@ -94,6 +140,12 @@ func (h *ConnectionHandler) HandleCollectionDelete(r io.Reader, conn net.Conn) {
 
 	h.CollectionManager.DeleteCollection(collectionName)
 	h.CollectionManager.EnqueueDeleteTask(collectionName)
+	forgetCollectionOps(collectionName)
+	if h.CollectionManager.GetCollectionMeta(collectionName) != (store.CollectionMeta{}) {
+		if err := h.CollectionManager.SaveCollectionMeta(collectionName, store.CollectionMeta{}); err != nil {
+			slog.Warn("Failed to remove collection settings", "collection", collectionName, "error", err)
+		}
+	}
 
 	slog.Info("Collection deleted", "user", h.AuthenticatedUser, "collection", collectionName)
 	if conn != nil {
@@ -101,25 +153,464 @@ func (h *ConnectionHandler) HandleCollectionDelete(r io.Reader, conn net.Conn) {
 	}
 }
 
+// HandleCollectionRename processes the CmdCollectionRename command. It is a write operation.
+// The collection keeps its items, indexes and settings; it needs admin permission on both names.
+func (h *ConnectionHandler) HandleCollectionRename(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	collectionName, newName, err := protocol.ReadCollectionRenameCommand(r)
+	if err != nil {
+		slog.Error("Failed to read RENAME_COLLECTION command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid RENAME_COLLECTION command format", nil)
+		}
+		return
+	}
+	if collectionName == "" || newName == "" {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name and new name cannot be empty", nil)
+		}
+		return
+	}
+	if collectionName == globalconst.SystemCollectionName || newName == globalconst.SystemCollectionName {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "The system collection cannot be renamed or replaced", nil)
+		}
+		return
+	}
+	if filepath.Base(newName) != newName {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Invalid collection name '%s'", newName), nil)
+		}
+		return
+	}
+
+	if conn != nil {
+		for _, name := range []string{collectionName, newName} {
+			if !h.hasPermission(name, globalconst.PermissionAdmin) {
+				slog.Warn("Unauthorized collection rename attempt", "user", h.AuthenticatedUser, "collection", collectionName, "new_name", newName)
+				protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have admin permission for collection '%s'", name), nil)
+				return
+			}
+		}
+		if h.CurrentTransactionID != "" {
+			protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Collections cannot be renamed inside a transaction.", nil)
+			return
+		}
+	}
+
+	if err := h.CollectionManager.RenameCollection(collectionName, newName); err != nil {
+		if conn != nil {
+			switch {
+			case errors.Is(err, store.ErrCollectionNotFound):
+				protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist", collectionName), nil)
+			case errors.Is(err, store.ErrCollectionExists):
+				protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Collection '%s' already exists. Delete it first or choose another name.", newName), nil)
+			default:
+				slog.Error("Failed to rename collection", "collection", collectionName, "new_name", newName, "error", err)
+				protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("ERROR: Failed to rename collection: %v", err), nil)
+			}
+		}
+		return
+	}
+	forgetCollectionOps(collectionName)
+	// Saves of the old name queued before the rename are dropped, so the latest data is saved again.
+	h.CollectionManager.EnqueueSaveTask(newName, h.CollectionManager.GetCollection(newName))
+
+	slog.Info("Collection renamed", "user", h.AuthenticatedUser, "collection", collectionName, "new_name", newName)
+	if conn != nil {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Collection '%s' renamed to '%s'", collectionName, newName), nil)
+	}
+}
+
+// HandleCollectionCopy processes the CmdCollectionCopy command. It is a write operation.
+// The copy gets the source's items, indexes and settings. It needs read permission on the source
+// and admin permission on the destination, which is only replaced if empty or if force is set.
+func (h *ConnectionHandler) HandleCollectionCopy(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	collectionName, newName, force, err := protocol.ReadCollectionCopyCommand(r)
+	if err != nil {
+		slog.Error("Failed to read COPY_COLLECTION command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid COPY_COLLECTION command format", nil)
+		}
+		return
+	}
+	if collectionName == "" || newName == "" {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name and new name cannot be empty", nil)
+		}
+		return
+	}
+	if collectionName == globalconst.SystemCollectionName || newName == globalconst.SystemCollectionName {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "The system collection cannot be copied or replaced", nil)
+		}
+		return
+	}
+	if filepath.Base(newName) != newName {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Invalid collection name '%s'", newName), nil)
+		}
+		return
+	}
+
+	if conn != nil {
+		if !h.hasPermission(collectionName, globalconst.PermissionRead) {
+			slog.Warn("Unauthorized collection copy attempt", "user", h.AuthenticatedUser, "collection", collectionName, "new_name", newName)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have read permission for collection '%s'", collectionName), nil)
+			return
+		}
+		if !h.hasPermission(newName, globalconst.PermissionAdmin) {
+			slog.Warn("Unauthorized collection copy attempt", "user", h.AuthenticatedUser, "collection", collectionName, "new_name", newName)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have admin permission for collection '%s'", newName), nil)
+			return
+		}
+		if h.CurrentTransactionID != "" {
+			protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Collections cannot be copied inside a transaction.", nil)
+			return
+		}
+	}
+
+	colStore, err := h.CollectionManager.CopyCollection(collectionName, newName, force)
+	if err != nil {
+		if conn != nil {
+			switch {
+			case errors.Is(err, store.ErrCollectionNotFound):
+				protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist", collectionName), nil)
+			case errors.Is(err, store.ErrCollectionExists):
+				protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Collection '%s' already exists and is not empty. Use force to replace it.", newName), nil)
+			default:
+				slog.Error("Failed to copy collection", "collection", collectionName, "new_name", newName, "error", err)
+				protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("ERROR: Failed to copy collection: %v", err), nil)
+			}
+		}
+		return
+	}
+	h.CollectionManager.EnqueueSaveTask(newName, colStore)
+
+	slog.Info("Collection copied", "user", h.AuthenticatedUser, "collection", collectionName, "new_name", newName, "force", force)
+	if conn != nil {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Collection '%s' copied to '%s' (%d items in memory)", collectionName, newName, colStore.Size()), nil)
+	}
+}
+
+// HandleCollectionTruncate processes the CmdCollectionTruncate command. It is a write operation.
+// Every item is removed, from RAM and from the data file, while the collection keeps its indexes
+// and settings. Inside a transaction the truncation is queued and applied on commit.
+func (h *ConnectionHandler) HandleCollectionTruncate(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	collectionName, err := protocol.ReadCollectionTruncateCommand(r)
+	if err != nil {
+		slog.Error("Failed to read TRUNCATE_COLLECTION command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid TRUNCATE_COLLECTION command format", nil)
+		}
+		return
+	}
+	if collectionName == "" {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name cannot be empty", nil)
+		}
+		return
+	}
+	if collectionName == globalconst.SystemCollectionName {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "The system collection cannot be truncated", nil)
+		}
+		return
+	}
+
+	if conn != nil {
+		if !h.hasPermission(collectionName, globalconst.PermissionWrite) {
+			slog.Warn("Unauthorized collection truncate attempt", "user", h.AuthenticatedUser, "collection", collectionName)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have write permission for collection '%s'", collectionName), nil)
+			return
+		}
+	}
+
+	if !h.CollectionManager.CollectionExists(collectionName) {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist", collectionName), nil)
+		}
+		return
+	}
+	if conn != nil {
+		recordCollectionWrite(collectionName)
+	}
+
+	if h.CurrentTransactionID != "" {
+		op := store.WriteOperation{Collection: collectionName, OpType: store.OpTypeTruncate}
+		if err := h.TransactionManager.RecordWrite(h.CurrentTransactionID, op); err != nil {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Failed to record operation in transaction: "+err.Error(), nil)
+			}
+			return
+		}
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusOk, "OK: Operation queued in transaction.", nil)
+		}
+		return
+	}
+
+	colStore, removed, err := h.CollectionManager.TruncateCollection(collectionName)
+	if err != nil {
+		slog.Error("Failed to truncate collection", "collection", collectionName, "error", err)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("ERROR: Failed to truncate collection: %v", err), nil)
+		}
+		return
+	}
+	h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
+	publishChange(collectionName, changeOpTruncate, "", nil)
+
+	slog.Info("Collection truncated", "user", h.AuthenticatedUser, "collection", collectionName, "removed", removed)
+	if conn != nil {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Collection '%s' truncated, %d items removed. Its indexes were kept.", collectionName, removed), nil)
+	}
+}
+
+// HandleCollectionSetCompression processes the CmdCollectionSetCompression command. It is a write operation.
+// Compressed collections keep their values gzip-compressed in RAM, trading CPU for memory.
+func (h *ConnectionHandler) HandleCollectionSetCompression(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	collectionName, enabled, err := protocol.ReadCollectionSetCompressionCommand(r)
+	if err != nil {
+		slog.Error("Failed to read SET_COLLECTION_COMPRESSION command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid SET_COLLECTION_COMPRESSION command format", nil)
+		}
+		return
+	}
+	if collectionName == "" {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name cannot be empty", nil)
+		}
+		return
+	}
+	if collectionName == globalconst.SystemCollectionName {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Compression cannot be changed on the system collection", nil)
+		}
+		return
+	}
+
+	if conn != nil {
+		if !h.hasPermission(collectionName, globalconst.PermissionAdmin) {
+			slog.Warn("Unauthorized collection compression change attempt", "user", h.AuthenticatedUser, "collection", collectionName)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have admin permission for collection '%s'", collectionName), nil)
+			return
+		}
+	}
+
+	if !h.CollectionManager.CollectionExists(collectionName) {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist", collectionName), nil)
+		}
+		return
+	}
+
+	meta := h.CollectionManager.GetCollectionMeta(collectionName)
+	meta.CompressInMemory = enabled
+	if err := h.CollectionManager.SaveCollectionMeta(collectionName, meta); err != nil {
+		slog.Error("Failed to save collection settings", "collection", collectionName, "error", err)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusError, "Failed to save collection settings", nil)
+		}
+		return
+	}
+	h.CollectionManager.GetCollection(collectionName).SetCompression(enabled)
+
+	state := "disabled"
+	if enabled {
+		state = "enabled"
+	}
+	slog.Info("Collection compression changed", "user", h.AuthenticatedUser, "collection", collectionName, "enabled", enabled)
+	if conn != nil {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: In-memory compression %s for collection '%s'.", state, collectionName), nil)
+	}
+}
+
+// HandleCollectionSetFileCompression processes the CmdCollectionSetFileCompression command. It is a write operation.
+// It sets the codec that compresses the values of the collection's data file. The file is rewritten
+// with the new codec by the next save, which is scheduled right away.
+func (h *ConnectionHandler) HandleCollectionSetFileCompression(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	collectionName, codecName, err := protocol.ReadCollectionSetFileCompressionCommand(r)
+	if err != nil {
+		slog.Error("Failed to read SET_COLLECTION_FILE_COMPRESSION command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid SET_COLLECTION_FILE_COMPRESSION command format", nil)
+		}
+		return
+	}
+	if collectionName == "" {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name cannot be empty", nil)
+		}
+		return
+	}
+	if collectionName == globalconst.SystemCollectionName {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "File compression cannot be changed on the system collection", nil)
+		}
+		return
+	}
+	codec, err := persistence.ParseFileCodec(codecName)
+	if err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, err.Error(), nil)
+		}
+		return
+	}
+
+	if conn != nil {
+		if !h.hasPermission(collectionName, globalconst.PermissionAdmin) {
+			slog.Warn("Unauthorized collection file compression change attempt", "user", h.AuthenticatedUser, "collection", collectionName)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have admin permission for collection '%s'", collectionName), nil)
+			return
+		}
+	}
+
+	if !h.CollectionManager.CollectionExists(collectionName) {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist", collectionName), nil)
+		}
+		return
+	}
+
+	meta := h.CollectionManager.GetCollectionMeta(collectionName)
+	meta.FileCompression = ""
+	if codec != persistence.FileCodecNone {
+		meta.FileCompression = string(codec)
+	}
+	if err := h.CollectionManager.SaveCollectionMeta(collectionName, meta); err != nil {
+		slog.Error("Failed to save collection settings", "collection", collectionName, "error", err)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusError, "Failed to save collection settings", nil)
+		}
+		return
+	}
+	colStore := h.CollectionManager.GetCollection(collectionName)
+	colStore.SetFileCodec(meta.FileCompression)
+	h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
+
+	slog.Info("Collection file compression changed", "user", h.AuthenticatedUser, "collection", collectionName, "codec", codec)
+	if conn != nil {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: File compression for collection '%s' set to '%s'. The data file is being rewritten in the background.", collectionName, codec), nil)
+	}
+}
+
+// handleCollectionReload processes the CmdCollectionReload command. It is root-only.
+// The collection's in-memory data is discarded and loaded again from its file on disk,
+// which picks up out-of-band changes such as a file copied in from a backup.
+// Like a backup it only syncs memory with disk, so it is not logged to the WAL.
+func (h *ConnectionHandler) handleCollectionReload(r io.Reader, conn net.Conn) {
+	collectionName, err := protocol.ReadCollectionReloadCommand(r)
+	if err != nil {
+		slog.Error("Failed to read RELOAD_COLLECTION command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid RELOAD_COLLECTION command format", nil)
+		return
+	}
+	if collectionName == "" {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name cannot be empty", nil)
+		return
+	}
+	if !h.IsRoot {
+		slog.Warn("Unauthorized collection reload attempt", "user", h.AuthenticatedUser, "collection", collectionName)
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Only root can reload a collection from disk.", nil)
+		return
+	}
+	if h.CurrentTransactionID != "" {
+		protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Collections cannot be reloaded inside a transaction.", nil)
+		return
+	}
+	if !h.CollectionManager.CollectionExists(collectionName) {
+		protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist", collectionName), nil)
+		return
+	}
+	exists, err := persistence.CollectionFileExists(collectionName)
+	if err != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("ERROR: %v", err), nil)
+		return
+	}
+	if !exists {
+		protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' has no data file on disk", collectionName), nil)
+		return
+	}
+
+	hotThreshold := persistence.HotThreshold(int(coldStorageMonths.Load()))
+	colStore, err := h.CollectionManager.ReloadCollection(collectionName, func(col store.DataStore) error {
+		return persistence.LoadCollectionData(collectionName, col, hotThreshold)
+	})
+	if err != nil {
+		slog.Error("Failed to reload collection from disk", "collection", collectionName, "error", err)
+		protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("ERROR: Failed to reload collection '%s': %v", collectionName, err), nil)
+		return
+	}
+
+	itemCount := colStore.Size()
+	slog.Info("Collection reloaded from disk", "user", h.AuthenticatedUser, "collection", collectionName, "items", itemCount)
+	responseData, _ := json.Marshal(map[string]int{"items_loaded": itemCount})
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Collection '%s' reloaded from disk with %d items in memory.", collectionName, itemCount), responseData)
+}
+
 // handleCollectionList processes the CmdCollectionList command. It is a read-only operation.
 func (h *ConnectionHandler) handleCollectionList(r io.Reader, conn net.Conn) {
+	prefix, limit, offset, err := protocol.ReadCollectionListCommand(r)
+	if err != nil {
+		slog.Error("Failed to read LIST_COLLECTIONS command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid LIST_COLLECTIONS command format", nil)
+		return
+	}
+	pageSize := int(limit)
+	if pageSize == 0 {
+		pageSize = int(collectionListDefaultLimit.Load())
+	}
+
 	allCollectionNames := h.CollectionManager.ListCollections()
 	accessibleCollections := []string{}
 
 	for _, name := range allCollectionNames {
-		if h.hasPermission(name, globalconst.PermissionRead) {
+		if strings.HasPrefix(name, prefix) && h.hasPermission(name, globalconst.PermissionMetadata) {
 			accessibleCollections = append(accessibleCollections, name)
 		}
 	}
+	sort.Strings(accessibleCollections)
 
-	jsonNames, err := json.Marshal(accessibleCollections)
+	total := len(accessibleCollections)
+	start := min(int(offset), total)
+	end := min(start+pageSize, total)
+	page := accessibleCollections[start:end]
+
+	jsonNames, err := json.Marshal(page)
 	if err != nil {
 		slog.Error("Failed to marshal collection names to JSON", "error", err)
 		protocol.WriteResponse(conn, protocol.StatusError, "Failed to marshal collection names", nil)
 		return
 	}
 
-	if err := protocol.WriteResponse(conn, protocol.StatusOk, "OK: Accessible collections listed", jsonNames); err != nil {
+	msg := fmt.Sprintf("OK: Accessible collections listed (%d-%d of %d)", start, end, total)
+	if err := protocol.WriteResponse(conn, protocol.StatusOk, msg, jsonNames); err != nil {
 		slog.Error("Failed to write collection list response", "error", err, "remote_addr", conn.RemoteAddr().String())
 	}
 }
@@ -139,6 +630,40 @@ func (h *ConnectionHandler) HandleCollectionIndexCreate(r io.Reader, conn net.Co
 		}
 		return
 	}
+	h.createCollectionIndex(conn, collectionName, fieldName, store.IndexOptions{})
+}
+
+// HandleCollectionIndexCreateWithOptions processes the CmdCollectionIndexCreateWithOptions command.
+// It is a write operation.
+func (h *ConnectionHandler) HandleCollectionIndexCreateWithOptions(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	collectionName, fieldName, optionsJSON, err := protocol.ReadCollectionIndexCreateWithOptionsCommand(r)
+	if err != nil {
+		slog.Error("Failed to read CREATE_COLLECTION_INDEX_WITH_OPTIONS command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid CREATE_COLLECTION_INDEX_WITH_OPTIONS command format", nil)
+		}
+		return
+	}
+	var options store.IndexOptions
+	if len(optionsJSON) > 0 {
+		if err := json.Unmarshal(optionsJSON, &options); err != nil {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusBadRequest, "Invalid index options JSON format", nil)
+			}
+			return
+		}
+	}
+	h.createCollectionIndex(conn, collectionName, fieldName, options)
+}
+
+// createCollectionIndex creates an index with the given options and backfills it with the hot data.
+// Creating an index that already exists succeeds unless it was created with different options.
+func (h *ConnectionHandler) createCollectionIndex(conn net.Conn, collectionName, fieldName string, options store.IndexOptions) {
 	if collectionName == "" || fieldName == "" {
 		if conn != nil {
 			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name and field name cannot be empty", nil)
@@ -147,9 +672,9 @@ func (h *ConnectionHandler) HandleCollectionIndexCreate(r io.Reader, conn net.Co
 	}
 
 	if conn != nil {
-		if !h.hasPermission(collectionName, globalconst.PermissionWrite) {
+		if !h.hasPermission(collectionName, globalconst.PermissionAdmin) {
 			slog.Warn("Unauthorized index create attempt", "user", h.AuthenticatedUser, "collection", collectionName, "field", fieldName)
-			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have write permission for collection '%s'", collectionName), nil)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have admin permission for collection '%s'", collectionName), nil)
 			return
 		}
 	}
@@ -162,10 +687,37 @@ func (h *ConnectionHandler) HandleCollectionIndexCreate(r io.Reader, conn net.Co
 	}
 
 	colStore := h.CollectionManager.GetCollection(collectionName)
-	colStore.CreateIndex(fieldName)
+	if existing, exists := colStore.GetIndexOptions(fieldName); exists && existing != options {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Index on field '%s' already exists with different options. Delete it first to recreate it.", fieldName), nil)
+		}
+		return
+	}
+	_, existed := colStore.GetIndexOptions(fieldName)
+	colStore.CreateIndexWithOptions(fieldName, options)
+	if options.Unique && !existed && conn != nil {
+		// Once the index exists, writes are checked against it and wait for the scan of the
+		// documents already stored.
+		unlock := h.CollectionManager.LockUniqueIndexes(collectionName)
+		err := h.CollectionManager.FindUniqueViolation(collectionName, fieldName, options.CaseInsensitive)
+		if err != nil {
+			colStore.DeleteIndex(fieldName)
+		}
+		unlock()
+		if err != nil {
+			slog.Warn("Unique index creation rejected", "user", h.AuthenticatedUser, "collection", collectionName, "field", fieldName, "error", err)
+			status := protocol.StatusError
+			var violation *store.UniqueViolationError
+			if errors.As(err, &violation) {
+				status = protocol.StatusConflict
+			}
+			protocol.WriteResponse(conn, status, fmt.Sprintf("Cannot create unique index on field '%s': %v", fieldName, err), nil)
+			return
+		}
+	}
 	h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
 
-	slog.Info("Index created on collection", "user", h.AuthenticatedUser, "collection", collectionName, "field", fieldName)
+	slog.Info("Index created on collection", "user", h.AuthenticatedUser, "collection", collectionName, "field", fieldName, "case_insensitive", options.CaseInsensitive, "unique", options.Unique)
 	if conn != nil {
 		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Index creation process for field '%s' on collection '%s' completed.", fieldName, collectionName), nil)
 	}
@@ -194,9 +746,9 @@ func (h *ConnectionHandler) HandleCollectionIndexDelete(r io.Reader, conn net.Co
 	}
 
 	if conn != nil {
-		if !h.hasPermission(collectionName, globalconst.PermissionWrite) {
+		if !h.hasPermission(collectionName, globalconst.PermissionAdmin) {
 			slog.Warn("Unauthorized index delete attempt", "user", h.AuthenticatedUser, "collection", collectionName, "field", fieldName)
-			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have write permission for collection '%s'", collectionName), nil)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have admin permission for collection '%s'", collectionName), nil)
 			return
 		}
 	}
@@ -218,6 +770,80 @@ func (h *ConnectionHandler) HandleCollectionIndexDelete(r io.Reader, conn net.Co
 	}
 }
 
+// HandleCollectionIndexDisable processes the CmdCollectionIndexDisable command. It is a write operation.
+func (h *ConnectionHandler) HandleCollectionIndexDisable(r io.Reader, conn net.Conn) {
+	h.handleIndexStateChange(r, conn, true)
+}
+
+// HandleCollectionIndexEnable processes the CmdCollectionIndexEnable command. It is a write operation.
+// Re-enabling an index backfills it, so writes made while it was disabled are reflected.
+func (h *ConnectionHandler) HandleCollectionIndexEnable(r io.Reader, conn net.Conn) {
+	h.handleIndexStateChange(r, conn, false)
+}
+
+// handleIndexStateChange contains the shared logic for disabling and enabling an index.
+func (h *ConnectionHandler) handleIndexStateChange(r io.Reader, conn net.Conn, disable bool) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	cmdName, action := "ENABLE_COLLECTION_INDEX", "enable"
+	if disable {
+		cmdName, action = "DISABLE_COLLECTION_INDEX", "disable"
+	}
+
+	collectionName, fieldName, err := protocol.ReadCollectionIndexStateCommand(r)
+	if err != nil {
+		slog.Error("Failed to read "+cmdName+" command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, fmt.Sprintf("Invalid %s command format", cmdName), nil)
+		}
+		return
+	}
+	if collectionName == "" || fieldName == "" {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name and field name cannot be empty", nil)
+		}
+		return
+	}
+
+	if conn != nil {
+		if !h.hasPermission(collectionName, globalconst.PermissionAdmin) {
+			slog.Warn("Unauthorized index "+action+" attempt", "user", h.AuthenticatedUser, "collection", collectionName, "field", fieldName)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have admin permission for collection '%s'", collectionName), nil)
+			return
+		}
+	}
+
+	if !h.CollectionManager.CollectionExists(collectionName) {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist", collectionName), nil)
+		}
+		return
+	}
+
+	colStore := h.CollectionManager.GetCollection(collectionName)
+	var found bool
+	if disable {
+		found = colStore.DisableIndex(fieldName)
+	} else {
+		found = colStore.EnableIndex(fieldName)
+	}
+	if !found {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: No index on field '%s' in collection '%s'", fieldName, collectionName), nil)
+		}
+		return
+	}
+	h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
+
+	slog.Info("Index state changed on collection", "user", h.AuthenticatedUser, "collection", collectionName, "field", fieldName, "disabled", disable)
+	if conn != nil {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Index for field '%s' on collection '%s' %sd.", fieldName, collectionName, action), nil)
+	}
+}
+
 // handleCollectionIndexList processes the CmdCollectionIndexList command. It is a read-only operation.
 func (h *ConnectionHandler) handleCollectionIndexList(r io.Reader, conn net.Conn) {
 	collectionName, err := protocol.ReadCollectionIndexListCommand(r)
@@ -231,9 +857,9 @@ func (h *ConnectionHandler) handleCollectionIndexList(r io.Reader, conn net.Conn
 		return
 	}
 
-	if !h.hasPermission(collectionName, globalconst.PermissionRead) {
+	if !h.hasPermission(collectionName, globalconst.PermissionMetadata) {
 		slog.Warn("Unauthorized index list attempt", "user", h.AuthenticatedUser, "collection", collectionName)
-		protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have read permission for collection '%s'", collectionName), nil)
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have metadata permission for collection '%s'", collectionName), nil)
 		return
 	}
 
@@ -0,0 +1,208 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"slices"
+	"testing"
+)
+
+func (e *testEnv) listCollections(prefix string, limit, offset uint32) []string {
+	e.t.Helper()
+	resp := e.run(e.handler().handleCollectionList, func(w io.Writer) error {
+		return protocol.WriteCollectionListCommand(w, prefix, limit, offset)
+	})
+	expectStatus(e.t, resp, protocol.StatusOk)
+	var names []string
+	if err := json.Unmarshal(resp.data, &names); err != nil {
+		e.t.Fatal(err)
+	}
+	return names
+}
+
+func TestCollectionListPaginationAndPrefix(t *testing.T) {
+	env := newTestEnv(t)
+	for i := range 2500 {
+		env.cm.GetCollection(fmt.Sprintf("logs_%04d", i))
+	}
+	for _, name := range []string{"users", "users_archive", "orders"} {
+		env.cm.GetCollection(name)
+	}
+
+	if names := env.listCollections("logs_", 0, 0); len(names) != DefaultCollectionListLimit || names[0] != "logs_0000" {
+		t.Fatalf("default page has %d names starting with %q, want %d starting with logs_0000", len(names), names[0], DefaultCollectionListLimit)
+	}
+
+	var all []string
+	for offset := uint32(0); ; offset += 700 {
+		page := env.listCollections("logs_", 700, offset)
+		if len(page) == 0 {
+			break
+		}
+		all = append(all, page...)
+	}
+	if len(all) != 2500 {
+		t.Fatalf("paged through %d collections, want 2500", len(all))
+	}
+	for i, name := range all {
+		if want := fmt.Sprintf("logs_%04d", i); name != want {
+			t.Fatalf("name %d = %q, want %q", i, name, want)
+		}
+	}
+
+	if names := env.listCollections("users", 10, 0); len(names) != 2 || names[0] != "users" || names[1] != "users_archive" {
+		t.Errorf("users prefix = %v, want [users users_archive]", names)
+	}
+	if names := env.listCollections("logs_", 10, 5000); len(names) != 0 {
+		t.Errorf("offset past the end = %v, want an empty page", names)
+	}
+}
+
+func TestCollectionReloadPicksUpFileChanges(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("people")
+	env.setItem("people", "stale", `{"city":"Rome"}`)
+
+	// Replace the data file out of band, as when a restored copy is put in place.
+	restored := store.NewInMemStoreWithShards(4)
+	restored.CreateIndex("city")
+	restored.Set("p1", []byte(`{"_id":"p1","city":"Oslo"}`), 0)
+	restored.Set("p2", []byte(`{"_id":"p2","city":"Lima"}`), 0)
+	restored.Set("p3", []byte(`{"_id":"p3","city":"Oslo"}`), 0)
+	if err := (&persistence.CollectionPersisterImpl{}).SaveCollectionData("people", restored); err != nil {
+		t.Fatalf("writing collection file: %v", err)
+	}
+
+	resp := env.run(env.handler().handleCollectionReload, func(w io.Writer) error {
+		return protocol.WriteCollectionReloadCommand(w, "people")
+	})
+	expectStatus(t, resp, protocol.StatusOk)
+	var loaded map[string]int
+	if err := json.Unmarshal(resp.data, &loaded); err != nil || loaded["items_loaded"] != 3 {
+		t.Fatalf("response data = %s, want 3 items loaded", resp.data)
+	}
+
+	colStore := env.cm.GetCollection("people")
+	if _, found := colStore.Get("stale"); found {
+		t.Error("item missing from the file survived the reload")
+	}
+	if doc := env.storedDoc("people", "p2"); doc["city"] != "Lima" {
+		t.Errorf("p2 = %v, want the document from the file", doc)
+	}
+	keys, used := colStore.Lookup("city", "Oslo")
+	slices.Sort(keys)
+	if !used || !slices.Equal(keys, []string{"p1", "p3"}) {
+		t.Errorf("index lookup = %v (used %v), want [p1 p3] from the rebuilt index", keys, used)
+	}
+}
+
+func TestCollectionReloadRequiresRootAndAFile(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("people")
+	reload := func(w io.Writer) error { return protocol.WriteCollectionReloadCommand(w, "people") }
+
+	expectStatus(t, env.run(env.handler().handleCollectionReload, reload), protocol.StatusNotFound)
+
+	h := env.handler()
+	h.IsRoot = false
+	h.Permissions["people"] = "write"
+	expectStatus(t, env.run(h.handleCollectionReload, reload), protocol.StatusUnauthorized)
+}
+
+func truncateCommand(collectionName string) func(io.Writer) error {
+	return func(w io.Writer) error { return protocol.WriteCollectionTruncateCommand(w, collectionName) }
+}
+
+// indexedItems creates a collection with an index on "status" and a few items in it.
+func (e *testEnv) indexedItems(collectionName string, keys ...string) {
+	e.t.Helper()
+	e.createTestCollection(collectionName)
+	expectStatus(e.t, e.run(e.handler().HandleCollectionIndexCreate, func(w io.Writer) error {
+		return protocol.WriteCollectionIndexCreateCommand(w, collectionName, "status")
+	}), protocol.StatusOk)
+	for _, key := range keys {
+		e.setItem(collectionName, key, `{"_id":"`+key+`","status":"open"}`)
+	}
+}
+
+func TestTruncateKeepsCollectionAndIndexes(t *testing.T) {
+	env := newTestEnv(t)
+	// The data file is emptied by the persister, so this test keeps one on disk.
+	env.cm = store.NewCollectionManager(&persistence.CollectionPersisterImpl{}, 4)
+	env.tm = store.NewTransactionManager(env.cm)
+	env.indexedItems("items", "a", "b")
+	if err := (&persistence.CollectionPersisterImpl{}).WriteColdItems("items", map[string][]byte{"cold": []byte(`{"_id":"cold","status":"open"}`)}); err != nil {
+		t.Fatalf("writing cold item: %v", err)
+	}
+	env.cm.GetCollection("items").MarkCold("cold")
+
+	h := env.handler()
+	h.IsRoot = false
+	h.Permissions["items"] = "read"
+	expectStatus(t, env.run(h.HandleCollectionTruncate, truncateCommand("items")), protocol.StatusUnauthorized)
+
+	resp := env.run(env.handler().HandleCollectionTruncate, truncateCommand("items"))
+	expectStatus(t, resp, protocol.StatusOk)
+	if want := "OK: Collection 'items' truncated, 3 items removed. Its indexes were kept."; resp.msg != want {
+		t.Errorf("msg = %q, want %q", resp.msg, want)
+	}
+	colStore := env.cm.GetCollection("items")
+	if !env.cm.CollectionExists("items") || colStore.Size() != 0 || !colStore.HasIndex("status") {
+		t.Fatalf("after truncate: exists=%v size=%d indexed=%v", env.cm.CollectionExists("items"), colStore.Size(), colStore.HasIndex("status"))
+	}
+	if _, found, err := persistence.GetColdItem("items", "cold"); err != nil || found {
+		t.Errorf("cold item after truncate: found=%v err=%v", found, err)
+	}
+
+	env.setItem("items", "c", `{"_id":"c","status":"open"}`)
+	if got := env.queryKeys("items", `{"filter":{"field":"status","op":"=","value":"open"}}`); !slices.Equal(got, []string{"c"}) {
+		t.Errorf("indexed query after truncate = %v, want [c]", got)
+	}
+}
+
+func TestTruncateInTransaction(t *testing.T) {
+	env := newTestEnv(t)
+	env.indexedItems("items", "a", "b")
+	h := env.handler()
+	get := func(key string) protocol.ResponseStatus {
+		return env.run(h.handleCollectionItemGet, func(w io.Writer) error {
+			return protocol.WriteCollectionItemGetCommand(w, "items", key, nil)
+		}).status
+	}
+
+	expectStatus(t, env.run(h.handleBegin, protocol.WriteBeginCommand), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCollectionTruncate, truncateCommand("items")), protocol.StatusOk)
+	expectStatus(t, env.run(h.handleRollback, protocol.WriteRollbackCommand), protocol.StatusOk)
+	if size := env.cm.GetCollection("items").Size(); size != 2 {
+		t.Fatalf("rolled back truncate left %d items, want 2", size)
+	}
+
+	expectStatus(t, env.run(h.handleBegin, protocol.WriteBeginCommand), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCollectionItemSet, func(w io.Writer) error {
+		return protocol.WriteCollectionItemSetCommand(w, "items", "before", []byte(`{"_id":"before","status":"open"}`), 0)
+	}), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCollectionTruncate, truncateCommand("items")), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCollectionItemSet, func(w io.Writer) error {
+		return protocol.WriteCollectionItemSetCommand(w, "items", "after", []byte(`{"_id":"after","status":"open"}`), 0)
+	}), protocol.StatusOk)
+
+	// The transaction sees its truncation, other connections only see it once committed.
+	if get("a") != protocol.StatusNotFound || get("after") != protocol.StatusOk {
+		t.Error("the transaction does not see its own truncation")
+	}
+	if size := env.cm.GetCollection("items").Size(); size != 2 {
+		t.Fatalf("queued truncate is visible before commit: %d items", size)
+	}
+
+	expectStatus(t, env.run(h.HandleCommit, protocol.WriteCommitCommand), protocol.StatusOk)
+	colStore := env.cm.GetCollection("items")
+	if keys := colStore.Keys(); !slices.Equal(keys, []string{"after"}) || !colStore.HasIndex("status") {
+		t.Fatalf("after commit: keys=%v indexed=%v, want only the write made after the truncation", keys, colStore.HasIndex("status"))
+	}
+	if got := env.queryKeys("items", `{"filter":{"field":"status","op":"=","value":"open"}}`); !slices.Equal(got, []string{"after"}) {
+		t.Errorf("indexed query after commit = %v, want [after]", got)
+	}
+}
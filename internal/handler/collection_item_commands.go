@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"bytes"
+	stdjson "encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
@@ -111,6 +113,7 @@ func (h *ConnectionHandler) HandleCollectionItemSet(r io.Reader, conn net.Conn)
 			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist. Please create it first.", collectionName), nil)
 			return
 		}
+		recordCollectionWrite(collectionName)
 	}
 
 	var data map[string]any
@@ -122,6 +125,9 @@ func (h *ConnectionHandler) HandleCollectionItemSet(r io.Reader, conn net.Conn)
 		return
 	}
 	data[globalconst.ID] = key
+	if !h.validateDocuments(conn, collectionName, false, data) {
+		return
+	}
 
 	// Transactional logic
 	if h.CurrentTransactionID != "" {
@@ -154,9 +160,15 @@ func (h *ConnectionHandler) HandleCollectionItemSet(r io.Reader, conn net.Conn)
 	}
 
 	// Non-transactional logic
-	now := time.Now().UTC().Format(time.RFC3339)
-	data[globalconst.UPDATED_AT] = now
-	data[globalconst.CREATED_AT] = now
+	unlock, ok := h.checkUniqueIndexes(conn, collectionName, false, map[string]map[string]any{key: data})
+	if !ok {
+		return
+	}
+	defer unlock()
+	now := time.Now()
+	data[globalconst.UPDATED_AT] = now.UTC().Format(time.RFC3339)
+	store.SetCreationTime(data, now)
+	store.SetVersion(data, 1)
 
 	finalValue, err := json.Marshal(data)
 	if err != nil {
@@ -167,8 +179,12 @@ func (h *ConnectionHandler) HandleCollectionItemSet(r io.Reader, conn net.Conn)
 		return
 	}
 
+	if ttl == 0 {
+		ttl = h.CollectionManager.DefaultTTL(collectionName)
+	}
 	colStore.Set(key, finalValue, ttl)
 	h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
+	publishChange(collectionName, changeOpSet, key, finalValue)
 
 	slog.Info("Item set in collection", "user", h.AuthenticatedUser, "collection", collectionName, "key", key, "operation", "create")
 	if conn != nil {
@@ -177,13 +193,31 @@ func (h *ConnectionHandler) HandleCollectionItemSet(r io.Reader, conn net.Conn)
 }
 
 // HandleCollectionItemUpdate processes the CmdCollectionItemUpdate command. It is a write operation.
+// With an If-Match version the patch is only applied if the stored document is at that version.
 func (h *ConnectionHandler) HandleCollectionItemUpdate(r io.Reader, conn net.Conn) {
+	h.handleItemUpdate(r, conn, false)
+}
+
+// HandleCollectionItemUpsert processes the CmdCollectionItemUpsert command. It is a write operation.
+// It behaves like an update, but creates the document from the patch when the key does not exist.
+func (h *ConnectionHandler) HandleCollectionItemUpsert(r io.Reader, conn net.Conn) {
+	h.handleItemUpdate(r, conn, true)
+}
+
+// handleItemUpdate applies a merge-patch to a document (hot, cold, or in a transaction).
+// When upsert is true and the key is missing, the patch is inserted as a new document.
+func (h *ConnectionHandler) handleItemUpdate(r io.Reader, conn net.Conn, upsert bool) {
 	remoteAddr := "recovery"
 	if conn != nil {
 		remoteAddr = conn.RemoteAddr().String()
 	}
 
 	collectionName, key, patchValue, err := protocol.ReadCollectionItemUpdateCommand(r)
+	var version uint64
+	var ifMatch bool
+	if err == nil && !upsert {
+		version, ifMatch, err = protocol.ReadIfMatch(r)
+	}
 	if err != nil {
 		slog.Error("Failed to read COLLECTION_ITEM_UPDATE command payload", "error", err, "remote_addr", remoteAddr)
 		if conn != nil {
@@ -191,6 +225,10 @@ func (h *ConnectionHandler) HandleCollectionItemUpdate(r io.Reader, conn net.Con
 		}
 		return
 	}
+	if ifMatch {
+		h.updateIfMatch(conn, collectionName, key, patchValue, version)
+		return
+	}
 
 	if conn != nil {
 		if collectionName == "" || key == "" || len(patchValue) == 0 {
@@ -207,12 +245,49 @@ func (h *ConnectionHandler) HandleCollectionItemUpdate(r io.Reader, conn net.Con
 			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist.", collectionName), nil)
 			return
 		}
+		recordCollectionWrite(collectionName)
+	}
+	if !h.validatePatchValue(conn, collectionName, patchValue) {
+		return
 	}
 
 	// Transactional logic
 	if h.CurrentTransactionID != "" {
-		colStore := h.CollectionManager.GetCollection(collectionName)
-		existingValue, found := colStore.Get(key)
+		// A key written earlier in the transaction is patched as it stands there, and a key the
+		// transaction created is still created at commit.
+		pendingOp, pending, err := h.TransactionManager.PendingWrite(h.CurrentTransactionID, collectionName, key)
+		if err != nil {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Failed to read transaction: "+err.Error(), nil)
+			}
+			return
+		}
+		existingValue, found := pendingOp.Value, pendingOp.OpType != store.OpTypeDelete
+		opType := store.OpTypeUpdate
+		if !pending {
+			existingValue, found = h.CollectionManager.GetCollection(collectionName).Get(key)
+		} else if pendingOp.OpType == store.OpTypeSet {
+			opType = store.OpTypeSet
+		}
+		if !found && upsert && pending {
+			h.upsertInsert(collectionName, key, patchValue, conn)
+			return
+		}
+		if !found && upsert {
+			// An item held only on disk would be replaced by the bare patch at commit.
+			foundInCold, err := persistence.CheckColdKeyExists(collectionName, key)
+			if err != nil {
+				slog.Error("Failed to check key existence in cold storage for upsert", "collection", collectionName, "key", key, "error", err)
+				if conn != nil {
+					protocol.WriteResponse(conn, protocol.StatusError, "Internal server error during key validation.", nil)
+				}
+				return
+			}
+			if !foundInCold {
+				h.upsertInsert(collectionName, key, patchValue, conn)
+				return
+			}
+		}
 		if !found {
 			if conn != nil {
 				protocol.WriteResponse(conn, protocol.StatusNotFound, "Item not found in memory. Updates inside a transaction currently only support hot data.", nil)
@@ -233,7 +308,7 @@ func (h *ConnectionHandler) HandleCollectionItemUpdate(r io.Reader, conn net.Con
 			return
 		}
 		for k, v := range patchData {
-			if k != globalconst.ID && k != globalconst.CREATED_AT {
+			if !store.IsManagedField(k) {
 				existingData[k] = v
 			}
 		}
@@ -243,7 +318,7 @@ func (h *ConnectionHandler) HandleCollectionItemUpdate(r io.Reader, conn net.Con
 			Collection: collectionName,
 			Key:        key,
 			Value:      finalValue,
-			OpType:     store.OpTypeUpdate,
+			OpType:     opType,
 		}
 
 		if err := h.TransactionManager.RecordWrite(h.CurrentTransactionID, op); err != nil {
@@ -259,59 +334,547 @@ func (h *ConnectionHandler) HandleCollectionItemUpdate(r io.Reader, conn net.Con
 	}
 
 	// Non-transactional logic (hot/cold)
+	var patch map[string]any
+	if err := json.Unmarshal(patchValue, &patch); err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Invalid patch JSON format.", nil)
+		}
+		return
+	}
+	unlock, ok := h.checkUniqueIndexes(conn, collectionName, true, map[string]map[string]any{key: patch})
+	if !ok {
+		return
+	}
+	defer unlock()
 	colStore := h.CollectionManager.GetCollection(collectionName)
-	if existingValue, found := colStore.Get(key); found {
-		var existingData, patchData map[string]any
-		if err := json.Unmarshal(existingValue, &existingData); err != nil {
+	var updated bool
+	for {
+		// The patch is applied under the shard lock, so concurrent updates of the key never lose one another.
+		var updatedValue []byte
+		var decodeErr error
+		found, _, err := colStore.UpdateIf(key, func(current []byte) ([]byte, bool) {
+			var existingData map[string]any
+			if decodeErr = json.Unmarshal(current, &existingData); decodeErr != nil {
+				return nil, false
+			}
+			for k, v := range patch {
+				if !store.IsManagedField(k) {
+					existingData[k] = v
+				}
+			}
+			existingData[globalconst.UPDATED_AT] = time.Now().UTC().Format(time.RFC3339)
+			store.BumpVersion(existingData)
+			updatedValue, decodeErr = json.Marshal(existingData)
+			return updatedValue, decodeErr == nil
+		})
+		if err != nil {
 			if conn != nil {
-				protocol.WriteResponse(conn, protocol.StatusError, "Failed to unmarshal existing document. Cannot apply patch.", nil)
+				protocol.WriteResponse(conn, protocol.StatusError, "ERROR: "+err.Error(), nil)
 			}
 			return
 		}
-		if err := json.Unmarshal(patchValue, &patchData); err != nil {
+		if found {
+			if decodeErr != nil {
+				if conn != nil {
+					protocol.WriteResponse(conn, protocol.StatusError, "Failed to unmarshal existing document. Cannot apply patch.", nil)
+				}
+				return
+			}
+			h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
+			publishChange(collectionName, changeOpUpdate, key, updatedValue)
+			slog.Info("Item updated in collection (hot)", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
 			if conn != nil {
-				protocol.WriteResponse(conn, protocol.StatusBadRequest, "Invalid patch JSON format.", nil)
+				protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Key '%s' updated in collection '%s'", key, collectionName), updatedValue)
+			}
+			return
+		}
+
+		fileLock := h.CollectionManager.GetFileLock(collectionName)
+		fileLock.Lock()
+		updated, err = persistence.UpdateColdItem(collectionName, key, patchValue)
+		fileLock.Unlock()
+
+		if err != nil {
+			slog.Error("Failed to update cold item on disk", "collection", collectionName, "key", key, "error", err)
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusError, "Failed to update item on disk", nil)
 			}
 			return
 		}
+		if updated || !upsert {
+			break
+		}
+		if h.upsertInsert(collectionName, key, patchValue, conn) {
+			return
+		}
+		// Another write created the key since it was looked up, so the patch is applied to it.
+	}
+	if !updated {
+		slog.Warn("Item update failed: key not found", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Key '%s' not found in collection '%s'", key, collectionName), nil)
+		}
+		return
+	}
+	publishChange(collectionName, changeOpUpdate, key, nil)
+	slog.Info("Item updated in collection (cold)", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+	if conn != nil {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Cold item '%s' updated in collection '%s'", key, collectionName), nil)
+	}
+}
+
+// upsertInsert creates a new document from an upsert patch whose key does not exist yet.
+// Inside a transaction the insert is queued as a set operation. Outside one, the key is only
+// created if it is still missing; it returns false, without answering, if another write created
+// it first. Otherwise the command has been answered.
+func (h *ConnectionHandler) upsertInsert(collectionName, key string, patchValue []byte, conn net.Conn) bool {
+	var data map[string]any
+	if err := json.Unmarshal(patchValue, &data); err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Invalid patch JSON format.", nil)
+		}
+		return true
+	}
+	data[globalconst.ID] = key
+	if !h.validateDocuments(conn, collectionName, false, data) {
+		return true
+	}
+
+	if h.CurrentTransactionID != "" {
+		valueForTx, err := json.Marshal(data)
+		if err != nil {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusError, "Internal server error preparing data for transaction", nil)
+			}
+			return true
+		}
+		op := store.WriteOperation{
+			Collection: collectionName,
+			Key:        key,
+			Value:      valueForTx,
+			OpType:     store.OpTypeSet,
+		}
+		if err := h.TransactionManager.RecordWrite(h.CurrentTransactionID, op); err != nil {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Failed to record upsert in transaction: "+err.Error(), nil)
+			}
+			return true
+		}
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusOk, "OK: Upsert (insert) operation queued in transaction.", valueForTx)
+		}
+		return true
+	}
+
+	now := time.Now()
+	store.SetCreationTime(data, now)
+	data[globalconst.UPDATED_AT] = now.UTC().Format(time.RFC3339)
+	store.SetVersion(data, 1)
+	finalValue, err := json.Marshal(data)
+	if err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusError, "Failed to process value with timestamps", nil)
+		}
+		return true
+	}
+
+	colStore := h.CollectionManager.GetCollection(collectionName)
+	set, err := colStore.SetIfAbsent(key, finalValue, h.CollectionManager.DefaultTTL(collectionName))
+	if err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusError, "ERROR: "+err.Error(), nil)
+		}
+		return true
+	}
+	if !set {
+		return false
+	}
+	h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
+	publishChange(collectionName, changeOpSet, key, finalValue)
+	slog.Info("Item set in collection", "user", h.AuthenticatedUser, "collection", collectionName, "key", key, "operation", "upsert")
+	if conn != nil {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Key '%s' inserted in collection '%s' (upsert)", key, collectionName), finalValue)
+	}
+	return true
+}
+
+// ConditionalUpdateResult is the response data of a conditional update.
+type ConditionalUpdateResult struct {
+	Applied  bool               `json:"applied"`
+	Document stdjson.RawMessage `json:"document,omitempty"`
+}
+
+// HandleCollectionItemUpdateIf processes the CmdCollectionItemUpdateIf command. It is a write operation.
+// The patch is applied only if the current document matches the condition filter. For hot data the
+// check and the write happen under the shard lock; for cold data they happen under the collection file lock.
+func (h *ConnectionHandler) HandleCollectionItemUpdateIf(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	collectionName, key, conditionValue, patchValue, err := protocol.ReadCollectionItemUpdateIfCommand(r)
+	if err != nil {
+		slog.Error("Failed to read UPDATE_COLLECTION_ITEM_IF command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid UPDATE_COLLECTION_ITEM_IF command format", nil)
+		}
+		return
+	}
+
+	if conn != nil {
+		if collectionName == "" || key == "" || len(conditionValue) == 0 || len(patchValue) == 0 {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name, key, condition, or patch value cannot be empty", nil)
+			return
+		}
+		if !h.hasPermission(collectionName, globalconst.PermissionWrite) {
+			slog.Warn("Unauthorized conditional update attempt", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have write permission for collection '%s'", collectionName), nil)
+			return
+		}
+		if !h.CollectionManager.CollectionExists(collectionName) {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist.", collectionName), nil)
+			return
+		}
+		recordCollectionWrite(collectionName)
+	}
+
+	var condition, patchData map[string]any
+	if err := json.Unmarshal(conditionValue, &condition); err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Invalid condition JSON format. Must be a filter object.", nil)
+		}
+		return
+	}
+	if err := compileRegexFilters(condition); err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Invalid condition: %v", err), nil)
+		}
+		return
+	}
+	if err := json.Unmarshal(patchValue, &patchData); err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Invalid patch JSON format.", nil)
+		}
+		return
+	}
+	if !h.validateDocuments(conn, collectionName, true, patchData) {
+		return
+	}
+
+	// applyIfMatches evaluates the condition against a stored value and returns the patched value.
+	applyIfMatches := func(current []byte, touch bool) ([]byte, bool) {
+		var existingData map[string]any
+		if err := json.Unmarshal(current, &existingData); err != nil {
+			return nil, false
+		}
+		if !h.matchFilter(existingData, condition) {
+			return nil, false
+		}
 		for k, v := range patchData {
-			if k != globalconst.ID && k != globalconst.CREATED_AT {
+			if !store.IsManagedField(k) {
 				existingData[k] = v
 			}
 		}
-		existingData[globalconst.UPDATED_AT] = time.Now().UTC().Format(time.RFC3339)
-		updatedValue, _ := json.Marshal(existingData)
-		colStore.Set(key, updatedValue, 0)
-		h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
-		slog.Info("Item updated in collection (hot)", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+		if touch {
+			existingData[globalconst.UPDATED_AT] = time.Now().UTC().Format(time.RFC3339)
+			store.BumpVersion(existingData)
+		}
+		updatedValue, err := json.Marshal(existingData)
+		if err != nil {
+			return nil, false
+		}
+		return updatedValue, true
+	}
+
+	colStore := h.CollectionManager.GetCollection(collectionName)
+
+	// Transactional logic: the condition is checked now and re-checked during the prepare phase of the commit.
+	if h.CurrentTransactionID != "" {
+		existingValue, found := colStore.Get(key)
+		if !found {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusNotFound, "Item not found in memory. Updates inside a transaction currently only support hot data.", nil)
+			}
+			return
+		}
+		finalValue, matched := applyIfMatches(existingValue, false)
+		if !matched {
+			h.writeConditionalUpdateResult(conn, "OK: Condition not met; update not queued.", ConditionalUpdateResult{Applied: false})
+			return
+		}
+
+		op := store.WriteOperation{
+			Collection: collectionName,
+			Key:        key,
+			Value:      finalValue,
+			OpType:     store.OpTypeUpdate,
+			Precondition: func(current []byte) bool {
+				var currentData map[string]any
+				if err := json.Unmarshal(current, &currentData); err != nil {
+					return false
+				}
+				return h.matchFilter(currentData, condition)
+			},
+		}
+		if err := h.TransactionManager.RecordWrite(h.CurrentTransactionID, op); err != nil {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Failed to record conditional update in transaction: "+err.Error(), nil)
+			}
+			return
+		}
+		h.writeConditionalUpdateResult(conn, "OK: Conditional update queued in transaction.", ConditionalUpdateResult{Applied: true, Document: finalValue})
+		return
+	}
+
+	// Non-transactional logic (hot/cold)
+	unlock, ok := h.checkUniqueIndexes(conn, collectionName, true, map[string]map[string]any{key: patchData})
+	if !ok {
+		return
+	}
+	defer unlock()
+	var updatedValue []byte
+	found, applied, err := colStore.UpdateIf(key, func(current []byte) ([]byte, bool) {
+		var ok bool
+		updatedValue, ok = applyIfMatches(current, true)
+		return updatedValue, ok
+	})
+	if err != nil {
 		if conn != nil {
-			protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Key '%s' updated in collection '%s'", key, collectionName), updatedValue)
+			protocol.WriteResponse(conn, protocol.StatusError, "ERROR: "+err.Error(), nil)
+		}
+		return
+	}
+	if found {
+		if !applied {
+			slog.Debug("Conditional update skipped: condition not met", "collection", collectionName, "key", key)
+			h.writeConditionalUpdateResult(conn, fmt.Sprintf("OK: Condition not met; key '%s' not updated.", key), ConditionalUpdateResult{Applied: false})
+			return
 		}
+		h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
+		publishChange(collectionName, changeOpUpdate, key, updatedValue)
+		slog.Info("Item conditionally updated in collection (hot)", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+		h.writeConditionalUpdateResult(conn, fmt.Sprintf("OK: Key '%s' updated in collection '%s'", key, collectionName), ConditionalUpdateResult{Applied: true, Document: updatedValue})
 		return
 	}
 
 	fileLock := h.CollectionManager.GetFileLock(collectionName)
 	fileLock.Lock()
-	updated, err := persistence.UpdateColdItem(collectionName, key, patchValue)
+	found, applied, err = persistence.UpdateColdItemIf(collectionName, key, patchValue, func(doc map[string]any) bool {
+		return h.matchFilter(doc, condition)
+	})
 	fileLock.Unlock()
 
 	if err != nil {
-		slog.Error("Failed to update cold item on disk", "collection", collectionName, "key", key, "error", err)
+		slog.Error("Failed to conditionally update cold item on disk", "collection", collectionName, "key", key, "error", err)
 		if conn != nil {
 			protocol.WriteResponse(conn, protocol.StatusError, "Failed to update item on disk", nil)
 		}
 		return
 	}
-	if !updated {
-		slog.Warn("Item update failed: key not found", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+	if !found {
 		if conn != nil {
 			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Key '%s' not found in collection '%s'", key, collectionName), nil)
 		}
 		return
 	}
-	slog.Info("Item updated in collection (cold)", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+	if !applied {
+		h.writeConditionalUpdateResult(conn, fmt.Sprintf("OK: Condition not met; cold item '%s' not updated.", key), ConditionalUpdateResult{Applied: false})
+		return
+	}
+	publishChange(collectionName, changeOpUpdate, key, nil)
+	slog.Info("Item conditionally updated in collection (cold)", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+	h.writeConditionalUpdateResult(conn, fmt.Sprintf("OK: Cold item '%s' updated in collection '%s'", key, collectionName), ConditionalUpdateResult{Applied: true})
+}
+
+// writeConditionalUpdateResult sends the outcome of a conditional update, if there is a client connection.
+func (h *ConnectionHandler) writeConditionalUpdateResult(conn net.Conn, msg string, result ConditionalUpdateResult) {
+	if conn == nil {
+		return
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to marshal conditional update result", nil)
+		return
+	}
+	protocol.WriteResponse(conn, protocol.StatusOk, msg, data)
+}
+
+// HandleCollectionItemReplace processes the CmdCollectionItemReplace command. It is a write operation.
+// The new value replaces the whole document, keeping only its _id and creation time. The key must exist
+// and, with an If-Match version, the stored document must be at that version.
+func (h *ConnectionHandler) HandleCollectionItemReplace(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
 	if conn != nil {
-		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Cold item '%s' updated in collection '%s'", key, collectionName), nil)
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	collectionName, key, value, err := protocol.ReadCollectionItemReplaceCommand(r)
+	var version uint64
+	var ifMatch bool
+	if err == nil {
+		version, ifMatch, err = protocol.ReadIfMatch(r)
+	}
+	if err != nil {
+		slog.Error("Failed to read REPLACE_COLLECTION_ITEM command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid REPLACE_COLLECTION_ITEM command format", nil)
+		}
+		return
+	}
+	if ifMatch {
+		h.replaceIfMatch(conn, collectionName, key, value, version)
+		return
+	}
+
+	if conn != nil {
+		if collectionName == "" || key == "" || len(value) == 0 {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name, key, or value cannot be empty", nil)
+			return
+		}
+		if !h.hasPermission(collectionName, globalconst.PermissionWrite) {
+			slog.Warn("Unauthorized collection item replace attempt", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have write permission for collection '%s'", collectionName), nil)
+			return
+		}
+		if !h.CollectionManager.CollectionExists(collectionName) {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist.", collectionName), nil)
+			return
+		}
+		recordCollectionWrite(collectionName)
+	}
+
+	var newData map[string]any
+	if err := json.Unmarshal(value, &newData); err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Invalid value. Must be a JSON object.", nil)
+		}
+		return
+	}
+	if !h.validateDocuments(conn, collectionName, false, newData) {
+		return
+	}
+
+	// replaceDocument builds the replacement for a stored value, carrying over its creation time.
+	replaceDocument := func(current []byte, touch bool) ([]byte, bool) {
+		var existingData map[string]any
+		if err := json.Unmarshal(current, &existingData); err != nil {
+			return nil, false
+		}
+		replacement := make(map[string]any, len(newData)+3)
+		for k, v := range newData {
+			if !store.IsManagedField(k) && k != globalconst.UPDATED_AT {
+				replacement[k] = v
+			}
+		}
+		replacement[globalconst.ID] = key
+		store.CopyCreationTime(replacement, existingData)
+		if touch {
+			replacement[globalconst.UPDATED_AT] = time.Now().UTC().Format(time.RFC3339)
+			store.SetVersion(replacement, store.VersionOf(existingData)+1)
+		}
+		replacedValue, err := json.Marshal(replacement)
+		if err != nil {
+			return nil, false
+		}
+		return replacedValue, true
+	}
+
+	colStore := h.CollectionManager.GetCollection(collectionName)
+
+	// Transactional logic: the key must still exist when the transaction commits.
+	if h.CurrentTransactionID != "" {
+		existingValue, found := colStore.Get(key)
+		if !found {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusNotFound, "Item not found in memory. Replacements inside a transaction currently only support hot data.", nil)
+			}
+			return
+		}
+		finalValue, ok := replaceDocument(existingValue, false)
+		if !ok {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusError, "Failed to unmarshal existing document for replace.", nil)
+			}
+			return
+		}
+
+		op := store.WriteOperation{
+			Collection:   collectionName,
+			Key:          key,
+			Value:        finalValue,
+			OpType:       store.OpTypeUpdate,
+			Precondition: func(current []byte) bool { return current != nil },
+		}
+		if err := h.TransactionManager.RecordWrite(h.CurrentTransactionID, op); err != nil {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Failed to record replace in transaction: "+err.Error(), nil)
+			}
+			return
+		}
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusOk, "OK: Replace operation queued in transaction.", finalValue)
+		}
+		return
+	}
+
+	// Non-transactional logic (hot/cold)
+	unlock, ok := h.checkUniqueIndexes(conn, collectionName, false, map[string]map[string]any{key: newData})
+	if !ok {
+		return
+	}
+	defer unlock()
+	var replacedValue []byte
+	found, applied, err := colStore.UpdateIf(key, func(current []byte) ([]byte, bool) {
+		var ok bool
+		replacedValue, ok = replaceDocument(current, true)
+		return replacedValue, ok
+	})
+	if err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusError, "ERROR: "+err.Error(), nil)
+		}
+		return
+	}
+	if found {
+		if !applied {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusError, "Failed to unmarshal existing document. Cannot replace it.", nil)
+			}
+			return
+		}
+		h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
+		publishChange(collectionName, changeOpUpdate, key, replacedValue)
+		slog.Info("Item replaced in collection (hot)", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Key '%s' replaced in collection '%s'", key, collectionName), replacedValue)
+		}
+		return
+	}
+
+	fileLock := h.CollectionManager.GetFileLock(collectionName)
+	fileLock.Lock()
+	replaced, err := persistence.ReplaceColdItem(collectionName, key, value)
+	fileLock.Unlock()
+
+	if err != nil {
+		slog.Error("Failed to replace cold item on disk", "collection", collectionName, "key", key, "error", err)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusError, "Failed to replace item on disk", nil)
+		}
+		return
+	}
+	if !replaced {
+		slog.Warn("Item replace failed: key not found", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Key '%s' not found in collection '%s'", key, collectionName), nil)
+		}
+		return
+	}
+	publishChange(collectionName, changeOpUpdate, key, nil)
+	slog.Info("Item replaced in collection (cold)", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+	if conn != nil {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Cold item '%s' replaced in collection '%s'", key, collectionName), nil)
 	}
 }
 
@@ -360,6 +923,14 @@ func (h *ConnectionHandler) HandleCollectionItemUpdateMany(r io.Reader, conn net
 			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist.", collectionName), nil)
 			return
 		}
+		recordCollectionWrite(collectionName)
+		patches := make([]map[string]any, len(payloads))
+		for i, p := range payloads {
+			patches[i] = p.Patch
+		}
+		if !h.validateDocuments(conn, collectionName, true, patches...) {
+			return
+		}
 	}
 
 	// Transactional logic
@@ -376,7 +947,7 @@ func (h *ConnectionHandler) HandleCollectionItemUpdateMany(r io.Reader, conn net
 			var existingData map[string]any
 			json.Unmarshal(existingValue, &existingData)
 			for k, v := range p.Patch {
-				if k != globalconst.ID && k != globalconst.CREATED_AT {
+				if !store.IsManagedField(k) {
 					existingData[k] = v
 				}
 			}
@@ -403,41 +974,50 @@ func (h *ConnectionHandler) HandleCollectionItemUpdateMany(r io.Reader, conn net
 	}
 
 	// Non-transactional logic (hot/cold)
-	colStore := h.CollectionManager.GetCollection(collectionName)
-	var hotPayloads []updateManyPayload
-	var coldPayloads []persistence.ColdUpdatePayload
+	patches := make(map[string]map[string]any, len(payloads))
 	for _, p := range payloads {
-		if _, found := colStore.Get(p.ID); found {
-			hotPayloads = append(hotPayloads, p)
-		} else {
-			coldPayloads = append(coldPayloads, persistence.ColdUpdatePayload{ID: p.ID, Patch: p.Patch})
-		}
+		patches[p.ID] = p.Patch
+	}
+	unlock, ok := h.checkUniqueIndexes(conn, collectionName, true, patches)
+	if !ok {
+		return
 	}
-	slog.Debug("Split update-many batch", "hot_count", len(hotPayloads), "cold_count", len(coldPayloads))
+	defer unlock()
+	colStore := h.CollectionManager.GetCollection(collectionName)
+	var coldPayloads []persistence.ColdUpdatePayload
 	updatedHotCount := 0
 	var failedHotKeys []string
 	now := time.Now().UTC().Format(time.RFC3339)
-	for _, p := range hotPayloads {
-		existingValue, _ := colStore.Get(p.ID)
-		var existingData map[string]any
-		if err := json.Unmarshal(existingValue, &existingData); err != nil {
-			failedHotKeys = append(failedHotKeys, p.ID)
-			continue
-		}
-		for k, v := range p.Patch {
-			if k != globalconst.ID && k != globalconst.CREATED_AT {
-				existingData[k] = v
+	for _, p := range payloads {
+		// Each patch is applied under the shard lock, as for a single update; keys not in memory are cold.
+		var updatedValue []byte
+		found, applied, err := colStore.UpdateIf(p.ID, func(current []byte) ([]byte, bool) {
+			var existingData map[string]any
+			if err := json.Unmarshal(current, &existingData); err != nil {
+				return nil, false
 			}
-		}
-		existingData[globalconst.UPDATED_AT] = now
-		updatedValue, err := json.Marshal(existingData)
-		if err != nil {
+			for k, v := range p.Patch {
+				if !store.IsManagedField(k) {
+					existingData[k] = v
+				}
+			}
+			existingData[globalconst.UPDATED_AT] = now
+			store.BumpVersion(existingData)
+			var marshalErr error
+			updatedValue, marshalErr = json.Marshal(existingData)
+			return updatedValue, marshalErr == nil
+		})
+		switch {
+		case err != nil || (found && !applied):
 			failedHotKeys = append(failedHotKeys, p.ID)
-			continue
+		case found:
+			publishChange(collectionName, changeOpUpdate, p.ID, updatedValue)
+			updatedHotCount++
+		default:
+			coldPayloads = append(coldPayloads, persistence.ColdUpdatePayload{ID: p.ID, Patch: p.Patch})
 		}
-		colStore.Set(p.ID, updatedValue, 0)
-		updatedHotCount++
 	}
+	slog.Debug("Split update-many batch", "hot_count", len(payloads)-len(coldPayloads), "cold_count", len(coldPayloads))
 	if updatedHotCount > 0 {
 		h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
 	}
@@ -465,20 +1045,78 @@ func (h *ConnectionHandler) HandleCollectionItemUpdateMany(r io.Reader, conn net
 		if len(failedHotKeys) > 0 {
 			responseData, _ = json.Marshal(map[string][]string{"failed_hot_keys": failedHotKeys})
 		}
-		protocol.WriteResponse(conn, protocol.StatusOk, summary, responseData)
+		protocol.WriteResponse(conn, protocol.StatusOk, summary, responseData)
+	}
+}
+
+// handleCollectionItemGet processes the CmdCollectionItemGet command. It is a read-only operation.
+// Inside a transaction it returns the transaction's own queued writes over the committed data.
+func (h *ConnectionHandler) handleCollectionItemGet(r io.Reader, conn net.Conn) {
+	collectionName, key, fields, err := protocol.ReadCollectionItemGetCommand(r)
+	if err != nil {
+		slog.Error("Failed to read GET_ITEM command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid COLLECTION_ITEM_GET command format", nil)
+		return
+	}
+	if collectionName == "" || key == "" {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name or key cannot be empty", nil)
+		return
+	}
+	if !h.hasPermission(collectionName, globalconst.PermissionRead) {
+		slog.Warn("Unauthorized collection item get attempt", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have read permission for collection '%s'", collectionName), nil)
+		return
+	}
+	if h.CollectionManager.CollectionExists(collectionName) {
+		recordCollectionRead(collectionName)
+	}
+	if len(fields) > 0 {
+		h.handleMaskedItemGet(conn, collectionName, key, fields)
+		return
+	}
+	value, found, pending, err := h.pendingValue(collectionName, key)
+	if err != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Failed to read transaction: "+err.Error(), nil)
+		return
+	}
+	if !pending {
+		value, found = h.CollectionManager.GetCollection(collectionName).Get(key)
+	}
+	if !found && !pending {
+		value, found, err = persistence.GetColdItem(collectionName, key)
+		if err != nil {
+			slog.Error("Failed to read cold item", "collection", collectionName, "key", key, "error", err)
+			protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Failed to read document: "+err.Error(), nil)
+			return
+		}
+		if found && recordColdRead(collectionName, key) {
+			promoteColdItem(h.CollectionManager, collectionName, key)
+		}
+	}
+	slog.Debug("Get item from collection", "user", h.AuthenticatedUser, "collection", collectionName, "key", key, "found", found)
+	if found {
+		if collectionName == globalconst.SystemCollectionName && strings.HasPrefix(key, globalconst.UserPrefix) {
+			var userInfo UserInfo
+			if err := json.Unmarshal(value, &userInfo); err == nil {
+				sanitizedBytes, _ := json.Marshal(sanitizeUserInfo(userInfo))
+				protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Key '%s' retrieved from collection '%s' (sanitized)", key, collectionName), sanitizedBytes)
+				return
+			}
+		}
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Key '%s' retrieved from collection '%s'", key, collectionName), value)
+	} else {
+		protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Key '%s' not found or expired in collection '%s'", key, collectionName), nil)
 	}
 }
 
-// handleCollectionItemGet processes the CmdCollectionItemGet command. It is a read-only operation.
-func (h *ConnectionHandler) handleCollectionItemGet(r io.Reader, conn net.Conn) {
-	if h.CurrentTransactionID != "" {
-		protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Read operations like GET are not supported inside a transaction in this version.", nil)
-		return
-	}
-	collectionName, key, err := protocol.ReadCollectionItemGetCommand(r)
+// handleCollectionItemExists processes the CmdCollectionItemExists command.
+// It answers whether a key exists, in RAM or in the collection's file, without sending the document.
+// Inside a transaction the transaction's own queued sets and deletes are taken into account.
+func (h *ConnectionHandler) handleCollectionItemExists(r io.Reader, conn net.Conn) {
+	collectionName, key, err := protocol.ReadCollectionItemExistsCommand(r)
 	if err != nil {
-		slog.Error("Failed to read GET_ITEM command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
-		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid COLLECTION_ITEM_GET command format", nil)
+		slog.Error("Failed to read COLLECTION_ITEM_EXISTS command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid COLLECTION_ITEM_EXISTS command format", nil)
 		return
 	}
 	if collectionName == "" || key == "" {
@@ -486,30 +1124,81 @@ func (h *ConnectionHandler) handleCollectionItemGet(r io.Reader, conn net.Conn)
 		return
 	}
 	if !h.hasPermission(collectionName, globalconst.PermissionRead) {
-		slog.Warn("Unauthorized collection item get attempt", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+		slog.Warn("Unauthorized collection item exists attempt", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
 		protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have read permission for collection '%s'", collectionName), nil)
 		return
 	}
-	colStore := h.CollectionManager.GetCollection(collectionName)
-	value, found := colStore.Get(key)
-	slog.Debug("Get item from collection", "user", h.AuthenticatedUser, "collection", collectionName, "key", key, "found", found)
-	if found {
-		if collectionName == globalconst.SystemCollectionName && strings.HasPrefix(key, globalconst.UserPrefix) {
-			var userInfo UserInfo
-			if err := json.Unmarshal(value, &userInfo); err == nil {
-				sanitizedInfo := map[string]any{
-					"username":    userInfo.Username,
-					"is_root":     userInfo.IsRoot,
-					"permissions": userInfo.Permissions,
-				}
-				sanitizedBytes, _ := json.Marshal(sanitizedInfo)
-				protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Key '%s' retrieved from collection '%s' (sanitized)", key, collectionName), sanitizedBytes)
-				return
-			}
+	if h.CollectionManager.CollectionExists(collectionName) {
+		recordCollectionRead(collectionName)
+	}
+
+	_, exists, pending, err := h.pendingValue(collectionName, key)
+	if err != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Failed to read transaction: "+err.Error(), nil)
+		return
+	}
+	if !pending {
+		_, exists = h.CollectionManager.GetCollection(collectionName).Get(key)
+	}
+	if !exists && !pending {
+		// The key scan skips values; a hit is confirmed with a read so tombstoned items don't count.
+		foundInCold, err := persistence.CheckColdKeyExists(collectionName, key)
+		if err == nil && foundInCold {
+			_, exists, err = persistence.GetColdItem(collectionName, key)
 		}
-		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Key '%s' retrieved from collection '%s'", key, collectionName), value)
+		if err != nil {
+			slog.Error("Failed to check key existence in cold storage", "collection", collectionName, "key", key, "error", err)
+			protocol.WriteResponse(conn, protocol.StatusError, "Internal server error during key existence check.", nil)
+			return
+		}
+	}
+
+	responseData, _ := json.Marshal(map[string]bool{"exists": exists})
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Key '%s' exists in collection '%s': %t", key, collectionName, exists), responseData)
+}
+
+// handleMaskedItemGet answers a GET carrying a field mask with only the requested paths of the document.
+// The document is looked up in RAM first and then in the collection's file, so cold items can be masked too.
+func (h *ConnectionHandler) handleMaskedItemGet(conn net.Conn, collectionName, key string, fields []string) {
+	var doc map[string]any
+	if collectionName == globalconst.SystemCollectionName && strings.HasPrefix(key, globalconst.UserPrefix) {
+		value, found := h.CollectionManager.GetCollection(collectionName).Get(key)
+		var userInfo UserInfo
+		if !found || json.Unmarshal(value, &userInfo) != nil {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Key '%s' not found or expired in collection '%s'", key, collectionName), nil)
+			return
+		}
+		doc = sanitizeUserInfo(userInfo)
 	} else {
-		protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Key '%s' not found or expired in collection '%s'", key, collectionName), nil)
+		var found bool
+		var err error
+		doc, found, err = h.fetchDocument(collectionName, key)
+		if err != nil {
+			slog.Error("Failed to fetch document for masked get", "collection", collectionName, "key", key, "error", err)
+			protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Failed to read document: "+err.Error(), nil)
+			return
+		}
+		if !found {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Key '%s' not found or expired in collection '%s'", key, collectionName), nil)
+			return
+		}
+	}
+	slog.Debug("Masked get item from collection", "user", h.AuthenticatedUser, "collection", collectionName, "key", key, "fields", len(fields))
+	responseData, err := json.Marshal(projectFields(doc, fields))
+	if err != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to marshal masked document", nil)
+		return
+	}
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: %d field(s) of key '%s' retrieved from collection '%s'", len(fields), key, collectionName), responseData)
+}
+
+// sanitizeUserInfo returns the fields of a user record that are safe to expose, omitting the password hash.
+func sanitizeUserInfo(userInfo UserInfo) map[string]any {
+	return map[string]any{
+		"username":    userInfo.Username,
+		"is_root":     userInfo.IsRoot,
+		"permissions": userInfo.Permissions,
+		"roles":       userInfo.Roles,
 	}
 }
 
@@ -544,6 +1233,7 @@ func (h *ConnectionHandler) HandleCollectionItemDelete(r io.Reader, conn net.Con
 			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist.", collectionName), nil)
 			return
 		}
+		recordCollectionWrite(collectionName)
 	}
 
 	// Transactional logic
@@ -571,6 +1261,7 @@ func (h *ConnectionHandler) HandleCollectionItemDelete(r io.Reader, conn net.Con
 	if _, foundInRam := colStore.Get(key); foundInRam {
 		colStore.Delete(key)
 		h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
+		publishChange(collectionName, changeOpDelete, key, nil)
 		slog.Info("Item deleted from collection (hot)", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
 		if conn != nil {
 			protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Key '%s' deleted from collection '%s'", key, collectionName), nil)
@@ -596,18 +1287,140 @@ func (h *ConnectionHandler) HandleCollectionItemDelete(r io.Reader, conn net.Con
 		}
 		return
 	}
+	publishChange(collectionName, changeOpDelete, key, nil)
 	slog.Info("Item marked for deletion in collection (cold)", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
 	if conn != nil {
 		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Key '%s' marked for deletion from collection '%s'", key, collectionName), nil)
 	}
 }
 
-// handleCollectionItemList processes the CmdCollectionItemList command. It is a read-only operation.
-func (h *ConnectionHandler) handleCollectionItemList(r io.Reader, conn net.Conn) {
+// HandleCollectionItemGetAndDelete processes the CmdCollectionItemGetAndDelete command. It is a write operation.
+// The item is returned and removed in one atomic step, so two consumers popping the same key
+// can never both receive it. It needs both read and write permission on the collection.
+func (h *ConnectionHandler) HandleCollectionItemGetAndDelete(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	collectionName, key, err := protocol.ReadCollectionItemGetAndDeleteCommand(r)
+	if err != nil {
+		slog.Error("Failed to read GET_AND_DELETE_ITEM command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid COLLECTION_ITEM_GET_AND_DELETE command format", nil)
+		}
+		return
+	}
+
+	if conn != nil {
+		if collectionName == "" || key == "" {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name or key cannot be empty", nil)
+			return
+		}
+		if !h.hasPermission(collectionName, globalconst.PermissionRead) || !h.hasPermission(collectionName, globalconst.PermissionWrite) {
+			slog.Warn("Unauthorized collection item get-and-delete attempt", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You need read and write permission for collection '%s'", collectionName), nil)
+			return
+		}
+		if !h.CollectionManager.CollectionExists(collectionName) {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist.", collectionName), nil)
+			return
+		}
+		recordCollectionRead(collectionName)
+		recordCollectionWrite(collectionName)
+	}
+
+	colStore := h.CollectionManager.GetCollection(collectionName)
+
+	// Transactional logic: the delete is queued with a precondition that the item still holds
+	// the returned value, so only one of several transactions taking the same item can commit.
 	if h.CurrentTransactionID != "" {
-		protocol.WriteResponse(conn, protocol.StatusError, "ERROR: LIST command is not allowed inside a transaction in this version.", nil)
+		value, found := colStore.Get(key)
+		if !found {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusNotFound, "Item not found in memory. Get-and-delete inside a transaction currently only supports hot data.", nil)
+			}
+			return
+		}
+		op := store.WriteOperation{
+			Collection: collectionName,
+			Key:        key,
+			OpType:     store.OpTypeDelete,
+			Precondition: func(current []byte) bool {
+				return current != nil && bytes.Equal(current, value)
+			},
+		}
+		if err := h.TransactionManager.RecordWrite(h.CurrentTransactionID, op); err != nil {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Failed to record get-and-delete in transaction: "+err.Error(), nil)
+			}
+			return
+		}
+		h.writeTakenItem(conn, collectionName, key, value, "OK: Key '%s' retrieved from collection '%s'; delete queued in transaction.")
 		return
 	}
+
+	// Non-transactional logic (hot/cold)
+	value, found, err := colStore.GetAndDelete(key)
+	if err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusError, "ERROR: "+err.Error(), nil)
+		}
+		return
+	}
+	if found {
+		h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
+		publishChange(collectionName, changeOpDelete, key, nil)
+		slog.Info("Item taken from collection (hot)", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+		h.writeTakenItem(conn, collectionName, key, value, "OK: Key '%s' retrieved and deleted from collection '%s'")
+		return
+	}
+
+	if hasColdData(colStore) {
+		hotThreshold := persistence.HotThreshold(int(coldStorageMonths.Load()))
+		fileLock := h.CollectionManager.GetFileLock(collectionName)
+		fileLock.Lock()
+		value, found, err = persistence.TakeColdItem(collectionName, key, func(doc map[string]any) bool {
+			return isColdRecord(colStore, key, doc, hotThreshold)
+		})
+		fileLock.Unlock()
+
+		if err != nil {
+			slog.Error("Failed to take item from disk", "collection", collectionName, "key", key, "error", err)
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusError, "Failed to perform get-and-delete operation on disk", nil)
+			}
+			return
+		}
+	}
+	if !found {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Key '%s' not found or expired in collection '%s'", key, collectionName), nil)
+		}
+		return
+	}
+	publishChange(collectionName, changeOpDelete, key, nil)
+	slog.Info("Item taken from collection (cold)", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+	h.writeTakenItem(conn, collectionName, key, value, "OK: Key '%s' retrieved and marked for deletion from collection '%s'")
+}
+
+// writeTakenItem answers a get-and-delete with the removed document, sanitizing user records like GET does.
+func (h *ConnectionHandler) writeTakenItem(conn net.Conn, collectionName, key string, value []byte, msgFormat string) {
+	if conn == nil {
+		return
+	}
+	if collectionName == globalconst.SystemCollectionName && strings.HasPrefix(key, globalconst.UserPrefix) {
+		var userInfo UserInfo
+		if err := json.Unmarshal(value, &userInfo); err == nil {
+			value, _ = json.Marshal(sanitizeUserInfo(userInfo))
+		}
+	}
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf(msgFormat, key, collectionName), value)
+}
+
+// handleCollectionItemList processes the CmdCollectionItemList command. It is a read-only operation.
+// Inside a transaction the listing includes the transaction's own queued writes.
+func (h *ConnectionHandler) handleCollectionItemList(r io.Reader, conn net.Conn) {
 	collectionName, err := protocol.ReadCollectionItemListCommand(r)
 	if err != nil {
 		slog.Error("Failed to read LIST_ITEMS command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
@@ -632,8 +1445,13 @@ func (h *ConnectionHandler) handleCollectionItemList(r io.Reader, conn net.Conn)
 		protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist for listing items", collectionName), nil)
 		return
 	}
+	recordCollectionRead(collectionName)
 	colStore := h.CollectionManager.GetCollection(collectionName)
 	allData := colStore.GetAll()
+	if err := h.overlayPendingWrites(collectionName, allData); err != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Failed to read transaction: "+err.Error(), nil)
+		return
+	}
 	if collectionName == globalconst.SystemCollectionName {
 		sanitizedData := make(map[string]map[string]any)
 		for key, val := range allData {
@@ -644,8 +1462,14 @@ func (h *ConnectionHandler) handleCollectionItemList(r io.Reader, conn net.Conn)
 						"username":    userInfo.Username,
 						"is_root":     userInfo.IsRoot,
 						"permissions": userInfo.Permissions,
+						"roles":       userInfo.Roles,
 					}
 				}
+			} else if strings.HasPrefix(key, globalconst.RolePrefix) {
+				var role RoleInfo
+				if err := json.Unmarshal(val, &role); err == nil {
+					sanitizedData[key] = map[string]any{"name": role.Name, "permissions": role.Permissions}
+				}
 			} else {
 				sanitizedData[key] = map[string]any{"data": "non-user system data (omitted)"}
 			}
@@ -680,11 +1504,14 @@ func (h *ConnectionHandler) HandleCollectionItemSetMany(r io.Reader, conn net.Co
 		return
 	}
 
-	var records []map[string]any
-	if err := json.Unmarshal(value, &records); err != nil {
+	records, minimalResponse, ttl, err := decodeSetManyPayload(value)
+	if err == nil && ttl < 0 {
+		err = fmt.Errorf("ttl_seconds cannot be negative")
+	}
+	if err != nil {
 		slog.Warn("Failed to unmarshal JSON array for SET_MANY", "collection", collectionName, "error", err, "user", h.AuthenticatedUser)
 		if conn != nil {
-			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Invalid JSON array format", nil)
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Invalid JSON format. Expected an array of documents or `{\"items\": [...], \"minimal_response\": true, \"ttl_seconds\": 60}`.", nil)
 		}
 		return
 	}
@@ -705,13 +1532,104 @@ func (h *ConnectionHandler) HandleCollectionItemSetMany(r io.Reader, conn net.Co
 			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist. Please create it first.", collectionName), nil)
 			return
 		}
+		recordCollectionWrite(collectionName)
+	}
+	if !h.validateDocuments(conn, collectionName, false, records...) {
+		return
 	}
 
-	// --- START: MODIFIED AND EFFICIENT ID VALIDATION LOGIC ---
 	colStore := h.CollectionManager.GetCollection(collectionName)
-	recordsToProcess := make([]map[string]any, 0, len(records))
-	duplicateKeys := make([]string, 0)
-	invalidRecordsCount := 0
+	recordsToProcess, duplicateKeys, invalidRecordsCount, err := h.checkNewRecordKeys(collectionName, colStore, records, conn == nil)
+	if err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusError, "Internal server error during batch key validation.", nil)
+		}
+		return
+	}
+
+	if len(recordsToProcess) == 0 && conn != nil && h.CurrentTransactionID == "" {
+		msg := fmt.Sprintf("OK: 0 items processed. %d records were skipped due to existing keys and %d were invalid or failed ID generation.", len(duplicateKeys), invalidRecordsCount)
+		var resultBytes []byte
+		if minimalResponse {
+			resultBytes, _ = json.Marshal(setManyResponse(nil, duplicateKeys, invalidRecordsCount, true))
+		}
+		protocol.WriteResponse(conn, protocol.StatusOk, msg, resultBytes)
+		return
+	}
+
+	// Transactional logic (no changes)
+	if h.CurrentTransactionID != "" {
+		for _, record := range recordsToProcess {
+			key := record[globalconst.ID].(string)
+			valBytes, err := json.Marshal(record)
+			if err != nil {
+				slog.Warn("Failed to marshal record in SET_MANY (transaction)", "key", key, "error", err)
+				continue // Skip this record if it can't be marshaled
+			}
+			op := store.WriteOperation{
+				Collection: collectionName, Key: key, Value: valBytes, OpType: store.OpTypeSet,
+			}
+			if err := h.TransactionManager.RecordWrite(h.CurrentTransactionID, op); err != nil {
+				if conn != nil {
+					protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Failed to record set-many op in transaction: "+err.Error(), nil)
+				}
+				return
+			}
+		}
+		if conn != nil {
+			finalDocsBytes, _ := json.Marshal(setManyResponse(recordsToProcess, duplicateKeys, invalidRecordsCount, minimalResponse))
+			protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: %d set operations queued in transaction.", len(recordsToProcess)), finalDocsBytes)
+		}
+		return
+	}
+
+	// Non-transactional logic (no changes)
+	recordsByKey := make(map[string]map[string]any, len(recordsToProcess))
+	for _, record := range recordsToProcess {
+		recordsByKey[record[globalconst.ID].(string)] = record
+	}
+	unlock, ok := h.checkUniqueIndexes(conn, collectionName, false, recordsByKey)
+	if !ok {
+		return
+	}
+	defer unlock()
+	if ttl == 0 {
+		ttl = h.CollectionManager.DefaultTTL(collectionName)
+	}
+	now := time.Now()
+	nowStr := now.UTC().Format(time.RFC3339)
+	for _, record := range recordsToProcess {
+		// ID is already guaranteed in the record
+		store.SetCreationTime(record, now)
+		record[globalconst.UPDATED_AT] = nowStr
+		store.SetVersion(record, 1)
+		updatedValue, err := json.Marshal(record)
+		if err != nil {
+			slog.Warn("Failed to marshal record in SET_MANY batch, skipping", "key", record[globalconst.ID], "error", err)
+			continue
+		}
+		colStore.Set(record[globalconst.ID].(string), updatedValue, ttl)
+		publishChange(collectionName, changeOpSet, record[globalconst.ID].(string), updatedValue)
+	}
+
+	if len(recordsToProcess) > 0 {
+		h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
+	}
+	slog.Info("Set-many operation completed", "user", h.AuthenticatedUser, "inserted_count", len(recordsToProcess), "duplicates_skipped", len(duplicateKeys), "invalid_skipped", invalidRecordsCount)
+	if conn != nil {
+		finalDocsBytes, _ := json.Marshal(setManyResponse(recordsToProcess, duplicateKeys, invalidRecordsCount, minimalResponse))
+		msg := fmt.Sprintf("OK: %d items set in collection '%s'. %d records were skipped due to existing keys. %d were invalid or failed ID generation.", len(recordsToProcess), collectionName, len(duplicateKeys), invalidRecordsCount)
+		protocol.WriteResponse(conn, protocol.StatusOk, msg, finalDocsBytes)
+	}
+}
+
+// checkNewRecordKeys applies the key-uniqueness rules of SET_MANY to records. Records without
+// an _id get a generated one, unless recovery is set: WAL entries always carry their keys.
+// Outside a transaction, records whose key already exists in memory or on disk are rejected as
+// duplicates; inside one, the commit validates them.
+func (h *ConnectionHandler) checkNewRecordKeys(collectionName string, colStore store.DataStore, records []map[string]any, recovery bool) (recordsToProcess []map[string]any, duplicateKeys []string, invalidRecordsCount int, err error) {
+	recordsToProcess = make([]map[string]any, 0, len(records))
+	duplicateKeys = make([]string, 0)
 
 	// 1. Collect all client-provided keys for batch verification.
 	clientProvidedKeys := make([]string, 0, len(records))
@@ -724,14 +1642,10 @@ func (h *ConnectionHandler) HandleCollectionItemSetMany(r io.Reader, conn net.Co
 	// 2. Check all keys on disk in a single pass.
 	var foundInCold map[string]bool
 	if h.CurrentTransactionID == "" && len(clientProvidedKeys) > 0 {
-		var checkErr error
-		foundInCold, checkErr = persistence.CheckManyColdKeysExist(collectionName, clientProvidedKeys)
-		if checkErr != nil {
-			slog.Error("Failed to check batch key existence in cold storage", "collection", collectionName, "error", checkErr)
-			if conn != nil {
-				protocol.WriteResponse(conn, protocol.StatusError, "Internal server error during batch key validation.", nil)
-			}
-			return
+		foundInCold, err = persistence.CheckManyColdKeysExist(collectionName, clientProvidedKeys)
+		if err != nil {
+			slog.Error("Failed to check batch key existence in cold storage", "collection", collectionName, "error", err)
+			return nil, nil, 0, err
 		}
 	}
 
@@ -741,7 +1655,7 @@ func (h *ConnectionHandler) HandleCollectionItemSetMany(r io.Reader, conn net.Co
 
 		// Case 1: Client does not provide an ID, server generates one.
 		if !clientProvidedKey || key == "" {
-			if conn == nil {
+			if recovery {
 				slog.Error("CRITICAL: SET_MANY record with empty key received during WAL replay.", "collection", collectionName)
 				invalidRecordsCount++
 				continue
@@ -785,63 +1699,56 @@ func (h *ConnectionHandler) HandleCollectionItemSetMany(r io.Reader, conn net.Co
 			recordsToProcess = append(recordsToProcess, record)
 		}
 	}
+	return recordsToProcess, duplicateKeys, invalidRecordsCount, nil
+}
 
-	if len(recordsToProcess) == 0 && conn != nil && h.CurrentTransactionID == "" {
-		msg := fmt.Sprintf("OK: 0 items processed. %d records were skipped due to existing keys and %d were invalid or failed ID generation.", len(duplicateKeys), invalidRecordsCount)
-		protocol.WriteResponse(conn, protocol.StatusOk, msg, nil)
-		return
-	}
-	// --- END: ID GENERATION AND VALIDATION LOGIC ---
+// setManyRequest is the object form of a SET_MANY payload. It lets the client ask for a
+// minimal response instead of receiving back every document it just sent, and give every item
+// of the batch the same TTL.
+type setManyRequest struct {
+	Items           []map[string]any `json:"items"`
+	MinimalResponse bool             `json:"minimal_response"`
+	// TTLSeconds is the expiry of every item of the batch; zero leaves the collection's default TTL.
+	TTLSeconds int64 `json:"ttl_seconds,omitempty"`
+}
 
-	// Transactional logic (no changes)
-	if h.CurrentTransactionID != "" {
-		for _, record := range recordsToProcess {
-			key := record[globalconst.ID].(string)
-			valBytes, err := json.Marshal(record)
-			if err != nil {
-				slog.Warn("Failed to marshal record in SET_MANY (transaction)", "key", key, "error", err)
-				continue // Skip this record if it can't be marshaled
-			}
-			op := store.WriteOperation{
-				Collection: collectionName, Key: key, Value: valBytes, OpType: store.OpTypeSet,
-			}
-			if err := h.TransactionManager.RecordWrite(h.CurrentTransactionID, op); err != nil {
-				if conn != nil {
-					protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Failed to record set-many op in transaction: "+err.Error(), nil)
-				}
-				return
-			}
-		}
-		if conn != nil {
-			finalDocsBytes, _ := json.Marshal(recordsToProcess)
-			protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: %d set operations queued in transaction.", len(recordsToProcess)), finalDocsBytes)
-		}
-		return
-	}
+// SetManyResult is the minimal response payload of a SET_MANY command.
+type SetManyResult struct {
+	IDs        []string `json:"ids"` // Keys of the accepted documents, including generated ones.
+	Inserted   int      `json:"inserted"`
+	Duplicates []string `json:"duplicates"` // Client-provided keys skipped because they already exist.
+	Invalid    int      `json:"invalid"`
+}
 
-	// Non-transactional logic (no changes)
-	now := time.Now().UTC().Format(time.RFC3339)
-	for _, record := range recordsToProcess {
-		// ID is already guaranteed in the record
-		record[globalconst.CREATED_AT] = now
-		record[globalconst.UPDATED_AT] = now
-		updatedValue, err := json.Marshal(record)
-		if err != nil {
-			slog.Warn("Failed to marshal record in SET_MANY batch, skipping", "key", record[globalconst.ID], "error", err)
-			continue
+// decodeSetManyPayload accepts either a plain JSON array of documents or a setManyRequest object.
+func decodeSetManyPayload(value []byte) (records []map[string]any, minimalResponse bool, ttl time.Duration, err error) {
+	trimmed := bytes.TrimSpace(value)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var request setManyRequest
+		if err := json.Unmarshal(trimmed, &request); err != nil {
+			return nil, false, 0, err
 		}
-		colStore.Set(record[globalconst.ID].(string), updatedValue, 0)
+		return request.Items, request.MinimalResponse, time.Duration(request.TTLSeconds) * time.Second, nil
+	}
+	if err := json.Unmarshal(value, &records); err != nil {
+		return nil, false, 0, err
 	}
+	return records, false, 0, nil
+}
 
-	if len(recordsToProcess) > 0 {
-		h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
+// setManyResponse builds the response data of a SET_MANY command: the full documents as
+// stored, or only their keys and the skip counts when a minimal response was requested.
+func setManyResponse(records []map[string]any, duplicateKeys []string, invalidCount int, minimal bool) any {
+	if !minimal {
+		return records
 	}
-	slog.Info("Set-many operation completed", "user", h.AuthenticatedUser, "inserted_count", len(recordsToProcess), "duplicates_skipped", len(duplicateKeys), "invalid_skipped", invalidRecordsCount)
-	if conn != nil {
-		finalDocsBytes, _ := json.Marshal(recordsToProcess)
-		msg := fmt.Sprintf("OK: %d items set in collection '%s'. %d records were skipped due to existing keys. %d were invalid or failed ID generation.", len(recordsToProcess), collectionName, len(duplicateKeys), invalidRecordsCount)
-		protocol.WriteResponse(conn, protocol.StatusOk, msg, finalDocsBytes)
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		if id, ok := record[globalconst.ID].(string); ok {
+			ids = append(ids, id)
+		}
 	}
+	return SetManyResult{IDs: ids, Inserted: len(ids), Duplicates: duplicateKeys, Invalid: invalidCount}
 }
 
 // HandleCollectionItemDeleteMany processes the CmdCollectionItemDeleteMany command. It is a write operation.
@@ -875,6 +1782,7 @@ func (h *ConnectionHandler) HandleCollectionItemDeleteMany(r io.Reader, conn net
 			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist.", collectionName), nil)
 			return
 		}
+		recordCollectionWrite(collectionName)
 	}
 
 	// Transactional logic
@@ -912,6 +1820,7 @@ func (h *ConnectionHandler) HandleCollectionItemDeleteMany(r io.Reader, conn net
 	if len(hotKeysToDelete) > 0 {
 		for _, key := range hotKeysToDelete {
 			colStore.Delete(key)
+			publishChange(collectionName, changeOpDelete, key, nil)
 		}
 		h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
 	}
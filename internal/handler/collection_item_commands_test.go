@@ -0,0 +1,767 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"memory-tools/internal/wal"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func upsertCommand(collectionName, key, patch string) func(io.Writer) error {
+	return func(w io.Writer) error {
+		return protocol.WriteCollectionItemUpsertCommand(w, collectionName, key, []byte(patch))
+	}
+}
+
+// storedDoc returns the in-memory document of a key.
+func (e *testEnv) storedDoc(collectionName, key string) map[string]any {
+	e.t.Helper()
+	value, found := e.cm.GetCollection(collectionName).Get(key)
+	if !found {
+		e.t.Fatalf("key '%s' not found in collection '%s'", key, collectionName)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(value, &doc); err != nil {
+		e.t.Fatalf("stored value of '%s' is not JSON: %v", key, err)
+	}
+	return doc
+}
+
+func TestUpsertInsertsThenMerges(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	h := env.handler()
+
+	expectStatus(t, env.run(h.HandleCollectionItemUpsert, upsertCommand("items", "k", `{"a":1}`)), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCollectionItemUpsert, upsertCommand("items", "k", `{"b":2}`)), protocol.StatusOk)
+
+	doc := env.storedDoc("items", "k")
+	if doc["a"] != float64(1) || doc["b"] != float64(2) {
+		t.Fatalf("document = %v, want both patches merged", doc)
+	}
+	if v := store.VersionOf(doc); v != 2 {
+		t.Fatalf("version = %d, want 2", v)
+	}
+}
+
+func TestUpsertInsertGetsTheCollectionDefaultTTL(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("sessions")
+	h := env.handler()
+	expectStatus(t, env.run(h.HandleCollectionSetDefaultTTL, func(w io.Writer) error {
+		return protocol.WriteCollectionSetDefaultTTLCommand(w, "sessions", time.Hour)
+	}), protocol.StatusOk)
+
+	expectStatus(t, env.run(h.HandleCollectionItemUpsert, upsertCommand("sessions", "s1", `{"user":"ada"}`)), protocol.StatusOk)
+
+	remaining, hasExpiry, found := env.cm.GetCollection("sessions").TTL("s1")
+	if !found || !hasExpiry || remaining <= 0 || remaining > time.Hour {
+		t.Fatalf("TTL of the inserted item = %v (expiry %v, found %v), want the default of one hour", remaining, hasExpiry, found)
+	}
+}
+
+func TestConcurrentUpsertsOfAMissingKeyKeepEveryPatch(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+
+	const keys, writers = 8, 32
+	handlers := make([]*ConnectionHandler, writers)
+	for i := range handlers {
+		handlers[i] = env.handler()
+	}
+	var wg sync.WaitGroup
+	for i, h := range handlers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range keys {
+				resp := env.run(h.HandleCollectionItemUpsert, upsertCommand("items", fmt.Sprintf("k%d", k), fmt.Sprintf(`{"f%d":%d}`, i, i)))
+				if resp.status != protocol.StatusOk {
+					t.Errorf("upsert %d of k%d: %d %s", i, k, resp.status, resp.msg)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for k := range keys {
+		doc := env.storedDoc("items", fmt.Sprintf("k%d", k))
+		for i := range writers {
+			if _, ok := doc[fmt.Sprintf("f%d", i)]; !ok {
+				t.Fatalf("patch %d of k%d was lost: %v", i, k, doc)
+			}
+		}
+		if v := store.VersionOf(doc); v != writers {
+			t.Fatalf("version of k%d = %d, want %d", k, v, writers)
+		}
+	}
+}
+
+func TestUpsertInTransactionRejectsItemHeldOnDisk(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	persister := &persistence.CollectionPersisterImpl{}
+	if err := persister.WriteColdItems("items", map[string][]byte{"cold": []byte(`{"_id":"cold","a":1,"b":2}`)}); err != nil {
+		t.Fatalf("writing cold item: %v", err)
+	}
+	h := env.handler()
+
+	expectStatus(t, env.run(h.handleBegin, protocol.WriteBeginCommand), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCollectionItemUpsert, upsertCommand("items", "cold", `{"a":5}`)), protocol.StatusNotFound)
+	expectStatus(t, env.run(h.HandleCommit, protocol.WriteCommitCommand), protocol.StatusOk)
+
+	if _, found := env.cm.GetCollection("items").Get("cold"); found {
+		t.Fatal("the upsert queued a new document over the item held on disk")
+	}
+	value, found, err := persistence.GetColdItem("items", "cold")
+	if err != nil || !found {
+		t.Fatalf("cold item: found=%v err=%v", found, err)
+	}
+	var doc map[string]any
+	json.Unmarshal(value, &doc)
+	if doc["b"] != float64(2) {
+		t.Fatalf("cold item = %v, want it unchanged", doc)
+	}
+}
+
+func TestUpsertInTransactionInsertsMissingItem(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	h := env.handler()
+
+	expectStatus(t, env.run(h.handleBegin, protocol.WriteBeginCommand), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCollectionItemUpsert, upsertCommand("items", "new", `{"a":1}`)), protocol.StatusOk)
+	if _, found := env.cm.GetCollection("items").Get("new"); found {
+		t.Fatal("queued upsert is visible before commit")
+	}
+	expectStatus(t, env.run(h.HandleCommit, protocol.WriteCommitCommand), protocol.StatusOk)
+	if doc := env.storedDoc("items", "new"); doc["a"] != float64(1) {
+		t.Fatalf("document = %v", doc)
+	}
+}
+
+func TestUpsertInTransactionMergesWithEarlierWrites(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	env.setItem("items", "old", `{"a":1}`)
+	h := env.handler()
+
+	expectStatus(t, env.run(h.handleBegin, protocol.WriteBeginCommand), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCollectionItemUpsert, upsertCommand("items", "new", `{"a":1}`)), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCollectionItemUpsert, upsertCommand("items", "new", `{"b":2}`)), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCollectionItemUpdate, func(w io.Writer) error {
+		return protocol.WriteCollectionItemUpdateCommand(w, "items", "new", []byte(`{"c":3}`))
+	}), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCollectionItemUpsert, upsertCommand("items", "old", `{"b":2}`)), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCollectionItemUpsert, upsertCommand("items", "old", `{"c":3}`)), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCommit, protocol.WriteCommitCommand), protocol.StatusOk)
+
+	for _, key := range []string{"new", "old"} {
+		doc := env.storedDoc("items", key)
+		if doc["a"] != float64(1) || doc["b"] != float64(2) || doc["c"] != float64(3) {
+			t.Errorf("document %q = %v, want every patch applied", key, doc)
+		}
+	}
+}
+
+func replaceCommand(collectionName, key, value string) func(io.Writer) error {
+	return func(w io.Writer) error {
+		return protocol.WriteCollectionItemReplaceCommand(w, collectionName, key, []byte(value))
+	}
+}
+
+func TestReplaceOverwritesExistingDocument(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	env.setItem("items", "k", `{"a":1,"nested":{"b":2}}`)
+	before := env.storedDoc("items", "k")
+
+	resp := env.run(env.handler().HandleCollectionItemReplace, replaceCommand("items", "k", `{"_id":"other","c":3}`))
+	expectStatus(t, resp, protocol.StatusOk)
+
+	doc := env.storedDoc("items", "k")
+	if _, kept := doc["a"]; kept {
+		t.Errorf("field 'a' survived the replace: %v", doc)
+	}
+	if _, kept := doc["nested"]; kept {
+		t.Errorf("field 'nested' survived the replace: %v", doc)
+	}
+	if doc["c"] != float64(3) {
+		t.Errorf("c = %v, want 3", doc["c"])
+	}
+	if doc["_id"] != "k" {
+		t.Errorf("_id = %v, want the key", doc["_id"])
+	}
+	if doc["created_at"] != before["created_at"] {
+		t.Errorf("created_at = %v, want %v kept", doc["created_at"], before["created_at"])
+	}
+	if doc["updated_at"] == nil {
+		t.Error("updated_at was not set")
+	}
+	if v := store.VersionOf(doc); v != store.VersionOf(before)+1 {
+		t.Errorf("version = %d, want %d", v, store.VersionOf(before)+1)
+	}
+}
+
+func TestReplaceMissingKeyFails(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+
+	resp := env.run(env.handler().HandleCollectionItemReplace, replaceCommand("items", "missing", `{"a":1}`))
+	expectStatus(t, resp, protocol.StatusNotFound)
+	if _, found := env.cm.GetCollection("items").Get("missing"); found {
+		t.Fatal("replace created the missing key")
+	}
+
+	h := env.handler()
+	expectStatus(t, env.run(h.handleBegin, protocol.WriteBeginCommand), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCollectionItemReplace, replaceCommand("items", "missing", `{"a":1}`)), protocol.StatusNotFound)
+	expectStatus(t, env.run(h.HandleCommit, protocol.WriteCommitCommand), protocol.StatusOk)
+	if _, found := env.cm.GetCollection("items").Get("missing"); found {
+		t.Fatal("transactional replace created the missing key")
+	}
+}
+
+func TestReplaceInTransactionAppliesOnCommit(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	env.setItem("items", "k", `{"a":1}`)
+	h := env.handler()
+
+	expectStatus(t, env.run(h.handleBegin, protocol.WriteBeginCommand), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCollectionItemReplace, replaceCommand("items", "k", `{"b":2}`)), protocol.StatusOk)
+	if doc := env.storedDoc("items", "k"); doc["a"] != float64(1) {
+		t.Fatalf("queued replace is visible before commit: %v", doc)
+	}
+	expectStatus(t, env.run(h.HandleCommit, protocol.WriteCommitCommand), protocol.StatusOk)
+	doc := env.storedDoc("items", "k")
+	if _, kept := doc["a"]; kept || doc["b"] != float64(2) {
+		t.Fatalf("document = %v, want only the replacement", doc)
+	}
+}
+
+func TestReplaceColdDocument(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	persister := &persistence.CollectionPersisterImpl{}
+	if err := persister.WriteColdItems("items", map[string][]byte{"cold": []byte(`{"_id":"cold","a":1}`)}); err != nil {
+		t.Fatalf("writing cold item: %v", err)
+	}
+
+	expectStatus(t, env.run(env.handler().HandleCollectionItemReplace, replaceCommand("items", "cold", `{"b":2}`)), protocol.StatusOk)
+	value, found, err := persistence.GetColdItem("items", "cold")
+	if err != nil || !found {
+		t.Fatalf("cold item: found=%v err=%v", found, err)
+	}
+	var doc map[string]any
+	json.Unmarshal(value, &doc)
+	if _, kept := doc["a"]; kept || doc["b"] != float64(2) {
+		t.Fatalf("cold item = %v, want only the replacement", doc)
+	}
+}
+
+// maskedGet fetches the given fields of a document through a GET with a field mask.
+func (e *testEnv) maskedGet(collectionName, key string, fields ...string) (testResponse, map[string]any) {
+	e.t.Helper()
+	resp := e.run(e.handler().handleCollectionItemGet, func(w io.Writer) error {
+		return protocol.WriteCollectionItemGetCommand(w, collectionName, key, fields)
+	})
+	var doc map[string]any
+	if resp.status == protocol.StatusOk {
+		if err := json.Unmarshal(resp.data, &doc); err != nil {
+			e.t.Fatalf("masked document %q: %v", resp.data, err)
+		}
+	}
+	return resp, doc
+}
+
+func TestGetWithFieldMaskReturnsNestedFields(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("people")
+	env.setItem("people", "p1", `{"name":"Ada","password":"x","address":{"city":"London","zip":"N1","geo":{"lat":51.5,"lng":-0.1}},"tags":["a","b"]}`)
+
+	resp, doc := env.maskedGet("people", "p1", "name", "address.city", "address.geo.lat", "missing.field")
+	expectStatus(t, resp, protocol.StatusOk)
+	want := map[string]any{
+		"name":    "Ada",
+		"address": map[string]any{"city": "London", "geo": map[string]any{"lat": 51.5}},
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Fatalf("masked document = %v, want %v", doc, want)
+	}
+
+	if _, doc := env.maskedGet("people", "p1", "address.geo", "tags"); !reflect.DeepEqual(doc, map[string]any{
+		"address": map[string]any{"geo": map[string]any{"lat": 51.5, "lng": -0.1}},
+		"tags":    []any{"a", "b"},
+	}) {
+		t.Fatalf("masked document = %v, want the whole geo object and tags", doc)
+	}
+
+	resp, _ = env.maskedGet("people", "nobody", "name")
+	expectStatus(t, resp, protocol.StatusNotFound)
+}
+
+func TestGetWithFieldMaskReadsColdDocument(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("people")
+	persister := &persistence.CollectionPersisterImpl{}
+	if err := persister.WriteColdItems("people", map[string][]byte{"cold": []byte(`{"_id":"cold","name":"Grace","address":{"city":"NYC","zip":"10001"}}`)}); err != nil {
+		t.Fatalf("writing cold item: %v", err)
+	}
+
+	resp, doc := env.maskedGet("people", "cold", "address.zip")
+	expectStatus(t, resp, protocol.StatusOk)
+	if want := map[string]any{"address": map[string]any{"zip": "10001"}}; !reflect.DeepEqual(doc, want) {
+		t.Fatalf("masked cold document = %v, want %v", doc, want)
+	}
+}
+
+func getAndDeleteCommand(collectionName, key string) func(io.Writer) error {
+	return func(w io.Writer) error {
+		return protocol.WriteCollectionItemGetAndDeleteCommand(w, collectionName, key)
+	}
+}
+
+// popConcurrently has consumers race to get-and-delete every key and returns how many
+// times each key was delivered, failing on any response other than OK or NOT FOUND.
+func (e *testEnv) popConcurrently(collectionName string, keys []string, consumers int) map[string]int {
+	e.t.Helper()
+	var mu sync.Mutex
+	delivered := make(map[string]int)
+	var wg sync.WaitGroup
+	for range consumers {
+		h := e.handler()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, key := range keys {
+				resp := e.run(h.HandleCollectionItemGetAndDelete, getAndDeleteCommand(collectionName, key))
+				switch resp.status {
+				case protocol.StatusOk:
+					var doc map[string]any
+					if err := json.Unmarshal(resp.data, &doc); err != nil || doc["_id"] != key {
+						e.t.Errorf("popped %s: got %s", key, resp.data)
+					}
+					mu.Lock()
+					delivered[key]++
+					mu.Unlock()
+				case protocol.StatusNotFound:
+				default:
+					e.t.Errorf("pop %s: %d %s", key, resp.status, resp.msg)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return delivered
+}
+
+func TestConcurrentGetAndDeleteDeliversEachItemOnce(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("queue")
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("job%03d", i)
+		env.setItem("queue", keys[i], `{"n":`+fmt.Sprint(i)+`}`)
+	}
+
+	delivered := env.popConcurrently("queue", keys, 16)
+	for _, key := range keys {
+		if delivered[key] != 1 {
+			t.Errorf("%s delivered %d times, want once", key, delivered[key])
+		}
+	}
+	if size := env.cm.GetCollection("queue").Size(); size != 0 {
+		t.Fatalf("%d items left in the queue", size)
+	}
+}
+
+func TestConcurrentGetAndDeleteOfColdItemsDeliversEachOnce(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("queue")
+	keys := make([]string, 30)
+	cold := make(map[string][]byte, len(keys))
+	for i := range keys {
+		keys[i] = fmt.Sprintf("job%03d", i)
+		cold[keys[i]] = fmt.Appendf(nil, `{"_id":"%s","n":%d}`, keys[i], i)
+	}
+	if err := (&persistence.CollectionPersisterImpl{}).WriteColdItems("queue", cold); err != nil {
+		t.Fatalf("writing cold items: %v", err)
+	}
+	env.cm.GetCollection("queue").MarkCold(keys...)
+
+	delivered := env.popConcurrently("queue", keys, 8)
+	for _, key := range keys {
+		if delivered[key] != 1 {
+			t.Errorf("%s delivered %d times, want once", key, delivered[key])
+		}
+		if _, found, _ := persistence.GetColdItem("queue", key); found {
+			t.Errorf("%s is still on disk", key)
+		}
+	}
+}
+
+func TestGetAndDeleteInTransactionsTakesItemOnce(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("queue")
+	env.setItem("queue", "job", `{"n":1}`)
+	first, second := env.handler(), env.handler()
+
+	for _, h := range []*ConnectionHandler{first, second} {
+		expectStatus(t, env.run(h.handleBegin, protocol.WriteBeginCommand), protocol.StatusOk)
+		expectStatus(t, env.run(h.HandleCollectionItemGetAndDelete, getAndDeleteCommand("queue", "job")), protocol.StatusOk)
+	}
+	if _, found := env.cm.GetCollection("queue").Get("job"); !found {
+		t.Fatal("queued get-and-delete removed the item before commit")
+	}
+
+	expectStatus(t, env.run(first.HandleCommit, protocol.WriteCommitCommand), protocol.StatusOk)
+	if resp := env.run(second.HandleCommit, protocol.WriteCommitCommand); resp.status == protocol.StatusOk {
+		t.Fatal("a second transaction committed the take of an item already taken")
+	}
+	if _, found := env.cm.GetCollection("queue").Get("job"); found {
+		t.Fatal("item survived the committed get-and-delete")
+	}
+	expectStatus(t, env.run(env.handler().HandleCollectionItemGetAndDelete, getAndDeleteCommand("queue", "job")), protocol.StatusNotFound)
+}
+
+func TestItemExistsChecksMemoryDiskAndTransaction(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	env.setItem("items", "hot", `{"a":1}`)
+	env.setItem("items", "doomed", `{"a":1}`)
+	cold := map[string][]byte{
+		"cold":       []byte(`{"_id":"cold","a":1}`),
+		"tombstoned": []byte(`{"_id":"tombstoned","a":1}`),
+	}
+	if err := (&persistence.CollectionPersisterImpl{}).WriteColdItems("items", cold); err != nil {
+		t.Fatalf("writing cold items: %v", err)
+	}
+	if _, err := persistence.DeleteColdItem("items", "tombstoned"); err != nil {
+		t.Fatalf("deleting cold item: %v", err)
+	}
+	h := env.handler()
+	exists := func(key string) bool {
+		t.Helper()
+		resp := env.run(h.handleCollectionItemExists, func(w io.Writer) error {
+			return protocol.WriteCollectionItemExistsCommand(w, "items", key)
+		})
+		expectStatus(t, resp, protocol.StatusOk)
+		var body map[string]bool
+		if err := json.Unmarshal(resp.data, &body); err != nil {
+			t.Fatal(err)
+		}
+		return body["exists"]
+	}
+
+	for key, want := range map[string]bool{"hot": true, "cold": true, "tombstoned": false, "missing": false} {
+		if got := exists(key); got != want {
+			t.Errorf("exists(%q) = %v, want %v", key, got, want)
+		}
+	}
+
+	// Inside a transaction the pending writes of the connection are taken into account.
+	expectStatus(t, env.run(h.handleBegin, protocol.WriteBeginCommand), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCollectionItemSet, func(w io.Writer) error {
+		return protocol.WriteCollectionItemSetCommand(w, "items", "queued", []byte(`{"a":1}`), 0)
+	}), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCollectionItemDelete, func(w io.Writer) error {
+		return protocol.WriteCollectionItemDeleteCommand(w, "items", "doomed")
+	}), protocol.StatusOk)
+	if !exists("queued") || exists("doomed") {
+		t.Error("exists ignores the pending writes of the transaction")
+	}
+	expectStatus(t, env.run(h.handleRollback, protocol.WriteRollbackCommand), protocol.StatusOk)
+	if exists("queued") || !exists("doomed") {
+		t.Error("rolled back writes are still visible")
+	}
+
+	h.IsRoot = false
+	h.Permissions["items"] = "metadata"
+	expectStatus(t, env.run(h.handleCollectionItemExists, func(w io.Writer) error {
+		return protocol.WriteCollectionItemExistsCommand(w, "items", "hot")
+	}), protocol.StatusUnauthorized)
+}
+
+func TestSetManyMinimalResponseHasIDsAndCountsOnly(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	env.setItem("items", "dup", `{"a":0}`)
+	setMany := func(h *ConnectionHandler, payload string) testResponse {
+		return env.run(h.HandleCollectionItemSetMany, func(w io.Writer) error {
+			return protocol.WriteCollectionItemSetManyCommand(w, "items", []byte(payload))
+		})
+	}
+	minimal := func(resp testResponse) map[string]any {
+		t.Helper()
+		expectStatus(t, resp, protocol.StatusOk)
+		var body map[string]any
+		if err := json.Unmarshal(resp.data, &body); err != nil {
+			t.Fatalf("minimal response %q: %v", resp.data, err)
+		}
+		return body
+	}
+
+	body := minimal(setMany(env.handler(), `{"items":[{"_id":"dup","a":1},{"_id":"x","a":2},{"a":3}],"minimal_response":true}`))
+	ids, _ := body["ids"].([]any)
+	if len(body) != 4 || len(ids) != 2 || ids[0] != "x" || body["inserted"] != float64(2) || body["invalid"] != float64(0) ||
+		!reflect.DeepEqual(body["duplicates"], []any{"dup"}) {
+		t.Fatalf("minimal response = %v, want two ids, the counts and the duplicate only", body)
+	}
+	if _, found := env.cm.GetCollection("items").Get(ids[1].(string)); !found {
+		t.Errorf("generated id %v was not stored", ids[1])
+	}
+
+	body = minimal(setMany(env.handler(), `{"items":[{"_id":"x"}],"minimal_response":true}`))
+	if body["inserted"] != float64(0) || !reflect.DeepEqual(body["duplicates"], []any{"x"}) {
+		t.Errorf("minimal response of an all-duplicate batch = %v", body)
+	}
+
+	h := env.handler()
+	expectStatus(t, env.run(h.handleBegin, protocol.WriteBeginCommand), protocol.StatusOk)
+	body = minimal(setMany(h, `{"items":[{"_id":"y","a":4}],"minimal_response":true}`))
+	if !reflect.DeepEqual(body["ids"], []any{"y"}) || body["inserted"] != float64(1) {
+		t.Errorf("minimal response in a transaction = %v", body)
+	}
+	expectStatus(t, env.run(h.HandleCommit, protocol.WriteCommitCommand), protocol.StatusOk)
+
+	resp := setMany(env.handler(), `[{"_id":"z","a":5}]`)
+	expectStatus(t, resp, protocol.StatusOk)
+	var docs []map[string]any
+	if err := json.Unmarshal(resp.data, &docs); err != nil || len(docs) != 1 || docs[0]["a"] != float64(5) {
+		t.Errorf("full response = %s, want the stored document", resp.data)
+	}
+}
+
+func updateIfCommand(collectionName, key, condition, patch string) func(io.Writer) error {
+	return func(w io.Writer) error {
+		return protocol.WriteCollectionItemUpdateIfCommand(w, collectionName, key, []byte(condition), []byte(patch))
+	}
+}
+
+// updateIf runs a conditional update and returns whether it was applied.
+func (e *testEnv) updateIf(h *ConnectionHandler, key, condition, patch string) bool {
+	e.t.Helper()
+	resp := e.run(h.HandleCollectionItemUpdateIf, updateIfCommand("orders", key, condition, patch))
+	expectStatus(e.t, resp, protocol.StatusOk)
+	var result ConditionalUpdateResult
+	if err := json.Unmarshal(resp.data, &result); err != nil {
+		e.t.Fatal(err)
+	}
+	return result.Applied
+}
+
+const (
+	ifPacked     = `{"field":"status","op":"=","value":"packed"}`
+	shippedPatch = `{"status":"shipped"}`
+)
+
+func TestUpdateIfAppliesOnlyWhenConditionMatches(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("orders")
+	env.setItem("orders", "o1", `{"status":"packed","total":10}`)
+	env.setItem("orders", "o2", `{"status":"pending","total":20}`)
+	persister := &persistence.CollectionPersisterImpl{}
+	if err := persister.WriteColdItems("orders", map[string][]byte{"cold": []byte(`{"_id":"cold","status":"packed"}`)}); err != nil {
+		t.Fatalf("writing cold item: %v", err)
+	}
+	h := env.handler()
+
+	if !env.updateIf(h, "o1", ifPacked, shippedPatch) {
+		t.Error("update not applied although the condition matches")
+	}
+	if doc := env.storedDoc("orders", "o1"); doc["status"] != "shipped" || doc["total"] != float64(10) {
+		t.Errorf("o1 = %v, want shipped with its total kept", doc)
+	}
+	if env.updateIf(h, "o2", ifPacked, shippedPatch) {
+		t.Error("update applied although the condition does not match")
+	}
+	if doc := env.storedDoc("orders", "o2"); doc["status"] != "pending" {
+		t.Errorf("o2 = %v, want it unchanged", doc)
+	}
+
+	if !env.updateIf(h, "cold", ifPacked, shippedPatch) || env.updateIf(h, "cold", ifPacked, shippedPatch) {
+		t.Error("the cold item was not updated exactly once")
+	}
+	value, found, err := persistence.GetColdItem("orders", "cold")
+	if err != nil || !found || !bytes.Contains(value, []byte(`"shipped"`)) {
+		t.Errorf("cold item = %s (found=%v, err=%v), want it shipped", value, found, err)
+	}
+	expectStatus(t, env.run(h.HandleCollectionItemUpdateIf, updateIfCommand("orders", "missing", ifPacked, shippedPatch)), protocol.StatusNotFound)
+}
+
+func TestConcurrentUpdateIfAppliesOnce(t *testing.T) {
+	const writers = 16
+	env := newTestEnv(t)
+	env.createTestCollection("orders")
+	env.setItem("orders", "o1", `{"status":"packed"}`)
+
+	var wg sync.WaitGroup
+	var applied sync.Map
+	for i := range writers {
+		h := env.handler()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var cmd bytes.Buffer
+			updateIfCommand("orders", "o1", ifPacked, fmt.Sprintf(`{"status":"shipped","by":%d}`, i))(&cmd)
+			cmd.Next(1)
+			conn := &testConn{}
+			h.HandleCollectionItemUpdateIf(&cmd, conn)
+			if bytes.Contains(conn.out.Bytes(), []byte(`"applied":true`)) {
+				applied.Store(i, true)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var winners []any
+	applied.Range(func(i, _ any) bool {
+		winners = append(winners, i)
+		return true
+	})
+	if len(winners) != 1 {
+		t.Fatalf("%d conditional updates applied, want exactly one", len(winners))
+	}
+	if doc := env.storedDoc("orders", "o1"); doc["by"] != float64(winners[0].(int)) {
+		t.Errorf("document = %v, want the update of writer %v", doc, winners[0])
+	}
+}
+
+func TestUpdateIfInTransactionRechecksConditionAtCommit(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("orders")
+	env.setItem("orders", "o1", `{"status":"packed"}`)
+	env.setItem("orders", "o2", `{"status":"packed"}`)
+	h := env.handler()
+
+	expectStatus(t, env.run(h.handleBegin, protocol.WriteBeginCommand), protocol.StatusOk)
+	if !env.updateIf(h, "o1", ifPacked, shippedPatch) {
+		t.Fatal("conditional update not queued")
+	}
+	if doc := env.storedDoc("orders", "o1"); doc["status"] != "packed" {
+		t.Fatalf("queued update is visible before commit: %v", doc)
+	}
+	expectStatus(t, env.run(h.HandleCommit, protocol.WriteCommitCommand), protocol.StatusOk)
+	if doc := env.storedDoc("orders", "o1"); doc["status"] != "shipped" {
+		t.Fatalf("o1 after commit = %v", doc)
+	}
+
+	// Another connection changes the item between the check and the commit.
+	expectStatus(t, env.run(h.handleBegin, protocol.WriteBeginCommand), protocol.StatusOk)
+	if !env.updateIf(h, "o2", ifPacked, shippedPatch) {
+		t.Fatal("conditional update not queued")
+	}
+	if !env.updateIf(env.handler(), "o2", ifPacked, `{"status":"cancelled"}`) {
+		t.Fatal("concurrent update not applied")
+	}
+	if resp := env.run(h.HandleCommit, protocol.WriteCommitCommand); resp.status == protocol.StatusOk {
+		t.Fatal("commit succeeded although the condition no longer holds")
+	}
+	if doc := env.storedDoc("orders", "o2"); doc["status"] != "cancelled" {
+		t.Errorf("o2 = %v, want the concurrent update kept", doc)
+	}
+}
+
+func TestUpdateAndReplaceWithIfMatchCheckTheVersion(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	env.setItem("items", "k", `{"a":1}`)
+	h := env.handler()
+
+	resp := env.run(h.HandleCollectionItemUpdate, func(w io.Writer) error {
+		return protocol.WriteCollectionItemUpdateIfMatchCommand(w, "items", "k", []byte(`{"a":2}`), 1)
+	})
+	expectStatus(t, resp, protocol.StatusOk)
+	resp = env.run(h.HandleCollectionItemUpdate, func(w io.Writer) error {
+		return protocol.WriteCollectionItemUpdateIfMatchCommand(w, "items", "k", []byte(`{"a":3}`), 1)
+	})
+	expectStatus(t, resp, protocol.StatusConflict)
+	resp = env.run(h.HandleCollectionItemReplace, func(w io.Writer) error {
+		return protocol.WriteCollectionItemReplaceIfMatchCommand(w, "items", "k", []byte(`{"b":4}`), 1)
+	})
+	expectStatus(t, resp, protocol.StatusConflict)
+	if doc := env.storedDoc("items", "k"); doc["a"] != float64(2) || store.VersionOf(doc) != 2 {
+		t.Fatalf("document after rejected writes = %v, want a = 2 at version 2", doc)
+	}
+
+	resp = env.run(h.HandleCollectionItemReplace, func(w io.Writer) error {
+		return protocol.WriteCollectionItemReplaceIfMatchCommand(w, "items", "k", []byte(`{"b":4}`), 2)
+	})
+	expectStatus(t, resp, protocol.StatusOk)
+	// Without If-Match the write is unconditional.
+	expectStatus(t, env.run(h.HandleCollectionItemUpdate, func(w io.Writer) error {
+		return protocol.WriteCollectionItemUpdateCommand(w, "items", "k", []byte(`{"c":5}`))
+	}), protocol.StatusOk)
+	if doc := env.storedDoc("items", "k"); doc["b"] != float64(4) || doc["c"] != float64(5) || store.VersionOf(doc) != 4 {
+		t.Fatalf("document = %v, want b = 4 and c = 5 at version 4", doc)
+	}
+}
+
+func TestUpdateLoggedWithoutIfMatchIsReplayed(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	env.setItem("items", "k", `{"a":1}`)
+
+	// An update logged before the If-Match field existed ends after its patch.
+	var cmd bytes.Buffer
+	protocol.WriteString(&cmd, "items")
+	protocol.WriteString(&cmd, "k")
+	protocol.WriteBytes(&cmd, []byte(`{"a":2}`))
+	env.handler().ApplyWalEntry(wal.WalEntry{CommandType: protocol.CmdCollectionItemUpdate, Payload: cmd.Bytes()})
+
+	if doc := env.storedDoc("items", "k"); doc["a"] != float64(2) {
+		t.Fatalf("replayed document = %v, want a = 2", doc)
+	}
+}
+
+func TestConcurrentUpdateManyLosesNoPatch(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	// A large document widens the window between reading and writing it back.
+	padding := make(map[string]int, 200)
+	for i := range 200 {
+		padding[fmt.Sprintf("p%d", i)] = i
+	}
+	doc, _ := json.Marshal(padding)
+	env.setItem("items", "k", string(doc))
+
+	const writers, rounds = 8, 100
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range writers {
+		h := env.handler()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for j := range rounds {
+				resp := env.run(h.HandleCollectionItemUpdateMany, func(w io.Writer) error {
+					return protocol.WriteCollectionItemUpdateManyCommand(w, "items", []byte(fmt.Sprintf(`[{"_id":"k","patch":{"w%d":%d}}]`, i, j)))
+				})
+				if resp.status != protocol.StatusOk {
+					t.Errorf("update many %d/%d: %d %s", i, j, resp.status, resp.msg)
+				}
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	stored := env.storedDoc("items", "k")
+	for i := range writers {
+		if stored[fmt.Sprintf("w%d", i)] != float64(rounds-1) {
+			t.Fatalf("last patch of writer %d was lost", i)
+		}
+	}
+	if v := store.VersionOf(stored); v != 1+writers*rounds {
+		t.Fatalf("version = %d, want %d: concurrent updates overwrote one another", v, 1+writers*rounds)
+	}
+}
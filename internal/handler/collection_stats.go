@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/protocol"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// opsWindowSeconds is the length of the sliding window used for the per-minute operation rates.
+const opsWindowSeconds = 60
+
+// opsBucket counts the operations recorded during one second of the sliding window.
+type opsBucket struct {
+	second int64
+	reads  uint64
+	writes uint64
+}
+
+// collectionOpStats holds the read/write counters of one collection.
+// Totals are plain atomics; the per-second ring buffer behind the rates is guarded by mu.
+type collectionOpStats struct {
+	reads  atomic.Uint64
+	writes atomic.Uint64
+
+	mu   sync.Mutex
+	ring [opsWindowSeconds]opsBucket
+}
+
+// CollectionStats is the response payload of the COLLECTION_STATS command.
+type CollectionStats struct {
+	Collection       string `json:"collection"`
+	ItemCount        int    `json:"item_count"`
+	ReadsTotal       uint64 `json:"reads_total"`
+	WritesTotal      uint64 `json:"writes_total"`
+	ReadsLastMinute  uint64 `json:"reads_last_minute"`
+	WritesLastMinute uint64 `json:"writes_last_minute"`
+}
+
+// collectionOps maps collection names to their *collectionOpStats. Counters live for the
+// lifetime of the process and are dropped when the collection is deleted.
+var collectionOps sync.Map
+
+func collectionOpsFor(collectionName string) *collectionOpStats {
+	if stats, ok := collectionOps.Load(collectionName); ok {
+		return stats.(*collectionOpStats)
+	}
+	stats, _ := collectionOps.LoadOrStore(collectionName, &collectionOpStats{})
+	return stats.(*collectionOpStats)
+}
+
+// recordCollectionRead counts one client read command against a collection.
+func recordCollectionRead(collectionName string) {
+	collectionOpsFor(collectionName).record(time.Now(), true)
+}
+
+// recordCollectionWrite counts one client write command against a collection.
+func recordCollectionWrite(collectionName string) {
+	collectionOpsFor(collectionName).record(time.Now(), false)
+}
+
+// forgetCollectionOps drops the counters of a deleted collection.
+func forgetCollectionOps(collectionName string) {
+	collectionOps.Delete(collectionName)
+}
+
+func (s *collectionOpStats) record(now time.Time, read bool) {
+	if read {
+		s.reads.Add(1)
+	} else {
+		s.writes.Add(1)
+	}
+
+	second := now.Unix()
+	s.mu.Lock()
+	bucket := &s.ring[second%opsWindowSeconds]
+	if bucket.second != second {
+		*bucket = opsBucket{second: second}
+	}
+	if read {
+		bucket.reads++
+	} else {
+		bucket.writes++
+	}
+	s.mu.Unlock()
+}
+
+// lastMinute sums the buckets that fall inside the sliding window ending at now.
+func (s *collectionOpStats) lastMinute(now time.Time) (reads, writes uint64) {
+	cutoff := now.Unix() - opsWindowSeconds
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, bucket := range s.ring {
+		if bucket.second > cutoff {
+			reads += bucket.reads
+			writes += bucket.writes
+		}
+	}
+	return reads, writes
+}
+
+// snapshotCollectionStats returns the current counters of a collection. Collections that
+// have not been accessed since startup report zeros.
+func snapshotCollectionStats(collectionName string) CollectionStats {
+	result := CollectionStats{Collection: collectionName}
+	value, ok := collectionOps.Load(collectionName)
+	if !ok {
+		return result
+	}
+	stats := value.(*collectionOpStats)
+	result.ReadsTotal = stats.reads.Load()
+	result.WritesTotal = stats.writes.Load()
+	result.ReadsLastMinute, result.WritesLastMinute = stats.lastMinute(time.Now())
+	return result
+}
+
+// handleCollectionStats processes the CmdCollectionStats command.
+// Counters only cover client commands since startup; WAL replay is not counted.
+func (h *ConnectionHandler) handleCollectionStats(r io.Reader, conn net.Conn) {
+	collectionName, err := protocol.ReadCollectionStatsCommand(r)
+	if err != nil {
+		slog.Error("Failed to read COLLECTION_STATS command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid COLLECTION_STATS command format", nil)
+		return
+	}
+	if collectionName == "" {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name cannot be empty", nil)
+		return
+	}
+	if !h.hasPermission(collectionName, globalconst.PermissionMetadata) {
+		slog.Warn("Unauthorized collection stats attempt", "user", h.AuthenticatedUser, "collection", collectionName)
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have metadata permission for collection '%s'", collectionName), nil)
+		return
+	}
+	if !h.CollectionManager.CollectionExists(collectionName) {
+		protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist.", collectionName), nil)
+		return
+	}
+
+	stats := snapshotCollectionStats(collectionName)
+	stats.ItemCount = h.CollectionManager.GetCollection(collectionName).Size()
+
+	responseData, err := json.Marshal(stats)
+	if err != nil {
+		slog.Error("Failed to marshal collection stats", "collection", collectionName, "error", err)
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to marshal collection stats", nil)
+		return
+	}
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Stats for collection '%s' (%d reads, %d writes in the last minute).", collectionName, stats.ReadsLastMinute, stats.WritesLastMinute), responseData)
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"io"
+	"memory-tools/internal/protocol"
+	"testing"
+	"time"
+)
+
+func TestCollectionStatsCountsReadsAndWrites(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("stats_hot")
+	env.createTestCollection("stats_cold")
+	for _, key := range []string{"a", "b", "c"} {
+		env.setItem("stats_hot", key, `{"n":1}`)
+	}
+	for range 5 {
+		resp := env.run(env.handler().handleCollectionItemGet, func(w io.Writer) error {
+			return protocol.WriteCollectionItemGetCommand(w, "stats_hot", "a", nil)
+		})
+		expectStatus(t, resp, protocol.StatusOk)
+	}
+	resp := env.run(env.handler().HandleCollectionItemDelete, func(w io.Writer) error {
+		return protocol.WriteCollectionItemDeleteCommand(w, "stats_hot", "b")
+	})
+	expectStatus(t, resp, protocol.StatusOk)
+
+	stats := env.collectionStats("stats_hot")
+	want := CollectionStats{Collection: "stats_hot", ItemCount: 2, ReadsTotal: 5, WritesTotal: 4, ReadsLastMinute: 5, WritesLastMinute: 4}
+	if stats != want {
+		t.Fatalf("stats = %+v, want %+v", stats, want)
+	}
+	if stats := env.collectionStats("stats_cold"); stats.ReadsTotal != 0 || stats.WritesTotal != 0 {
+		t.Errorf("untouched collection has counters %+v", stats)
+	}
+
+	resp = env.run(env.handler().HandleCollectionDelete, func(w io.Writer) error {
+		return protocol.WriteCollectionDeleteCommand(w, "stats_hot")
+	})
+	expectStatus(t, resp, protocol.StatusOk)
+	env.createTestCollection("stats_hot")
+	if stats := env.collectionStats("stats_hot"); stats.ReadsTotal != 0 || stats.WritesTotal != 0 {
+		t.Errorf("recreated collection kept the counters of the deleted one: %+v", stats)
+	}
+}
+
+func TestCollectionOpStatsLastMinute(t *testing.T) {
+	var stats collectionOpStats
+	start := time.Unix(1_700_000_000, 0)
+	stats.record(start, true)
+	stats.record(start.Add(30*time.Second), false)
+	stats.record(start.Add(59*time.Second), true)
+
+	if reads, writes := stats.lastMinute(start.Add(59 * time.Second)); reads != 2 || writes != 1 {
+		t.Errorf("last minute = %d reads, %d writes; want 2 and 1", reads, writes)
+	}
+	// The window ends at now, so the first read falls out of it a minute later.
+	if reads, writes := stats.lastMinute(start.Add(60 * time.Second)); reads != 1 || writes != 1 {
+		t.Errorf("last minute after 60s = %d reads, %d writes; want 1 and 1", reads, writes)
+	}
+	if reads, writes := stats.lastMinute(start.Add(95 * time.Second)); reads != 1 || writes != 0 {
+		t.Errorf("last minute after 95s = %d reads, %d writes; want 1 and 0", reads, writes)
+	}
+	// A bucket reused a minute later starts from zero.
+	stats.record(start.Add(60*time.Second), false)
+	if reads, writes := stats.lastMinute(start.Add(60 * time.Second)); reads != 1 || writes != 2 {
+		t.Errorf("last minute after reuse = %d reads, %d writes; want 1 and 2", reads, writes)
+	}
+	if total := stats.reads.Load() + stats.writes.Load(); total != 4 {
+		t.Errorf("totals = %d operations, want 4", total)
+	}
+}
+
+// collectionStats returns the response of the COLLECTION_STATS command.
+func (e *testEnv) collectionStats(collectionName string) CollectionStats {
+	e.t.Helper()
+	resp := e.run(e.handler().handleCollectionStats, func(w io.Writer) error {
+		return protocol.WriteCollectionStatsCommand(w, collectionName)
+	})
+	expectStatus(e.t, resp, protocol.StatusOk)
+	var stats CollectionStats
+	if err := json.Unmarshal(resp.data, &stats); err != nil {
+		e.t.Fatal(err)
+	}
+	return stats
+}
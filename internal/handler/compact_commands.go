@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// finishedCompactJobTTL is how long a finished compact-all job stays available to status polls.
+const finishedCompactJobTTL = time.Hour
+
+// CompactJob tracks the progress of a background compaction of all collection files.
+type CompactJob struct {
+	ID                   string            `json:"job_id"`
+	State                string            `json:"state"` // "running" or "finished"
+	StartedAt            time.Time         `json:"started_at"`
+	FinishedAt           *time.Time        `json:"finished_at,omitempty"`
+	TotalCollections     int               `json:"total_collections"`
+	ProcessedCollections int               `json:"processed_collections"`
+	CompactedCollections int               `json:"compacted_collections"`
+	BytesReclaimed       int64             `json:"bytes_reclaimed"`
+	Errors               map[string]string `json:"errors,omitempty"` // Key: collection name, Value: error message.
+}
+
+// compactJobs is the registry of compact-all jobs, shared by all connections.
+var compactJobs = struct {
+	mu   sync.Mutex
+	jobs map[string]*CompactJob
+}{jobs: make(map[string]*CompactJob)}
+
+// startCompactJob registers a new compact-all job and runs it in the background.
+// If a job is already running, it is returned instead and started is false.
+func startCompactJob(cm *store.CollectionManager) (job CompactJob, started bool) {
+	compactJobs.mu.Lock()
+	defer compactJobs.mu.Unlock()
+
+	for id, existing := range compactJobs.jobs {
+		if existing.State == "running" {
+			return *existing, false
+		}
+		if time.Since(*existing.FinishedAt) > finishedCompactJobTTL {
+			delete(compactJobs.jobs, id)
+		}
+	}
+
+	newJob := &CompactJob{
+		ID:        uuid.New().String(),
+		State:     "running",
+		StartedAt: time.Now().UTC(),
+		Errors:    make(map[string]string),
+	}
+	compactJobs.jobs[newJob.ID] = newJob
+	go runCompactJob(cm, newJob)
+	return *newJob, true
+}
+
+// compactJobSnapshot returns a copy of a job's current progress.
+func compactJobSnapshot(id string) (CompactJob, bool) {
+	compactJobs.mu.Lock()
+	defer compactJobs.mu.Unlock()
+
+	job, ok := compactJobs.jobs[id]
+	if !ok {
+		return CompactJob{}, false
+	}
+	snapshot := *job
+	snapshot.Errors = make(map[string]string, len(job.Errors))
+	for name, msg := range job.Errors {
+		snapshot.Errors[name] = msg
+	}
+	return snapshot, true
+}
+
+// runCompactJob compacts every collection file, holding each collection's file lock while
+// its file is rewritten so it cannot interleave with a save.
+func runCompactJob(cm *store.CollectionManager, job *CompactJob) {
+	slog.Info("Compact-all job started", "job_id", job.ID)
+	collectionNames, err := persistence.ListCollectionFiles()
+
+	compactJobs.mu.Lock()
+	if err != nil {
+		job.Errors["*"] = fmt.Sprintf("failed to list collection files: %v", err)
+	}
+	job.TotalCollections = len(collectionNames)
+	compactJobs.mu.Unlock()
+
+	for _, name := range collectionNames {
+		fileLock := cm.GetFileLock(name)
+		fileLock.Lock()
+		reclaimed, err := persistence.CompactCollectionFile(name)
+		fileLock.Unlock()
+
+		compactJobs.mu.Lock()
+		job.ProcessedCollections++
+		if err != nil {
+			job.Errors[name] = err.Error()
+		} else {
+			job.CompactedCollections++
+			job.BytesReclaimed += reclaimed
+		}
+		compactJobs.mu.Unlock()
+
+		if err != nil {
+			slog.Error("Compact-all job failed to compact collection", "job_id", job.ID, "collection", name, "error", err)
+		}
+	}
+
+	compactJobs.mu.Lock()
+	finishedAt := time.Now().UTC()
+	job.FinishedAt = &finishedAt
+	job.State = "finished"
+	compactJobs.mu.Unlock()
+	slog.Info("Compact-all job finished", "job_id", job.ID, "compacted", job.CompactedCollections, "bytes_reclaimed", job.BytesReclaimed, "errors", len(job.Errors))
+}
+
+// handleCompactAll processes the CmdCompactAll command. It is root-only.
+// Compaction runs in the background; the response carries the job id to poll with COMPACT_STATUS.
+// Like a backup it only rewrites files without changing data, so it is not logged to the WAL.
+func (h *ConnectionHandler) handleCompactAll(r io.Reader, conn net.Conn) {
+	if !h.IsRoot {
+		slog.Warn("Unauthorized compact-all attempt", "user", h.AuthenticatedUser, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Only root can compact all collections.", nil)
+		return
+	}
+
+	job, started := startCompactJob(h.CollectionManager)
+	responseData, _ := json.Marshal(job)
+	if !started {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Compaction job '%s' is already running.", job.ID), responseData)
+		return
+	}
+	slog.Info("Compact-all job requested", "user", h.AuthenticatedUser, "job_id", job.ID)
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Compaction job '%s' started.", job.ID), responseData)
+}
+
+// handleCompactStatus processes the CmdCompactStatus command. It is root-only.
+func (h *ConnectionHandler) handleCompactStatus(r io.Reader, conn net.Conn) {
+	jobID, err := protocol.ReadCompactStatusCommand(r)
+	if err != nil {
+		slog.Error("Failed to read COMPACT_STATUS command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid COMPACT_STATUS command format", nil)
+		return
+	}
+	if !h.IsRoot {
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Only root can inspect compaction jobs.", nil)
+		return
+	}
+	if jobID == "" {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Job id cannot be empty", nil)
+		return
+	}
+
+	job, ok := compactJobSnapshot(jobID)
+	if !ok {
+		protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Compaction job '%s' does not exist or has expired", jobID), nil)
+		return
+	}
+	responseData, err := json.Marshal(job)
+	if err != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to marshal compaction job status", nil)
+		return
+	}
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Compaction job '%s' is %s (%d/%d collections).", job.ID, job.State, job.ProcessedCollections, job.TotalCollections), responseData)
+}
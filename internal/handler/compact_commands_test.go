@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/protocol"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func collectionFileSize(t *testing.T, name string) int64 {
+	t.Helper()
+	info, err := os.Stat(filepath.Join(globalconst.CollectionsDirName, name+globalconst.DBFileExtension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func TestCompactAllReclaimsTombstones(t *testing.T) {
+	env := newTestEnv(t)
+	persister := &persistence.CollectionPersisterImpl{}
+	names := []string{"alpha", "beta", "gamma"}
+	sizesBefore := make(map[string]int64)
+	for i, name := range names {
+		env.cm.GetCollection(name)
+		items := make(map[string][]byte)
+		for j := range 40 {
+			key := fmt.Sprintf("k%02d", j)
+			items[key] = fmt.Appendf(nil, `{"_id":"%s","payload":"%s"}`, key, strings.Repeat("x", 100))
+		}
+		if err := persister.WriteColdItems(name, items); err != nil {
+			t.Fatal(err)
+		}
+		// Each collection gets a different number of tombstones.
+		for j := range 10 * (i + 1) {
+			if deleted, err := persistence.DeleteColdItem(name, fmt.Sprintf("k%02d", j)); err != nil || !deleted {
+				t.Fatalf("deleting %s/k%02d: %v %v", name, j, deleted, err)
+			}
+		}
+		sizesBefore[name] = collectionFileSize(t, name)
+	}
+
+	h := env.handler()
+	resp := env.run(h.handleCompactAll, protocol.WriteCompactAllCommand)
+	expectStatus(t, resp, protocol.StatusOk)
+	var job CompactJob
+	if err := json.Unmarshal(resp.data, &job); err != nil || job.ID == "" {
+		t.Fatalf("compact-all response %s: %v", resp.data, err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for job.State != "finished" {
+		if time.Now().After(deadline) {
+			t.Fatalf("compaction job still %s after 10s", job.State)
+		}
+		time.Sleep(5 * time.Millisecond)
+		resp := env.run(h.handleCompactStatus, func(w io.Writer) error { return protocol.WriteCompactStatusCommand(w, job.ID) })
+		expectStatus(t, resp, protocol.StatusOk)
+		job = CompactJob{}
+		if err := json.Unmarshal(resp.data, &job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wantReclaimed int64
+	for _, name := range names {
+		reclaimed := sizesBefore[name] - collectionFileSize(t, name)
+		if reclaimed <= 0 {
+			t.Errorf("%s did not shrink", name)
+		}
+		wantReclaimed += reclaimed
+	}
+	if job.TotalCollections != len(names) || job.CompactedCollections != len(names) || len(job.Errors) != 0 {
+		t.Errorf("job = %+v, want %d collections compacted without errors", job, len(names))
+	}
+	if job.BytesReclaimed != wantReclaimed {
+		t.Errorf("bytes reclaimed = %d, want %d", job.BytesReclaimed, wantReclaimed)
+	}
+
+	if _, found, _ := persistence.GetColdItem("gamma", "k29"); found {
+		t.Error("deleted item is readable after compaction")
+	}
+	if _, found, _ := persistence.GetColdItem("gamma", "k30"); !found {
+		t.Error("live item was lost by compaction")
+	}
+}
+
+func TestCompactStatusOfUnknownJob(t *testing.T) {
+	env := newTestEnv(t)
+	resp := env.run(env.handler().handleCompactStatus, func(w io.Writer) error { return protocol.WriteCompactStatusCommand(w, "no-such-job") })
+	expectStatus(t, resp, protocol.StatusNotFound)
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// drainPollInterval is how often DrainConnections checks whether every connection has closed.
+const drainPollInterval = 50 * time.Millisecond
+
+// connectionTracker follows the connections being served, so a shutdown can let each one
+// finish its current command and close those that are waiting for one.
+var connectionTracker = struct {
+	mu       sync.Mutex
+	draining bool
+	busy     map[net.Conn]bool // Whether the connection is running a command rather than waiting for one.
+}{busy: make(map[net.Conn]bool)}
+
+// trackConnection registers a connection as busy until it first waits for a command. It returns
+// false if the server is shutting down, in which case the connection must not be served.
+func trackConnection(conn net.Conn) bool {
+	connectionTracker.mu.Lock()
+	defer connectionTracker.mu.Unlock()
+	if connectionTracker.draining {
+		return false
+	}
+	connectionTracker.busy[conn] = true
+	return true
+}
+
+// untrackConnection removes a connection registered by trackConnection.
+func untrackConnection(conn net.Conn) {
+	connectionTracker.mu.Lock()
+	delete(connectionTracker.busy, conn)
+	connectionTracker.mu.Unlock()
+}
+
+// awaitCommand marks a connection as waiting for its next command and arms the idle timeout.
+// It returns false once the server is draining, so the connection closes between commands.
+func awaitCommand(conn net.Conn, idleTimeout time.Duration) bool {
+	connectionTracker.mu.Lock()
+	defer connectionTracker.mu.Unlock()
+	if connectionTracker.draining {
+		return false
+	}
+	connectionTracker.busy[conn] = false
+	if idleTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+	}
+	return true
+}
+
+// beginCommand marks a connection as running a command. Its read deadline is cleared, as
+// neither the idle timeout nor a shutdown interrupts reading the command's payload.
+func beginCommand(conn net.Conn) {
+	connectionTracker.mu.Lock()
+	defer connectionTracker.mu.Unlock()
+	connectionTracker.busy[conn] = true
+	conn.SetReadDeadline(time.Time{})
+}
+
+// isDraining reports whether DrainConnections has been called.
+func isDraining() bool {
+	connectionTracker.mu.Lock()
+	defer connectionTracker.mu.Unlock()
+	return connectionTracker.draining
+}
+
+// DrainConnections stops serving commands for a shutdown. Connections waiting for a command are
+// closed right away and the others as soon as their current command finishes; connections still
+// queued for a worker are closed without being served. It waits up to timeout for every accepted
+// connection to close and then force-closes the rest. It returns how many connections closed
+// on their own and how many were still open at the timeout.
+func DrainConnections(timeout time.Duration) (drained, forced int) {
+	open := activeConnections.Load()
+
+	connectionTracker.mu.Lock()
+	connectionTracker.draining = true
+	for conn, busy := range connectionTracker.busy {
+		if !busy {
+			// Unblocks the wait for the next command.
+			conn.SetReadDeadline(time.Now())
+		}
+	}
+	connectionTracker.mu.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for activeConnections.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+
+	remaining := activeConnections.Load()
+	if remaining > 0 {
+		connectionTracker.mu.Lock()
+		for conn := range connectionTracker.busy {
+			conn.Close()
+		}
+		connectionTracker.mu.Unlock()
+	}
+	if open < remaining {
+		open = remaining
+	}
+	return int(open - remaining), int(remaining)
+}
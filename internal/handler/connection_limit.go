@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"log/slog"
+	"memory-tools/internal/protocol"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// connectionRejectTimeout bounds the TLS handshake and the busy response of a connection
+// turned away by the connection limit, so a slow client cannot hold on to it.
+const connectionRejectTimeout = 5 * time.Second
+
+var (
+	// activeConnections counts the accepted connections that are not closed yet, including
+	// those still waiting for a free worker.
+	activeConnections atomic.Int64
+	// rejectedConnections counts the connections turned away since startup.
+	rejectedConnections atomic.Uint64
+	// maxConnections bounds activeConnections; 0 means unlimited.
+	maxConnections atomic.Int64
+)
+
+// SetMaxConnections limits how many connections may be open at once. A limit of 0 or less
+// removes it.
+func SetMaxConnections(limit int) {
+	maxConnections.Store(int64(max(limit, 0)))
+}
+
+// AcquireConnection counts a newly accepted connection. Over the limit, the connection gets a
+// busy response and is closed in the background, and false is returned; otherwise
+// ReleaseConnection must be called once the connection is done.
+func AcquireConnection(conn net.Conn) bool {
+	active := activeConnections.Add(1)
+	if limit := maxConnections.Load(); limit > 0 && active > limit {
+		activeConnections.Add(-1)
+		rejectedConnections.Add(1)
+		slog.Warn("Connection rejected: too many connections", "remote_addr", conn.RemoteAddr().String(), "max_connections", limit)
+		go rejectConnection(conn)
+		return false
+	}
+	return true
+}
+
+// ReleaseConnection uncounts a connection admitted by AcquireConnection.
+func ReleaseConnection() {
+	activeConnections.Add(-1)
+}
+
+// rejectConnection tells a connection over the limit that the server is busy and closes it.
+func rejectConnection(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(connectionRejectTimeout))
+	protocol.WriteResponse(conn, protocol.StatusError, "BUSY: Server busy: too many open connections. Please retry later.", nil)
+}
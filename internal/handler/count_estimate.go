@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"math"
+	"math/rand/v2"
+	"memory-tools/internal/store"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	// defaultEstimateSampleRate is the fraction of documents evaluated by an estimated count.
+	defaultEstimateSampleRate = 0.1
+	// minEstimateSampleSize is the smallest expected sample worth extrapolating from. Collections
+	// of up to this many documents are counted exactly, which costs little at that size.
+	minEstimateSampleSize = 1000
+	// estimateZScore gives the 95% confidence level of the reported interval.
+	estimateZScore = 1.96
+)
+
+// CountEstimate is the result of a query run with "count" and "estimate". Like an exact count it
+// carries the number of matches under "count", here estimated, with Lower/Upper bounding it at the
+// reported confidence level.
+type CountEstimate struct {
+	Count      int     `json:"count"`
+	Lower      int     `json:"lower"`
+	Upper      int     `json:"upper"`
+	Confidence float64 `json:"confidence"`
+	Sampled    int     `json:"sampled"`
+	Total      int     `json:"total"`
+	Exact      bool    `json:"exact"` // The whole collection was evaluated, so the count is not extrapolated.
+}
+
+// isEstimatedCount reports whether a query asks for an estimated count that can be sampled.
+// Counts combined with aggregations, grouping, distinct or lookups are always computed exactly.
+func isEstimatedCount(query *Query) bool {
+	return query.Count && query.Estimate && len(query.Aggregations) == 0 && len(query.GroupBy) == 0 &&
+		query.Distinct == "" && len(query.Lookups) == 0
+}
+
+// estimateCount evaluates the filter on a random sample of the collection's in-memory documents and
+// extrapolates the number of matches. Skipped documents are never decoded, which is where the time
+// is saved. The interval is a Wilson score interval, which stays meaningful when few or none of the
+// sampled documents match. Cold documents are not sampled.
+func (h *ConnectionHandler) estimateCount(colStore store.DataStore, filter map[string]any, sampleRate float64, stats *QueryStats) CountEstimate {
+	if sampleRate <= 0 {
+		sampleRate = defaultEstimateSampleRate
+	}
+	// The rate is raised so the expected sample never drops below the minimum; on small
+	// collections that means evaluating every document.
+	if size := colStore.Size(); size > 0 {
+		sampleRate = math.Max(sampleRate, float64(minEstimateSampleSize)/float64(size))
+	}
+
+	scanStart := time.Now()
+	total, sampled, matched := 0, 0, 0
+	colStore.StreamAll(func(key string, value []byte) bool {
+		total++
+		if sampleRate < 1 && rand.Float64() >= sampleRate {
+			return true
+		}
+		sampled++
+		var doc map[string]any
+		if err := jsoniter.Unmarshal(value, &doc); err != nil {
+			return true
+		}
+		if h.matchFilter(doc, filter) {
+			matched++
+		}
+		return true
+	})
+	if stats != nil {
+		stats.Path = "estimate"
+		stats.HotScanned = sampled
+		stats.HotMatched = matched
+		stats.Returned = 1
+		stats.recordPhase("hot_scan", scanStart)
+	}
+
+	result := CountEstimate{Confidence: 0.95, Sampled: sampled, Total: total}
+	if sampled == total {
+		result.Count, result.Lower, result.Upper, result.Exact = matched, matched, matched, true
+		return result
+	}
+	// The sampled matches and non-matches are certain, so the bounds never go past them.
+	minMatches, maxMatches := matched, total-(sampled-matched)
+	if sampled == 0 {
+		result.Lower, result.Upper = minMatches, maxMatches
+		return result
+	}
+
+	n := float64(sampled)
+	p := float64(matched) / n
+	z2 := estimateZScore * estimateZScore
+	center := (p + z2/(2*n)) / (1 + z2/n)
+	margin := estimateZScore / (1 + z2/n) * math.Sqrt(p*(1-p)/n+z2/(4*n*n))
+
+	result.Count = clampInt(int(math.Round(p*float64(total))), minMatches, maxMatches)
+	result.Lower = clampInt(int(math.Floor((center-margin)*float64(total))), minMatches, maxMatches)
+	result.Upper = clampInt(int(math.Ceil((center+margin)*float64(total))), minMatches, maxMatches)
+	return result
+}
+
+// clampInt limits v to the range [lo, hi].
+func clampInt(v, lo, hi int) int {
+	return min(max(v, lo), hi)
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"memory-tools/internal/protocol"
+	"testing"
+)
+
+// estimate runs an estimated count query and returns its result.
+func (e *testEnv) estimate(collectionName, query string) CountEstimate {
+	e.t.Helper()
+	resp := e.run(e.handler().handleCollectionQuery, func(w io.Writer) error {
+		return protocol.WriteCollectionQueryCommand(w, collectionName, []byte(query))
+	})
+	expectStatus(e.t, resp, protocol.StatusOk)
+	var result CountEstimate
+	if err := json.Unmarshal(resp.data, &result); err != nil {
+		e.t.Fatalf("estimate %q: %v", resp.data, err)
+	}
+	return result
+}
+
+func TestEstimatedCountIsWithinTolerance(t *testing.T) {
+	const total, exact = 40000, 4000
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	colStore := env.cm.GetCollection("items")
+	for i := range total {
+		key := fmt.Sprintf("k%d", i)
+		colStore.Set(key, fmt.Appendf(nil, `{"_id":%q,"bucket":%d}`, key, i%10), 0)
+	}
+
+	est := env.estimate("items", `{"filter":{"field":"bucket","op":"=","value":3},"count":true,"estimate":true,"sample_rate":0.1}`)
+	if est.Exact || est.Total != total {
+		t.Fatalf("estimate = %+v, want a sampled estimate over %d documents", est, total)
+	}
+	// About 4000 documents are sampled, so a 20% error is over four standard deviations away.
+	if math.Abs(float64(est.Count-exact)) > 0.2*exact {
+		t.Errorf("estimated count %d is not within 20%% of %d", est.Count, exact)
+	}
+	if est.Lower > est.Count || est.Count > est.Upper || est.Upper-est.Lower > exact/2 {
+		t.Errorf("interval [%d, %d] around %d is inconsistent or too wide", est.Lower, est.Upper, est.Count)
+	}
+	if est.Sampled < total/20 || est.Sampled > total/5 {
+		t.Errorf("sampled %d documents at a 10%% rate out of %d", est.Sampled, total)
+	}
+
+	// A sample that would fall under the minimum size evaluates every document.
+	env.createTestCollection("small")
+	for i := range 50 {
+		env.setItem("small", fmt.Sprintf("k%d", i), fmt.Sprintf(`{"bucket":%d}`, i%10))
+	}
+	est = env.estimate("small", `{"filter":{"field":"bucket","op":"<","value":2},"count":true,"estimate":true,"sample_rate":0.01}`)
+	if want := (CountEstimate{Count: 10, Lower: 10, Upper: 10, Confidence: 0.95, Sampled: 50, Total: 50, Exact: true}); est != want {
+		t.Errorf("estimate on a small collection = %+v, want %+v", est, want)
+	}
+}
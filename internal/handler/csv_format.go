@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"memory-tools/internal/protocol"
+	"sort"
+	"strconv"
+	"strings"
+
+	stdjson "encoding/json"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// csvJSON decodes stored documents for CSV output, keeping numbers as their original literals so
+// integers beyond 2^53 are written exactly.
+var csvJSON = jsoniter.Config{UseNumber: true}.Froze()
+
+// csvResults is query results ready to be sent as RFC 4180 CSV with a header row. Nested objects
+// become dotted column names (e.g. "address.city") and arrays are written as JSON. The header
+// follows the projection when one is given, otherwise it is the sorted union of all flattened
+// keys. Rows are encoded from the result documents as they are written, so the CSV is never
+// held in memory: it is encoded once to measure the response, then again to send it.
+type csvResults struct {
+	count   int
+	row     func(i int) (any, error) // A document, or a single value written in a "value" column.
+	headers []string
+	size    int
+}
+
+// newCSVResults prepares query results for writeResponse. It returns an error, before anything is
+// sent, if a result cannot be encoded.
+func newCSVResults(results any, projection []string) (*csvResults, error) {
+	c := &csvResults{headers: projection}
+	switch v := results.(type) {
+	case nil:
+	case []stdjson.RawMessage:
+		c.count = len(v)
+		c.row = func(i int) (any, error) {
+			var doc any
+			if err := csvJSON.Unmarshal(v[i], &doc); err != nil {
+				return nil, fmt.Errorf("failed to decode result: %w", err)
+			}
+			return doc, nil
+		}
+	case []map[string]any:
+		c.count = len(v)
+		c.row = func(i int) (any, error) { return v[i], nil }
+	case []any:
+		c.count = len(v)
+		c.row = func(i int) (any, error) { return v[i], nil }
+	default:
+		// Aggregations, distinct counts and other small results share the generic shape of JSON.
+		raw, err := jsoniter.Marshal(results)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal results: %w", err)
+		}
+		var generic any
+		if err := csvJSON.Unmarshal(raw, &generic); err != nil {
+			return nil, fmt.Errorf("failed to decode results: %w", err)
+		}
+		items, ok := generic.([]any)
+		if !ok {
+			items = []any{generic}
+		}
+		c.count = len(items)
+		c.row = func(i int) (any, error) { return items[i], nil }
+	}
+
+	if len(c.headers) == 0 {
+		headerSet := make(map[string]struct{})
+		for i := range c.count {
+			item, err := c.row(i)
+			if err != nil {
+				return nil, err
+			}
+			if doc, ok := item.(map[string]any); ok {
+				csvColumns("", doc, headerSet)
+			} else {
+				headerSet["value"] = struct{}{}
+			}
+		}
+		c.headers = make([]string, 0, len(headerSet))
+		for k := range headerSet {
+			c.headers = append(c.headers, k)
+		}
+		sort.Strings(c.headers)
+	}
+
+	var counter byteCounter
+	if err := c.encode(&counter); err != nil {
+		return nil, err
+	}
+	c.size = int(counter)
+	return c, nil
+}
+
+// writeResponse sends the CSV as the data of a response.
+func (c *csvResults) writeResponse(w io.Writer, status protocol.ResponseStatus, msg string) error {
+	if err := protocol.WriteResponseHeader(w, status, msg, c.size); err != nil {
+		return err
+	}
+	counter := byteCounter(0)
+	if err := c.encode(io.MultiWriter(w, &counter)); err != nil {
+		return err
+	}
+	if int(counter) != c.size {
+		return fmt.Errorf("CSV output changed size while being sent: %d bytes instead of %d", counter, c.size)
+	}
+	return nil
+}
+
+// encode writes the header row and one row per result to w.
+func (c *csvResults) encode(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = true
+	if err := cw.Write(c.headers); err != nil {
+		return fmt.Errorf("failed to write CSV header: %w", err)
+	}
+	record := make([]string, len(c.headers))
+	for i := range c.count {
+		item, err := c.row(i)
+		if err != nil {
+			return err
+		}
+		doc, isDoc := item.(map[string]any)
+		for j, h := range c.headers {
+			switch {
+			case isDoc:
+				record[j] = csvCell(doc, h)
+			case h == "value":
+				record[j] = csvCellValue(item)
+			default:
+				record[j] = ""
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return fmt.Errorf("failed to write CSV row: %w", err)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("failed to flush CSV output: %w", err)
+	}
+	return nil
+}
+
+// byteCounter is an io.Writer that only counts the bytes written to it.
+type byteCounter int
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// csvColumns adds the flattened column names of a document's leaves to columns.
+func csvColumns(prefix string, doc map[string]any, columns map[string]struct{}) {
+	for k, v := range doc {
+		path := joinFieldPath(prefix, k)
+		if nested, ok := v.(map[string]any); ok && len(nested) > 0 {
+			csvColumns(path, nested, columns)
+			continue
+		}
+		columns[path] = struct{}{}
+	}
+}
+
+// csvCell returns the cell of a document in a flattened column, or an empty cell if the column is
+// not one of the document's leaves. Field names may hold dots themselves, so every way of
+// splitting the column into nested fields is tried.
+func csvCell(doc map[string]any, column string) string {
+	if v, ok := doc[column]; ok {
+		if nested, isMap := v.(map[string]any); !isMap || len(nested) == 0 {
+			return csvCellValue(v)
+		}
+	}
+	for i := strings.IndexByte(column, '.'); i >= 0; {
+		if nested, ok := doc[column[:i]].(map[string]any); ok && len(nested) > 0 {
+			if cell := csvCell(nested, column[i+1:]); cell != "" {
+				return cell
+			}
+		}
+		next := strings.IndexByte(column[i+1:], '.')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return ""
+}
+
+// csvCellValue renders a single JSON value as a CSV cell.
+func csvCellValue(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case stdjson.Number:
+		return val.String()
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	default:
+		b, err := jsoniter.Marshal(val)
+		if err != nil {
+			return fmt.Sprintf("%v", val)
+		}
+		return string(b)
+	}
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"bytes"
+	"memory-tools/internal/protocol"
+	"testing"
+
+	stdjson "encoding/json"
+)
+
+// csvResponse sends results as a CSV response and returns its data.
+func csvResponse(t *testing.T, results any, projection []string) string {
+	t.Helper()
+	c, err := newCSVResults(results, projection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := c.writeResponse(&out, protocol.StatusOk, "OK"); err != nil {
+		t.Fatal(err)
+	}
+	resp := readTestResponse(t, out.Bytes())
+	if resp.msg != "OK" || len(resp.data) != out.Len()-11 {
+		t.Fatalf("response framing is wrong: %d bytes of data in %d", len(resp.data), out.Len())
+	}
+	return string(resp.data)
+}
+
+func TestCSVFlattensStoredDocuments(t *testing.T) {
+	results := []stdjson.RawMessage{
+		[]byte(`{"_id":"a","n":9007199254740993,"address":{"city":"Oslo"},"tags":["x","y"]}`),
+		[]byte(`{"_id":"b","address":{},"note":"say \"hi\""}`),
+	}
+	want := "_id,address,address.city,n,note,tags\r\n" +
+		"a,,Oslo,9007199254740993,,\"[\"\"x\"\",\"\"y\"\"]\"\r\n" +
+		"b,{},,,\"say \"\"hi\"\"\",\r\n"
+	if got := csvResponse(t, results, nil); got != want {
+		t.Fatalf("CSV =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestCSVFollowsProjection(t *testing.T) {
+	results := []map[string]any{
+		{"name": "Ada", "address": map[string]any{"city": "London"}},
+		{"name": "Bob"},
+	}
+	want := "address.city,name\r\nLondon,Ada\r\n,Bob\r\n"
+	if got := csvResponse(t, results, []string{"address.city", "name"}); got != want {
+		t.Fatalf("CSV = %q, want %q", got, want)
+	}
+}
+
+func TestCSVWritesValuesInOneColumn(t *testing.T) {
+	want := "value\r\nred\r\n3\r\n"
+	if got := csvResponse(t, []any{"red", float64(3)}, nil); got != want {
+		t.Fatalf("CSV = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/protocol"
+	"net"
+	"time"
+)
+
+// HandleCollectionSetDefaultTTL processes the CmdCollectionSetDefaultTTL command. It is a write operation.
+// The default TTL is kept in the collection's settings and applies to items set afterwards without a
+// TTL of their own; items already stored keep their expiry. A zero TTL removes the default.
+func (h *ConnectionHandler) HandleCollectionSetDefaultTTL(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	collectionName, ttl, err := protocol.ReadCollectionSetDefaultTTLCommand(r)
+	if err != nil {
+		slog.Error("Failed to read SET_COLLECTION_DEFAULT_TTL command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid SET_COLLECTION_DEFAULT_TTL command format", nil)
+		}
+		return
+	}
+	if collectionName == "" {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name cannot be empty", nil)
+		}
+		return
+	}
+	if collectionName == globalconst.SystemCollectionName {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "A default TTL cannot be set on the system collection", nil)
+		}
+		return
+	}
+	if ttl < 0 {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Default TTL cannot be negative", nil)
+		}
+		return
+	}
+
+	if conn != nil {
+		if !h.hasPermission(collectionName, globalconst.PermissionAdmin) {
+			slog.Warn("Unauthorized collection default TTL change attempt", "user", h.AuthenticatedUser, "collection", collectionName)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have admin permission for collection '%s'", collectionName), nil)
+			return
+		}
+	}
+
+	if !h.CollectionManager.CollectionExists(collectionName) {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist", collectionName), nil)
+		}
+		return
+	}
+
+	meta := h.CollectionManager.GetCollectionMeta(collectionName)
+	meta.DefaultTTLSeconds = int64(ttl / time.Second)
+	if err := h.CollectionManager.SaveCollectionMeta(collectionName, meta); err != nil {
+		slog.Error("Failed to save collection settings", "collection", collectionName, "error", err)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusError, "Failed to save collection settings", nil)
+		}
+		return
+	}
+
+	slog.Info("Collection default TTL changed", "user", h.AuthenticatedUser, "collection", collectionName, "ttl", ttl)
+	if conn != nil {
+		msg := fmt.Sprintf("OK: Items set in collection '%s' without a TTL now expire after %s.", collectionName, ttl)
+		if ttl == 0 {
+			msg = fmt.Sprintf("OK: Default TTL removed from collection '%s'.", collectionName)
+		}
+		protocol.WriteResponse(conn, protocol.StatusOk, msg, nil)
+	}
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/protocol"
+	"net"
+	"reflect"
+)
+
+// DocumentDiff describes the differences between two documents.
+// Field names use dot notation for nested objects (e.g. "address.city").
+type DocumentDiff struct {
+	Added   map[string]any         `json:"added"`
+	Removed map[string]any         `json:"removed"`
+	Changed map[string]FieldChange `json:"changed"`
+}
+
+// FieldChange holds the old and new value of a field present in both documents.
+type FieldChange struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+// handleCollectionItemDiff processes the CmdCollectionItemDiff command. It is a read-only operation.
+func (h *ConnectionHandler) handleCollectionItemDiff(r io.Reader, conn net.Conn) {
+	if h.CurrentTransactionID != "" {
+		protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Read operations like DIFF are not supported inside a transaction in this version.", nil)
+		return
+	}
+	collectionName, keyA, keyB, document, err := protocol.ReadCollectionItemDiffCommand(r)
+	if err != nil {
+		slog.Error("Failed to read DIFF_ITEMS command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid COLLECTION_ITEM_DIFF command format", nil)
+		return
+	}
+	if collectionName == "" || keyA == "" || (keyB == "" && len(document) == 0) {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name, first key, and a second key or document are required", nil)
+		return
+	}
+	if collectionName == globalconst.SystemCollectionName {
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Diffing documents of the system collection is not allowed", nil)
+		return
+	}
+	if !h.hasPermission(collectionName, globalconst.PermissionRead) {
+		slog.Warn("Unauthorized collection item diff attempt", "user", h.AuthenticatedUser, "collection", collectionName)
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have read permission for collection '%s'", collectionName), nil)
+		return
+	}
+	if !h.CollectionManager.CollectionExists(collectionName) {
+		protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist.", collectionName), nil)
+		return
+	}
+	recordCollectionRead(collectionName)
+
+	docA, found, err := h.fetchDocument(collectionName, keyA)
+	if err != nil {
+		slog.Error("Failed to fetch document for diff", "collection", collectionName, "key", keyA, "error", err)
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to read document for diff", nil)
+		return
+	}
+	if !found {
+		protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Key '%s' not found in collection '%s'", keyA, collectionName), nil)
+		return
+	}
+
+	var docB map[string]any
+	if keyB != "" {
+		docB, found, err = h.fetchDocument(collectionName, keyB)
+		if err != nil {
+			slog.Error("Failed to fetch document for diff", "collection", collectionName, "key", keyB, "error", err)
+			protocol.WriteResponse(conn, protocol.StatusError, "Failed to read document for diff", nil)
+			return
+		}
+		if !found {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Key '%s' not found in collection '%s'", keyB, collectionName), nil)
+			return
+		}
+	} else if err := json.Unmarshal(document, &docB); err != nil {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Invalid document. Must be a JSON object.", nil)
+		return
+	}
+
+	diff := diffDocuments(docA, docB)
+	responseData, err := json.Marshal(diff)
+	if err != nil {
+		slog.Error("Failed to marshal document diff", "collection", collectionName, "error", err)
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to marshal diff", nil)
+		return
+	}
+
+	slog.Debug("Documents diffed", "user", h.AuthenticatedUser, "collection", collectionName, "key_a", keyA, "key_b", keyB, "added", len(diff.Added), "removed", len(diff.Removed), "changed", len(diff.Changed))
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: %d added, %d removed, %d changed", len(diff.Added), len(diff.Removed), len(diff.Changed)), responseData)
+}
+
+// fetchDocument retrieves a document from RAM, falling back to the collection's file on disk.
+// Inside a transaction, the transaction's own queued writes take precedence.
+// Cold items read often enough are promoted back to RAM (see SetColdPromotionThreshold).
+func (h *ConnectionHandler) fetchDocument(collectionName, key string) (map[string]any, bool, error) {
+	value, found, pending, err := h.pendingValue(collectionName, key)
+	if err != nil || (pending && !found) {
+		return nil, false, err
+	}
+	if !pending {
+		value, found = h.CollectionManager.GetCollection(collectionName).Get(key)
+	}
+	if !found {
+		value, found, err = persistence.GetColdItem(collectionName, key)
+		if err != nil || !found {
+			return nil, false, err
+		}
+		if recordColdRead(collectionName, key) {
+			promoteColdItem(h.CollectionManager, collectionName, key)
+		}
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(value, &doc); err != nil {
+		return nil, false, fmt.Errorf("failed to unmarshal document '%s': %w", key, err)
+	}
+	return doc, true, nil
+}
+
+// diffDocuments computes the fields added, removed, and changed going from docA to docB.
+// The _id field is ignored, as it always differs between two distinct documents.
+func diffDocuments(docA, docB map[string]any) DocumentDiff {
+	diff := DocumentDiff{
+		Added:   make(map[string]any),
+		Removed: make(map[string]any),
+		Changed: make(map[string]FieldChange),
+	}
+	delete(docA, globalconst.ID)
+	delete(docB, globalconst.ID)
+	diffMaps("", docA, docB, &diff)
+	return diff
+}
+
+// diffMaps recursively compares two maps, recording differences under the given path prefix.
+func diffMaps(prefix string, a, b map[string]any, diff *DocumentDiff) {
+	for k, oldVal := range a {
+		path := joinFieldPath(prefix, k)
+		newVal, ok := b[k]
+		if !ok {
+			diff.Removed[path] = oldVal
+			continue
+		}
+		oldMap, oldIsMap := oldVal.(map[string]any)
+		newMap, newIsMap := newVal.(map[string]any)
+		if oldIsMap && newIsMap {
+			diffMaps(path, oldMap, newMap, diff)
+			continue
+		}
+		if !reflect.DeepEqual(oldVal, newVal) {
+			diff.Changed[path] = FieldChange{Old: oldVal, New: newVal}
+		}
+	}
+	for k, newVal := range b {
+		if _, ok := a[k]; !ok {
+			diff.Added[joinFieldPath(prefix, k)] = newVal
+		}
+	}
+}
+
+// joinFieldPath builds a dotted field path.
+func joinFieldPath(prefix, field string) string {
+	if prefix == "" {
+		return field
+	}
+	return prefix + "." + field
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"io"
+	"memory-tools/internal/protocol"
+	"reflect"
+	"testing"
+)
+
+func TestDiffDocuments(t *testing.T) {
+	docA := map[string]any{
+		"_id":     "a",
+		"name":    "Ada",
+		"age":     float64(36),
+		"address": map[string]any{"city": "London", "zip": "N1"},
+	}
+	docB := map[string]any{
+		"_id":     "b",
+		"name":    "Ada",
+		"email":   "ada@example.com",
+		"address": map[string]any{"city": "Paris", "zip": "N1", "country": "FR"},
+	}
+
+	got := diffDocuments(docA, docB)
+	want := DocumentDiff{
+		Added:   map[string]any{"email": "ada@example.com", "address.country": "FR"},
+		Removed: map[string]any{"age": float64(36)},
+		Changed: map[string]FieldChange{"address.city": {Old: "London", New: "Paris"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("diff = %+v, want %+v", got, want)
+	}
+}
+
+func TestDiffReportsObjectReplacedByValue(t *testing.T) {
+	got := diffDocuments(map[string]any{"tags": map[string]any{"a": true}}, map[string]any{"tags": []any{"a"}})
+	if len(got.Changed) != 1 || len(got.Added) != 0 || len(got.Removed) != 0 {
+		t.Fatalf("diff = %+v, want only 'tags' changed", got)
+	}
+}
+
+func TestDiffCommandAgainstDocument(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("people")
+	env.setItem("people", "p1", `{"name":"Ada","address":{"city":"London"}}`)
+
+	resp := env.run(env.handler().handleCollectionItemDiff, func(w io.Writer) error {
+		return protocol.WriteCollectionItemDiffCommand(w, "people", "p1", "", []byte(`{"name":"Ada","address":{"city":"Paris"},"email":"a@b.c"}`))
+	})
+	expectStatus(t, resp, protocol.StatusOk)
+	var diff DocumentDiff
+	if err := json.Unmarshal(resp.data, &diff); err != nil {
+		t.Fatal(err)
+	}
+	if change := diff.Changed["address.city"]; change.Old != "London" || change.New != "Paris" {
+		t.Errorf("address.city change = %+v, want London to Paris", change)
+	}
+	if diff.Added["email"] != "a@b.c" {
+		t.Errorf("added = %v, want email", diff.Added)
+	}
+	if _, changed := diff.Changed["name"]; changed {
+		t.Error("unchanged name reported as changed")
+	}
+
+	resp = env.run(env.handler().handleCollectionItemDiff, func(w io.Writer) error {
+		return protocol.WriteCollectionItemDiffCommand(w, "people", "p1", "missing", nil)
+	})
+	expectStatus(t, resp, protocol.StatusNotFound)
+}
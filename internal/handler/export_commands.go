@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/csv"
+	stdjson "encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/protocol"
+	"net"
+	"strings"
+)
+
+const (
+	// exportChunkSize is the amount of exported data buffered before it is sent as one
+	// StatusPartial response.
+	exportChunkSize = 256 * 1024
+	// exportBatchSize is the number of in-memory items fetched at a time. Items are fetched in
+	// batches so no shard lock is held while writing to the network.
+	exportBatchSize = 500
+)
+
+// collectionExporter encodes documents in the export format and sends them to the client in
+// chunks, so the export never has to fit in memory.
+type collectionExporter struct {
+	conn   net.Conn
+	format string
+	fields []string
+	buf    bytes.Buffer
+	csv    *csv.Writer
+	count  int
+	err    error
+}
+
+func newCollectionExporter(conn net.Conn, format string, fields []string) *collectionExporter {
+	e := &collectionExporter{conn: conn, format: format, fields: fields}
+	if format == globalconst.FormatCSV {
+		e.csv = csv.NewWriter(&e.buf)
+		e.csv.UseCRLF = true
+		e.err = e.csv.Write(fields)
+	}
+	return e
+}
+
+// addRaw exports a document stored as JSON.
+func (e *collectionExporter) addRaw(value []byte) {
+	if e.format == globalconst.FormatJSON && len(e.fields) == 0 {
+		// Stored documents are exported as they are, only made to fit on one line.
+		if err := stdjson.Compact(&e.buf, value); err != nil {
+			return
+		}
+		e.buf.WriteByte('\n')
+		e.written()
+		return
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(value, &doc); err != nil {
+		return
+	}
+	e.add(doc)
+}
+
+// add exports a decoded document.
+func (e *collectionExporter) add(doc map[string]any) {
+	switch e.format {
+	case globalconst.FormatCSV:
+		record := make([]string, len(e.fields))
+		for i, field := range e.fields {
+			if value, ok := getNestedValue(doc, field); ok {
+				record[i] = csvCellValue(value)
+			}
+		}
+		if err := e.csv.Write(record); err != nil {
+			e.err = err
+			return
+		}
+	default:
+		if len(e.fields) > 0 {
+			doc = projectFields(doc, e.fields)
+		}
+		line, err := json.Marshal(doc)
+		if err != nil {
+			return
+		}
+		e.buf.Write(line)
+		e.buf.WriteByte('\n')
+	}
+	e.written()
+}
+
+// written counts an exported document and sends a chunk once enough data is buffered.
+func (e *collectionExporter) written() {
+	e.count++
+	if e.csv != nil {
+		e.csv.Flush()
+	}
+	if e.buf.Len() >= exportChunkSize && e.err == nil {
+		e.err = protocol.WriteResponse(e.conn, protocol.StatusPartial, "", e.buf.Bytes())
+		e.buf.Reset()
+	}
+}
+
+// handleCollectionExport processes the CmdCollectionExport command. It is a read-only operation.
+// Every live item, in memory or only on disk, is sent as newline-delimited JSON or as CSV with
+// one column per requested field. The data arrives in StatusPartial responses followed by a
+// final response that carries the last chunk and the outcome of the export.
+func (h *ConnectionHandler) handleCollectionExport(r io.Reader, conn net.Conn) {
+	collectionName, format, fields, err := protocol.ReadCollectionExportCommand(r)
+	if err != nil {
+		slog.Error("Failed to read EXPORT_COLLECTION command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid EXPORT_COLLECTION command format", nil)
+		return
+	}
+	if collectionName == "" {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name cannot be empty", nil)
+		return
+	}
+	format = strings.ToLower(format)
+	if format == "" {
+		format = globalconst.FormatJSON
+	}
+	if format != globalconst.FormatJSON && format != globalconst.FormatCSV {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Unsupported export format '%s'. Use 'json' or 'csv'.", format), nil)
+		return
+	}
+	for _, field := range fields {
+		if field == "" {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Export fields cannot be empty", nil)
+			return
+		}
+	}
+	if format == globalconst.FormatCSV && len(fields) == 0 {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "A CSV export needs the list of fields to use as columns", nil)
+		return
+	}
+	if !h.hasPermission(collectionName, globalconst.PermissionRead) {
+		slog.Warn("Unauthorized collection export attempt", "user", h.AuthenticatedUser, "collection", collectionName)
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have read permission for collection '%s'", collectionName), nil)
+		return
+	}
+	if collectionName == globalconst.SystemCollectionName {
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: The system collection cannot be exported; use 'user export' for users.", nil)
+		return
+	}
+	if !h.CollectionManager.CollectionExists(collectionName) {
+		protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist", collectionName), nil)
+		return
+	}
+	recordCollectionRead(collectionName)
+	colStore := h.CollectionManager.GetCollection(collectionName)
+
+	// Only keys are collected up front; values are fetched a batch at a time.
+	hotKeys := make(map[string]struct{}, colStore.Size())
+	colStore.StreamAll(func(key string, value []byte) bool {
+		hotKeys[key] = struct{}{}
+		return true
+	})
+
+	exporter := newCollectionExporter(conn, format, fields)
+	batch := make([]string, 0, exportBatchSize)
+	exportBatch := func() {
+		for _, value := range colStore.GetMany(batch) {
+			if exporter.err != nil {
+				return
+			}
+			exporter.addRaw(value)
+		}
+		batch = batch[:0]
+	}
+	for key := range hotKeys {
+		batch = append(batch, key)
+		if len(batch) == exportBatchSize {
+			exportBatch()
+		}
+	}
+	exportBatch()
+	hotCount := exporter.count
+
+	// Cold documents are streamed from disk; tombstones and documents still in memory are skipped.
+	err = persistence.ScanColdData(collectionName, func(doc map[string]any) bool {
+		id, _ := doc[globalconst.ID].(string)
+		_, inMemory := hotKeys[id]
+		return !inMemory
+	}, func(doc map[string]any) bool {
+		exporter.add(doc)
+		return exporter.err == nil
+	})
+	if exporter.err != nil {
+		slog.Warn("Collection export aborted", "collection", collectionName, "exported", exporter.count, "error", exporter.err)
+		return
+	}
+	if err != nil {
+		slog.Error("Error reading cold data during export", "collection", collectionName, "error", err)
+		protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("ERROR: Export of collection '%s' stopped after %d items: %v", collectionName, exporter.count, err), nil)
+		return
+	}
+
+	slog.Info("Collection exported", "user", h.AuthenticatedUser, "collection", collectionName, "format", format, "hot_items", hotCount, "cold_items", exporter.count-hotCount)
+	if exporter.csv != nil {
+		exporter.csv.Flush()
+	}
+	msg := fmt.Sprintf("OK: Exported %d items from collection '%s' (%s).", exporter.count, collectionName, format)
+	protocol.WriteResponse(conn, protocol.StatusOk, msg, exporter.buf.Bytes())
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	stdjson "encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/protocol"
+	"net"
+	"strings"
+)
+
+// maxGetManyKeys bounds the keys a single GET_COLLECTION_ITEMS_MANY can ask for.
+const maxGetManyKeys = 10000
+
+// handleCollectionItemGetMany processes the CmdCollectionItemGetMany command. It is a read-only operation.
+// Keys are looked up in memory first and the misses are read from the collection file in a single
+// pass. The response data is an object mapping every key found to its document; missing and
+// expired keys are simply absent. Inside a transaction its own queued writes are taken into account.
+func (h *ConnectionHandler) handleCollectionItemGetMany(r io.Reader, conn net.Conn) {
+	collectionName, keys, err := protocol.ReadCollectionItemGetManyCommand(r)
+	if err != nil {
+		slog.Error("Failed to read GET_COLLECTION_ITEMS_MANY command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid GET_COLLECTION_ITEMS_MANY command format", nil)
+		return
+	}
+	if collectionName == "" || len(keys) == 0 {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name or keys cannot be empty", nil)
+		return
+	}
+	if len(keys) > maxGetManyKeys {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Too many keys: at most %d can be retrieved at once", maxGetManyKeys), nil)
+		return
+	}
+	if !h.hasPermission(collectionName, globalconst.PermissionRead) {
+		slog.Warn("Unauthorized collection item get many attempt", "user", h.AuthenticatedUser, "collection", collectionName, "keys", len(keys))
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have read permission for collection '%s'", collectionName), nil)
+		return
+	}
+	if h.CollectionManager.CollectionExists(collectionName) {
+		recordCollectionRead(collectionName)
+	}
+
+	// Keys with a write queued in the transaction are answered from it and never read elsewhere.
+	items := make(map[string][]byte, len(keys))
+	lookup := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value, found, pending, err := h.pendingValue(collectionName, key)
+		if err != nil {
+			protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Failed to read transaction: "+err.Error(), nil)
+			return
+		}
+		if !pending {
+			lookup = append(lookup, key)
+		} else if found {
+			items[key] = value
+		}
+	}
+
+	hot := h.CollectionManager.GetCollection(collectionName).GetMany(lookup)
+	misses := make([]string, 0, len(lookup)-len(hot))
+	for _, key := range lookup {
+		if value, found := hot[key]; found {
+			items[key] = value
+		} else {
+			misses = append(misses, key)
+		}
+	}
+	if len(misses) > 0 {
+		cold, err := persistence.GetManyColdItems(collectionName, misses)
+		if err != nil {
+			slog.Error("Failed to read cold items for get many", "collection", collectionName, "error", err)
+			protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Failed to read documents: "+err.Error(), nil)
+			return
+		}
+		for key, value := range cold {
+			items[key] = value
+			if recordColdRead(collectionName, key) {
+				promoteColdItem(h.CollectionManager, collectionName, key)
+			}
+		}
+	}
+
+	results := make(map[string]stdjson.RawMessage, len(items))
+	for key, value := range items {
+		if collectionName == globalconst.SystemCollectionName && strings.HasPrefix(key, globalconst.UserPrefix) {
+			var userInfo UserInfo
+			if err := json.Unmarshal(value, &userInfo); err != nil {
+				continue
+			}
+			value, _ = json.Marshal(sanitizeUserInfo(userInfo))
+		}
+		results[key] = value
+	}
+	responseData, err := json.Marshal(results)
+	if err != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to serialize retrieved documents", nil)
+		return
+	}
+	slog.Debug("Get many items from collection", "user", h.AuthenticatedUser, "collection", collectionName, "requested", len(keys), "found", len(results))
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: %d of %d keys retrieved from collection '%s'", len(results), len(keys), collectionName), responseData)
+}
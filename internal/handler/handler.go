@@ -2,6 +2,7 @@ package handler
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -11,12 +12,23 @@ import (
 	"memory-tools/internal/wal"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	jsoniter "github.com/json-iterator/go"
 )
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
 
+// connIdleTimeout closes connections that send no command for this long. Zero disables it.
+var connIdleTimeout atomic.Int64
+
+// SetConnIdleTimeout sets how long a connection may stay idle between commands before the
+// server closes it. Any command, including PING, resets the timer. Zero disables the timeout.
+func SetConnIdleTimeout(timeout time.Duration) {
+	connIdleTimeout.Store(int64(timeout))
+}
+
 type ActivityUpdater interface {
 	UpdateActivity()
 }
@@ -33,8 +45,21 @@ type ConnectionHandler struct {
 	IsLocalhostConn      bool
 	IsRoot               bool
 	Permissions          map[string]string
+	Roles                []string
 	TransactionManager   *store.TransactionManager
 	CurrentTransactionID string
+	// ClientCertCN is the common name of the verified client certificate, empty without one.
+	ClientCertCN string
+
+	// effectivePermissions caches Permissions merged with those of Roles, valid while
+	// effectiveRoleVersion matches the global role version.
+	effectivePermissions map[string]permissionSet
+	effectiveRoleVersion uint64
+
+	// queuedWalEntries are the commands queued by the transaction queuedWalTx, logged to the
+	// WAL only once it commits.
+	queuedWalEntries []wal.WalEntry
+	queuedWalTx      string
 }
 
 var connectionHandlerPool = sync.Pool{
@@ -57,8 +82,14 @@ func (h *ConnectionHandler) Reset() {
 	h.IsLocalhostConn = false
 	h.IsRoot = false
 	clear(h.Permissions)
+	h.Roles = nil
+	h.effectivePermissions = nil
+	h.effectiveRoleVersion = 0
 	h.TransactionManager = nil
 	h.CurrentTransactionID = ""
+	h.ClientCertCN = ""
+	h.queuedWalEntries = nil
+	h.queuedWalTx = ""
 }
 
 // GetConnectionHandlerFromPool retrieves a handler from the pool and initializes it.
@@ -111,19 +142,47 @@ func isWriteCommand(cmdType protocol.CommandType) bool {
 		protocol.CmdCollectionCreate,
 		protocol.CmdCollectionDelete,
 		protocol.CmdCollectionIndexCreate,
+		protocol.CmdCollectionIndexCreateWithOptions,
 		protocol.CmdCollectionIndexDelete,
+		protocol.CmdCollectionIndexDisable,
+		protocol.CmdCollectionIndexEnable,
+		protocol.CmdCollectionSetCompression,
+		protocol.CmdCollectionSetFileCompression,
+		protocol.CmdCollectionRename,
+		protocol.CmdCollectionCopy,
+		protocol.CmdCollectionTruncate,
+		protocol.CmdCollectionSetSchema,
+		protocol.CmdCollectionSetDefaultTTL,
 		protocol.CmdCollectionItemSet,
 		protocol.CmdCollectionItemSetMany,
 		protocol.CmdCollectionItemDelete,
 		protocol.CmdCollectionItemDeleteMany,
+		protocol.CmdCollectionItemGetAndDelete,
 		protocol.CmdCollectionItemUpdate,
+		protocol.CmdCollectionItemUpsert,
+		protocol.CmdCollectionItemUpdateIf,
+		protocol.CmdCollectionItemReplace,
+		protocol.CmdCollectionItemTouch,
 		protocol.CmdCollectionItemUpdateMany,
+		protocol.CmdCollectionItemMergeByQuery,
+		protocol.CmdCollectionItemIncrement,
 		protocol.CmdChangeUserPassword,
 		protocol.CmdUserCreate,
 		protocol.CmdUserUpdate,
 		protocol.CmdUserDelete,
+		protocol.CmdImportUsers,
+		protocol.CmdAPIKeyPut,
+		protocol.CmdAPIKeyRevoke,
+		protocol.CmdRoleCreate,
+		protocol.CmdRoleUpdate,
+		protocol.CmdRoleDelete,
+		protocol.CmdUserSetRoles,
+		protocol.CmdProcedurePut,
+		protocol.CmdProcedureDelete,
+		protocol.CmdCallProcedure,
 		protocol.CmdCommit,
-		protocol.CmdRestore:
+		protocol.CmdRestore,
+		protocol.CmdRestoreCollection:
 		return true
 	default:
 		return false
@@ -133,113 +192,345 @@ func isWriteCommand(cmdType protocol.CommandType) bool {
 // HandleConnection is the main loop for processing commands from a single connection.
 func (h *ConnectionHandler) HandleConnection(conn net.Conn) {
 	defer conn.Close()
-	slog.Info("New client connected", "remote_addr", conn.RemoteAddr().String(), "is_localhost", h.IsLocalhostConn)
+	if !trackConnection(conn) {
+		slog.Info("Closing connection: server is shutting down", "remote_addr", conn.RemoteAddr().String())
+		return
+	}
+	defer untrackConnection(conn)
+	if err := h.identifyClientCert(conn); err != nil {
+		slog.Warn("TLS handshake failed", "remote_addr", conn.RemoteAddr().String(), "error", err)
+		return
+	}
+	slog.Info("New client connected", "remote_addr", conn.RemoteAddr().String(), "is_localhost", h.IsLocalhostConn, "client_cn", h.ClientCertCN)
+
+	// The connection is tracked under the conn it was accepted as.
+	trackedConn := conn
+	conn = newPipelinedConn(conn)
 
 	for {
+		idleTimeout := time.Duration(connIdleTimeout.Load())
+		if !awaitCommand(trackedConn, idleTimeout) {
+			slog.Info("Closing connection: server is shutting down", "remote_addr", conn.RemoteAddr().String(), "user", h.AuthenticatedUser)
+			return
+		}
 		cmdType, err := protocol.ReadCommandType(conn)
 		if err != nil {
-			if err != io.EOF {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && isDraining() {
+				slog.Info("Closing connection: server is shutting down", "remote_addr", conn.RemoteAddr().String(), "user", h.AuthenticatedUser)
+			} else if errors.As(err, &netErr) && netErr.Timeout() {
+				// An open transaction is rolled back when the handler returns to the pool.
+				slog.Info("Closing idle connection", "remote_addr", conn.RemoteAddr().String(), "user", h.AuthenticatedUser, "idle_timeout", idleTimeout.String(), "open_transaction", h.CurrentTransactionID != "")
+			} else if err != io.EOF {
 				slog.Error("Failed to read command type", "remote_addr", conn.RemoteAddr().String(), "error", err)
 			} else {
 				slog.Info("Client disconnected", "remote_addr", conn.RemoteAddr().String())
 			}
 			return
 		}
+		// The timeout only applies while waiting for a command, not to reading its payload.
+		beginCommand(trackedConn)
 
 		h.ActivityUpdater.UpdateActivity()
 
+		// PING is answered before the authentication check so clients can probe the server.
+		if cmdType == protocol.CmdPing {
+			h.handlePing(conn, conn)
+			continue
+		}
+
 		var reader io.Reader = conn
+		// payloadBuf is pooled memory: handlers decode from it with copying reads
+		// and it is released only after their response has been written.
+		var payloadBuf *bytes.Buffer
 
-		if h.Wal != nil && isWriteCommand(cmdType) {
-			payload, err := protocol.ReadCommandPayload(conn, cmdType)
+		if reason := h.CollectionManager.ReadOnlyReason(); reason != nil && isWriteCommand(cmdType) {
+			discardBuf := protocol.AcquirePayloadBuffer()
+			err := protocol.ReadCommandPayloadInto(conn, cmdType, discardBuf)
+			protocol.ReleasePayloadBuffer(discardBuf)
 			if err != nil {
-				slog.Error("Failed to read command payload for WAL", "error", err, "command_type", cmdType)
-				protocol.WriteResponse(conn, protocol.StatusError, "Internal server error reading command", nil)
-				continue
+				slog.Error("Failed to read payload of rejected write command", "error", err, "command_type", cmdType)
+				return
 			}
+			slog.Warn("Write rejected: server is in read-only mode", "remote_addr", conn.RemoteAddr().String(), "command_type", cmdType)
+			protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("ERROR: Server is in read-only mode because saving data to disk keeps failing (%v). Writes are rejected until persistence recovers.", reason), nil)
+			continue
+		}
+
+		// Commands of a transaction the garbage collector aborted must not run outside of it.
+		// Streamed commands have no fixed payload to discard and are left to reject themselves.
+		if h.CurrentTransactionID != "" && protocol.HasFixedPayload(cmdType) && h.TransactionManager.Expired(h.CurrentTransactionID) {
+			if !h.rejectExpiredTransaction(conn, cmdType) {
+				return
+			}
+			continue
+		}
 
-			entry := wal.WalEntry{
-				CommandType: cmdType,
-				Payload:     payload,
+		// The rate limit is checked before the WAL so a throttled command is never logged.
+		if prefetched, allowed, keepOpen := h.rateLimitCommand(conn, cmdType); !allowed {
+			if !keepOpen {
+				return
 			}
+			continue
+		} else if prefetched != nil {
+			payloadBuf = prefetched
+			reader = bytes.NewReader(prefetched.Bytes())
+		}
 
-			if err := h.Wal.Write(entry); err != nil {
-				slog.Error("CRITICAL: Failed to write to WAL", "error", err)
-				protocol.WriteResponse(conn, protocol.StatusError, "Internal server error: could not persist command", nil)
-				continue
+		// A write command is read whole so it can be logged to the WAL once it has succeeded.
+		var cmdConn net.Conn = conn
+		var logged *walLoggedConn
+		if h.Wal != nil && isWriteCommand(cmdType) {
+			if payloadBuf == nil {
+				payloadBuf = protocol.AcquirePayloadBuffer()
+				if err := protocol.ReadCommandPayloadInto(conn, cmdType, payloadBuf); err != nil {
+					protocol.ReleasePayloadBuffer(payloadBuf)
+					slog.Error("Failed to read command payload for WAL", "error", err, "command_type", cmdType)
+					protocol.WriteResponse(conn, protocol.StatusError, "Internal server error reading command", nil)
+					// The rest of the payload would be read as the next command.
+					return
+				}
 			}
-			reader = bytes.NewReader(payload)
+			reader = bytes.NewReader(payloadBuf.Bytes())
+			logged = &walLoggedConn{Conn: conn}
+			cmdConn = logged
+		}
+		txID := h.CurrentTransactionID
+		txWrites := 0
+		if logged != nil && txID != "" {
+			txWrites = h.TransactionManager.WriteCount(txID)
 		}
 
 		if cmdType == protocol.CmdAuthenticate {
 			h.handleAuthenticate(reader, conn)
+			protocol.ReleasePayloadBuffer(payloadBuf)
+			continue
+		}
+		if cmdType == protocol.CmdAuthenticateToken {
+			h.handleAuthenticateToken(reader, conn)
+			protocol.ReleasePayloadBuffer(payloadBuf)
 			continue
 		}
 
 		if !h.IsAuthenticated {
 			slog.Warn("Unauthorized access attempt", "remote_addr", conn.RemoteAddr().String(), "command_type", cmdType)
 			protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Please authenticate first.", nil)
-			io.Copy(io.Discard, reader)
+			discarded := payloadBuf != nil || discardCommandPayload(conn, cmdType)
+			protocol.ReleasePayloadBuffer(payloadBuf)
+			if !discarded {
+				return
+			}
 			continue
 		}
 
 		switch cmdType {
 		case protocol.CmdBegin:
-			h.handleBegin(reader, conn)
+			h.handleBegin(reader, cmdConn)
 		case protocol.CmdCommit:
-			h.HandleCommit(reader, conn)
+			h.HandleCommit(reader, cmdConn)
 		case protocol.CmdRollback:
-			h.handleRollback(reader, conn)
+			h.handleRollback(reader, cmdConn)
+		case protocol.CmdTransactionStatus:
+			h.handleTransactionStatus(reader, cmdConn)
+		case protocol.CmdCollectionReload:
+			h.handleCollectionReload(reader, cmdConn)
+		case protocol.CmdCompactAll:
+			h.handleCompactAll(reader, cmdConn)
+		case protocol.CmdCompactStatus:
+			h.handleCompactStatus(reader, cmdConn)
+		case protocol.CmdCollectionStats:
+			h.handleCollectionStats(reader, cmdConn)
+		case protocol.CmdMemoryStats:
+			h.handleMemoryStats(reader, cmdConn)
+		case protocol.CmdStats:
+			h.handleStats(reader, cmdConn)
+		case protocol.CmdSlowQueryList:
+			h.handleSlowQueryList(reader, cmdConn)
+		case protocol.CmdSubscribe:
+			if h.handleSubscribe(reader, cmdConn) {
+				// A subscribed connection only receives events, so no command is read from it again.
+				protocol.ReleasePayloadBuffer(payloadBuf)
+				return
+			}
+		case protocol.CmdChangeFeed:
+			if h.handleChangeFeed(reader, cmdConn) {
+				// Once a feed starts, only its acknowledgements are read from the connection.
+				protocol.ReleasePayloadBuffer(payloadBuf)
+				return
+			}
+		case protocol.CmdChangeFeedAck:
+			h.handleChangeFeedAck(reader, cmdConn)
 		case protocol.CmdSet:
-			h.HandleMainStoreSet(reader, conn)
+			h.HandleMainStoreSet(reader, cmdConn)
 		case protocol.CmdGet:
-			h.handleMainStoreGet(reader, conn)
+			h.handleMainStoreGet(reader, cmdConn)
 		case protocol.CmdCollectionCreate:
-			h.HandleCollectionCreate(reader, conn)
+			h.HandleCollectionCreate(reader, cmdConn)
 		case protocol.CmdCollectionDelete:
-			h.HandleCollectionDelete(reader, conn)
+			h.HandleCollectionDelete(reader, cmdConn)
 		case protocol.CmdCollectionList:
-			h.handleCollectionList(reader, conn)
+			h.handleCollectionList(reader, cmdConn)
 		case protocol.CmdCollectionIndexCreate:
-			h.HandleCollectionIndexCreate(reader, conn)
+			h.HandleCollectionIndexCreate(reader, cmdConn)
+		case protocol.CmdCollectionIndexCreateWithOptions:
+			h.HandleCollectionIndexCreateWithOptions(reader, cmdConn)
 		case protocol.CmdCollectionIndexDelete:
-			h.HandleCollectionIndexDelete(reader, conn)
+			h.HandleCollectionIndexDelete(reader, cmdConn)
+		case protocol.CmdCollectionIndexDisable:
+			h.HandleCollectionIndexDisable(reader, cmdConn)
+		case protocol.CmdCollectionIndexEnable:
+			h.HandleCollectionIndexEnable(reader, cmdConn)
+		case protocol.CmdCollectionSetCompression:
+			h.HandleCollectionSetCompression(reader, cmdConn)
+		case protocol.CmdCollectionSetFileCompression:
+			h.HandleCollectionSetFileCompression(reader, cmdConn)
+		case protocol.CmdCollectionRename:
+			h.HandleCollectionRename(reader, cmdConn)
+		case protocol.CmdCollectionCopy:
+			h.HandleCollectionCopy(reader, cmdConn)
+		case protocol.CmdCollectionTruncate:
+			h.HandleCollectionTruncate(reader, cmdConn)
+		case protocol.CmdCollectionSetSchema:
+			h.HandleCollectionSetSchema(reader, cmdConn)
+		case protocol.CmdCollectionSetDefaultTTL:
+			h.HandleCollectionSetDefaultTTL(reader, cmdConn)
 		case protocol.CmdCollectionIndexList:
-			h.handleCollectionIndexList(reader, conn)
+			h.handleCollectionIndexList(reader, cmdConn)
+		case protocol.CmdCollectionIndexAudit:
+			h.handleCollectionIndexAudit(reader, cmdConn)
 		case protocol.CmdCollectionItemSet:
-			h.HandleCollectionItemSet(reader, conn)
+			h.HandleCollectionItemSet(reader, cmdConn)
 		case protocol.CmdCollectionItemSetMany:
-			h.HandleCollectionItemSetMany(reader, conn)
+			h.HandleCollectionItemSetMany(reader, cmdConn)
 		case protocol.CmdCollectionItemDeleteMany:
-			h.HandleCollectionItemDeleteMany(reader, conn)
+			h.HandleCollectionItemDeleteMany(reader, cmdConn)
 		case protocol.CmdCollectionItemGet:
-			h.handleCollectionItemGet(reader, conn)
+			h.handleCollectionItemGet(reader, cmdConn)
+		case protocol.CmdCollectionItemExists:
+			h.handleCollectionItemExists(reader, cmdConn)
 		case protocol.CmdCollectionItemDelete:
-			h.HandleCollectionItemDelete(reader, conn)
+			h.HandleCollectionItemDelete(reader, cmdConn)
+		case protocol.CmdCollectionItemGetAndDelete:
+			h.HandleCollectionItemGetAndDelete(reader, cmdConn)
 		case protocol.CmdCollectionItemList:
-			h.handleCollectionItemList(reader, conn)
+			h.handleCollectionItemList(reader, cmdConn)
 		case protocol.CmdCollectionItemUpdate:
-			h.HandleCollectionItemUpdate(reader, conn)
+			h.HandleCollectionItemUpdate(reader, cmdConn)
+		case protocol.CmdCollectionItemUpsert:
+			h.HandleCollectionItemUpsert(reader, cmdConn)
+		case protocol.CmdCollectionItemUpdateIf:
+			h.HandleCollectionItemUpdateIf(reader, cmdConn)
+		case protocol.CmdCollectionItemReplace:
+			h.HandleCollectionItemReplace(reader, cmdConn)
+		case protocol.CmdCollectionItemTTL:
+			h.handleCollectionItemTTL(reader, cmdConn)
+		case protocol.CmdCollectionItemTouch:
+			h.HandleCollectionItemTouch(reader, cmdConn)
+		case protocol.CmdCollectionKeyScan:
+			h.handleCollectionKeyScan(reader, cmdConn)
+		case protocol.CmdCollectionItemGetMany:
+			h.handleCollectionItemGetMany(reader, cmdConn)
 		case protocol.CmdCollectionItemUpdateMany:
-			h.HandleCollectionItemUpdateMany(reader, conn)
+			h.HandleCollectionItemUpdateMany(reader, cmdConn)
+		case protocol.CmdCollectionItemMergeByQuery:
+			h.HandleCollectionItemMergeByQuery(reader, cmdConn)
+		case protocol.CmdCollectionItemIncrement:
+			h.HandleCollectionItemIncrement(reader, cmdConn)
 		case protocol.CmdCollectionQuery:
-			h.handleCollectionQuery(reader, conn)
+			h.handleCollectionQuery(reader, cmdConn)
+		case protocol.CmdCollectionItemDiff:
+			h.handleCollectionItemDiff(reader, cmdConn)
+		case protocol.CmdCollectionTopLargest:
+			h.handleCollectionTopLargest(reader, cmdConn)
 		case protocol.CmdChangeUserPassword:
-			h.HandleChangeUserPassword(reader, conn)
+			h.HandleChangeUserPassword(reader, cmdConn)
 		case protocol.CmdUserCreate:
-			h.HandleUserCreate(reader, conn)
+			h.HandleUserCreate(reader, cmdConn)
 		case protocol.CmdUserUpdate:
-			h.HandleUserUpdate(reader, conn)
+			h.HandleUserUpdate(reader, cmdConn)
 		case protocol.CmdUserDelete:
-			h.HandleUserDelete(reader, conn)
+			h.HandleUserDelete(reader, cmdConn)
+		case protocol.CmdExportUsers:
+			h.handleExportUsers(reader, cmdConn)
+		case protocol.CmdImportUsers:
+			h.HandleImportUsers(reader, cmdConn)
+		case protocol.CmdAPIKeyCreate:
+			h.handleAPIKeyCreate(reader, cmdConn)
+		case protocol.CmdAPIKeyPut:
+			h.HandleAPIKeyPut(reader, cmdConn)
+		case protocol.CmdAPIKeyRevoke:
+			h.HandleAPIKeyRevoke(reader, cmdConn)
+		case protocol.CmdAPIKeyList:
+			h.handleAPIKeyList(reader, cmdConn)
+		case protocol.CmdRoleCreate:
+			h.HandleRoleCreate(reader, cmdConn)
+		case protocol.CmdRoleUpdate:
+			h.HandleRoleUpdate(reader, cmdConn)
+		case protocol.CmdRoleDelete:
+			h.HandleRoleDelete(reader, cmdConn)
+		case protocol.CmdUserSetRoles:
+			h.HandleUserSetRoles(reader, cmdConn)
+		case protocol.CmdProcedurePut:
+			h.HandleProcedurePut(reader, cmdConn)
+		case protocol.CmdProcedureDelete:
+			h.HandleProcedureDelete(reader, cmdConn)
+		case protocol.CmdProcedureList:
+			h.handleProcedureList(reader, cmdConn)
+		case protocol.CmdCallProcedure:
+			h.HandleCallProcedure(reader, cmdConn)
 		case protocol.CmdBackup:
-			h.handleBackup(reader, conn)
+			h.handleBackup(reader, cmdConn)
 		case protocol.CmdRestore:
-			h.HandleRestore(reader, conn)
+			h.HandleRestore(reader, cmdConn)
+		case protocol.CmdRestoreCollection:
+			h.HandleRestoreCollection(reader, cmdConn)
+		case protocol.CmdBackupList:
+			h.handleBackupList(reader, cmdConn)
+		case protocol.CmdBackupDelete:
+			h.handleBackupDelete(reader, cmdConn)
+		case protocol.CmdRestoreValidate:
+			h.handleRestoreValidate(reader, cmdConn)
+		case protocol.CmdCollectionExport:
+			h.handleCollectionExport(reader, cmdConn)
+		case protocol.CmdCollectionImport:
+			h.handleCollectionImport(reader, cmdConn)
 		default:
 			slog.Warn("Received unhandled command type", "command_type", cmdType, "remote_addr", conn.RemoteAddr().String())
 			protocol.WriteResponse(conn, protocol.StatusBadCommand, fmt.Sprintf("BAD COMMAND: Unhandled or unknown command type %d", cmdType), nil)
-			io.Copy(io.Discard, reader)
+			// Without a known layout there is no telling where the next command starts.
+			if payloadBuf == nil && !discardCommandPayload(conn, cmdType) {
+				slog.Info("Closing connection after an unknown command", "remote_addr", conn.RemoteAddr().String(), "command_type", cmdType)
+				return
+			}
+		}
+		if logged != nil {
+			h.logWrite(wal.WalEntry{CommandType: cmdType, Payload: payloadBuf.Bytes()}, logged, txID, txWrites)
+		}
+		if h.queuedWalTx != "" && h.queuedWalTx != h.CurrentTransactionID {
+			// The transaction ended: its commands were logged with its commit or dropped.
+			h.queuedWalEntries, h.queuedWalTx = nil, ""
 		}
+		protocol.ReleasePayloadBuffer(payloadBuf)
+	}
+}
+
+// PingResponse is the response payload of the PING command.
+type PingResponse struct {
+	ServerTime string `json:"server_time"` // RFC 3339 with nanoseconds, in UTC.
+}
+
+// handlePing processes the CmdPing command. It needs no authentication, so pooled clients can
+// check a connection's liveness without issuing a data command. The server's clock is returned
+// so clients can also estimate skew.
+func (h *ConnectionHandler) handlePing(r io.Reader, conn net.Conn) {
+	if err := protocol.ReadPingCommand(r); err != nil {
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid PING command format", nil)
+		return
+	}
+	responseData, err := json.Marshal(PingResponse{ServerTime: time.Now().UTC().Format(time.RFC3339Nano)})
+	if err != nil {
+		protocol.WriteResponse(conn, protocol.StatusOk, "PONG", nil)
+		return
 	}
+	protocol.WriteResponse(conn, protocol.StatusOk, "PONG", responseData)
 }
@@ -1,9 +1,11 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"memory-tools/internal/globalconst"
 	"memory-tools/internal/persistence"
 	"memory-tools/internal/protocol"
 	"net"
@@ -84,7 +86,16 @@ func (h *ConnectionHandler) HandleRestore(r io.Reader, conn net.Conn) {
 		"remote_addr", remoteAddr,
 	)
 
-	err = persistence.PerformRestore(backupName, h.MainStore, h.CollectionManager)
+	// The WAL of incremental backups is replayed as on recovery, outside this connection's transaction.
+	replayHandler := GetConnectionHandlerFromPool(nil, h.MainStore, h.CollectionManager, nil, h.TransactionManager, h.ActivityUpdater, nil)
+	replayHandler.IsAuthenticated = true
+	replayHandler.IsRoot = true
+	err = persistence.PerformRestore(backupName, h.MainStore, h.CollectionManager, replayHandler.ApplyWalEntry)
+	PutConnectionHandlerToPool(replayHandler)
+	if h.Wal != nil {
+		// The restored data no longer follows from the archived WAL, so the next backup is full.
+		h.Wal.ClearArchiveHead()
+	}
 	if err != nil {
 		slog.Error("Restore failed", "backup_name", backupName, "user", h.AuthenticatedUser, "error", err)
 		if conn != nil {
@@ -112,3 +123,174 @@ func (h *ConnectionHandler) HandleRestore(r io.Reader, conn net.Conn) {
 		protocol.WriteResponse(conn, protocol.StatusOk, msg, nil)
 	}
 }
+
+// HandleRestoreCollection handles the command to restore a single collection from a backup.
+// It is reserved to root@localhost and, like a full restore, is logged to the WAL.
+func (h *ConnectionHandler) HandleRestoreCollection(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	backupName, collectionName, err := protocol.ReadRestoreCollectionCommand(r)
+	if err != nil {
+		slog.Error("Failed to read RESTORE_COLLECTION command payload", "remote_addr", remoteAddr, "error", err)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid RESTORE_COLLECTION command format.", nil)
+		}
+		return
+	}
+
+	// During WAL recovery, conn is nil and authorization is skipped.
+	if conn != nil {
+		if !h.IsRoot || !h.IsLocalhostConn {
+			slog.Warn("Unauthorized collection restore attempt",
+				"user", h.AuthenticatedUser,
+				"collection", collectionName,
+				"remote_addr", remoteAddr,
+			)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Restoring a collection is a privileged operation for root@localhost.", nil)
+			return
+		}
+		if h.CurrentTransactionID != "" {
+			protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Collections cannot be restored inside a transaction.", nil)
+			return
+		}
+	}
+	if backupName == "" || collectionName == "" {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Backup name and collection name cannot be empty.", nil)
+		}
+		return
+	}
+	if collectionName == globalconst.SystemCollectionName {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: The system collection cannot be restored on its own; use a full restore.", nil)
+		}
+		return
+	}
+
+	slog.Warn("DESTRUCTIVE ACTION: Collection restore initiated",
+		"user", h.AuthenticatedUser,
+		"backup_name", backupName,
+		"collection", collectionName,
+		"remote_addr", remoteAddr,
+	)
+
+	colStore, err := persistence.RestoreCollection(backupName, collectionName, h.CollectionManager)
+	if err != nil {
+		slog.Error("Collection restore failed", "backup_name", backupName, "collection", collectionName, "user", h.AuthenticatedUser, "error", err)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("ERROR: Collection restore failed: %v", err), nil)
+		}
+		return
+	}
+	h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
+
+	itemCount := colStore.Size()
+	slog.Info("Collection restore completed successfully", "backup_name", backupName, "collection", collectionName, "items", itemCount, "user", h.AuthenticatedUser)
+	if conn != nil {
+		responseData, _ := json.Marshal(map[string]int{"items_restored": itemCount})
+		msg := fmt.Sprintf("OK: Collection '%s' restored from '%s' with %d items.", collectionName, backupName, itemCount)
+		protocol.WriteResponse(conn, protocol.StatusOk, msg, responseData)
+	}
+}
+
+// handleRestoreValidate handles the command to check that a backup can be restored without
+// restoring it. It is reserved to root, like a restore, and does not modify data state.
+func (h *ConnectionHandler) handleRestoreValidate(r io.Reader, conn net.Conn) {
+	backupName, err := protocol.ReadRestoreValidateCommand(r)
+	if err != nil {
+		slog.Error("Failed to read RESTORE_VALIDATE command payload", "remote_addr", conn.RemoteAddr().String(), "error", err)
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid RESTORE_VALIDATE command format.", nil)
+		return
+	}
+	if !h.IsRoot {
+		slog.Warn("Unauthorized restore validation attempt", "user", h.AuthenticatedUser, "backup_name", backupName, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Only root can validate a restore.", nil)
+		return
+	}
+	if backupName == "" {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Backup name cannot be empty.", nil)
+		return
+	}
+
+	plan, err := persistence.ValidateRestore(backupName)
+	if err != nil {
+		slog.Warn("Restore validation failed", "backup_name", backupName, "user", h.AuthenticatedUser, "error", err)
+		protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("ERROR: Backup '%s' failed validation: %v", backupName, err), nil)
+		return
+	}
+	responseData, err := json.Marshal(plan)
+	if err != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to serialize restore plan", nil)
+		return
+	}
+	msg := fmt.Sprintf("OK: Backup '%s' is valid: %d main store items, %d collections, %d WAL entries across %d backups. Run 'restore %s' to apply it.",
+		backupName, plan.MainStoreItems, len(plan.Collections), plan.WalEntries, len(plan.Chain), backupName)
+	protocol.WriteResponse(conn, protocol.StatusOk, msg, responseData)
+}
+
+// handleBackupList handles the command to list the stored backups with their size and
+// verification status. It is reserved to root@localhost and does not modify data state.
+func (h *ConnectionHandler) handleBackupList(r io.Reader, conn net.Conn) {
+	if !h.IsRoot || !h.IsLocalhostConn {
+		slog.Warn("Unauthorized backup list attempt", "user", h.AuthenticatedUser, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Listing backups is a privileged operation for root@localhost.", nil)
+		return
+	}
+
+	backups, err := h.BackupManager.ListBackups()
+	if err != nil {
+		slog.Error("Failed to list backups", "error", err)
+		protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("ERROR: Failed to list backups: %v", err), nil)
+		return
+	}
+	responseData, err := json.Marshal(backups)
+	if err != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to serialize backup list", nil)
+		return
+	}
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: %d backups found.", len(backups)), responseData)
+}
+
+// handleBackupDelete handles the command to delete a backup directory. It is reserved to
+// root@localhost. Backups are not data state, so it is not logged to the WAL.
+func (h *ConnectionHandler) handleBackupDelete(r io.Reader, conn net.Conn) {
+	backupName, err := protocol.ReadBackupDeleteCommand(r)
+	if err != nil {
+		slog.Error("Failed to read BACKUP_DELETE command payload", "remote_addr", conn.RemoteAddr().String(), "error", err)
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid BACKUP_DELETE command format.", nil)
+		return
+	}
+	if !h.IsRoot || !h.IsLocalhostConn {
+		slog.Warn("Unauthorized backup delete attempt", "user", h.AuthenticatedUser, "backup_name", backupName, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Deleting backups is a privileged operation for root@localhost.", nil)
+		return
+	}
+	if backupName == "" {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Backup name cannot be empty.", nil)
+		return
+	}
+
+	if err := h.BackupManager.DeleteBackup(backupName); err != nil {
+		if errors.Is(err, persistence.ErrBackupNotFound) {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Backup '%s' does not exist.", backupName), nil)
+			return
+		}
+		if errors.Is(err, persistence.ErrInvalidBackupName) {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Invalid backup name '%s'.", backupName), nil)
+			return
+		}
+		if errors.Is(err, persistence.ErrBackupInUse) {
+			protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("ERROR: Backup '%s' cannot be deleted: %v", backupName, err), nil)
+			return
+		}
+		slog.Error("Backup delete failed", "backup_name", backupName, "user", h.AuthenticatedUser, "error", err)
+		protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("ERROR: %v", err), nil)
+		return
+	}
+
+	slog.Warn("Backup deleted", "backup_name", backupName, "user", h.AuthenticatedUser, "remote_addr", conn.RemoteAddr().String())
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Backup '%s' deleted.", backupName), nil)
+}
@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"net"
+	"slices"
+	"testing"
+)
+
+// discardPersister is a store.CollectionPersister that keeps nothing on disk. Tests that need
+// cold data write the collection file themselves.
+type discardPersister struct{}
+
+func (discardPersister) SaveCollectionData(string, store.DataStore) error          { return nil }
+func (discardPersister) DeleteCollectionFile(string) error                         { return nil }
+func (discardPersister) RenameCollectionFile(string, string) error                 { return nil }
+func (discardPersister) CopyCollectionFile(string, string) error                   { return nil }
+func (discardPersister) TruncateCollectionFile(string) error                       { return nil }
+func (discardPersister) WriteColdItems(string, map[string][]byte) error            { return nil }
+func (discardPersister) ScanColdDocuments(string, func(map[string]any) bool) error { return nil }
+
+// testConn records the responses written to a connection.
+type testConn struct {
+	net.Conn
+	out bytes.Buffer
+}
+
+func (c *testConn) Write(p []byte) (int, error) { return c.out.Write(p) }
+func (c *testConn) Read([]byte) (int, error)    { return 0, io.EOF }
+func (c *testConn) RemoteAddr() net.Addr        { return &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000} }
+
+// testResponse is a response read back from a testConn.
+type testResponse struct {
+	status protocol.ResponseStatus
+	msg    string
+	data   []byte
+}
+
+// testEnv is a server state shared by the handlers of a test, run in a temporary directory.
+type testEnv struct {
+	t  *testing.T
+	cm *store.CollectionManager
+	tm *store.TransactionManager
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	cm := store.NewCollectionManager(discardPersister{}, 4)
+	return &testEnv{t: t, cm: cm, tm: store.NewTransactionManager(cm)}
+}
+
+// handler returns an authenticated root handler, as for a local root connection.
+func (e *testEnv) handler() *ConnectionHandler {
+	h := GetConnectionHandlerFromPool(nil, store.NewInMemStoreWithShards(4), e.cm, nil, e.tm, nil, nil)
+	h.IsAuthenticated, h.IsRoot = true, true
+	e.t.Cleanup(func() { PutConnectionHandlerToPool(h) })
+	return h
+}
+
+// run builds a command with write, then passes its payload to handle and returns its response.
+func (e *testEnv) run(handle func(io.Reader, net.Conn), write func(io.Writer) error) testResponse {
+	e.t.Helper()
+	var cmd bytes.Buffer
+	if err := write(&cmd); err != nil {
+		e.t.Fatalf("building command: %v", err)
+	}
+	cmd.Next(1) // The command type is read by the connection loop.
+	conn := &testConn{}
+	handle(&cmd, conn)
+	return readTestResponse(e.t, conn.out.Bytes())
+}
+
+func readTestResponse(t *testing.T, b []byte) testResponse {
+	t.Helper()
+	if len(b) < 9 {
+		t.Fatalf("short response: %q", b)
+	}
+	msgLen := protocol.ByteOrder.Uint32(b[1:5])
+	msg := b[5 : 5+msgLen]
+	dataLen := protocol.ByteOrder.Uint32(b[5+msgLen : 9+msgLen])
+	return testResponse{status: protocol.ResponseStatus(b[0]), msg: string(msg), data: b[9+msgLen : 9+msgLen+dataLen]}
+}
+
+// expectStatus fails the test if a response does not have the wanted status.
+func expectStatus(t *testing.T, resp testResponse, want protocol.ResponseStatus) {
+	t.Helper()
+	if resp.status != want {
+		t.Fatalf("status = %d (%s), want %d", resp.status, resp.msg, want)
+	}
+}
+
+// createTestCollection creates a collection through its command.
+func (e *testEnv) createTestCollection(name string) {
+	e.t.Helper()
+	resp := e.run(e.handler().HandleCollectionCreate, func(w io.Writer) error { return protocol.WriteCollectionCreateCommand(w, name) })
+	expectStatus(e.t, resp, protocol.StatusOk)
+}
+
+// setItem stores a document through the SET command.
+func (e *testEnv) setItem(collectionName, key, value string) {
+	e.t.Helper()
+	resp := e.run(e.handler().HandleCollectionItemSet, func(w io.Writer) error {
+		return protocol.WriteCollectionItemSetCommand(w, collectionName, key, []byte(value), 0)
+	})
+	expectStatus(e.t, resp, protocol.StatusOk)
+}
+
+// noActivity is an ActivityUpdater for handlers served a connection.
+type noActivity struct{}
+
+func (noActivity) UpdateActivity() {}
+
+// serve runs the connection loop of h, as for an accepted connection, and returns the client's
+// end. The connection is closed, and the loop waited for, when the test ends.
+func (e *testEnv) serve(h *ConnectionHandler) net.Conn {
+	e.t.Helper()
+	h.ActivityUpdater = noActivity{}
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.HandleConnection(server)
+	}()
+	e.t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+	return client
+}
+
+// send writes a command to a served connection and returns its response.
+func send(t *testing.T, conn net.Conn, write func(io.Writer) error) testResponse {
+	t.Helper()
+	var cmd bytes.Buffer
+	if err := write(&cmd); err != nil {
+		t.Fatalf("building command: %v", err)
+	}
+	if _, err := conn.Write(cmd.Bytes()); err != nil {
+		t.Fatalf("sending command: %v", err)
+	}
+	return receive(t, conn)
+}
+
+// receive reads the next response from a served connection.
+func receive(t *testing.T, conn net.Conn) testResponse {
+	t.Helper()
+	var header [5]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	rest := make([]byte, protocol.ByteOrder.Uint32(header[1:5])+4)
+	if _, err := io.ReadFull(conn, rest); err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	data := make([]byte, protocol.ByteOrder.Uint32(rest[len(rest)-4:]))
+	if _, err := io.ReadFull(conn, data); err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	return readTestResponse(t, slices.Concat(header[:], rest, data))
+}
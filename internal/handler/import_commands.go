@@ -0,0 +1,268 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"memory-tools/internal/wal"
+	"net"
+	"slices"
+	"time"
+)
+
+const (
+	// defaultImportBatchSize is used when the client leaves the batch size to the server.
+	defaultImportBatchSize = 1000
+	// maxImportBatchSize bounds the documents held in memory at once during an import.
+	maxImportBatchSize = 50000
+)
+
+// ImportProgress is the payload of every IMPORT_COLLECTION response. Documents are committed a
+// batch at a time, so after a failure a client can resume the import by skipping the first
+// Processed documents of its input; LastCommittedID names the last document that was stored.
+type ImportProgress struct {
+	Inserted        int    `json:"inserted"`
+	Skipped         int    `json:"skipped"`   // Documents whose _id already exists.
+	Failed          int    `json:"failed"`    // Documents that are not JSON objects, break the schema, could not get an ID or repeat a unique value.
+	Processed       int    `json:"processed"` // Documents read from the stream and committed or rejected.
+	LastCommittedID string `json:"last_committed_id,omitempty"`
+}
+
+// drainImport reads the rest of an import stream so the connection can be used for the next
+// command after the import is rejected.
+func drainImport(r io.Reader) error {
+	for {
+		doc, err := protocol.ReadImportDocument(r)
+		if err != nil || doc == nil {
+			return err
+		}
+	}
+}
+
+// handleCollectionImport processes the CmdCollectionImport command. Documents arrive one at a time
+// and are stored in batches with the key-uniqueness rules of SET_MANY: documents without an _id
+// get a generated one, documents whose _id already exists are skipped, and documents that break
+// the collection's schema or would repeat a value of a unique index are counted as failed. Every committed batch
+// is logged to the WAL as a SET_MANY and answered with a StatusPartial response carrying the
+// running ImportProgress; the final response carries the totals.
+func (h *ConnectionHandler) handleCollectionImport(r io.Reader, conn net.Conn) {
+	collectionName, batchSize, err := protocol.ReadCollectionImportCommand(r)
+	if err != nil {
+		slog.Error("Failed to read IMPORT_COLLECTION command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid IMPORT_COLLECTION command format", nil)
+		return
+	}
+	reject := func(status protocol.ResponseStatus, msg string) {
+		if err := drainImport(r); err != nil {
+			slog.Error("Failed to read rejected IMPORT_COLLECTION stream", "error", err, "remote_addr", conn.RemoteAddr().String())
+			return
+		}
+		protocol.WriteResponse(conn, status, msg, nil)
+	}
+	if collectionName == "" {
+		reject(protocol.StatusBadRequest, "Collection name cannot be empty")
+		return
+	}
+	if reason := h.CollectionManager.ReadOnlyReason(); reason != nil {
+		reject(protocol.StatusError, fmt.Sprintf("ERROR: Server is in read-only mode because saving data to disk keeps failing (%v). Writes are rejected until persistence recovers.", reason))
+		return
+	}
+	if h.CurrentTransactionID != "" {
+		reject(protocol.StatusError, "ERROR: Collections cannot be imported inside a transaction.")
+		return
+	}
+	if !h.hasPermission(collectionName, globalconst.PermissionWrite) {
+		slog.Warn("Unauthorized collection import attempt", "user", h.AuthenticatedUser, "collection", collectionName)
+		reject(protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have write permission for collection '%s'", collectionName))
+		return
+	}
+	if collectionName == globalconst.SystemCollectionName {
+		reject(protocol.StatusUnauthorized, "UNAUTHORIZED: Documents cannot be imported into the system collection; use 'user import' for users.")
+		return
+	}
+	if !h.CollectionManager.CollectionExists(collectionName) {
+		reject(protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist. Please create it first.", collectionName))
+		return
+	}
+	if batchSize == 0 {
+		batchSize = defaultImportBatchSize
+	}
+	if batchSize > maxImportBatchSize {
+		batchSize = maxImportBatchSize
+	}
+	recordCollectionWrite(collectionName)
+	colStore := h.CollectionManager.GetCollection(collectionName)
+	// Documents are checked against the schema the collection has when the import starts.
+	schema := h.collectionSchema(collectionName)
+
+	var progress ImportProgress
+	respond := func(status protocol.ResponseStatus, msg string) error {
+		data, _ := json.Marshal(progress)
+		return protocol.WriteResponse(conn, status, msg, data)
+	}
+	// fail ends an import stopped by a server-side error once the client has sent everything.
+	fail := func(msg string) {
+		if err := drainImport(r); err != nil {
+			slog.Error("Failed to read IMPORT_COLLECTION stream after an error", "error", err, "remote_addr", conn.RemoteAddr().String())
+			return
+		}
+		respond(protocol.StatusError, msg)
+	}
+
+	batch := make([]map[string]any, 0, batchSize)
+	// pending counts the documents read since the last commit, invalid ones included, so
+	// Processed only ever moves past committed batches.
+	pending, pendingInvalid := 0, 0
+	// commit stores the pending batch and reports the progress to the client.
+	commit := func() error {
+		if pending == 0 {
+			return nil
+		}
+		// _id values repeated within the batch are skipped like any other existing key.
+		unique := make([]map[string]any, 0, len(batch))
+		seen := make(map[string]struct{}, len(batch))
+		repeated := 0
+		for _, record := range batch {
+			if key, ok := record[globalconst.ID].(string); ok && key != "" {
+				if _, dup := seen[key]; dup {
+					repeated++
+					continue
+				}
+				seen[key] = struct{}{}
+			}
+			unique = append(unique, record)
+		}
+
+		records, duplicateKeys, invalidCount, err := h.checkNewRecordKeys(collectionName, colStore, unique, false)
+		if err != nil {
+			return fmt.Errorf("batch key validation failed: %w", err)
+		}
+
+		// Documents that would repeat a unique value, of the store or of an earlier document of
+		// the batch, are dropped one at a time until the rest of the batch can be written. The
+		// lock is held until the batch is stored so no other write can take the values meanwhile.
+		recordsByKey := make(map[string]map[string]any, len(records))
+		for _, record := range records {
+			recordsByKey[record[globalconst.ID].(string)] = record
+		}
+		unlock := h.CollectionManager.LockUniqueIndexes(collectionName)
+		conflicts := 0
+		for {
+			err := h.CollectionManager.CheckUniqueIndexes(collectionName, recordsByKey, false, false)
+			if err == nil {
+				break
+			}
+			var violation *store.UniqueViolationError
+			if !errors.As(err, &violation) {
+				unlock()
+				return fmt.Errorf("unique index check failed: %w", err)
+			}
+			slog.Debug("Import document rejected by unique index", "collection", collectionName, "field", violation.Field, "key", violation.Key)
+			delete(recordsByKey, violation.Key)
+			conflicts++
+		}
+		if conflicts > 0 {
+			records = slices.DeleteFunc(records, func(record map[string]any) bool {
+				_, kept := recordsByKey[record[globalconst.ID].(string)]
+				return !kept
+			})
+		}
+
+		if len(records) > 0 && h.Wal != nil {
+			// The batch is logged as a SET_MANY so recovery replays it with the keys assigned here.
+			recordsJSON, err := json.Marshal(records)
+			if err != nil {
+				unlock()
+				return fmt.Errorf("failed to encode batch for the WAL: %w", err)
+			}
+			var payload bytes.Buffer
+			protocol.WriteString(&payload, collectionName)
+			protocol.WriteBytes(&payload, recordsJSON)
+			if err := h.Wal.Write(wal.WalEntry{CommandType: protocol.CmdCollectionItemSetMany, Payload: payload.Bytes()}); err != nil {
+				slog.Error("CRITICAL: Failed to write import batch to WAL", "collection", collectionName, "error", err)
+				unlock()
+				return fmt.Errorf("could not persist batch: %w", err)
+			}
+		}
+
+		ttl := h.CollectionManager.DefaultTTL(collectionName)
+		now := time.Now()
+		nowStr := now.UTC().Format(time.RFC3339)
+		for _, record := range records {
+			store.SetCreationTime(record, now)
+			record[globalconst.UPDATED_AT] = nowStr
+			store.SetVersion(record, 1)
+			value, err := json.Marshal(record)
+			if err != nil {
+				slog.Warn("Failed to marshal record in import batch, skipping", "key", record[globalconst.ID], "error", err)
+				progress.Failed++
+				continue
+			}
+			key := record[globalconst.ID].(string)
+			colStore.Set(key, value, ttl)
+			publishChange(collectionName, changeOpSet, key, value)
+			progress.Inserted++
+			progress.LastCommittedID = key
+		}
+		unlock()
+		progress.Skipped += len(duplicateKeys) + repeated
+		progress.Failed += invalidCount + conflicts + pendingInvalid
+		progress.Processed += pending
+		batch = batch[:0]
+		pending, pendingInvalid = 0, 0
+		return respond(protocol.StatusPartial, "")
+	}
+
+	var importErr error
+	for importErr == nil {
+		doc, err := protocol.ReadImportDocument(r)
+		if err != nil {
+			// The stream is broken, so no response can be framed; the connection is dropped by the client.
+			slog.Error("Failed to read IMPORT_COLLECTION document", "collection", collectionName, "processed", progress.Processed, "error", err)
+			break
+		}
+		if doc == nil {
+			importErr = commit()
+			if importErr == nil {
+				if progress.Inserted > 0 {
+					h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
+				}
+				slog.Info("Collection import completed", "user", h.AuthenticatedUser, "collection", collectionName, "inserted", progress.Inserted, "skipped", progress.Skipped, "failed", progress.Failed)
+				respond(protocol.StatusOk, fmt.Sprintf("OK: Imported %d documents into collection '%s'. %d were skipped due to existing keys and %d failed.", progress.Inserted, collectionName, progress.Skipped, progress.Failed))
+				return
+			}
+			break
+		}
+		pending++
+		var record map[string]any
+		if err := json.Unmarshal(doc, &record); err != nil || record == nil {
+			// Invalid documents are not stored, but they are counted with the batch they arrived in.
+			pendingInvalid++
+			continue
+		}
+		if schema != nil {
+			if err := schema.Validate(record); err != nil {
+				slog.Debug("Import document rejected by collection schema", "collection", collectionName, "error", err)
+				pendingInvalid++
+				continue
+			}
+		}
+		batch = append(batch, record)
+		if pending == int(batchSize) {
+			importErr = commit()
+		}
+	}
+
+	if progress.Inserted > 0 {
+		h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
+	}
+	if importErr != nil {
+		slog.Error("Collection import stopped", "collection", collectionName, "processed", progress.Processed, "error", importErr)
+		fail(fmt.Sprintf("ERROR: Import into collection '%s' stopped after %d documents: %v", collectionName, progress.Processed, importErr))
+	}
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"memory-tools/internal/protocol"
+	"testing"
+)
+
+// runImport streams docs to an import with the given batch size and returns its final progress.
+func (e *testEnv) runImport(collectionName string, batchSize uint32, docs ...string) ImportProgress {
+	e.t.Helper()
+	var cmd bytes.Buffer
+	protocol.WriteCollectionImportCommand(&cmd, collectionName, batchSize)
+	for _, doc := range docs {
+		protocol.WriteImportDocument(&cmd, []byte(doc))
+	}
+	protocol.WriteImportEnd(&cmd)
+	cmd.Next(1)
+	conn := &testConn{}
+	e.handler().handleCollectionImport(&cmd, conn)
+
+	// Every batch is answered with a partial response before the final one.
+	out := conn.out.Bytes()
+	var resp testResponse
+	for len(out) > 0 {
+		resp = readTestResponse(e.t, out)
+		out = out[9+len(resp.msg)+len(resp.data):]
+		if resp.status != protocol.StatusPartial {
+			break
+		}
+	}
+	expectStatus(e.t, resp, protocol.StatusOk)
+	var progress ImportProgress
+	if err := json.Unmarshal(resp.data, &progress); err != nil {
+		e.t.Fatalf("import progress %q: %v", resp.data, err)
+	}
+	return progress
+}
+
+// createUniqueIndex creates a unique index through its command.
+func (e *testEnv) createUniqueIndex(collectionName, field string) {
+	e.t.Helper()
+	resp := e.run(e.handler().HandleCollectionIndexCreateWithOptions, func(w io.Writer) error {
+		return protocol.WriteCollectionIndexCreateWithOptionsCommand(w, collectionName, field, []byte(`{"unique":true}`))
+	})
+	expectStatus(e.t, resp, protocol.StatusOk)
+}
+
+func TestImportRejectsRepeatedUniqueValues(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("users")
+	env.createUniqueIndex("users", "email")
+	expectStatus(t, env.run(env.handler().HandleCollectionItemSet, func(w io.Writer) error {
+		return protocol.WriteCollectionItemSetCommand(w, "users", "u0", []byte(`{"email":"a@example.com"}`), 0)
+	}), protocol.StatusOk)
+
+	progress := env.runImport("users", 2,
+		`{"_id":"u1","email":"a@example.com"}`, // Taken by a stored document.
+		`{"_id":"u2","email":"b@example.com"}`,
+		`{"_id":"u3","email":"b@example.com"}`, // Taken by an earlier document of the import.
+		`{"_id":"u4","email":"c@example.com"}`,
+	)
+	if progress.Inserted != 2 || progress.Failed != 2 || progress.Processed != 4 {
+		t.Fatalf("progress = %+v, want 2 inserted and 2 failed of 4", progress)
+	}
+	users := env.cm.GetCollection("users")
+	for key, want := range map[string]bool{"u1": false, "u2": true, "u3": false, "u4": true} {
+		if _, found := users.Get(key); found != want {
+			t.Errorf("key '%s' stored = %v, want %v", key, found, want)
+		}
+	}
+}
+
+func TestImportRejectsDocumentsBreakingTheSchema(t *testing.T) {
+	env := newTestEnv(t)
+	env.schemaCollection("people")
+
+	progress := env.runImport("people", 0,
+		`{"_id":"p1","name":"Ada","age":36}`,
+		`{"_id":"p2","age":40}`,               // Missing the required name.
+		`{"_id":"p3","name":"Bob","age":"x"}`, // Age is not an integer.
+	)
+	if progress.Inserted != 1 || progress.Failed != 2 || progress.Processed != 3 {
+		t.Fatalf("progress = %+v, want 1 inserted and 2 failed of 3", progress)
+	}
+	if _, found := env.cm.GetCollection("people").Get("p2"); found {
+		t.Fatal("document breaking the schema was imported")
+	}
+}
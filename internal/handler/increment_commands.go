@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"net"
+	"time"
+)
+
+// IncrementResult is the response payload of the INCREMENT_COLLECTION_ITEM command.
+type IncrementResult struct {
+	Field string  `json:"field"`
+	Value float64 `json:"value"`
+}
+
+// HandleCollectionItemIncrement processes the CmdCollectionItemIncrement command. It is a write operation.
+// The delta is added to a numeric field while the item's shard is locked, so concurrent increments
+// never lose an update. A missing field is created with the delta as its value.
+func (h *ConnectionHandler) HandleCollectionItemIncrement(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	collectionName, key, field, delta, err := protocol.ReadCollectionItemIncrementCommand(r)
+	if err != nil {
+		slog.Error("Failed to read INCREMENT_COLLECTION_ITEM command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid INCREMENT_COLLECTION_ITEM command format", nil)
+		}
+		return
+	}
+
+	if conn != nil {
+		if collectionName == "" || key == "" || field == "" {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name, key, or field cannot be empty", nil)
+			return
+		}
+		if field == globalconst.UPDATED_AT || store.IsManagedField(field) {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Field '%s' is managed by the server and cannot be incremented", field), nil)
+			return
+		}
+		if math.IsNaN(delta) || math.IsInf(delta, 0) {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Delta must be a finite number", nil)
+			return
+		}
+		if !h.hasPermission(collectionName, globalconst.PermissionWrite) {
+			slog.Warn("Unauthorized increment attempt", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have write permission for collection '%s'", collectionName), nil)
+			return
+		}
+		if !h.CollectionManager.CollectionExists(collectionName) {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist.", collectionName), nil)
+			return
+		}
+		recordCollectionWrite(collectionName)
+	}
+
+	// increment applies the delta to a stored value and returns the updated document and field value.
+	increment := func(current []byte, touch bool) ([]byte, float64, error) {
+		var existingData map[string]any
+		if err := json.Unmarshal(current, &existingData); err != nil {
+			return nil, 0, fmt.Errorf("could not unmarshal existing data: %w", err)
+		}
+		newValue, err := store.IncrementField(existingData, field, delta)
+		if err != nil {
+			return nil, 0, err
+		}
+		if touch {
+			existingData[globalconst.UPDATED_AT] = time.Now().UTC().Format(time.RFC3339)
+			store.BumpVersion(existingData)
+		}
+		updatedValue, err := json.Marshal(existingData)
+		if err != nil {
+			return nil, 0, fmt.Errorf("could not marshal updated data: %w", err)
+		}
+		return updatedValue, newValue, nil
+	}
+
+	colStore := h.CollectionManager.GetCollection(collectionName)
+
+	// Transactional logic: the commit fails if the field changed after it was read here.
+	if h.CurrentTransactionID != "" {
+		existingValue, found := colStore.Get(key)
+		if !found {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusNotFound, "Item not found in memory. Updates inside a transaction currently only support hot data.", nil)
+			}
+			return
+		}
+		var existingData map[string]any
+		json.Unmarshal(existingValue, &existingData)
+		observed, observedExists := existingData[field]
+
+		finalValue, newValue, err := increment(existingValue, false)
+		if err != nil {
+			h.writeIncrementError(conn, collectionName, key, err)
+			return
+		}
+		op := store.WriteOperation{
+			Collection: collectionName,
+			Key:        key,
+			Value:      finalValue,
+			OpType:     store.OpTypeUpdate,
+			Precondition: func(current []byte) bool {
+				var currentData map[string]any
+				if err := json.Unmarshal(current, &currentData); err != nil {
+					return false
+				}
+				value, exists := currentData[field]
+				return exists == observedExists && value == observed
+			},
+		}
+		if err := h.TransactionManager.RecordWrite(h.CurrentTransactionID, op); err != nil {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Failed to record increment in transaction: "+err.Error(), nil)
+			}
+			return
+		}
+		h.writeIncrementResult(conn, "OK: Increment queued in transaction.", IncrementResult{Field: field, Value: newValue})
+		return
+	}
+
+	// Non-transactional logic (hot/cold)
+	var newValue float64
+	var updatedValue []byte
+	var incrementErr error
+	found, applied, err := colStore.UpdateIf(key, func(current []byte) ([]byte, bool) {
+		updatedValue, newValue, incrementErr = increment(current, true)
+		return updatedValue, incrementErr == nil
+	})
+	if err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusError, "ERROR: "+err.Error(), nil)
+		}
+		return
+	}
+	if found {
+		if !applied {
+			h.writeIncrementError(conn, collectionName, key, incrementErr)
+			return
+		}
+		h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
+		publishChange(collectionName, changeOpUpdate, key, updatedValue)
+		slog.Debug("Item field incremented (hot)", "collection", collectionName, "key", key, "field", field, "value", newValue)
+		h.writeIncrementResult(conn, fmt.Sprintf("OK: Field '%s' of key '%s' incremented in collection '%s'", field, key, collectionName), IncrementResult{Field: field, Value: newValue})
+		return
+	}
+
+	fileLock := h.CollectionManager.GetFileLock(collectionName)
+	fileLock.Lock()
+	newValue, found, err = persistence.IncrementColdItemField(collectionName, key, field, delta)
+	fileLock.Unlock()
+
+	if err != nil {
+		h.writeIncrementError(conn, collectionName, key, err)
+		return
+	}
+	if !found {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Key '%s' not found in collection '%s'", key, collectionName), nil)
+		}
+		return
+	}
+	publishChange(collectionName, changeOpUpdate, key, nil)
+	slog.Debug("Item field incremented (cold)", "collection", collectionName, "key", key, "field", field, "value", newValue)
+	h.writeIncrementResult(conn, fmt.Sprintf("OK: Field '%s' of cold item '%s' incremented in collection '%s'", field, key, collectionName), IncrementResult{Field: field, Value: newValue})
+}
+
+// writeIncrementResult sends the new value of an incremented field, if there is a client connection.
+func (h *ConnectionHandler) writeIncrementResult(conn net.Conn, msg string, result IncrementResult) {
+	if conn == nil {
+		return
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to marshal increment result", nil)
+		return
+	}
+	protocol.WriteResponse(conn, protocol.StatusOk, msg, data)
+}
+
+// writeIncrementError reports a failed increment: a non-numeric field is the client's fault,
+// anything else is a server error.
+func (h *ConnectionHandler) writeIncrementError(conn net.Conn, collectionName, key string, err error) {
+	if errors.Is(err, store.ErrFieldNotNumeric) {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Cannot increment key '%s': %v", key, err), nil)
+		}
+		return
+	}
+	slog.Error("Failed to increment item field", "collection", collectionName, "key", key, "error", err)
+	if conn != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to increment item field", nil)
+	}
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"net"
+	"slices"
+	"sort"
+)
+
+// IndexAuditEntry reports how much of a collection's in-memory data an index covers.
+type IndexAuditEntry struct {
+	Field     string  `json:"field"`
+	Disabled  bool    `json:"disabled"`
+	Documents int     `json:"documents_with_field"`
+	Coverage  float64 `json:"coverage"` // Fraction of documents that have the field, from 0 to 1.
+	Orphaned  bool    `json:"orphaned"` // No document has the field: the index is a candidate for deletion.
+}
+
+// IndexAuditReport is the response payload of the AUDIT_COLLECTION_INDEXES command.
+type IndexAuditReport struct {
+	Collection     string            `json:"collection"`
+	TotalDocuments int               `json:"total_documents"`
+	Indexes        []IndexAuditEntry `json:"indexes"`
+}
+
+// auditIndexes counts, in a single parallel scan, how many documents carry each indexed field.
+// Indexes only cover data held in RAM, so cold documents are not scanned.
+func auditIndexes(colStore store.DataStore) (int, []IndexAuditEntry) {
+	fields := colStore.ListIndexes()
+	sort.Strings(fields)
+	disabled := colStore.ListDisabledIndexes()
+
+	// Each shard is scanned by its own goroutine, so it gets its own counters.
+	shardCounts := make([][]int, colStore.ShardCount())
+	shardTotals := make([]int, colStore.ShardCount())
+	for i := range shardCounts {
+		shardCounts[i] = make([]int, len(fields))
+	}
+	colStore.ParallelStreamAll(func(shardIndex int, key string, value []byte) bool {
+		var doc map[string]any
+		if err := json.Unmarshal(value, &doc); err != nil {
+			return true
+		}
+		shardTotals[shardIndex]++
+		for i, field := range fields {
+			if _, ok := getNestedValue(doc, field); ok {
+				shardCounts[shardIndex][i]++
+			}
+		}
+		return true
+	})
+
+	total := 0
+	for _, shardTotal := range shardTotals {
+		total += shardTotal
+	}
+	entries := make([]IndexAuditEntry, len(fields))
+	for i, field := range fields {
+		documents := 0
+		for _, counts := range shardCounts {
+			documents += counts[i]
+		}
+		entries[i] = IndexAuditEntry{
+			Field:     field,
+			Disabled:  slices.Contains(disabled, field),
+			Documents: documents,
+			Orphaned:  documents == 0,
+		}
+		if total > 0 {
+			entries[i].Coverage = float64(documents) / float64(total)
+		}
+	}
+	return total, entries
+}
+
+// handleCollectionIndexAudit processes the CmdCollectionIndexAudit command.
+// It reports each index's coverage and flags indexes on fields that no document has anymore.
+func (h *ConnectionHandler) handleCollectionIndexAudit(r io.Reader, conn net.Conn) {
+	collectionName, err := protocol.ReadCollectionIndexAuditCommand(r)
+	if err != nil {
+		slog.Error("Failed to read AUDIT_COLLECTION_INDEXES command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid AUDIT_COLLECTION_INDEXES command format", nil)
+		return
+	}
+	if collectionName == "" {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name cannot be empty", nil)
+		return
+	}
+	if !h.hasPermission(collectionName, globalconst.PermissionMetadata) {
+		slog.Warn("Unauthorized index audit attempt", "user", h.AuthenticatedUser, "collection", collectionName)
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have metadata permission for collection '%s'", collectionName), nil)
+		return
+	}
+	if !h.CollectionManager.CollectionExists(collectionName) {
+		protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist", collectionName), nil)
+		return
+	}
+
+	report := IndexAuditReport{Collection: collectionName}
+	report.TotalDocuments, report.Indexes = auditIndexes(h.CollectionManager.GetCollection(collectionName))
+
+	orphaned := 0
+	for _, entry := range report.Indexes {
+		if entry.Orphaned {
+			orphaned++
+		}
+	}
+
+	responseData, err := json.Marshal(report)
+	if err != nil {
+		slog.Error("Failed to marshal index audit", "collection", collectionName, "error", err)
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to marshal index audit", nil)
+		return
+	}
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Audited %d indexes on collection '%s' (%d orphaned).", len(report.Indexes), collectionName, orphaned), responseData)
+}
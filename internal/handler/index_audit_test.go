@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"io"
+	"memory-tools/internal/protocol"
+	"testing"
+)
+
+func TestIndexAuditFlagsIndexOnAbsentField(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	env.setItem("items", "a", `{"status":"open","address":{"city":"Lima"}}`)
+	env.setItem("items", "b", `{"status":"closed"}`)
+	env.setItem("items", "c", `{"status":"open","address":{"city":"Quito"}}`)
+	env.setItem("items", "d", `{"other":true}`)
+	for _, field := range []string{"status", "address.city", "legacy_field"} {
+		expectStatus(t, env.run(env.handler().HandleCollectionIndexCreate, func(w io.Writer) error {
+			return protocol.WriteCollectionIndexCreateCommand(w, "items", field)
+		}), protocol.StatusOk)
+	}
+
+	resp := env.run(env.handler().handleCollectionIndexAudit, func(w io.Writer) error {
+		return protocol.WriteCollectionIndexAuditCommand(w, "items")
+	})
+	expectStatus(t, resp, protocol.StatusOk)
+	var report IndexAuditReport
+	if err := json.Unmarshal(resp.data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.TotalDocuments != 4 {
+		t.Errorf("total documents = %d, want 4", report.TotalDocuments)
+	}
+	byField := make(map[string]IndexAuditEntry)
+	for _, entry := range report.Indexes {
+		byField[entry.Field] = entry
+	}
+	want := map[string]IndexAuditEntry{
+		"status":       {Field: "status", Documents: 3, Coverage: 0.75},
+		"address.city": {Field: "address.city", Documents: 2, Coverage: 0.5},
+		"legacy_field": {Field: "legacy_field", Documents: 0, Coverage: 0, Orphaned: true},
+	}
+	for field, entry := range want {
+		if byField[field] != entry {
+			t.Errorf("audit of %s = %+v, want %+v", field, byField[field], entry)
+		}
+	}
+	for _, entry := range report.Indexes {
+		if entry.Orphaned && entry.Field != "legacy_field" {
+			t.Errorf("index %s flagged as orphaned: %+v", entry.Field, entry)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/store"
+	"time"
+)
+
+// isCountOnlyQuery reports whether a query asks for nothing but the number of matching documents.
+func isCountOnlyQuery(query *Query) bool {
+	return query.Count && len(query.Filter) > 0 && query.Limit == nil &&
+		len(query.Aggregations) == 0 && len(query.GroupBy) == 0 && query.Distinct == "" && len(query.Lookups) == 0
+}
+
+// countFromIndexes counts the documents matching filter without loading the hot ones: when the
+// whole filter is resolved by indexes, the candidate keys are the hot matches. Cold documents are
+// still matched on disk, but only counted. It reports false when some of the filter needs the
+// documents themselves, in which case the regular query path must run.
+func (h *ConnectionHandler) countFromIndexes(collectionName string, colStore store.DataStore, filter map[string]any, stats *QueryStats) (int, bool, error) {
+	hotScanStart := time.Now()
+	candidateKeys, usedIndex, remainingFilter := h.findCandidateKeysFromFilter(colStore, filter)
+	if !usedIndex || len(remainingFilter) > 0 {
+		return 0, false, nil
+	}
+	if stats != nil {
+		stats.Path = "index_count"
+		stats.IndexesUsed = indexedFilterFields(colStore, filter)
+		stats.HotMatched = len(candidateKeys)
+		stats.Returned = 1
+		stats.recordPhase("hot_scan", hotScanStart)
+	}
+
+	// When every document is in memory, the file holds nothing more.
+	if !hasColdData(colStore) {
+		return len(candidateKeys), true, nil
+	}
+
+	coldScanStart := time.Now()
+	coldScanned, coldMatched := 0, 0
+	err := persistence.ScanColdData(collectionName, func(item map[string]any) bool {
+		coldScanned++
+		// Documents held in memory were already counted, or did not match there.
+		if id, ok := item[globalconst.ID].(string); ok {
+			if _, inHot := colStore.Get(id); inHot {
+				return false
+			}
+		}
+		if h.matchFilter(item, filter) {
+			coldMatched++
+		}
+		return false
+	}, func(doc map[string]any) bool { return true })
+	if err != nil {
+		return 0, false, fmt.Errorf("error searching cold data: %w", err)
+	}
+	slog.Debug("Index count finished", "collection", collectionName, "hot_matches", len(candidateKeys), "cold_matches", coldMatched)
+	if stats != nil {
+		stats.ColdScanned = coldScanned
+		stats.ColdMatched = coldMatched
+		stats.recordPhase("cold_scan", coldScanStart)
+	}
+	return len(candidateKeys) + coldMatched, true, nil
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/protocol"
+	"net"
+	"slices"
+)
+
+const (
+	// defaultKeyScanLimit is the page size of a key scan that leaves it to the server.
+	defaultKeyScanLimit = 1000
+	// maxKeyScanLimit bounds the keys returned by a single key scan page.
+	maxKeyScanLimit = 10000
+)
+
+// KeyScanPage is the response data of SCAN_COLLECTION_KEYS. NextCursor is the last key of the
+// page when more keys follow, and empty on the last page.
+type KeyScanPage struct {
+	Keys       []string `json:"keys"`
+	NextCursor string   `json:"next_cursor"`
+}
+
+// handleCollectionKeyScan processes the CmdCollectionKeyScan command. It is a read-only operation.
+// Keys starting with the prefix are returned in ascending order, a page at a time; each page
+// starts after the cursor, which is simply the last key of the previous page, so keys added or
+// deleted between pages never make the scan repeat or skip the others. With includeCold, keys
+// only stored in the collection file are merged in, at the cost of reading the file's keys.
+func (h *ConnectionHandler) handleCollectionKeyScan(r io.Reader, conn net.Conn) {
+	collectionName, prefix, after, limit, includeCold, err := protocol.ReadCollectionKeyScanCommand(r)
+	if err != nil {
+		slog.Error("Failed to read SCAN_COLLECTION_KEYS command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid SCAN_COLLECTION_KEYS command format", nil)
+		return
+	}
+	if collectionName == "" {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name cannot be empty", nil)
+		return
+	}
+	if limit < 0 {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Limit cannot be negative", nil)
+		return
+	}
+	if limit == 0 {
+		limit = defaultKeyScanLimit
+	}
+	if limit > maxKeyScanLimit {
+		limit = maxKeyScanLimit
+	}
+	if !h.hasPermission(collectionName, globalconst.PermissionRead) {
+		slog.Warn("Unauthorized collection key scan attempt", "user", h.AuthenticatedUser, "collection", collectionName)
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have read permission for collection '%s'", collectionName), nil)
+		return
+	}
+	if !h.CollectionManager.CollectionExists(collectionName) {
+		protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist", collectionName), nil)
+		return
+	}
+	recordCollectionRead(collectionName)
+	colStore := h.CollectionManager.GetCollection(collectionName)
+
+	keys := colStore.KeysWithPrefix(prefix, after)
+	if includeCold {
+		hotKeys := make(map[string]struct{}, len(keys))
+		for _, key := range keys {
+			hotKeys[key] = struct{}{}
+		}
+		err := persistence.ScanColdKeys(collectionName, prefix, func(key string) bool {
+			if _, inMemory := hotKeys[key]; !inMemory && key > after {
+				hotKeys[key] = struct{}{}
+				keys = append(keys, key)
+			}
+			return true
+		})
+		if err != nil {
+			slog.Error("Error reading cold keys during key scan", "collection", collectionName, "error", err)
+			protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("ERROR: Failed to read the keys of collection '%s' from disk: %v", collectionName, err), nil)
+			return
+		}
+		slices.Sort(keys)
+	}
+
+	page := KeyScanPage{Keys: keys}
+	if len(keys) > limit {
+		page.Keys = keys[:limit]
+		page.NextCursor = page.Keys[limit-1]
+	}
+	if page.Keys == nil {
+		page.Keys = []string{}
+	}
+	responseData, err := json.Marshal(page)
+	if err != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to serialize scanned keys", nil)
+		return
+	}
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: %d keys with prefix '%s' in collection '%s'.", len(page.Keys), prefix, collectionName), responseData)
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"net"
+	"sort"
+)
+
+// CollectionMemoryStats reports the memory held by one collection.
+type CollectionMemoryStats struct {
+	Collection string `json:"collection"`
+	store.MemoryStats
+}
+
+// MemoryStatsReport is the response payload of the MEMORY_STATS command.
+type MemoryStatsReport struct {
+	MaxBytesPerCollection int64                   `json:"max_bytes_per_collection"` // 0 when the memory cap is disabled.
+	EvictionPolicy        store.EvictionPolicy    `json:"eviction_policy"`
+	TotalBytes            int64                   `json:"total_bytes"`
+	Collections           []CollectionMemoryStats `json:"collections"`
+}
+
+// handleMemoryStats processes the CmdMemoryStats command. It is root-only.
+// It reports the approximate RAM used by each collection next to the memory cap, so operators
+// can see how close collections are to eviction.
+func (h *ConnectionHandler) handleMemoryStats(r io.Reader, conn net.Conn) {
+	if err := protocol.ReadMemoryStatsCommand(r); err != nil {
+		slog.Error("Failed to read MEMORY_STATS command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid MEMORY_STATS command format", nil)
+		return
+	}
+	if !h.IsRoot {
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Only root can inspect memory usage.", nil)
+		return
+	}
+
+	report := MemoryStatsReport{}
+	report.MaxBytesPerCollection, report.EvictionPolicy = h.CollectionManager.MemoryCap()
+	names := h.CollectionManager.ListCollections()
+	sort.Strings(names)
+	report.Collections = make([]CollectionMemoryStats, 0, len(names))
+	for _, name := range names {
+		stats := CollectionMemoryStats{Collection: name, MemoryStats: h.CollectionManager.GetCollection(name).MemoryStats()}
+		report.TotalBytes += stats.Bytes
+		report.Collections = append(report.Collections, stats)
+	}
+
+	responseData, err := json.Marshal(report)
+	if err != nil {
+		slog.Error("Failed to marshal memory stats", "error", err)
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to marshal memory stats", nil)
+		return
+	}
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: %d collections hold about %d bytes in memory.", len(report.Collections), report.TotalBytes), responseData)
+}
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"net"
+	"time"
+)
+
+// HandleCollectionItemMergeByQuery processes the CmdCollectionItemMergeByQuery command. It is a write operation.
+// The patch is deep-merged into every document matching the filter following RFC 7386: nested objects
+// are merged, other values replace the field and null removes it. Each document is merged atomically,
+// and the filter is re-checked against its current value at that moment.
+func (h *ConnectionHandler) HandleCollectionItemMergeByQuery(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	collectionName, filterValue, patchValue, err := protocol.ReadCollectionItemMergeByQueryCommand(r)
+	if err != nil {
+		slog.Error("Failed to read MERGE_COLLECTION_ITEMS_BY_QUERY command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid MERGE_COLLECTION_ITEMS_BY_QUERY command format", nil)
+		}
+		return
+	}
+
+	if conn != nil {
+		if collectionName == "" || len(filterValue) == 0 || len(patchValue) == 0 {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name, filter, or patch cannot be empty", nil)
+			return
+		}
+		if collectionName == globalconst.SystemCollectionName {
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Merging into documents of the system collection is not allowed", nil)
+			return
+		}
+		if !h.hasPermission(collectionName, globalconst.PermissionWrite) {
+			slog.Warn("Unauthorized merge-by-query attempt", "user", h.AuthenticatedUser, "collection", collectionName)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have write permission for collection '%s'", collectionName), nil)
+			return
+		}
+		if !h.CollectionManager.CollectionExists(collectionName) {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist.", collectionName), nil)
+			return
+		}
+		recordCollectionWrite(collectionName)
+	}
+
+	var filter, patch map[string]any
+	if err := json.Unmarshal(filterValue, &filter); err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Invalid filter JSON format. Must be a filter object.", nil)
+		}
+		return
+	}
+	if len(filter) == 0 {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Filter cannot be empty. Merging into every document must be requested with an explicit filter.", nil)
+		}
+		return
+	}
+	if err := compileRegexFilters(filter); err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Invalid filter: %v", err), nil)
+		}
+		return
+	}
+	if err := json.Unmarshal(patchValue, &patch); err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Invalid patch JSON format. Must be an object.", nil)
+		}
+		return
+	}
+	if !h.validateDocuments(conn, collectionName, true, patch) {
+		return
+	}
+
+	// mergeIfMatches re-evaluates the filter against a stored value and returns the merged value.
+	mergeIfMatches := func(current []byte, touch bool) ([]byte, bool) {
+		var existingData map[string]any
+		if err := json.Unmarshal(current, &existingData); err != nil {
+			return nil, false
+		}
+		if !h.matchFilter(existingData, filter) {
+			return nil, false
+		}
+		store.MergePatch(existingData, patch)
+		if touch {
+			existingData[globalconst.UPDATED_AT] = time.Now().UTC().Format(time.RFC3339)
+			store.BumpVersion(existingData)
+		}
+		mergedValue, err := json.Marshal(existingData)
+		if err != nil {
+			return nil, false
+		}
+		return mergedValue, true
+	}
+
+	colStore := h.CollectionManager.GetCollection(collectionName)
+	candidateKeys, usedIndex, _ := h.findCandidateKeysFromFilter(colStore, filter)
+	if !usedIndex {
+		hotMatches, _ := h.parallelScan(colStore, filter)
+		candidateKeys = make([]string, 0, len(hotMatches))
+		for key := range hotMatches {
+			candidateKeys = append(candidateKeys, key)
+		}
+	}
+
+	// Transactional logic: only hot documents are merged, and the filter is re-checked during the prepare phase.
+	if h.CurrentTransactionID != "" {
+		queued := 0
+		for _, key := range candidateKeys {
+			existingValue, found := colStore.Get(key)
+			if !found {
+				continue
+			}
+			finalValue, matched := mergeIfMatches(existingValue, false)
+			if !matched {
+				continue
+			}
+			op := store.WriteOperation{
+				Collection: collectionName,
+				Key:        key,
+				Value:      finalValue,
+				OpType:     store.OpTypeUpdate,
+				Precondition: func(current []byte) bool {
+					var currentData map[string]any
+					if err := json.Unmarshal(current, &currentData); err != nil {
+						return false
+					}
+					return h.matchFilter(currentData, filter)
+				},
+			}
+			if err := h.TransactionManager.RecordWrite(h.CurrentTransactionID, op); err != nil {
+				if conn != nil {
+					protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Failed to record merge in transaction: "+err.Error(), nil)
+				}
+				return
+			}
+			queued++
+		}
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: %d merge operations queued in transaction.", queued), nil)
+		}
+		return
+	}
+
+	// Non-transactional logic (hot/cold)
+	mergedHotCount := 0
+	for _, key := range candidateKeys {
+		var mergedValue []byte
+		_, applied, err := colStore.UpdateIf(key, func(current []byte) ([]byte, bool) {
+			var matched bool
+			mergedValue, matched = mergeIfMatches(current, true)
+			return mergedValue, matched
+		})
+		if err != nil {
+			slog.Warn("Merge-by-query skipped a locked item", "collection", collectionName, "key", key, "error", err)
+			continue
+		}
+		if applied {
+			mergedHotCount++
+			publishChange(collectionName, changeOpUpdate, key, mergedValue)
+		}
+	}
+	if mergedHotCount > 0 {
+		h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
+	}
+
+	mergedColdCount := 0
+	var mergedColdKeys []string
+	if hasColdData(colStore) {
+		hotThreshold := persistence.HotThreshold(int(coldStorageMonths.Load()))
+		fileLock := h.CollectionManager.GetFileLock(collectionName)
+		fileLock.Lock()
+		mergedColdCount, err = persistence.MergeColdItemsMatching(collectionName, patch, func(key string, doc map[string]any) bool {
+			if _, inHot := colStore.Get(key); inHot {
+				return false
+			}
+			if !isColdRecord(colStore, key, doc, hotThreshold) || !h.matchFilter(doc, filter) {
+				return false
+			}
+			mergedColdKeys = append(mergedColdKeys, key)
+			return true
+		})
+		fileLock.Unlock()
+
+		if err != nil {
+			slog.Error("Failed to merge cold items on disk", "collection", collectionName, "error", err)
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("%d hot items were merged, but merging cold items on disk failed", mergedHotCount), nil)
+			}
+			return
+		}
+		// Cold documents stay on disk, so their events carry no value.
+		for _, key := range mergedColdKeys {
+			publishChange(collectionName, changeOpUpdate, key, nil)
+		}
+	}
+
+	totalMerged := mergedHotCount + mergedColdCount
+	slog.Info("Merge-by-query completed", "user", h.AuthenticatedUser, "collection", collectionName, "hot_merged", mergedHotCount, "cold_merged", mergedColdCount)
+	if conn != nil {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: %d items merged in collection '%s' (%d hot, %d cold).", totalMerged, collectionName, mergedHotCount, mergedColdCount), nil)
+	}
+}
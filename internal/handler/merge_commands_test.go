@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"io"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/protocol"
+	"reflect"
+	"testing"
+)
+
+func TestMergeByQueryMergesNestedObjectIntoMatches(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	env.setItem("items", "hot_match", `{"team":"a","name":"x","flags":{"stale":true,"pinned":true}}`)
+	env.setItem("items", "hot_other", `{"team":"b","name":"y","flags":{"stale":true}}`)
+	persister := &persistence.CollectionPersisterImpl{}
+	cold := map[string][]byte{
+		"cold_match": []byte(`{"_id":"cold_match","team":"a","name":"z"}`),
+		"cold_other": []byte(`{"_id":"cold_other","team":"b","name":"w"}`),
+	}
+	if err := persister.WriteColdItems("items", cold); err != nil {
+		t.Fatalf("writing cold items: %v", err)
+	}
+	env.cm.GetCollection("items").MarkCold("cold_match", "cold_other")
+	otherBefore := env.storedDoc("items", "hot_other")
+
+	resp := env.run(env.handler().HandleCollectionItemMergeByQuery, func(w io.Writer) error {
+		return protocol.WriteCollectionItemMergeByQueryCommand(w, "items", []byte(`{"field":"team","op":"=","value":"a"}`), []byte(`{"flags":{"reviewed":true,"stale":null}}`))
+	})
+	expectStatus(t, resp, protocol.StatusOk)
+	if want := "OK: 2 items merged in collection 'items' (1 hot, 1 cold)."; resp.msg != want {
+		t.Fatalf("msg = %q, want %q", resp.msg, want)
+	}
+
+	doc := env.storedDoc("items", "hot_match")
+	if want := map[string]any{"reviewed": true, "pinned": true}; !reflect.DeepEqual(doc["flags"], want) {
+		t.Errorf("hot flags = %v, want %v", doc["flags"], want)
+	}
+	if doc["name"] != "x" {
+		t.Errorf("sibling field changed: %v", doc)
+	}
+	if doc := env.storedDoc("items", "hot_other"); !reflect.DeepEqual(doc, otherBefore) {
+		t.Errorf("unmatched hot document = %v, want %v", doc, otherBefore)
+	}
+
+	coldDoc := func(key string) map[string]any {
+		value, found, err := persistence.GetColdItem("items", key)
+		if err != nil || !found {
+			t.Fatalf("cold item %s: found=%v err=%v", key, found, err)
+		}
+		var doc map[string]any
+		if err := json.Unmarshal(value, &doc); err != nil {
+			t.Fatal(err)
+		}
+		return doc
+	}
+	if doc := coldDoc("cold_match"); !reflect.DeepEqual(doc["flags"], map[string]any{"reviewed": true}) || doc["name"] != "z" {
+		t.Errorf("merged cold document = %v", doc)
+	}
+	if doc := coldDoc("cold_other"); doc["flags"] != nil || doc["name"] != "w" {
+		t.Errorf("unmatched cold document = %v", doc)
+	}
+}
+
+func TestMergeByQueryInTransactionAppliesOnCommit(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	env.setItem("items", "k1", `{"team":"a","flags":{"pinned":true}}`)
+	env.setItem("items", "k2", `{"team":"a"}`)
+	h := env.handler()
+
+	expectStatus(t, env.run(h.handleBegin, protocol.WriteBeginCommand), protocol.StatusOk)
+	resp := env.run(h.HandleCollectionItemMergeByQuery, func(w io.Writer) error {
+		return protocol.WriteCollectionItemMergeByQueryCommand(w, "items", []byte(`{"field":"team","op":"=","value":"a"}`), []byte(`{"flags":{"reviewed":true}}`))
+	})
+	expectStatus(t, resp, protocol.StatusOk)
+	if _, merged := env.storedDoc("items", "k1")["flags"].(map[string]any)["reviewed"]; merged {
+		t.Fatal("queued merge is visible before commit")
+	}
+	// A document that stops matching before commit, through another connection, fails the transaction.
+	expectStatus(t, env.run(env.handler().HandleCollectionItemReplace, replaceCommand("items", "k2", `{"team":"b"}`)), protocol.StatusOk)
+	resp = env.run(h.HandleCommit, protocol.WriteCommitCommand)
+	if resp.status == protocol.StatusOk {
+		t.Fatal("commit succeeded although k2 no longer matches the filter")
+	}
+	if _, merged := env.storedDoc("items", "k1")["flags"].(map[string]any)["reviewed"]; merged {
+		t.Fatal("failed commit merged k1")
+	}
+
+	expectStatus(t, env.run(h.handleBegin, protocol.WriteBeginCommand), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCollectionItemMergeByQuery, func(w io.Writer) error {
+		return protocol.WriteCollectionItemMergeByQueryCommand(w, "items", []byte(`{"field":"team","op":"=","value":"a"}`), []byte(`{"flags":{"reviewed":true}}`))
+	}), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCommit, protocol.WriteCommitCommand), protocol.StatusOk)
+	if want := map[string]any{"pinned": true, "reviewed": true}; !reflect.DeepEqual(env.storedDoc("items", "k1")["flags"], want) {
+		t.Errorf("flags after commit = %v, want %v", env.storedDoc("items", "k1")["flags"], want)
+	}
+}
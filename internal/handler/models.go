@@ -4,10 +4,13 @@ import "sync"
 
 // LookupClause defines the structure for a collection join operation.
 type LookupClause struct {
-	FromCollection string `json:"from"`         // The collection to join with
-	LocalField     string `json:"localField"`   // Field from the input documents
-	ForeignField   string `json:"foreignField"` // Field from the documents of the "from" collection
-	As             string `json:"as"`           // The new array field to add to the input documents
+	FromCollection string         `json:"from"`                 // The collection to join with
+	LocalField     string         `json:"localField"`           // Field from the input documents
+	ForeignField   string         `json:"foreignField"`         // Field from the documents of the "from" collection
+	As             string         `json:"as"`                   // The new array field to add to the input documents
+	Type           string         `json:"type,omitempty"`       // "one" or "many"; empty attaches a single match as an object and anything else as an array
+	Filter         map[string]any `json:"filter,omitempty"`     // Extra conditions the joined documents must match
+	Projection     []string       `json:"projection,omitempty"` // Fields kept from the joined documents
 }
 
 // UserInfo structure holds user details and permissions.
@@ -15,23 +18,58 @@ type UserInfo struct {
 	Username     string            `json:"username"`
 	PasswordHash string            `json:"password_hash"`
 	IsRoot       bool              `json:"is_root,omitempty"`
-	Permissions  map[string]string `json:"permissions,omitempty"` // Key: collection name, Value: "read" or "write". "*" for all collections.
+	Permissions  map[string]string `json:"permissions,omitempty"` // Key: collection name, Value: "metadata", "read", "admin" or "write". "*" for all collections.
+	Roles        []string          `json:"roles,omitempty"`       // Names of roles whose permissions are merged with the user's own.
+}
+
+// RoleInfo is a named set of permissions that users can be given in addition to their own.
+type RoleInfo struct {
+	Name        string            `json:"name"`
+	Permissions map[string]string `json:"permissions"`
+}
+
+// APIKeyInfo is an API key as stored in the system collection. Only a hash of its secret is kept.
+type APIKeyInfo struct {
+	ID          string            `json:"id"`
+	SecretHash  string            `json:"secret_hash,omitempty"` // Hex SHA-256 of the secret part of the key.
+	Username    string            `json:"username"`
+	Permissions map[string]string `json:"permissions,omitempty"` // Replaces the user's permissions when set.
+	CreatedAt   string            `json:"created_at"`
+	ExpiresAt   string            `json:"expires_at,omitempty"` // RFC3339; empty for keys that never expire.
 }
 
 // Query defines the structure for a collection query command,
 // encompassing filtering, ordering, limiting, and aggregation.
 type Query struct {
-	Filter       map[string]any         `json:"filter,omitempty"`       // WHERE clause equivalents (AND, OR, NOT, LIKE, BETWEEN, IN, IS NULL)
-	OrderBy      []OrderByClause        `json:"order_by,omitempty"`     // ORDER BY clause
-	Limit        *int                   `json:"limit,omitempty"`        // LIMIT clause
-	Offset       int                    `json:"offset,omitempty"`       // OFFSET clause
-	Count        bool                   `json:"count,omitempty"`        // COUNT(*) equivalent
-	Aggregations map[string]Aggregation `json:"aggregations,omitempty"` // SUM, AVG, MIN, MAX
-	GroupBy      []string               `json:"group_by,omitempty"`     // GROUP BY clause
-	Having       map[string]any         `json:"having,omitempty"`       // HAVING clause (filters aggregated results)
-	Distinct     string                 `json:"distinct,omitempty"`     // DISTINCT field
-	Projection   []string               `json:"projection,omitempty"`
-	Lookups      []LookupClause         `json:"lookups,omitempty"`
+	Filter         map[string]any         `json:"filter,omitempty"`          // WHERE clause equivalents (AND, OR, NOT, LIKE, BETWEEN, IN, IS NULL)
+	OrderBy        []OrderByClause        `json:"order_by,omitempty"`        // ORDER BY clause
+	Limit          *int                   `json:"limit,omitempty"`           // LIMIT clause
+	Offset         int                    `json:"offset,omitempty"`          // OFFSET clause
+	Count          bool                   `json:"count,omitempty"`           // COUNT(*) equivalent
+	Aggregations   map[string]Aggregation `json:"aggregations,omitempty"`    // SUM, AVG, MIN, MAX
+	GroupBy        []string               `json:"group_by,omitempty"`        // GROUP BY clause
+	Having         map[string]any         `json:"having,omitempty"`          // HAVING clause (filters aggregated results)
+	Distinct       string                 `json:"distinct,omitempty"`        // DISTINCT field
+	DistinctCounts bool                   `json:"distinct_counts,omitempty"` // With "distinct", return {value, count} pairs instead of bare values
+	Projection     []string               `json:"projection,omitempty"`
+	Exclude        []string               `json:"exclude,omitempty"` // Return every field except these dot-separated paths
+	Lookups        []LookupClause         `json:"lookups,omitempty"`
+	Format         string                 `json:"format,omitempty"`      // Result format: "json" (default) or "csv"
+	SortedKeys     bool                   `json:"sorted_keys,omitempty"` // Serialize each JSON result document with its keys in sorted order
+	WithStats      bool                   `json:"with_stats,omitempty"`  // Wrap the results as {"results": ..., "stats": ...} with execution statistics
+	Estimate       bool                   `json:"estimate,omitempty"`    // With "count", extrapolate the count from a random sample of the documents
+	SampleRate     float64                `json:"sample_rate,omitempty"` // Fraction of documents sampled by an estimated count, in (0, 1]
+	Paginate       bool                   `json:"paginate,omitempty"`    // Return a page of "limit" results with a next_cursor for the following page
+	After          string                 `json:"after,omitempty"`       // Resume cursor pagination after the page that returned this next_cursor
+	Stream         bool                   `json:"stream,omitempty"`      // Send the result documents in StatusPartial chunks as they are produced
+	Explain        bool                   `json:"explain,omitempty"`     // Return the execution plan (QueryPlan) instead of the results
+}
+
+// DistinctCount is one value of a distinct query with "distinct_counts" and the number of
+// matching documents that have it.
+type DistinctCount struct {
+	Value any `json:"value"`
+	Count int `json:"count"`
 }
 
 // OrderByClause defines a single ordering criterion.
@@ -42,7 +80,7 @@ type OrderByClause struct {
 
 // Aggregation defines an aggregation function.
 type Aggregation struct {
-	Func  string `json:"func"`  // "sum", "avg", "min", "max", "count"
+	Func  string `json:"func"`  // "sum", "avg", "min", "max", "count", "count_distinct", "stddev", "stddev_sample", "variance", "variance_sample"
 	Field string `json:"field"` // Field to aggregate on, "*" for count
 }
 
@@ -57,8 +95,19 @@ func (q *Query) Reset() {
 	q.GroupBy = nil
 	q.Having = nil
 	q.Distinct = ""
+	q.DistinctCounts = false
 	q.Projection = nil
+	q.Exclude = nil
 	q.Lookups = nil
+	q.Format = ""
+	q.SortedKeys = false
+	q.WithStats = false
+	q.Estimate = false
+	q.SampleRate = 0
+	q.Paginate = false
+	q.After = ""
+	q.Stream = false
+	q.Explain = false
 }
 
 // A pool for Query objects to reduce memory allocation overhead.
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"bufio"
+	"memory-tools/internal/protocol"
+	"net"
+)
+
+// pipelineReadBufferSize is the size of the buffer commands are read through. A client that
+// pipelines small commands has many of them read from the socket at once.
+const pipelineReadBufferSize = 32 * 1024
+
+// pipelinedConn reads a connection through a buffer. Clients may pipeline commands, sending
+// several without waiting for their responses: HandleConnection runs them one at a time in the
+// order received and writes each response before reading the next command, so responses come
+// back in the same order. The buffer only saves the system calls of reading the small fields of
+// each command; every read of the connection, including those of handlers, must go through it.
+type pipelinedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func newPipelinedConn(conn net.Conn) *pipelinedConn {
+	return &pipelinedConn{Conn: conn, reader: bufio.NewReaderSize(conn, pipelineReadBufferSize)}
+}
+
+func (c *pipelinedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// discardCommandPayload reads and drops the payload of a command that is rejected before it
+// runs, so the next pipelined command is read from its start. It returns false if the payload
+// could not be skipped, as for streamed commands, which have no fixed layout; the connection
+// must then be closed.
+func discardCommandPayload(conn net.Conn, cmdType protocol.CommandType) bool {
+	if !protocol.HasFixedPayload(cmdType) {
+		return false
+	}
+	discardBuf := protocol.AcquirePayloadBuffer()
+	defer protocol.ReleasePayloadBuffer(discardBuf)
+	return protocol.ReadCommandPayloadInto(conn, cmdType, discardBuf) == nil
+}
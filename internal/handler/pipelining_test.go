@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"memory-tools/internal/protocol"
+	"net"
+	"testing"
+)
+
+// pipeline sends commands back to back in a single write, without waiting for any response.
+// The write runs in the background, as net.Pipe blocks it until the server has read it all.
+func pipeline(t *testing.T, conn net.Conn, writes ...func(io.Writer) error) {
+	t.Helper()
+	var cmds bytes.Buffer
+	for _, write := range writes {
+		if err := write(&cmds); err != nil {
+			t.Fatalf("building command: %v", err)
+		}
+	}
+	go conn.Write(cmds.Bytes())
+}
+
+func pingCommand(w io.Writer) error { return protocol.WritePingCommand(w) }
+
+func TestPipelinedCommandsAreAnsweredInOrder(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	conn := env.serve(env.handler())
+
+	getCommand := func(key string) func(io.Writer) error {
+		return func(w io.Writer) error { return protocol.WriteCollectionItemGetCommand(w, "items", key, nil) }
+	}
+	pipeline(t, conn,
+		func(w io.Writer) error {
+			return protocol.WriteCollectionItemSetCommand(w, "items", "a", []byte(`{"n":1}`), 0)
+		},
+		func(w io.Writer) error {
+			return protocol.WriteCollectionItemSetCommand(w, "items", "b", []byte(`{"n":2}`), 0)
+		},
+		getCommand("b"),
+		getCommand("missing"),
+		getCommand("a"),
+	)
+
+	expectStatus(t, receive(t, conn), protocol.StatusOk)
+	expectStatus(t, receive(t, conn), protocol.StatusOk)
+	for _, want := range []string{`"n":2`, "", `"n":1`} {
+		resp := receive(t, conn)
+		if want == "" {
+			expectStatus(t, resp, protocol.StatusNotFound)
+			continue
+		}
+		expectStatus(t, resp, protocol.StatusOk)
+		if !bytes.Contains(resp.data, []byte(want)) {
+			t.Fatalf("response data = %s, want the document with %s", resp.data, want)
+		}
+	}
+}
+
+func TestRejectedPipelinedCommandsLeaveTheStreamInSync(t *testing.T) {
+	// The payload is padded with PING command types, which would be answered if it were read
+	// as commands instead of being skipped.
+	setItem := func(w io.Writer) error {
+		return protocol.WriteCollectionItemSetCommand(w, "items", "k", []byte(`{"padding":"`+string(bytes.Repeat([]byte{byte(protocol.CmdPing)}, 64))+`"}`), 0)
+	}
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		env := newTestEnv(t)
+		h := env.handler()
+		h.IsAuthenticated, h.IsRoot = false, false
+		conn := env.serve(h)
+
+		pipeline(t, conn, setItem, pingCommand, setItem, pingCommand)
+		for range 2 {
+			expectStatus(t, receive(t, conn), protocol.StatusUnauthorized)
+			if resp := receive(t, conn); resp.status != protocol.StatusOk || resp.msg != "PONG" {
+				t.Fatalf("response = %d (%s), want the PONG of the next command", resp.status, resp.msg)
+			}
+		}
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		SetRateLimit(0.001, 1, false)
+		t.Cleanup(func() { SetRateLimit(0, 0, false) })
+		env := newTestEnv(t)
+		h := env.handler()
+		h.IsRoot, h.AuthenticatedUser = false, "alice"
+		conn := env.serve(h)
+
+		pipeline(t, conn, func(w io.Writer) error { return protocol.WriteBeginCommand(w) }, setItem, pingCommand, setItem, pingCommand)
+		expectStatus(t, receive(t, conn), protocol.StatusOk)
+		for range 2 {
+			expectStatus(t, receive(t, conn), protocol.StatusThrottled)
+			if resp := receive(t, conn); resp.status != protocol.StatusOk || resp.msg != "PONG" {
+				t.Fatalf("response = %d (%s), want the PONG of the next command", resp.status, resp.msg)
+			}
+		}
+	})
+}
+
+func TestUnknownPipelinedCommandClosesTheConnection(t *testing.T) {
+	env := newTestEnv(t)
+	conn := env.serve(env.handler())
+
+	// The bytes after an unknown command cannot be told apart from its payload, so they must
+	// not be run as the next command.
+	pipeline(t, conn, func(w io.Writer) error {
+		_, err := w.Write([]byte{255})
+		return err
+	}, pingCommand)
+
+	expectStatus(t, receive(t, conn), protocol.StatusBadCommand)
+	if _, err := conn.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("reading after the unknown command: err = %v, want the connection closed", err)
+	}
+}
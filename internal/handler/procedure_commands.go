@@ -0,0 +1,600 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"net"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// maxProcedureSteps caps the steps of a procedure, whose writes are all held until it commits.
+const maxProcedureSteps = 100
+
+// Operations a procedure step can perform.
+const (
+	procedureOpSet       = "set"
+	procedureOpUpdate    = "update"
+	procedureOpIncrement = "increment"
+	procedureOpDelete    = "delete"
+)
+
+var (
+	procedureParamNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// procedureParamRefPattern matches a ${name} reference to a parameter.
+	procedureParamRefPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// Procedure is a named, declarative sequence of writes. CALL_PROCEDURE runs its steps in order
+// and commits all their writes atomically, or none of them.
+type Procedure struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Params      []string        `json:"params,omitempty"`
+	Steps       []ProcedureStep `json:"steps"`
+}
+
+// ProcedureStep is one write of a procedure. Its strings may reference the procedure's parameters
+// as ${name}: a string made of a single reference takes the parameter's value, whatever its type,
+// and a reference inside a longer string is replaced by the value's text.
+type ProcedureStep struct {
+	Op         string         `json:"op"` // "set", "update", "increment" or "delete".
+	Collection string         `json:"collection"`
+	Key        string         `json:"key"`
+	Value      map[string]any `json:"value,omitempty"` // The document of a "set", or the merge patch of an "update".
+	Field      string         `json:"field,omitempty"` // The numeric field of an "increment".
+	Delta      any            `json:"delta,omitempty"` // The amount of an "increment": a number, or a parameter reference that "-" negates.
+	If         map[string]any `json:"if,omitempty"`    // A filter the item must match, or the call fails. Not allowed for "set".
+}
+
+// ProcedureWrite is a write committed by a procedure call, in the response of CALL_PROCEDURE.
+type ProcedureWrite struct {
+	Op         string `json:"op"` // "set", "update" or "delete".
+	Collection string `json:"collection"`
+	Key        string `json:"key"`
+	Document   any    `json:"document,omitempty"`
+}
+
+// validate checks a procedure's definition before it is stored, so a call only fails on its
+// parameters or on the data it finds.
+func (p *Procedure) validate() error {
+	if len(p.Steps) == 0 {
+		return errors.New("a procedure needs at least one step")
+	}
+	if len(p.Steps) > maxProcedureSteps {
+		return fmt.Errorf("a procedure can have at most %d steps", maxProcedureSteps)
+	}
+	declared := make(map[string]bool, len(p.Params))
+	for _, name := range p.Params {
+		if !procedureParamNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid parameter name '%s': use letters, digits and underscores", name)
+		}
+		if declared[name] {
+			return fmt.Errorf("parameter '%s' is declared twice", name)
+		}
+		declared[name] = true
+	}
+	for i, step := range p.Steps {
+		if err := step.validate(declared); err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (s *ProcedureStep) validate(declared map[string]bool) error {
+	if s.Collection == "" || s.Key == "" {
+		return errors.New("collection and key are required")
+	}
+	if s.Collection == globalconst.SystemCollectionName {
+		return errors.New("procedures cannot write to the system collection")
+	}
+	switch s.Op {
+	case procedureOpSet:
+		if s.Value == nil {
+			return errors.New("'set' requires a value")
+		}
+		if s.If != nil {
+			return errors.New("'set' cannot have a condition: it writes whether or not the item exists")
+		}
+	case procedureOpUpdate:
+		if s.Value == nil {
+			return errors.New("'update' requires a value")
+		}
+	case procedureOpIncrement:
+		if s.Field == "" || s.Delta == nil {
+			return errors.New("'increment' requires a field and a delta")
+		}
+		if s.Field == globalconst.UPDATED_AT || store.IsManagedField(s.Field) {
+			return fmt.Errorf("field '%s' is managed by the server and cannot be incremented", s.Field)
+		}
+		switch delta := s.Delta.(type) {
+		case float64:
+		case string:
+			if !isWholeParamRef(strings.TrimPrefix(delta, "-")) {
+				return errors.New("delta must be a number or a single parameter reference, optionally negated")
+			}
+		default:
+			return errors.New("delta must be a number or a single parameter reference, optionally negated")
+		}
+	case procedureOpDelete:
+	default:
+		return fmt.Errorf("unknown op '%s': use '%s', '%s', '%s' or '%s'", s.Op, procedureOpSet, procedureOpUpdate, procedureOpIncrement, procedureOpDelete)
+	}
+
+	stepJSON, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	for _, match := range procedureParamRefPattern.FindAllStringSubmatch(string(stepJSON), -1) {
+		if !declared[match[1]] {
+			return fmt.Errorf("parameter '%s' is not declared", match[1])
+		}
+	}
+	return nil
+}
+
+// bind returns the step with its parameter references replaced by the values of params.
+func (s ProcedureStep) bind(params map[string]any) (ProcedureStep, error) {
+	bound := ProcedureStep{
+		Op:         s.Op,
+		Collection: bindParamsInText(s.Collection, params),
+		Key:        bindParamsInText(s.Key, params),
+		Field:      bindParamsInText(s.Field, params),
+	}
+	if s.Value != nil {
+		bound.Value = bindParams(s.Value, params).(map[string]any)
+	}
+	if s.If != nil {
+		bound.If = bindParams(s.If, params).(map[string]any)
+	}
+	if s.Delta != nil {
+		// A reference to a parameter can be negated, as "-${amount}", to subtract it.
+		sign, delta := 1.0, s.Delta
+		if ref, ok := delta.(string); ok && strings.HasPrefix(ref, "-") {
+			sign, delta = -1, ref[1:]
+		}
+		value, ok := bindParams(delta, params).(float64)
+		if !ok || math.IsNaN(value) || math.IsInf(value, 0) {
+			return bound, errors.New("delta must be a finite number")
+		}
+		bound.Delta = sign * value
+	}
+	if bound.Collection == "" || bound.Key == "" {
+		return bound, errors.New("collection and key cannot be empty")
+	}
+	if bound.Collection == globalconst.SystemCollectionName {
+		return bound, errors.New("procedures cannot write to the system collection")
+	}
+	return bound, nil
+}
+
+func isWholeParamRef(s string) bool {
+	loc := procedureParamRefPattern.FindStringIndex(s)
+	return loc != nil && loc[0] == 0 && loc[1] == len(s)
+}
+
+// bindParams replaces the parameter references in the strings of a JSON value.
+func bindParams(value any, params map[string]any) any {
+	switch v := value.(type) {
+	case string:
+		if isWholeParamRef(v) {
+			return params[v[2:len(v)-1]]
+		}
+		return bindParamsInText(v, params)
+	case map[string]any:
+		bound := make(map[string]any, len(v))
+		for k, item := range v {
+			bound[k] = bindParams(item, params)
+		}
+		return bound
+	case []any:
+		bound := make([]any, len(v))
+		for i, item := range v {
+			bound[i] = bindParams(item, params)
+		}
+		return bound
+	default:
+		return value
+	}
+}
+
+// bindParamsInText replaces each parameter reference in s by the text of the parameter's value.
+func bindParamsInText(s string, params map[string]any) string {
+	return procedureParamRefPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		switch v := params[ref[2:len(ref)-1]].(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			text, _ := json.Marshal(v)
+			return string(text)
+		}
+	})
+}
+
+// HandleProcedurePut processes the CmdProcedurePut command, which creates or replaces a stored
+// procedure. It is a write operation.
+func (h *ConnectionHandler) HandleProcedurePut(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	// Authorization is skipped during WAL recovery (conn is nil)
+	if conn != nil && !h.hasPermission(globalconst.SystemCollectionName, globalconst.PermissionWrite) {
+		slog.Warn("Unauthorized procedure change attempt", "user", h.AuthenticatedUser, "remote_addr", remoteAddr)
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: You do not have permission to manage procedures.", nil)
+		return
+	}
+
+	name, definitionJSON, err := protocol.ReadProcedurePutCommand(r)
+	if err != nil {
+		slog.Error("Failed to read PROCEDURE_PUT command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid PROCEDURE_PUT command format", nil)
+		}
+		return
+	}
+	if name == "" {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Procedure name cannot be empty", nil)
+		}
+		return
+	}
+	var procedure Procedure
+	if err := json.Unmarshal(definitionJSON, &procedure); err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Invalid procedure definition JSON: %v", err), nil)
+		}
+		return
+	}
+	procedure.Name = name
+	if err := procedure.validate(); err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Invalid procedure '%s': %v", name, err), nil)
+		}
+		return
+	}
+
+	sysCol := h.CollectionManager.GetCollection(globalconst.SystemCollectionName)
+	procedureBytes, _ := json.Marshal(procedure)
+	sysCol.Set(globalconst.ProcedurePrefix+name, procedureBytes, 0)
+	h.CollectionManager.EnqueueSaveTask(globalconst.SystemCollectionName, sysCol)
+
+	slog.Info("Procedure stored", "user", h.AuthenticatedUser, "procedure", name, "steps", len(procedure.Steps))
+	if conn != nil {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Procedure '%s' stored with %d steps.", name, len(procedure.Steps)), nil)
+	}
+}
+
+// HandleProcedureDelete processes the CmdProcedureDelete command. It is a write operation.
+func (h *ConnectionHandler) HandleProcedureDelete(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	// Authorization is skipped during WAL recovery (conn is nil)
+	if conn != nil && !h.hasPermission(globalconst.SystemCollectionName, globalconst.PermissionWrite) {
+		slog.Warn("Unauthorized procedure delete attempt", "user", h.AuthenticatedUser, "remote_addr", remoteAddr)
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: You do not have permission to manage procedures.", nil)
+		return
+	}
+
+	name, err := protocol.ReadProcedureDeleteCommand(r)
+	if err != nil {
+		slog.Error("Failed to read PROCEDURE_DELETE command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid PROCEDURE_DELETE command format", nil)
+		}
+		return
+	}
+
+	sysCol := h.CollectionManager.GetCollection(globalconst.SystemCollectionName)
+	procedureKey := globalconst.ProcedurePrefix + name
+	if _, found := sysCol.Get(procedureKey); !found {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("Procedure '%s' not found", name), nil)
+		}
+		return
+	}
+	sysCol.Delete(procedureKey)
+	h.CollectionManager.EnqueueSaveTask(globalconst.SystemCollectionName, sysCol)
+
+	slog.Info("Procedure deleted", "user", h.AuthenticatedUser, "procedure", name)
+	if conn != nil {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Procedure '%s' deleted.", name), nil)
+	}
+}
+
+// handleProcedureList returns the definitions of the stored procedures, sorted by name. Any
+// authenticated user can list them: calling one still needs write permission on its collections.
+func (h *ConnectionHandler) handleProcedureList(r io.Reader, conn net.Conn) {
+	procedures := make([]Procedure, 0)
+	for key, value := range h.CollectionManager.GetCollection(globalconst.SystemCollectionName).GetAll() {
+		if !strings.HasPrefix(key, globalconst.ProcedurePrefix) {
+			continue
+		}
+		var procedure Procedure
+		if err := json.Unmarshal(value, &procedure); err != nil {
+			slog.Warn("Skipping unreadable procedure", "key", key, "error", err)
+			continue
+		}
+		procedures = append(procedures, procedure)
+	}
+	sort.Slice(procedures, func(i, j int) bool { return procedures[i].Name < procedures[j].Name })
+
+	responseData, err := json.Marshal(procedures)
+	if err != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to serialize procedures", nil)
+		return
+	}
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: %d procedures.", len(procedures)), responseData)
+}
+
+// procedureItem is the state a procedure call leaves an item in, built up step by step before
+// anything is written.
+type procedureItem struct {
+	collection string
+	key        string
+	existed    bool           // The item was in memory when the call read it.
+	version    uint64         // The version read, which must still be current at commit.
+	doc        map[string]any // Nil if the item does not exist, or was deleted by a step.
+}
+
+// HandleCallProcedure processes the CmdCallProcedure command. It is a write operation: calls that
+// commit are logged and replayed by running the procedure again. The steps run in order, each
+// seeing the items as the previous steps left them, and their writes are committed in one
+// transaction: if a step fails, or an item a step read is changed by another client before the
+// commit, nothing is written. Like writes inside transactions, procedures only see items held in
+// memory; a set of an item held only on disk fails rather than overwrite it.
+func (h *ConnectionHandler) HandleCallProcedure(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+	fail := func(status protocol.ResponseStatus, msg string) {
+		if conn != nil {
+			protocol.WriteResponse(conn, status, msg, nil)
+		}
+	}
+
+	name, paramsJSON, err := protocol.ReadCallProcedureCommand(r)
+	if err != nil {
+		slog.Error("Failed to read CALL_PROCEDURE command payload", "error", err, "remote_addr", remoteAddr)
+		fail(protocol.StatusBadCommand, "Invalid CALL_PROCEDURE command format")
+		return
+	}
+	if h.CurrentTransactionID != "" {
+		fail(protocol.StatusBadRequest, "Cannot call a procedure inside a transaction. Commit or roll it back first.")
+		return
+	}
+
+	procedureData, found := h.CollectionManager.GetCollection(globalconst.SystemCollectionName).Get(globalconst.ProcedurePrefix + name)
+	if !found {
+		fail(protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Procedure '%s' does not exist.", name))
+		return
+	}
+	var procedure Procedure
+	if err := json.Unmarshal(procedureData, &procedure); err != nil {
+		slog.Error("Failed to read stored procedure", "procedure", name, "error", err)
+		fail(protocol.StatusError, fmt.Sprintf("ERROR: Procedure '%s' is unreadable.", name))
+		return
+	}
+
+	params := make(map[string]any)
+	if len(paramsJSON) > 0 {
+		if err := json.Unmarshal(paramsJSON, &params); err != nil {
+			fail(protocol.StatusBadRequest, "Invalid parameters JSON: expected an object")
+			return
+		}
+	}
+	for _, param := range procedure.Params {
+		if _, ok := params[param]; !ok {
+			fail(protocol.StatusBadRequest, fmt.Sprintf("Missing parameter '%s' of procedure '%s'", param, name))
+			return
+		}
+	}
+	for param := range params {
+		if !slices.Contains(procedure.Params, param) {
+			fail(protocol.StatusBadRequest, fmt.Sprintf("Unknown parameter '%s' of procedure '%s'", param, name))
+			return
+		}
+	}
+
+	items := make(map[string]*procedureItem)
+	var order []*procedureItem
+	for i, step := range procedure.Steps {
+		stepName := fmt.Sprintf("step %d (%s)", i+1, step.Op)
+		step, err := step.bind(params)
+		if err != nil {
+			fail(protocol.StatusBadRequest, fmt.Sprintf("Procedure '%s' failed at %s: %v. Nothing was written.", name, stepName, err))
+			return
+		}
+		if conn != nil && !h.hasPermission(step.Collection, globalconst.PermissionWrite) {
+			slog.Warn("Unauthorized procedure call", "user", h.AuthenticatedUser, "procedure", name, "collection", step.Collection)
+			fail(protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have write permission for collection '%s'", step.Collection))
+			return
+		}
+		if !h.CollectionManager.CollectionExists(step.Collection) {
+			fail(protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' of %s does not exist.", step.Collection, stepName))
+			return
+		}
+
+		itemID := step.Collection + "\x00" + step.Key
+		item, seen := items[itemID]
+		if !seen {
+			item = &procedureItem{collection: step.Collection, key: step.Key}
+			if value, found := h.CollectionManager.GetCollection(step.Collection).Get(step.Key); found {
+				if err := json.Unmarshal(value, &item.doc); err != nil {
+					fail(protocol.StatusError, fmt.Sprintf("ERROR: Could not read item '%s' of collection '%s'", step.Key, step.Collection))
+					return
+				}
+				item.existed = true
+				item.version = store.VersionOf(item.doc)
+			} else if conn != nil && step.Op == procedureOpSet {
+				// An item held only on disk would be overwritten at commit, as it is not in memory.
+				foundInCold, err := persistence.CheckColdKeyExists(step.Collection, step.Key)
+				if err != nil {
+					slog.Error("Failed to check key existence in cold storage for procedure", "procedure", name, "collection", step.Collection, "key", step.Key, "error", err)
+					fail(protocol.StatusError, "Internal server error during key validation.")
+					return
+				}
+				if foundInCold {
+					fail(protocol.StatusConflict, fmt.Sprintf("CONFLICT: Procedure '%s' failed at %s: item '%s' of collection '%s' is only held on disk, and procedures only write items held in memory. Nothing was written.", name, stepName, step.Key, step.Collection))
+					return
+				}
+			}
+			items[itemID] = item
+			order = append(order, item)
+		}
+
+		if step.Op != procedureOpSet {
+			if item.doc == nil {
+				fail(protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Procedure '%s' failed at %s: item '%s' not found in memory in collection '%s'. Nothing was written.", name, stepName, step.Key, step.Collection))
+				return
+			}
+			if step.If != nil {
+				if err := compileRegexFilters(step.If); err != nil {
+					fail(protocol.StatusBadRequest, fmt.Sprintf("Procedure '%s' failed at %s: invalid condition: %v. Nothing was written.", name, stepName, err))
+					return
+				}
+				if !h.matchFilter(item.doc, step.If) {
+					fail(protocol.StatusConflict, fmt.Sprintf("CONFLICT: Procedure '%s' failed at %s: item '%s' of collection '%s' does not match the condition. Nothing was written.", name, stepName, step.Key, step.Collection))
+					return
+				}
+			}
+		}
+
+		switch step.Op {
+		case procedureOpSet:
+			if !h.validateDocuments(conn, step.Collection, false, step.Value) {
+				return
+			}
+			doc := step.Value
+			for field, value := range item.doc {
+				if store.IsManagedField(field) {
+					doc[field] = value
+				}
+			}
+			doc[globalconst.ID] = step.Key
+			item.doc = doc
+		case procedureOpUpdate:
+			if !h.validateDocuments(conn, step.Collection, true, step.Value) {
+				return
+			}
+			store.MergePatch(item.doc, step.Value)
+		case procedureOpIncrement:
+			newValue, err := store.IncrementField(item.doc, step.Field, step.Delta.(float64))
+			if err != nil {
+				fail(protocol.StatusBadRequest, fmt.Sprintf("Procedure '%s' failed at %s: %v. Nothing was written.", name, stepName, err))
+				return
+			}
+			if !h.validateDocuments(conn, step.Collection, true, map[string]any{step.Field: newValue}) {
+				return
+			}
+		case procedureOpDelete:
+			item.doc = nil
+		}
+	}
+
+	// Each item gets one write with the state the steps left it in, on the condition that nobody
+	// changed it since it was read.
+	ops := make([]store.WriteOperation, 0, len(order))
+	for _, item := range order {
+		op := store.WriteOperation{Collection: item.collection, Key: item.key}
+		switch {
+		case item.existed && item.doc == nil:
+			op.OpType = store.OpTypeDelete
+		case item.existed:
+			op.OpType = store.OpTypeUpdate
+		case item.doc != nil:
+			op.OpType = store.OpTypeSet
+		default:
+			continue // Created and deleted by the call.
+		}
+		if item.doc != nil {
+			op.Value, err = json.Marshal(item.doc)
+			if err != nil {
+				fail(protocol.StatusError, fmt.Sprintf("ERROR: Could not marshal item '%s' of collection '%s'", item.key, item.collection))
+				return
+			}
+		}
+		existed, version := item.existed, item.version
+		op.Precondition = func(current []byte) bool {
+			if !existed {
+				return current == nil
+			}
+			var currentData map[string]any
+			if current == nil || json.Unmarshal(current, &currentData) != nil {
+				return false
+			}
+			return store.VersionOf(currentData) == version
+		}
+		ops = append(ops, op)
+	}
+
+	if len(ops) > 0 {
+		txID, err := h.TransactionManager.Begin()
+		if err != nil {
+			fail(protocol.StatusError, fmt.Sprintf("ERROR: Could not start the procedure's transaction: %v", err))
+			return
+		}
+		for _, op := range ops {
+			if err := h.TransactionManager.RecordWrite(txID, op); err != nil {
+				h.TransactionManager.Rollback(txID)
+				fail(protocol.StatusError, fmt.Sprintf("ERROR: Failed to record write of procedure '%s': %v", name, err))
+				return
+			}
+		}
+		if err := h.TransactionManager.Commit(txID); err != nil {
+			slog.Warn("Procedure failed to commit and was rolled back", "procedure", name, "user", h.AuthenticatedUser, "error", err)
+			var violation *store.UniqueViolationError
+			if errors.As(err, &violation) {
+				fail(protocol.StatusConflict, fmt.Sprintf("CONFLICT: Procedure '%s' failed and was rolled back: %v", name, err))
+				return
+			}
+			fail(protocol.StatusError, fmt.Sprintf("ERROR: Procedure '%s' failed and was rolled back: %v", name, err))
+			return
+		}
+		if conn != nil {
+			for _, op := range ops {
+				recordCollectionWrite(op.Collection)
+			}
+		}
+		h.publishCommittedChanges(ops)
+	}
+
+	slog.Debug("Procedure called", "procedure", name, "user", h.AuthenticatedUser, "writes", len(ops))
+	if conn == nil {
+		return
+	}
+	writes := make([]ProcedureWrite, 0, len(ops))
+	for _, op := range ops {
+		write := ProcedureWrite{Op: op.OpType.String(), Collection: op.Collection, Key: op.Key}
+		if op.OpType != store.OpTypeDelete {
+			if value, found := h.CollectionManager.GetCollection(op.Collection).Get(op.Key); found {
+				write.Document = jsonValue(value)
+			}
+		}
+		writes = append(writes, write)
+	}
+	responseData, err := json.Marshal(writes)
+	if err != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to serialize procedure result", nil)
+		return
+	}
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Procedure '%s' committed %d writes.", name, len(ops)), responseData)
+}
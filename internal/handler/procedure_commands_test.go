@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/wal"
+	"sync"
+	"testing"
+)
+
+// transferProcedure moves an amount between two accounts and records it in a ledger.
+const transferProcedure = `{"params":["from","to","amount"],"steps":[
+	{"op":"increment","collection":"accounts","key":"${from}","field":"balance","delta":"-${amount}","if":{"field":"balance","op":">=","value":"${amount}"}},
+	{"op":"increment","collection":"accounts","key":"${to}","field":"balance","delta":"${amount}"},
+	{"op":"set","collection":"ledger","key":"${from}-${to}","value":{"amount":"${amount}"}}
+]}`
+
+func (e *testEnv) putProcedure(name, definition string) testResponse {
+	e.t.Helper()
+	return e.run(e.handler().HandleProcedurePut, func(w io.Writer) error {
+		return protocol.WriteProcedurePutCommand(w, name, []byte(definition))
+	})
+}
+
+func callProcedureCommand(name, params string) func(io.Writer) error {
+	return func(w io.Writer) error { return protocol.WriteCallProcedureCommand(w, name, []byte(params)) }
+}
+
+// balance returns the balance of an account.
+func (e *testEnv) balance(key string) float64 {
+	e.t.Helper()
+	balance, _ := e.storedDoc("accounts", key)["balance"].(float64)
+	return balance
+}
+
+// accountsEnv returns a test environment with the transfer procedure and two accounts.
+func accountsEnv(t *testing.T, balanceA, balanceB int) *testEnv {
+	env := newTestEnv(t)
+	env.createTestCollection("accounts")
+	env.createTestCollection("ledger")
+	env.setItem("accounts", "a", fmt.Sprintf(`{"balance":%d}`, balanceA))
+	env.setItem("accounts", "b", fmt.Sprintf(`{"balance":%d}`, balanceB))
+	expectStatus(t, env.putProcedure("transfer", transferProcedure), protocol.StatusOk)
+	return env
+}
+
+func TestProcedurePutRejectsInvalidDefinitions(t *testing.T) {
+	env := newTestEnv(t)
+	for name, definition := range map[string]string{
+		"no steps":         `{"steps":[]}`,
+		"undeclared param": `{"steps":[{"op":"delete","collection":"c","key":"${id}"}]}`,
+		"conditional set":  `{"steps":[{"op":"set","collection":"c","key":"k","value":{},"if":{"field":"a","op":"=","value":1}}]}`,
+		"unknown op":       `{"steps":[{"op":"eval","collection":"c","key":"k"}]}`,
+		"system writes":    `{"steps":[{"op":"delete","collection":"_system","key":"k"}]}`,
+		"managed field":    `{"steps":[{"op":"increment","collection":"c","key":"k","field":"_id","delta":1}]}`,
+	} {
+		if resp := env.putProcedure("p", definition); resp.status != protocol.StatusBadRequest {
+			t.Errorf("%s: status %d (%s), want a bad request", name, resp.status, resp.msg)
+		}
+	}
+}
+
+func TestCallProcedureCommitsAllSteps(t *testing.T) {
+	env := accountsEnv(t, 100, 5)
+	resp := env.run(env.handler().HandleCallProcedure, callProcedureCommand("transfer", `{"from":"a","to":"b","amount":30}`))
+	expectStatus(t, resp, protocol.StatusOk)
+	var writes []ProcedureWrite
+	if err := json.Unmarshal(resp.data, &writes); err != nil {
+		t.Fatal(err)
+	}
+	if len(writes) != 3 || writes[2].Op != "set" || writes[2].Key != "a-b" {
+		t.Fatalf("writes = %+v, want both accounts updated and the ledger entry set", writes)
+	}
+	if env.balance("a") != 70 || env.balance("b") != 35 {
+		t.Errorf("balances = %v and %v, want 70 and 35", env.balance("a"), env.balance("b"))
+	}
+	if entry := env.storedDoc("ledger", "a-b"); entry["amount"] != float64(30) {
+		t.Errorf("ledger entry = %v", entry)
+	}
+}
+
+func TestCallProcedureWritesNothingWhenAStepFails(t *testing.T) {
+	env := accountsEnv(t, 10, 5)
+	h := env.handler()
+	calls := []struct {
+		params string
+		want   protocol.ResponseStatus
+	}{
+		{`{"from":"a","to":"b","amount":50}`, protocol.StatusConflict},     // The condition of the first step fails.
+		{`{"from":"a","to":"nobody","amount":5}`, protocol.StatusNotFound}, // The second step finds no item.
+		{`{"from":"a","to":"b"}`, protocol.StatusBadRequest},               // A parameter is missing.
+		{`{"from":"a","to":"b","amount":"x"}`, protocol.StatusBadRequest},  // The delta is not a number.
+	}
+	for _, call := range calls {
+		expectStatus(t, env.run(h.HandleCallProcedure, callProcedureCommand("transfer", call.params)), call.want)
+	}
+	if env.balance("a") != 10 || env.balance("b") != 5 || env.cm.GetCollection("ledger").Size() != 0 {
+		t.Fatalf("a failed call wrote: balances %v and %v, %d ledger entries", env.balance("a"), env.balance("b"), env.cm.GetCollection("ledger").Size())
+	}
+
+	// Write permission is needed on every collection the steps write to.
+	h.IsRoot = false
+	h.Permissions["accounts"] = "write"
+	expectStatus(t, env.run(h.HandleCallProcedure, callProcedureCommand("transfer", `{"from":"a","to":"b","amount":1}`)), protocol.StatusUnauthorized)
+	if env.balance("a") != 10 {
+		t.Errorf("an unauthorized call wrote: balance %v", env.balance("a"))
+	}
+}
+
+func TestCallProcedureInsideTransactionIsRejected(t *testing.T) {
+	env := accountsEnv(t, 10, 5)
+	h := env.handler()
+	expectStatus(t, env.run(h.handleBegin, protocol.WriteBeginCommand), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCallProcedure, callProcedureCommand("transfer", `{"from":"a","to":"b","amount":1}`)), protocol.StatusBadRequest)
+	expectStatus(t, env.run(h.handleRollback, protocol.WriteRollbackCommand), protocol.StatusOk)
+	if env.balance("a") != 10 {
+		t.Errorf("balance = %v, want the call inside the transaction to have no effect", env.balance("a"))
+	}
+}
+
+func TestConcurrentProcedureCallsKeepBalancesConsistent(t *testing.T) {
+	const workers, callsPerWorker = 8, 100
+	env := accountsEnv(t, 1_000_000, 0)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	committed, moved := 0, 0
+	start := make(chan struct{})
+	for w := range workers {
+		h := env.handler()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for i := range callsPerWorker {
+				amount := w*callsPerWorker + i + 1
+				var cmd bytes.Buffer
+				protocol.WriteCallProcedureCommand(&cmd, "transfer", fmt.Appendf(nil, `{"from":"a","to":"b","amount":%d}`, amount))
+				cmd.Next(1)
+				conn := &testConn{}
+				h.HandleCallProcedure(&cmd, conn)
+				// A call whose accounts another call changed first is rolled back as a whole.
+				if conn.out.Bytes()[0] == byte(protocol.StatusOk) {
+					mu.Lock()
+					committed++
+					moved += amount
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if committed == 0 {
+		t.Fatal("no call committed")
+	}
+	// Each call moves a different amount, so the balances show exactly which calls committed.
+	if a, b := env.balance("a"), env.balance("b"); a != float64(1_000_000-moved) || b != float64(moved) {
+		t.Fatalf("balances = %v and %v after %d committed calls moving %d, want %d and %d", a, b, committed, moved, 1_000_000-moved, moved)
+	}
+}
+
+func TestCallProcedureIsReplayedFromTheWal(t *testing.T) {
+	env := accountsEnv(t, 100, 0)
+	var cmd bytes.Buffer
+	if err := callProcedureCommand("transfer", `{"from":"a","to":"b","amount":40}`)(&cmd); err != nil {
+		t.Fatal(err)
+	}
+	env.handler().ApplyWalEntry(wal.WalEntry{CommandType: protocol.CmdCallProcedure, Payload: cmd.Bytes()[1:]})
+	if env.balance("a") != 60 || env.balance("b") != 40 {
+		t.Errorf("balances after replay = %v and %v, want 60 and 40", env.balance("a"), env.balance("b"))
+	}
+}
+
+func TestOnlyCommittedProcedureCallsAreLogged(t *testing.T) {
+	env := newTestEnv(t)
+	conn, walPath := env.serveLogged()
+	expectStatus(t, send(t, conn, func(w io.Writer) error { return protocol.WriteCollectionCreateCommand(w, "accounts") }), protocol.StatusOk)
+	expectStatus(t, send(t, conn, func(w io.Writer) error { return protocol.WriteCollectionCreateCommand(w, "ledger") }), protocol.StatusOk)
+	for key, doc := range map[string]string{"a": `{"balance":100}`, "b": `{"balance":0}`} {
+		expectStatus(t, send(t, conn, func(w io.Writer) error {
+			return protocol.WriteCollectionItemSetCommand(w, "accounts", key, []byte(doc), 0)
+		}), protocol.StatusOk)
+	}
+	expectStatus(t, send(t, conn, func(w io.Writer) error {
+		return protocol.WriteProcedurePutCommand(w, "transfer", []byte(transferProcedure))
+	}), protocol.StatusOk)
+
+	expectStatus(t, send(t, conn, callProcedureCommand("transfer", `{"from":"a","to":"b","amount":150}`)), protocol.StatusConflict)
+	expectStatus(t, send(t, conn, callProcedureCommand("transfer", `{"from":"a","to":"b","amount":40}`)), protocol.StatusOk)
+
+	entries, err := wal.Replay(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls []string
+	for entry := range entries {
+		if entry.CommandType != protocol.CmdCallProcedure {
+			continue
+		}
+		_, params, err := protocol.ReadCallProcedureCommand(bytes.NewReader(entry.Payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+		calls = append(calls, string(params))
+	}
+	if len(calls) != 1 || calls[0] != `{"from":"a","to":"b","amount":40}` {
+		t.Errorf("logged calls = %q, want only the committed one", calls)
+	}
+}
+
+func TestCallProcedureRejectsSetOfItemHeldOnDisk(t *testing.T) {
+	env := accountsEnv(t, 100, 0)
+	persister := &persistence.CollectionPersisterImpl{}
+	if err := persister.WriteColdItems("ledger", map[string][]byte{"a-b": []byte(`{"_id":"a-b","amount":7}`)}); err != nil {
+		t.Fatalf("writing cold item: %v", err)
+	}
+
+	resp := env.run(env.handler().HandleCallProcedure, callProcedureCommand("transfer", `{"from":"a","to":"b","amount":30}`))
+	expectStatus(t, resp, protocol.StatusConflict)
+
+	if env.balance("a") != 100 || env.balance("b") != 0 {
+		t.Errorf("balances = %v and %v, want them unchanged", env.balance("a"), env.balance("b"))
+	}
+	if _, found := env.cm.GetCollection("ledger").Get("a-b"); found {
+		t.Error("the call set the ledger entry held on disk")
+	}
+}
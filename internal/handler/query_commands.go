@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"log/slog"
@@ -14,6 +15,8 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	stdjson "encoding/json"
 
@@ -22,6 +25,14 @@ import (
 
 // ./internal/handler/query_commands.go
 
+// maxQueryResponseBytes caps the serialized size of the documents a query returns. Zero disables the cap.
+var maxQueryResponseBytes atomic.Int64
+
+// SetMaxQueryResponseBytes sets the byte cap for query results. Zero or negative disables it.
+func SetMaxQueryResponseBytes(maxBytes int) {
+	maxQueryResponseBytes.Store(int64(max(maxBytes, 0)))
+}
+
 func (h *ConnectionHandler) handleCollectionQuery(r io.Reader, conn net.Conn) {
 	collectionName, queryJSONBytes, err := protocol.ReadCollectionQueryCommand(r)
 	if err != nil {
@@ -48,6 +59,7 @@ func (h *ConnectionHandler) handleCollectionQuery(r io.Reader, conn net.Conn) {
 		protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist for query", collectionName), nil)
 		return
 	}
+	recordCollectionRead(collectionName)
 
 	query := queryPool.Get().(*Query)
 	defer func() {
@@ -64,10 +76,102 @@ func (h *ConnectionHandler) handleCollectionQuery(r io.Reader, conn net.Conn) {
 		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Invalid query JSON format", nil)
 		return
 	}
+	if query.Format != "" && query.Format != globalconst.FormatJSON && query.Format != globalconst.FormatCSV {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Unsupported query format '%s'. Use 'json' or 'csv'.", query.Format), nil)
+		return
+	}
+	if query.WithStats && query.Format == globalconst.FormatCSV {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Query stats are only available with the 'json' format.", nil)
+		return
+	}
+	if query.Explain && (query.Stream || query.Format == globalconst.FormatCSV) {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "'explain' cannot be combined with 'stream' or the 'csv' format.", nil)
+		return
+	}
+	if query.Estimate && !query.Count {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "'estimate' can only be used together with 'count'.", nil)
+		return
+	}
+	if len(query.Projection) > 0 && len(query.Exclude) > 0 {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "'projection' and 'exclude' cannot be used together.", nil)
+		return
+	}
+	if query.DistinctCounts && query.Distinct == "" {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "'distinct_counts' can only be used together with 'distinct'.", nil)
+		return
+	}
+	if query.SampleRate < 0 || query.SampleRate > 1 {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "'sample_rate' must be greater than 0 and at most 1.", nil)
+		return
+	}
+	if query.Stream {
+		if err := validateStreamQuery(query); err != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Invalid streaming query: %v", err), nil)
+			return
+		}
+	}
+	if isCursorQuery(query) {
+		if err := validateCursorQuery(query); err != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Invalid cursor query: %v", err), nil)
+			return
+		}
+	}
+	if err := resolveRelativeDates(query.Filter, time.Now()); err != nil {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Invalid query filter: %v", err), nil)
+		return
+	}
+	if err := compileRegexFilters(query.Filter); err != nil {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Invalid query filter: %v", err), nil)
+		return
+	}
+	if err := compileRegexFilters(query.Having); err != nil {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Invalid query having: %v", err), nil)
+		return
+	}
+	if err := validateLookups(query.Lookups, time.Now()); err != nil {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Invalid query lookups: %v", err), nil)
+		return
+	}
+
+	// A plan only reads the indexes, so it neither waits for a query slot nor counts as a slow query.
+	if query.Explain {
+		plan := h.explainQuery(h.CollectionManager.GetCollection(collectionName), query)
+		slog.Debug("Explaining collection query", "user", h.AuthenticatedUser, "collection", collectionName, "path", plan.Path)
+		planBytes, err := jsoniter.Marshal(plan)
+		if err != nil {
+			protocol.WriteResponse(conn, protocol.StatusError, "Failed to serialize query plan", nil)
+			return
+		}
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Query plan for collection '%s'", collectionName), planBytes)
+		return
+	}
+
+	if !isIndexedPointLookup(h.CollectionManager.GetCollection(collectionName), query) {
+		release, ok := acquireQuerySlot()
+		if !ok {
+			slog.Warn("Query rejected: too many concurrent queries", "user", h.AuthenticatedUser, "collection", collectionName)
+			protocol.WriteResponse(conn, protocol.StatusError, "BUSY: Too many concurrent queries. Please retry later.", nil)
+			return
+		}
+		defer release()
+	}
 
 	slog.Debug("Processing collection query", "user", h.AuthenticatedUser, "collection", collectionName, "query", string(queryJSONBytes))
 
-	results, err := h.processCollectionQuery(collectionName, query)
+	if query.Stream {
+		h.streamCollectionQuery(conn, collectionName, query)
+		return
+	}
+
+	// Stats are also collected for the slow query log, which reports them for slow queries.
+	var stats *QueryStats
+	if query.WithStats || slowQueryLogEnabled() {
+		stats = newQueryStats()
+	}
+	queryStart := time.Now()
+	results, truncated, err := h.processCollectionQuery(collectionName, query, stats, int(maxQueryResponseBytes.Load()))
+	stats.recordPhase("total", queryStart)
+	h.recordSlowQuery(collectionName, queryJSONBytes, stats, time.Since(queryStart))
 	if err != nil {
 		slog.Error("Error processing collection query",
 			"user", h.AuthenticatedUser,
@@ -78,7 +182,38 @@ func (h *ConnectionHandler) handleCollectionQuery(r io.Reader, conn net.Conn) {
 		return
 	}
 
-	responseBytes, err := jsoniter.Marshal(results)
+	msg := fmt.Sprintf("OK: Query executed on collection '%s'", collectionName)
+	if truncated {
+		slog.Warn("Query results truncated by the response byte cap", "user", h.AuthenticatedUser, "collection", collectionName, "max_bytes", maxQueryResponseBytes.Load())
+		msg += fmt.Sprintf(" (TRUNCATED: results exceed %d bytes)", maxQueryResponseBytes.Load())
+	}
+
+	if query.Format == globalconst.FormatCSV {
+		csvResults, err := newCSVResults(results, query.Projection)
+		if err != nil {
+			slog.Error("Error encoding query results as CSV",
+				"user", h.AuthenticatedUser,
+				"collection", collectionName,
+				"error", err,
+			)
+			protocol.WriteResponse(conn, protocol.StatusError, "Failed to encode query results as CSV", nil)
+			return
+		}
+		if err := csvResults.writeResponse(conn, protocol.StatusOk, msg+" (csv)"); err != nil {
+			slog.Error("Failed to write COLLECTION_QUERY response", "error", err, "remote_addr", conn.RemoteAddr().String())
+		}
+		return
+	}
+
+	var responseBytes []byte
+	if query.WithStats {
+		results = queryResponseWithStats{Results: results, Stats: stats}
+	}
+	if query.SortedKeys {
+		responseBytes, err = marshalSortedKeys(results)
+	} else {
+		responseBytes, err = jsoniter.Marshal(results)
+	}
 	if err != nil {
 		slog.Error("Error marshalling query results",
 			"user", h.AuthenticatedUser,
@@ -89,20 +224,58 @@ func (h *ConnectionHandler) handleCollectionQuery(r io.Reader, conn net.Conn) {
 		return
 	}
 
-	if err := protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Query executed on collection '%s'", collectionName), responseBytes); err != nil {
+	if err := protocol.WriteResponse(conn, protocol.StatusOk, msg, responseBytes); err != nil {
 		slog.Error("Failed to write COLLECTION_QUERY response", "error", err, "remote_addr", conn.RemoteAddr().String())
 	}
 }
 
+// sortedKeysJSON matches jsoniter's default encoding but always writes object keys in sorted order.
+var sortedKeysJSON = jsoniter.Config{EscapeHTML: true, SortMapKeys: true}.Froze()
+
+// marshalSortedKeys serializes query results with every object's keys in sorted order, so identical
+// queries produce byte-identical responses. Results can hold raw documents copied verbatim from storage,
+// so they are decoded into generic values first; numbers are kept as their original literals.
+func marshalSortedKeys(results any) ([]byte, error) {
+	raw, err := jsoniter.Marshal(results)
+	if err != nil {
+		return nil, err
+	}
+	decoder := sortedKeysJSON.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var generic any
+	if err := decoder.Decode(&generic); err != nil {
+		return nil, err
+	}
+	return sortedKeysJSON.Marshal(generic)
+}
+
 // processCollectionQuery executes a complex query on a collection.
-func (h *ConnectionHandler) processCollectionQuery(collectionName string, query *Query) (any, error) {
+// truncated reports that documents were dropped to keep the results within the response byte cap.
+// When stats is not nil it is filled with the scan/match counts and phase timings of the execution.
+// maxBytes caps the size of the returned documents; zero disables the cap.
+func (h *ConnectionHandler) processCollectionQuery(collectionName string, query *Query, stats *QueryStats, maxBytes int) (results any, truncated bool, err error) {
 	colStore := h.CollectionManager.GetCollection(collectionName)
 
-	isSimpleQuery := len(query.Filter) == 0 && len(query.OrderBy) == 0 &&
-		len(query.Aggregations) == 0 && len(query.GroupBy) == 0 &&
-		query.Distinct == "" && len(query.Lookups) == 0 && len(query.Projection) == 0 && !query.Count
+	if isCursorQuery(query) {
+		return h.processCursorQuery(collectionName, colStore, query, stats, maxBytes)
+	}
 
-	if isSimpleQuery {
+	if isEstimatedCount(query) {
+		slog.Debug("Estimating query count from a sample", "collection", collectionName, "sample_rate", query.SampleRate)
+		return h.estimateCount(colStore, query.Filter, query.SampleRate, stats), false, nil
+	}
+
+	if isCountOnlyQuery(query) {
+		count, counted, err := h.countFromIndexes(collectionName, colStore, query.Filter, stats)
+		if err != nil {
+			return nil, false, err
+		}
+		if counted {
+			return map[string]int{globalconst.AggCount: count}, false, nil
+		}
+	}
+
+	if isSimpleQuery(query) {
 		slog.Debug("Executing simple query fast path with streaming", "collection", collectionName)
 
 		capacity := 1024
@@ -116,13 +289,27 @@ func (h *ConnectionHandler) processCollectionQuery(collectionName string, query
 		if query.Limit != nil {
 			limit = *query.Limit
 		}
+		responseSize := 2 // The enclosing brackets of the JSON array.
 
+		scanStart := time.Now()
 		colStore.StreamAll(func(key string, value []byte) bool {
 			if processedCount < query.Offset {
 				processedCount++
 				return true
 			}
 
+			if maxBytes > 0 {
+				nextSize := responseSize + len(value)
+				if len(rawResults) > 0 {
+					nextSize++ // Separating comma.
+				}
+				if nextSize > maxBytes {
+					truncated = true
+					return false
+				}
+				responseSize = nextSize
+			}
+
 			rawResults = append(rawResults, value)
 
 			if limit != -1 && len(rawResults) >= limit {
@@ -132,43 +319,44 @@ func (h *ConnectionHandler) processCollectionQuery(collectionName string, query
 			return true
 		})
 
-		slog.Info("Simple query fast path finished", "collection", collectionName, "results_count", len(rawResults))
-		return rawResults, nil
+		slog.Info("Simple query fast path finished", "collection", collectionName, "results_count", len(rawResults), "truncated", truncated)
+		if stats != nil {
+			stats.Path = "simple"
+			stats.HotScanned = processedCount + len(rawResults)
+			stats.HotMatched = processedCount + len(rawResults)
+			stats.Returned = len(rawResults)
+			stats.recordPhase("hot_scan", scanStart)
+		}
+		return rawResults, truncated, nil
 	}
 
 	// --- Original Logic for Complex Queries ---
 	slog.Debug("Executing complex query path", "collection", collectionName)
 
 	// --- HOT SEARCH (IN RAM) ---
-	candidateKeys, usedIndex, remainingFilter := h.findCandidateKeysFromFilter(colStore, query.Filter)
-
-	var itemsData map[string][]byte
-	if usedIndex {
-		slog.Debug("Query optimizer using index(es) for hot data", "collection", collectionName, "candidate_keys", len(candidateKeys))
-		itemsData = colStore.GetMany(candidateKeys)
-	} else {
-		slog.Debug("Query optimizer NOT using index for hot data, falling back to full scan", "collection", collectionName)
-		itemsData = colStore.GetAll()
-		remainingFilter = query.Filter
+	if stats != nil {
+		stats.Path = "complex"
 	}
-
-	hotResultsMap := make(map[string]map[string]any)
-	for k, vBytes := range itemsData {
-		var val map[string]any
-		if err := jsoniter.Unmarshal(vBytes, &val); err != nil {
-			continue
-		}
-		if h.matchFilter(val, remainingFilter) {
-			hotResultsMap[k] = val
+	hotScanStart := time.Now()
+	hotResultsMap, hotScanned, usedIndex := h.searchHotData(collectionName, colStore, query.Filter)
+	slog.Info("Hot data query finished", "collection", collectionName, "found_matches", len(hotResultsMap))
+	if stats != nil {
+		if usedIndex {
+			stats.IndexesUsed = indexedFilterFields(colStore, query.Filter)
 		}
+		stats.HotScanned = hotScanned
+		stats.HotMatched = len(hotResultsMap)
+		stats.recordPhase("hot_scan", hotScanStart)
 	}
-	slog.Info("Hot data query finished", "collection", collectionName, "found_matches", len(hotResultsMap))
 
 	finalResults := make([]map[string]any, 0, len(hotResultsMap))
 	for _, hotItem := range hotResultsMap {
 		finalResults = append(finalResults, hotItem)
 	}
 
+	// COUNT/SUM/AVG/MIN/MAX are accumulated while scanning cold data instead of loading it.
+	streamAgg := newStreamingAggregator(query)
+
 	shouldSkipColdSearch := false
 	if query.Limit != nil && len(finalResults) >= *query.Limit {
 		slog.Debug("Skipping cold search: Limit met with hot data.", "collection", collectionName, "limit", *query.Limit, "hot_results", len(finalResults))
@@ -178,28 +366,69 @@ func (h *ConnectionHandler) processCollectionQuery(collectionName string, query
 	if !shouldSkipColdSearch {
 		// --- COLD SEARCH (ON DISK) ---
 		slog.Debug("Executing query against cold data (Disk)...", "collection", collectionName)
+		coldScanStart := time.Now()
+		coldScanned, coldMatched := 0, 0
 		coldMatcher := func(item map[string]any) bool {
+			coldScanned++
 			if id, ok := item[globalconst.ID].(string); ok {
 				if _, existsInHot := hotResultsMap[id]; existsInHot {
 					return false
 				}
 			}
-			return h.matchFilter(item, query.Filter)
+			if !h.matchFilter(item, query.Filter) {
+				return false
+			}
+			coldMatched++
+			return true
 		}
-		coldResults, err := persistence.SearchColdData(collectionName, coldMatcher)
-		if err != nil {
-			return nil, fmt.Errorf("error searching cold data: %w", err)
+		if streamAgg != nil {
+			coldMatches := 0
+			err := persistence.ScanColdData(collectionName, coldMatcher, func(doc map[string]any) bool {
+				streamAgg.add(doc)
+				coldMatches++
+				return true
+			})
+			if err != nil {
+				return nil, false, fmt.Errorf("error searching cold data: %w", err)
+			}
+			slog.Info("Cold data streaming aggregation finished", "collection", collectionName, "found_matches", coldMatches)
+		} else {
+			coldResults, err := persistence.SearchColdData(collectionName, coldMatcher)
+			if err != nil {
+				return nil, false, fmt.Errorf("error searching cold data: %w", err)
+			}
+			slog.Info("Cold data query finished", "collection", collectionName, "found_matches", len(coldResults))
+
+			// --- MERGE RESULTS ---
+			if len(coldResults) > 0 {
+				finalResults = append(finalResults, coldResults...)
+			}
 		}
-		slog.Info("Cold data query finished", "collection", collectionName, "found_matches", len(coldResults))
+		if stats != nil {
+			stats.ColdScanned = coldScanned
+			stats.ColdMatched = coldMatched
+			stats.recordPhase("cold_scan", coldScanStart)
+		}
+	}
 
-		// --- MERGE RESULTS ---
-		if len(coldResults) > 0 {
-			finalResults = append(finalResults, coldResults...)
+	if streamAgg != nil {
+		aggStart := time.Now()
+		for _, item := range finalResults {
+			streamAgg.add(item)
 		}
+		aggResult := streamAgg.result(h)
+		stats.recordPhase("aggregate", aggStart)
+		return aggResult, false, nil
 	}
 
 	slog.Info("Total results before processing", "count", len(finalResults))
 
+	aggStart := time.Now()
+	if query.Distinct != "" && query.DistinctCounts {
+		counts := countDistinctValues(finalResults, query.Distinct)
+		stats.recordPhase("aggregate", aggStart)
+		return counts, false, nil
+	}
 	if query.Distinct != "" {
 		distinctValues := make(map[any]bool)
 		var resultList []any
@@ -211,10 +440,12 @@ func (h *ConnectionHandler) processCollectionQuery(collectionName string, query
 				}
 			}
 		}
-		return resultList, nil
+		stats.recordPhase("aggregate", aggStart)
+		return resultList, false, nil
 	}
 	if query.Count && len(query.Aggregations) == 0 && len(query.GroupBy) == 0 {
-		return map[string]int{globalconst.AggCount: len(finalResults)}, nil
+		stats.recordPhase("aggregate", aggStart)
+		return map[string]int{globalconst.AggCount: len(finalResults)}, false, nil
 	}
 	if len(query.Aggregations) > 0 || len(query.GroupBy) > 0 {
 		var itemsForAgg []struct {
@@ -228,9 +459,12 @@ func (h *ConnectionHandler) processCollectionQuery(collectionName string, query
 				Val map[string]any
 			}{Key: key, Val: res})
 		}
-		return h.performAggregations(itemsForAgg, query)
+		aggResults, err := h.performAggregations(itemsForAgg, query)
+		stats.recordPhase("aggregate", aggStart)
+		return aggResults, false, err
 	}
 	if len(query.OrderBy) > 0 {
+		sortStart := time.Now()
 		sort.Slice(finalResults, func(i, j int) bool {
 			for _, ob := range query.OrderBy {
 				valA, okA := finalResults[i][ob.Field]
@@ -254,6 +488,7 @@ func (h *ConnectionHandler) processCollectionQuery(collectionName string, query
 			}
 			return false
 		})
+		stats.recordPhase("sort", sortStart)
 	}
 
 	offset := min(max(query.Offset, 0), len(finalResults))
@@ -270,61 +505,232 @@ func (h *ConnectionHandler) processCollectionQuery(collectionName string, query
 		}
 	}
 
+	paginatedResults, truncated = h.shapeResults(paginatedResults, query, stats, maxBytes)
+	return paginatedResults, truncated, nil
+}
+
+// isSimpleQuery reports whether a query returns stored documents as they are, with no filter,
+// ordering, or reshaping, so it can be answered by streaming the hot data.
+func isSimpleQuery(query *Query) bool {
+	return len(query.Filter) == 0 && len(query.OrderBy) == 0 &&
+		len(query.Aggregations) == 0 && len(query.GroupBy) == 0 &&
+		query.Distinct == "" && len(query.Lookups) == 0 && len(query.Projection) == 0 && len(query.Exclude) == 0 && !query.Count
+}
+
+// shapeResults runs the steps that follow pagination on a page of results: chained lookups,
+// projection, and the response byte cap. It reports whether the cap dropped documents.
+func (h *ConnectionHandler) shapeResults(paginatedResults []map[string]any, query *Query, stats *QueryStats, maxBytes int) (_ []map[string]any, truncated bool) {
 	// Chained Lookups (JOIN Pipeline)
 	if len(query.Lookups) > 0 {
-		currentResults := paginatedResults
+		lookupStart := time.Now()
 		for _, lookupSpec := range query.Lookups {
-			nextResults := []map[string]any{}
-			for _, doc := range currentResults {
-				localValue, ok := getNestedValue(doc, lookupSpec.LocalField)
-				if !ok {
-					doc[lookupSpec.As] = nil
-					nextResults = append(nextResults, doc)
-					continue
-				}
-
-				joinQuery := Query{
-					Filter: map[string]any{
-						"field": lookupSpec.ForeignField,
-						"op":    globalconst.OpEqual,
-						"value": localValue,
-					},
-				}
-
-				joinedData, err := h.processCollectionQuery(lookupSpec.FromCollection, &joinQuery)
-				if err != nil {
-					slog.Warn("Lookup sub-query failed", "error", err, "from", lookupSpec.FromCollection)
-					doc[lookupSpec.As] = nil
-				} else {
-					if joinedSlice, isSlice := joinedData.([]map[string]any); isSlice && len(joinedSlice) == 1 {
-						doc[lookupSpec.As] = joinedSlice[0]
-					} else {
-						doc[lookupSpec.As] = joinedData
-					}
-				}
-				nextResults = append(nextResults, doc)
-			}
-			currentResults = nextResults
+			h.applyLookup(paginatedResults, lookupSpec)
 		}
-		paginatedResults = currentResults
+		stats.recordPhase("lookup", lookupStart)
 	}
 
 	// Projection (SELECT specific fields)
 	if len(query.Projection) > 0 {
 		projectedResults := make([]map[string]any, 0, len(paginatedResults))
 		for _, fullDoc := range paginatedResults {
-			projectedDoc := make(map[string]any)
-			for _, fieldPath := range query.Projection {
-				if value, ok := getNestedValue(fullDoc, fieldPath); ok {
-					setNestedValue(projectedDoc, fieldPath, value)
-				}
-			}
-			projectedResults = append(projectedResults, projectedDoc)
+			projectedResults = append(projectedResults, projectFields(fullDoc, query.Projection))
+		}
+		paginatedResults = projectedResults
+	}
+	if len(query.Exclude) > 0 {
+		for i, fullDoc := range paginatedResults {
+			paginatedResults[i] = excludeFields(fullDoc, query.Exclude)
+		}
+	}
+
+	paginatedResults, truncated = capResultBytes(paginatedResults, maxBytes)
+	if stats != nil {
+		stats.Returned = len(paginatedResults)
+	}
+	return paginatedResults, truncated
+}
+
+// countDistinctValues counts the documents that have each value of field. The counts are ordered
+// by count, highest first, and then by value, so the result does not depend on the order in which
+// hot and cold documents were collected.
+func countDistinctValues(docs []map[string]any, field string) []DistinctCount {
+	positions := make(map[any]int)
+	counts := []DistinctCount{}
+	for _, doc := range docs {
+		val, ok := doc[field]
+		if !ok || val == nil {
+			continue
+		}
+		key := distinctKey(val)
+		if i, seen := positions[key]; seen {
+			counts[i].Count++
+			continue
+		}
+		positions[key] = len(counts)
+		counts = append(counts, DistinctCount{Value: val, Count: 1})
+	}
+	sort.SliceStable(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return compareOrderValues(counts[i].Value, counts[j].Value) < 0
+	})
+	return counts
+}
+
+// distinctKey maps a field value to a comparable key. Numbers are keyed by their float64 value so
+// the same number decoded as different types counts once; objects and arrays by their JSON encoding.
+func distinctKey(val any) any {
+	if num, ok := numberValue(val); ok {
+		return num
+	}
+	if isScalar(val) {
+		return val
+	}
+	encoded, _ := json.Marshal(val)
+	return struct{ json string }{string(encoded)}
+}
+
+// numberValue is toFloat64 for values that are numbers themselves; numeric strings such as "007"
+// stay strings, so they are not grouped with 7.
+func numberValue(val any) (float64, bool) {
+	if _, isString := val.(string); isString {
+		return 0, false
+	}
+	return toFloat64(val)
+}
+
+// lookupLikePattern finds the candidates of a LIKE pattern in a case-insensitive index. A pattern
+// without wildcards is a case-insensitive equality and one ending in wildcards is a prefix match;
+// any other pattern cannot use the index.
+func lookupLikePattern(colStore store.DataStore, field, pattern string) ([]string, bool) {
+	prefix := strings.TrimRight(pattern, "%")
+	if strings.Contains(prefix, "%") {
+		return nil, false
+	}
+	if prefix == pattern {
+		return colStore.Lookup(field, pattern)
+	}
+	return colStore.LookupPrefix(field, prefix)
+}
+
+// lookupNegation finds the documents that do not equal any of values (a "!=" or "not in" filter)
+// as every in-memory key minus the keys the index finds for the values. Documents without the
+// field match too, which is why the index's own keys are not enough. exact is false when some
+// documents hold values the index cannot hold, so the candidates must still be checked. The
+// index cannot be used at all if it is case-insensitive or holds array elements, since a lookup
+// would then find documents that are not equal to the value, nor for values it cannot hold.
+func lookupNegation(colStore store.DataStore, field string, values []any, options store.IndexOptions) (keys []string, exact, used bool) {
+	if options.CaseInsensitive || colStore.IndexHasArrayValues(field) {
+		return nil, false, false
+	}
+	excluded := make(map[string]struct{})
+	for _, value := range values {
+		if _, isBool := value.(bool); isBool || !isScalar(value) {
+			return nil, false, false
+		}
+		matches, ok := colStore.Lookup(field, value)
+		if !ok {
+			return nil, false, false
+		}
+		for _, key := range matches {
+			excluded[key] = struct{}{}
+		}
+	}
+	allKeys := colStore.Keys()
+	keys = make([]string, 0, len(allKeys))
+	for _, key := range allKeys {
+		if _, isExcluded := excluded[key]; !isExcluded {
+			keys = append(keys, key)
+		}
+	}
+	return keys, !colStore.IndexHasUnindexedValues(field), true
+}
+
+// searchHotData finds the in-memory documents matching filter, using indexes when the optimizer can.
+// It returns the matches by key, the number of documents examined, and whether an index was used.
+func (h *ConnectionHandler) searchHotData(collectionName string, colStore store.DataStore, filter map[string]any) (matches map[string]map[string]any, scanned int, usedIndex bool) {
+	candidateKeys, usedIndex, remainingFilter := h.findCandidateKeysFromFilter(colStore, filter)
+	if !usedIndex {
+		slog.Debug("Query optimizer NOT using index for hot data, falling back to parallel full scan", "collection", collectionName)
+		matches, scanned = h.parallelScan(colStore, filter)
+		return matches, scanned, false
+	}
+
+	slog.Debug("Query optimizer using index(es) for hot data", "collection", collectionName, "candidate_keys", len(candidateKeys))
+	itemsData := colStore.GetMany(candidateKeys)
+	matches = make(map[string]map[string]any)
+	for k, vBytes := range itemsData {
+		var val map[string]any
+		if err := jsoniter.Unmarshal(vBytes, &val); err != nil {
+			continue
+		}
+		if h.matchFilter(val, remainingFilter) {
+			matches[k] = val
+		}
+	}
+	return matches, len(itemsData), true
+}
+
+// capResultBytes returns the longest prefix of docs whose JSON array encoding fits in maxBytes,
+// and whether any documents were dropped. A non-positive maxBytes disables the cap.
+func capResultBytes(docs []map[string]any, maxBytes int) ([]map[string]any, bool) {
+	if maxBytes <= 0 {
+		return docs, false
+	}
+	responseSize := 2 // The enclosing brackets of the JSON array.
+	for i, doc := range docs {
+		encoded, err := jsoniter.Marshal(doc)
+		if err != nil {
+			continue
+		}
+		if i > 0 {
+			responseSize++ // Separating comma.
+		}
+		responseSize += len(encoded)
+		if responseSize > maxBytes {
+			return docs[:i], true
 		}
-		return projectedResults, nil
 	}
+	return docs, false
+}
+
+// parallelScan filters every hot item of a collection, scanning each shard in its own goroutine.
+// Matches are collected into per-shard maps and merged once all shards are done,
+// which avoids copying the whole collection as GetAll would. scanned is the number of items examined.
+func (h *ConnectionHandler) parallelScan(colStore store.DataStore, filter map[string]any) (matches map[string]map[string]any, scanned int) {
+	perShard := make([]map[string]map[string]any, colStore.ShardCount())
+	for i := range perShard {
+		perShard[i] = make(map[string]map[string]any)
+	}
+	scannedPerShard := make([]int, colStore.ShardCount())
+
+	colStore.ParallelStreamAll(func(shardIndex int, key string, value []byte) bool {
+		scannedPerShard[shardIndex]++
+		var val map[string]any
+		if err := jsoniter.Unmarshal(value, &val); err != nil {
+			return true
+		}
+		if h.matchFilter(val, filter) {
+			perShard[shardIndex][key] = val
+		}
+		return true
+	})
 
-	return paginatedResults, nil
+	total := 0
+	for _, m := range perShard {
+		total += len(m)
+	}
+	merged := make(map[string]map[string]any, total)
+	for _, m := range perShard {
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+	for _, n := range scannedPerShard {
+		scanned += n
+	}
+	return merged, scanned
 }
 
 // findCandidateKeysFromFilter is the advanced query optimizer.
@@ -346,9 +752,10 @@ func (h *ConnectionHandler) findCandidateKeysFromFilter(colStore store.DataStore
 				break
 			}
 
-			subKeys, subIndexUsed, _ := h.findCandidateKeysFromFilter(colStore, condMap)
+			subKeys, subIndexUsed, subRemainingFilter := h.findCandidateKeysFromFilter(colStore, condMap)
 
-			if !subIndexUsed {
+			// Keys are only a superset of a condition's matches while part of it remains to be checked.
+			if !subIndexUsed || len(subRemainingFilter) > 0 {
 				allConditionsAreIndexable = false
 				break
 			}
@@ -414,6 +821,7 @@ func (h *ConnectionHandler) findCandidateKeysFromFilter(colStore store.DataStore
 	if fieldOk && opOk && colStore.HasIndex(field) {
 		var keys []string
 		var used bool
+		options, _ := colStore.GetIndexOptions(field)
 
 		switch op {
 		case globalconst.OpEqual:
@@ -446,10 +854,41 @@ func (h *ConnectionHandler) findCandidateKeysFromFilter(colStore store.DataStore
 			if bounds, ok := value.([]any); ok && len(bounds) == 2 {
 				keys, used = colStore.LookupRange(field, bounds[0], bounds[1], true, true)
 			}
+		case globalconst.OpLike:
+			// LIKE matches regardless of case, so only a case-insensitive index can answer it.
+			if pattern, isStr := value.(string); isStr && options.CaseInsensitive {
+				keys, used = lookupLikePattern(colStore, field, pattern)
+			}
+		case globalconst.OpArrayContains:
+			// The elements of array values are indexed, so this is an equality lookup on them.
+			if isScalar(value) {
+				keys, used = colStore.Lookup(field, value)
+			}
+		case globalconst.OpNotEqual, globalconst.OpNotIn:
+			values, isSlice := value.([]any)
+			if op == globalconst.OpNotEqual {
+				values, isSlice = []any{value}, true
+			}
+			if isSlice {
+				if negKeys, exact, negUsed := lookupNegation(colStore, field, values, options); negUsed {
+					slog.Debug("Query optimizer: using index for negated filter", "field", field, "op", op, "found_keys", len(negKeys), "exact", exact)
+					if !exact {
+						return negKeys, true, filter
+					}
+					return negKeys, true, make(map[string]any)
+				}
+			}
 		}
 
 		if used {
 			slog.Debug("Query optimizer: using index for simple filter", "field", field, "op", op, "found_keys", len(keys))
+			// A case-insensitive index narrows the candidates, but its normalized values can differ
+			// from what the filter compares (e.g. case for "=", types for LIKE), so the condition is
+			// still checked against the candidate documents. So is any condition on an index holding
+			// array elements, and "contains", which an equal scalar field also satisfies in the index.
+			if options.CaseInsensitive || op == globalconst.OpArrayContains || colStore.IndexHasArrayValues(field) {
+				return keys, true, filter
+			}
 			return keys, true, make(map[string]any)
 		}
 	}
@@ -512,13 +951,13 @@ func (h *ConnectionHandler) matchFilter(item map[string]any, filter map[string]a
 	case globalconst.OpNotEqual:
 		return !itemValueExists || compare(itemValue, value) != 0
 	case globalconst.OpGreaterThan:
-		return itemValueExists && compare(itemValue, value) > 0
+		return isOrderable(itemValue, itemValueExists) && compare(itemValue, value) > 0
 	case globalconst.OpGreaterThanOrEqual:
-		return itemValueExists && compare(itemValue, value) >= 0
+		return isOrderable(itemValue, itemValueExists) && compare(itemValue, value) >= 0
 	case globalconst.OpLessThan:
-		return itemValueExists && compare(itemValue, value) < 0
+		return isOrderable(itemValue, itemValueExists) && compare(itemValue, value) < 0
 	case globalconst.OpLessThanOrEqual:
-		return itemValueExists && compare(itemValue, value) <= 0
+		return isOrderable(itemValue, itemValueExists) && compare(itemValue, value) <= 0
 	case globalconst.OpLike:
 		if !itemValueExists {
 			return false
@@ -535,10 +974,23 @@ func (h *ConnectionHandler) matchFilter(item map[string]any, filter map[string]a
 			}
 		}
 		return false
-	case globalconst.OpBetween:
+	case globalconst.OpRegex:
 		if !itemValueExists {
 			return false
 		}
+		if sVal, isStr := itemValue.(string); isStr {
+			re, err := filterRegexp(value)
+			if err != nil {
+				slog.Warn("Error in regex filter pattern", "value", value, "error", err)
+				return false
+			}
+			return re.MatchString(sVal)
+		}
+		return false
+	case globalconst.OpBetween:
+		if !isOrderable(itemValue, itemValueExists) {
+			return false
+		}
 		if values, ok := value.([]any); ok && len(values) == 2 {
 			return compare(itemValue, values[0]) >= 0 && compare(itemValue, values[1]) <= 0
 		}
@@ -555,16 +1007,63 @@ func (h *ConnectionHandler) matchFilter(item map[string]any, filter map[string]a
 			}
 		}
 		return false
+	case globalconst.OpNotIn:
+		values, ok := value.([]any)
+		if !ok {
+			return false
+		}
+		if !itemValueExists {
+			return true
+		}
+		for _, v := range values {
+			if compare(itemValue, v) == 0 {
+				return false
+			}
+		}
+		return true
 	case globalconst.OpIsNull:
 		return !itemValueExists || itemValue == nil
 	case globalconst.OpIsNotNull:
 		return itemValueExists && itemValue != nil
+	case globalconst.OpArrayContains:
+		if elements, ok := itemValue.([]any); ok {
+			for _, element := range elements {
+				if isScalar(element) && compare(element, value) == 0 {
+					return true
+				}
+			}
+		}
+		return false
+	case globalconst.OpArraySize:
+		if elements, ok := itemValue.([]any); ok {
+			if size, isNum := toFloat64(value); isNum {
+				return float64(len(elements)) == size
+			}
+		}
+		return false
 	default:
 		slog.Warn("Unsupported filter operator", "operator", op)
 		return false
 	}
 }
 
+// isOrderable reports whether a field value can take part in a range comparison. A null value,
+// such as an aggregation over a group without numeric values, would otherwise be compared as the
+// text "<nil>" and match ranges like "> 1000".
+func isOrderable(v any, exists bool) bool {
+	return exists && v != nil
+}
+
+// isScalar reports whether a value is a string, number or boolean rather than an object, array or null.
+func isScalar(v any) bool {
+	switch v.(type) {
+	case string, bool:
+		return true
+	}
+	_, isNum := toFloat64(v)
+	return isNum
+}
+
 // compare two any values. Returns -1 if a<b, 0 if a==b, 1 if a>b.
 func compare(a, b any) int {
 	if numA, okA := toFloat64(a); okA {
@@ -597,6 +1096,12 @@ func toFloat64(val any) (float64, bool) {
 		return float64(v), true
 	case int64:
 		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
 	case float32:
 		return float64(v), true
 	case float64:
@@ -604,6 +1109,10 @@ func toFloat64(val any) (float64, bool) {
 	case jsoniter.Number:
 		f, err := v.Float64()
 		return f, err == nil
+	case stdjson.Number:
+		// Decoders with UseNumber, jsoniter's included, produce encoding/json numbers.
+		f, err := v.Float64()
+		return f, err == nil
 	case string:
 		f, err := strconv.ParseFloat(v, 64)
 		return f, err == nil
@@ -618,6 +1127,7 @@ func (h *ConnectionHandler) performAggregations(items []struct {
 	Val map[string]any
 }, query *Query) (any, error) {
 	groupedData := make(map[string][]map[string]any)
+	groupValues := make(map[string][]any)
 
 	if len(query.GroupBy) == 0 {
 		groupKey := "_no_group_"
@@ -627,15 +1137,10 @@ func (h *ConnectionHandler) performAggregations(items []struct {
 		}
 	} else {
 		for _, item := range items {
-			groupKeyParts := make([]string, len(query.GroupBy))
-			for i, field := range query.GroupBy {
-				if val, ok := item.Val[field]; ok && val != nil {
-					groupKeyParts[i] = fmt.Sprintf("%v", val)
-				} else {
-					groupKeyParts[i] = "NULL"
-				}
+			groupKey, values := groupKeyOf(item.Val, query.GroupBy)
+			if _, ok := groupedData[groupKey]; !ok {
+				groupValues[groupKey] = values
 			}
-			groupKey := strings.Join(groupKeyParts, "|")
 			groupedData[groupKey] = append(groupedData[groupKey], item.Val)
 		}
 	}
@@ -644,15 +1149,8 @@ func (h *ConnectionHandler) performAggregations(items []struct {
 	for groupKey, groupItems := range groupedData {
 		resultRow := make(map[string]any)
 
-		if len(query.GroupBy) > 0 {
-			if groupKey != "_no_group_" {
-				groupKeyValues := strings.Split(groupKey, "|")
-				for i, field := range query.GroupBy {
-					if i < len(groupKeyValues) {
-						resultRow[field] = groupKeyValues[i]
-					}
-				}
-			}
+		for i, field := range query.GroupBy {
+			resultRow[field] = groupValues[groupKey][i]
 		}
 
 		for aggName, agg := range query.Aggregations {
@@ -672,7 +1170,16 @@ func (h *ConnectionHandler) performAggregations(items []struct {
 					}
 					aggValue = count
 				}
-			case globalconst.AggSum, globalconst.AggAvg, globalconst.AggMin, globalconst.AggMax:
+			case globalconst.AggCountDistinct:
+				seen := make(map[any]struct{})
+				for _, item := range groupItems {
+					if val, ok := item[agg.Field]; ok && val != nil {
+						seen[distinctKey(val)] = struct{}{}
+					}
+				}
+				aggValue = len(seen)
+			case globalconst.AggSum, globalconst.AggAvg, globalconst.AggMin, globalconst.AggMax,
+				globalconst.AggStdDev, globalconst.AggStdDevSample, globalconst.AggVariance, globalconst.AggVarianceSample:
 				numbers := []float64{}
 				for _, item := range groupItems {
 					if val, ok := item[agg.Field]; ok {
@@ -716,6 +1223,18 @@ func (h *ConnectionHandler) performAggregations(items []struct {
 						}
 					}
 					aggValue = max
+				case globalconst.AggStdDev, globalconst.AggStdDevSample, globalconst.AggVariance, globalconst.AggVarianceSample:
+					sample := agg.Func == globalconst.AggStdDevSample || agg.Func == globalconst.AggVarianceSample
+					variance, defined := varianceOf(numbers, sample)
+					switch {
+					case !defined:
+						// The sample variance of a single value is undefined.
+						aggValue = nil
+					case agg.Func == globalconst.AggStdDev || agg.Func == globalconst.AggStdDevSample:
+						aggValue = math.Sqrt(variance)
+					default:
+						aggValue = variance
+					}
 				default:
 					err = fmt.Errorf("unsupported aggregation function: %s", agg.Func)
 				}
@@ -737,6 +1256,48 @@ func (h *ConnectionHandler) performAggregations(items []struct {
 	return aggregatedResults, nil
 }
 
+// groupKeyOf returns the GROUP BY key of doc and the values it was built from. The key encodes
+// the tuple of values, so values containing any character cannot collide, and numbers are keyed
+// by value, so 5 and 5.0 fall in the same group. A missing field groups like null.
+func groupKeyOf(doc map[string]any, fields []string) (string, []any) {
+	values := make([]any, len(fields))
+	keyParts := make([]any, len(fields))
+	for i, field := range fields {
+		if val, ok := doc[field]; ok && val != nil {
+			values[i] = val
+			keyParts[i] = val
+			if num, isNum := numberValue(val); isNum {
+				keyParts[i] = num
+			}
+		}
+	}
+	key, _ := json.Marshal(keyParts)
+	return string(key), values
+}
+
+// varianceOf returns the population variance of numbers, or the sample variance (divided by n-1)
+// if sample is set. The second result is false when the variance is undefined: a sample variance
+// needs at least two values.
+func varianceOf(numbers []float64, sample bool) (float64, bool) {
+	n := len(numbers)
+	if n == 0 || (sample && n < 2) {
+		return 0, false
+	}
+	mean := 0.0
+	for _, x := range numbers {
+		mean += x
+	}
+	mean /= float64(n)
+	sumSquares := 0.0
+	for _, x := range numbers {
+		sumSquares += (x - mean) * (x - mean)
+	}
+	if sample {
+		return sumSquares / float64(n-1), true
+	}
+	return sumSquares / float64(n), true
+}
+
 func min(a, b int) int {
 	return int(math.Min(float64(a), float64(b)))
 }
@@ -777,29 +1338,58 @@ func intersectKeys(keySets [][]string) []string {
 
 // getNestedValue retrieves a value from a nested map using a dot-separated path.
 func getNestedValue(data map[string]any, path string) (any, bool) {
+	return store.NestedValue(data, path)
+}
+
+// projectFields returns a new document holding only the given dot-separated paths of doc.
+// Paths missing from doc are skipped.
+func projectFields(doc map[string]any, fields []string) map[string]any {
+	projected := make(map[string]any)
+	for _, fieldPath := range fields {
+		if value, ok := getNestedValue(doc, fieldPath); ok {
+			setNestedValue(projected, fieldPath, value)
+		}
+	}
+	return projected
+}
+
+// excludeFields returns a copy of doc without the given dot-separated paths. Maps on the way to
+// a removed path are copied, so doc itself is left untouched. _id, created_at and updated_at are
+// treated like any other field: they are only removed when listed.
+func excludeFields(doc map[string]any, fields []string) map[string]any {
+	result := make(map[string]any, len(doc))
+	for k, v := range doc {
+		result[k] = v
+	}
+	for _, fieldPath := range fields {
+		deleteNestedValue(result, fieldPath)
+	}
+	return result
+}
+
+// deleteNestedValue removes a value from a nested map using a dot-separated path. data must be a
+// copy owned by the caller; nested maps along the path are copied before they are changed.
+func deleteNestedValue(data map[string]any, path string) {
 	parts := strings.Split(path, ".")
-	var current any = data
+	currentMap := data
 
-	for _, part := range parts {
-		if currentSlice, ok := current.([]any); ok && len(currentSlice) == 1 {
-			current = currentSlice[0]
-		} else if currentMapSlice, ok := current.([]map[string]any); ok && len(currentMapSlice) == 1 {
-			current = currentMapSlice[0]
+	for i, key := range parts {
+		if i == len(parts)-1 {
+			delete(currentMap, key)
+			return
 		}
 
-		currentMap, ok := current.(map[string]any)
+		nextMap, ok := currentMap[key].(map[string]any)
 		if !ok {
-			return nil, false
+			return
 		}
-
-		value, found := currentMap[part]
-		if !found {
-			return nil, false
+		copied := make(map[string]any, len(nextMap))
+		for k, v := range nextMap {
+			copied[k] = v
 		}
-		current = value
+		currentMap[key] = copied
+		currentMap = copied
 	}
-
-	return current, true
 }
 
 // setNestedValue sets a value in a nested map using a dot-separated path.
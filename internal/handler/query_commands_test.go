@@ -0,0 +1,307 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	stdjson "encoding/json"
+)
+
+// queryKeys runs a query and returns the sorted _id of the documents it returns.
+func (e *testEnv) queryKeys(collectionName, query string) []string {
+	e.t.Helper()
+	resp := e.run(e.handler().handleCollectionQuery, func(w io.Writer) error {
+		return protocol.WriteCollectionQueryCommand(w, collectionName, []byte(query))
+	})
+	expectStatus(e.t, resp, protocol.StatusOk)
+	var docs []map[string]stdjson.RawMessage
+	if err := json.Unmarshal(resp.data, &docs); err != nil {
+		e.t.Fatalf("query results %q: %v", resp.data, err)
+	}
+	keys := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		var key string
+		json.Unmarshal(doc["_id"], &key)
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func TestRangeQueryOnMixedTypeIndexMatchesFullScan(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	h := env.handler()
+	for key, value := range map[string]string{"a": `5`, "b": `50`, "c": `500`, "d": `"70"`, "e": `true`} {
+		expectStatus(t, env.run(h.HandleCollectionItemSet, func(w io.Writer) error {
+			return protocol.WriteCollectionItemSetCommand(w, "items", key, []byte(`{"_id":"`+key+`","v":`+value+`}`), 0)
+		}), protocol.StatusOk)
+	}
+	query := `{"filter":{"field":"v","op":">","value":10}}`
+	want := env.queryKeys("items", query)
+
+	expectStatus(t, env.run(h.HandleCollectionIndexCreate, func(w io.Writer) error {
+		return protocol.WriteCollectionIndexCreateCommand(w, "items", "v")
+	}), protocol.StatusOk)
+	if got := env.queryKeys("items", query); !slices.Equal(got, want) {
+		t.Fatalf("indexed query = %v, full scan = %v", got, want)
+	}
+}
+
+// BenchmarkFullScan compares a scan of every shard in turn with parallelScan, which scans the
+// shards concurrently, for a filter no index can answer.
+func BenchmarkFullScan(b *testing.B) {
+	const docs = 100000
+	colStore := store.NewInMemStoreWithShards(16)
+	for i := range docs {
+		colStore.Set(fmt.Sprintf("doc-%d", i), fmt.Appendf(nil, `{"n":%d,"group":"g%d","text":"some filler text"}`, i, i%10), 0)
+	}
+	filter := map[string]any{"field": "group", "op": "=", "value": "g3"}
+	h := &ConnectionHandler{}
+
+	b.Run("sequential", func(b *testing.B) {
+		for b.Loop() {
+			matches := make(map[string]map[string]any)
+			colStore.StreamAll(func(key string, value []byte) bool {
+				var doc map[string]any
+				if err := json.Unmarshal(value, &doc); err == nil && h.matchFilter(doc, filter) {
+					matches[key] = doc
+				}
+				return true
+			})
+			if len(matches) != docs/10 {
+				b.Fatalf("%d matches, want %d", len(matches), docs/10)
+			}
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		for b.Loop() {
+			if matches, _ := h.parallelScan(colStore, filter); len(matches) != docs/10 {
+				b.Fatalf("%d matches, want %d", len(matches), docs/10)
+			}
+		}
+	})
+}
+
+func TestSortedKeysQueryOutputIsStable(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	for i := range 20 {
+		env.setItem("items", fmt.Sprintf("k%02d", i), fmt.Sprintf(`{"zeta":%d,"alpha":"a","mid":{"y":1,"b":[{"q":1,"c":2}],"a":true},"omega":null,"beta":%d.5}`, i, i))
+	}
+	query := []byte(`{"sorted_keys":true,"order_by":[{"field":"zeta","direction":"asc"}],"projection":["_id","zeta","alpha","mid","omega","beta"]}`)
+
+	var first []byte
+	for range 25 {
+		resp := env.run(env.handler().handleCollectionQuery, func(w io.Writer) error {
+			return protocol.WriteCollectionQueryCommand(w, "items", query)
+		})
+		expectStatus(t, resp, protocol.StatusOk)
+		if first == nil {
+			first = resp.data
+			continue
+		}
+		if string(resp.data) != string(first) {
+			t.Fatalf("query output changed between runs:\n%s\n%s", first, resp.data)
+		}
+	}
+
+	// encoding/json writes map keys in sorted order, so re-encoding must not change anything.
+	var generic any
+	decoder := stdjson.NewDecoder(bytes.NewReader(first))
+	decoder.UseNumber()
+	if err := decoder.Decode(&generic); err != nil {
+		t.Fatal(err)
+	}
+	sorted, _ := stdjson.Marshal(generic)
+	if string(sorted) != string(first) {
+		t.Fatalf("keys are not sorted:\n got  %s\n want %s", first, sorted)
+	}
+}
+
+func TestMarshalSortedKeysOfRawDocuments(t *testing.T) {
+	results := []stdjson.RawMessage{[]byte(`{"b":9007199254740993,"a":{"d":2,"c":3}}`), []byte(`{"z":"x","y":[{"k":1,"j":2}]}`)}
+	got, err := marshalSortedKeys(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"a":{"c":3,"d":2},"b":9007199254740993},{"y":[{"j":2,"k":1}],"z":"x"}]`; string(got) != want {
+		t.Fatalf("marshalSortedKeys = %s, want %s", got, want)
+	}
+}
+
+// largeDocs stores count documents of roughly 1 KiB each.
+func (e *testEnv) largeDocs(collectionName string, count int) {
+	e.t.Helper()
+	e.createTestCollection(collectionName)
+	padding := strings.Repeat("x", 1000)
+	for i := range count {
+		e.setItem(collectionName, fmt.Sprintf("k%03d", i), fmt.Sprintf(`{"n":%d,"padding":"%s"}`, i, padding))
+	}
+}
+
+func TestQueryByteCapTruncatesBeforeRowLimit(t *testing.T) {
+	env := newTestEnv(t)
+	env.largeDocs("big", 60)
+	const maxBytes = 10000
+
+	for name, raw := range map[string]string{
+		"simple":   `{"limit":50}`,
+		"filtered": `{"filter":{"field":"n","op":">=","value":5},"order_by":[{"field":"n","direction":"asc"}],"limit":50}`,
+	} {
+		var query Query
+		if err := json.Unmarshal([]byte(raw), &query); err != nil {
+			t.Fatal(err)
+		}
+		results, truncated, err := env.handler().processCollectionQuery("big", &query, nil, maxBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, _ := json.Marshal(results)
+		var docs []map[string]any
+		json.Unmarshal(encoded, &docs)
+		if !truncated {
+			t.Errorf("%s: not truncated", name)
+		}
+		if len(encoded) > maxBytes {
+			t.Errorf("%s: results are %d bytes, over the %d byte cap", name, len(encoded), maxBytes)
+		}
+		if len(docs) == 0 || len(docs) >= 50 {
+			t.Errorf("%s: %d documents returned, want a partial set below the row limit", name, len(docs))
+		}
+		if name == "filtered" && docs[0]["n"] != float64(5) {
+			t.Errorf("filtered: first document = %v, want the partial set to start at the first match", docs[0]["n"])
+		}
+	}
+
+	var query Query
+	json.Unmarshal([]byte(`{"limit":5}`), &query)
+	if _, truncated, _ := env.handler().processCollectionQuery("big", &query, nil, maxBytes); truncated {
+		t.Error("results within the cap were reported as truncated")
+	}
+}
+
+func TestQueryResponseReportsTruncation(t *testing.T) {
+	env := newTestEnv(t)
+	env.largeDocs("big", 30)
+	SetMaxQueryResponseBytes(5000)
+	t.Cleanup(func() { SetMaxQueryResponseBytes(0) })
+
+	resp := env.run(env.handler().handleCollectionQuery, func(w io.Writer) error {
+		return protocol.WriteCollectionQueryCommand(w, "big", []byte(`{"limit":20}`))
+	})
+	expectStatus(t, resp, protocol.StatusOk)
+	if !strings.Contains(resp.msg, "TRUNCATED") {
+		t.Errorf("message %q does not report the truncation", resp.msg)
+	}
+	if len(resp.data) > 5000 {
+		t.Errorf("response data is %d bytes, over the cap", len(resp.data))
+	}
+}
+
+func TestCreatedTsAgeQueriesMatchCreatedAt(t *testing.T) {
+	env := newTestEnv(t)
+	env.cm.SetCreatedTsIndex(true)
+	env.createTestCollection("events")
+	colStore := env.cm.GetCollection("events")
+	now := time.Now().UTC().Truncate(time.Second)
+	for i, age := range []time.Duration{0, time.Hour, 47 * time.Hour, 48 * time.Hour, 49 * time.Hour, 30 * 24 * time.Hour, 400 * 24 * time.Hour} {
+		key := fmt.Sprintf("e%d", i)
+		doc := map[string]any{"_id": key}
+		store.SetCreationTime(doc, now.Add(-age))
+		value, _ := json.Marshal(doc)
+		colStore.Set(key, value, 0)
+	}
+	env.setItem("events", "fresh", `{}`)
+
+	cutoff, since := now.Add(-48*time.Hour), now.Add(-60*24*time.Hour)
+	for _, pair := range [][2]string{
+		{
+			fmt.Sprintf(`{"filter":{"field":"_created_ts","op":"<","value":%d}}`, cutoff.Unix()),
+			fmt.Sprintf(`{"filter":{"field":"created_at","op":"<","value":"%s"}}`, cutoff.Format(time.RFC3339)),
+		},
+		{
+			fmt.Sprintf(`{"filter":{"field":"_created_ts","op":">=","value":%d}}`, cutoff.Unix()),
+			fmt.Sprintf(`{"filter":{"field":"created_at","op":">=","value":"%s"}}`, cutoff.Format(time.RFC3339)),
+		},
+		{
+			fmt.Sprintf(`{"filter":{"field":"_created_ts","op":"between","value":[%d,%d]}}`, since.Unix(), cutoff.Unix()),
+			fmt.Sprintf(`{"filter":{"field":"created_at","op":"between","value":["%s","%s"]}}`, since.Format(time.RFC3339), cutoff.Format(time.RFC3339)),
+		},
+	} {
+		numeric, str := env.queryKeys("events", pair[0]), env.queryKeys("events", pair[1])
+		if len(numeric) == 0 || !slices.Equal(numeric, str) {
+			t.Errorf("_created_ts query %s = %v, created_at query = %v", pair[0], numeric, str)
+		}
+	}
+
+	resp := env.run(env.handler().handleCollectionQuery, func(w io.Writer) error {
+		return protocol.WriteCollectionQueryCommand(w, "events", fmt.Appendf(nil, `{"explain":true,"filter":{"field":"_created_ts","op":"<","value":%d}}`, cutoff.Unix()))
+	})
+	expectStatus(t, resp, protocol.StatusOk)
+	var plan QueryPlan
+	if err := json.Unmarshal(resp.data, &plan); err != nil {
+		t.Fatal(err)
+	}
+	if plan.FullScan || !slices.Contains(plan.IndexesUsed, "_created_ts") {
+		t.Errorf("plan = %+v, want the _created_ts index used", plan)
+	}
+}
+
+func TestHavingFiltersOnAggregationAliases(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("sales")
+	for i, sale := range []string{
+		`{"region":"north","amount":700}`, `{"region":"north","amount":800}`,
+		`{"region":"south","amount":300}`, `{"region":"south","amount":500}`,
+		`{"region":"east","amount":1000}`,
+		`{"region":"west","amount":"n/a"}`,
+	} {
+		env.setItem("sales", fmt.Sprintf("s%d", i), sale)
+	}
+	regions := func(having string) []string {
+		t.Helper()
+		resp := env.run(env.handler().handleCollectionQuery, func(w io.Writer) error {
+			return protocol.WriteCollectionQueryCommand(w, "sales", []byte(`{"group_by":["region"],"aggregations":{"total":{"func":"sum","field":"amount"},"avg_amount":{"func":"avg","field":"amount"},"var":{"func":"variance_sample","field":"amount"}},"having":`+having+`}`))
+		})
+		expectStatus(t, resp, protocol.StatusOk)
+		var rows []map[string]any
+		if err := json.Unmarshal(resp.data, &rows); err != nil {
+			t.Fatalf("results %q: %v", resp.data, err)
+		}
+		names := make([]string, 0, len(rows))
+		for _, row := range rows {
+			names = append(names, row["region"].(string))
+		}
+		slices.Sort(names)
+		return names
+	}
+
+	// The west group has no numeric amount and the sample variance of the single east amount is
+	// null, so these aggregates never satisfy a range.
+	tests := []struct {
+		having string
+		want   []string
+	}{
+		{`{"field":"total","op":">","value":1000}`, []string{"north"}},
+		{`{"field":"total","op":">=","value":1000}`, []string{"east", "north"}},
+		{`{"field":"total","op":"<","value":1000}`, []string{"south"}},
+		{`{"field":"total","op":"<=","value":1000.0}`, []string{"east", "south"}},
+		{`{"field":"total","op":"between","value":[800,1000]}`, []string{"east", "south"}},
+		{`{"field":"avg_amount","op":">","value":500}`, []string{"east", "north"}},
+		{`{"field":"var","op":">","value":0}`, []string{"north", "south"}},
+		{`{"and":[{"field":"total","op":">","value":100},{"field":"avg_amount","op":"<","value":800}]}`, []string{"north", "south"}},
+	}
+	for _, tt := range tests {
+		if got := regions(tt.having); !slices.Equal(got, tt.want) {
+			t.Errorf("having %s = %v, want %v", tt.having, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package handler
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/store"
+	"sort"
+	"strings"
+	"time"
+)
+
+// minCursorScanBatch is the smallest number of index positions fetched per step of an index seek.
+const minCursorScanBatch = 100
+
+// CursorPage is the response payload of a query paginated with a cursor.
+type CursorPage struct {
+	Results    []map[string]any `json:"results"`
+	NextCursor string           `json:"next_cursor"` // Pass as "after" to fetch the next page. Empty on the last page.
+}
+
+// queryCursor is the decoded form of the opaque next_cursor token. It records the position of the
+// last returned document rather than the document itself, so a page can be resumed even after that
+// document has been deleted or updated.
+type queryCursor struct {
+	Field      string `json:"f"`
+	Descending bool   `json:"d,omitempty"`
+	Value      any    `json:"v,omitempty"`
+	Missing    bool   `json:"m,omitempty"` // The document had no value for the order field.
+	ID         string `json:"id"`
+}
+
+// isCursorQuery reports whether a query asks for cursor pagination.
+func isCursorQuery(query *Query) bool {
+	return query.Paginate || query.After != ""
+}
+
+// cursorOrder returns the field and direction a cursor query is ordered by. Without an order_by,
+// documents are ordered by _id.
+func cursorOrder(query *Query) (field string, descending bool) {
+	if len(query.OrderBy) == 0 {
+		return globalconst.ID, false
+	}
+	return query.OrderBy[0].Field, query.OrderBy[0].Direction == globalconst.SortDesc
+}
+
+func encodeQueryCursor(cursor queryCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeQueryCursor(token string) (*queryCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	var cursor queryCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Field == "" {
+		return nil, errors.New("malformed cursor")
+	}
+	return &cursor, nil
+}
+
+// validateCursorQuery checks that a query can be paginated with a cursor and, when it resumes from
+// one, that the cursor was issued for the same ordering.
+func validateCursorQuery(query *Query) error {
+	if query.Limit == nil || *query.Limit <= 0 {
+		return errors.New("cursor pagination requires a positive 'limit'")
+	}
+	if query.Offset != 0 {
+		return errors.New("'offset' cannot be combined with cursor pagination")
+	}
+	if len(query.OrderBy) > 1 {
+		return errors.New("cursor pagination supports ordering by a single field; ties are broken by _id")
+	}
+	if query.Count || len(query.Aggregations) > 0 || len(query.GroupBy) > 0 || query.Distinct != "" {
+		return errors.New("cursor pagination cannot be combined with count, aggregations, group_by or distinct")
+	}
+	if query.Format == globalconst.FormatCSV {
+		return errors.New("cursor pagination is only available with the 'json' format")
+	}
+	if query.After == "" {
+		return nil
+	}
+	cursor, err := decodeQueryCursor(query.After)
+	if err != nil {
+		return err
+	}
+	field, descending := cursorOrder(query)
+	if cursor.Field != field || cursor.Descending != descending {
+		return errors.New("cursor was issued for a different order_by")
+	}
+	return nil
+}
+
+// cursorPositionOf returns a document's position in the order of a cursor query.
+func cursorPositionOf(doc map[string]any, field string, descending bool) queryCursor {
+	id, _ := doc[globalconst.ID].(string)
+	position := queryCursor{Field: field, Descending: descending, ID: id}
+	if value, ok := getNestedValue(doc, field); ok && value != nil {
+		position.Value = value
+	} else {
+		position.Missing = true
+	}
+	return position
+}
+
+// compareCursorPositions orders two positions by the order field, documents without a value first,
+// then by _id so that documents sharing a value keep a stable order. Descending order reverses both.
+func compareCursorPositions(a, b queryCursor) int {
+	cmp := 0
+	switch {
+	case a.Missing && b.Missing:
+	case a.Missing:
+		cmp = -1
+	case b.Missing:
+		cmp = 1
+	default:
+		cmp = compareOrderValues(a.Value, b.Value)
+	}
+	if cmp == 0 {
+		cmp = strings.Compare(a.ID, b.ID)
+	}
+	if a.Descending {
+		return -cmp
+	}
+	return cmp
+}
+
+// compareOrderValues compares order field values the way indexes order them: strings
+// lexicographically even when they look numeric, numbers numerically, and numbers before strings.
+func compareOrderValues(a, b any) int {
+	strA, isStrA := a.(string)
+	strB, isStrB := b.(string)
+	switch {
+	case isStrA && isStrB:
+		return strings.Compare(strA, strB)
+	case isStrA:
+		return 1
+	case isStrB:
+		return -1
+	}
+	return compare(a, b)
+}
+
+// processCursorQuery returns the page of results that follows the query's cursor. When every
+// document is in memory and the order field's index orders all of them, the page is read by seeking
+// in the index, so deep pages cost no more than the first one. Otherwise the matches that follow the
+// cursor are collected and sorted.
+func (h *ConnectionHandler) processCursorQuery(collectionName string, colStore store.DataStore, query *Query, stats *QueryStats, maxBytes int) (CursorPage, bool, error) {
+	field, descending := cursorOrder(query)
+	limit := *query.Limit
+	var cursor *queryCursor
+	if query.After != "" {
+		var err error
+		if cursor, err = decodeQueryCursor(query.After); err != nil {
+			return CursorPage{}, false, err
+		}
+	}
+	if stats != nil {
+		stats.Path = "cursor"
+	}
+
+	scanStart := time.Now()
+	page, usedIndex := h.seekCursorPage(colStore, query.Filter, field, descending, cursor, limit, stats)
+	if !usedIndex {
+		var err error
+		page, err = h.scanCursorPage(collectionName, colStore, query.Filter, field, descending, cursor, limit, stats)
+		if err != nil {
+			return CursorPage{}, false, err
+		}
+	}
+	stats.recordPhase("hot_scan", scanStart)
+	slog.Debug("Cursor page collected", "collection", collectionName, "order_field", field, "used_index", usedIndex, "documents", len(page))
+
+	hasMore := len(page) > limit
+	page = page[:min(len(page), limit)]
+	// Positions are taken before lookups and projection, which may drop the order field.
+	positions := make([]queryCursor, len(page))
+	for i, doc := range page {
+		positions[i] = cursorPositionOf(doc, field, descending)
+	}
+
+	results, truncated := h.shapeResults(page, query, stats, maxBytes)
+	response := CursorPage{Results: results}
+	if (hasMore || truncated) && len(page) > 0 {
+		// A document too large for the byte cap on its own is skipped, or the cursor would never move.
+		response.NextCursor = encodeQueryCursor(positions[max(len(results), 1)-1])
+	}
+	return response, truncated, nil
+}
+
+// seekCursorPage reads up to limit+1 matching documents following the cursor by walking the index of
+// the order field. It reports false when the index cannot be used, e.g. because some documents are
+// in cold storage or lack an indexed value for the field.
+func (h *ConnectionHandler) seekCursorPage(colStore store.DataStore, filter map[string]any, field string, descending bool, cursor *queryCursor, limit int, stats *QueryStats) ([]map[string]any, bool) {
+	if hasColdData(colStore) {
+		return nil, false
+	}
+	var after *store.IndexPosition
+	if cursor != nil {
+		if cursor.Missing {
+			return nil, false
+		}
+		after = &store.IndexPosition{Value: cursor.Value, Key: cursor.ID}
+	}
+
+	batchSize := max(limit+1, minCursorScanBatch)
+	page := make([]map[string]any, 0, limit+1)
+	scanned := 0
+	for len(page) <= limit {
+		positions, ok := colStore.ScanIndexOrder(field, after, descending, batchSize)
+		if !ok {
+			return nil, false
+		}
+		if len(positions) == 0 {
+			break
+		}
+		keys := make([]string, len(positions))
+		for i, position := range positions {
+			keys[i] = position.Key
+		}
+		docs := colStore.GetMany(keys)
+		for _, position := range positions {
+			raw, found := docs[position.Key]
+			if !found {
+				continue // Deleted or expired since the index was read.
+			}
+			var doc map[string]any
+			if err := json.Unmarshal(raw, &doc); err != nil {
+				continue
+			}
+			scanned++
+			if h.matchFilter(doc, filter) {
+				page = append(page, doc)
+				if len(page) > limit {
+					break
+				}
+			}
+		}
+		if len(positions) < batchSize {
+			break
+		}
+		after = &positions[len(positions)-1]
+	}
+
+	if stats != nil {
+		stats.IndexesUsed = []string{field}
+		stats.HotScanned = scanned
+		stats.HotMatched = len(page)
+	}
+	return page, true
+}
+
+// scanCursorPage collects the hot and cold matches that follow the cursor, sorts them and returns
+// the first limit+1.
+func (h *ConnectionHandler) scanCursorPage(collectionName string, colStore store.DataStore, filter map[string]any, field string, descending bool, cursor *queryCursor, limit int, stats *QueryStats) ([]map[string]any, error) {
+	followsCursor := func(doc map[string]any) bool {
+		return cursor == nil || compareCursorPositions(cursorPositionOf(doc, field, descending), *cursor) > 0
+	}
+
+	hotMatches, hotScanned, usedIndex := h.searchHotData(collectionName, colStore, filter)
+	candidates := make([]map[string]any, 0, len(hotMatches))
+	for _, doc := range hotMatches {
+		if followsCursor(doc) {
+			candidates = append(candidates, doc)
+		}
+	}
+
+	coldScanned, coldMatched := 0, 0
+	coldResults, err := persistence.SearchColdData(collectionName, func(item map[string]any) bool {
+		coldScanned++
+		if id, ok := item[globalconst.ID].(string); ok {
+			if _, existsInHot := hotMatches[id]; existsInHot {
+				return false
+			}
+		}
+		if !h.matchFilter(item, filter) {
+			return false
+		}
+		coldMatched++
+		return followsCursor(item)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error searching cold data: %w", err)
+	}
+	candidates = append(candidates, coldResults...)
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return compareCursorPositions(cursorPositionOf(candidates[i], field, descending), cursorPositionOf(candidates[j], field, descending)) < 0
+	})
+
+	if stats != nil {
+		if usedIndex {
+			stats.IndexesUsed = indexedFilterFields(colStore, filter)
+		}
+		stats.HotScanned = hotScanned
+		stats.HotMatched = len(hotMatches)
+		stats.ColdScanned = coldScanned
+		stats.ColdMatched = coldMatched
+	}
+	return candidates[:min(len(candidates), limit+1)], nil
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"math"
+	"memory-tools/internal/store"
+)
+
+// QueryPlan describes how a query would be executed. It is returned instead of the results when
+// the query sets "explain". Building it only reads the indexes, so no document is loaded.
+type QueryPlan struct {
+	Path           string   `json:"path"` // The path the query takes, as in QueryStats.
+	IndexesUsed    []string `json:"indexes_used"`
+	FullScan       bool     `json:"full_scan"`       // Every in-memory document is examined.
+	CandidateKeys  int      `json:"candidate_keys"`  // In-memory documents examined: the keys found by the indexes, or the whole collection on a full scan.
+	ResidualFilter bool     `json:"residual_filter"` // Part of the filter is evaluated on the candidate documents.
+	ColdScan       bool     `json:"cold_scan"`       // The collection file is searched for documents held only on disk.
+	Notes          []string `json:"notes,omitempty"`
+}
+
+// explainQuery returns the plan processCollectionQuery would follow for a query, making the same
+// choices in the same order.
+func (h *ConnectionHandler) explainQuery(colStore store.DataStore, query *Query) QueryPlan {
+	plan := QueryPlan{IndexesUsed: []string{}}
+	size := colStore.Size()
+
+	if isCursorQuery(query) {
+		plan.Path = "cursor"
+		field, _ := cursorOrder(query)
+		if !hasColdData(colStore) && colStore.HasIndex(field) {
+			plan.IndexesUsed = []string{field}
+			plan.CandidateKeys = min(*query.Limit+1, size)
+			plan.ResidualFilter = len(query.Filter) > 0
+			plan.Notes = append(plan.Notes, "The page is read by seeking in the index of the order field, which continues past the documents the filter rejects. If some documents have no indexed value for the field, every in-memory document is scanned instead.")
+			return plan
+		}
+		plan.FullScan = true
+		plan.CandidateKeys = size
+		plan.ResidualFilter = len(query.Filter) > 0
+		plan.ColdScan = true
+		return plan
+	}
+
+	if isEstimatedCount(query) {
+		plan.Path = "estimate"
+		sampleRate := query.SampleRate
+		if sampleRate <= 0 {
+			sampleRate = defaultEstimateSampleRate
+		}
+		if size > 0 {
+			sampleRate = math.Max(sampleRate, float64(minEstimateSampleSize)/float64(size))
+		}
+		plan.CandidateKeys = int(math.Ceil(math.Min(sampleRate, 1) * float64(size)))
+		plan.ResidualFilter = len(query.Filter) > 0
+		plan.Notes = append(plan.Notes, "candidate_keys is the expected size of the random sample. Cold documents are not sampled.")
+		return plan
+	}
+
+	candidateKeys, usedIndex, remainingFilter := h.findCandidateKeysFromFilter(colStore, query.Filter)
+
+	if isCountOnlyQuery(query) && usedIndex && len(remainingFilter) == 0 {
+		plan.Path = "index_count"
+		plan.IndexesUsed = indexedFilterFields(colStore, query.Filter)
+		plan.CandidateKeys = len(candidateKeys)
+		plan.ColdScan = hasColdData(colStore)
+		return plan
+	}
+
+	if isSimpleQuery(query) {
+		plan.Path = "simple"
+		plan.FullScan = true
+		plan.CandidateKeys = size
+		if query.Limit != nil {
+			plan.CandidateKeys = min(query.Offset+max(*query.Limit, 0), size)
+			plan.FullScan = plan.CandidateKeys == size
+		}
+		return plan
+	}
+
+	plan.Path = "complex"
+	if usedIndex {
+		plan.IndexesUsed = indexedFilterFields(colStore, query.Filter)
+		plan.CandidateKeys = len(candidateKeys)
+		plan.ResidualFilter = len(remainingFilter) > 0
+	} else {
+		plan.FullScan = true
+		plan.CandidateKeys = size
+		plan.ResidualFilter = len(query.Filter) > 0
+	}
+	// The cold search is skipped once the in-memory matches reach the limit. Without a residual
+	// filter every candidate matches, so that is known in advance.
+	plan.ColdScan = true
+	if query.Limit != nil {
+		if usedIndex && !plan.ResidualFilter {
+			plan.ColdScan = plan.CandidateKeys < *query.Limit
+		} else {
+			plan.Notes = append(plan.Notes, "The cold search is skipped if the in-memory matches reach the limit.")
+		}
+	}
+	return plan
+}
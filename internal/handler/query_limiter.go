@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/store"
+	"sync/atomic"
+)
+
+// queryLimiter bounds the number of queries executing at once. Queries beyond the limit wait
+// for a slot while fewer than maxQueued are already waiting, and are rejected otherwise.
+type queryLimiter struct {
+	slots     chan struct{}
+	waiting   atomic.Int64
+	maxQueued int64
+}
+
+// activeQueryLimiter is nil when the number of concurrent queries is unlimited.
+var activeQueryLimiter atomic.Pointer[queryLimiter]
+
+// SetQueryConcurrencyLimit limits how many queries execute at once. When all slots are taken,
+// up to maxQueued queries wait for one and any further query is rejected as busy.
+// A maxConcurrent of 0 or less removes the limit.
+func SetQueryConcurrencyLimit(maxConcurrent, maxQueued int) {
+	if maxConcurrent <= 0 {
+		activeQueryLimiter.Store(nil)
+		return
+	}
+	activeQueryLimiter.Store(&queryLimiter{
+		slots:     make(chan struct{}, maxConcurrent),
+		maxQueued: int64(max(maxQueued, 0)),
+	})
+}
+
+// acquireQuerySlot reserves an execution slot, waiting in the queue if there is room.
+// It returns false if the query must be rejected; otherwise release must be called when done.
+func acquireQuerySlot() (release func(), ok bool) {
+	limiter := activeQueryLimiter.Load()
+	if limiter == nil {
+		return func() {}, true
+	}
+	// The limiter is captured so a reconfiguration never releases a slot of the wrong limiter.
+	release = func() { <-limiter.slots }
+
+	select {
+	case limiter.slots <- struct{}{}:
+		return release, true
+	default:
+	}
+	if limiter.waiting.Add(1) > limiter.maxQueued {
+		limiter.waiting.Add(-1)
+		return nil, false
+	}
+	limiter.slots <- struct{}{}
+	limiter.waiting.Add(-1)
+	return release, true
+}
+
+// isIndexedPointLookup reports whether a query is a plain equality match on an indexed field.
+// Such queries are resolved through the index and are exempt from the concurrency limit.
+func isIndexedPointLookup(colStore store.DataStore, query *Query) bool {
+	if len(query.Aggregations) > 0 || len(query.GroupBy) > 0 || query.Distinct != "" ||
+		len(query.Lookups) > 0 || len(query.OrderBy) > 0 || query.Count {
+		return false
+	}
+	field, ok := query.Filter["field"].(string)
+	if !ok || len(query.Filter) != 3 {
+		return false
+	}
+	if op, _ := query.Filter["op"].(string); op != globalconst.OpEqual {
+		return false
+	}
+	return colStore.HasIndex(field)
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"io"
+	"memory-tools/internal/protocol"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueryLimiterQueuesThenRejects(t *testing.T) {
+	SetQueryConcurrencyLimit(2, 1)
+	t.Cleanup(func() { SetQueryConcurrencyLimit(0, 0) })
+
+	var held []func()
+	for range 2 {
+		release, ok := acquireQuerySlot()
+		if !ok {
+			t.Fatal("query rejected while slots are free")
+		}
+		held = append(held, release)
+	}
+
+	queued := make(chan func())
+	go func() {
+		release, ok := acquireQuerySlot()
+		if !ok {
+			close(queued)
+			return
+		}
+		queued <- release
+	}()
+	for activeQueryLimiter.Load().waiting.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := acquireQuerySlot(); ok {
+		t.Fatal("query accepted beyond the queue")
+	}
+
+	held[0]()
+	release, ok := <-queued
+	if !ok {
+		t.Fatal("queued query was rejected")
+	}
+	release()
+	held[1]()
+}
+
+func TestConcurrentQueriesStayWithinLimit(t *testing.T) {
+	const limit, queries = 3, 40
+	SetQueryConcurrencyLimit(limit, queries)
+	t.Cleanup(func() { SetQueryConcurrencyLimit(0, 0) })
+
+	var running, peak atomic.Int64
+	var wg sync.WaitGroup
+	for range queries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, ok := acquireQuerySlot()
+			if !ok {
+				t.Error("query rejected although the queue has room for all of them")
+				return
+			}
+			defer release()
+			n := running.Add(1)
+			for p := peak.Load(); n > p; p = peak.Load() {
+				if peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(2 * time.Millisecond)
+			running.Add(-1)
+		}()
+	}
+	wg.Wait()
+	if peak.Load() > limit {
+		t.Fatalf("%d queries ran at once, limit is %d", peak.Load(), limit)
+	}
+}
+
+func TestBusyQueryRejectedButPointLookupExempt(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	env.setItem("items", "a", `{"_id":"a","n":1}`)
+	SetQueryConcurrencyLimit(1, 0)
+	t.Cleanup(func() { SetQueryConcurrencyLimit(0, 0) })
+	release, _ := acquireQuerySlot()
+	defer release()
+
+	query := func(q string) testResponse {
+		return env.run(env.handler().handleCollectionQuery, func(w io.Writer) error {
+			return protocol.WriteCollectionQueryCommand(w, "items", []byte(q))
+		})
+	}
+	resp := query(`{"filter":{"field":"n","op":">","value":0}}`)
+	if resp.status != protocol.StatusError || !strings.HasPrefix(resp.msg, "BUSY") {
+		t.Fatalf("scan while the only slot is taken: status %d %q, want BUSY", resp.status, resp.msg)
+	}
+	expectStatus(t, query(`{"filter":{"field":"_id","op":"=","value":"a"}}`), protocol.StatusOk)
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"sort"
+	"strings"
+	"time"
+)
+
+// validateLookups checks the join type of every lookup and prepares their sub-filters like the
+// main filter: relative dates are resolved and regex patterns compiled.
+func validateLookups(lookups []LookupClause, now time.Time) error {
+	for i := range lookups {
+		lookup := &lookups[i]
+		switch lookup.Type {
+		case "", globalconst.LookupOne, globalconst.LookupMany:
+		default:
+			return fmt.Errorf("lookup '%s': unsupported type '%s', use '%s' or '%s'", lookup.As, lookup.Type, globalconst.LookupOne, globalconst.LookupMany)
+		}
+		if err := resolveRelativeDates(lookup.Filter, now); err != nil {
+			return fmt.Errorf("lookup '%s': %w", lookup.As, err)
+		}
+		if err := compileRegexFilters(lookup.Filter); err != nil {
+			return fmt.Errorf("lookup '%s': %w", lookup.As, err)
+		}
+	}
+	return nil
+}
+
+// applyLookup joins docs with the documents of another collection. The foreign documents of the
+// whole page are fetched with a single "in" query on the foreign field, which is answered from the
+// index when the field is indexed, instead of one query per document.
+func (h *ConnectionHandler) applyLookup(docs []map[string]any, lookup LookupClause) {
+	var values []any
+	seen := make(map[any]struct{})
+	for _, doc := range docs {
+		localValue, ok := getNestedValue(doc, lookup.LocalField)
+		if !ok || !isScalar(localValue) {
+			continue
+		}
+		key := distinctKey(localValue)
+		if _, dup := seen[key]; !dup {
+			seen[key] = struct{}{}
+			values = append(values, localValue)
+		}
+	}
+
+	matches := make(map[any][]map[string]any)
+	failed := false
+	if len(values) > 0 {
+		filter := map[string]any{"field": lookup.ForeignField, "op": globalconst.OpIn, "value": values}
+		if len(lookup.Filter) > 0 {
+			filter = map[string]any{globalconst.OpAnd: []any{filter, lookup.Filter}}
+		}
+		// Joined documents are not capped separately; the cap applies to the final response.
+		joined, _, err := h.processCollectionQuery(lookup.FromCollection, &Query{Filter: filter}, nil, 0)
+		if err != nil {
+			slog.Warn("Lookup sub-query failed", "error", err, "from", lookup.FromCollection)
+			failed = true
+		}
+		joinedDocs, _ := joined.([]map[string]any)
+		sort.Slice(joinedDocs, func(i, j int) bool {
+			idA, _ := joinedDocs[i][globalconst.ID].(string)
+			idB, _ := joinedDocs[j][globalconst.ID].(string)
+			return strings.Compare(idA, idB) < 0
+		})
+		for _, joinedDoc := range joinedDocs {
+			foreignValue, ok := getNestedValue(joinedDoc, lookup.ForeignField)
+			if !ok || !isScalar(foreignValue) {
+				continue
+			}
+			if len(lookup.Projection) > 0 {
+				joinedDoc = projectFields(joinedDoc, lookup.Projection)
+			}
+			key := distinctKey(foreignValue)
+			matches[key] = append(matches[key], joinedDoc)
+		}
+	}
+
+	for _, doc := range docs {
+		var found []map[string]any
+		localValue, ok := getNestedValue(doc, lookup.LocalField)
+		if ok && isScalar(localValue) {
+			found = matches[distinctKey(localValue)]
+		}
+		switch {
+		case lookup.Type == globalconst.LookupMany:
+			if found == nil {
+				found = []map[string]any{}
+			}
+			doc[lookup.As] = found
+		case len(found) == 0 && (lookup.Type == globalconst.LookupOne || !ok || failed):
+			doc[lookup.As] = nil
+		case lookup.Type == globalconst.LookupOne || len(found) == 1:
+			doc[lookup.As] = found[0]
+		default:
+			// Without a type, a single match is attached as an object and anything else as an array.
+			if found == nil {
+				found = []map[string]any{}
+			}
+			doc[lookup.As] = found
+		}
+	}
+}
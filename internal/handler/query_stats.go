@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/store"
+	"sort"
+	"time"
+)
+
+// QueryStats describes how a query was actually executed. It is returned next to the
+// results when the query sets "with_stats".
+type QueryStats struct {
+	Path        string             `json:"path"` // "simple" (streamed, no filter) or "complex"
+	IndexesUsed []string           `json:"indexes_used"`
+	HotScanned  int                `json:"hot_scanned"`
+	HotMatched  int                `json:"hot_matched"`
+	ColdScanned int                `json:"cold_scanned"`
+	ColdMatched int                `json:"cold_matched"`
+	Returned    int                `json:"returned"`
+	PhaseMs     map[string]float64 `json:"phase_ms"` // Wall time per phase: hot_scan, cold_scan, aggregate, sort, lookup, total.
+}
+
+// queryResponseWithStats is the response payload of a JSON query run with "with_stats".
+type queryResponseWithStats struct {
+	Results any         `json:"results"`
+	Stats   *QueryStats `json:"stats"`
+}
+
+func newQueryStats() *QueryStats {
+	return &QueryStats{IndexesUsed: []string{}, PhaseMs: make(map[string]float64)}
+}
+
+// recordPhase adds the time elapsed since start to a phase. It is a no-op on a nil receiver,
+// so the query path can be instrumented unconditionally.
+func (s *QueryStats) recordPhase(phase string, start time.Time) {
+	if s == nil {
+		return
+	}
+	s.PhaseMs[phase] += float64(time.Since(start).Microseconds()) / 1000
+}
+
+// indexedFilterFields lists the indexed fields a filter refers to, which are the indexes the
+// optimizer can have used for it.
+func indexedFilterFields(colStore store.DataStore, filter map[string]any) []string {
+	seen := make(map[string]struct{})
+	var walk func(f map[string]any)
+	walk = func(f map[string]any) {
+		for _, op := range []string{globalconst.OpAnd, globalconst.OpOr} {
+			if conditions, ok := f[op].([]any); ok {
+				for _, cond := range conditions {
+					if condMap, isMap := cond.(map[string]any); isMap {
+						walk(condMap)
+					}
+				}
+			}
+		}
+		if field, ok := f["field"].(string); ok && colStore.HasIndex(field) {
+			seen[field] = struct{}{}
+		}
+	}
+	walk(filter)
+
+	fields := make([]string, 0, len(seen))
+	for field := range seen {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
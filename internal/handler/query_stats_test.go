@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/protocol"
+	"reflect"
+	"testing"
+
+	stdjson "encoding/json"
+)
+
+// queryStats runs a query with "with_stats" and returns the number of results and the stats.
+func (e *testEnv) queryStats(collectionName, query string) (int, QueryStats) {
+	e.t.Helper()
+	resp := e.run(e.handler().handleCollectionQuery, func(w io.Writer) error {
+		return protocol.WriteCollectionQueryCommand(w, collectionName, []byte(query))
+	})
+	expectStatus(e.t, resp, protocol.StatusOk)
+	var payload struct {
+		Results []stdjson.RawMessage `json:"results"`
+		Stats   QueryStats           `json:"stats"`
+	}
+	if err := json.Unmarshal(resp.data, &payload); err != nil {
+		e.t.Fatalf("query response %q: %v", resp.data, err)
+	}
+	return len(payload.Results), payload.Stats
+}
+
+func TestQueryStatsCountScannedAndMatchedDocuments(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	for i := range 10 {
+		env.setItem("items", fmt.Sprintf("h%d", i), fmt.Sprintf(`{"n":%d}`, i))
+	}
+	cold := make(map[string][]byte)
+	for _, n := range []int{2, 3, 20, 21} {
+		key := fmt.Sprintf("c%d", n)
+		cold[key] = fmt.Appendf(nil, `{"_id":%q,"n":%d}`, key, n)
+	}
+	if err := (&persistence.CollectionPersisterImpl{}).WriteColdItems("items", cold); err != nil {
+		t.Fatalf("writing cold items: %v", err)
+	}
+
+	returned, stats := env.queryStats("items", `{"filter":{"field":"n","op":">=","value":5},"with_stats":true}`)
+	want := QueryStats{Path: "complex", IndexesUsed: []string{}, HotScanned: 10, HotMatched: 5, ColdScanned: 4, ColdMatched: 2, Returned: 7}
+	phases := stats.PhaseMs
+	stats.PhaseMs = nil
+	if !reflect.DeepEqual(stats, want) || returned != 7 {
+		t.Fatalf("stats = %+v with %d results, want %+v with 7", stats, returned, want)
+	}
+	for _, phase := range []string{"hot_scan", "cold_scan", "total"} {
+		if _, ok := phases[phase]; !ok {
+			t.Errorf("phase %q missing from %v", phase, phases)
+		}
+	}
+
+	// The cold scan is skipped once the hot matches fill the limit.
+	returned, stats = env.queryStats("items", `{"filter":{"field":"n","op":">=","value":5},"limit":3,"with_stats":true}`)
+	if stats.HotMatched != 5 || stats.ColdScanned != 0 || stats.Returned != 3 || returned != 3 {
+		t.Errorf("limited query stats = %+v with %d results", stats, returned)
+	}
+
+	expectStatus(t, env.run(env.handler().HandleCollectionIndexCreate, func(w io.Writer) error {
+		return protocol.WriteCollectionIndexCreateCommand(w, "items", "n")
+	}), protocol.StatusOk)
+	_, stats = env.queryStats("items", `{"filter":{"field":"n","op":">=","value":5},"with_stats":true}`)
+	if !reflect.DeepEqual(stats.IndexesUsed, []string{"n"}) || stats.HotScanned != 5 || stats.HotMatched != 5 {
+		t.Errorf("indexed query stats = %+v, want the n index used and only its 5 candidates scanned", stats)
+	}
+
+	resp := env.run(env.handler().handleCollectionQuery, func(w io.Writer) error {
+		return protocol.WriteCollectionQueryCommand(w, "items", []byte(`{"with_stats":true,"format":"csv"}`))
+	})
+	expectStatus(t, resp, protocol.StatusBadRequest)
+}
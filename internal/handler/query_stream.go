@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/protocol"
+	"net"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	// queryStreamChunkSize is the amount of row data buffered before it is sent as one
+	// StatusPartial response of a streaming query.
+	queryStreamChunkSize = 256 * 1024
+	// queryStreamBatchSize is the number of in-memory documents fetched at a time by the simple
+	// streaming path, so no shard lock is held while writing to the network.
+	queryStreamBatchSize = 500
+)
+
+// validateStreamQuery checks that a query with "stream" returns documents, the only results
+// that can be sent row by row.
+func validateStreamQuery(query *Query) error {
+	if query.Count || len(query.Aggregations) > 0 || len(query.GroupBy) > 0 || query.Distinct != "" {
+		return errors.New("streaming cannot be combined with count, aggregations, group_by or distinct")
+	}
+	if isCursorQuery(query) {
+		return errors.New("streaming cannot be combined with cursor pagination")
+	}
+	if query.Format == globalconst.FormatCSV {
+		return errors.New("streaming is only available with the 'json' format")
+	}
+	if query.WithStats {
+		return errors.New("streaming cannot be combined with with_stats")
+	}
+	return nil
+}
+
+// queryRowWriter sends the documents of a streaming query to the client. Every row is a
+// length-prefixed JSON document, written like protocol.WriteBytes; rows are gathered into
+// StatusPartial responses of about queryStreamChunkSize bytes.
+type queryRowWriter struct {
+	conn       net.Conn
+	sortedKeys bool
+	buf        bytes.Buffer
+	count      int
+	err        error
+}
+
+// writeRaw sends a document stored as JSON.
+func (w *queryRowWriter) writeRaw(row []byte) {
+	if w.err != nil {
+		return
+	}
+	if w.sortedKeys {
+		sorted, err := marshalSortedKeys(jsoniter.RawMessage(row))
+		if err != nil {
+			return
+		}
+		row = sorted
+	}
+	protocol.WriteBytes(&w.buf, row)
+	w.count++
+	if w.buf.Len() >= queryStreamChunkSize {
+		w.err = protocol.WriteResponse(w.conn, protocol.StatusPartial, "", w.buf.Bytes())
+		w.buf.Reset()
+	}
+}
+
+// write sends a decoded document.
+func (w *queryRowWriter) write(doc map[string]any) {
+	row, err := jsoniter.Marshal(doc)
+	if err != nil {
+		return
+	}
+	w.writeRaw(row)
+}
+
+// streamCollectionQuery runs a query with "stream" and sends its documents as they are produced
+// instead of marshalling the whole result set into one response. The rows arrive in StatusPartial
+// responses followed by a final response that carries the last rows and ends the stream. The
+// response byte cap does not apply, as the results are never held in a single buffer.
+func (h *ConnectionHandler) streamCollectionQuery(conn net.Conn, collectionName string, query *Query) {
+	rows := &queryRowWriter{conn: conn, sortedKeys: query.SortedKeys}
+
+	if isSimpleQuery(query) {
+		// Only keys are collected up front; documents are fetched and sent a batch at a time.
+		colStore := h.CollectionManager.GetCollection(collectionName)
+		keys := colStore.Keys()
+		keys = keys[min(max(query.Offset, 0), len(keys)):]
+		if query.Limit != nil && *query.Limit >= 0 && *query.Limit < len(keys) {
+			keys = keys[:*query.Limit]
+		}
+		for start := 0; start < len(keys) && rows.err == nil; start += queryStreamBatchSize {
+			batch := keys[start:min(start+queryStreamBatchSize, len(keys))]
+			for _, value := range colStore.GetMany(batch) {
+				rows.writeRaw(value)
+			}
+		}
+	} else {
+		results, _, err := h.processCollectionQuery(collectionName, query, nil, 0)
+		if err != nil {
+			slog.Error("Error processing streaming collection query", "user", h.AuthenticatedUser, "collection", collectionName, "error", err)
+			protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("Failed to execute query: %v", err), nil)
+			return
+		}
+		docs, _ := results.([]map[string]any)
+		for _, doc := range docs {
+			if rows.err != nil {
+				break
+			}
+			rows.write(doc)
+		}
+	}
+
+	if rows.err != nil {
+		slog.Warn("Streaming query aborted", "collection", collectionName, "sent", rows.count, "error", rows.err)
+		return
+	}
+	slog.Info("Streaming query finished", "collection", collectionName, "results_count", rows.count)
+	if err := protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Query streamed %d documents from collection '%s'", rows.count, collectionName), rows.buf.Bytes()); err != nil {
+		slog.Error("Failed to write COLLECTION_QUERY response", "error", err, "remote_addr", conn.RemoteAddr().String())
+	}
+}
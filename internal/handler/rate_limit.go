@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"math"
+	"memory-tools/internal/protocol"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// rateLimiter holds one token bucket per user, or per user and collection, shared by every
+// connection so opening more connections does not raise a user's limit. A bucket refills at
+// rate tokens per second up to burst, and every command takes one token.
+type rateLimiter struct {
+	rate          float64
+	burst         float64
+	perCollection bool
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+// tokenBucket is the state of one bucket as of its last command.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// activeRateLimiter is nil when commands are not rate limited.
+var activeRateLimiter atomic.Pointer[rateLimiter]
+
+// SetRateLimit limits every user but root to perSecond commands per second, with bursts of up
+// to burst commands; a burst of 0 uses perSecond rounded up. With perCollection, commands on a
+// collection are counted per user and collection, so flooding one collection does not block
+// the user's work on the others. A perSecond of 0 or less removes the limit.
+func SetRateLimit(perSecond float64, burst int, perCollection bool) {
+	if perSecond <= 0 {
+		activeRateLimiter.Store(nil)
+		return
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(perSecond))
+	}
+	activeRateLimiter.Store(&rateLimiter{
+		rate:          perSecond,
+		burst:         float64(burst),
+		perCollection: perCollection,
+		buckets:       make(map[string]*tokenBucket),
+	})
+}
+
+// allow takes a token from the bucket of key and reports whether one was available.
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+
+	bucket, found := l.buckets[key]
+	if !found {
+		bucket = &tokenBucket{tokens: l.burst}
+		l.buckets[key] = bucket
+	} else {
+		bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	}
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// prune drops, at most once a minute, the buckets that have refilled completely, as they are
+// no different from a new one. It must be called with mu held.
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimitCommand checks a command against the rate limit before it runs. With the limit per
+// collection, the payload of a command on a collection is read first to find the collection, and
+// returned so the command is decoded from it. A throttled command is answered with
+// StatusThrottled and its payload discarded; allowed is then false, and keepOpen is false if the
+// connection cannot go on, as happens for commands whose payload has no fixed layout.
+func (h *ConnectionHandler) rateLimitCommand(conn net.Conn, cmdType protocol.CommandType) (payloadBuf *bytes.Buffer, allowed, keepOpen bool) {
+	limiter := activeRateLimiter.Load()
+	if limiter == nil || !h.IsAuthenticated || h.IsRoot {
+		return nil, true, true
+	}
+
+	key := h.AuthenticatedUser
+	if limiter.perCollection && isCollectionCommand(cmdType) {
+		payloadBuf = protocol.AcquirePayloadBuffer()
+		if err := protocol.ReadCommandPayloadInto(conn, cmdType, payloadBuf); err != nil {
+			protocol.ReleasePayloadBuffer(payloadBuf)
+			slog.Error("Failed to read command payload for rate limiting", "error", err, "command_type", cmdType)
+			return nil, false, false
+		}
+		if collectionName, err := protocol.ReadString(bytes.NewReader(payloadBuf.Bytes())); err == nil {
+			key += "\x00" + collectionName
+		}
+	}
+	if limiter.allow(key, time.Now()) {
+		return payloadBuf, true, true
+	}
+
+	keepOpen = true
+	if payloadBuf != nil {
+		protocol.ReleasePayloadBuffer(payloadBuf)
+	} else if protocol.HasFixedPayload(cmdType) {
+		discardBuf := protocol.AcquirePayloadBuffer()
+		err := protocol.ReadCommandPayloadInto(conn, cmdType, discardBuf)
+		protocol.ReleasePayloadBuffer(discardBuf)
+		if err != nil {
+			slog.Error("Failed to read payload of throttled command", "error", err, "command_type", cmdType)
+			return nil, false, false
+		}
+	} else {
+		keepOpen = false
+	}
+	slog.Warn("Command throttled: rate limit exceeded", "user", h.AuthenticatedUser, "remote_addr", conn.RemoteAddr().String(), "command_type", cmdType)
+	protocol.WriteResponse(conn, protocol.StatusThrottled, fmt.Sprintf("THROTTLED: Rate limit of %g commands per second exceeded. Please retry later.", limiter.rate), nil)
+	return nil, false, keepOpen
+}
+
+// isCollectionCommand reports whether the payload of a command starts with the name of the
+// collection it works on.
+func isCollectionCommand(cmdType protocol.CommandType) bool {
+	switch cmdType {
+	case protocol.CmdCollectionCreate,
+		protocol.CmdCollectionDelete,
+		protocol.CmdCollectionReload,
+		protocol.CmdCollectionStats,
+		protocol.CmdCollectionSetCompression,
+		protocol.CmdCollectionSetFileCompression,
+		protocol.CmdCollectionRename,
+		protocol.CmdCollectionCopy,
+		protocol.CmdCollectionTruncate,
+		protocol.CmdCollectionSetSchema,
+		protocol.CmdCollectionSetDefaultTTL,
+		protocol.CmdSubscribe,
+		protocol.CmdCollectionIndexCreate,
+		protocol.CmdCollectionIndexCreateWithOptions,
+		protocol.CmdCollectionIndexDelete,
+		protocol.CmdCollectionIndexList,
+		protocol.CmdCollectionIndexDisable,
+		protocol.CmdCollectionIndexEnable,
+		protocol.CmdCollectionIndexAudit,
+		protocol.CmdCollectionItemSet,
+		protocol.CmdCollectionItemSetMany,
+		protocol.CmdCollectionItemGet,
+		protocol.CmdCollectionItemGetMany,
+		protocol.CmdCollectionItemExists,
+		protocol.CmdCollectionItemTTL,
+		protocol.CmdCollectionItemTouch,
+		protocol.CmdCollectionItemDelete,
+		protocol.CmdCollectionItemDeleteMany,
+		protocol.CmdCollectionItemGetAndDelete,
+		protocol.CmdCollectionItemList,
+		protocol.CmdCollectionItemUpdate,
+		protocol.CmdCollectionItemUpdateMany,
+		protocol.CmdCollectionItemUpdateIf,
+		protocol.CmdCollectionItemUpsert,
+		protocol.CmdCollectionItemReplace,
+		protocol.CmdCollectionItemMergeByQuery,
+		protocol.CmdCollectionItemIncrement,
+		protocol.CmdCollectionItemDiff,
+		protocol.CmdCollectionQuery,
+		protocol.CmdCollectionKeyScan,
+		protocol.CmdCollectionExport,
+		protocol.CmdCollectionTopLargest:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"fmt"
+	"memory-tools/internal/globalconst"
+	"regexp"
+)
+
+// compileRegexFilters compiles the pattern of every regex condition in a filter and stores the
+// compiled expression in place of the pattern, so matchFilter does not compile it again for
+// every document. It fails on the first pattern that is not a valid regular expression.
+func compileRegexFilters(filter map[string]any) error {
+	if len(filter) == 0 {
+		return nil
+	}
+	for _, logicalOp := range []string{globalconst.OpAnd, globalconst.OpOr} {
+		if conditions, ok := filter[logicalOp].([]any); ok {
+			for _, cond := range conditions {
+				if condMap, isMap := cond.(map[string]any); isMap {
+					if err := compileRegexFilters(condMap); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	if notCondition, ok := filter[globalconst.OpNot].(map[string]any); ok {
+		if err := compileRegexFilters(notCondition); err != nil {
+			return err
+		}
+	}
+
+	if op, _ := filter["op"].(string); op != globalconst.OpRegex {
+		return nil
+	}
+	re, err := filterRegexp(filter["value"])
+	if err != nil {
+		return err
+	}
+	filter["value"] = re
+	return nil
+}
+
+// filterRegexp returns the regular expression of a regex condition's value, compiling it if
+// compileRegexFilters has not already done so.
+func filterRegexp(value any) (*regexp.Regexp, error) {
+	switch v := value.(type) {
+	case *regexp.Regexp:
+		return v, nil
+	case string:
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex pattern '%s': %w", v, err)
+		}
+		return re, nil
+	default:
+		return nil, fmt.Errorf("the value of a '%s' condition must be a pattern string", globalconst.OpRegex)
+	}
+}
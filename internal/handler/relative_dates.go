@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"fmt"
+	"memory-tools/internal/globalconst"
+	"strconv"
+	"time"
+)
+
+// resolveRelativeDates replaces every {"$now": "<offset>"} filter value with the RFC3339
+// timestamp it denotes, so matchFilter and the index optimizer only see plain strings.
+// Offsets are a signed sequence of <number><unit> with units d, h, and m (e.g. "-7d",
+// "+1d12h", "-30m"); an empty offset means the current time.
+func resolveRelativeDates(filter map[string]any, now time.Time) error {
+	if len(filter) == 0 {
+		return nil
+	}
+	for _, logicalOp := range []string{globalconst.OpAnd, globalconst.OpOr} {
+		if conditions, ok := filter[logicalOp].([]any); ok {
+			for _, cond := range conditions {
+				if condMap, isMap := cond.(map[string]any); isMap {
+					if err := resolveRelativeDates(condMap, now); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	if notCondition, ok := filter[globalconst.OpNot].(map[string]any); ok {
+		if err := resolveRelativeDates(notCondition, now); err != nil {
+			return err
+		}
+	}
+
+	switch value := filter["value"].(type) {
+	case map[string]any:
+		resolved, isRelative, err := resolveRelativeValue(value, now)
+		if err != nil {
+			return err
+		}
+		if isRelative {
+			filter["value"] = resolved
+		}
+	case []any:
+		for i, element := range value {
+			elementMap, isMap := element.(map[string]any)
+			if !isMap {
+				continue
+			}
+			resolved, isRelative, err := resolveRelativeValue(elementMap, now)
+			if err != nil {
+				return err
+			}
+			if isRelative {
+				value[i] = resolved
+			}
+		}
+	}
+	return nil
+}
+
+// resolveRelativeValue resolves a single {"$now": "<offset>"} object.
+func resolveRelativeValue(value map[string]any, now time.Time) (string, bool, error) {
+	raw, ok := value[globalconst.RelativeNow]
+	if !ok || len(value) != 1 {
+		return "", false, nil
+	}
+	offsetStr, ok := raw.(string)
+	if !ok {
+		return "", false, fmt.Errorf("%s offset must be a string such as \"-7d\"", globalconst.RelativeNow)
+	}
+	offset, err := parseRelativeOffset(offsetStr)
+	if err != nil {
+		return "", false, err
+	}
+	return now.Add(offset).UTC().Format(time.RFC3339), true, nil
+}
+
+// parseRelativeOffset parses offsets such as "-7d", "+2h", or "-1d12h30m".
+func parseRelativeOffset(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	sign := time.Duration(1)
+	rest := s
+	switch rest[0] {
+	case '-':
+		sign, rest = -1, rest[1:]
+	case '+':
+		rest = rest[1:]
+	}
+	if rest == "" {
+		return 0, fmt.Errorf("invalid %s offset '%s'", globalconst.RelativeNow, s)
+	}
+
+	var total time.Duration
+	for rest != "" {
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+		if i == 0 || i == len(rest) {
+			return 0, fmt.Errorf("invalid %s offset '%s': expected <number><unit> with unit d, h, or m", globalconst.RelativeNow, s)
+		}
+		n, err := strconv.Atoi(rest[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s offset '%s': %w", globalconst.RelativeNow, s, err)
+		}
+		var unit time.Duration
+		switch rest[i] {
+		case 'd':
+			unit = 24 * time.Hour
+		case 'h':
+			unit = time.Hour
+		case 'm':
+			unit = time.Minute
+		default:
+			return 0, fmt.Errorf("invalid %s offset '%s': unknown unit '%c', use d, h, or m", globalconst.RelativeNow, s, rest[i])
+		}
+		total += time.Duration(n) * unit
+		rest = rest[i+1:]
+	}
+	return sign * total, nil
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"io"
+	"memory-tools/internal/protocol"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestResolveRelativeDates(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 30, 0, 0, time.UTC)
+	var filter map[string]any
+	raw := `{"and":[{"field":"created_at","op":">","value":{"$now":"-7d"}},{"not":{"field":"seen","op":"between","value":[{"$now":"-1d12h"},{"$now":""}]}},{"field":"tag","op":"=","value":{"other":"x"}}]}`
+	if err := json.Unmarshal([]byte(raw), &filter); err != nil {
+		t.Fatal(err)
+	}
+	if err := resolveRelativeDates(filter, now); err != nil {
+		t.Fatal(err)
+	}
+
+	conditions := filter["and"].([]any)
+	if got := conditions[0].(map[string]any)["value"]; got != "2024-03-03T12:30:00Z" {
+		t.Errorf("-7d = %v, want 2024-03-03T12:30:00Z", got)
+	}
+	between := conditions[1].(map[string]any)["not"].(map[string]any)["value"].([]any)
+	if between[0] != "2024-03-09T00:30:00Z" || between[1] != "2024-03-10T12:30:00Z" {
+		t.Errorf("between = %v, want [2024-03-09T00:30:00Z 2024-03-10T12:30:00Z]", between)
+	}
+	if _, untouched := conditions[2].(map[string]any)["value"].(map[string]any); !untouched {
+		t.Error("an object value without $now was replaced")
+	}
+}
+
+func TestParseRelativeOffset(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"":         0,
+		"-7d":      -7 * 24 * time.Hour,
+		"+2h":      2 * time.Hour,
+		"30m":      30 * time.Minute,
+		"-1d12h3m": -(36*time.Hour + 3*time.Minute),
+	} {
+		if got, err := parseRelativeOffset(s); err != nil || got != want {
+			t.Errorf("parseRelativeOffset(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"-", "7", "d", "-7w", "1.5h", "7d-"} {
+		if _, err := parseRelativeOffset(s); err == nil {
+			t.Errorf("parseRelativeOffset(%q): want an error", s)
+		}
+	}
+}
+
+func TestRelativeDateFiltersStringDates(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("events")
+	now := time.Now().UTC()
+	for key, age := range map[string]time.Duration{"today": time.Hour, "lastweek": 6 * 24 * time.Hour, "old": 8 * 24 * time.Hour, "ancient": 400 * 24 * time.Hour} {
+		env.setItem("events", key, `{"_id":"`+key+`","happened_at":"`+now.Add(-age).Format(time.RFC3339)+`"}`)
+	}
+
+	got := env.queryKeys("events", `{"filter":{"field":"happened_at","op":">","value":{"$now":"-7d"}}}`)
+	if want := []string{"lastweek", "today"}; !slices.Equal(got, want) {
+		t.Errorf("newer than 7 days = %v, want %v", got, want)
+	}
+	got = env.queryKeys("events", `{"filter":{"field":"happened_at","op":"between","value":[{"$now":"-30d"},{"$now":"-2d"}]}}`)
+	if want := []string{"lastweek", "old"}; !slices.Equal(got, want) {
+		t.Errorf("between 30 and 2 days ago = %v, want %v", got, want)
+	}
+
+	resp := env.run(env.handler().handleCollectionQuery, func(w io.Writer) error {
+		return protocol.WriteCollectionQueryCommand(w, "events", []byte(`{"filter":{"field":"happened_at","op":">","value":{"$now":"-7w"}}}`))
+	})
+	expectStatus(t, resp, protocol.StatusBadRequest)
+}
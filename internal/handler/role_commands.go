@@ -0,0 +1,282 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/protocol"
+	"net"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// roleVersion changes whenever a role is created, updated or deleted, so connections notice on
+// their next permission check that their cached effective permissions are stale.
+var roleVersion atomic.Uint64
+
+// grantPermissions sets the permissions and roles of the user that just logged in and drops
+// the effective permissions cached for a previous login on the connection.
+func (h *ConnectionHandler) grantPermissions(permissions map[string]string, roles []string) {
+	h.Permissions = permissions
+	h.Roles = roles
+	h.effectivePermissions = nil
+}
+
+// resolvedPermissions returns the user's direct permissions merged with those of its roles.
+// Where both grant access to the same collection, their levels are combined. Roles that no
+// longer exist are ignored. The result is cached until a role changes.
+func (h *ConnectionHandler) resolvedPermissions() map[string]permissionSet {
+	version := roleVersion.Load()
+	if h.effectivePermissions != nil && (len(h.Roles) == 0 || h.effectiveRoleVersion == version) {
+		return h.effectivePermissions
+	}
+
+	merged := make(map[string]permissionSet, len(h.Permissions))
+	for collectionName, level := range h.Permissions {
+		merged[collectionName] |= levelSet(level)
+	}
+	sysCol := h.CollectionManager.GetCollection(globalconst.SystemCollectionName)
+	for _, roleName := range h.Roles {
+		roleData, found := sysCol.Get(globalconst.RolePrefix + roleName)
+		if !found {
+			continue
+		}
+		var role RoleInfo
+		if err := json.Unmarshal(roleData, &role); err != nil {
+			slog.Warn("Skipping unreadable role", "role", roleName, "error", err)
+			continue
+		}
+		for collectionName, level := range role.Permissions {
+			merged[collectionName] |= levelSet(level)
+		}
+	}
+	h.effectivePermissions = merged
+	h.effectiveRoleVersion = version
+	return merged
+}
+
+// validatePermissions checks that every permission level is one of "metadata", "read",
+// "admin" or "write".
+func validatePermissions(permissions map[string]string) error {
+	for collectionName, level := range permissions {
+		if levelSet(level) == 0 {
+			return fmt.Errorf("invalid permission '%s' for '%s': use '%s', '%s', '%s' or '%s'", level, collectionName,
+				globalconst.PermissionMetadata, globalconst.PermissionRead, globalconst.PermissionAdmin, globalconst.PermissionWrite)
+		}
+	}
+	return nil
+}
+
+// HandleRoleCreate processes the CmdRoleCreate command. It is a write operation.
+func (h *ConnectionHandler) HandleRoleCreate(r io.Reader, conn net.Conn) {
+	h.handleRoleWrite(r, conn, true)
+}
+
+// HandleRoleUpdate processes the CmdRoleUpdate command. It is a write operation.
+// Connected users with the role get its new permissions from their next command.
+func (h *ConnectionHandler) HandleRoleUpdate(r io.Reader, conn net.Conn) {
+	h.handleRoleWrite(r, conn, false)
+}
+
+// handleRoleWrite stores a role for ROLE_CREATE, which requires it to be new, and ROLE_UPDATE,
+// which requires it to exist.
+func (h *ConnectionHandler) handleRoleWrite(r io.Reader, conn net.Conn, create bool) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+	commandName, readCommand := "ROLE_UPDATE", protocol.ReadRoleUpdateCommand
+	if create {
+		commandName, readCommand = "ROLE_CREATE", protocol.ReadRoleCreateCommand
+	}
+
+	// Authorization is skipped during WAL recovery (conn is nil)
+	if conn != nil && !h.hasPermission(globalconst.SystemCollectionName, globalconst.PermissionWrite) {
+		slog.Warn("Unauthorized role change attempt", "user", h.AuthenticatedUser, "remote_addr", remoteAddr, "command", commandName)
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: You do not have permission to manage roles.", nil)
+		return
+	}
+
+	roleName, permissionsJSON, err := readCommand(r)
+	if err != nil {
+		slog.Error("Failed to read "+commandName+" command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, fmt.Sprintf("Invalid %s command format", commandName), nil)
+		}
+		return
+	}
+	if roleName == "" {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Role name cannot be empty", nil)
+		}
+		return
+	}
+	var permissions map[string]string
+	if err := json.Unmarshal(permissionsJSON, &permissions); err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Invalid permissions JSON format", nil)
+		}
+		return
+	}
+	if err := validatePermissions(permissions); err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, err.Error(), nil)
+		}
+		return
+	}
+
+	sysCol := h.CollectionManager.GetCollection(globalconst.SystemCollectionName)
+	roleKey := globalconst.RolePrefix + roleName
+	if _, found := sysCol.Get(roleKey); found && create {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("Role '%s' already exists", roleName), nil)
+		}
+		return
+	} else if !found && !create {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("Role '%s' not found", roleName), nil)
+		}
+		return
+	}
+
+	roleBytes, _ := json.Marshal(RoleInfo{Name: roleName, Permissions: permissions})
+	sysCol.Set(roleKey, roleBytes, 0)
+	h.CollectionManager.EnqueueSaveTask(globalconst.SystemCollectionName, sysCol)
+	roleVersion.Add(1)
+
+	verb := "updated"
+	if create {
+		verb = "created"
+	}
+	slog.Info("Role "+verb, "admin_user", h.AuthenticatedUser, "role", roleName)
+	if conn != nil {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("Role '%s' %s successfully", roleName, verb), nil)
+	}
+}
+
+// HandleRoleDelete processes the CmdRoleDelete command. It is a write operation.
+// The role is also removed from every user that had it.
+func (h *ConnectionHandler) HandleRoleDelete(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	// Authorization is skipped during WAL recovery (conn is nil)
+	if conn != nil && !h.hasPermission(globalconst.SystemCollectionName, globalconst.PermissionWrite) {
+		slog.Warn("Unauthorized role delete attempt", "user", h.AuthenticatedUser, "remote_addr", remoteAddr)
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: You do not have permission to manage roles.", nil)
+		return
+	}
+
+	roleName, err := protocol.ReadRoleDeleteCommand(r)
+	if err != nil {
+		slog.Error("Failed to read ROLE_DELETE command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid ROLE_DELETE command format", nil)
+		}
+		return
+	}
+
+	sysCol := h.CollectionManager.GetCollection(globalconst.SystemCollectionName)
+	roleKey := globalconst.RolePrefix + roleName
+	if _, found := sysCol.Get(roleKey); !found {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("Role '%s' not found", roleName), nil)
+		}
+		return
+	}
+	sysCol.Delete(roleKey)
+
+	// Users keep no dangling reference that a new role with the same name would silently revive.
+	usersUpdated := 0
+	for key, value := range sysCol.GetAll() {
+		if !strings.HasPrefix(key, globalconst.UserPrefix) {
+			continue
+		}
+		var userInfo UserInfo
+		if err := json.Unmarshal(value, &userInfo); err != nil || !slices.Contains(userInfo.Roles, roleName) {
+			continue
+		}
+		userInfo.Roles = slices.DeleteFunc(userInfo.Roles, func(role string) bool { return role == roleName })
+		userBytes, _ := json.Marshal(userInfo)
+		sysCol.Set(key, userBytes, 0)
+		usersUpdated++
+	}
+	h.CollectionManager.EnqueueSaveTask(globalconst.SystemCollectionName, sysCol)
+	roleVersion.Add(1)
+
+	slog.Info("Role deleted", "admin_user", h.AuthenticatedUser, "role", roleName, "users_updated", usersUpdated)
+	if conn != nil {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("Role '%s' deleted successfully", roleName), nil)
+	}
+}
+
+// HandleUserSetRoles processes the CmdUserSetRoles command. It is a write operation.
+// The user's role list is replaced; sessions already open keep the roles they logged in with.
+func (h *ConnectionHandler) HandleUserSetRoles(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	// Authorization is skipped during WAL recovery (conn is nil)
+	if conn != nil && !h.hasPermission(globalconst.SystemCollectionName, globalconst.PermissionWrite) {
+		slog.Warn("Unauthorized user role assignment attempt", "user", h.AuthenticatedUser, "remote_addr", remoteAddr)
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: You do not have permission to update users.", nil)
+		return
+	}
+
+	username, roles, err := protocol.ReadUserSetRolesCommand(r)
+	if err != nil {
+		slog.Error("Failed to read USER_SET_ROLES command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid USER_SET_ROLES command format", nil)
+		}
+		return
+	}
+
+	sysCol := h.CollectionManager.GetCollection(globalconst.SystemCollectionName)
+	userKey := globalconst.UserPrefix + username
+	userData, found := sysCol.Get(userKey)
+	if !found {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("User '%s' not found", username), nil)
+		}
+		return
+	}
+	var userInfo UserInfo
+	json.Unmarshal(userData, &userInfo)
+	if userInfo.IsRoot {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, "Cannot assign roles to the root user.", nil)
+		}
+		return
+	}
+
+	slices.Sort(roles)
+	roles = slices.Compact(roles)
+	for _, roleName := range roles {
+		if _, found := sysCol.Get(globalconst.RolePrefix + roleName); !found {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("Role '%s' not found", roleName), nil)
+			}
+			return
+		}
+	}
+
+	userInfo.Roles = roles
+	if len(roles) == 0 {
+		userInfo.Roles = nil
+	}
+	userBytes, _ := json.Marshal(userInfo)
+	sysCol.Set(userKey, userBytes, 0)
+	h.CollectionManager.EnqueueSaveTask(globalconst.SystemCollectionName, sysCol)
+
+	slog.Info("User roles updated", "admin_user", h.AuthenticatedUser, "target_user", username, "roles", roles)
+	if conn != nil {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("Roles for user '%s' set to %v", username, roles), nil)
+	}
+}
@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"net"
+	"sync"
+)
+
+// schemaCache maps the JSON of the schemas in use to their parsed form, so a write does not
+// parse its collection's schema again.
+var schemaCache sync.Map
+
+// collectionSchema returns the schema of a collection, or nil if it has none.
+func (h *ConnectionHandler) collectionSchema(collectionName string) *store.CollectionSchema {
+	raw := h.CollectionManager.GetCollectionMeta(collectionName).Schema
+	if raw == "" {
+		return nil
+	}
+	if schema, ok := schemaCache.Load(raw); ok {
+		return schema.(*store.CollectionSchema)
+	}
+	schema, err := store.ParseCollectionSchema([]byte(raw))
+	if err != nil {
+		slog.Warn("Ignoring invalid collection schema", "collection", collectionName, "error", err)
+		return nil
+	}
+	schemaCache.Store(raw, schema)
+	return schema
+}
+
+// validateDocuments checks documents written to a collection against its schema: whole
+// documents, or only the fields they set if they are patches. If any document breaks the schema it
+// answers StatusBadRequest with every violation found as the response data, and returns false.
+// Writes replayed from the WAL (conn is nil) always pass: only writes that passed when they were
+// made are logged, and a schema set since must not break recovery.
+func (h *ConnectionHandler) validateDocuments(conn net.Conn, collectionName string, patches bool, docs ...map[string]any) bool {
+	if conn == nil {
+		return true
+	}
+	schema := h.collectionSchema(collectionName)
+	if schema == nil {
+		return true
+	}
+	var violations []schemaViolation
+	for i, doc := range docs {
+		var err error
+		if patches {
+			err = schema.ValidatePatch(doc)
+		} else {
+			err = schema.Validate(doc)
+		}
+		var schemaErr *store.SchemaError
+		if !errors.As(err, &schemaErr) {
+			continue
+		}
+		for _, violation := range schemaErr.Violations {
+			v := schemaViolation{SchemaViolation: violation}
+			if len(docs) > 1 {
+				v.Document = &i
+			}
+			violations = append(violations, v)
+		}
+	}
+	if len(violations) == 0 {
+		return true
+	}
+
+	slog.Debug("Write rejected by collection schema", "user", h.AuthenticatedUser, "collection", collectionName, "violations", len(violations))
+	msg := fmt.Sprintf("Schema validation failed for collection '%s': %s", collectionName, violations[0].Message)
+	if len(violations) > 1 {
+		msg = fmt.Sprintf("%s (and %d more violations)", msg, len(violations)-1)
+	}
+	if len(docs) > 1 {
+		msg += ". No document was written."
+	}
+	data, err := json.Marshal(violations)
+	if err != nil {
+		data = nil
+	}
+	protocol.WriteResponse(conn, protocol.StatusBadRequest, msg, data)
+	return false
+}
+
+// schemaViolation is a violation as reported to the client. Document is the position of the
+// document that breaks the schema, set only for writes of several documents.
+type schemaViolation struct {
+	store.SchemaViolation
+	Document *int `json:"document,omitempty"`
+}
+
+// validatePatchValue is validateDocuments for a single patch still in its JSON form. A patch that
+// is not a JSON object is left for the caller to reject.
+func (h *ConnectionHandler) validatePatchValue(conn net.Conn, collectionName string, patchValue []byte) bool {
+	if conn == nil || h.collectionSchema(collectionName) == nil {
+		return true
+	}
+	var patch map[string]any
+	if err := json.Unmarshal(patchValue, &patch); err != nil {
+		return true
+	}
+	return h.validateDocuments(conn, collectionName, true, patch)
+}
+
+// HandleCollectionSetSchema processes the CmdCollectionSetSchema command. It is a write operation.
+// The schema is kept in the collection's settings; an empty schema turns validation off.
+// Documents already stored are not checked against a new schema.
+func (h *ConnectionHandler) HandleCollectionSetSchema(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	collectionName, rawSchema, err := protocol.ReadCollectionSetSchemaCommand(r)
+	if err != nil {
+		slog.Error("Failed to read SET_COLLECTION_SCHEMA command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid SET_COLLECTION_SCHEMA command format", nil)
+		}
+		return
+	}
+	if collectionName == "" {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name cannot be empty", nil)
+		}
+		return
+	}
+	if collectionName == globalconst.SystemCollectionName {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "A schema cannot be set on the system collection", nil)
+		}
+		return
+	}
+	if rawSchema != "" {
+		if _, err := store.ParseCollectionSchema([]byte(rawSchema)); err != nil {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusBadRequest, err.Error(), nil)
+			}
+			return
+		}
+	}
+
+	if conn != nil {
+		if !h.hasPermission(collectionName, globalconst.PermissionAdmin) {
+			slog.Warn("Unauthorized collection schema change attempt", "user", h.AuthenticatedUser, "collection", collectionName)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have admin permission for collection '%s'", collectionName), nil)
+			return
+		}
+	}
+
+	if !h.CollectionManager.CollectionExists(collectionName) {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist", collectionName), nil)
+		}
+		return
+	}
+
+	meta := h.CollectionManager.GetCollectionMeta(collectionName)
+	meta.Schema = rawSchema
+	if err := h.CollectionManager.SaveCollectionMeta(collectionName, meta); err != nil {
+		slog.Error("Failed to save collection settings", "collection", collectionName, "error", err)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusError, "Failed to save collection settings", nil)
+		}
+		return
+	}
+
+	slog.Info("Collection schema changed", "user", h.AuthenticatedUser, "collection", collectionName, "enabled", rawSchema != "")
+	if conn != nil {
+		msg := fmt.Sprintf("OK: Schema validation enabled for collection '%s'. Existing documents were not checked.", collectionName)
+		if rawSchema == "" {
+			msg = fmt.Sprintf("OK: Schema validation disabled for collection '%s'", collectionName)
+		}
+		protocol.WriteResponse(conn, protocol.StatusOk, msg, nil)
+	}
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"io"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// schemaCollection creates a collection whose documents need a string name and an integer age.
+func (e *testEnv) schemaCollection(name string) {
+	e.t.Helper()
+	e.createTestCollection(name)
+	schema := `{"required":["name"],"properties":{"name":{"type":"string"},"age":{"type":"integer"}}}`
+	resp := e.run(e.handler().HandleCollectionSetSchema, func(w io.Writer) error {
+		return protocol.WriteCollectionSetSchemaCommand(w, name, schema)
+	})
+	expectStatus(e.t, resp, protocol.StatusOk)
+}
+
+// setViolations writes a document and returns the violations the write was rejected with.
+func (e *testEnv) setViolations(collectionName, key, value string) []schemaViolation {
+	e.t.Helper()
+	resp := e.run(e.handler().HandleCollectionItemSet, func(w io.Writer) error {
+		return protocol.WriteCollectionItemSetCommand(w, collectionName, key, []byte(value), time.Duration(0))
+	})
+	expectStatus(e.t, resp, protocol.StatusBadRequest)
+	var violations []schemaViolation
+	if err := json.Unmarshal(resp.data, &violations); err != nil {
+		e.t.Fatalf("response data %q is not a list of violations: %v", resp.data, err)
+	}
+	return violations
+}
+
+func TestSchemaRejectsMissingRequiredField(t *testing.T) {
+	env := newTestEnv(t)
+	env.schemaCollection("people")
+
+	got := env.setViolations("people", "p1", `{"age":30}`)
+	want := []schemaViolation{{SchemaViolation: store.SchemaViolation{
+		Field: "name", Constraint: store.SchemaConstraintRequired, Message: "required field 'name' is missing",
+	}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("violations = %+v, want %+v", got, want)
+	}
+}
+
+func TestSchemaRejectsWrongTypedField(t *testing.T) {
+	env := newTestEnv(t)
+	env.schemaCollection("people")
+
+	got := env.setViolations("people", "p1", `{"name":"Ada","age":"thirty"}`)
+	if len(got) != 1 || got[0].Field != "age" || got[0].Constraint != store.SchemaConstraintType {
+		t.Fatalf("violations = %+v, want one type violation on 'age'", got)
+	}
+	if _, found := env.cm.GetCollection("people").Get("p1"); found {
+		t.Fatal("rejected document was stored")
+	}
+}
+
+func TestSchemaReportsEveryViolation(t *testing.T) {
+	env := newTestEnv(t)
+	env.schemaCollection("people")
+
+	got := env.setViolations("people", "p1", `{"age":1.5}`)
+	if len(got) != 2 || got[0].Constraint != store.SchemaConstraintRequired || got[1].Constraint != store.SchemaConstraintType {
+		t.Fatalf("violations = %+v, want the missing name then the non-integer age", got)
+	}
+}
+
+func TestSchemaRejectedWritesAreNotReplayed(t *testing.T) {
+	env := newTestEnv(t)
+	conn, walPath := env.serveLogged()
+	set := func(key, value string) func(io.Writer) error {
+		return func(w io.Writer) error {
+			return protocol.WriteCollectionItemSetCommand(w, "people", key, []byte(value), 0)
+		}
+	}
+	expectStatus(t, send(t, conn, func(w io.Writer) error { return protocol.WriteCollectionCreateCommand(w, "people") }), protocol.StatusOk)
+	expectStatus(t, send(t, conn, func(w io.Writer) error {
+		return protocol.WriteCollectionSetSchemaCommand(w, "people", `{"required":["name"]}`)
+	}), protocol.StatusOk)
+	expectStatus(t, send(t, conn, set("p1", `{"name":"Ada"}`)), protocol.StatusOk)
+	expectStatus(t, send(t, conn, set("p2", `{"age":30}`)), protocol.StatusBadRequest)
+
+	recovered := replayed(t, walPath)
+	if doc := recovered.storedDoc("people", "p1"); doc["name"] != "Ada" {
+		t.Errorf("replayed p1 = %v, want the accepted document", doc)
+	}
+	if _, found := recovered.cm.GetCollection("people").Get("p2"); found {
+		t.Error("a write the schema rejected was replayed")
+	}
+}
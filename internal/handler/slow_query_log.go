@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"memory-tools/internal/protocol"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// slowQueryThreshold is the execution time, in nanoseconds, from which a query is logged as slow.
+// Zero disables the slow query log.
+var slowQueryThreshold atomic.Int64
+
+// maxSlowQueryText caps the query JSON kept for a slow query, so one huge filter cannot bloat the log.
+const maxSlowQueryText = 4096
+
+// SlowQuery describes a query that took at least the slow query threshold to execute.
+type SlowQuery struct {
+	Time        string   `json:"time"`
+	User        string   `json:"user"`
+	Collection  string   `json:"collection"`
+	Query       string   `json:"query"` // Truncated to maxSlowQueryText bytes.
+	Path        string   `json:"path"`
+	IndexUsed   bool     `json:"index_used"`
+	IndexesUsed []string `json:"indexes_used"`
+	HotScanned  int      `json:"hot_scanned"`
+	ColdScanned int      `json:"cold_scanned"`
+	Returned    int      `json:"returned"`
+	DurationMs  float64  `json:"duration_ms"`
+}
+
+// slowQueryLog keeps the latest slow queries in a ring buffer.
+type slowQueryLog struct {
+	mu      sync.Mutex
+	entries []SlowQuery
+	next    int
+}
+
+// activeSlowQueryLog is nil when slow queries are only written to the server log.
+var activeSlowQueryLog atomic.Pointer[slowQueryLog]
+
+// SetSlowQueryLog logs the queries that take at least threshold to execute and keeps the last size
+// of them for the SLOW_QUERY_LIST command. A threshold of 0 or less disables the slow query log;
+// a size of 0 or less keeps no entries.
+func SetSlowQueryLog(threshold time.Duration, size int) {
+	if threshold < 0 {
+		threshold = 0
+	}
+	slowQueryThreshold.Store(int64(threshold))
+	if threshold == 0 || size <= 0 {
+		activeSlowQueryLog.Store(nil)
+		return
+	}
+	activeSlowQueryLog.Store(&slowQueryLog{entries: make([]SlowQuery, 0, size)})
+}
+
+// slowQueryLogEnabled reports whether query execution is timed against the slow query threshold.
+func slowQueryLogEnabled() bool {
+	return slowQueryThreshold.Load() > 0
+}
+
+// add stores an entry, replacing the oldest one once the buffer is full.
+func (l *slowQueryLog) add(entry SlowQuery) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) < cap(l.entries) {
+		l.entries = append(l.entries, entry)
+		return
+	}
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % len(l.entries)
+}
+
+// snapshot returns a copy of the stored entries.
+func (l *slowQueryLog) snapshot() []SlowQuery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]SlowQuery(nil), l.entries...)
+}
+
+// recordSlowQuery logs a query whose execution took at least the slow query threshold and keeps it
+// in the ring buffer. stats holds the counts of the execution; it is nil if none were collected.
+func (h *ConnectionHandler) recordSlowQuery(collectionName string, queryJSON []byte, stats *QueryStats, elapsed time.Duration) {
+	threshold := slowQueryThreshold.Load()
+	if threshold <= 0 || elapsed < time.Duration(threshold) {
+		return
+	}
+	if stats == nil {
+		stats = newQueryStats()
+	}
+	queryText := string(queryJSON)
+	if len(queryText) > maxSlowQueryText {
+		queryText = queryText[:maxSlowQueryText]
+	}
+	entry := SlowQuery{
+		Time:        time.Now().UTC().Format(time.RFC3339Nano),
+		User:        h.AuthenticatedUser,
+		Collection:  collectionName,
+		Query:       queryText,
+		Path:        stats.Path,
+		IndexUsed:   len(stats.IndexesUsed) > 0,
+		IndexesUsed: stats.IndexesUsed,
+		HotScanned:  stats.HotScanned,
+		ColdScanned: stats.ColdScanned,
+		Returned:    stats.Returned,
+		DurationMs:  float64(elapsed.Microseconds()) / 1000,
+	}
+
+	slog.Warn("Slow query",
+		"user", entry.User,
+		"collection", entry.Collection,
+		"query", entry.Query,
+		"path", entry.Path,
+		"index_used", entry.IndexUsed,
+		"indexes_used", entry.IndexesUsed,
+		"hot_scanned", entry.HotScanned,
+		"cold_scanned", entry.ColdScanned,
+		"returned", entry.Returned,
+		"duration_ms", entry.DurationMs,
+	)
+	if log := activeSlowQueryLog.Load(); log != nil {
+		log.add(entry)
+	}
+}
+
+// handleSlowQueryList processes the CmdSlowQueryList command. It is root-only.
+// It returns the slow queries kept in the ring buffer, slowest first, so operators can review the
+// worst offenders after the fact. A limit of 0 returns them all.
+func (h *ConnectionHandler) handleSlowQueryList(r io.Reader, conn net.Conn) {
+	limit, err := protocol.ReadSlowQueryListCommand(r)
+	if err != nil {
+		slog.Error("Failed to read SLOW_QUERY_LIST command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid SLOW_QUERY_LIST command format", nil)
+		return
+	}
+	if !h.IsRoot {
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Only root can read the slow query log.", nil)
+		return
+	}
+
+	entries := []SlowQuery{}
+	if log := activeSlowQueryLog.Load(); log != nil {
+		entries = log.snapshot()
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].DurationMs > entries[j].DurationMs
+	})
+	if limit > 0 && int(limit) < len(entries) {
+		entries = entries[:limit]
+	}
+
+	responseData, err := json.Marshal(entries)
+	if err != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to serialize slow query log", nil)
+		return
+	}
+	msg := fmt.Sprintf("OK: %d slow queries found.", len(entries))
+	if !slowQueryLogEnabled() {
+		msg += " The slow query log is disabled."
+	}
+	protocol.WriteResponse(conn, protocol.StatusOk, msg, responseData)
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"io"
+	"log/slog"
+	"memory-tools/internal/protocol"
+	"net"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// StatsVersion is the version of the STATS response layout. Fields are only ever added within a
+// version; it changes when existing fields are removed or change meaning.
+const StatsVersion = 1
+
+// ServerStats is the response payload of the STATS command.
+type ServerStats struct {
+	Version     int                    `json:"version"`
+	Timestamp   string                 `json:"timestamp"`
+	MainStore   MainStoreStats         `json:"main_store"`
+	Collections []CollectionStoreStats `json:"collections"`
+	Wal         WalStats               `json:"wal"`
+	LastBackup  string                 `json:"last_backup,omitempty"` // RFC3339; omitted if no backup has run since startup.
+	Connections ConnectionStats        `json:"connections"`
+	Runtime     RuntimeStats           `json:"runtime"`
+}
+
+// MainStoreStats describes the main key-value store.
+type MainStoreStats struct {
+	Items  int `json:"items"`
+	Shards int `json:"shards"`
+}
+
+// CollectionStoreStats describes one collection's in-memory store.
+type CollectionStoreStats struct {
+	Collection string `json:"collection"`
+	Items      int    `json:"items"`
+	Indexes    int    `json:"indexes"`
+	Shards     int    `json:"shards"`
+}
+
+// WalStats describes the write-ahead log.
+type WalStats struct {
+	Enabled   bool  `json:"enabled"`
+	SizeBytes int64 `json:"size_bytes"`
+}
+
+// ConnectionStats describes the client connections and the connection limit.
+type ConnectionStats struct {
+	Active   int64  `json:"active"`   // Open connections, including those waiting for a worker.
+	Max      int64  `json:"max"`      // 0 when unlimited.
+	Rejected uint64 `json:"rejected"` // Connections turned away by the limit since startup.
+}
+
+// RuntimeStats is a subset of the Go runtime's memory statistics.
+type RuntimeStats struct {
+	Goroutines     int    `json:"goroutines"`
+	HeapAllocBytes uint64 `json:"heap_alloc_bytes"`
+	HeapInuseBytes uint64 `json:"heap_inuse_bytes"`
+	SysBytes       uint64 `json:"sys_bytes"`
+	TotalAlloc     uint64 `json:"total_alloc_bytes"`
+	NumGC          uint32 `json:"num_gc"`
+	LastGC         string `json:"last_gc,omitempty"`
+}
+
+// handleStats processes the CmdStats command. It is root-only.
+// It gathers store, WAL, backup and Go runtime metrics for monitoring.
+func (h *ConnectionHandler) handleStats(r io.Reader, conn net.Conn) {
+	if err := protocol.ReadStatsCommand(r); err != nil {
+		slog.Error("Failed to read STATS command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid STATS command format", nil)
+		return
+	}
+	if !h.IsRoot {
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Only root can read server stats.", nil)
+		return
+	}
+
+	stats := ServerStats{
+		Version:   StatsVersion,
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		MainStore: MainStoreStats{Items: h.MainStore.Size(), Shards: h.MainStore.ShardCount()},
+	}
+
+	names := h.CollectionManager.ListCollections()
+	sort.Strings(names)
+	stats.Collections = make([]CollectionStoreStats, 0, len(names))
+	for _, name := range names {
+		colStore := h.CollectionManager.GetCollection(name)
+		stats.Collections = append(stats.Collections, CollectionStoreStats{
+			Collection: name,
+			Items:      colStore.Size(),
+			Indexes:    len(colStore.ListIndexes()),
+			Shards:     colStore.ShardCount(),
+		})
+	}
+
+	if h.Wal != nil {
+		stats.Wal.Enabled = true
+		size, err := h.Wal.Size()
+		if err != nil {
+			slog.Warn("Failed to read WAL size for stats", "error", err)
+		}
+		stats.Wal.SizeBytes = size
+	}
+
+	if h.BackupManager != nil {
+		if lastBackup := h.BackupManager.GetLastBackupTime(); !lastBackup.IsZero() {
+			stats.LastBackup = lastBackup.UTC().Format(time.RFC3339)
+		}
+	}
+
+	stats.Connections = ConnectionStats{
+		Active:   activeConnections.Load(),
+		Max:      maxConnections.Load(),
+		Rejected: rejectedConnections.Load(),
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats.Runtime = RuntimeStats{
+		Goroutines:     runtime.NumGoroutine(),
+		HeapAllocBytes: mem.HeapAlloc,
+		HeapInuseBytes: mem.HeapInuse,
+		SysBytes:       mem.Sys,
+		TotalAlloc:     mem.TotalAlloc,
+		NumGC:          mem.NumGC,
+	}
+	if mem.LastGC > 0 {
+		stats.Runtime.LastGC = time.Unix(0, int64(mem.LastGC)).UTC().Format(time.RFC3339Nano)
+	}
+
+	responseData, err := json.Marshal(stats)
+	if err != nil {
+		slog.Error("Failed to marshal server stats", "error", err)
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to marshal server stats", nil)
+		return
+	}
+	protocol.WriteResponse(conn, protocol.StatusOk, "OK: Server stats retrieved.", responseData)
+}
@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	stdjson "encoding/json"
+)
+
+const (
+	// subscriptionBufferSize is how many events a subscriber may fall behind by before it is
+	// dropped. Writes never wait for subscribers.
+	subscriptionBufferSize = 1024
+	// subscriptionWriteTimeout ends a subscription whose client stops reading its events.
+	subscriptionWriteTimeout = 10 * time.Second
+)
+
+// Operations reported by change events.
+const (
+	changeOpSet      = "set"
+	changeOpUpdate   = "update"
+	changeOpDelete   = "delete"
+	changeOpTruncate = "truncate"
+)
+
+// ChangeEvent is pushed to the subscribers of a collection for each write to it.
+type ChangeEvent struct {
+	Collection string             `json:"collection"`
+	Op         string             `json:"op"`              // "set", "update", "delete" or "truncate".
+	Key        string             `json:"key,omitempty"`   // Empty for "truncate", which removes every key.
+	Value      stdjson.RawMessage `json:"value,omitempty"` // The document written, if the subscriber asked for values and it is known.
+	Time       string             `json:"time"`
+}
+
+// subscriber is a connection receiving the change events of a collection.
+type subscriber struct {
+	collection string
+	keyPrefix  string
+	withValues bool
+	events     chan ChangeEvent
+	overflow   chan struct{} // Closed once an event is dropped because events is full.
+	overflowed sync.Once
+}
+
+// subscriptions holds the subscribers of each collection.
+var subscriptions = struct {
+	mu           sync.RWMutex
+	byCollection map[string]map[*subscriber]struct{}
+	count        atomic.Int64 // Lets writes skip the lock while nobody is subscribed.
+}{byCollection: make(map[string]map[*subscriber]struct{})}
+
+func addSubscriber(sub *subscriber) {
+	subscriptions.mu.Lock()
+	defer subscriptions.mu.Unlock()
+	subs, ok := subscriptions.byCollection[sub.collection]
+	if !ok {
+		subs = make(map[*subscriber]struct{})
+		subscriptions.byCollection[sub.collection] = subs
+	}
+	subs[sub] = struct{}{}
+	subscriptions.count.Add(1)
+}
+
+func removeSubscriber(sub *subscriber) {
+	subscriptions.mu.Lock()
+	defer subscriptions.mu.Unlock()
+	subs := subscriptions.byCollection[sub.collection]
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(subscriptions.byCollection, sub.collection)
+	}
+	subscriptions.count.Add(-1)
+}
+
+// publishChange sends a change event to every subscriber of a collection whose key prefix matches.
+// A nil value leaves the document out of the event. It is called once a write has been applied;
+// a subscriber too far behind to take the event is dropped rather than making the write wait.
+func publishChange(collectionName, op, key string, value []byte) {
+	if subscriptions.count.Load() == 0 {
+		return
+	}
+	subscriptions.mu.RLock()
+	defer subscriptions.mu.RUnlock()
+	subs := subscriptions.byCollection[collectionName]
+	if len(subs) == 0 {
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	for sub := range subs {
+		if op != changeOpTruncate && !strings.HasPrefix(key, sub.keyPrefix) {
+			continue
+		}
+		event := ChangeEvent{Collection: collectionName, Op: op, Key: key, Time: now}
+		if sub.withValues && value != nil {
+			event.Value = value
+		}
+		select {
+		case sub.events <- event:
+		default:
+			sub.overflowed.Do(func() { close(sub.overflow) })
+		}
+	}
+}
+
+// publishCommittedChanges sends the change events of a committed transaction's writes, in the
+// order they were made. The values are read back from the collections, as the commit adds the
+// timestamps and versions.
+func (h *ConnectionHandler) publishCommittedChanges(ops []store.WriteOperation) {
+	if subscriptions.count.Load() == 0 {
+		return
+	}
+	for _, op := range ops {
+		switch op.OpType {
+		case store.OpTypeTruncate:
+			publishChange(op.Collection, changeOpTruncate, "", nil)
+		case store.OpTypeDelete:
+			publishChange(op.Collection, changeOpDelete, op.Key, nil)
+		case store.OpTypeSet, store.OpTypeUpdate:
+			value, _ := h.CollectionManager.GetCollection(op.Collection).Get(op.Key)
+			publishChange(op.Collection, op.OpType.String(), op.Key, value)
+		}
+	}
+}
+
+// handleSubscribe processes the CmdSubscribe command. It needs read permission on the collection.
+// Once subscribed, the connection only receives events: each one is a StatusPartial response
+// holding a ChangeEvent, after a first StatusPartial response confirming the subscription. The
+// subscription lasts until the client closes the connection, falls too far behind, or the server
+// shuts down; a final response then reports why, if the connection is still open. It returns
+// whether the subscription started, in which case the connection must be closed afterwards.
+func (h *ConnectionHandler) handleSubscribe(r io.Reader, conn net.Conn) bool {
+	collectionName, keyPrefix, withValues, err := protocol.ReadSubscribeCommand(r)
+	if err != nil {
+		slog.Error("Failed to read SUBSCRIBE command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid SUBSCRIBE command format", nil)
+		return false
+	}
+	if collectionName == "" {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name cannot be empty", nil)
+		return false
+	}
+	if collectionName == globalconst.SystemCollectionName {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "The system collection cannot be subscribed to", nil)
+		return false
+	}
+	if h.CurrentTransactionID != "" {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Cannot subscribe inside a transaction. Commit or roll it back first.", nil)
+		return false
+	}
+	if !h.hasPermission(collectionName, globalconst.PermissionRead) {
+		slog.Warn("Unauthorized subscribe attempt", "user", h.AuthenticatedUser, "collection", collectionName, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have read permission for collection '%s'", collectionName), nil)
+		return false
+	}
+	if !h.CollectionManager.CollectionExists(collectionName) {
+		protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist", collectionName), nil)
+		return false
+	}
+
+	sub := &subscriber{
+		collection: collectionName,
+		keyPrefix:  keyPrefix,
+		withValues: withValues,
+		events:     make(chan ChangeEvent, subscriptionBufferSize),
+		overflow:   make(chan struct{}),
+	}
+	addSubscriber(sub)
+	defer removeSubscriber(sub)
+	slog.Info("Client subscribed to collection changes", "user", h.AuthenticatedUser, "collection", collectionName, "key_prefix", keyPrefix, "with_values", withValues)
+
+	// The client sends nothing more: reading only tells when it closes the connection.
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(closed)
+	}()
+	drainCheck := time.NewTicker(drainPollInterval)
+	defer drainCheck.Stop()
+
+	send := func(status protocol.ResponseStatus, msg string, data []byte) error {
+		conn.SetWriteDeadline(time.Now().Add(subscriptionWriteTimeout))
+		return protocol.WriteResponse(conn, status, msg, data)
+	}
+	if err := send(protocol.StatusPartial, fmt.Sprintf("OK: Subscribed to changes of collection '%s'", collectionName), nil); err != nil {
+		return true
+	}
+	for {
+		select {
+		case event := <-sub.events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				slog.Warn("Failed to marshal change event", "collection", collectionName, "key", event.Key, "error", err)
+				continue
+			}
+			if err := send(protocol.StatusPartial, "", data); err != nil {
+				slog.Info("Subscription ended: failed to send event", "user", h.AuthenticatedUser, "collection", collectionName, "error", err)
+				return true
+			}
+		case <-sub.overflow:
+			slog.Warn("Subscription dropped: subscriber fell behind", "user", h.AuthenticatedUser, "collection", collectionName, "buffered_events", subscriptionBufferSize)
+			send(protocol.StatusError, fmt.Sprintf("ERROR: Subscription dropped: more than %d events were waiting to be sent. Subscribe again and re-read the collection to catch up.", subscriptionBufferSize), nil)
+			return true
+		case <-closed:
+			slog.Info("Subscription ended: client closed the connection", "user", h.AuthenticatedUser, "collection", collectionName)
+			return true
+		case <-drainCheck.C:
+			if isDraining() {
+				send(protocol.StatusOk, "OK: Subscription ended: the server is shutting down.", nil)
+				return true
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"io"
+	"memory-tools/internal/protocol"
+	"sort"
+	"testing"
+)
+
+// subscribeTest subscribes to every change of a collection for the rest of the test.
+func subscribeTest(t *testing.T, collectionName string) *subscriber {
+	t.Helper()
+	sub := &subscriber{
+		collection: collectionName,
+		withValues: true,
+		events:     make(chan ChangeEvent, subscriptionBufferSize),
+		overflow:   make(chan struct{}),
+	}
+	addSubscriber(sub)
+	t.Cleanup(func() { removeSubscriber(sub) })
+	return sub
+}
+
+// receivedEvents returns the events a subscriber has been sent so far, as "op key" sorted.
+func receivedEvents(sub *subscriber) []string {
+	var events []string
+	for {
+		select {
+		case event := <-sub.events:
+			events = append(events, event.Op+" "+event.Key)
+		default:
+			sort.Strings(events)
+			return events
+		}
+	}
+}
+
+func TestMergeByQueryPublishesEachMergedDocument(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("orders")
+	h := env.handler()
+	for key, status := range map[string]string{"o1": "shipped", "o2": "open", "o3": "shipped"} {
+		expectStatus(t, env.run(h.HandleCollectionItemSet, func(w io.Writer) error {
+			return protocol.WriteCollectionItemSetCommand(w, "orders", key, []byte(`{"status":"`+status+`"}`), 0)
+		}), protocol.StatusOk)
+	}
+	sub := subscribeTest(t, "orders")
+
+	expectStatus(t, env.run(h.HandleCollectionItemMergeByQuery, func(w io.Writer) error {
+		return protocol.WriteCollectionItemMergeByQueryCommand(w, "orders", []byte(`{"field":"status","op":"=","value":"shipped"}`), []byte(`{"reviewed":true}`))
+	}), protocol.StatusOk)
+
+	got := receivedEvents(sub)
+	if len(got) != 2 || got[0] != "update o1" || got[1] != "update o3" {
+		t.Fatalf("events = %q, want an update of o1 and o3", got)
+	}
+}
+
+func TestImportPublishesEachInsertedDocument(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	sub := subscribeTest(t, "items")
+
+	env.runImport("items", 2, `{"_id":"a"}`, `{"_id":"b"}`, `not json`, `{"_id":"c"}`)
+
+	got := receivedEvents(sub)
+	if len(got) != 3 || got[0] != "set a" || got[1] != "set b" || got[2] != "set c" {
+		t.Fatalf("events = %q, want a set of a, b and c", got)
+	}
+}
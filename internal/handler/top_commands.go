@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/protocol"
+	"net"
+	"sort"
+)
+
+// maxTopLargestCount caps how many documents a single top-largest request may return.
+const maxTopLargestCount = 1000
+
+// DocumentSize describes the stored size of a single document.
+type DocumentSize struct {
+	Key  string `json:"key"`
+	Size int    `json:"size_bytes"`
+}
+
+// documentSizeHeap is a min-heap of DocumentSize ordered by size, so the
+// smallest of the current top-N is always at the root and can be evicted first.
+type documentSizeHeap []DocumentSize
+
+func (h documentSizeHeap) Len() int { return len(h) }
+func (h documentSizeHeap) Less(i, j int) bool {
+	if h[i].Size != h[j].Size {
+		return h[i].Size < h[j].Size
+	}
+	return h[i].Key > h[j].Key
+}
+func (h documentSizeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *documentSizeHeap) Push(x any)   { *h = append(*h, x.(DocumentSize)) }
+func (h *documentSizeHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// handleCollectionTopLargest processes the CmdCollectionTopLargest command. It is a read-only operation.
+func (h *ConnectionHandler) handleCollectionTopLargest(r io.Reader, conn net.Conn) {
+	if h.CurrentTransactionID != "" {
+		protocol.WriteResponse(conn, protocol.StatusError, "ERROR: Read operations like TOP LARGEST are not supported inside a transaction in this version.", nil)
+		return
+	}
+	collectionName, count, err := protocol.ReadCollectionTopLargestCommand(r)
+	if err != nil {
+		slog.Error("Failed to read TOP_LARGEST command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid COLLECTION_TOP_LARGEST command format", nil)
+		return
+	}
+	if collectionName == "" {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name cannot be empty", nil)
+		return
+	}
+	if count == 0 || count > maxTopLargestCount {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, fmt.Sprintf("Count must be between 1 and %d", maxTopLargestCount), nil)
+		return
+	}
+	if !h.hasPermission(collectionName, globalconst.PermissionRead) {
+		slog.Warn("Unauthorized top largest attempt", "user", h.AuthenticatedUser, "collection", collectionName)
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have read permission for collection '%s'", collectionName), nil)
+		return
+	}
+	if !h.CollectionManager.CollectionExists(collectionName) {
+		protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist.", collectionName), nil)
+		return
+	}
+	recordCollectionRead(collectionName)
+
+	colStore := h.CollectionManager.GetCollection(collectionName)
+	largest := topLargestDocuments(colStore.StreamAll, int(count))
+
+	responseData, err := json.Marshal(largest)
+	if err != nil {
+		slog.Error("Failed to marshal top largest documents", "collection", collectionName, "error", err)
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to marshal top largest documents", nil)
+		return
+	}
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: %d largest documents in collection '%s' retrieved.", len(largest), collectionName), responseData)
+}
+
+// topLargestDocuments streams every item once, keeping only the N biggest in a bounded min-heap.
+// Only sizes and keys are retained, never the values. The result is sorted largest first.
+func topLargestDocuments(stream func(callback func(key string, value []byte) bool), n int) []DocumentSize {
+	h := make(documentSizeHeap, 0, n)
+	stream(func(key string, value []byte) bool {
+		entry := DocumentSize{Key: key, Size: len(value)}
+		if h.Len() < n {
+			heap.Push(&h, entry)
+		} else if entry.Size > h[0].Size {
+			h[0] = entry
+			heap.Fix(&h, 0)
+		}
+		return true
+	})
+
+	result := []DocumentSize(h)
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Size != result[j].Size {
+			return result[i].Size > result[j].Size
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"io"
+	"memory-tools/internal/protocol"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTopLargestDocuments(t *testing.T) {
+	docs := map[string]int{"a": 5, "b": 40, "c": 12, "d": 40, "e": 1, "f": 33, "g": 7}
+	stream := func(callback func(key string, value []byte) bool) {
+		for key, size := range docs {
+			if !callback(key, make([]byte, size)) {
+				return
+			}
+		}
+	}
+
+	got := topLargestDocuments(stream, 3)
+	want := []DocumentSize{{Key: "b", Size: 40}, {Key: "d", Size: 40}, {Key: "f", Size: 33}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("top 3 = %v, want %v", got, want)
+	}
+	if got := topLargestDocuments(stream, 100); len(got) != len(docs) || got[len(got)-1].Key != "e" {
+		t.Fatalf("top 100 = %v, want all %d documents ending with the smallest", got, len(docs))
+	}
+}
+
+func TestTopLargestCommand(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("docs")
+	for i, key := range []string{"small", "huge", "medium", "large", "tiny"} {
+		env.setItem("docs", key, `{"pad":"`+strings.Repeat("x", []int{10, 500, 100, 250, 1}[i])+`"}`)
+	}
+
+	resp := env.run(env.handler().handleCollectionTopLargest, func(w io.Writer) error {
+		return protocol.WriteCollectionTopLargestCommand(w, "docs", 3)
+	})
+	expectStatus(t, resp, protocol.StatusOk)
+	var largest []DocumentSize
+	if err := json.Unmarshal(resp.data, &largest); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, doc := range largest {
+		keys = append(keys, doc.Key)
+	}
+	if want := []string{"huge", "large", "medium"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("largest = %v, want %v", keys, want)
+	}
+	if largest[0].Size <= largest[1].Size || largest[1].Size <= largest[2].Size {
+		t.Errorf("sizes not in decreasing order: %v", largest)
+	}
+
+	resp = env.run(env.handler().handleCollectionTopLargest, func(w io.Writer) error {
+		return protocol.WriteCollectionTopLargestCommand(w, "docs", 0)
+	})
+	expectStatus(t, resp, protocol.StatusBadRequest)
+}
@@ -1,13 +1,45 @@
 package handler
 
 import (
+	stdjson "encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"memory-tools/internal/globalconst"
 	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
 	"net"
+	"time"
 )
 
+// TransactionStarted is the response data of BEGIN. Timeout is how long the transaction may
+// stay open before the server aborts it; it and ExpiresAt are omitted if transactions never expire.
+type TransactionStarted struct {
+	ID             string `json:"id"`
+	TimeoutSeconds int64  `json:"timeout_seconds,omitempty"`
+	ExpiresAt      string `json:"expires_at,omitempty"`
+}
+
+// TransactionStatus describes the connection's active transaction and what it has buffered.
+type TransactionStatus struct {
+	ID             string             `json:"id"`
+	State          string             `json:"state"`
+	StartedAt      string             `json:"started_at"`
+	ExpiresAt      string             `json:"expires_at,omitempty"`
+	OperationCount int                `json:"operation_count"`
+	Operations     []PendingOperation `json:"operations"`
+}
+
+// PendingOperation summarizes a write queued in a transaction.
+// Value is only included when the user may read the target collection.
+type PendingOperation struct {
+	Collection string             `json:"collection"`
+	Key        string             `json:"key"`
+	Op         string             `json:"op"`
+	Value      stdjson.RawMessage `json:"value,omitempty"`
+}
+
 // handleBegin starts a new transaction for the current connection.
 // It is not a write operation to the WAL, as it only modifies the connection's state.
 func (h *ConnectionHandler) handleBegin(r io.Reader, conn net.Conn) {
@@ -34,10 +66,37 @@ func (h *ConnectionHandler) handleBegin(r io.Reader, conn net.Conn) {
 	h.CurrentTransactionID = txID
 	slog.Info("Transaction started", "txID", txID, "user", h.AuthenticatedUser)
 	if conn != nil {
-		protocol.WriteResponse(conn, protocol.StatusOk, "OK: Transaction started.", []byte(txID))
+		started := TransactionStarted{ID: txID}
+		msg := "OK: Transaction started."
+		if timeout := h.TransactionManager.Timeout(); timeout > 0 {
+			started.TimeoutSeconds = int64(timeout / time.Second)
+			started.ExpiresAt = time.Now().Add(timeout).UTC().Format(time.RFC3339)
+			msg = fmt.Sprintf("OK: Transaction started. It is aborted if not committed within %s.", timeout)
+		}
+		data, _ := json.Marshal(started)
+		protocol.WriteResponse(conn, protocol.StatusOk, msg, data)
 	}
 }
 
+// rejectExpiredTransaction answers a command sent inside a transaction that the garbage collector
+// has already aborted, instead of running it outside of any transaction. The connection leaves
+// the transaction, so the client can begin a new one and retry. The payload is discarded unread;
+// false means it could not be and the connection must be closed.
+func (h *ConnectionHandler) rejectExpiredTransaction(conn net.Conn, cmdType protocol.CommandType) bool {
+	txID := h.CurrentTransactionID
+	h.CurrentTransactionID = ""
+	discardBuf := protocol.AcquirePayloadBuffer()
+	err := protocol.ReadCommandPayloadInto(conn, cmdType, discardBuf)
+	protocol.ReleasePayloadBuffer(discardBuf)
+	if err != nil {
+		slog.Error("Failed to read payload of command in expired transaction", "error", err, "command_type", cmdType)
+		return false
+	}
+	slog.Warn("Command rejected: transaction expired", "txID", txID, "user", h.AuthenticatedUser, "command_type", cmdType)
+	protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("ERROR: Transaction expired: it was aborted after staying open longer than %s. Begin a new transaction and retry.", h.TransactionManager.Timeout()), nil)
+	return true
+}
+
 // HandleCommit attempts to commit the current transaction. It is a write operation to the WAL.
 func (h *ConnectionHandler) HandleCommit(r io.Reader, conn net.Conn) {
 	if h.CurrentTransactionID == "" {
@@ -51,16 +110,24 @@ func (h *ConnectionHandler) HandleCommit(r io.Reader, conn net.Conn) {
 	// Clear the transaction ID from the connection immediately.
 	h.CurrentTransactionID = ""
 
+	// The writes are read before the commit, which discards the transaction, to notify subscribers.
+	_, _, ops, _ := h.TransactionManager.Inspect(txID)
 	err := h.TransactionManager.Commit(txID)
 
 	if err != nil {
 		slog.Error("Transaction failed to commit and was rolled back", "txID", txID, "error", err, "user", h.AuthenticatedUser)
 		if conn != nil {
+			var violation *store.UniqueViolationError
+			if errors.As(err, &violation) {
+				protocol.WriteResponse(conn, protocol.StatusConflict, fmt.Sprintf("CONFLICT: Transaction failed and was rolled back: %v", err), nil)
+				return
+			}
 			protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("ERROR: Transaction failed and was rolled back: %v", err), nil)
 		}
 		return
 	}
 
+	h.publishCommittedChanges(ops)
 	slog.Info("Transaction committed successfully", "txID", txID, "user", h.AuthenticatedUser)
 	if conn != nil {
 		protocol.WriteResponse(conn, protocol.StatusOk, "OK: Transaction committed successfully.", nil)
@@ -94,3 +161,85 @@ func (h *ConnectionHandler) handleRollback(r io.Reader, conn net.Conn) {
 		protocol.WriteResponse(conn, protocol.StatusOk, "OK: Transaction rolled back successfully.", nil)
 	}
 }
+
+// handleTransactionStatus reports the pending operations of the current transaction.
+// It is a read-only operation and does not change the transaction.
+func (h *ConnectionHandler) handleTransactionStatus(r io.Reader, conn net.Conn) {
+	if h.CurrentTransactionID == "" {
+		protocol.WriteResponse(conn, protocol.StatusError, "ERROR: No transaction in progress.", nil)
+		return
+	}
+
+	state, startedAt, ops, err := h.TransactionManager.Inspect(h.CurrentTransactionID)
+	if err != nil {
+		protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("ERROR: %v", err), nil)
+		return
+	}
+
+	status := TransactionStatus{
+		ID:             h.CurrentTransactionID,
+		State:          state.String(),
+		StartedAt:      startedAt.UTC().Format(time.RFC3339),
+		OperationCount: len(ops),
+		Operations:     make([]PendingOperation, 0, len(ops)),
+	}
+	if timeout := h.TransactionManager.Timeout(); timeout > 0 {
+		status.ExpiresAt = startedAt.Add(timeout).UTC().Format(time.RFC3339)
+	}
+	for _, op := range ops {
+		pending := PendingOperation{Collection: op.Collection, Key: op.Key, Op: op.OpType.String()}
+		if op.Value != nil && h.hasPermission(op.Collection, globalconst.PermissionRead) {
+			pending.Value = op.Value
+		}
+		status.Operations = append(status.Operations, pending)
+	}
+
+	responseData, err := json.Marshal(status)
+	if err != nil {
+		slog.Error("Failed to marshal transaction status", "txID", h.CurrentTransactionID, "error", err)
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to marshal transaction status", nil)
+		return
+	}
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Transaction has %d pending operations.", len(ops)), responseData)
+}
+
+// pendingValue looks up a key in the current transaction's queued writes, so reads inside a
+// transaction see its own changes: a queued set or update returns the new value and a queued
+// delete reports the key as missing. pending is false outside a transaction or if the
+// transaction has not written the key, in which case the committed data answers the read.
+func (h *ConnectionHandler) pendingValue(collectionName, key string) (value []byte, found, pending bool, err error) {
+	if h.CurrentTransactionID == "" {
+		return nil, false, false, nil
+	}
+	op, pending, err := h.TransactionManager.PendingWrite(h.CurrentTransactionID, collectionName, key)
+	if err != nil || !pending {
+		return nil, false, false, err
+	}
+	if op.OpType == store.OpTypeDelete {
+		return nil, false, true, nil
+	}
+	return op.Value, true, true, nil
+}
+
+// overlayPendingWrites applies the current transaction's queued writes on a collection to a
+// snapshot of its committed items. A truncation by the transaction hides every committed item.
+func (h *ConnectionHandler) overlayPendingWrites(collectionName string, items map[string][]byte) error {
+	if h.CurrentTransactionID == "" {
+		return nil
+	}
+	ops, truncated, err := h.TransactionManager.PendingWrites(h.CurrentTransactionID, collectionName)
+	if err != nil {
+		return err
+	}
+	if truncated {
+		clear(items)
+	}
+	for key, op := range ops {
+		if op.OpType == store.OpTypeDelete {
+			delete(items, key)
+		} else {
+			items[key] = op.Value
+		}
+	}
+	return nil
+}
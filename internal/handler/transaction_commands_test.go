@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"io"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"testing"
+)
+
+// transactionStatus returns the status of the handler's current transaction.
+func (e *testEnv) transactionStatus(h *ConnectionHandler) TransactionStatus {
+	e.t.Helper()
+	resp := e.run(h.handleTransactionStatus, protocol.WriteTransactionStatusCommand)
+	expectStatus(e.t, resp, protocol.StatusOk)
+	var status TransactionStatus
+	if err := json.Unmarshal(resp.data, &status); err != nil {
+		e.t.Fatal(err)
+	}
+	return status
+}
+
+func TestTransactionStatusReportsPendingOperations(t *testing.T) {
+	env := newTestEnv(t)
+	env.createTestCollection("items")
+	env.setItem("items", "old", `{"a":1}`)
+	h := env.handler()
+
+	expectStatus(t, env.run(h.handleTransactionStatus, protocol.WriteTransactionStatusCommand), protocol.StatusError)
+	expectStatus(t, env.run(h.handleBegin, protocol.WriteBeginCommand), protocol.StatusOk)
+	if status := env.transactionStatus(h); status.OperationCount != 0 || len(status.Operations) != 0 {
+		t.Fatalf("new transaction reports %d operations", status.OperationCount)
+	}
+
+	expectStatus(t, env.run(h.HandleCollectionItemSet, func(w io.Writer) error {
+		return protocol.WriteCollectionItemSetCommand(w, "items", "new", []byte(`{"b":2}`), 0)
+	}), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCollectionItemUpdate, func(w io.Writer) error {
+		return protocol.WriteCollectionItemUpdateCommand(w, "items", "old", []byte(`{"a":5}`))
+	}), protocol.StatusOk)
+	expectStatus(t, env.run(h.HandleCollectionItemDelete, func(w io.Writer) error {
+		return protocol.WriteCollectionItemDeleteCommand(w, "items", "old")
+	}), protocol.StatusOk)
+
+	status := env.transactionStatus(h)
+	if status.ID != h.CurrentTransactionID || status.State != store.StateActive.String() {
+		t.Errorf("id = %q state = %q, want %q active", status.ID, status.State, h.CurrentTransactionID)
+	}
+	want := []PendingOperation{
+		{Collection: "items", Key: "new", Op: "set"},
+		{Collection: "items", Key: "old", Op: "update"},
+		{Collection: "items", Key: "old", Op: "delete"},
+	}
+	if status.OperationCount != len(want) || len(status.Operations) != len(want) {
+		t.Fatalf("operations = %+v, want %d", status.Operations, len(want))
+	}
+	for i, op := range status.Operations {
+		if op.Collection != want[i].Collection || op.Key != want[i].Key || op.Op != want[i].Op {
+			t.Errorf("operation %d = %+v, want %+v", i, op, want[i])
+		}
+	}
+	var value map[string]any
+	if err := json.Unmarshal(status.Operations[0].Value, &value); err != nil || value["b"] != float64(2) {
+		t.Errorf("value of the queued set = %s, want the new document", status.Operations[0].Value)
+	}
+	if status.Operations[2].Value != nil {
+		t.Errorf("queued delete has a value: %s", status.Operations[2].Value)
+	}
+
+	if _, found := env.cm.GetCollection("items").Get("new"); found {
+		t.Fatal("asking for the status applied the transaction")
+	}
+}
+
+func TestTransactionStatusHidesUnreadableValues(t *testing.T) {
+	env := newTestEnv(t)
+	h := env.handler()
+	h.IsRoot = false
+	h.Permissions["items"] = "write"
+	h.Permissions["secrets"] = "metadata"
+
+	expectStatus(t, env.run(h.handleBegin, protocol.WriteBeginCommand), protocol.StatusOk)
+	for _, op := range []store.WriteOperation{
+		{Collection: "items", Key: "a", Value: []byte(`{"x":1}`), OpType: store.OpTypeSet},
+		{Collection: "secrets", Key: "b", Value: []byte(`{"pin":1234}`), OpType: store.OpTypeSet},
+	} {
+		if err := env.tm.RecordWrite(h.CurrentTransactionID, op); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status := env.transactionStatus(h)
+	if len(status.Operations) != 2 {
+		t.Fatalf("operations = %+v, want 2", status.Operations)
+	}
+	if status.Operations[0].Value == nil {
+		t.Error("value of a readable collection is hidden")
+	}
+	if status.Operations[1].Value != nil || status.Operations[1].Key != "b" {
+		t.Errorf("operation on an unreadable collection = %+v, want its key without the value", status.Operations[1])
+	}
+}
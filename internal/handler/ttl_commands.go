@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/protocol"
+	"net"
+	"time"
+)
+
+// noExpiryTTL is the ttl_seconds reported for items that never expire.
+const noExpiryTTL = -1
+
+// ItemTTL is the response data of COLLECTION_ITEM_TTL and TOUCH_COLLECTION_ITEM.
+type ItemTTL struct {
+	TTLSeconds int64  `json:"ttl_seconds"`          // Seconds left, rounded up, or -1 if the item never expires.
+	ExpiresAt  string `json:"expires_at,omitempty"` // RFC3339 UTC time of expiry, for items that expire.
+}
+
+func newItemTTL(remaining time.Duration, hasExpiry bool) ItemTTL {
+	if !hasExpiry {
+		return ItemTTL{TTLSeconds: noExpiryTTL}
+	}
+	return ItemTTL{
+		TTLSeconds: int64(math.Ceil(remaining.Seconds())),
+		ExpiresAt:  time.Now().Add(remaining).UTC().Format(time.RFC3339),
+	}
+}
+
+// coldItemExists reports whether a key has a live document in the collection file.
+func coldItemExists(collectionName, key string) (bool, error) {
+	// The key scan skips values; a hit is confirmed with a read so tombstoned items don't count.
+	foundInCold, err := persistence.CheckColdKeyExists(collectionName, key)
+	if err != nil || !foundInCold {
+		return false, err
+	}
+	_, found, err := persistence.GetColdItem(collectionName, key)
+	return found, err
+}
+
+// handleCollectionItemTTL processes the CmdCollectionItemTTL command. It is a read-only operation.
+// Items in the collection file were written without a TTL, so they are reported as never expiring.
+func (h *ConnectionHandler) handleCollectionItemTTL(r io.Reader, conn net.Conn) {
+	collectionName, key, err := protocol.ReadCollectionItemTTLCommand(r)
+	if err != nil {
+		slog.Error("Failed to read COLLECTION_ITEM_TTL command payload", "error", err, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid COLLECTION_ITEM_TTL command format", nil)
+		return
+	}
+	if collectionName == "" || key == "" {
+		protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name or key cannot be empty", nil)
+		return
+	}
+	if !h.hasPermission(collectionName, globalconst.PermissionRead) {
+		slog.Warn("Unauthorized collection item TTL attempt", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have read permission for collection '%s'", collectionName), nil)
+		return
+	}
+	if !h.CollectionManager.CollectionExists(collectionName) {
+		protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist.", collectionName), nil)
+		return
+	}
+	recordCollectionRead(collectionName)
+
+	remaining, hasExpiry, found := h.CollectionManager.GetCollection(collectionName).TTL(key)
+	if !found {
+		foundInCold, err := coldItemExists(collectionName, key)
+		if err != nil {
+			slog.Error("Failed to check key in cold storage", "collection", collectionName, "key", key, "error", err)
+			protocol.WriteResponse(conn, protocol.StatusError, "Internal server error during TTL check.", nil)
+			return
+		}
+		if !foundInCold {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Key '%s' not found in collection '%s'", key, collectionName), nil)
+			return
+		}
+	}
+
+	ttl := newItemTTL(remaining, hasExpiry)
+	responseData, _ := json.Marshal(ttl)
+	if !hasExpiry {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Key '%s' in collection '%s' does not expire.", key, collectionName), responseData)
+		return
+	}
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Key '%s' in collection '%s' expires in %d seconds.", key, collectionName, ttl.TTLSeconds), responseData)
+}
+
+// HandleCollectionItemTouch processes the CmdCollectionItemTouch command. It is a write operation.
+// The item's TTL restarts from now without its value being rewritten; a zero TTL removes the expiry.
+// A cold item given a TTL is loaded back into memory, where expiry is tracked.
+func (h *ConnectionHandler) HandleCollectionItemTouch(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	collectionName, key, ttl, err := protocol.ReadCollectionItemTouchCommand(r)
+	if err != nil {
+		slog.Error("Failed to read TOUCH_COLLECTION_ITEM command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid TOUCH_COLLECTION_ITEM command format", nil)
+		}
+		return
+	}
+
+	if conn != nil {
+		if collectionName == "" || key == "" {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name or key cannot be empty", nil)
+			return
+		}
+		if ttl < 0 {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "TTL cannot be negative. Use 0 to remove the expiry.", nil)
+			return
+		}
+		if h.CurrentTransactionID != "" {
+			protocol.WriteResponse(conn, protocol.StatusError, "ERROR: TTLs cannot be changed inside a transaction.", nil)
+			return
+		}
+		if !h.hasPermission(collectionName, globalconst.PermissionWrite) {
+			slog.Warn("Unauthorized collection item touch attempt", "user", h.AuthenticatedUser, "collection", collectionName, "key", key)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have write permission for collection '%s'", collectionName), nil)
+			return
+		}
+		if !h.CollectionManager.CollectionExists(collectionName) {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist.", collectionName), nil)
+			return
+		}
+		recordCollectionWrite(collectionName)
+	}
+
+	colStore := h.CollectionManager.GetCollection(collectionName)
+	found, err := colStore.Touch(key, ttl)
+	if err == nil && !found {
+		var foundInCold bool
+		foundInCold, err = coldItemExists(collectionName, key)
+		if err == nil && foundInCold {
+			found = true
+			// Cold items never expire, so only a new TTL needs the item in memory.
+			if ttl > 0 {
+				promoteColdItem(h.CollectionManager, collectionName, key)
+				found, err = colStore.Touch(key, ttl)
+			}
+		}
+	}
+	if err != nil {
+		slog.Error("Failed to touch item", "collection", collectionName, "key", key, "error", err)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusError, "ERROR: "+err.Error(), nil)
+		}
+		return
+	}
+	if !found {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Key '%s' not found in collection '%s'", key, collectionName), nil)
+		}
+		return
+	}
+
+	slog.Info("Item TTL touched", "user", h.AuthenticatedUser, "collection", collectionName, "key", key, "ttl", ttl.String())
+	if conn != nil {
+		responseData, _ := json.Marshal(newItemTTL(ttl, ttl > 0))
+		if ttl == 0 {
+			protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Key '%s' in collection '%s' no longer expires.", key, collectionName), responseData)
+			return
+		}
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Key '%s' in collection '%s' now expires in %s.", key, collectionName, ttl), responseData)
+	}
+}
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"net"
+)
+
+// checkUniqueIndexes checks writes to a collection against its unique indexes, as
+// store.CollectionManager.CheckUniqueIndexes does. If they pass, the indexes stay locked and the
+// caller must call unlock once its writes are applied; otherwise it answers StatusConflict (or
+// StatusError if the check failed) and returns false. Writes inside a transaction are checked
+// when it commits. Writes replayed from the WAL (conn is nil) are not checked: only writes that
+// passed are logged.
+func (h *ConnectionHandler) checkUniqueIndexes(conn net.Conn, collectionName string, patches bool, writes map[string]map[string]any) (unlock func(), ok bool) {
+	if conn == nil || h.CurrentTransactionID != "" {
+		return func() {}, true
+	}
+	unlock = h.CollectionManager.LockUniqueIndexes(collectionName)
+	err := h.CollectionManager.CheckUniqueIndexes(collectionName, writes, patches, false)
+	if err == nil {
+		return unlock, true
+	}
+	unlock()
+
+	var violation *store.UniqueViolationError
+	if errors.As(err, &violation) {
+		slog.Debug("Write rejected by unique index", "user", h.AuthenticatedUser, "collection", collectionName, "field", violation.Field, "key", violation.Key)
+		protocol.WriteResponse(conn, protocol.StatusConflict, fmt.Sprintf("CONFLICT: %v", err), nil)
+		return nil, false
+	}
+	slog.Error("Failed to check unique indexes", "collection", collectionName, "error", err)
+	protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("ERROR: %v", err), nil)
+	return nil, false
+}
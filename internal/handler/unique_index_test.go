@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"io"
+	"memory-tools/internal/protocol"
+	"testing"
+)
+
+func TestUniqueIndexConflictsAreNotReplayed(t *testing.T) {
+	env := newTestEnv(t)
+	conn, walPath := env.serveLogged()
+	set := func(key, value string) func(io.Writer) error {
+		return func(w io.Writer) error {
+			return protocol.WriteCollectionItemSetCommand(w, "users", key, []byte(value), 0)
+		}
+	}
+	expectStatus(t, send(t, conn, func(w io.Writer) error { return protocol.WriteCollectionCreateCommand(w, "users") }), protocol.StatusOk)
+	expectStatus(t, send(t, conn, func(w io.Writer) error {
+		return protocol.WriteCollectionIndexCreateWithOptionsCommand(w, "users", "email", []byte(`{"unique":true}`))
+	}), protocol.StatusOk)
+	expectStatus(t, send(t, conn, set("u1", `{"email":"ada@example.com"}`)), protocol.StatusOk)
+	expectStatus(t, send(t, conn, set("u2", `{"email":"ada@example.com"}`)), protocol.StatusConflict)
+
+	recovered := replayed(t, walPath)
+	if doc := recovered.storedDoc("users", "u1"); doc["email"] != "ada@example.com" {
+		t.Errorf("replayed u1 = %v, want the accepted document", doc)
+	}
+	if _, found := recovered.cm.GetCollection("users").Get("u2"); found {
+		t.Error("a write the unique index rejected was replayed")
+	}
+}
@@ -219,9 +219,10 @@ func (h *ConnectionHandler) HandleUserDelete(r io.Reader, conn net.Conn) {
 	}
 
 	sysCol.Delete(userKey)
+	revoked := deleteUserAPIKeys(sysCol, username)
 	h.CollectionManager.EnqueueSaveTask(globalconst.SystemCollectionName, sysCol)
 
-	slog.Info("User deleted successfully", "admin_user", h.AuthenticatedUser, "deleted_user", username)
+	slog.Info("User deleted successfully", "admin_user", h.AuthenticatedUser, "deleted_user", username, "revoked_api_keys", revoked)
 	if conn != nil {
 		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("User '%s' deleted successfully", username), nil)
 	}
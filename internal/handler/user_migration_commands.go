@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/protocol"
+	"net"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UserImportResult is the response payload of the IMPORT_USERS command.
+type UserImportResult struct {
+	Created []string          `json:"created"`
+	Updated []string          `json:"updated"`
+	Skipped map[string]string `json:"skipped"` // Key: username, Value: reason.
+}
+
+// handleExportUsers processes the CmdExportUsers command. It is restricted to root@localhost,
+// since the response carries every user's password hash.
+// Exporting does not modify data, so it is not logged to the WAL.
+func (h *ConnectionHandler) handleExportUsers(r io.Reader, conn net.Conn) {
+	if !h.IsRoot || !h.IsLocalhostConn {
+		slog.Warn("Unauthorized user export attempt", "user", h.AuthenticatedUser, "remote_addr", conn.RemoteAddr().String())
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Exporting users is a privileged operation for root@localhost.", nil)
+		return
+	}
+
+	sysCol := h.CollectionManager.GetCollection(globalconst.SystemCollectionName)
+	users := make([]UserInfo, 0)
+	for key, value := range sysCol.GetAll() {
+		if !strings.HasPrefix(key, globalconst.UserPrefix) {
+			continue
+		}
+		var userInfo UserInfo
+		if err := json.Unmarshal(value, &userInfo); err != nil {
+			slog.Warn("Skipping unreadable user record during export", "key", key, "error", err)
+			continue
+		}
+		users = append(users, userInfo)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+
+	responseData, err := json.Marshal(users)
+	if err != nil {
+		slog.Error("Failed to marshal exported users", "error", err)
+		protocol.WriteResponse(conn, protocol.StatusError, "Failed to marshal users", nil)
+		return
+	}
+
+	slog.Info("AUDIT: Users exported", "user", h.AuthenticatedUser, "remote_addr", conn.RemoteAddr().String(), "count", len(users))
+	protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: %d users exported.", len(users)), responseData)
+}
+
+// HandleImportUsers processes the CmdImportUsers command. It is a write operation.
+// Users are stored with their exported password hashes. Existing users are only replaced when
+// overwrite is set, and root accounts are never imported: each server keeps its own root.
+func (h *ConnectionHandler) HandleImportUsers(r io.Reader, conn net.Conn) {
+	remoteAddr := "recovery"
+	if conn != nil {
+		remoteAddr = conn.RemoteAddr().String()
+	}
+
+	overwrite, usersJSON, err := protocol.ReadImportUsersCommand(r)
+	if err != nil {
+		slog.Error("Failed to read IMPORT_USERS command payload", "error", err, "remote_addr", remoteAddr)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadCommand, "Invalid IMPORT_USERS command format", nil)
+		}
+		return
+	}
+
+	// Authorization is skipped during WAL recovery (conn is nil)
+	if conn != nil && (!h.IsRoot || !h.IsLocalhostConn) {
+		slog.Warn("Unauthorized user import attempt", "user", h.AuthenticatedUser, "remote_addr", remoteAddr)
+		protocol.WriteResponse(conn, protocol.StatusUnauthorized, "UNAUTHORIZED: Importing users is a privileged operation for root@localhost.", nil)
+		return
+	}
+
+	var users []UserInfo
+	if err := json.Unmarshal(usersJSON, &users); err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Invalid users JSON format. Must be an array of users as returned by EXPORT_USERS.", nil)
+		}
+		return
+	}
+
+	result := UserImportResult{Created: []string{}, Updated: []string{}, Skipped: make(map[string]string)}
+	sysCol := h.CollectionManager.GetCollection(globalconst.SystemCollectionName)
+	for _, user := range users {
+		if user.Username == "" {
+			continue
+		}
+		if user.IsRoot {
+			result.Skipped[user.Username] = "root users are not imported"
+			continue
+		}
+		if _, err := bcrypt.Cost([]byte(user.PasswordHash)); err != nil {
+			result.Skipped[user.Username] = "password hash is not a bcrypt hash"
+			continue
+		}
+
+		userKey := globalconst.UserPrefix + user.Username
+		existed := false
+		if existingData, found := sysCol.Get(userKey); found {
+			var existing UserInfo
+			json.Unmarshal(existingData, &existing)
+			if existing.IsRoot {
+				result.Skipped[user.Username] = "would replace a root user"
+				continue
+			}
+			if !overwrite {
+				result.Skipped[user.Username] = "already exists"
+				continue
+			}
+			existed = true
+		}
+
+		userBytes, err := json.Marshal(user)
+		if err != nil {
+			result.Skipped[user.Username] = "failed to serialize user data"
+			continue
+		}
+		sysCol.Set(userKey, userBytes, 0)
+		if existed {
+			result.Updated = append(result.Updated, user.Username)
+		} else {
+			result.Created = append(result.Created, user.Username)
+		}
+	}
+
+	if len(result.Created)+len(result.Updated) > 0 {
+		h.CollectionManager.EnqueueSaveTask(globalconst.SystemCollectionName, sysCol)
+	}
+
+	slog.Info("AUDIT: Users imported",
+		"user", h.AuthenticatedUser,
+		"remote_addr", remoteAddr,
+		"overwrite", overwrite,
+		"created", result.Created,
+		"updated", result.Updated,
+		"skipped", len(result.Skipped),
+	)
+	if conn != nil {
+		responseData, _ := json.Marshal(result)
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: %d users created, %d updated, %d skipped.", len(result.Created), len(result.Updated), len(result.Skipped)), responseData)
+	}
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"io"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/protocol"
+	"reflect"
+	"testing"
+)
+
+func TestUsersRoundTripBetweenServers(t *testing.T) {
+	source := newTestEnv(t)
+	admin := source.handler()
+	admin.IsLocalhostConn = true
+	for user, password := range map[string]string{"alice": "alice-secret", "bob": "bob-secret"} {
+		expectStatus(t, source.run(admin.HandleUserCreate, func(w io.Writer) error {
+			return protocol.WriteUserCreateCommand(w, user, password, []byte(`{"orders":"read"}`))
+		}), protocol.StatusOk)
+	}
+	resp := source.run(admin.handleExportUsers, protocol.WriteExportUsersCommand)
+	expectStatus(t, resp, protocol.StatusOk)
+	exported := resp.data
+
+	target := newTestEnv(t)
+	importer := target.handler()
+	importer.IsLocalhostConn = true
+	expectStatus(t, target.run(importer.HandleUserCreate, func(w io.Writer) error {
+		return protocol.WriteUserCreateCommand(w, "bob", "old-password", []byte(`{"*":"write"}`))
+	}), protocol.StatusOk)
+
+	importUsers := func(overwrite bool) UserImportResult {
+		t.Helper()
+		resp := target.run(importer.HandleImportUsers, func(w io.Writer) error {
+			return protocol.WriteImportUsersCommand(w, overwrite, exported)
+		})
+		expectStatus(t, resp, protocol.StatusOk)
+		var result UserImportResult
+		if err := json.Unmarshal(resp.data, &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+	login := func(user, password string) *ConnectionHandler {
+		t.Helper()
+		h := target.handler()
+		h.IsAuthenticated, h.IsRoot = false, false
+		expectStatus(t, target.run(h.handleAuthenticate, func(w io.Writer) error {
+			return protocol.WriteAuthenticateCommand(w, user, password)
+		}), protocol.StatusOk)
+		return h
+	}
+
+	result := importUsers(false)
+	if !reflect.DeepEqual(result.Created, []string{"alice"}) || result.Skipped["bob"] != "already exists" {
+		t.Fatalf("import without overwrite = %+v", result)
+	}
+	if h := login("alice", "alice-secret"); !h.hasPermission("orders", globalconst.PermissionRead) || h.hasPermission("orders", globalconst.PermissionWrite) {
+		t.Errorf("imported alice has the wrong permissions: %v", h.Permissions)
+	}
+	login("bob", "old-password")
+
+	result = importUsers(true)
+	if !reflect.DeepEqual(result.Updated, []string{"alice", "bob"}) {
+		t.Fatalf("import with overwrite = %+v", result)
+	}
+	login("bob", "bob-secret")
+
+	outsider := target.handler()
+	expectStatus(t, target.run(outsider.handleExportUsers, protocol.WriteExportUsersCommand), protocol.StatusUnauthorized)
+}
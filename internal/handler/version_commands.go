@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/persistence"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/store"
+	"net"
+	"time"
+)
+
+// VersionConflict is the response data of a write rejected because the stored document is not
+// at the version the client expected.
+type VersionConflict struct {
+	ExpectedVersion uint64 `json:"expected_version"`
+	CurrentVersion  uint64 `json:"current_version"`
+}
+
+// updateIfMatch runs an UPDATE_COLLECTION_ITEM command with an If-Match version: the patch is
+// merged like an update, but only if the stored document is still at the version the client read.
+func (h *ConnectionHandler) updateIfMatch(conn net.Conn, collectionName, key string, patchValue []byte, version uint64) {
+	h.writeIfMatch(conn, "update", collectionName, key, patchValue, version,
+		func(existing, patch map[string]any) map[string]any {
+			for k, v := range patch {
+				if !store.IsManagedField(k) {
+					existing[k] = v
+				}
+			}
+			return existing
+		},
+		func(condition func(doc map[string]any) bool) (bool, bool, error) {
+			return persistence.UpdateColdItemIf(collectionName, key, patchValue, condition)
+		})
+}
+
+// replaceIfMatch runs a REPLACE_COLLECTION_ITEM command with an If-Match version: the value
+// replaces the document like a replace, but only if the stored document is still at the version
+// the client read.
+func (h *ConnectionHandler) replaceIfMatch(conn net.Conn, collectionName, key string, value []byte, version uint64) {
+	h.writeIfMatch(conn, "replace", collectionName, key, value, version,
+		func(existing, newData map[string]any) map[string]any {
+			replacement := make(map[string]any, len(newData)+4)
+			for k, v := range newData {
+				if !store.IsManagedField(k) && k != globalconst.UPDATED_AT {
+					replacement[k] = v
+				}
+			}
+			replacement[globalconst.ID] = key
+			store.CopyCreationTime(replacement, existing)
+			return replacement
+		},
+		func(condition func(doc map[string]any) bool) (bool, bool, error) {
+			return persistence.ReplaceColdItemIf(collectionName, key, value, condition)
+		})
+}
+
+// writeIfMatch applies a versioned write to a document (hot, cold, or in a transaction). build
+// derives the new document from the stored one and the client's value; the write is rejected with
+// StatusConflict if the stored document is not at the expected version. For hot data the check and
+// the write happen under the shard lock; for cold data, applyCold runs them under the collection
+// file lock; inside a transaction the version is checked again during the prepare phase.
+func (h *ConnectionHandler) writeIfMatch(conn net.Conn, operation, collectionName, key string, value []byte, expected uint64,
+	build func(existing, value map[string]any) map[string]any,
+	applyCold func(condition func(doc map[string]any) bool) (found, applied bool, err error),
+) {
+	if conn != nil {
+		if collectionName == "" || key == "" || len(value) == 0 {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Collection name, key, or value cannot be empty", nil)
+			return
+		}
+		if !h.hasPermission(collectionName, globalconst.PermissionWrite) {
+			slog.Warn("Unauthorized versioned write attempt", "user", h.AuthenticatedUser, "collection", collectionName, "key", key, "operation", operation)
+			protocol.WriteResponse(conn, protocol.StatusUnauthorized, fmt.Sprintf("UNAUTHORIZED: You do not have write permission for collection '%s'", collectionName), nil)
+			return
+		}
+		if !h.CollectionManager.CollectionExists(collectionName) {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Collection '%s' does not exist.", collectionName), nil)
+			return
+		}
+		recordCollectionWrite(collectionName)
+	}
+
+	var valueData map[string]any
+	if err := json.Unmarshal(value, &valueData); err != nil || valueData == nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusBadRequest, "Invalid value. Must be a JSON object.", nil)
+		}
+		return
+	}
+	if !h.validateDocuments(conn, collectionName, operation == "update", valueData) {
+		return
+	}
+
+	var currentVersion uint64
+	malformed := false
+	// buildIfMatches checks the version of a stored value and returns the new value.
+	buildIfMatches := func(current []byte, touch bool) ([]byte, bool) {
+		var existingData map[string]any
+		if err := json.Unmarshal(current, &existingData); err != nil {
+			malformed = true
+			return nil, false
+		}
+		currentVersion = store.VersionOf(existingData)
+		if currentVersion != expected {
+			return nil, false
+		}
+		newData := build(existingData, valueData)
+		if touch {
+			newData[globalconst.UPDATED_AT] = time.Now().UTC().Format(time.RFC3339)
+			store.SetVersion(newData, currentVersion+1)
+		}
+		newValue, err := json.Marshal(newData)
+		if err != nil {
+			malformed = true
+			return nil, false
+		}
+		return newValue, true
+	}
+	conflict := func() {
+		slog.Debug("Versioned write rejected", "collection", collectionName, "key", key, "expected_version", expected, "current_version", currentVersion)
+		if conn != nil {
+			data, _ := json.Marshal(VersionConflict{ExpectedVersion: expected, CurrentVersion: currentVersion})
+			protocol.WriteResponse(conn, protocol.StatusConflict, fmt.Sprintf("CONFLICT: Key '%s' is at version %d, not %d.", key, currentVersion, expected), data)
+		}
+	}
+
+	colStore := h.CollectionManager.GetCollection(collectionName)
+
+	// Transactional logic: the version is checked now and again during the prepare phase of the commit.
+	if h.CurrentTransactionID != "" {
+		existingValue, found := colStore.Get(key)
+		if !found {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusNotFound, "Item not found in memory. Versioned writes inside a transaction currently only support hot data.", nil)
+			}
+			return
+		}
+		finalValue, ok := buildIfMatches(existingValue, false)
+		if !ok && malformed {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusError, "Failed to unmarshal existing document. Cannot apply the write.", nil)
+			}
+			return
+		}
+		if !ok {
+			conflict()
+			return
+		}
+		op := store.WriteOperation{
+			Collection: collectionName,
+			Key:        key,
+			Value:      finalValue,
+			OpType:     store.OpTypeUpdate,
+			Precondition: func(current []byte) bool {
+				var currentData map[string]any
+				if current == nil || json.Unmarshal(current, &currentData) != nil {
+					return false
+				}
+				return store.VersionOf(currentData) == expected
+			},
+		}
+		if err := h.TransactionManager.RecordWrite(h.CurrentTransactionID, op); err != nil {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusError, fmt.Sprintf("ERROR: Failed to record versioned %s in transaction: %v", operation, err), nil)
+			}
+			return
+		}
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Versioned %s queued in transaction.", operation), finalValue)
+		}
+		return
+	}
+
+	// Non-transactional logic (hot/cold)
+	var newValue []byte
+	found, applied, err := colStore.UpdateIf(key, func(current []byte) ([]byte, bool) {
+		var ok bool
+		newValue, ok = buildIfMatches(current, true)
+		return newValue, ok
+	})
+	if err != nil {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusError, "ERROR: "+err.Error(), nil)
+		}
+		return
+	}
+	if found {
+		if malformed {
+			if conn != nil {
+				protocol.WriteResponse(conn, protocol.StatusError, "Failed to unmarshal existing document. Cannot apply the write.", nil)
+			}
+			return
+		}
+		if !applied {
+			conflict()
+			return
+		}
+		h.CollectionManager.EnqueueSaveTask(collectionName, colStore)
+		publishChange(collectionName, changeOpUpdate, key, newValue)
+		slog.Info("Item written with version check (hot)", "user", h.AuthenticatedUser, "collection", collectionName, "key", key, "operation", operation, "version", expected+1)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Key '%s' %sd in collection '%s' (version %d)", key, operation, collectionName, expected+1), newValue)
+		}
+		return
+	}
+
+	fileLock := h.CollectionManager.GetFileLock(collectionName)
+	fileLock.Lock()
+	found, applied, err = applyCold(func(doc map[string]any) bool {
+		currentVersion = store.VersionOf(doc)
+		return currentVersion == expected
+	})
+	fileLock.Unlock()
+
+	if err != nil {
+		slog.Error("Failed to apply versioned write to cold item on disk", "collection", collectionName, "key", key, "error", err)
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusError, "Failed to update item on disk", nil)
+		}
+		return
+	}
+	if !found {
+		if conn != nil {
+			protocol.WriteResponse(conn, protocol.StatusNotFound, fmt.Sprintf("NOT FOUND: Key '%s' not found in collection '%s'", key, collectionName), nil)
+		}
+		return
+	}
+	if !applied {
+		conflict()
+		return
+	}
+	publishChange(collectionName, changeOpUpdate, key, nil)
+	slog.Info("Item written with version check (cold)", "user", h.AuthenticatedUser, "collection", collectionName, "key", key, "operation", operation, "version", expected+1)
+	if conn != nil {
+		protocol.WriteResponse(conn, protocol.StatusOk, fmt.Sprintf("OK: Cold item '%s' %sd in collection '%s' (version %d)", key, operation, collectionName, expected+1), nil)
+	}
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"bytes"
+	"log/slog"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/wal"
+	"net"
+	"slices"
+)
+
+// walLoggedConn holds back the response of a write command until the command is logged to the
+// WAL. A command is logged only once it has run and succeeded, so recovery and the change feed
+// never see a write the server rejected, and its client is answered only once it is durable.
+type walLoggedConn struct {
+	net.Conn
+	response bytes.Buffer
+}
+
+func (c *walLoggedConn) Write(p []byte) (int, error) {
+	return c.response.Write(p)
+}
+
+// succeeded reports whether the command was answered with StatusOk.
+func (c *walLoggedConn) succeeded() bool {
+	response := c.response.Bytes()
+	return len(response) > 0 && protocol.ResponseStatus(response[0]) == protocol.StatusOk
+}
+
+// logWrite logs a write command that has run, given the transaction open and the number of
+// operations it had buffered before the command, and then sends the response held back by
+// logged. A command that a transaction queued is kept until the transaction commits, and
+// dropped if it rolls back; a failed command is never logged.
+func (h *ConnectionHandler) logWrite(entry wal.WalEntry, logged *walLoggedConn, txID string, txWrites int) {
+	var entries []wal.WalEntry
+	switch {
+	case !logged.succeeded():
+	case entry.CommandType == protocol.CmdCommit:
+		if h.queuedWalTx == txID {
+			entries = h.queuedWalEntries
+		}
+		entries = append(entries, entry)
+	case txID != "" && h.TransactionManager.WriteCount(txID) > txWrites:
+		if h.queuedWalTx != txID {
+			h.queuedWalEntries, h.queuedWalTx = nil, txID
+		}
+		// The payload is pooled memory, released once this command is answered.
+		entry.Payload = slices.Clone(entry.Payload)
+		h.queuedWalEntries = append(h.queuedWalEntries, entry)
+	default:
+		entries = []wal.WalEntry{entry}
+	}
+
+	if len(entries) > 0 {
+		if err := h.Wal.WriteBatch(entries); err != nil {
+			slog.Error("CRITICAL: Failed to write to WAL", "error", err, "command_type", entry.CommandType, "entries", len(entries))
+			protocol.WriteResponse(logged.Conn, protocol.StatusError, "Internal server error: the command ran but could not be persisted", nil)
+			return
+		}
+	}
+	logged.Conn.Write(logged.response.Bytes())
+}
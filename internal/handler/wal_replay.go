@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"bytes"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/wal"
+)
+
+// ApplyWalEntry replays a WAL entry through the handler of its command, as done on startup to
+// recover the writes made since the last snapshot and on restore to apply incremental backups.
+// Handlers skip authorization and write no response, as conn is nil. Entries of commands that do
+// not change data state are ignored.
+func (h *ConnectionHandler) ApplyWalEntry(entry wal.WalEntry) {
+	r := bytes.NewReader(entry.Payload)
+	switch entry.CommandType {
+	case protocol.CmdSet:
+		h.HandleMainStoreSet(r, nil)
+	case protocol.CmdCollectionCreate:
+		h.HandleCollectionCreate(r, nil)
+	case protocol.CmdCollectionDelete:
+		h.HandleCollectionDelete(r, nil)
+	case protocol.CmdCollectionIndexCreate:
+		h.HandleCollectionIndexCreate(r, nil)
+	case protocol.CmdCollectionIndexCreateWithOptions:
+		h.HandleCollectionIndexCreateWithOptions(r, nil)
+	case protocol.CmdCollectionIndexDelete:
+		h.HandleCollectionIndexDelete(r, nil)
+	case protocol.CmdCollectionIndexDisable:
+		h.HandleCollectionIndexDisable(r, nil)
+	case protocol.CmdCollectionIndexEnable:
+		h.HandleCollectionIndexEnable(r, nil)
+	case protocol.CmdCollectionSetCompression:
+		h.HandleCollectionSetCompression(r, nil)
+	case protocol.CmdCollectionSetFileCompression:
+		h.HandleCollectionSetFileCompression(r, nil)
+	case protocol.CmdCollectionRename:
+		h.HandleCollectionRename(r, nil)
+	case protocol.CmdCollectionCopy:
+		h.HandleCollectionCopy(r, nil)
+	case protocol.CmdCollectionTruncate:
+		h.HandleCollectionTruncate(r, nil)
+	case protocol.CmdCollectionSetSchema:
+		h.HandleCollectionSetSchema(r, nil)
+	case protocol.CmdCollectionSetDefaultTTL:
+		h.HandleCollectionSetDefaultTTL(r, nil)
+	case protocol.CmdCollectionItemSet:
+		h.HandleCollectionItemSet(r, nil)
+	case protocol.CmdCollectionItemSetMany:
+		h.HandleCollectionItemSetMany(r, nil)
+	case protocol.CmdCollectionItemDelete:
+		h.HandleCollectionItemDelete(r, nil)
+	case protocol.CmdCollectionItemDeleteMany:
+		h.HandleCollectionItemDeleteMany(r, nil)
+	case protocol.CmdCollectionItemGetAndDelete:
+		h.HandleCollectionItemGetAndDelete(r, nil)
+	case protocol.CmdCollectionItemUpdate:
+		h.HandleCollectionItemUpdate(r, nil)
+	case protocol.CmdCollectionItemUpsert:
+		h.HandleCollectionItemUpsert(r, nil)
+	case protocol.CmdCollectionItemUpdateIf:
+		h.HandleCollectionItemUpdateIf(r, nil)
+	case protocol.CmdCollectionItemReplace:
+		h.HandleCollectionItemReplace(r, nil)
+	case protocol.CmdCollectionItemTouch:
+		h.HandleCollectionItemTouch(r, nil)
+	case protocol.CmdCollectionItemUpdateMany:
+		h.HandleCollectionItemUpdateMany(r, nil)
+	case protocol.CmdCollectionItemMergeByQuery:
+		h.HandleCollectionItemMergeByQuery(r, nil)
+	case protocol.CmdCollectionItemIncrement:
+		h.HandleCollectionItemIncrement(r, nil)
+	case protocol.CmdChangeUserPassword:
+		h.HandleChangeUserPassword(r, nil)
+	case protocol.CmdUserCreate:
+		h.HandleUserCreate(r, nil)
+	case protocol.CmdUserUpdate:
+		h.HandleUserUpdate(r, nil)
+	case protocol.CmdUserDelete:
+		h.HandleUserDelete(r, nil)
+	case protocol.CmdImportUsers:
+		h.HandleImportUsers(r, nil)
+	case protocol.CmdAPIKeyPut:
+		h.HandleAPIKeyPut(r, nil)
+	case protocol.CmdAPIKeyRevoke:
+		h.HandleAPIKeyRevoke(r, nil)
+	case protocol.CmdRoleCreate:
+		h.HandleRoleCreate(r, nil)
+	case protocol.CmdRoleUpdate:
+		h.HandleRoleUpdate(r, nil)
+	case protocol.CmdRoleDelete:
+		h.HandleRoleDelete(r, nil)
+	case protocol.CmdUserSetRoles:
+		h.HandleUserSetRoles(r, nil)
+	case protocol.CmdProcedurePut:
+		h.HandleProcedurePut(r, nil)
+	case protocol.CmdProcedureDelete:
+		h.HandleProcedureDelete(r, nil)
+	case protocol.CmdCallProcedure:
+		h.HandleCallProcedure(r, nil)
+	case protocol.CmdCommit:
+		h.HandleCommit(r, nil)
+	case protocol.CmdRestore:
+		h.HandleRestore(r, nil)
+	case protocol.CmdRestoreCollection:
+		h.HandleRestoreCollection(r, nil)
+	}
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/wal"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetManyBatchIsReplayedFromOneWalEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wal.log")
+	w, err := wal.New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Entries are logged as HandleConnection does: the command type and its raw payload.
+	for _, write := range []func(io.Writer) error{
+		func(w io.Writer) error { return protocol.WriteCollectionCreateCommand(w, "items") },
+		func(w io.Writer) error {
+			return protocol.WriteCollectionItemSetManyCommand(w, "items", []byte(`[{"_id":"a","n":1},{"_id":"b","n":2},{"_id":"c","n":3}]`))
+		},
+	} {
+		var cmd bytes.Buffer
+		if err := write(&cmd); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Write(wal.WalEntry{CommandType: protocol.CommandType(cmd.Bytes()[0]), Payload: cmd.Bytes()[1:]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	env := newTestEnv(t)
+	h := env.handler()
+	entries, err := wal.Replay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for entry := range entries {
+		h.ApplyWalEntry(entry)
+	}
+	for key, n := range map[string]float64{"a": 1, "b": 2, "c": 3} {
+		if doc := env.storedDoc("items", key); doc["n"] != n {
+			t.Errorf("replayed %s = %v, want n = %v", key, doc, n)
+		}
+	}
+	if size := env.cm.GetCollection("items").Size(); size != 3 {
+		t.Errorf("collection has %d items after replay, want 3", size)
+	}
+}
+
+// serveLogged serves a root connection that logs its writes to a new WAL, as the server does,
+// and returns it with the path of the WAL.
+func (e *testEnv) serveLogged() (net.Conn, string) {
+	e.t.Helper()
+	path := filepath.Join(e.t.TempDir(), "wal.log")
+	w, err := wal.New(path)
+	if err != nil {
+		e.t.Fatal(err)
+	}
+	e.t.Cleanup(func() { w.Close() })
+	h := e.handler()
+	h.Wal = w
+	return e.serve(h), path
+}
+
+// replayed returns a new server state recovered from the WAL at path, as on startup.
+func replayed(t *testing.T, path string) *testEnv {
+	t.Helper()
+	env := newTestEnv(t)
+	h := env.handler()
+	entries, err := wal.Replay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for entry := range entries {
+		h.ApplyWalEntry(entry)
+	}
+	return env
+}
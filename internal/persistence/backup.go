@@ -1,18 +1,72 @@
 package persistence
 
 import (
+	"bufio"
 	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
 	"memory-tools/internal/globalconst"
 	"memory-tools/internal/store"
+	"memory-tools/internal/wal"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 )
 
+var (
+	// ErrBackupNotFound is returned by DeleteBackup when no backup has the given name.
+	ErrBackupNotFound = errors.New("backup not found")
+	// ErrInvalidBackupName is returned by DeleteBackup for a name that is not a plain directory name.
+	ErrInvalidBackupName = errors.New("invalid backup name")
+	// ErrBackupInUse is returned by DeleteBackup for a backup that an incremental backup builds on.
+	ErrBackupInUse = errors.New("backup is the parent of an incremental backup")
+)
+
+// Backup types, as recorded in a backup's manifest.
+const (
+	BackupTypeFull        = "full"
+	BackupTypeIncremental = "incremental"
+)
+
+// backupManifestFile names the file describing a backup inside its directory. Backups written
+// before incremental backups existed have none and are full backups.
+const backupManifestFile = "backup.json"
+
+// backupWalDir names the directory of an incremental backup holding its WAL files.
+const backupWalDir = "wal"
+
+// backupManifest describes a backup and, for an incremental one, the backup it builds on.
+type backupManifest struct {
+	Type      string `json:"type"`
+	CreatedAt string `json:"created_at"`
+	// Parent is the backup an incremental backup continues from, full or incremental.
+	Parent string `json:"parent,omitempty"`
+	// Chain counts the incremental backups since the full backup, this one included.
+	Chain    int                `json:"chain"`
+	WalFiles []wal.ArchivedFile `json:"wal_files,omitempty"`
+	// Files lists the data files of a full backup with their checksums, by path relative to the
+	// backup directory. Backups written before checksums were recorded have none.
+	Files []wal.ArchivedFile `json:"files,omitempty"`
+}
+
+// BackupInfo describes one backup directory, as listed by BACKUP_LIST.
+type BackupInfo struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`             // BackupTypeFull or BackupTypeIncremental.
+	Parent      string `json:"parent,omitempty"` // The backup an incremental backup builds on.
+	CreatedAt   string `json:"created_at"`       // RFC3339, from the directory's modification time.
+	SizeBytes   int64  `json:"size_bytes"`
+	Collections int    `json:"collections"`
+	Verified    bool   `json:"verified"`
+	Error       string `json:"error,omitempty"` // Why the backup failed verification.
+}
+
 // BackupManager handles backup operations
 type BackupManager struct {
 	mainStore       store.DataStore
@@ -24,6 +78,11 @@ type BackupManager struct {
 	wg              sync.WaitGroup
 	backupInterval  time.Duration
 	backupRetention time.Duration
+
+	// wal is set when backups are incremental: a full backup is followed by backups holding only
+	// the WAL segments archived since the previous one, up to fullEvery backups in a chain.
+	wal       *wal.WAL
+	fullEvery int
 }
 
 // NewBackupManager creates a new instance of the backup manager
@@ -37,6 +96,16 @@ func NewBackupManager(mainStore store.DataStore, colManager *store.CollectionMan
 	}
 }
 
+// EnableIncremental makes backups incremental. Every fullEvery-th backup, and the first one, is a
+// full backup; the others only hold the WAL segments sealed since the previous backup, which w
+// must archive. A fullEvery of 1 or less keeps every backup full.
+func (bm *BackupManager) EnableIncremental(w *wal.WAL, fullEvery int) {
+	bm.backupLock.Lock()
+	defer bm.backupLock.Unlock()
+	bm.wal = w
+	bm.fullEvery = fullEvery
+}
+
 // Start initiates the periodic backup service
 func (bm *BackupManager) Start() {
 	if err := os.MkdirAll(globalconst.BackupsDirName, 0755); err != nil {
@@ -80,7 +149,8 @@ func (bm *BackupManager) runPeriodicBackups() {
 	}
 }
 
-// PerformBackup executes a full backup of all data
+// PerformBackup backs up all data: a full backup, or an incremental one holding the WAL written
+// since the previous backup when incremental backups are enabled and the chain can go on.
 func (bm *BackupManager) PerformBackup() error {
 	bm.backupLock.Lock()
 	defer bm.backupLock.Unlock()
@@ -101,6 +171,21 @@ func (bm *BackupManager) PerformBackup() error {
 		return fmt.Errorf("error creating backup directory: %w", err)
 	}
 
+	if parent, chain := bm.incrementalParent(); parent != "" {
+		return bm.performIncrementalBackup(backupPath, parent, chain)
+	}
+
+	// Entries sealed from here on go to the next incremental backup. Those written while the
+	// snapshot is taken may be in both, and are applied again on restore, as in a checkpoint.
+	chainable := false
+	if bm.wal != nil {
+		if err := bm.wal.ResetArchive(); err != nil {
+			slog.Error("Failed to reset WAL archive; the next backup will be a full one", "error", err)
+		} else {
+			chainable = true
+		}
+	}
+
 	if err := bm.backupMainStore(backupPath); err != nil {
 		os.RemoveAll(backupPath)
 		return fmt.Errorf("error in main store backup: %w", err)
@@ -111,20 +196,178 @@ func (bm *BackupManager) PerformBackup() error {
 		return fmt.Errorf("error in collections backup: %w", err)
 	}
 
+	files, err := describeBackupFiles(backupPath)
+	if err == nil {
+		err = writeBackupManifest(backupPath, backupManifest{Type: BackupTypeFull, Files: files})
+	}
+	if err != nil {
+		os.RemoveAll(backupPath)
+		return err
+	}
+	verifyErr := bm.verifyBackup(backupPath)
+	if err := publishBackup(backupPath); err != nil {
+		return err
+	}
+	if chainable {
+		if err := bm.wal.SetArchiveHead(backupTime); err != nil {
+			slog.Error("Failed to start incremental backup chain", "error", err)
+		}
+	}
+
 	go bm.cleanOldBackups()
 
 	bm.lastBackupTime = time.Now()
 	slog.Info("Backup completed successfully", "path", backupPath)
 
-	if err := bm.verifyBackup(backupPath); err != nil {
-		slog.Error("CRITICAL: Backup verification failed", "path", backupPath, "error", err)
-		return fmt.Errorf("backup verification failed: %w", err)
+	if verifyErr != nil {
+		slog.Error("CRITICAL: Backup verification failed", "path", backupPath, "error", verifyErr)
+		return fmt.Errorf("backup verification failed: %w", verifyErr)
 	}
 
 	slog.Debug("Backup verified successfully", "path", backupPath)
 	return nil
 }
 
+// incrementalParent returns the backup the next backup can build on, and the position of the next
+// backup in its chain; the parent is "" if the next backup has to be a full one.
+func (bm *BackupManager) incrementalParent() (string, int) {
+	if bm.wal == nil || bm.fullEvery <= 1 {
+		return "", 0
+	}
+	head := bm.wal.ArchiveHead()
+	if head == "" {
+		return "", 0
+	}
+	manifest, err := readDestinationManifest(currentDestination(), head)
+	if err != nil {
+		slog.Warn("Previous backup unavailable, starting a new backup chain", "backup", head, "error", err)
+		return "", 0
+	}
+	if manifest.Chain+1 >= bm.fullEvery {
+		return "", 0
+	}
+	return head, manifest.Chain + 1
+}
+
+// performIncrementalBackup moves the archived WAL segments into a new backup that builds on
+// parent. If that fails, the segments are lost to the chain, so the next backup is a full one.
+func (bm *BackupManager) performIncrementalBackup(backupPath, parent string, chain int) error {
+	files, err := bm.wal.CollectArchive(filepath.Join(backupPath, backupWalDir))
+	if err == nil {
+		err = writeBackupManifest(backupPath, backupManifest{Type: BackupTypeIncremental, Parent: parent, Chain: chain, WalFiles: files})
+	}
+	if err != nil {
+		os.RemoveAll(backupPath)
+		bm.wal.ClearArchiveHead()
+		return fmt.Errorf("error in incremental backup: %w", err)
+	}
+
+	_, _, verifyErr := checkBackupFiles(backupPath)
+	if err := publishBackup(backupPath); err != nil {
+		bm.wal.ClearArchiveHead()
+		return err
+	}
+
+	backupName := filepath.Base(backupPath)
+	if err := bm.wal.SetArchiveHead(backupName); err != nil {
+		slog.Error("Failed to extend incremental backup chain", "error", err)
+	}
+	go bm.cleanOldBackups()
+
+	bm.lastBackupTime = time.Now()
+	slog.Info("Incremental backup completed successfully", "path", backupPath, "parent", parent, "chain", chain, "wal_files", len(files))
+
+	if verifyErr != nil {
+		slog.Error("CRITICAL: Backup verification failed", "path", backupPath, "error", verifyErr)
+		return fmt.Errorf("backup verification failed: %w", verifyErr)
+	}
+	return nil
+}
+
+// publishBackup uploads a backup written to the local backups directory to a remote destination,
+// if one is set, and then removes the local copy. A failed upload is removed from the destination,
+// as far as possible, and the backup is lost.
+func publishBackup(backupPath string) error {
+	dest := currentDestination()
+	if isLocalDestination(dest) {
+		return nil
+	}
+	err := uploadBackup(dest, backupPath)
+	os.RemoveAll(backupPath)
+	if err != nil {
+		if cleanupErr := dest.DeleteAll(filepath.Base(backupPath) + "/"); cleanupErr != nil {
+			slog.Error("Failed to remove incomplete backup upload", "backup_name", filepath.Base(backupPath), "error", cleanupErr)
+		}
+		return fmt.Errorf("error uploading backup: %w", err)
+	}
+	return nil
+}
+
+// describeBackupFiles returns the size and checksum of every data file of a backup.
+func describeBackupFiles(backupPath string) ([]wal.ArchivedFile, error) {
+	var files []wal.ArchivedFile
+	err := filepath.WalkDir(backupPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || entry.Name() == backupManifestFile {
+			return err
+		}
+		file, err := wal.DescribeFile(filePath)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(backupPath, filePath)
+		if err != nil {
+			return err
+		}
+		file.Name = filepath.ToSlash(rel)
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error checksumming backup files: %w", err)
+	}
+	return files, nil
+}
+
+// writeBackupManifest writes the manifest of a backup once all its files are written.
+func writeBackupManifest(backupPath string, manifest backupManifest) error {
+	manifest.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding backup manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(backupPath, backupManifestFile), data, 0644); err != nil {
+		return fmt.Errorf("error writing backup manifest: %w", err)
+	}
+	return nil
+}
+
+// readBackupManifest reads the manifest of a backup. A backup without one is a full backup
+// written before manifests existed.
+func readBackupManifest(backupPath string) (backupManifest, error) {
+	if _, err := os.Stat(backupPath); err != nil {
+		return backupManifest{}, err
+	}
+	data, err := os.ReadFile(filepath.Join(backupPath, backupManifestFile))
+	if os.IsNotExist(err) {
+		return backupManifest{Type: BackupTypeFull}, nil
+	} else if err != nil {
+		return backupManifest{}, fmt.Errorf("error reading backup manifest: %w", err)
+	}
+	return decodeBackupManifest(data)
+}
+
+// decodeBackupManifest parses the contents of a backup manifest.
+func decodeBackupManifest(data []byte) (backupManifest, error) {
+	var manifest backupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return backupManifest{}, fmt.Errorf("error decoding backup manifest: %w", err)
+	}
+	if manifest.Type != BackupTypeFull && manifest.Type != BackupTypeIncremental {
+		return backupManifest{}, fmt.Errorf("unknown backup type '%s'", manifest.Type)
+	}
+	return manifest, nil
+}
+
 // backupMainStore performs the backup of the main store
 func (bm *BackupManager) backupMainStore(backupPath string) error {
 	snapshot := bm.mainStore.GetAll()
@@ -159,7 +402,7 @@ func (bm *BackupManager) backupCollections(backupPath string) error {
 	for _, colName := range collectionNames {
 		colStore := bm.colManager.GetCollection(colName)
 		data := colStore.GetAll()
-		indexedFields := colStore.ListIndexes()
+		indexedFields := persistedIndexEntries(colStore)
 		backupFile := filepath.Join(collectionsBackupDir, colName+".mtdb")
 
 		slog.Debug("Backing up collection", "collection", colName, "indexes", len(indexedFields), "items", len(data))
@@ -194,7 +437,7 @@ func (bm *BackupManager) backupCollections(backupPath string) error {
 	return nil
 }
 
-// saveBackupFile saves a backup file securely
+// saveBackupFile saves a backup file securely, encrypted with the current key if one is configured.
 func (bm *BackupManager) saveBackupFile(path string, writeFunc func(io.Writer) error) error {
 	tempPath := path + ".tmp"
 	file, err := os.Create(tempPath)
@@ -202,7 +445,24 @@ func (bm *BackupManager) saveBackupFile(path string, writeFunc func(io.Writer) e
 		return fmt.Errorf("error creating temporary file: %w", err)
 	}
 
-	if err := writeFunc(file); err != nil {
+	bw := bufio.NewWriter(file)
+	w, closeEncryption, err := encryptingWriter(bw)
+	if err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return fmt.Errorf("error starting encryption: %w", err)
+	}
+	if err := writeFunc(w); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return fmt.Errorf("error writing data: %w", err)
+	}
+	if err := closeEncryption(); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return fmt.Errorf("error finishing encryption: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
 		file.Close()
 		os.Remove(tempPath)
 		return fmt.Errorf("error writing data: %w", err)
@@ -227,30 +487,66 @@ func (bm *BackupManager) saveBackupFile(path string, writeFunc func(io.Writer) e
 
 // verifyBackup verifies the integrity of the backup
 func (bm *BackupManager) verifyBackup(backupPath string) error {
+	collections, _, err := checkBackupFiles(backupPath)
+	if err != nil {
+		return err
+	}
+	if collections != len(bm.colManager.ListCollections()) {
+		slog.Warn("Backup verification mismatch", "backed_up_collections", collections, "active_collections", len(bm.colManager.ListCollections()))
+	}
+	return nil
+}
+
+// checkBackupFiles checks that a backup has its main file and that none of its files is empty,
+// which is never the case for a backup that was written completely, and that the files listed in
+// the manifest of a full backup match their checksums. For an incremental backup, it checks its
+// WAL files against the sizes and checksums of its manifest instead. It returns the number of
+// collection files and the total size of the backup.
+func checkBackupFiles(backupPath string) (collections int, sizeBytes int64, err error) {
+	manifest, err := readBackupManifest(backupPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	if manifest.Type == BackupTypeIncremental {
+		for _, expected := range manifest.WalFiles {
+			actual, err := wal.DescribeFile(filepath.Join(backupPath, backupWalDir, expected.Name))
+			if err != nil {
+				return 0, sizeBytes, err
+			}
+			if actual != expected {
+				return 0, sizeBytes, fmt.Errorf("WAL file '%s' does not match the backup manifest", expected.Name)
+			}
+			sizeBytes += actual.Size
+		}
+		return 0, sizeBytes, nil
+	}
+	for _, expected := range manifest.Files {
+		actual, err := wal.DescribeFile(filepath.Join(backupPath, filepath.FromSlash(expected.Name)))
+		if err != nil {
+			return 0, 0, err
+		}
+		actual.Name = expected.Name
+		if actual != expected {
+			return 0, 0, fmt.Errorf("backup file '%s' does not match its checksum in the backup manifest", expected.Name)
+		}
+	}
+
 	mainFile := filepath.Join(backupPath, "in-memory.mtdb")
 	if info, err := os.Stat(mainFile); err != nil {
 		if os.IsNotExist(err) {
-			return fmt.Errorf("main backup file '%s' does not exist", mainFile)
+			return 0, 0, fmt.Errorf("main backup file '%s' does not exist", mainFile)
 		}
-		return fmt.Errorf("error verifying main file: %w", err)
+		return 0, 0, fmt.Errorf("error verifying main file: %w", err)
 	} else if info.Size() == 0 {
-		if bm.mainStore.Size() > 0 {
-			return fmt.Errorf("main backup file is empty but store is not")
-		}
+		return 0, 0, fmt.Errorf("main backup file is empty")
+	} else {
+		sizeBytes += info.Size()
 	}
 
 	collectionsDir := filepath.Join(backupPath, "collections")
-	if _, err := os.Stat(collectionsDir); err != nil {
-		return fmt.Errorf("error verifying collections directory: %w", err)
-	}
-
 	entries, err := os.ReadDir(collectionsDir)
 	if err != nil {
-		return fmt.Errorf("error reading collections directory: %w", err)
-	}
-
-	if len(entries) != len(bm.colManager.ListCollections()) {
-		slog.Warn("Backup verification mismatch", "backed_up_collections", len(entries), "active_collections", len(bm.colManager.ListCollections()))
+		return 0, sizeBytes, fmt.Errorf("error reading collections directory: %w", err)
 	}
 
 	for _, entry := range entries {
@@ -259,24 +555,137 @@ func (bm *BackupManager) verifyBackup(backupPath string) error {
 		}
 		info, err := entry.Info()
 		if err != nil {
-			return fmt.Errorf("error getting file info for '%s': %w", entry.Name(), err)
+			return collections, sizeBytes, fmt.Errorf("error getting file info for '%s': %w", entry.Name(), err)
 		}
 		if info.Size() == 0 {
-			return fmt.Errorf("collection backup file '%s' is empty", entry.Name())
+			return collections, sizeBytes, fmt.Errorf("collection backup file '%s' is empty", entry.Name())
+		}
+		collections++
+		sizeBytes += info.Size()
+	}
+	return collections, sizeBytes, nil
+}
+
+// ListBackups describes every backup directory, oldest first, checking each one's files.
+func (bm *BackupManager) ListBackups() ([]BackupInfo, error) {
+	bm.backupLock.RLock()
+	defer bm.backupLock.RUnlock()
+
+	if dest := currentDestination(); !isLocalDestination(dest) {
+		return listRemoteBackups(dest)
+	}
+	entries, err := os.ReadDir(globalconst.BackupsDirName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []BackupInfo{}, nil
 		}
+		return nil, fmt.Errorf("error reading backup directory: %w", err)
+	}
+
+	backups := make([]BackupInfo, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backupPath := filepath.Join(globalconst.BackupsDirName, entry.Name())
+		backup := BackupInfo{Name: entry.Name(), CreatedAt: info.ModTime().UTC().Format(time.RFC3339)}
+		if manifest, err := readBackupManifest(backupPath); err == nil {
+			backup.Type, backup.Parent = manifest.Type, manifest.Parent
+		}
+		collections, sizeBytes, err := checkBackupFiles(backupPath)
+		backup.Collections, backup.SizeBytes = collections, sizeBytes
+		if err != nil {
+			backup.Error = err.Error()
+		} else {
+			backup.Verified = true
+		}
+		backups = append(backups, backup)
+	}
+	sort.SliceStable(backups, func(i, j int) bool { return backups[i].CreatedAt < backups[j].CreatedAt })
+	return backups, nil
+}
+
+// DeleteBackup removes a backup directory. The name must be that of a directory directly inside
+// the backups directory, so no other path can be removed through it. A backup that an incremental
+// backup builds on cannot be deleted, as that backup could no longer be restored.
+func (bm *BackupManager) DeleteBackup(backupName string) error {
+	if backupName == "" || backupName == "." || backupName == ".." || filepath.Base(backupName) != backupName {
+		return fmt.Errorf("%w '%s'", ErrInvalidBackupName, backupName)
+	}
+
+	// Waits for a backup being written, which may be the one to delete.
+	bm.backupLock.Lock()
+	defer bm.backupLock.Unlock()
+
+	if dest := currentDestination(); !isLocalDestination(dest) {
+		return deleteRemoteBackup(dest, backupName)
+	}
+	backupPath := filepath.Join(globalconst.BackupsDirName, backupName)
+	info, err := os.Stat(backupPath)
+	if os.IsNotExist(err) || (err == nil && !info.IsDir()) {
+		return ErrBackupNotFound
+	} else if err != nil {
+		return fmt.Errorf("error reading backup '%s': %w", backupName, err)
+	}
+	for name, manifest := range readBackupManifests() {
+		if manifest.Parent == backupName {
+			return fmt.Errorf("%w '%s'", ErrBackupInUse, name)
+		}
+	}
+	if err := os.RemoveAll(backupPath); err != nil {
+		return fmt.Errorf("error deleting backup '%s': %w", backupName, err)
 	}
 	return nil
 }
 
-// cleanOldBackups removes backups older than the retention period
+// readBackupManifests returns the manifests of the backups that have a readable one, by name.
+func readBackupManifests() map[string]backupManifest {
+	manifests := make(map[string]backupManifest)
+	entries, err := os.ReadDir(globalconst.BackupsDirName)
+	if err != nil {
+		return manifests
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if manifest, err := readBackupManifest(filepath.Join(globalconst.BackupsDirName, entry.Name())); err == nil {
+			manifests[entry.Name()] = manifest
+		}
+	}
+	return manifests
+}
+
+// cleanOldBackups removes backups older than the retention period, except those that a newer
+// incremental backup still builds on.
 func (bm *BackupManager) cleanOldBackups() {
 	cutoffTime := time.Now().Add(-bm.backupRetention)
+	if dest := currentDestination(); !isLocalDestination(dest) {
+		cleanOldRemoteBackups(dest, cutoffTime)
+		return
+	}
 	entries, err := os.ReadDir(globalconst.BackupsDirName)
 	if err != nil {
 		slog.Error("Failed to read backup directory for cleanup", "error", err)
 		return
 	}
 
+	manifests := readBackupManifests()
+	needed := make(map[string]bool)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !entry.IsDir() || info.ModTime().Before(cutoffTime) {
+			continue
+		}
+		for name := manifests[entry.Name()].Parent; name != "" && !needed[name]; name = manifests[name].Parent {
+			needed[name] = true
+		}
+	}
+
 	cleanedCount := 0
 	for _, entry := range entries {
 		if !entry.IsDir() {
@@ -286,7 +695,7 @@ func (bm *BackupManager) cleanOldBackups() {
 		if err != nil {
 			continue
 		}
-		if info.ModTime().Before(cutoffTime) {
+		if info.ModTime().Before(cutoffTime) && !needed[entry.Name()] {
 			path := filepath.Join(globalconst.BackupsDirName, entry.Name())
 			if err := os.RemoveAll(path); err != nil {
 				slog.Error("Failed to delete old backup", "path", path, "error", err)
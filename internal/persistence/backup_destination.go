@@ -0,0 +1,449 @@
+package persistence
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// BackupObject is a file stored at a backup destination.
+type BackupObject struct {
+	Key          string // "<backup name>/<path in the backup>", slash-separated.
+	Size         int64
+	LastModified time.Time
+}
+
+// BackupDestination stores the files of backups. Backups are always written to the local backups
+// directory first; a remote destination receives each file once the backup is complete, and
+// restores download the backups they need from it.
+type BackupDestination interface {
+	// Put stores size bytes read from r under key, replacing any previous object.
+	Put(key string, r io.Reader, size int64) error
+	// Get opens the object stored under key. A missing object is reported with fs.ErrNotExist.
+	Get(key string) (io.ReadCloser, error)
+	// List returns every object whose key starts with prefix.
+	List(prefix string) ([]BackupObject, error)
+	// DeleteAll removes every object whose key starts with prefix.
+	DeleteAll(prefix string) error
+	// String describes the destination for logs.
+	String() string
+}
+
+// destinationBox lets backupDestination hold any BackupDestination implementation.
+type destinationBox struct{ BackupDestination }
+
+var backupDestination atomic.Pointer[destinationBox]
+
+func init() {
+	backupDestination.Store(&destinationBox{NewLocalBackupDestination(globalconst.BackupsDirName)})
+}
+
+// SetBackupDestination sets where backups are stored. The default is the local backups directory.
+func SetBackupDestination(dest BackupDestination) {
+	backupDestination.Store(&destinationBox{dest})
+}
+
+// currentDestination returns the destination set with SetBackupDestination.
+func currentDestination() BackupDestination {
+	return backupDestination.Load().BackupDestination
+}
+
+// isLocalDestination reports whether dest is the local backups directory itself, where backups
+// are written in place and never need to be copied.
+func isLocalDestination(dest BackupDestination) bool {
+	local, ok := dest.(*localBackupDestination)
+	return ok && filepath.Clean(local.root) == filepath.Clean(globalconst.BackupsDirName)
+}
+
+// localBackupDestination stores backups as plain files under a directory.
+type localBackupDestination struct {
+	root string
+}
+
+// NewLocalBackupDestination returns a destination that stores backups under root.
+func NewLocalBackupDestination(root string) BackupDestination {
+	return &localBackupDestination{root: root}
+}
+
+func (d *localBackupDestination) String() string {
+	return "local:" + d.root
+}
+
+func (d *localBackupDestination) Put(key string, r io.Reader, size int64) error {
+	filePath := filepath.Join(d.root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	tempPath := filePath + ".tmp"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return os.Rename(tempPath, filePath)
+}
+
+func (d *localBackupDestination) Get(key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(d.root, filepath.FromSlash(key)))
+}
+
+// List skips the directories whose name starts with a dot, which hold the downloads of restores
+// in progress rather than backups.
+func (d *localBackupDestination) List(prefix string) ([]BackupObject, error) {
+	var objects []BackupObject
+	err := filepath.WalkDir(d.root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			if filePath != d.root && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(d.root, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) || strings.HasSuffix(key, ".tmp") {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, BackupObject{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+func (d *localBackupDestination) DeleteAll(prefix string) error {
+	return os.RemoveAll(filepath.Join(d.root, filepath.FromSlash(prefix)))
+}
+
+// groupBackupObjects groups the objects of a destination by the backup they belong to.
+func groupBackupObjects(objects []BackupObject) map[string][]BackupObject {
+	backups := make(map[string][]BackupObject)
+	for _, object := range objects {
+		name, _, found := strings.Cut(object.Key, "/")
+		if found && name != "" {
+			backups[name] = append(backups[name], object)
+		}
+	}
+	return backups
+}
+
+// readDestinationManifest reads the manifest of a backup at dest. Only local backups may lack
+// one, having been written before manifests existed; a remote backup without one is an upload
+// that did not complete.
+func readDestinationManifest(dest BackupDestination, backupName string) (backupManifest, error) {
+	if isLocalDestination(dest) {
+		return readBackupManifest(filepath.Join(globalconst.BackupsDirName, backupName))
+	}
+	reader, err := dest.Get(path.Join(backupName, backupManifestFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return backupManifest{}, fmt.Errorf("backup '%s' has no manifest; its upload did not complete", backupName)
+	} else if err != nil {
+		return backupManifest{}, fmt.Errorf("error reading backup manifest: %w", err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return backupManifest{}, fmt.Errorf("error reading backup manifest: %w", err)
+	}
+	return decodeBackupManifest(data)
+}
+
+// uploadBackup copies every file of a local backup to dest, the manifest last, so a backup that
+// has a manifest at the destination is complete.
+func uploadBackup(dest BackupDestination, backupPath string) error {
+	backupName := filepath.Base(backupPath)
+	var files []string
+	err := filepath.WalkDir(backupPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if entry.Name() != backupManifestFile || filepath.Dir(filePath) != backupPath {
+			files = append(files, filePath)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error reading backup files: %w", err)
+	}
+	files = append(files, filepath.Join(backupPath, backupManifestFile))
+
+	for _, filePath := range files {
+		rel, err := filepath.Rel(backupPath, filePath)
+		if err != nil {
+			return err
+		}
+		if err := uploadFile(dest, path.Join(backupName, filepath.ToSlash(rel)), filePath); err != nil {
+			return err
+		}
+	}
+	slog.Info("Backup uploaded", "backup_name", backupName, "destination", dest.String(), "files", len(files))
+	return nil
+}
+
+// uploadFile streams one file to dest under key.
+func uploadFile(dest BackupDestination, key, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("error opening backup file '%s': %w", filePath, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("error reading backup file '%s': %w", filePath, err)
+	}
+	if err := dest.Put(key, file, info.Size()); err != nil {
+		return fmt.Errorf("error uploading '%s' to %s: %w", key, dest, err)
+	}
+	return nil
+}
+
+// stageBackupChain makes the backups needed to restore backupName available on local disk and
+// returns the directory holding them. With a remote destination, the backup and those it builds
+// on are downloaded to a temporary directory, which cleanup removes.
+func stageBackupChain(backupName string) (root string, cleanup func(), err error) {
+	dest := currentDestination()
+	if isLocalDestination(dest) {
+		return globalconst.BackupsDirName, func() {}, nil
+	}
+
+	root = filepath.Join(globalconst.BackupsDirName, fmt.Sprintf(".restore-%d", time.Now().UnixNano()))
+	cleanup = func() { os.RemoveAll(root) }
+	for name := backupName; ; {
+		if name == "" || filepath.Base(name) != name {
+			cleanup()
+			return "", nil, fmt.Errorf("invalid backup name '%s'", name)
+		}
+		objects, err := dest.List(name + "/")
+		if err != nil {
+			cleanup()
+			return "", nil, fmt.Errorf("error listing backup '%s' at %s: %w", name, dest, err)
+		}
+		if len(objects) == 0 {
+			cleanup()
+			if name == backupName {
+				return "", nil, fmt.Errorf("backup directory '%s' not found", backupName)
+			}
+			return "", nil, fmt.Errorf("backup chain of '%s' is incomplete: backup '%s' is missing", backupName, name)
+		}
+		if !slices.ContainsFunc(objects, func(object BackupObject) bool { return object.Key == path.Join(name, backupManifestFile) }) {
+			cleanup()
+			return "", nil, fmt.Errorf("backup '%s' has no manifest; its upload did not complete", name)
+		}
+		slog.Info("Downloading backup for restore", "backup_name", name, "destination", dest.String(), "files", len(objects))
+		for _, object := range objects {
+			if err := downloadObject(dest, object.Key, filepath.Join(root, filepath.FromSlash(object.Key))); err != nil {
+				cleanup()
+				return "", nil, err
+			}
+		}
+		manifest, err := readBackupManifest(filepath.Join(root, name))
+		if err != nil {
+			cleanup()
+			return "", nil, fmt.Errorf("backup '%s': %w", name, err)
+		}
+		if manifest.Type == BackupTypeFull {
+			return root, cleanup, nil
+		}
+		name = manifest.Parent
+	}
+}
+
+// downloadObject copies the object stored under key at dest to filePath.
+func downloadObject(dest BackupDestination, key, filePath string) error {
+	reader, err := dest.Get(key)
+	if err != nil {
+		return fmt.Errorf("error downloading '%s' from %s: %w", key, dest, err)
+	}
+	defer reader.Close()
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, reader); err != nil {
+		file.Close()
+		return fmt.Errorf("error downloading '%s' from %s: %w", key, dest, err)
+	}
+	return file.Close()
+}
+
+// remoteBackups returns the objects of every backup at a remote destination, by backup name.
+func remoteBackups(dest BackupDestination) (map[string][]BackupObject, error) {
+	objects, err := dest.List("")
+	if err != nil {
+		return nil, fmt.Errorf("error listing backups at %s: %w", dest, err)
+	}
+	return groupBackupObjects(objects), nil
+}
+
+// remoteBackupTime returns when a remote backup was uploaded: the time of its newest object.
+func remoteBackupTime(objects []BackupObject) time.Time {
+	var newest time.Time
+	for _, object := range objects {
+		if object.LastModified.After(newest) {
+			newest = object.LastModified
+		}
+	}
+	return newest
+}
+
+// listRemoteBackups is ListBackups for a remote destination. Backups are checked against their
+// object listing, as checkBackupFiles does for local files; WAL checksums are only checked when a
+// backup is downloaded for a restore.
+func listRemoteBackups(dest BackupDestination) ([]BackupInfo, error) {
+	grouped, err := remoteBackups(dest)
+	if err != nil {
+		return nil, err
+	}
+	backups := make([]BackupInfo, 0, len(grouped))
+	for name, objects := range grouped {
+		backup := BackupInfo{Name: name, CreatedAt: remoteBackupTime(objects).UTC().Format(time.RFC3339)}
+		manifest, err := readDestinationManifest(dest, name)
+		if err == nil {
+			backup.Type, backup.Parent = manifest.Type, manifest.Parent
+			backup.Collections, backup.SizeBytes, err = checkBackupObjects(name, manifest, objects)
+		}
+		if err != nil {
+			backup.Error = err.Error()
+		} else {
+			backup.Verified = true
+		}
+		backups = append(backups, backup)
+	}
+	sort.SliceStable(backups, func(i, j int) bool { return backups[i].CreatedAt < backups[j].CreatedAt })
+	return backups, nil
+}
+
+// checkBackupObjects checks the objects of a remote backup as checkBackupFiles checks local files,
+// comparing the WAL files of an incremental backup with the sizes in its manifest.
+func checkBackupObjects(backupName string, manifest backupManifest, objects []BackupObject) (collections int, sizeBytes int64, err error) {
+	sizes := make(map[string]int64, len(objects))
+	for _, object := range objects {
+		sizes[strings.TrimPrefix(object.Key, backupName+"/")] = object.Size
+		sizeBytes += object.Size
+	}
+	if manifest.Type == BackupTypeIncremental {
+		for _, file := range manifest.WalFiles {
+			if size, found := sizes[path.Join(backupWalDir, file.Name)]; !found {
+				return 0, sizeBytes, fmt.Errorf("WAL file '%s' is missing", file.Name)
+			} else if size != file.Size {
+				return 0, sizeBytes, fmt.Errorf("WAL file '%s' does not match the backup manifest", file.Name)
+			}
+		}
+		return 0, sizeBytes, nil
+	}
+
+	if size, found := sizes["in-memory.mtdb"]; !found {
+		return 0, sizeBytes, fmt.Errorf("main backup file 'in-memory.mtdb' does not exist")
+	} else if size == 0 {
+		return 0, sizeBytes, fmt.Errorf("main backup file is empty")
+	}
+	for key, size := range sizes {
+		if !strings.HasPrefix(key, "collections/") {
+			continue
+		}
+		if size == 0 {
+			return collections, sizeBytes, fmt.Errorf("collection backup file '%s' is empty", path.Base(key))
+		}
+		collections++
+	}
+	return collections, sizeBytes, nil
+}
+
+// deleteRemoteBackup is DeleteBackup for a remote destination.
+func deleteRemoteBackup(dest BackupDestination, backupName string) error {
+	grouped, err := remoteBackups(dest)
+	if err != nil {
+		return err
+	}
+	if _, found := grouped[backupName]; !found {
+		return ErrBackupNotFound
+	}
+	for name := range grouped {
+		if manifest, err := readDestinationManifest(dest, name); err == nil && manifest.Parent == backupName {
+			return fmt.Errorf("%w '%s'", ErrBackupInUse, name)
+		}
+	}
+	if err := dest.DeleteAll(backupName + "/"); err != nil {
+		return fmt.Errorf("error deleting backup '%s': %w", backupName, err)
+	}
+	return nil
+}
+
+// cleanOldRemoteBackups is cleanOldBackups for a remote destination.
+func cleanOldRemoteBackups(dest BackupDestination, cutoffTime time.Time) {
+	grouped, err := remoteBackups(dest)
+	if err != nil {
+		slog.Error("Failed to list backups for cleanup", "destination", dest.String(), "error", err)
+		return
+	}
+
+	manifests := make(map[string]backupManifest, len(grouped))
+	for name := range grouped {
+		if manifest, err := readDestinationManifest(dest, name); err == nil {
+			manifests[name] = manifest
+		}
+	}
+	needed := make(map[string]bool)
+	for name, objects := range grouped {
+		if remoteBackupTime(objects).Before(cutoffTime) {
+			continue
+		}
+		for parent := manifests[name].Parent; parent != "" && !needed[parent]; parent = manifests[parent].Parent {
+			needed[parent] = true
+		}
+	}
+
+	cleanedCount := 0
+	for name, objects := range grouped {
+		if !remoteBackupTime(objects).Before(cutoffTime) || needed[name] {
+			continue
+		}
+		if err := dest.DeleteAll(name + "/"); err != nil {
+			slog.Error("Failed to delete old backup", "backup_name", name, "destination", dest.String(), "error", err)
+		} else {
+			slog.Info("Old backup deleted", "backup_name", name, "destination", dest.String())
+			cleanedCount++
+		}
+	}
+	if cleanedCount > 0 {
+		slog.Info("Backup cleanup finished", "deleted_count", cleanedCount, "destination", dest.String())
+	}
+}
@@ -0,0 +1,281 @@
+package persistence
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3RequestTimeout bounds a single request to the object store, including the transfer of a
+// whole backup file.
+const s3RequestTimeout = 30 * time.Minute
+
+// s3UnsignedPayload is sent as the payload hash, so files are streamed without being read twice.
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Config configures an S3-compatible backup destination, such as AWS S3 or MinIO.
+type S3Config struct {
+	Endpoint  string // Base URL of the service, e.g. "https://s3.eu-west-1.amazonaws.com".
+	Bucket    string
+	Region    string // Region used to sign requests; "us-east-1" if empty.
+	AccessKey string
+	SecretKey string
+	Prefix    string // Optional path inside the bucket under which backups are stored.
+}
+
+// s3BackupDestination stores backups as objects in a bucket, using path-style requests signed
+// with AWS Signature Version 4.
+type s3BackupDestination struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3BackupDestination returns a destination that stores backups in an S3-compatible bucket.
+func NewS3BackupDestination(cfg S3Config) (BackupDestination, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("an S3 backup destination needs an endpoint and a bucket")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("an S3 backup destination needs an access key and a secret key")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint '%s'", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+	return &s3BackupDestination{cfg: cfg, endpoint: endpoint, client: &http.Client{Timeout: s3RequestTimeout}}, nil
+}
+
+func (d *s3BackupDestination) String() string {
+	if d.cfg.Prefix != "" {
+		return fmt.Sprintf("s3://%s/%s", d.cfg.Bucket, d.cfg.Prefix)
+	}
+	return "s3://" + d.cfg.Bucket
+}
+
+// objectKey returns the key in the bucket of a backup file.
+func (d *s3BackupDestination) objectKey(key string) string {
+	if d.cfg.Prefix == "" {
+		return key
+	}
+	return d.cfg.Prefix + "/" + key
+}
+
+func (d *s3BackupDestination) Put(key string, r io.Reader, size int64) error {
+	resp, err := d.do(http.MethodPut, d.objectKey(key), nil, r, size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (d *s3BackupDestination) Get(key string) (io.ReadCloser, error) {
+	resp, err := d.do(http.MethodGet, d.objectKey(key), nil, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// s3ListResult is the part of a ListObjectsV2 response that List reads.
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (d *s3BackupDestination) List(prefix string) ([]BackupObject, error) {
+	var objects []BackupObject
+	query := url.Values{"list-type": {"2"}, "prefix": {d.objectKey(prefix)}}
+	for {
+		resp, err := d.do(http.MethodGet, "", query, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding S3 object listing: %w", err)
+		}
+		for _, object := range result.Contents {
+			key := object.Key
+			if d.cfg.Prefix != "" {
+				key = strings.TrimPrefix(key, d.cfg.Prefix+"/")
+			}
+			objects = append(objects, BackupObject{Key: key, Size: object.Size, LastModified: object.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return objects, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (d *s3BackupDestination) DeleteAll(prefix string) error {
+	objects, err := d.List(prefix)
+	if err != nil {
+		return err
+	}
+	for _, object := range objects {
+		resp, err := d.do(http.MethodDelete, d.objectKey(object.Key), nil, nil, 0)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// do sends a signed request for an object of the bucket, or for the bucket itself if objectKey
+// is empty. Responses other than 2xx are returned as errors; a missing object wraps fs.ErrNotExist.
+func (d *s3BackupDestination) do(method, objectKey string, query url.Values, body io.Reader, size int64) (*http.Response, error) {
+	u := *d.endpoint
+	objectPath := strings.TrimSuffix(u.Path, "/") + "/" + d.cfg.Bucket
+	if objectKey != "" {
+		objectPath += "/" + objectKey
+	}
+	u.Path = objectPath
+	u.RawPath = s3EncodePath(objectPath)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		req.Body = io.NopCloser(body)
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	req.Header.Set("x-amz-content-sha256", s3UnsignedPayload)
+	d.sign(req, time.Now())
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 %s '%s' failed: %w", method, objectKey, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	var s3Err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&s3Err)
+	err = fmt.Errorf("S3 %s '%s' failed: %s %s %s", method, objectKey, resp.Status, s3Err.Code, s3Err.Message)
+	if resp.StatusCode == http.StatusNotFound && s3Err.Code != "NoSuchBucket" {
+		return nil, fmt.Errorf("%w: %w", fs.ErrNotExist, err)
+	}
+	return nil, err
+}
+
+// sign adds the AWS Signature Version 4 Authorization header to req. The host and every header
+// already set on req are signed.
+func (d *s3BackupDestination) sign(req *http.Request, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("x-amz-content-sha256"),
+	}, "\n")
+	scope := date + "/" + d.cfg.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := s3HMAC([]byte("AWS4"+d.cfg.SecretKey), date)
+	key = s3HMAC(key, d.cfg.Region)
+	key = s3HMAC(key, "s3")
+	key = s3HMAC(key, "aws4_request")
+	signature := hex.EncodeToString(s3HMAC(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		d.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+func s3HMAC(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3CanonicalQuery encodes query parameters sorted by name, as Signature Version 4 expects them.
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, s3Encode(name, true)+"="+s3Encode(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3EncodePath percent-encodes every segment of a path, keeping the slashes between them.
+func s3EncodePath(p string) string {
+	return s3Encode(p, false)
+}
+
+// s3Encode percent-encodes every byte but the unreserved characters of RFC 3986, and slashes
+// unless encodeSlash is set.
+func s3Encode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
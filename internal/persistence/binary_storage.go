@@ -1,6 +1,8 @@
 package persistence
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -9,6 +11,9 @@ import (
 	"memory-tools/internal/store"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	jsoniter "github.com/json-iterator/go"
@@ -18,6 +23,10 @@ import (
 const mainDataFile = "in-memory.mtdb"
 const mainSnapshotTempFile = "in-memory.mtdb.tmp"
 
+// checksummedMainFileMarker starts main data files whose records end with a checksum. Older files
+// start with the entry count, which never reaches this value.
+const checksummedMainFileMarker uint32 = 0xFFFFFFFF
+
 // SaveData saves all non-expired data from the main DataStore to a binary file.
 func SaveData(s store.DataStore) error {
 	data := s.GetAll()
@@ -28,30 +37,36 @@ func SaveData(s store.DataStore) error {
 	}
 	defer file.Close()
 
-	if err := binary.Write(file, binary.LittleEndian, uint32(len(data))); err != nil {
+	bw := bufio.NewWriter(file)
+	w, closeEncryption, err := encryptingWriter(bw)
+	if err != nil {
+		os.Remove(mainSnapshotTempFile)
+		return fmt.Errorf("failed to start encrypting temporary main file: %w", err)
+	}
+	if err := binary.Write(w, binary.LittleEndian, checksummedMainFileMarker); err != nil {
+		os.Remove(mainSnapshotTempFile)
+		return fmt.Errorf("failed to write format marker to temporary main file: %w", err)
+	}
+	if err := binary.Write(w, binary.LittleEndian, uint32(len(data))); err != nil {
 		os.Remove(mainSnapshotTempFile)
 		return fmt.Errorf("failed to write data count to temporary main file: %w", err)
 	}
 
 	for key, value := range data {
-		if err := binary.Write(file, binary.LittleEndian, uint32(len(key))); err != nil {
-			os.Remove(mainSnapshotTempFile)
-			return fmt.Errorf("failed to write key length for '%s' in main store: %w", key, err)
-		}
-		if _, err := file.WriteString(key); err != nil {
+		if err := writeRecord(w, []byte(key), value); err != nil {
 			os.Remove(mainSnapshotTempFile)
-			return fmt.Errorf("failed to write key '%s' in main store: %w", key, err)
-		}
-		if err := binary.Write(file, binary.LittleEndian, uint32(len(value))); err != nil {
-			os.Remove(mainSnapshotTempFile)
-			return fmt.Errorf("failed to write value length for '%s' in main store: %w", key, err)
-		}
-		if _, err := file.Write(value); err != nil {
-			os.Remove(mainSnapshotTempFile)
-			return fmt.Errorf("failed to write value for '%s' in main store: %w", key, err)
+			return fmt.Errorf("failed to write record for '%s' in main store: %w", key, err)
 		}
 	}
 
+	if err := closeEncryption(); err != nil {
+		os.Remove(mainSnapshotTempFile)
+		return fmt.Errorf("failed to finish encrypting temporary main file: %w", err)
+	}
+	if err := bw.Flush(); err != nil {
+		os.Remove(mainSnapshotTempFile)
+		return fmt.Errorf("failed to write temporary main snapshot file: %w", err)
+	}
 	if err := file.Sync(); err != nil {
 		os.Remove(mainSnapshotTempFile)
 		return fmt.Errorf("failed to sync temporary main snapshot file to disk: %w", err)
@@ -79,37 +94,43 @@ func LoadData(s store.DataStore) error {
 	}
 	defer file.Close()
 
+	r, err := decryptingReader(file)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt main data file '%s': %w", mainDataFile, err)
+	}
 	var numEntries uint32
-	if err := binary.Read(file, binary.LittleEndian, &numEntries); err != nil {
+	if err := binary.Read(r, binary.LittleEndian, &numEntries); err != nil {
 		return fmt.Errorf("failed to read number of entries from '%s': %w", mainDataFile, err)
 	}
+	checksums := numEntries == checksummedMainFileMarker
+	if checksums {
+		if err := binary.Read(r, binary.LittleEndian, &numEntries); err != nil {
+			return fmt.Errorf("failed to read number of entries from '%s': %w", mainDataFile, err)
+		}
+	}
 
 	loadedData := make(map[string][]byte, numEntries)
+	corrupted := 0
+	records := newRecordReader(r, checksums, fileSize(mainDataFile))
 	for i := 0; i < int(numEntries); i++ {
-		var keyLen uint32
-		if err := binary.Read(file, binary.LittleEndian, &keyLen); err != nil {
-			return fmt.Errorf("failed to read key length for entry %d in main store: %w", i, err)
-		}
-		keyBytes := make([]byte, keyLen)
-		if _, err := io.ReadFull(file, keyBytes); err != nil {
-			return fmt.Errorf("failed to read key for entry %d in main store: %w", i, err)
-		}
-		key := string(keyBytes)
-
-		var valLen uint32
-		if err := binary.Read(file, binary.LittleEndian, &valLen); err != nil {
-			return fmt.Errorf("failed to read value length for key '%s' in main store: %w", key, err)
-		}
-		valBytes := make([]byte, valLen)
-		if _, err := io.ReadFull(file, valBytes); err != nil {
-			return fmt.Errorf("failed to read value for key '%s' in main store: %w", key, err)
+		keyBytes, valBytes, err := records.next()
+		if err != nil {
+			if skipCorruptRecord(mainDataFile, err) {
+				corrupted++
+				continue
+			}
+			if strictRecovery.Load() {
+				return fmt.Errorf("failed to read entry %d in main store: %w", i, noEOF(err))
+			}
+			slog.Error("Main data file is corrupted, keeping the records read so far", "path", mainDataFile, "entry", i, "error", noEOF(err))
+			corrupted += int(numEntries) - i
+			break
 		}
-		value := valBytes
-		loadedData[key] = value
+		loadedData[string(keyBytes)] = valBytes
 	}
 
 	s.LoadData(loadedData)
-	slog.Info("Main data successfully loaded", "path", mainDataFile, "total_keys", len(loadedData))
+	slog.Info("Main data successfully loaded", "path", mainDataFile, "total_keys", len(loadedData), "corrupted_records", corrupted)
 	return nil
 }
 
@@ -167,13 +188,29 @@ func (sm *SnapshotManager) Stop() {
 type CollectionPersisterImpl struct{}
 
 // SaveCollectionData saves all non-expired data from a single collection (DataStore) to a file.
+// Records of cold keys, which are not held in memory, are copied over from the current file.
+// A key that is both in memory and cold is saved from the file.
 func (p *CollectionPersisterImpl) SaveCollectionData(collectionName string, s store.DataStore) error {
 	if err := os.MkdirAll(globalconst.CollectionsDirName, 0755); err != nil {
 		return fmt.Errorf("failed to create collections directory '%s': %w", globalconst.CollectionsDirName, err)
 	}
 
 	data := s.GetAll()
-	indexedFields := s.ListIndexes()
+	coldKeys := make(map[string]struct{})
+	for _, key := range s.ColdKeys() {
+		coldKeys[key] = struct{}{}
+		delete(data, key)
+	}
+	indexedFields := persistedIndexEntries(s)
+	codec, err := ParseFileCodec(s.FileCodec())
+	if err != nil {
+		return fmt.Errorf("invalid file codec for collection '%s': %w", collectionName, err)
+	}
+	header, err := newCollectionHeader(codec)
+	if err != nil {
+		return fmt.Errorf("failed to prepare file header for collection '%s': %w", collectionName, err)
+	}
+	headerEntries := append(indexedFields, header.formatEntries()...)
 
 	filePath := filepath.Join(globalconst.CollectionsDirName, collectionName+globalconst.DBFileExtension)
 	tempFilePath := filePath + globalconst.TempFileSuffix
@@ -184,11 +221,11 @@ func (p *CollectionPersisterImpl) SaveCollectionData(collectionName string, s st
 	}
 	defer file.Close()
 
-	if err := binary.Write(file, binary.LittleEndian, uint32(len(indexedFields))); err != nil {
+	if err := binary.Write(file, binary.LittleEndian, uint32(len(headerEntries))); err != nil {
 		os.Remove(tempFilePath)
 		return fmt.Errorf("failed to write index count for collection '%s': %w", collectionName, err)
 	}
-	for _, field := range indexedFields {
+	for _, field := range headerEntries {
 		if err := binary.Write(file, binary.LittleEndian, uint32(len(field))); err != nil {
 			os.Remove(tempFilePath)
 			return fmt.Errorf("failed to write index field name length for '%s': %w", field, err)
@@ -199,31 +236,44 @@ func (p *CollectionPersisterImpl) SaveCollectionData(collectionName string, s st
 		}
 	}
 
+	countOffset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		os.Remove(tempFilePath)
+		return fmt.Errorf("failed to locate data count for collection '%s': %w", collectionName, err)
+	}
 	if err := binary.Write(file, binary.LittleEndian, uint32(len(data))); err != nil {
 		os.Remove(tempFilePath)
 		return fmt.Errorf("failed to write data count for collection '%s': %w", collectionName, err)
 	}
 
+	index := uint64(0)
 	for key, value := range data {
-		if err := binary.Write(file, binary.LittleEndian, uint32(len(key))); err != nil {
+		if value, err = header.encodeValue(index, []byte(key), value); err != nil {
 			file.Close()
 			os.Remove(tempFilePath)
-			return fmt.Errorf("failed to write key length for '%s' in collection '%s': %w", key, collectionName, err)
+			return fmt.Errorf("failed to encode value for '%s' in collection '%s': %w", key, collectionName, err)
 		}
-		if _, err := file.WriteString(key); err != nil {
+		index++
+		if err := writeRecord(file, []byte(key), value); err != nil {
 			file.Close()
 			os.Remove(tempFilePath)
-			return fmt.Errorf("failed to write key '%s' in collection '%s': %w", key, collectionName, err)
+			return fmt.Errorf("failed to write record for '%s' in collection '%s': %w", key, collectionName, err)
 		}
-		if err := binary.Write(file, binary.LittleEndian, uint32(len(value))); err != nil {
+	}
+
+	carried := 0
+	if len(coldKeys) > 0 {
+		if carried, err = copyColdRecords(filePath, file, coldKeys, header, index); err != nil {
 			file.Close()
 			os.Remove(tempFilePath)
-			return fmt.Errorf("failed to write value length for '%s' in collection '%s': %w", key, collectionName, err)
+			return fmt.Errorf("failed to carry cold data over for collection '%s': %w", collectionName, err)
 		}
-		if _, err := file.Write(value); err != nil {
+		var count [4]byte
+		binary.LittleEndian.PutUint32(count[:], uint32(len(data)+carried))
+		if _, err := file.WriteAt(count[:], countOffset); err != nil {
 			file.Close()
 			os.Remove(tempFilePath)
-			return fmt.Errorf("failed to write value for '%s' in collection '%s': %w", key, collectionName, err)
+			return fmt.Errorf("failed to write data count for collection '%s': %w", collectionName, err)
 		}
 	}
 
@@ -239,10 +289,71 @@ func (p *CollectionPersisterImpl) SaveCollectionData(collectionName string, s st
 		return fmt.Errorf("failed to rename temporary file to '%s' for collection '%s': %w", filePath, collectionName, err)
 	}
 
-	slog.Info("Collection data saved", "collection", collectionName, "path", filePath, "indexes", len(indexedFields), "items", len(data))
+	slog.Info("Collection data saved", "collection", collectionName, "path", filePath, "indexes", len(indexedFields), "items", len(data), "cold_items", carried, "codec", codec, "encrypted", header.cipher != nil)
 	return nil
 }
 
+// copyColdRecords appends to w the checksummed records of filePath whose keys are in keys, and
+// returns how many it copied. The destination file is described by dst and the first copied
+// record is its startIndex-th. Values are re-encoded unless both files store them the same way.
+// A missing file holds nothing to copy.
+func copyColdRecords(filePath string, w io.Writer, keys map[string]struct{}, dst collectionHeader, startIndex uint64) (int, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+
+	var numIndexes uint32
+	if err := binary.Read(r, binary.LittleEndian, &numIndexes); err != nil {
+		return 0, fmt.Errorf("failed to read index header count: %w", err)
+	}
+	header, err := readCollectionHeader(r, numIndexes)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read index header: %w", err)
+	}
+	var numEntries uint32
+	if err := binary.Read(r, binary.LittleEndian, &numEntries); err != nil {
+		return 0, fmt.Errorf("failed to read entry count: %w", err)
+	}
+
+	copied := 0
+	records := newRecordReader(r, header.checksums, fileSize(filePath))
+	for i := 0; i < int(numEntries); i++ {
+		keyBytes, valBytes, err := records.next()
+		if err != nil {
+			if skipCorruptRecord(filePath, err) {
+				continue
+			}
+			return copied, fmt.Errorf("failed to read entry %d: %w", i, noEOF(err))
+		}
+		key := string(keyBytes)
+		if _, ok := keys[key]; !ok {
+			continue
+		}
+		// A key is copied once, even if the file somehow holds it twice.
+		delete(keys, key)
+		if !header.sameEncoding(dst) {
+			decoded, err := header.decodeValue(uint64(i), keyBytes, valBytes)
+			if err != nil {
+				return copied, fmt.Errorf("failed to decode value for key '%s': %w", key, err)
+			}
+			if valBytes, err = dst.encodeValue(startIndex+uint64(copied), keyBytes, decoded); err != nil {
+				return copied, fmt.Errorf("failed to encode value for key '%s': %w", key, err)
+			}
+		}
+		if err := writeRecord(w, keyBytes, valBytes); err != nil {
+			return copied, err
+		}
+		copied++
+	}
+	return copied, nil
+}
+
 // DeleteCollectionFile removes a collection's data file from disk.
 func (p *CollectionPersisterImpl) DeleteCollectionFile(collectionName string) error {
 	filePath := filepath.Join(globalconst.CollectionsDirName, collectionName+globalconst.DBFileExtension)
@@ -257,6 +368,61 @@ func (p *CollectionPersisterImpl) DeleteCollectionFile(collectionName string) er
 	return nil
 }
 
+// RenameCollectionFile moves a collection's data file to the file of a new collection name,
+// replacing any file left there by a deleted collection. A collection never saved has no file to move.
+func (p *CollectionPersisterImpl) RenameCollectionFile(oldName, newName string) error {
+	oldPath := filepath.Join(globalconst.CollectionsDirName, oldName+globalconst.DBFileExtension)
+	newPath := filepath.Join(globalconst.CollectionsDirName, newName+globalconst.DBFileExtension)
+	if err := os.Rename(oldPath, newPath); err != nil {
+		if os.IsNotExist(err) {
+			slog.Debug("Collection file does not exist, no need to rename", "path", oldPath)
+			return nil
+		}
+		return fmt.Errorf("failed to rename collection file '%s' to '%s': %w", oldPath, newPath, err)
+	}
+	slog.Info("Collection file renamed on disk", "old_path", oldPath, "new_path", newPath)
+	return nil
+}
+
+// CopyCollectionFile replaces the data file of dstName with a copy of the file of srcName. The copy
+// is written to a temporary file first, so the destination holds either its old file or the full
+// copy. If srcName has no file, any file of dstName is removed.
+func (p *CollectionPersisterImpl) CopyCollectionFile(srcName, dstName string) error {
+	srcPath := filepath.Join(globalconst.CollectionsDirName, srcName+globalconst.DBFileExtension)
+	dstPath := filepath.Join(globalconst.CollectionsDirName, dstName+globalconst.DBFileExtension)
+	src, err := os.Open(srcPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return p.DeleteCollectionFile(dstName)
+		}
+		return fmt.Errorf("failed to open collection file '%s': %w", srcPath, err)
+	}
+	defer src.Close()
+
+	tempPath := dstPath + globalconst.TempFileSuffix
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for collection '%s': %w", dstName, err)
+	}
+	if _, err := io.Copy(file, src); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to copy collection file '%s': %w", srcPath, err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to sync temporary file for collection '%s' to disk: %w", dstName, err)
+	}
+	file.Close()
+	if err := os.Rename(tempPath, dstPath); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to rename temporary file to '%s' for collection '%s': %w", dstPath, dstName, err)
+	}
+	slog.Info("Collection file copied on disk", "source", srcPath, "destination", dstPath)
+	return nil
+}
+
 // LoadCollectionData loads data for a single collection from its file.
 func LoadCollectionData(collectionName string, s store.DataStore, hotThreshold time.Time) error {
 	filePath := filepath.Join(globalconst.CollectionsDirName, collectionName+globalconst.DBFileExtension)
@@ -279,18 +445,11 @@ func LoadCollectionData(collectionName string, s store.DataStore, hotThreshold t
 		numIndexes = 0
 	}
 
-	indexedFields := make([]string, numIndexes)
-	for i := 0; i < int(numIndexes); i++ {
-		var fieldLen uint32
-		if err := binary.Read(file, binary.LittleEndian, &fieldLen); err != nil {
-			return fmt.Errorf("failed to read index field length for collection '%s': %w", collectionName, err)
-		}
-		fieldBytes := make([]byte, fieldLen)
-		if _, err := io.ReadFull(file, fieldBytes); err != nil {
-			return fmt.Errorf("failed to read index field name for collection '%s': %w", collectionName, err)
-		}
-		indexedFields[i] = string(fieldBytes)
+	header, err := readCollectionHeader(file, numIndexes)
+	if err != nil {
+		return fmt.Errorf("failed to read index header for collection '%s': %w", collectionName, err)
 	}
+	indexedFields := header.indexEntries
 
 	var numEntries uint32
 	if err := binary.Read(file, binary.LittleEndian, &numEntries); err != nil {
@@ -299,63 +458,140 @@ func LoadCollectionData(collectionName string, s store.DataStore, hotThreshold t
 
 	collectionData := make(map[string][]byte, numEntries)
 	hotDataCount := 0
-	coldDataCount := 0
+	corrupted := 0
+	var coldKeys []string
 
+	// Corrupted records are skipped in best-effort recovery mode. A record whose lengths are
+	// corrupted hides where the next one starts, so loading stops there with what was read.
+	records := newRecordReader(file, header.checksums, fileSize(filePath))
 	for i := 0; i < int(numEntries); i++ {
-		var keyLen uint32
-		if err := binary.Read(file, binary.LittleEndian, &keyLen); err != nil {
-			return fmt.Errorf("failed to read key length for entry %d in collection '%s': %w", i, collectionName, err)
-		}
-		keyBytes := make([]byte, keyLen)
-		if _, err := io.ReadFull(file, keyBytes); err != nil {
-			return fmt.Errorf("failed to read key for entry %d in collection '%s': %w", i, collectionName, err)
+		keyBytes, valBytes, err := records.next()
+		if err != nil {
+			if skipCorruptRecord(filePath, err) {
+				corrupted++
+				continue
+			}
+			if strictRecovery.Load() {
+				return fmt.Errorf("failed to read entry %d in collection '%s': %w", i, collectionName, noEOF(err))
+			}
+			slog.Error("Collection file is corrupted, keeping the records read so far", "collection", collectionName, "path", filePath, "entry", i, "error", noEOF(err))
+			corrupted += int(numEntries) - i
+			break
 		}
 		key := string(keyBytes)
-
-		var valLen uint32
-		if err := binary.Read(file, binary.LittleEndian, &valLen); err != nil {
-			return fmt.Errorf("failed to read value length for key '%s' in collection '%s': %w", key, collectionName, err)
+		if valBytes, err = header.decodeValue(uint64(i), keyBytes, valBytes); err != nil {
+			if strictRecovery.Load() {
+				return fmt.Errorf("failed to decode value for key '%s' in collection '%s': %w", key, collectionName, err)
+			}
+			slog.Error("Skipping record that cannot be decoded", "collection", collectionName, "key", key, "error", err)
+			corrupted++
+			continue
 		}
-		valBytes := make([]byte, valLen)
-		if _, err := io.ReadFull(file, valBytes); err != nil {
-			return fmt.Errorf("failed to read value for key '%s' in collection '%s': %w", key, collectionName, err)
+
+		var doc map[string]any
+		if !hotThreshold.IsZero() || !bytes.Contains(valBytes, createdTsFieldName) {
+			if err := jsoniter.Unmarshal(valBytes, &doc); err != nil {
+				doc = nil
+			}
 		}
 
-		if !hotThreshold.IsZero() {
-			var doc map[string]any
-			if err := jsoniter.Unmarshal(valBytes, &doc); err == nil {
-				if createdAtStr, ok := doc[globalconst.CREATED_AT].(string); ok {
-					createdAt, err := time.Parse(time.RFC3339, createdAtStr)
-					if err == nil && createdAt.Before(hotThreshold) {
-						coldDataCount++
-						continue
-					}
+		if doc != nil && !hotThreshold.IsZero() {
+			if createdAtStr, ok := doc[globalconst.CREATED_AT].(string); ok {
+				createdAt, err := time.Parse(time.RFC3339, createdAtStr)
+				if err == nil && createdAt.Before(hotThreshold) {
+					coldKeys = append(coldKeys, key)
+					continue
 				}
 			}
 		}
 
+		// Documents written before CREATED_TS existed get it derived from CREATED_AT,
+		// so age-range queries on the numeric field cover them too.
+		if doc != nil && store.BackfillCreatedTs(doc) {
+			if backfilled, err := jsoniter.Marshal(doc); err == nil {
+				valBytes = backfilled
+			}
+		}
+
 		collectionData[key] = valBytes
 		hotDataCount++
 	}
 
 	s.LoadData(collectionData)
+	s.MarkCold(coldKeys...)
 	slog.Info("Collection data loaded",
 		"collection", collectionName,
 		"path", filePath,
 		"hot_items_in_ram", hotDataCount,
-		"cold_items_on_disk", coldDataCount)
+		"cold_items_on_disk", len(coldKeys),
+		"corrupted_records", corrupted,
+		"codec", header.codec,
+		"encrypted", header.cipher != nil)
 
 	if len(indexedFields) > 0 {
 		slog.Info("Rebuilding indexes for hot data in collection", "collection", collectionName, "index_count", len(indexedFields))
-		for _, field := range indexedFields {
-			s.CreateIndex(field)
-		}
+		rebuildPersistedIndexes(s, indexedFields)
 		slog.Info("Finished rebuilding indexes for hot data", "collection", collectionName)
 	}
 
 	return nil
 }
 
+// createdTsFieldName is the quoted CREATED_TS key, used to skip decoding documents that already carry it.
+var createdTsFieldName = []byte(`"` + globalconst.CREATED_TS + `"`)
+
+// disabledIndexMarker prefixes the field name of a disabled index in the file header,
+// so its paused state survives restarts without changing the on-disk layout.
+const disabledIndexMarker = "!"
+
+// caseInsensitiveIndexMarker prefixes the field name of a case-insensitive index in the file header.
+// It follows the disabled marker when both apply.
+const caseInsensitiveIndexMarker = "~"
+
+// uniqueIndexMarker prefixes the field name of a unique index in the file header.
+const uniqueIndexMarker = "^"
+
+// persistedIndexEntries returns the index header entries for a store, marking disabled,
+// case-insensitive and unique indexes.
+func persistedIndexEntries(s store.DataStore) []string {
+	disabled := make(map[string]struct{})
+	for _, field := range s.ListDisabledIndexes() {
+		disabled[field] = struct{}{}
+	}
+	fields := s.ListIndexes()
+	entries := make([]string, 0, len(fields))
+	for _, field := range fields {
+		entry := field
+		options, _ := s.GetIndexOptions(field)
+		if options.Unique {
+			entry = uniqueIndexMarker + entry
+		}
+		if options.CaseInsensitive {
+			entry = caseInsensitiveIndexMarker + entry
+		}
+		if _, ok := disabled[field]; ok {
+			entry = disabledIndexMarker + entry
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// rebuildPersistedIndexes recreates indexes from header entries, restoring their disabled state
+// and options.
+func rebuildPersistedIndexes(s store.DataStore, entries []string) {
+	for _, entry := range entries {
+		field, disabled := strings.CutPrefix(entry, disabledIndexMarker)
+		var options store.IndexOptions
+		field, options.CaseInsensitive = strings.CutPrefix(field, caseInsensitiveIndexMarker)
+		field, options.Unique = strings.CutPrefix(field, uniqueIndexMarker)
+		s.CreateIndexWithOptions(field, options)
+		if disabled {
+			s.DisableIndex(field)
+		}
+	}
+}
+
 // ListCollectionFiles returns a list of all collection names found on disk.
 func ListCollectionFiles() ([]string, error) {
 	if _, err := os.Stat(globalconst.CollectionsDirName); os.IsNotExist(err) {
@@ -377,30 +613,87 @@ func ListCollectionFiles() ([]string, error) {
 	return names, nil
 }
 
+// CollectionFileExists reports whether a collection has a data file on disk.
+func CollectionFileExists(collectionName string) (bool, error) {
+	filePath := filepath.Join(globalconst.CollectionsDirName, collectionName+globalconst.DBFileExtension)
+	if _, err := os.Stat(filePath); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check collection file '%s': %w", filePath, err)
+	}
+	return true, nil
+}
+
+// HotThreshold returns the creation time before which documents stay on disk (cold).
+// A zero time means hot/cold storage is disabled and everything is loaded into RAM.
+func HotThreshold(coldStorageMonths int) time.Time {
+	if coldStorageMonths <= 0 {
+		return time.Time{}
+	}
+	return time.Now().AddDate(0, -coldStorageMonths, 0)
+}
+
+// IsColdDocument reports whether a document read from a collection file belongs to cold storage,
+// i.e. it was created before hotThreshold. Younger documents on disk are copies of hot items.
+func IsColdDocument(doc map[string]any, hotThreshold time.Time) bool {
+	createdAtStr, _ := doc[globalconst.CREATED_AT].(string)
+	createdAt, err := time.Parse(time.RFC3339, createdAtStr)
+	return err == nil && createdAt.Before(hotThreshold)
+}
+
 // LoadAllCollectionsIntoManager loads all existing collections from disk into the CollectionManager.
+// Collections are independent of each other, so they are loaded by a bounded pool of workers
+// and the CPU-bound index rebuilds of large collections run in parallel.
+// A collection that fails to load is skipped, unless the recovery mode is strict.
 func LoadAllCollectionsIntoManager(cm *store.CollectionManager, coldStorageMonths int) error {
 	collectionNames, err := ListCollectionFiles()
 	if err != nil {
 		return fmt.Errorf("failed to get list of collection files: %w", err)
 	}
 
-	var hotThreshold time.Time
-	if coldStorageMonths > 0 {
-		hotThreshold = time.Now().AddDate(0, -coldStorageMonths, 0)
+	hotThreshold := HotThreshold(coldStorageMonths)
+	if !hotThreshold.IsZero() {
 		slog.Info("Hot/Cold storage enabled", "hot_threshold", hotThreshold.Format(time.RFC3339))
 	} else {
 		slog.Info("Hot/Cold storage is disabled. All data will be loaded into RAM.")
 	}
 
+	workers := min(runtime.NumCPU(), len(collectionNames))
+	names := make(chan string)
+	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var firstErr error
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for colName := range names {
+				colStore := cm.GetCollection(colName)
+				if err := LoadCollectionData(colName, colStore, hotThreshold); err != nil {
+					if strictRecovery.Load() {
+						errMu.Lock()
+						if firstErr == nil {
+							firstErr = fmt.Errorf("failed to load collection '%s': %w", colName, err)
+						}
+						errMu.Unlock()
+						continue
+					}
+					slog.Warn("Failed to load data for collection, skipping", "collection", colName, "error", err)
+				}
+			}
+		}()
+	}
 	for _, colName := range collectionNames {
-		colStore := cm.GetCollection(colName)
-		if err := LoadCollectionData(colName, colStore, hotThreshold); err != nil {
-			slog.Warn("Failed to load data for collection, skipping", "collection", colName, "error", err)
-			continue
-		}
+		names <- colName
+	}
+	close(names)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
 	}
 
-	slog.Info("Finished loading all collections into manager.")
+	slog.Info("Finished loading all collections into manager.", "workers", workers)
 	return nil
 }
 
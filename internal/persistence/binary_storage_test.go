@@ -0,0 +1,114 @@
+package persistence
+
+import (
+	"fmt"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/store"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// discardPersister is a store.CollectionPersister that keeps nothing on disk, so loading
+// collections does not write their files back.
+type discardPersister struct{}
+
+func (discardPersister) SaveCollectionData(string, store.DataStore) error          { return nil }
+func (discardPersister) DeleteCollectionFile(string) error                         { return nil }
+func (discardPersister) RenameCollectionFile(string, string) error                 { return nil }
+func (discardPersister) CopyCollectionFile(string, string) error                   { return nil }
+func (discardPersister) TruncateCollectionFile(string) error                       { return nil }
+func (discardPersister) WriteColdItems(string, map[string][]byte) error            { return nil }
+func (discardPersister) ScanColdDocuments(string, func(map[string]any) bool) error { return nil }
+
+// writeIndexedCollections writes collections "col0".."colN" with docs documents each and
+// indexes on "group" and "n".
+func writeIndexedCollections(tb testing.TB, collections, docs int) {
+	tb.Helper()
+	persister := &CollectionPersisterImpl{}
+	for c := range collections {
+		s := store.NewInMemStoreWithShards(4)
+		s.CreateIndex("group")
+		s.CreateIndex("n")
+		for i := range docs {
+			key := fmt.Sprintf("d%d", i)
+			s.Set(key, fmt.Appendf(nil, `{"_id":"%s","group":"g%d","n":%d,"col":%d}`, key, i%5, i, c), 0)
+		}
+		if err := persister.SaveCollectionData(fmt.Sprintf("col%d", c), s); err != nil {
+			tb.Fatalf("writing collection %d: %v", c, err)
+		}
+	}
+}
+
+func TestLoadAllCollectionsBuildsEveryIndex(t *testing.T) {
+	t.Chdir(t.TempDir())
+	const collections, docs = 12, 200
+	writeIndexedCollections(t, collections, docs)
+
+	cm := store.NewCollectionManager(discardPersister{}, 4)
+	if err := LoadAllCollectionsIntoManager(cm, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for c := range collections {
+		name := fmt.Sprintf("col%d", c)
+		if !cm.CollectionExists(name) {
+			t.Fatalf("collection %s was not loaded", name)
+		}
+		colStore := cm.GetCollection(name)
+		if colStore.Size() != docs {
+			t.Fatalf("%s has %d items, want %d", name, colStore.Size(), docs)
+		}
+		if indexes := colStore.ListIndexes(); !slices.Contains(indexes, "group") || !slices.Contains(indexes, "n") {
+			t.Fatalf("%s indexes = %v, want group and n", name, indexes)
+		}
+		keys, used := colStore.Lookup("group", "g3")
+		if !used || len(keys) != docs/5 {
+			t.Errorf("%s: lookup of g3 found %d keys (used %v), want %d", name, len(keys), used, docs/5)
+		}
+		keys, used = colStore.LookupRange("n", float64(10), float64(19), true, true)
+		if !used || len(keys) != 10 {
+			t.Errorf("%s: range lookup found %d keys (used %v), want 10", name, len(keys), used)
+		}
+		value, _ := colStore.Get("d7")
+		if want := fmt.Sprintf(`"col":%d`, c); !strings.Contains(string(value), want) {
+			t.Errorf("%s: d7 = %s, want a document of this collection", name, value)
+		}
+	}
+}
+
+// BenchmarkLoadAllCollections measures startup loading of many indexed collections, whose
+// index backfill runs on a worker per CPU.
+func BenchmarkLoadAllCollections(b *testing.B) {
+	b.Chdir(b.TempDir())
+	writeIndexedCollections(b, 16, 2000)
+
+	for b.Loop() {
+		cm := store.NewCollectionManager(discardPersister{}, 16)
+		if err := LoadAllCollectionsIntoManager(cm, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestLoadBackfillsCreatedTs(t *testing.T) {
+	t.Chdir(t.TempDir())
+	old := store.NewInMemStoreWithShards(4)
+	old.Set("a", []byte(`{"_id":"a","created_at":"2021-06-01T00:00:00Z"}`), 0)
+	old.Set("b", []byte(`{"_id":"b","created_at":"2023-06-01T00:00:00Z"}`), 0)
+	if err := (&CollectionPersisterImpl{}).SaveCollectionData("legacy", old); err != nil {
+		t.Fatal(err)
+	}
+
+	cm := store.NewCollectionManager(discardPersister{}, 4)
+	cm.SetCreatedTsIndex(true)
+	if err := LoadAllCollectionsIntoManager(cm, 0); err != nil {
+		t.Fatal(err)
+	}
+	cutoff := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	keys, used := cm.GetCollection("legacy").LookupRange(globalconst.CREATED_TS, nil, float64(cutoff), false, false)
+	if !used || !slices.Equal(keys, []string{"a"}) {
+		t.Fatalf("documents created before 2022 = %v (index used %v), want [a]", keys, used)
+	}
+}
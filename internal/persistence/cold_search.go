@@ -18,60 +18,76 @@ type MatcherFunc func(item map[string]any) bool
 // SearchColdData searches a collection's persistence file for items that match a filter.
 // This is an I/O-intensive operation that sequentially reads the file.
 func SearchColdData(collectionName string, matcher MatcherFunc) ([]map[string]any, error) {
+	results := []map[string]any{}
+	err := ScanColdData(collectionName, matcher, func(doc map[string]any) bool {
+		results = append(results, doc)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Debug("Cold data search complete", "collection", collectionName, "found_matches", len(results))
+	return results, nil
+}
+
+// ScanColdData reads a collection's persistence file sequentially and passes every live
+// document accepted by matcher to visit, one at a time, without keeping them in memory.
+// Scanning stops early if visit returns false.
+func ScanColdData(collectionName string, matcher MatcherFunc, visit func(doc map[string]any) bool) error {
 	filePath := filepath.Join(globalconst.CollectionsDirName, collectionName+globalconst.DBFileExtension)
 	file, err := os.Open(filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return []map[string]any{}, nil // No file, so no cold data.
+			return nil // No file, so no cold data.
 		}
-		return nil, fmt.Errorf("failed to open cold data file '%s': %w", filePath, err)
+		return fmt.Errorf("failed to open cold data file '%s': %w", filePath, err)
 	}
 	defer file.Close()
 
-	// We skip the index header, as we don't use it for the cold search.
+	// Only the record format is needed from the index header.
 	var numIndexes uint32
 	if err := binary.Read(file, binary.LittleEndian, &numIndexes); err != nil {
 		if err == io.EOF {
 			numIndexes = 0
 			if _, seekErr := file.Seek(0, 0); seekErr != nil {
-				return nil, fmt.Errorf("failed to seek back to start of file for '%s': %w", collectionName, seekErr)
+				return fmt.Errorf("failed to seek back to start of file for '%s': %w", collectionName, seekErr)
 			}
 		} else {
-			return nil, fmt.Errorf("failed to read index header from cold file '%s': %w", filePath, err)
+			return fmt.Errorf("failed to read index header from cold file '%s': %w", filePath, err)
 		}
 	}
 
-	for i := 0; i < int(numIndexes); i++ {
-		var fieldLen uint32
-		if err := binary.Read(file, binary.LittleEndian, &fieldLen); err != nil {
-			return nil, fmt.Errorf("failed to read index field length from cold file: %w", err)
-		}
-		if _, err := io.CopyN(io.Discard, file, int64(fieldLen)); err != nil {
-			return nil, fmt.Errorf("failed to discard index field name from cold file: %w", err)
-		}
+	header, err := readCollectionHeader(file, numIndexes)
+	if err != nil {
+		return fmt.Errorf("failed to read index header from cold file '%s': %w", filePath, err)
 	}
 
 	var numEntries uint32
 	if err := binary.Read(file, binary.LittleEndian, &numEntries); err != nil {
 		if err == io.EOF {
-			return []map[string]any{}, nil
+			return nil
 		}
-		return nil, fmt.Errorf("failed to read number of entries from cold file '%s': %w", filePath, err)
+		return fmt.Errorf("failed to read number of entries from cold file '%s': %w", filePath, err)
 	}
 
-	var results []map[string]any
+	records := newRecordReader(file, header.checksums, fileSize(filePath))
 	for i := 0; i < int(numEntries); i++ {
-		_, err := readPrefixedBytes(file) // Read and discard the key
+		keyBytes, valBytes, err := records.next()
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			slog.Warn("Failed to read key in cold search, skipping record", "collection", collectionName, "error", err)
-			continue
+			if skipCorruptRecord(filePath, err) {
+				continue
+			}
+			if strictRecovery.Load() {
+				return fmt.Errorf("failed to read record %d from cold file '%s': %w", i, filePath, err)
+			}
+			slog.Error("Cold data file is corrupted, stopping the scan", "collection", collectionName, "path", filePath, "entry", i, "error", err)
+			break
 		}
-		valBytes, err := readPrefixedBytes(file)
-		if err != nil {
-			slog.Warn("Failed to read value in cold search, skipping record", "collection", collectionName, "error", err)
+		if valBytes, err = header.decodeValue(uint64(i), keyBytes, valBytes); err != nil {
+			slog.Warn("Failed to decode value in cold search, skipping record", "collection", collectionName, "error", err)
 			continue
 		}
 
@@ -84,13 +100,12 @@ func SearchColdData(collectionName string, matcher MatcherFunc) ([]map[string]an
 			continue
 		}
 
-		if matcher(doc) {
-			results = append(results, doc)
+		if matcher(doc) && !visit(doc) {
+			break
 		}
 	}
 
-	slog.Debug("Cold data search complete", "collection", collectionName, "found_matches", len(results))
-	return results, nil
+	return nil
 }
 
 // readPrefixedBytes is a helper function to read length-prefixed data.
@@ -1,11 +1,14 @@
 package persistence
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"memory-tools/internal/globalconst"
+	"memory-tools/internal/store"
 	"os"
 	"path/filepath"
 	"time"
@@ -15,19 +18,34 @@ import (
 
 // rewriteCollectionFile atomically rewrites a collection's data file.
 // It iterates through the existing file and uses the updateFunc to decide
-// what to do with each item (keep, modify, or skip).
+// what to do with each item (keep, modify, or skip). updateFunc sees decoded values;
+// the file keeps its codec and is encrypted with the current key, if any.
 func rewriteCollectionFile(collectionName string, updateFunc func(key string, data []byte) ([]byte, error)) error {
+	return rewriteCollectionFileAppending(collectionName, updateFunc, nil)
+}
+
+// rewriteCollectionFileAppending is rewriteCollectionFile followed by appending new records.
+// When there is something to append, a missing file is created without index entries.
+func rewriteCollectionFileAppending(collectionName string, updateFunc func(key string, data []byte) ([]byte, error), appendRecords map[string][]byte) error {
 	filePath := filepath.Join(globalconst.CollectionsDirName, collectionName+globalconst.DBFileExtension)
 	tempFilePath := filePath + ".tmp"
 
-	sourceFile, err := os.Open(filePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil // File doesn't exist, nothing to rewrite.
-		}
+	var numIndexes, numEntries uint32
+	var sourceFile io.Reader
+	file, err := os.Open(filePath)
+	switch {
+	case err == nil:
+		defer file.Close()
+		sourceFile = file
+	case !os.IsNotExist(err):
 		return fmt.Errorf("failed to open source collection file '%s': %w", filePath, err)
+	case len(appendRecords) == 0:
+		return nil // File doesn't exist, nothing to rewrite.
+	default:
+		if err := os.MkdirAll(globalconst.CollectionsDirName, 0755); err != nil {
+			return fmt.Errorf("failed to create collections directory '%s': %w", globalconst.CollectionsDirName, err)
+		}
 	}
-	defer sourceFile.Close()
 
 	destFile, err := os.Create(tempFilePath)
 	if err != nil {
@@ -35,79 +53,101 @@ func rewriteCollectionFile(collectionName string, updateFunc func(key string, da
 	}
 	defer destFile.Close()
 
-	// Preserve the index header.
-	var numIndexes uint32
-	if err := binary.Read(sourceFile, binary.LittleEndian, &numIndexes); err != nil {
-		return fmt.Errorf("rewrite: failed to read index header count: %w", err)
+	// Preserve the index header and codec. The rewritten file always has checksums, and a fresh
+	// nonce under the current key if it is encrypted, so rewrites also complete key rotations.
+	header := collectionHeader{codec: FileCodecNone}
+	if sourceFile != nil {
+		if err := binary.Read(sourceFile, binary.LittleEndian, &numIndexes); err != nil {
+			return fmt.Errorf("rewrite: failed to read index header count: %w", err)
+		}
+		if header, err = readCollectionHeader(sourceFile, numIndexes); err != nil {
+			return fmt.Errorf("rewrite: failed to read index header: %w", err)
+		}
+		if err := binary.Read(sourceFile, binary.LittleEndian, &numEntries); err != nil {
+			return fmt.Errorf("rewrite: failed to read entry count: %w", err)
+		}
 	}
-	if err := binary.Write(destFile, binary.LittleEndian, numIndexes); err != nil {
-		return fmt.Errorf("rewrite: failed to write index header count: %w", err)
+	dst, err := newCollectionHeader(header.codec)
+	if err != nil {
+		return fmt.Errorf("rewrite: failed to prepare file header: %w", err)
 	}
-
-	indexedFields := make([][]byte, numIndexes)
-	for i := 0; i < int(numIndexes); i++ {
-		fieldBytes, err := readPrefixedBytes(sourceFile)
-		if err != nil {
-			return fmt.Errorf("rewrite: failed to read index field name: %w", err)
+	headerEntries := append(header.indexEntries, dst.formatEntries()...)
+	writeHeader := func(count uint32) error {
+		if err := binary.Write(destFile, binary.LittleEndian, uint32(len(headerEntries))); err != nil {
+			return fmt.Errorf("rewrite: failed to write index header count: %w", err)
+		}
+		for _, entry := range headerEntries {
+			if err := writePrefixedBytes(destFile, []byte(entry)); err != nil {
+				return fmt.Errorf("rewrite: failed to write index field name: %w", err)
+			}
 		}
-		indexedFields[i] = fieldBytes
-		if err := writePrefixedBytes(destFile, fieldBytes); err != nil {
-			return fmt.Errorf("rewrite: failed to write index field name: %w", err)
+		if err := binary.Write(destFile, binary.LittleEndian, count); err != nil {
+			return fmt.Errorf("rewrite: failed to write entry count: %w", err)
 		}
+		return nil
 	}
-
-	var numEntries uint32
-	if err := binary.Read(sourceFile, binary.LittleEndian, &numEntries); err != nil {
-		return fmt.Errorf("rewrite: failed to read entry count: %w", err)
-	}
-
-	if err := binary.Write(destFile, binary.LittleEndian, uint32(0)); err != nil {
-		return fmt.Errorf("rewrite: failed to write placeholder entry count: %w", err)
+	// The entry count is a placeholder until every record has been written.
+	if err := writeHeader(0); err != nil {
+		return err
 	}
 
 	var finalCount uint32
+	var records *recordReader
+	if sourceFile != nil {
+		records = newRecordReader(sourceFile, header.checksums, fileSize(filePath))
+	}
 	for i := 0; i < int(numEntries); i++ {
-		keyBytes, err := readPrefixedBytes(sourceFile)
+		keyBytes, valBytes, err := records.next()
 		if err != nil {
-			return fmt.Errorf("rewrite: failed to read key at entry %d: %w", i, err)
+			if skipCorruptRecord(filePath, err) {
+				continue
+			}
+			return fmt.Errorf("rewrite: failed to read entry %d: %w", i, noEOF(err))
 		}
-		valBytes, err := readPrefixedBytes(sourceFile)
+
+		decoded, err := header.decodeValue(uint64(i), keyBytes, valBytes)
 		if err != nil {
-			return fmt.Errorf("rewrite: failed to read value at entry %d: %w", i, err)
+			return fmt.Errorf("rewrite: failed to decode value for '%s': %w", string(keyBytes), err)
 		}
-
-		newValBytes, err := updateFunc(string(keyBytes), valBytes)
+		newValBytes, err := updateFunc(string(keyBytes), decoded)
 		if err != nil {
 			return fmt.Errorf("rewrite: update function failed for key '%s': %w", string(keyBytes), err)
 		}
+		if newValBytes != nil && !dst.isPlain() {
+			// Unchanged values keep their encoded form when the encoding allows it, so untouched
+			// records are not recompressed.
+			if header.sameEncoding(dst) && bytes.Equal(newValBytes, decoded) {
+				newValBytes = valBytes
+			} else if newValBytes, err = dst.encodeValue(uint64(finalCount), keyBytes, newValBytes); err != nil {
+				return fmt.Errorf("rewrite: failed to encode value for '%s': %w", string(keyBytes), err)
+			}
+		}
 
 		if newValBytes != nil {
-			if err := writePrefixedBytes(destFile, keyBytes); err != nil {
-				return fmt.Errorf("rewrite: failed to write key for '%s': %w", string(keyBytes), err)
-			}
-			if err := writePrefixedBytes(destFile, newValBytes); err != nil {
-				return fmt.Errorf("rewrite: failed to write value for '%s': %w", string(keyBytes), err)
+			if err := writeRecord(destFile, keyBytes, newValBytes); err != nil {
+				return fmt.Errorf("rewrite: failed to write record for '%s': %w", string(keyBytes), err)
 			}
 			finalCount++
 		}
 	}
 
+	for key, value := range appendRecords {
+		value, err := dst.encodeValue(uint64(finalCount), []byte(key), value)
+		if err != nil {
+			return fmt.Errorf("rewrite: failed to encode value for '%s': %w", key, err)
+		}
+		if err := writeRecord(destFile, []byte(key), value); err != nil {
+			return fmt.Errorf("rewrite: failed to write record for '%s': %w", key, err)
+		}
+		finalCount++
+	}
+
 	// Go back to the beginning to write the final count.
 	if _, err := destFile.Seek(0, 0); err != nil {
 		return fmt.Errorf("rewrite: failed to seek to start of temp file: %w", err)
 	}
-
-	// Re-write the header (indexes and final count).
-	if err := binary.Write(destFile, binary.LittleEndian, numIndexes); err != nil {
-		return fmt.Errorf("rewrite: failed to write final index count: %w", err)
-	}
-	for _, fieldBytes := range indexedFields {
-		if err := writePrefixedBytes(destFile, fieldBytes); err != nil {
-			return fmt.Errorf("rewrite: failed to write final index field name: %w", err)
-		}
-	}
-	if err := binary.Write(destFile, binary.LittleEndian, finalCount); err != nil {
-		return fmt.Errorf("rewrite: failed to write final entry count: %w", err)
+	if err := writeHeader(finalCount); err != nil {
+		return err
 	}
 
 	if err := destFile.Close(); err != nil {
@@ -122,6 +162,38 @@ func rewriteCollectionFile(collectionName string, updateFunc func(key string, da
 	return nil
 }
 
+// WriteColdItems writes items to a collection's data file, replacing the records of keys already
+// in it and appending the others. The memory cap uses it to move items out of RAM.
+func (p *CollectionPersisterImpl) WriteColdItems(collectionName string, items map[string][]byte) error {
+	remaining := maps.Clone(items)
+	return rewriteCollectionFileAppending(collectionName, func(key string, data []byte) ([]byte, error) {
+		value, ok := items[key]
+		if !ok {
+			return data, nil
+		}
+		// A key the file holds twice keeps a single record.
+		if _, pending := remaining[key]; !pending {
+			return nil, nil
+		}
+		delete(remaining, key)
+		return value, nil
+	}, remaining)
+}
+
+// TruncateCollectionFile removes every record from a collection's data file. The index header and
+// codec are kept, so the collection reloads empty with the same indexes.
+func (p *CollectionPersisterImpl) TruncateCollectionFile(collectionName string) error {
+	return rewriteCollectionFile(collectionName, func(key string, data []byte) ([]byte, error) {
+		return nil, nil
+	})
+}
+
+// ScanColdDocuments passes every live document of a collection's data file to visit. Unique indexes
+// use it to find values held by documents that are not in memory.
+func (p *CollectionPersisterImpl) ScanColdDocuments(collectionName string, visit func(doc map[string]any) bool) error {
+	return ScanColdData(collectionName, func(map[string]any) bool { return true }, visit)
+}
+
 // UpdateColdItem finds a cold item by key and applies a patch to it on disk.
 func UpdateColdItem(collectionName, key string, patchValue []byte) (bool, error) {
 	found := false
@@ -142,19 +214,141 @@ func UpdateColdItem(collectionName, key string, patchValue []byte) (bool, error)
 		}
 
 		for k, v := range patchData {
-			if k == globalconst.ID || k == globalconst.CREATED_AT {
+			if store.IsManagedField(k) {
+				continue
+			}
+			existingData[k] = v
+		}
+		existingData[globalconst.UPDATED_AT] = time.Now().UTC().Format(time.RFC3339)
+		store.BumpVersion(existingData)
+
+		return jsoniter.Marshal(existingData)
+	})
+
+	return found, err
+}
+
+// UpdateColdItemIf applies a patch to a cold item on disk only if condition accepts its current document.
+// It reports whether the key was found and whether the patch was applied.
+func UpdateColdItemIf(collectionName, key string, patchValue []byte, condition func(doc map[string]any) bool) (found, applied bool, err error) {
+	var patchData map[string]any
+	if err := jsoniter.Unmarshal(patchValue, &patchData); err != nil {
+		return false, false, fmt.Errorf("could not unmarshal patch data: %w", err)
+	}
+
+	err = rewriteCollectionFile(collectionName, func(itemKey string, data []byte) ([]byte, error) {
+		if itemKey != key {
+			return data, nil
+		}
+
+		var existingData map[string]any
+		if err := jsoniter.Unmarshal(data, &existingData); err != nil {
+			return nil, fmt.Errorf("could not unmarshal existing cold data: %w", err)
+		}
+		if deleted, _ := existingData[globalconst.DELETED_FLAG].(bool); deleted {
+			return data, nil
+		}
+		found = true
+		if !condition(existingData) {
+			return data, nil
+		}
+
+		applied = true
+		for k, v := range patchData {
+			if store.IsManagedField(k) {
 				continue
 			}
 			existingData[k] = v
 		}
 		existingData[globalconst.UPDATED_AT] = time.Now().UTC().Format(time.RFC3339)
+		store.BumpVersion(existingData)
 
 		return jsoniter.Marshal(existingData)
 	})
 
+	return found, applied, err
+}
+
+// IncrementColdItemField atomically adds delta to a numeric field of a cold item on disk and
+// returns the new value. It reports whether the key was found; a non-numeric field makes it
+// fail with an error wrapping store.ErrFieldNotNumeric.
+func IncrementColdItemField(collectionName, key, field string, delta float64) (newValue float64, found bool, err error) {
+	err = rewriteCollectionFile(collectionName, func(itemKey string, data []byte) ([]byte, error) {
+		if itemKey != key {
+			return data, nil
+		}
+
+		var existingData map[string]any
+		if err := jsoniter.Unmarshal(data, &existingData); err != nil {
+			return nil, fmt.Errorf("could not unmarshal existing cold data: %w", err)
+		}
+		if deleted, _ := existingData[globalconst.DELETED_FLAG].(bool); deleted {
+			return data, nil
+		}
+		found = true
+
+		newValue, err = store.IncrementField(existingData, field, delta)
+		if err != nil {
+			return nil, err
+		}
+		existingData[globalconst.UPDATED_AT] = time.Now().UTC().Format(time.RFC3339)
+		store.BumpVersion(existingData)
+
+		return jsoniter.Marshal(existingData)
+	})
+
+	return newValue, found, err
+}
+
+// ReplaceColdItem overwrites a cold item on disk with a new document, keeping its _id and creation time.
+// It reports whether the key was found.
+func ReplaceColdItem(collectionName, key string, value []byte) (bool, error) {
+	found, _, err := ReplaceColdItemIf(collectionName, key, value, func(map[string]any) bool { return true })
 	return found, err
 }
 
+// ReplaceColdItemIf overwrites a cold item on disk like ReplaceColdItem, but only if condition
+// accepts its current document. It reports whether the key was found and whether it was replaced.
+func ReplaceColdItemIf(collectionName, key string, value []byte, condition func(doc map[string]any) bool) (found, applied bool, err error) {
+	var newData map[string]any
+	if err := jsoniter.Unmarshal(value, &newData); err != nil {
+		return false, false, fmt.Errorf("could not unmarshal replacement data: %w", err)
+	}
+	for k := range newData {
+		if store.IsManagedField(k) {
+			delete(newData, k)
+		}
+	}
+
+	err = rewriteCollectionFile(collectionName, func(itemKey string, data []byte) ([]byte, error) {
+		if itemKey != key {
+			return data, nil
+		}
+
+		var existingData map[string]any
+		if err := jsoniter.Unmarshal(data, &existingData); err != nil {
+			return nil, fmt.Errorf("could not unmarshal existing cold data: %w", err)
+		}
+		if deleted, _ := existingData[globalconst.DELETED_FLAG].(bool); deleted {
+			return data, nil
+		}
+		found = true
+		if !condition(existingData) {
+			return data, nil
+		}
+
+		applied = true
+		newData[globalconst.ID] = key
+		store.CopyCreationTime(newData, existingData)
+		newData[globalconst.UPDATED_AT] = time.Now().UTC().Format(time.RFC3339)
+		store.SetVersion(newData, store.VersionOf(existingData)+1)
+
+		return jsoniter.Marshal(newData)
+	})
+
+	return found, applied, err
+}
+
 // DeleteColdItem finds a cold item by key and marks it as deleted on disk (tombstone).
 func DeleteColdItem(collectionName, key string) (bool, error) {
 	found := false
@@ -178,10 +372,48 @@ func DeleteColdItem(collectionName, key string) (bool, error) {
 	return found, err
 }
 
+// TakeColdItem marks a cold item as deleted on disk and returns the document it held.
+// Only documents for which isCold holds are taken: the others live in RAM, so a copy
+// of them on disk is a stale snapshot that must not be handed out a second time.
+// Items that are already tombstoned are reported as not found.
+func TakeColdItem(collectionName, key string, isCold func(doc map[string]any) bool) ([]byte, bool, error) {
+	var value []byte
+	found := false
+	err := rewriteCollectionFile(collectionName, func(itemKey string, data []byte) ([]byte, error) {
+		if itemKey != key {
+			return data, nil
+		}
+
+		var doc map[string]any
+		if err := jsoniter.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("could not unmarshal cold data for deletion: %w", err)
+		}
+		if deleted, _ := doc[globalconst.DELETED_FLAG].(bool); deleted {
+			return data, nil
+		}
+		if !isCold(doc) {
+			return data, nil
+		}
+		found = true
+		value = data
+
+		doc[globalconst.DELETED_FLAG] = true
+		doc[globalconst.UPDATED_AT] = time.Now().UTC().Format(time.RFC3339)
+
+		return jsoniter.Marshal(doc)
+	})
+
+	return value, found, err
+}
+
 // CompactCollectionFile rewrites a collection file, permanently removing tombstones.
-func CompactCollectionFile(collectionName string) error {
+// It returns the number of bytes by which the file shrank.
+func CompactCollectionFile(collectionName string) (int64, error) {
 	slog.Info("Compacting collection file", "collection", collectionName)
-	return rewriteCollectionFile(collectionName, func(key string, data []byte) ([]byte, error) {
+	filePath := filepath.Join(globalconst.CollectionsDirName, collectionName+globalconst.DBFileExtension)
+	sizeBefore := fileSize(filePath)
+
+	err := rewriteCollectionFile(collectionName, func(key string, data []byte) ([]byte, error) {
 		var doc map[string]any
 		if err := jsoniter.Unmarshal(data, &doc); err != nil {
 			return data, nil
@@ -193,6 +425,19 @@ func CompactCollectionFile(collectionName string) error {
 
 		return data, nil // Keep this record.
 	})
+	if err != nil {
+		return 0, err
+	}
+	return max(sizeBefore-fileSize(filePath), 0), nil
+}
+
+// fileSize returns the size of a file in bytes, or zero if it cannot be read.
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
 }
 
 // writePrefixedBytes is a helper for the rewriter.
@@ -229,12 +474,13 @@ func UpdateManyColdItems(collectionName string, payloads []ColdUpdatePayload) (i
 			}
 
 			for k, v := range patchData {
-				if k == globalconst.ID || k == globalconst.CREATED_AT {
+				if store.IsManagedField(k) {
 					continue
 				}
 				existingData[k] = v
 			}
 			existingData[globalconst.UPDATED_AT] = time.Now().UTC().Format(time.RFC3339)
+			store.BumpVersion(existingData)
 
 			return jsoniter.Marshal(existingData)
 		}
@@ -245,6 +491,30 @@ func UpdateManyColdItems(collectionName string, payloads []ColdUpdatePayload) (i
 	return updatedCount, err
 }
 
+// MergeColdItemsMatching applies an RFC 7386 merge patch to every live cold item accepted by match,
+// in a single file rewrite. It returns the number of items changed.
+func MergeColdItemsMatching(collectionName string, patch map[string]any, match func(key string, doc map[string]any) bool) (int, error) {
+	mergedCount := 0
+	now := time.Now().UTC().Format(time.RFC3339)
+	err := rewriteCollectionFile(collectionName, func(itemKey string, data []byte) ([]byte, error) {
+		var doc map[string]any
+		if err := jsoniter.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("could not unmarshal cold data for merge: %w", err)
+		}
+		if deleted, _ := doc[globalconst.DELETED_FLAG].(bool); deleted || !match(itemKey, doc) {
+			return data, nil
+		}
+
+		mergedCount++
+		store.MergePatch(doc, patch)
+		doc[globalconst.UPDATED_AT] = now
+		store.BumpVersion(doc)
+		return jsoniter.Marshal(doc)
+	})
+
+	return mergedCount, err
+}
+
 // DeleteManyColdItems marks multiple cold items as deleted (tombstone) in a single file rewrite.
 func DeleteManyColdItems(collectionName string, keys []string) (int, error) {
 	keysToDelete := make(map[string]struct{}, len(keys))
@@ -290,12 +560,9 @@ func CheckColdKeyExists(collectionName, keyToFind string) (bool, error) {
 	if err := binary.Read(file, binary.LittleEndian, &numIndexes); err != nil {
 		return false, nil
 	}
-	for i := 0; i < int(numIndexes); i++ {
-		fieldBytes, err := readPrefixedBytes(file)
-		if err != nil {
-			return false, fmt.Errorf("could not read index field name: %w", err)
-		}
-		_ = fieldBytes
+	header, err := readCollectionHeader(file, numIndexes)
+	if err != nil {
+		return false, err
 	}
 
 	var numEntries uint32
@@ -320,7 +587,7 @@ func CheckColdKeyExists(collectionName, keyToFind string) (bool, error) {
 		if err := binary.Read(file, binary.LittleEndian, &valLen); err != nil {
 			return false, fmt.Errorf("error reading value length for key '%s': %w", string(keyBytes), err)
 		}
-		if _, err := file.Seek(int64(valLen), io.SeekCurrent); err != nil {
+		if _, err := file.Seek(int64(valLen)+header.trailerSize(), io.SeekCurrent); err != nil {
 			return false, fmt.Errorf("error seeking past value for key '%s': %w", string(keyBytes), err)
 		}
 	}
@@ -353,9 +620,9 @@ func CheckManyColdKeysExist(collectionName string, keysToFind []string) (map[str
 
 	var numIndexes uint32
 	binary.Read(file, binary.LittleEndian, &numIndexes)
-	for i := 0; i < int(numIndexes); i++ {
-		fieldBytes, _ := readPrefixedBytes(file)
-		_ = fieldBytes
+	header, err := readCollectionHeader(file, numIndexes)
+	if err != nil {
+		return nil, err
 	}
 
 	var numEntries uint32
@@ -379,7 +646,7 @@ func CheckManyColdKeysExist(collectionName string, keysToFind []string) (map[str
 
 		var valLen uint32
 		binary.Read(file, binary.LittleEndian, &valLen)
-		file.Seek(int64(valLen), io.SeekCurrent)
+		file.Seek(int64(valLen)+header.trailerSize(), io.SeekCurrent)
 
 		if len(foundKeys) == len(keysToFind) {
 			break
@@ -388,3 +655,231 @@ func CheckManyColdKeysExist(collectionName string, keysToFind []string) (map[str
 
 	return foundKeys, nil
 }
+
+// ScanColdKeys passes the key of every live item in a collection's file that starts with prefix to
+// visit, in file order. Only the values of matching keys are read, to skip tombstones; the others
+// are seeked past. Scanning stops early if visit returns false.
+func ScanColdKeys(collectionName, prefix string, visit func(key string) bool) error {
+	filePath := filepath.Join(globalconst.CollectionsDirName, collectionName+globalconst.DBFileExtension)
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to open cold data file '%s': %w", filePath, err)
+	}
+	defer file.Close()
+
+	var numIndexes uint32
+	if err := binary.Read(file, binary.LittleEndian, &numIndexes); err != nil {
+		return nil
+	}
+	header, err := readCollectionHeader(file, numIndexes)
+	if err != nil {
+		return err
+	}
+
+	var numEntries uint32
+	if err := binary.Read(file, binary.LittleEndian, &numEntries); err != nil {
+		return nil
+	}
+
+	for i := 0; i < int(numEntries); i++ {
+		keyBytes, err := readPrefixedBytes(file)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("error reading key at position %d: %w", i, err)
+		}
+
+		if !bytes.HasPrefix(keyBytes, []byte(prefix)) {
+			var valLen uint32
+			if err := binary.Read(file, binary.LittleEndian, &valLen); err != nil {
+				return fmt.Errorf("error reading value length for key '%s': %w", string(keyBytes), err)
+			}
+			if _, err := file.Seek(int64(valLen)+header.trailerSize(), io.SeekCurrent); err != nil {
+				return fmt.Errorf("error seeking past value for key '%s': %w", string(keyBytes), err)
+			}
+			continue
+		}
+
+		valBytes, err := readPrefixedBytes(file)
+		if err != nil {
+			return fmt.Errorf("error reading value for key '%s': %w", string(keyBytes), err)
+		}
+		if header.checksums {
+			if err := verifyChecksum(file, keyBytes, valBytes); err != nil {
+				return err
+			}
+		}
+		if valBytes, err = header.decodeValue(uint64(i), keyBytes, valBytes); err != nil {
+			return fmt.Errorf("error decoding value for key '%s': %w", string(keyBytes), err)
+		}
+		var doc map[string]any
+		if err := jsoniter.Unmarshal(valBytes, &doc); err == nil {
+			if deleted, ok := doc[globalconst.DELETED_FLAG].(bool); ok && deleted {
+				continue
+			}
+		}
+		if !visit(string(keyBytes)) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// GetColdItem reads a single item's value from a collection's persistence file.
+// Items marked as deleted are reported as not found.
+func GetColdItem(collectionName, keyToFind string) ([]byte, bool, error) {
+	filePath := filepath.Join(globalconst.CollectionsDirName, collectionName+globalconst.DBFileExtension)
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to open cold data file '%s': %w", filePath, err)
+	}
+	defer file.Close()
+
+	var numIndexes uint32
+	if err := binary.Read(file, binary.LittleEndian, &numIndexes); err != nil {
+		return nil, false, nil
+	}
+	header, err := readCollectionHeader(file, numIndexes)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var numEntries uint32
+	if err := binary.Read(file, binary.LittleEndian, &numEntries); err != nil {
+		return nil, false, nil
+	}
+
+	for i := 0; i < int(numEntries); i++ {
+		keyBytes, err := readPrefixedBytes(file)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, false, fmt.Errorf("error reading key at position %d: %w", i, err)
+		}
+
+		if string(keyBytes) != keyToFind {
+			var valLen uint32
+			if err := binary.Read(file, binary.LittleEndian, &valLen); err != nil {
+				return nil, false, fmt.Errorf("error reading value length for key '%s': %w", string(keyBytes), err)
+			}
+			if _, err := file.Seek(int64(valLen)+header.trailerSize(), io.SeekCurrent); err != nil {
+				return nil, false, fmt.Errorf("error seeking past value for key '%s': %w", string(keyBytes), err)
+			}
+			continue
+		}
+
+		valBytes, err := readPrefixedBytes(file)
+		if err != nil {
+			return nil, false, fmt.Errorf("error reading value for key '%s': %w", keyToFind, err)
+		}
+		if header.checksums {
+			if err := verifyChecksum(file, keyBytes, valBytes); err != nil {
+				return nil, false, err
+			}
+		}
+		if valBytes, err = header.decodeValue(uint64(i), keyBytes, valBytes); err != nil {
+			return nil, false, fmt.Errorf("error decoding value for key '%s': %w", keyToFind, err)
+		}
+		var doc map[string]any
+		if err := jsoniter.Unmarshal(valBytes, &doc); err == nil {
+			if deleted, ok := doc[globalconst.DELETED_FLAG].(bool); ok && deleted {
+				return nil, false, nil
+			}
+		}
+		return valBytes, true, nil
+	}
+
+	return nil, false, nil
+}
+
+// GetManyColdItems reads the values of several items from a collection's persistence file in a
+// single pass. Only the values of requested keys are read; the others are seeked past. Items marked
+// as deleted are left out of the result, like missing ones.
+func GetManyColdItems(collectionName string, keysToFind []string) (map[string][]byte, error) {
+	found := make(map[string][]byte)
+	if len(keysToFind) == 0 {
+		return found, nil
+	}
+	wanted := make(map[string]struct{}, len(keysToFind))
+	for _, k := range keysToFind {
+		wanted[k] = struct{}{}
+	}
+
+	filePath := filepath.Join(globalconst.CollectionsDirName, collectionName+globalconst.DBFileExtension)
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return found, nil
+		}
+		return nil, fmt.Errorf("failed to open cold data file '%s': %w", filePath, err)
+	}
+	defer file.Close()
+
+	var numIndexes uint32
+	if err := binary.Read(file, binary.LittleEndian, &numIndexes); err != nil {
+		return found, nil
+	}
+	header, err := readCollectionHeader(file, numIndexes)
+	if err != nil {
+		return nil, err
+	}
+
+	var numEntries uint32
+	if err := binary.Read(file, binary.LittleEndian, &numEntries); err != nil {
+		return found, nil
+	}
+
+	for i := 0; i < int(numEntries) && len(wanted) > 0; i++ {
+		keyBytes, err := readPrefixedBytes(file)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("error reading key at position %d: %w", i, err)
+		}
+
+		key := string(keyBytes)
+		if _, ok := wanted[key]; !ok {
+			var valLen uint32
+			if err := binary.Read(file, binary.LittleEndian, &valLen); err != nil {
+				return nil, fmt.Errorf("error reading value length for key '%s': %w", key, err)
+			}
+			if _, err := file.Seek(int64(valLen)+header.trailerSize(), io.SeekCurrent); err != nil {
+				return nil, fmt.Errorf("error seeking past value for key '%s': %w", key, err)
+			}
+			continue
+		}
+		delete(wanted, key)
+
+		valBytes, err := readPrefixedBytes(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading value for key '%s': %w", key, err)
+		}
+		if header.checksums {
+			if err := verifyChecksum(file, keyBytes, valBytes); err != nil {
+				return nil, err
+			}
+		}
+		if valBytes, err = header.decodeValue(uint64(i), keyBytes, valBytes); err != nil {
+			return nil, fmt.Errorf("error decoding value for key '%s': %w", key, err)
+		}
+		var doc map[string]any
+		if err := jsoniter.Unmarshal(valBytes, &doc); err == nil {
+			if deleted, ok := doc[globalconst.DELETED_FLAG].(bool); ok && deleted {
+				continue
+			}
+		}
+		found[key] = valBytes
+	}
+
+	return found, nil
+}
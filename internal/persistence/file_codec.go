@@ -0,0 +1,78 @@
+package persistence
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// FileCodec names the compression applied to the values of a collection file.
+// Keys and the header stay uncompressed, so key lookups never decode values.
+type FileCodec string
+
+const (
+	// FileCodecNone stores values as plain JSON. Files written before file compression existed use it.
+	FileCodecNone FileCodec = "none"
+	// FileCodecGzip stores every value gzip-compressed.
+	FileCodecGzip FileCodec = "gzip"
+)
+
+// fileCodecMarker prefixes the header entry that records a file's codec. Files without it are
+// uncompressed, so older files load unchanged.
+const fileCodecMarker = "#codec:"
+
+// ParseFileCodec validates a codec name. An empty name means FileCodecNone.
+func ParseFileCodec(name string) (FileCodec, error) {
+	switch codec := FileCodec(strings.ToLower(strings.TrimSpace(name))); codec {
+	case "", FileCodecNone:
+		return FileCodecNone, nil
+	case FileCodecGzip:
+		return codec, nil
+	case "zstd":
+		return "", fmt.Errorf("file codec 'zstd' is not available in this build; supported codecs are 'none' and 'gzip'")
+	default:
+		return "", fmt.Errorf("unknown file codec '%s'; supported codecs are 'none' and 'gzip'", name)
+	}
+}
+
+// headerEntry returns the header entry recording the codec, or "" for uncompressed files.
+func (c FileCodec) headerEntry() string {
+	if c == FileCodecNone || c == "" {
+		return ""
+	}
+	return fileCodecMarker + string(c)
+}
+
+// encode compresses a value for writing to a file with this codec.
+func (c FileCodec) encode(value []byte) ([]byte, error) {
+	if c != FileCodecGzip {
+		return value, nil
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decode reverses encode for a value read from a file with this codec.
+func (c FileCodec) decode(value []byte) ([]byte, error) {
+	if c != FileCodecGzip {
+		return value, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(value))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress value: %w", err)
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,66 @@
+package persistence
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"memory-tools/internal/encryption"
+	"strings"
+	"sync/atomic"
+)
+
+// cipherMarker prefixes the header entry of encrypted collection files. The entry ends with the
+// ID of the key and the file's base nonce, both hex-encoded and separated by a colon.
+const cipherMarker = "#cipher:aes-256-gcm:"
+
+var keyring atomic.Pointer[encryption.Keyring]
+
+// SetEncryptionKeyring sets the keys of encryption at rest. Data files and backups are written
+// encrypted with the keyring's current key, if it has one; existing files encrypted with any key
+// of the ring stay readable.
+func SetEncryptionKeyring(kr *encryption.Keyring) {
+	keyring.Store(kr)
+}
+
+// parseCipherEntry returns the key and base nonce recorded in a cipher header entry.
+func parseCipherEntry(entry string) (*encryption.Key, []byte, error) {
+	keyID, nonceHex, ok := strings.Cut(strings.TrimPrefix(entry, cipherMarker), ":")
+	if !ok {
+		return nil, nil, fmt.Errorf("malformed encryption header entry '%s'", entry)
+	}
+	nonce, err := hex.DecodeString(nonceHex)
+	if err != nil || len(nonce) != encryption.NonceSize {
+		return nil, nil, fmt.Errorf("malformed nonce in encryption header entry '%s'", entry)
+	}
+	key, err := keyring.Load().KeyByString(keyID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, nonce, nil
+}
+
+// cipherEntry returns the header entry recording a file's key and base nonce.
+func cipherEntry(key *encryption.Key, nonce []byte) string {
+	return cipherMarker + key.IDString() + ":" + hex.EncodeToString(nonce)
+}
+
+// encryptingWriter wraps w so that what is written to it is encrypted with the current key.
+// Without a current key, w is returned as is. The returned function must be called once
+// everything has been written.
+func encryptingWriter(w io.Writer) (io.Writer, func() error, error) {
+	key := keyring.Load().Current()
+	if key == nil {
+		return w, func() error { return nil }, nil
+	}
+	ew, err := encryption.NewWriter(w, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ew, ew.Close, nil
+}
+
+// decryptingReader wraps r so that reads return its plaintext. Unencrypted data is read as is.
+func decryptingReader(r io.Reader) (io.Reader, error) {
+	plain, _, err := encryption.NewReader(r, keyring.Load())
+	return plain, err
+}
@@ -0,0 +1,211 @@
+package persistence
+
+import (
+	"bytes"
+	"errors"
+	"memory-tools/internal/encryption"
+	"memory-tools/internal/globalconst"
+	"memory-tools/internal/store"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testKey  = strings.Repeat("11", encryption.KeySize)
+	otherKey = strings.Repeat("22", encryption.KeySize)
+)
+
+// useKeyring sets the keyring of encryption at rest for the rest of the test.
+func useKeyring(t *testing.T, current string, previous ...string) *encryption.Keyring {
+	t.Helper()
+	kr, err := encryption.NewKeyring(current, previous)
+	if err != nil {
+		t.Fatalf("building keyring: %v", err)
+	}
+	SetEncryptionKeyring(kr)
+	t.Cleanup(func() { SetEncryptionKeyring(nil) })
+	return kr
+}
+
+var cipherEntryPattern = regexp.MustCompile(regexp.QuoteMeta(cipherMarker) + `[0-9a-f]+:[0-9a-f]+`)
+
+// collectionFile returns the cipher header entry of a collection file, which must be encrypted and
+// must not hold the plaintext secret.
+func collectionFile(t *testing.T, collectionName, secret string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(globalconst.CollectionsDirName, collectionName+globalconst.DBFileExtension))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(secret)) {
+		t.Fatalf("file of %s holds the plaintext %q", collectionName, secret)
+	}
+	entry := cipherEntryPattern.Find(data)
+	if entry == nil {
+		t.Fatalf("file of %s has no encryption header entry", collectionName)
+	}
+	return string(entry)
+}
+
+// saveSecrets writes a collection whose documents hold the word "s3cr3t".
+func saveSecrets(t *testing.T, collectionName string) {
+	t.Helper()
+	s := store.NewInMemStoreWithShards(4)
+	s.CreateIndex("owner")
+	s.Set("a", []byte(`{"_id":"a","owner":"ada","note":"s3cr3t a"}`), 0)
+	s.Set("b", []byte(`{"_id":"b","owner":"bob","note":"s3cr3t b"}`), 0)
+	if err := (&CollectionPersisterImpl{}).SaveCollectionData(collectionName, s); err != nil {
+		t.Fatalf("saving %s: %v", collectionName, err)
+	}
+}
+
+// loadSecrets loads a collection file into a new store.
+func loadSecrets(collectionName string) (store.DataStore, error) {
+	s := store.NewInMemStoreWithShards(4)
+	return s, LoadCollectionData(collectionName, s, time.Time{})
+}
+
+func TestEncryptedCollectionFileRoundTrip(t *testing.T) {
+	t.Chdir(t.TempDir())
+	kr := useKeyring(t, testKey)
+	saveSecrets(t, "secrets")
+
+	entry := collectionFile(t, "secrets", "s3cr3t")
+	if !strings.Contains(entry, kr.Current().IDString()) {
+		t.Fatalf("header entry %q does not name the current key", entry)
+	}
+	s, err := loadSecrets("secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := s.Get("b"); !strings.Contains(string(value), "s3cr3t b") {
+		t.Fatalf("b = %s", value)
+	}
+	if keys, used := s.Lookup("owner", "ada"); !used || len(keys) != 1 {
+		t.Fatalf("lookup of the restored index = %v (used %v)", keys, used)
+	}
+	if value, found, err := GetColdItem("secrets", "a"); err != nil || !found || !strings.Contains(string(value), "s3cr3t a") {
+		t.Fatalf("cold read of a = %s, found %v, err %v", value, found, err)
+	}
+}
+
+func TestEveryCollectionFileGetsAFreshNonce(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useKeyring(t, testKey)
+
+	saveSecrets(t, "first")
+	saveSecrets(t, "second")
+	first := collectionFile(t, "first", "s3cr3t")
+	second := collectionFile(t, "second", "s3cr3t")
+	saveSecrets(t, "first")
+	resaved := collectionFile(t, "first", "s3cr3t")
+
+	if first == second || first == resaved {
+		t.Fatalf("files share a base nonce: %q, %q, %q", first, second, resaved)
+	}
+}
+
+func TestCollectionFileWithUnknownKeyFailsToLoad(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useKeyring(t, testKey)
+	saveSecrets(t, "secrets")
+
+	useKeyring(t, otherKey)
+	if _, err := loadSecrets("secrets"); !errors.Is(err, encryption.ErrUnknownKey) {
+		t.Fatalf("loading with another key: err = %v, want ErrUnknownKey", err)
+	}
+	if _, _, err := GetColdItem("secrets", "a"); !errors.Is(err, encryption.ErrUnknownKey) {
+		t.Fatalf("cold read with another key: err = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestRewritesReencryptWithTheCurrentKey(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useKeyring(t, testKey)
+	saveSecrets(t, "secrets")
+	before := collectionFile(t, "secrets", "s3cr3t")
+
+	// After a key rotation, both rewrites write the file under the new key and a fresh nonce.
+	rotated := useKeyring(t, otherKey, testKey)
+	err := rewriteCollectionFile("secrets", func(key string, data []byte) ([]byte, error) {
+		if key == "b" {
+			return []byte(`{"_id":"b","owner":"bob","note":"s3cr3t b","` + globalconst.DELETED_FLAG + `":true}`), nil
+		}
+		return data, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewritten := collectionFile(t, "secrets", "s3cr3t")
+	if _, err := CompactCollectionFile("secrets"); err != nil {
+		t.Fatal(err)
+	}
+	compacted := collectionFile(t, "secrets", "s3cr3t")
+
+	for name, entry := range map[string]string{"rewritten": rewritten, "compacted": compacted} {
+		if !strings.Contains(entry, rotated.Current().IDString()) {
+			t.Errorf("%s file header %q does not name the new key", name, entry)
+		}
+	}
+	if rewritten == before || compacted == rewritten {
+		t.Fatalf("rewrites reused a base nonce: %q, %q, %q", before, rewritten, compacted)
+	}
+
+	useKeyring(t, otherKey)
+	s, err := loadSecrets("secrets")
+	if err != nil {
+		t.Fatalf("loading with only the new key: %v", err)
+	}
+	if _, found := s.Get("b"); found || s.Size() != 1 {
+		t.Fatalf("compacted file holds %d items, want only a", s.Size())
+	}
+}
+
+func TestEncryptedBackupRoundTrip(t *testing.T) {
+	t.Chdir(t.TempDir())
+	useKeyring(t, testKey)
+	mainStore := store.NewInMemStoreWithShards(4)
+	mainStore.Set("token", []byte("s3cr3t token"), 0)
+	cm := store.NewCollectionManager(discardPersister{}, 4)
+	cm.GetCollection("secrets").Set("a", []byte(`{"_id":"a","note":"s3cr3t a"}`), 0)
+
+	if err := os.Mkdir(globalconst.BackupsDirName, 0755); err != nil {
+		t.Fatal(err)
+	}
+	bm := NewBackupManager(mainStore, cm, time.Hour, time.Hour)
+	if err := bm.PerformBackup(); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := bm.ListBackups()
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("backups = %v, err %v", backups, err)
+	}
+	backupName := backups[0].Name
+	for _, file := range []string{"in-memory.mtdb", filepath.Join("collections", "secrets.mtdb")} {
+		data, err := os.ReadFile(filepath.Join(globalconst.BackupsDirName, backupName, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("s3cr3t")) || !bytes.HasPrefix(data, []byte("MTENC")) {
+			t.Fatalf("backup file %s is not encrypted", file)
+		}
+	}
+
+	restored := store.NewCollectionManager(discardPersister{}, 4)
+	colStore, err := RestoreCollection(backupName, "secrets", restored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := colStore.Get("a"); !strings.Contains(string(value), "s3cr3t a") {
+		t.Fatalf("restored a = %s", value)
+	}
+
+	useKeyring(t, otherKey)
+	if _, err := RestoreCollection(backupName, "secrets", store.NewCollectionManager(discardPersister{}, 4)); !errors.Is(err, encryption.ErrUnknownKey) {
+		t.Fatalf("restoring with another key: err = %v, want ErrUnknownKey", err)
+	}
+}
@@ -0,0 +1,267 @@
+package persistence
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"memory-tools/internal/encryption"
+	"strings"
+	"sync/atomic"
+)
+
+// Format entries share the index header of a collection file with the index entries, so files
+// written before a format feature existed keep their layout and still load. Index field names
+// never start with '#'.
+const (
+	// formatEntryPrefix starts every header entry that describes the file format rather than an index.
+	formatEntryPrefix = "#"
+	// checksumEntry marks collection files whose records end with a CRC32 checksum.
+	checksumEntry = "#checksum:crc32"
+)
+
+// ErrChecksumMismatch reports a record whose content does not match its checksum. The record
+// was read in full, so the rest of the file can still be read.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// RecoveryMode decides what happens when a data file turns out to be corrupted.
+type RecoveryMode string
+
+const (
+	// RecoveryBestEffort logs and skips corrupted records and keeps the rest of the file.
+	RecoveryBestEffort RecoveryMode = "best_effort"
+	// RecoveryStrict fails on the first corrupted record.
+	RecoveryStrict RecoveryMode = "strict"
+)
+
+var strictRecovery atomic.Bool
+
+// SetRecoveryMode sets how corrupted records found in data files are handled.
+func SetRecoveryMode(mode RecoveryMode) {
+	strictRecovery.Store(mode == RecoveryStrict)
+}
+
+// skipCorruptRecord reports whether a record that could not be read may be skipped: only records
+// that failed their checksum can, and only in best-effort mode. Skipped records are logged.
+func skipCorruptRecord(path string, err error) bool {
+	if !errors.Is(err, ErrChecksumMismatch) || strictRecovery.Load() {
+		return false
+	}
+	slog.Error("Skipping corrupted record", "path", path, "error", err)
+	return true
+}
+
+// collectionHeader describes a collection file as recorded in its index header.
+type collectionHeader struct {
+	codec        FileCodec
+	checksums    bool
+	cipher       *encryption.Key // Nil for unencrypted files.
+	nonce        []byte          // Base nonce of the records of an encrypted file.
+	indexEntries []string        // Index entries, without the format entries.
+}
+
+// newCollectionHeader returns the header of a new file whose values are compressed with codec.
+// Files are always written with checksums, and encrypted when an encryption key is configured.
+// Every new file gets a fresh base nonce.
+func newCollectionHeader(codec FileCodec) (collectionHeader, error) {
+	header := collectionHeader{codec: codec, checksums: true, cipher: keyring.Load().Current()}
+	if header.cipher != nil {
+		nonce, err := encryption.NewNonce()
+		if err != nil {
+			return collectionHeader{}, err
+		}
+		header.nonce = nonce
+	}
+	return header, nil
+}
+
+// formatEntries returns the header entries that describe the file format.
+func (h collectionHeader) formatEntries() []string {
+	var entries []string
+	if h.checksums {
+		entries = append(entries, checksumEntry)
+	}
+	if entry := h.codec.headerEntry(); entry != "" {
+		entries = append(entries, entry)
+	}
+	if h.cipher != nil {
+		entries = append(entries, cipherEntry(h.cipher, h.nonce))
+	}
+	return entries
+}
+
+// encodeValue returns the bytes stored for the value of the index-th record of the file:
+// the value compressed, then encrypted. The key is authenticated with the value.
+func (h collectionHeader) encodeValue(index uint64, key, value []byte) ([]byte, error) {
+	encoded, err := h.codec.encode(value)
+	if err != nil {
+		return nil, err
+	}
+	if h.cipher != nil {
+		encoded = h.cipher.SealAt(h.nonce, index, encoded, key)
+	}
+	return encoded, nil
+}
+
+// decodeValue reverses encodeValue.
+func (h collectionHeader) decodeValue(index uint64, key, stored []byte) ([]byte, error) {
+	if h.cipher != nil {
+		var err error
+		if stored, err = h.cipher.OpenAt(h.nonce, index, stored, key); err != nil {
+			return nil, err
+		}
+	}
+	return h.codec.decode(stored)
+}
+
+// isPlain reports whether values are stored as they are.
+func (h collectionHeader) isPlain() bool {
+	return h.cipher == nil && h.codec == FileCodecNone
+}
+
+// sameEncoding reports whether stored values can be copied as they are between files with
+// headers h and other. Encrypted values never can, since every file has its own nonce.
+func (h collectionHeader) sameEncoding(other collectionHeader) bool {
+	return h.cipher == nil && other.cipher == nil && h.codec == other.codec
+}
+
+// parseCollectionHeader separates the format entries of a file header from its index entries.
+func parseCollectionHeader(entries []string) (collectionHeader, error) {
+	header := collectionHeader{codec: FileCodecNone, indexEntries: make([]string, 0, len(entries))}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry, formatEntryPrefix) {
+			header.indexEntries = append(header.indexEntries, entry)
+			continue
+		}
+		if entry == checksumEntry {
+			header.checksums = true
+			continue
+		}
+		if strings.HasPrefix(entry, cipherMarker) {
+			key, nonce, err := parseCipherEntry(entry)
+			if err != nil {
+				return collectionHeader{}, err
+			}
+			header.cipher, header.nonce = key, nonce
+			continue
+		}
+		name, ok := strings.CutPrefix(entry, fileCodecMarker)
+		if !ok {
+			return collectionHeader{}, fmt.Errorf("unsupported file format entry '%s'", entry)
+		}
+		codec, err := ParseFileCodec(name)
+		if err != nil {
+			return collectionHeader{}, err
+		}
+		header.codec = codec
+	}
+	return header, nil
+}
+
+// readCollectionHeader reads the numIndexes entries of a collection file's index header.
+func readCollectionHeader(r io.Reader, numIndexes uint32) (collectionHeader, error) {
+	entries := make([]string, numIndexes)
+	for i := range entries {
+		entry, err := readPrefixedBytes(r)
+		if err != nil {
+			return collectionHeader{}, fmt.Errorf("could not read index field name: %w", err)
+		}
+		entries[i] = string(entry)
+	}
+	return parseCollectionHeader(entries)
+}
+
+// trailerSize returns the number of bytes that follow the value of each record.
+func (h collectionHeader) trailerSize() int64 {
+	if h.checksums {
+		return 4
+	}
+	return 0
+}
+
+// recordChecksum returns the CRC32 of a record's key and stored value.
+func recordChecksum(key, value []byte) uint32 {
+	return crc32.Update(crc32.ChecksumIEEE(key), crc32.IEEETable, value)
+}
+
+// writeRecord writes a length-prefixed key and value followed by their checksum.
+func writeRecord(w io.Writer, key, value []byte) error {
+	if err := writePrefixedBytes(w, key); err != nil {
+		return err
+	}
+	if err := writePrefixedBytes(w, value); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.LittleEndian, recordChecksum(key, value))
+}
+
+// verifyChecksum reads the checksum that follows a record and checks it against the record.
+func verifyChecksum(r io.Reader, key, value []byte) error {
+	var checksum uint32
+	if err := binary.Read(r, binary.LittleEndian, &checksum); err != nil {
+		return fmt.Errorf("could not read checksum for key '%s': %w", key, noEOF(err))
+	}
+	if checksum != recordChecksum(key, value) {
+		return fmt.Errorf("%w for key '%s'", ErrChecksumMismatch, key)
+	}
+	return nil
+}
+
+// recordReader reads the records of a data file, verifying their checksums when the file has them.
+type recordReader struct {
+	r         io.Reader
+	checksums bool
+	remaining int64 // Upper bound of the bytes left, to reject corrupted lengths before allocating.
+}
+
+// newRecordReader reads records from r, which holds at most size more bytes.
+func newRecordReader(r io.Reader, checksums bool, size int64) *recordReader {
+	return &recordReader{r: r, checksums: checksums, remaining: size}
+}
+
+// next reads the next record. It returns io.EOF at the end of the file and an error wrapping
+// ErrChecksumMismatch when the record read does not match its checksum; any other error means
+// the rest of the file cannot be read.
+func (rr *recordReader) next() (key, value []byte, err error) {
+	if key, err = rr.readBytes(); err != nil {
+		return nil, nil, err
+	}
+	if value, err = rr.readBytes(); err != nil {
+		return nil, nil, fmt.Errorf("could not read value for key '%s': %w", key, noEOF(err))
+	}
+	if !rr.checksums {
+		return key, value, nil
+	}
+	rr.remaining -= 4
+	if err := verifyChecksum(rr.r, key, value); err != nil {
+		return nil, nil, err
+	}
+	return key, value, nil
+}
+
+func (rr *recordReader) readBytes() ([]byte, error) {
+	var length uint32
+	if err := binary.Read(rr.r, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	rr.remaining -= 4
+	if int64(length) > rr.remaining {
+		return nil, fmt.Errorf("record length %d exceeds the file size, the file is corrupted", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(rr.r, data); err != nil {
+		return nil, noEOF(err)
+	}
+	rr.remaining -= int64(length)
+	return data, nil
+}
+
+// noEOF turns an end of file inside a record into an unexpected one.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -6,72 +6,285 @@ import (
 	"io"
 	"log/slog"
 	"memory-tools/internal/globalconst"
+	"memory-tools/internal/protocol"
 	"memory-tools/internal/store"
+	"memory-tools/internal/wal"
 	"os"
 	"path/filepath"
 )
 
-// PerformRestore performs a full restore from a specific backup directory.
+// PerformRestore performs a full restore from a specific backup directory. An incremental backup
+// is restored by loading the full backup its chain starts from and replaying, through replay, the
+// WAL of every incremental backup up to it. The whole chain is checked before any data is touched.
 // WARNING: This is a destructive operation that replaces all in-memory data.
-func PerformRestore(backupName string, mainStore store.DataStore, colManager *store.CollectionManager) error {
-	backupPath := filepath.Join(globalconst.BackupsDirName, backupName)
-	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
-		return fmt.Errorf("backup directory '%s' not found", backupName)
+func PerformRestore(backupName string, mainStore store.DataStore, colManager *store.CollectionManager, replay func(wal.WalEntry)) error {
+	root, cleanup, err := stageBackupChain(backupName)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	chain, err := backupChain(root, backupName)
+	if err != nil {
+		return err
 	}
+	if len(chain) > 1 && replay == nil {
+		return fmt.Errorf("backup '%s' is incremental and cannot be restored here", backupName)
+	}
+	basePath := filepath.Join(root, chain[0].name)
 
-	slog.Warn("--- STARTING RESTORE ---", "backup_name", backupName)
+	slog.Warn("--- STARTING RESTORE ---", "backup_name", backupName, "base_backup", chain[0].name, "incremental_backups", len(chain)-1)
 
-	if err := restoreMainStore(backupPath, mainStore); err != nil {
+	if err := restoreMainStore(basePath, mainStore); err != nil {
 		return fmt.Errorf("failed to restore main store: %w", err)
 	}
 
-	if err := restoreCollections(backupPath, colManager); err != nil {
+	if err := restoreCollections(basePath, colManager); err != nil {
 		return fmt.Errorf("failed to restore collections: %w", err)
 	}
 
+	for _, link := range chain[1:] {
+		paths := make([]string, len(link.manifest.WalFiles))
+		for i, file := range link.manifest.WalFiles {
+			paths[i] = filepath.Join(root, link.name, backupWalDir, file.Name)
+		}
+		applied, err := wal.ReplayFiles(paths, func(entry wal.WalEntry) {
+			// A restore logged while the chain was recorded started over from another backup;
+			// the chain ends there and is cut by the server, so it is never followed.
+			if entry.CommandType == protocol.CmdRestore {
+				slog.Warn("Skipping restore command found in incremental backup", "backup_name", link.name)
+				return
+			}
+			replay(entry)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply incremental backup '%s': %w", link.name, err)
+		}
+		slog.Info("Incremental backup applied.", "backup_name", link.name, "entries", applied)
+	}
+
 	slog.Info("--- RESTORE COMPLETED SUCCESSFULLY ---", "backup_name", backupName)
 	return nil
 }
 
+// RestorePlan describes what restoring a backup would do, as found by ValidateRestore.
+type RestorePlan struct {
+	Backup string `json:"backup"`
+	// Chain lists the backups a restore applies, the full backup first.
+	Chain          []string                `json:"chain"`
+	MainStoreItems int                     `json:"main_store_items"`
+	Collections    []CollectionRestorePlan `json:"collections"`
+	// WalEntries counts the writes of the incremental backups of the chain, replayed on top of
+	// the full backup's data; the item counts are those of the full backup.
+	WalEntries   int `json:"wal_entries"`
+	FilesChecked int `json:"files_checked"`
+}
+
+// CollectionRestorePlan describes a collection of the full backup a restore starts from.
+type CollectionRestorePlan struct {
+	Name    string   `json:"name"`
+	Items   int      `json:"items"`
+	Indexes []string `json:"indexes,omitempty"`
+}
+
+// ValidateRestore checks a backup as PerformRestore would, without changing any live data: the
+// chain is checked as for a restore, every file of the full backup is decoded in full and every
+// entry of the incremental backups is read and checksummed. It returns what a restore would load.
+func ValidateRestore(backupName string) (*RestorePlan, error) {
+	root, cleanup, err := stageBackupChain(backupName)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	chain, err := backupChain(root, backupName)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &RestorePlan{Backup: backupName, Collections: []CollectionRestorePlan{}}
+	for _, link := range chain {
+		plan.Chain = append(plan.Chain, link.name)
+	}
+	basePath := filepath.Join(root, chain[0].name)
+
+	mainData, err := readMainStoreBackup(filepath.Join(basePath, "in-memory.mtdb"))
+	if err != nil {
+		return nil, fmt.Errorf("backup '%s': %w", chain[0].name, err)
+	}
+	plan.MainStoreItems = len(mainData)
+	plan.FilesChecked++
+
+	files, err := filepath.Glob(filepath.Join(basePath, "collections", "*"+globalconst.DBFileExtension))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list collection backup files: %w", err)
+	}
+	for _, filePath := range files {
+		indexedFields, data, err := readCollectionBackup(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("backup '%s': %w", chain[0].name, err)
+		}
+		baseName := filepath.Base(filePath)
+		plan.Collections = append(plan.Collections, CollectionRestorePlan{
+			Name:    baseName[:len(baseName)-len(globalconst.DBFileExtension)],
+			Items:   len(data),
+			Indexes: indexedFields,
+		})
+		plan.FilesChecked++
+	}
+
+	for _, link := range chain[1:] {
+		paths := make([]string, len(link.manifest.WalFiles))
+		for i, file := range link.manifest.WalFiles {
+			paths[i] = filepath.Join(root, link.name, backupWalDir, file.Name)
+		}
+		entries, err := wal.ReplayFiles(paths, func(wal.WalEntry) {})
+		if err != nil {
+			return nil, fmt.Errorf("incremental backup '%s': %w", link.name, err)
+		}
+		plan.WalEntries += entries
+		plan.FilesChecked += len(paths)
+	}
+	return plan, nil
+}
+
+// chainLink is one backup of the chain that restores a backup.
+type chainLink struct {
+	name     string
+	manifest backupManifest
+}
+
+// backupChain returns the backups under root to apply to restore backupName, starting with the
+// full backup and ending with backupName itself. Every backup of the chain must exist and be complete.
+func backupChain(root, backupName string) ([]chainLink, error) {
+	var chain []chainLink
+	for name := backupName; ; {
+		if name == "" || filepath.Base(name) != name {
+			return nil, fmt.Errorf("invalid backup name '%s'", name)
+		}
+		backupPath := filepath.Join(root, name)
+		if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+			if name == backupName {
+				return nil, fmt.Errorf("backup directory '%s' not found", backupName)
+			}
+			return nil, fmt.Errorf("backup chain of '%s' is incomplete: backup '%s' is missing", backupName, name)
+		}
+		manifest, err := readBackupManifest(backupPath)
+		if err != nil {
+			return nil, fmt.Errorf("backup '%s': %w", name, err)
+		}
+		if _, _, err := checkBackupFiles(backupPath); err != nil {
+			return nil, fmt.Errorf("backup chain of '%s' is damaged: backup '%s': %w", backupName, name, err)
+		}
+		chain = append([]chainLink{{name: name, manifest: manifest}}, chain...)
+		if manifest.Type == BackupTypeFull {
+			return chain, nil
+		}
+		// Positions count down to the full backup, which also rules out a loop of parents.
+		if manifest.Chain < 1 || (len(chain) > 1 && chain[1].manifest.Chain != manifest.Chain+1) {
+			return nil, fmt.Errorf("backup chain of '%s' is inconsistent at backup '%s'", backupName, name)
+		}
+		name = manifest.Parent
+	}
+}
+
+// RestoreCollection replaces a single collection with its copy in a backup, leaving the main store
+// and every other collection untouched. The collection is created if it no longer exists. Items
+// held only on disk are discarded along with the rest of the collection's current data, since
+// the collection's file is rewritten from the restored store on its next save.
+func RestoreCollection(backupName, collectionName string, colManager *store.CollectionManager) (store.DataStore, error) {
+	if filepath.Base(backupName) != backupName || filepath.Base(collectionName) != collectionName {
+		return nil, fmt.Errorf("invalid backup or collection name")
+	}
+	root, cleanup, err := stageBackupChain(backupName)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	backupPath := filepath.Join(root, backupName)
+	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("backup directory '%s' not found", backupName)
+	}
+	if manifest, err := readBackupManifest(backupPath); err == nil && manifest.Type == BackupTypeIncremental {
+		return nil, fmt.Errorf("backup '%s' is incremental; a single collection can only be restored from a full backup", backupName)
+	}
+	filePath := filepath.Join(backupPath, "collections", collectionName+globalconst.DBFileExtension)
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("collection '%s' not found in backup '%s'", collectionName, backupName)
+	}
+
+	slog.Warn("--- STARTING COLLECTION RESTORE ---", "backup_name", backupName, "collection", collectionName)
+
+	created := !colManager.CollectionExists(collectionName)
+	colManager.GetCollection(collectionName)
+	colStore, err := colManager.ReloadCollection(collectionName, func(col store.DataStore) error {
+		return loadCollectionDataFromBackup(filePath, col)
+	})
+	if err != nil {
+		if created {
+			colManager.DeleteCollection(collectionName)
+		}
+		return nil, fmt.Errorf("failed to restore collection '%s': %w", collectionName, err)
+	}
+
+	slog.Info("--- COLLECTION RESTORE COMPLETED SUCCESSFULLY ---", "backup_name", backupName, "collection", collectionName, "items", colStore.Size())
+	return colStore, nil
+}
+
 // restoreMainStore loads the main store's data from its backup file.
 func restoreMainStore(backupPath string, s store.DataStore) error {
 	filePath := filepath.Join(backupPath, "in-memory.mtdb")
 	slog.Info("Restoring main store...", "path", filePath)
 
-	file, err := os.Open(filePath)
+	loadedData, err := readMainStoreBackup(filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			slog.Warn("Main store backup file not found, skipping.", "path", filePath)
 			s.LoadData(make(map[string][]byte))
 			return nil
 		}
-		return fmt.Errorf("failed to open main backup file '%s': %w", filePath, err)
+		return err
+	}
+
+	s.LoadData(loadedData)
+	slog.Info("Main store restored.", "key_count", len(loadedData))
+	return nil
+}
+
+// readMainStoreBackup decodes the main store's backup file. A missing file is returned as an
+// error satisfying os.IsNotExist.
+func readMainStoreBackup(filePath string) (map[string][]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to open main backup file '%s': %w", filePath, err)
 	}
 	defer file.Close()
 
+	r, err := decryptingReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt main backup file '%s': %w", filePath, err)
+	}
 	var numEntries uint32
-	if err := binary.Read(file, binary.LittleEndian, &numEntries); err != nil {
-		return fmt.Errorf("failed to read number of entries from main backup: %w", err)
+	if err := binary.Read(r, binary.LittleEndian, &numEntries); err != nil {
+		return nil, fmt.Errorf("failed to read number of entries from main backup: %w", err)
 	}
 
 	loadedData := make(map[string][]byte, numEntries)
 	for i := 0; i < int(numEntries); i++ {
-		keyBytes, err := readLengthPrefixed(file)
+		keyBytes, err := readLengthPrefixed(r)
 		if err != nil {
-			return fmt.Errorf("failed to read key for entry %d in main backup: %w", i, err)
+			return nil, fmt.Errorf("failed to read key for entry %d in main backup: %w", i, err)
 		}
 		key := string(keyBytes)
 
-		valBytes, err := readLengthPrefixed(file)
+		valBytes, err := readLengthPrefixed(r)
 		if err != nil {
-			return fmt.Errorf("failed to read value for key '%s' in main backup: %w", key, err)
+			return nil, fmt.Errorf("failed to read value for key '%s' in main backup: %w", key, err)
 		}
 		loadedData[key] = valBytes
 	}
-
-	s.LoadData(loadedData)
-	slog.Info("Main store restored.", "key_count", len(loadedData))
-	return nil
+	return loadedData, nil
 }
 
 // restoreCollections loads all collections from the backup directory.
@@ -108,58 +321,69 @@ func restoreCollections(backupPath string, cm *store.CollectionManager) error {
 
 // loadCollectionDataFromBackup loads a single collection and rebuilds its indexes.
 func loadCollectionDataFromBackup(filePath string, s store.DataStore) error {
+	indexedFields, collectionData, err := readCollectionBackup(filePath)
+	if err != nil {
+		return err
+	}
+
+	s.LoadData(collectionData)
+	slog.Info("Collection data loaded.", "key_count", len(collectionData))
+
+	if len(indexedFields) > 0 {
+		slog.Info("Rebuilding indexes...", "index_count", len(indexedFields))
+		rebuildPersistedIndexes(s, indexedFields)
+		slog.Info("Finished rebuilding indexes.")
+	}
+
+	return nil
+}
+
+// readCollectionBackup decodes a collection's backup file into its index entries and items.
+func readCollectionBackup(filePath string) ([]string, map[string][]byte, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to open collection backup file '%s': %w", filePath, err)
+		return nil, nil, fmt.Errorf("failed to open collection backup file '%s': %w", filePath, err)
 	}
 	defer file.Close()
 
+	r, err := decryptingReader(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decrypt collection backup file '%s': %w", filePath, err)
+	}
 	var numIndexes uint32
-	if err := binary.Read(file, binary.LittleEndian, &numIndexes); err != nil {
-		return fmt.Errorf("failed to read index count from '%s': %w", filePath, err)
+	if err := binary.Read(r, binary.LittleEndian, &numIndexes); err != nil {
+		return nil, nil, fmt.Errorf("failed to read index count from '%s': %w", filePath, err)
 	}
 
 	indexedFields := make([]string, numIndexes)
 	for i := 0; i < int(numIndexes); i++ {
-		fieldBytes, err := readLengthPrefixed(file)
+		fieldBytes, err := readLengthPrefixed(r)
 		if err != nil {
-			return fmt.Errorf("failed to read index field name from '%s': %w", filePath, err)
+			return nil, nil, fmt.Errorf("failed to read index field name from '%s': %w", filePath, err)
 		}
 		indexedFields[i] = string(fieldBytes)
 	}
 
 	var numEntries uint32
-	if err := binary.Read(file, binary.LittleEndian, &numEntries); err != nil {
-		return fmt.Errorf("failed to read entry count from '%s': %w", filePath, err)
+	if err := binary.Read(r, binary.LittleEndian, &numEntries); err != nil {
+		return nil, nil, fmt.Errorf("failed to read entry count from '%s': %w", filePath, err)
 	}
 
 	collectionData := make(map[string][]byte, numEntries)
 	for i := 0; i < int(numEntries); i++ {
-		keyBytes, err := readLengthPrefixed(file)
+		keyBytes, err := readLengthPrefixed(r)
 		if err != nil {
-			return fmt.Errorf("failed to read key for entry %d in '%s': %w", i, filePath, err)
+			return nil, nil, fmt.Errorf("failed to read key for entry %d in '%s': %w", i, filePath, err)
 		}
 		key := string(keyBytes)
 
-		valBytes, err := readLengthPrefixed(file)
+		valBytes, err := readLengthPrefixed(r)
 		if err != nil {
-			return fmt.Errorf("failed to read value for key '%s' in '%s': %w", key, filePath, err)
+			return nil, nil, fmt.Errorf("failed to read value for key '%s' in '%s': %w", key, filePath, err)
 		}
 		collectionData[key] = valBytes
 	}
-
-	s.LoadData(collectionData)
-	slog.Info("Collection data loaded.", "key_count", len(collectionData))
-
-	if len(indexedFields) > 0 {
-		slog.Info("Rebuilding indexes...", "index_count", len(indexedFields))
-		for _, field := range indexedFields {
-			s.CreateIndex(field)
-		}
-		slog.Info("Finished rebuilding indexes.")
-	}
-
-	return nil
+	return indexedFields, collectionData, nil
 }
 
 // readLengthPrefixed is a helper function to read length-prefixed data.
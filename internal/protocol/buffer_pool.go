@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultReadBufferSize is the initial capacity of pooled read buffers.
+// It covers the typical small command (a few keys and a short JSON document).
+const DefaultReadBufferSize = 4096
+
+// maxPooledBufferFactor bounds how much a pooled buffer may grow before it is
+// dropped instead of being returned, so one huge payload does not pin memory.
+const maxPooledBufferFactor = 16
+
+var readBufferSize atomic.Int64
+
+func init() {
+	readBufferSize.Store(DefaultReadBufferSize)
+}
+
+// payloadPool holds buffers used to capture full command payloads (e.g. for the WAL).
+var payloadPool = sync.Pool{
+	New: func() any {
+		return bytes.NewBuffer(make([]byte, 0, readBufferSize.Load()))
+	},
+}
+
+// scratchPool holds byte slices used while decoding length-prefixed strings.
+var scratchPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, readBufferSize.Load())
+		return &b
+	},
+}
+
+// SetReadBufferSize sets the initial capacity of newly pooled read buffers.
+// Non-positive sizes are ignored.
+func SetReadBufferSize(size int) {
+	if size > 0 {
+		readBufferSize.Store(int64(size))
+	}
+}
+
+// AcquirePayloadBuffer returns an empty buffer from the pool.
+// The caller owns it until ReleasePayloadBuffer is called and must not keep
+// any slice of its contents after releasing it.
+func AcquirePayloadBuffer() *bytes.Buffer {
+	return payloadPool.Get().(*bytes.Buffer)
+}
+
+// ReleasePayloadBuffer returns a buffer to the pool once the command using it has been fully handled.
+func ReleasePayloadBuffer(buf *bytes.Buffer) {
+	if buf == nil || int64(buf.Cap()) > readBufferSize.Load()*maxPooledBufferFactor {
+		return
+	}
+	buf.Reset()
+	payloadPool.Put(buf)
+}
+
+// getScratch returns a pooled slice with length n.
+func getScratch(n int) *[]byte {
+	bp := scratchPool.Get().(*[]byte)
+	if cap(*bp) < n {
+		*bp = make([]byte, n)
+	}
+	*bp = (*bp)[:n]
+	return bp
+}
+
+// putScratch returns a slice obtained from getScratch to the pool.
+func putScratch(bp *[]byte) {
+	if int64(cap(*bp)) > readBufferSize.Load()*maxPooledBufferFactor {
+		return
+	}
+	scratchPool.Put(bp)
+}
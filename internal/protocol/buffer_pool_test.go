@@ -0,0 +1,47 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// BenchmarkReadSmallCommands reads a stream of small SET commands as the connection loop does
+// before logging them to the WAL, with a new buffer per command and with pooled buffers.
+func BenchmarkReadSmallCommands(b *testing.B) {
+	const commands = 1000
+	var stream bytes.Buffer
+	for i := range commands {
+		WriteCollectionItemSetCommand(&stream, "items", fmt.Sprintf("key-%d", i), []byte(`{"name":"small","n":1}`), 0)
+	}
+	data := stream.Bytes()
+
+	readAll := func(b *testing.B, read func(*bytes.Reader, CommandType) error) {
+		b.ReportAllocs()
+		for b.Loop() {
+			r := bytes.NewReader(data)
+			for range commands {
+				cmdType, err := ReadCommandType(r)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if err := read(r, cmdType); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+	b.Run("unpooled", func(b *testing.B) {
+		readAll(b, func(r *bytes.Reader, cmdType CommandType) error {
+			_, err := ReadCommandPayload(r, cmdType)
+			return err
+		})
+	})
+	b.Run("pooled", func(b *testing.B) {
+		readAll(b, func(r *bytes.Reader, cmdType CommandType) error {
+			buf := AcquirePayloadBuffer()
+			defer ReleasePayloadBuffer(buf)
+			return ReadCommandPayloadInto(r, cmdType, buf)
+		})
+	})
+}
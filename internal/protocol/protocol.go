@@ -3,8 +3,10 @@ package protocol
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"time"
 )
 
@@ -19,7 +21,7 @@ const (
 	// Collection Management Commands
 	CmdCollectionCreate      // CREATE_COLLECTION collectionName
 	CmdCollectionDelete      // DELETE_COLLECTION collectionName
-	CmdCollectionList        // LIST_COLLECTIONS
+	CmdCollectionList        // LIST_COLLECTIONS prefix, limit, offset
 	CmdCollectionIndexCreate // CREATE_COLLECTION_INDEX collectionName, fieldName
 	CmdCollectionIndexDelete // DELETE_COLLECTION_INDEX collectionName, fieldName
 	CmdCollectionIndexList   // LIST_COLLECTION_INDEXES collectionName
@@ -27,12 +29,12 @@ const (
 	// Collection Item Commands
 	CmdCollectionItemSet        // SET_COLLECTION_ITEM collectionName, key, value, ttl
 	CmdCollectionItemSetMany    // SET_COLLECTION_ITEMS_MANY collectionName, json_array
-	CmdCollectionItemGet        // GET_COLLECTION_ITEM collectionName, key
+	CmdCollectionItemGet        // GET_COLLECTION_ITEM collectionName, key, fields
 	CmdCollectionItemDelete     // DELETE_COLLECTION_ITEM collectionName, key
 	CmdCollectionItemList       // LIST_COLLECTION_ITEMS collectionName
 	CmdCollectionQuery          // QUERY_COLLECTION collectionName, query_json
 	CmdCollectionItemDeleteMany // DELETE_COLLECTION_ITEMS_MANY collectionName, keys_array
-	CmdCollectionItemUpdate     // UPDATE_COLLECTION_ITEM collectionName, key, patch_value
+	CmdCollectionItemUpdate     // UPDATE_COLLECTION_ITEM collectionName, key, patch_value, if_match
 	CmdCollectionItemUpdateMany // UPDATE_COLLECTION_ITEMS_MANY collectionName, json_array
 
 	// Authentication Commands
@@ -52,6 +54,143 @@ const (
 	CmdBegin
 	CmdCommit
 	CmdRollback
+
+	// Collection Item Inspection Commands
+	CmdCollectionItemDiff // DIFF_COLLECTION_ITEMS collectionName, keyA, keyB, document_json
+
+	// Collection Item Write Commands (extended)
+	CmdCollectionItemUpsert // UPSERT_COLLECTION_ITEM collectionName, key, patch_value
+
+	// Index Maintenance Commands
+	CmdCollectionIndexDisable // DISABLE_COLLECTION_INDEX collectionName, fieldName
+	CmdCollectionIndexEnable  // ENABLE_COLLECTION_INDEX collectionName, fieldName
+
+	// Collection Inspection Commands
+	CmdCollectionTopLargest // TOP_LARGEST_COLLECTION_ITEMS collectionName, count
+
+	// Conditional Write Commands
+	CmdCollectionItemUpdateIf // UPDATE_COLLECTION_ITEM_IF collectionName, key, condition_filter, patch_value
+
+	// Collection Settings Commands
+	CmdCollectionSetCompression // SET_COLLECTION_COMPRESSION collectionName, enabled ("true" or "false")
+
+	// Collection Item Write Commands (replace)
+	CmdCollectionItemReplace // REPLACE_COLLECTION_ITEM collectionName, key, value, if_match
+
+	// Transaction Inspection Commands
+	CmdTransactionStatus // TRANSACTION_STATUS
+
+	// Collection Maintenance Commands
+	CmdCollectionReload // RELOAD_COLLECTION collectionName
+
+	// Work Queue Commands
+	CmdCollectionItemGetAndDelete // GET_AND_DELETE_COLLECTION_ITEM collectionName, key
+
+	// Background Maintenance Commands
+	CmdCompactAll    // COMPACT_ALL
+	CmdCompactStatus // COMPACT_STATUS jobID
+
+	// Collection Metrics Commands
+	CmdCollectionStats // COLLECTION_STATS collectionName
+
+	// Bulk Write Commands
+	CmdCollectionItemMergeByQuery // MERGE_COLLECTION_ITEMS_BY_QUERY collectionName, filter_json, patch_json
+
+	// Connection Commands
+	CmdPing // PING
+
+	// User Migration Commands
+	CmdExportUsers // EXPORT_USERS
+	CmdImportUsers // IMPORT_USERS overwrite ("true" or "false"), users_json
+
+	// Item Existence Commands
+	CmdCollectionItemExists // COLLECTION_ITEM_EXISTS collectionName, key
+
+	// Index Maintenance Commands
+	CmdCollectionIndexAudit // AUDIT_COLLECTION_INDEXES collectionName
+
+	// Atomic Counter Commands
+	CmdCollectionItemIncrement // INCREMENT_COLLECTION_ITEM collectionName, key, field, delta
+
+	// Index Option Commands
+	CmdCollectionIndexCreateWithOptions // CREATE_COLLECTION_INDEX_WITH_OPTIONS collectionName, fieldName, options_json
+
+	// Memory Commands
+	CmdMemoryStats // MEMORY_STATS
+
+	// Monitoring Commands
+	CmdStats // STATS
+
+	// File Compression Commands
+	CmdCollectionSetFileCompression // SET_COLLECTION_FILE_COMPRESSION collectionName, codec ("none" or "gzip")
+
+	// Partial Restore Commands
+	CmdRestoreCollection // RESTORE_COLLECTION backup_name, collectionName
+
+	// Export Commands
+	CmdCollectionExport // EXPORT_COLLECTION collectionName, format ("json" or "csv"), fields
+
+	// Import Commands
+	CmdCollectionImport // IMPORT_COLLECTION collectionName, batchSize, then a stream of documents
+
+	// Time To Live Commands
+	CmdCollectionItemTTL   // COLLECTION_ITEM_TTL collectionName, key
+	CmdCollectionItemTouch // TOUCH_COLLECTION_ITEM collectionName, key, ttl
+
+	// Key Iteration Commands
+	CmdCollectionKeyScan // SCAN_COLLECTION_KEYS collectionName, prefix, after, limit, includeCold
+
+	// Batch Read Commands
+	CmdCollectionItemGetMany // GET_COLLECTION_ITEMS_MANY collectionName, keys_array
+
+	// API Key Commands
+	CmdAuthenticateToken // AUTH_TOKEN api_key
+	CmdAPIKeyCreate      // API_KEY_CREATE username, expires_at, permissions_json
+	CmdAPIKeyPut         // API_KEY_PUT key_id, key_json
+	CmdAPIKeyRevoke      // API_KEY_REVOKE key_id
+	CmdAPIKeyList        // API_KEY_LIST
+
+	// Role Commands
+	CmdRoleCreate   // ROLE_CREATE roleName, permissions_json
+	CmdRoleUpdate   // ROLE_UPDATE roleName, permissions_json
+	CmdRoleDelete   // ROLE_DELETE roleName
+	CmdUserSetRoles // USER_SET_ROLES username, roles_array
+
+	// Backup Management Commands
+	CmdBackupList      // BACKUP_LIST
+	CmdBackupDelete    // BACKUP_DELETE backup_name
+	CmdRestoreValidate // RESTORE_VALIDATE backup_name
+
+	// Collection Rename Commands
+	CmdCollectionRename // RENAME_COLLECTION collectionName, newName
+
+	// Collection Copy Commands
+	CmdCollectionCopy // COPY_COLLECTION collectionName, newName, force ("true" or "false")
+
+	// Collection Truncate Commands
+	CmdCollectionTruncate // TRUNCATE_COLLECTION collectionName
+
+	// Schema Validation Commands
+	CmdCollectionSetSchema // SET_COLLECTION_SCHEMA collectionName, schema_json (empty to remove)
+
+	// Default TTL Commands
+	CmdCollectionSetDefaultTTL // SET_COLLECTION_DEFAULT_TTL collectionName, ttl (0 to remove)
+
+	// Slow Query Log Commands
+	CmdSlowQueryList // SLOW_QUERY_LIST limit (0 for all)
+
+	// Change Notification Commands
+	CmdSubscribe // SUBSCRIBE collectionName, keyPrefix, withValues ("true" or "false")
+
+	// Change Data Capture Commands
+	CmdChangeFeed    // CHANGE_FEED consumerName, collectionName ("" for all), fromOffset ("", "earliest", "latest" or an offset)
+	CmdChangeFeedAck // CHANGE_FEED_ACK consumerName, offset
+
+	// Stored Procedure Commands
+	CmdProcedurePut    // PROCEDURE_PUT name, definition_json
+	CmdProcedureDelete // PROCEDURE_DELETE name
+	CmdProcedureList   // PROCEDURE_LIST
+	CmdCallProcedure   // CALL_PROCEDURE name, params_json
 )
 
 // ResponseStatus defines the status of a server response.
@@ -64,6 +203,9 @@ const (
 	StatusBadCommand                  // Bad command format.
 	StatusUnauthorized                // Unauthorized access.
 	StatusBadRequest                  // Bad request (e.g., empty key/name).
+	StatusPartial                     // One chunk of a streamed response; more responses follow.
+	StatusConflict                    // The stored version of the item differs from the expected one.
+	StatusThrottled                   // The command was not executed because the user exceeded its rate limit.
 )
 
 var ByteOrder = binary.LittleEndian
@@ -92,6 +234,14 @@ func WriteRollbackCommand(w io.Writer) error {
 	return nil
 }
 
+// WriteTransactionStatusCommand writes a TRANSACTION_STATUS command.
+func WriteTransactionStatusCommand(w io.Writer) error {
+	if _, err := w.Write([]byte{byte(CmdTransactionStatus)}); err != nil {
+		return fmt.Errorf("failed to write command type (transaction status): %w", err)
+	}
+	return nil
+}
+
 // WriteBackupCommand writes a BACKUP command.
 func WriteBackupCommand(w io.Writer) error {
 	if _, err := w.Write([]byte{byte(CmdBackup)}); err != nil {
@@ -120,6 +270,148 @@ func ReadRestoreCommand(r io.Reader) (string, error) {
 	return backupName, nil
 }
 
+// WriteRestoreCollectionCommand writes a RESTORE_COLLECTION command.
+func WriteRestoreCollectionCommand(w io.Writer, backupName, collectionName string) error {
+	if _, err := w.Write([]byte{byte(CmdRestoreCollection)}); err != nil {
+		return fmt.Errorf("failed to write command type (restore collection): %w", err)
+	}
+	if err := WriteString(w, backupName); err != nil {
+		return fmt.Errorf("failed to write backup name (restore collection): %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name (restore collection): %w", err)
+	}
+	return nil
+}
+
+// WriteBackupListCommand writes a BACKUP_LIST command.
+func WriteBackupListCommand(w io.Writer) error {
+	if _, err := w.Write([]byte{byte(CmdBackupList)}); err != nil {
+		return fmt.Errorf("failed to write command type (backup list): %w", err)
+	}
+	return nil
+}
+
+// WriteBackupDeleteCommand writes a BACKUP_DELETE command.
+func WriteBackupDeleteCommand(w io.Writer, backupName string) error {
+	if _, err := w.Write([]byte{byte(CmdBackupDelete)}); err != nil {
+		return fmt.Errorf("failed to write command type (backup delete): %w", err)
+	}
+	if err := WriteString(w, backupName); err != nil {
+		return fmt.Errorf("failed to write backup name (backup delete): %w", err)
+	}
+	return nil
+}
+
+// ReadBackupDeleteCommand reads a BACKUP_DELETE command.
+func ReadBackupDeleteCommand(r io.Reader) (string, error) {
+	backupName, err := ReadString(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read backup name (backup delete): %w", err)
+	}
+	return backupName, nil
+}
+
+// WriteRestoreValidateCommand writes a RESTORE_VALIDATE command.
+func WriteRestoreValidateCommand(w io.Writer, backupName string) error {
+	if _, err := w.Write([]byte{byte(CmdRestoreValidate)}); err != nil {
+		return fmt.Errorf("failed to write command type (restore validate): %w", err)
+	}
+	if err := WriteString(w, backupName); err != nil {
+		return fmt.Errorf("failed to write backup name (restore validate): %w", err)
+	}
+	return nil
+}
+
+// ReadRestoreValidateCommand reads a RESTORE_VALIDATE command.
+func ReadRestoreValidateCommand(r io.Reader) (string, error) {
+	backupName, err := ReadString(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read backup name (restore validate): %w", err)
+	}
+	return backupName, nil
+}
+
+// ReadRestoreCollectionCommand reads a RESTORE_COLLECTION command.
+func ReadRestoreCollectionCommand(r io.Reader) (backupName, collectionName string, err error) {
+	backupName, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read backup name (restore collection): %w", err)
+	}
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read collection name (restore collection): %w", err)
+	}
+	return backupName, collectionName, nil
+}
+
+// WritePingCommand writes a PING command. It carries no payload and is answered with PONG.
+func WritePingCommand(w io.Writer) error {
+	if _, err := w.Write([]byte{byte(CmdPing)}); err != nil {
+		return fmt.Errorf("failed to write command type (ping): %w", err)
+	}
+	return nil
+}
+
+// ReadPingCommand reads a PING command. There is no payload to read.
+func ReadPingCommand(r io.Reader) error {
+	return nil
+}
+
+// WriteCompactAllCommand writes a COMPACT_ALL command.
+func WriteCompactAllCommand(w io.Writer) error {
+	if _, err := w.Write([]byte{byte(CmdCompactAll)}); err != nil {
+		return fmt.Errorf("failed to write command type (compact all): %w", err)
+	}
+	return nil
+}
+
+// WriteCompactStatusCommand writes a COMPACT_STATUS command.
+func WriteCompactStatusCommand(w io.Writer, jobID string) error {
+	if _, err := w.Write([]byte{byte(CmdCompactStatus)}); err != nil {
+		return fmt.Errorf("failed to write command type (compact status): %w", err)
+	}
+	if err := WriteString(w, jobID); err != nil {
+		return fmt.Errorf("failed to write job id (compact status): %w", err)
+	}
+	return nil
+}
+
+// ReadCompactStatusCommand reads a COMPACT_STATUS command.
+func ReadCompactStatusCommand(r io.Reader) (string, error) {
+	jobID, err := ReadString(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read job id (compact status): %w", err)
+	}
+	return jobID, nil
+}
+
+// WriteMemoryStatsCommand writes a MEMORY_STATS command.
+func WriteMemoryStatsCommand(w io.Writer) error {
+	if _, err := w.Write([]byte{byte(CmdMemoryStats)}); err != nil {
+		return fmt.Errorf("failed to write command type (memory stats): %w", err)
+	}
+	return nil
+}
+
+// ReadMemoryStatsCommand reads a MEMORY_STATS command. There is no payload to read.
+func ReadMemoryStatsCommand(r io.Reader) error {
+	return nil
+}
+
+// WriteStatsCommand writes a STATS command.
+func WriteStatsCommand(w io.Writer) error {
+	if _, err := w.Write([]byte{byte(CmdStats)}); err != nil {
+		return fmt.Errorf("failed to write command type (stats): %w", err)
+	}
+	return nil
+}
+
+// ReadStatsCommand reads a STATS command. There is no payload to read.
+func ReadStatsCommand(r io.Reader) error {
+	return nil
+}
+
 // WriteUserCreateCommand writes a USER_CREATE command.
 func WriteUserCreateCommand(w io.Writer, username, password string, permissionsJSON []byte) error {
 	if _, err := w.Write([]byte{byte(CmdUserCreate)}); err != nil {
@@ -201,6 +493,159 @@ func ReadUserDeleteCommand(r io.Reader) (username string, err error) {
 	return username, nil
 }
 
+// WriteRoleCreateCommand writes a ROLE_CREATE command.
+func WriteRoleCreateCommand(w io.Writer, roleName string, permissionsJSON []byte) error {
+	if _, err := w.Write([]byte{byte(CmdRoleCreate)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, roleName); err != nil {
+		return fmt.Errorf("failed to write role name: %w", err)
+	}
+	if err := WriteBytes(w, permissionsJSON); err != nil {
+		return fmt.Errorf("failed to write permissions: %w", err)
+	}
+	return nil
+}
+
+// ReadRoleCreateCommand reads a ROLE_CREATE command.
+func ReadRoleCreateCommand(r io.Reader) (roleName string, permissionsJSON []byte, err error) {
+	roleName, err = ReadString(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read role name: %w", err)
+	}
+	permissionsJSON, err = ReadBytes(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read permissions: %w", err)
+	}
+	return roleName, permissionsJSON, nil
+}
+
+// WriteRoleUpdateCommand writes a ROLE_UPDATE command.
+func WriteRoleUpdateCommand(w io.Writer, roleName string, permissionsJSON []byte) error {
+	if _, err := w.Write([]byte{byte(CmdRoleUpdate)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, roleName); err != nil {
+		return fmt.Errorf("failed to write role name: %w", err)
+	}
+	if err := WriteBytes(w, permissionsJSON); err != nil {
+		return fmt.Errorf("failed to write permissions: %w", err)
+	}
+	return nil
+}
+
+// ReadRoleUpdateCommand reads a ROLE_UPDATE command.
+func ReadRoleUpdateCommand(r io.Reader) (roleName string, permissionsJSON []byte, err error) {
+	roleName, err = ReadString(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read role name: %w", err)
+	}
+	permissionsJSON, err = ReadBytes(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read permissions: %w", err)
+	}
+	return roleName, permissionsJSON, nil
+}
+
+// WriteRoleDeleteCommand writes a ROLE_DELETE command.
+func WriteRoleDeleteCommand(w io.Writer, roleName string) error {
+	if _, err := w.Write([]byte{byte(CmdRoleDelete)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, roleName); err != nil {
+		return fmt.Errorf("failed to write role name: %w", err)
+	}
+	return nil
+}
+
+// ReadRoleDeleteCommand reads a ROLE_DELETE command.
+func ReadRoleDeleteCommand(r io.Reader) (roleName string, err error) {
+	roleName, err = ReadString(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read role name: %w", err)
+	}
+	return roleName, nil
+}
+
+// WriteUserSetRolesCommand writes a USER_SET_ROLES command. An empty list removes every role.
+// Format: [CmdUserSetRoles (1 byte)] [UsernameLength] [Username] [RolesCount] [Role1Length] [Role1] ...
+func WriteUserSetRolesCommand(w io.Writer, username string, roles []string) error {
+	if _, err := w.Write([]byte{byte(CmdUserSetRoles)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, username); err != nil {
+		return fmt.Errorf("failed to write username: %w", err)
+	}
+	if err := binary.Write(w, ByteOrder, uint32(len(roles))); err != nil {
+		return fmt.Errorf("failed to write roles count: %w", err)
+	}
+	for _, role := range roles {
+		if err := WriteString(w, role); err != nil {
+			return fmt.Errorf("failed to write role '%s': %w", role, err)
+		}
+	}
+	return nil
+}
+
+// ReadUserSetRolesCommand reads a USER_SET_ROLES command.
+func ReadUserSetRolesCommand(r io.Reader) (username string, roles []string, err error) {
+	username, err = ReadString(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read username: %w", err)
+	}
+	var rolesCount uint32
+	if err := binary.Read(r, ByteOrder, &rolesCount); err != nil {
+		return "", nil, fmt.Errorf("failed to read roles count: %w", err)
+	}
+	roles = make([]string, rolesCount)
+	for i := 0; i < int(rolesCount); i++ {
+		if roles[i], err = ReadString(r); err != nil {
+			return "", nil, fmt.Errorf("failed to read role %d: %w", i, err)
+		}
+	}
+	return username, roles, nil
+}
+
+// WriteExportUsersCommand writes an EXPORT_USERS command.
+func WriteExportUsersCommand(w io.Writer) error {
+	if _, err := w.Write([]byte{byte(CmdExportUsers)}); err != nil {
+		return fmt.Errorf("failed to write command type (export users): %w", err)
+	}
+	return nil
+}
+
+// WriteImportUsersCommand writes an IMPORT_USERS command.
+// The flag is sent as a string so the command fits the generic payload layout used by the WAL.
+func WriteImportUsersCommand(w io.Writer, overwrite bool, usersJSON []byte) error {
+	if _, err := w.Write([]byte{byte(CmdImportUsers)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, strconv.FormatBool(overwrite)); err != nil {
+		return fmt.Errorf("failed to write overwrite flag: %w", err)
+	}
+	if err := WriteBytes(w, usersJSON); err != nil {
+		return fmt.Errorf("failed to write users: %w", err)
+	}
+	return nil
+}
+
+// ReadImportUsersCommand reads an IMPORT_USERS command.
+func ReadImportUsersCommand(r io.Reader) (overwrite bool, usersJSON []byte, err error) {
+	flag, err := ReadString(r)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to read overwrite flag: %w", err)
+	}
+	overwrite, err = strconv.ParseBool(flag)
+	if err != nil {
+		return false, nil, fmt.Errorf("invalid overwrite flag '%s': %w", flag, err)
+	}
+	usersJSON, err = ReadBytes(r)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to read users: %w", err)
+	}
+	return overwrite, usersJSON, nil
+}
+
 // WriteResponse sends a structured binary response over the connection.
 func WriteResponse(w io.Writer, status ResponseStatus, msg string, data []byte) error {
 	bufferSize := 1 + 4 + len(msg) + 4 + len(data)
@@ -231,6 +676,20 @@ func WriteResponse(w io.Writer, status ResponseStatus, msg string, data []byte)
 	return nil
 }
 
+// WriteResponseHeader sends the start of a response whose dataLen bytes of data the caller writes
+// next, for data that is written as it is produced instead of being built in memory first.
+func WriteResponseHeader(w io.Writer, status ResponseStatus, msg string, dataLen int) error {
+	buf := bytes.NewBuffer(make([]byte, 0, 1+4+len(msg)+4))
+	buf.WriteByte(byte(status))
+	binary.Write(buf, ByteOrder, uint32(len(msg)))
+	buf.WriteString(msg)
+	binary.Write(buf, ByteOrder, uint32(dataLen))
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write response header to network: %w", err)
+	}
+	return nil
+}
+
 // ReadCommandType reads the command type from the connection.
 func ReadCommandType(r io.Reader) (CommandType, error) {
 	buf := make([]byte, 1)
@@ -246,11 +705,13 @@ func ReadString(r io.Reader) (string, error) {
 	if err := binary.Read(r, ByteOrder, &strLen); err != nil {
 		return "", fmt.Errorf("failed to read string length: %w", err)
 	}
-	strBytes := make([]byte, strLen)
-	if _, err := io.ReadFull(r, strBytes); err != nil {
+	// The string conversion copies the bytes, so the pooled scratch buffer is never aliased.
+	scratch := getScratch(int(strLen))
+	defer putScratch(scratch)
+	if _, err := io.ReadFull(r, *scratch); err != nil {
 		return "", fmt.Errorf("failed to read string bytes: %w", err)
 	}
-	return string(strBytes), nil
+	return string(*scratch), nil
 }
 
 // WriteString writes a length-prefixed string to the connection.
@@ -387,15 +848,80 @@ func ReadCollectionDeleteCommand(r io.Reader) (collectionName string, err error)
 	return collectionName, nil
 }
 
+// WriteCollectionReloadCommand writes a RELOAD_COLLECTION command to the connection.
+func WriteCollectionReloadCommand(w io.Writer, collectionName string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionReload)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionReloadCommand reads a RELOAD_COLLECTION command from the connection.
+func ReadCollectionReloadCommand(r io.Reader) (collectionName string, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read collection name: %w", err)
+	}
+	return collectionName, nil
+}
+
+// WriteCollectionStatsCommand writes a COLLECTION_STATS command to the connection.
+func WriteCollectionStatsCommand(w io.Writer, collectionName string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionStats)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionStatsCommand reads a COLLECTION_STATS command from the connection.
+func ReadCollectionStatsCommand(r io.Reader) (collectionName string, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read collection name: %w", err)
+	}
+	return collectionName, nil
+}
+
 // WriteCollectionListCommand writes a LIST_COLLECTIONS command to the connection.
-// Format: [CmdCollectionList (1 byte)]
-func WriteCollectionListCommand(w io.Writer) error {
+// An empty prefix matches every collection and a zero limit uses the server's default page size.
+// Format: [CmdCollectionList (1 byte)] [PrefixLength] [Prefix] [Limit (4 bytes)] [Offset (4 bytes)]
+func WriteCollectionListCommand(w io.Writer, prefix string, limit, offset uint32) error {
 	if _, err := w.Write([]byte{byte(CmdCollectionList)}); err != nil {
 		return fmt.Errorf("failed to write command type: %w", err)
 	}
+	if err := WriteString(w, prefix); err != nil {
+		return fmt.Errorf("failed to write prefix: %w", err)
+	}
+	if err := binary.Write(w, ByteOrder, limit); err != nil {
+		return fmt.Errorf("failed to write limit: %w", err)
+	}
+	if err := binary.Write(w, ByteOrder, offset); err != nil {
+		return fmt.Errorf("failed to write offset: %w", err)
+	}
 	return nil
 }
 
+// ReadCollectionListCommand reads a LIST_COLLECTIONS command from the connection.
+func ReadCollectionListCommand(r io.Reader) (prefix string, limit, offset uint32, err error) {
+	prefix, err = ReadString(r)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("failed to read prefix: %w", err)
+	}
+	if err = binary.Read(r, ByteOrder, &limit); err != nil {
+		return "", 0, 0, fmt.Errorf("failed to read limit: %w", err)
+	}
+	if err = binary.Read(r, ByteOrder, &offset); err != nil {
+		return "", 0, 0, fmt.Errorf("failed to read offset: %w", err)
+	}
+	return prefix, limit, offset, nil
+}
+
 // WriteCollectionItemSetCommand writes a SET_COLLECTION_ITEM command to the connection.
 // Format: [CmdCollectionItemSet (1 byte)] [ColNameLength] [ColName] [KeyLength] [Key] [ValueLength] [Value] [TTLSeconds]
 func WriteCollectionItemSetCommand(w io.Writer, collectionName, key string, value []byte, ttl time.Duration) error {
@@ -440,8 +966,18 @@ func ReadCollectionItemSetCommand(r io.Reader) (collectionName, key string, valu
 }
 
 // WriteCollectionItemUpdateCommand writes a UPDATE_COLLECTION_ITEM command to the connection.
-// Format: [CmdCollectionItemUpdate (1 byte)] [ColNameLength] [ColName] [KeyLength] [Key] [PatchValueLength] [PatchValue]
+// Format: [CmdCollectionItemUpdate (1 byte)] [ColNameLength] [ColName] [KeyLength] [Key] [PatchValueLength] [PatchValue] [IfMatchLength] [IfMatch]
 func WriteCollectionItemUpdateCommand(w io.Writer, collectionName, key string, patchValue []byte) error {
+	return writeCollectionItemUpdateCommand(w, collectionName, key, patchValue, "")
+}
+
+// WriteCollectionItemUpdateIfMatchCommand writes a UPDATE_COLLECTION_ITEM command with an If-Match version.
+// The patch is only applied if the stored document is at that version; 0 matches documents without one.
+func WriteCollectionItemUpdateIfMatchCommand(w io.Writer, collectionName, key string, patchValue []byte, version uint64) error {
+	return writeCollectionItemUpdateCommand(w, collectionName, key, patchValue, strconv.FormatUint(version, 10))
+}
+
+func writeCollectionItemUpdateCommand(w io.Writer, collectionName, key string, patchValue []byte, ifMatch string) error {
 	if _, err := w.Write([]byte{byte(CmdCollectionItemUpdate)}); err != nil {
 		return fmt.Errorf("failed to write command type: %w", err)
 	}
@@ -454,10 +990,11 @@ func WriteCollectionItemUpdateCommand(w io.Writer, collectionName, key string, p
 	if err := WriteBytes(w, patchValue); err != nil {
 		return fmt.Errorf("failed to write patch value: %w", err)
 	}
-	return nil
+	return writeIfMatch(w, ifMatch)
 }
 
-// ReadCollectionItemUpdateCommand reads a UPDATE_COLLECTION_ITEM command from the connection.
+// ReadCollectionItemUpdateCommand reads a UPDATE_COLLECTION_ITEM command from the connection,
+// up to its If-Match version, which is read with ReadIfMatch.
 func ReadCollectionItemUpdateCommand(r io.Reader) (collectionName, key string, patchValue []byte, err error) {
 	collectionName, err = ReadString(r)
 	if err != nil {
@@ -474,10 +1011,11 @@ func ReadCollectionItemUpdateCommand(r io.Reader) (collectionName, key string, p
 	return collectionName, key, patchValue, nil
 }
 
-// WriteCollectionItemGetCommand writes a GET_COLLECTION_ITEM command to the connection.
-// Format: [CmdCollectionItemGet (1 byte)] [ColNameLength] [ColName] [KeyLength] [Key]
-func WriteCollectionItemGetCommand(w io.Writer, collectionName, key string) error {
-	if _, err := w.Write([]byte{byte(CmdCollectionItemGet)}); err != nil {
+// WriteCollectionItemUpsertCommand writes a UPSERT_COLLECTION_ITEM command to the connection.
+// It uses the same payload as UPDATE_COLLECTION_ITEM and is read with ReadCollectionItemUpdateCommand.
+// Format: [CmdCollectionItemUpsert (1 byte)] [ColNameLength] [ColName] [KeyLength] [Key] [PatchValueLength] [PatchValue]
+func WriteCollectionItemUpsertCommand(w io.Writer, collectionName, key string, patchValue []byte) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionItemUpsert)}); err != nil {
 		return fmt.Errorf("failed to write command type: %w", err)
 	}
 	if err := WriteString(w, collectionName); err != nil {
@@ -486,411 +1024,1750 @@ func WriteCollectionItemGetCommand(w io.Writer, collectionName, key string) erro
 	if err := WriteString(w, key); err != nil {
 		return fmt.Errorf("failed to write key: %w", err)
 	}
-	return nil
+	if err := WriteBytes(w, patchValue); err != nil {
+		return fmt.Errorf("failed to write patch value: %w", err)
+	}
+	return nil
+}
+
+// WriteCollectionItemUpdateIfCommand writes an UPDATE_COLLECTION_ITEM_IF command to the connection.
+// The patch is applied only if the current document matches the condition filter.
+// Format: [CmdCollectionItemUpdateIf (1 byte)] [ColNameLength] [ColName] [KeyLength] [Key] [ConditionLength] [Condition] [PatchValueLength] [PatchValue]
+func WriteCollectionItemUpdateIfCommand(w io.Writer, collectionName, key string, condition, patchValue []byte) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionItemUpdateIf)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, key); err != nil {
+		return fmt.Errorf("failed to write key: %w", err)
+	}
+	if err := WriteBytes(w, condition); err != nil {
+		return fmt.Errorf("failed to write condition: %w", err)
+	}
+	if err := WriteBytes(w, patchValue); err != nil {
+		return fmt.Errorf("failed to write patch value: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionItemUpdateIfCommand reads an UPDATE_COLLECTION_ITEM_IF command from the connection.
+func ReadCollectionItemUpdateIfCommand(r io.Reader) (collectionName, key string, condition, patchValue []byte, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", "", nil, nil, fmt.Errorf("failed to read collection name: %w", err)
+	}
+	key, err = ReadString(r)
+	if err != nil {
+		return "", "", nil, nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	condition, err = ReadBytes(r)
+	if err != nil {
+		return "", "", nil, nil, fmt.Errorf("failed to read condition: %w", err)
+	}
+	patchValue, err = ReadBytes(r)
+	if err != nil {
+		return "", "", nil, nil, fmt.Errorf("failed to read patch value: %w", err)
+	}
+	return collectionName, key, condition, patchValue, nil
+}
+
+// WriteCollectionItemMergeByQueryCommand writes a MERGE_COLLECTION_ITEMS_BY_QUERY command to the connection.
+// The patch is an RFC 7386 merge patch applied to every document matching the filter.
+// Format: [CmdCollectionItemMergeByQuery (1 byte)] [ColNameLength] [ColName] [FilterLength] [Filter] [PatchLength] [Patch]
+func WriteCollectionItemMergeByQueryCommand(w io.Writer, collectionName string, filter, patch []byte) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionItemMergeByQuery)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteBytes(w, filter); err != nil {
+		return fmt.Errorf("failed to write filter: %w", err)
+	}
+	if err := WriteBytes(w, patch); err != nil {
+		return fmt.Errorf("failed to write patch: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionItemMergeByQueryCommand reads a MERGE_COLLECTION_ITEMS_BY_QUERY command from the connection.
+func ReadCollectionItemMergeByQueryCommand(r io.Reader) (collectionName string, filter, patch []byte, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to read collection name: %w", err)
+	}
+	filter, err = ReadBytes(r)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to read filter: %w", err)
+	}
+	patch, err = ReadBytes(r)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to read patch: %w", err)
+	}
+	return collectionName, filter, patch, nil
+}
+
+// WriteCollectionItemIncrementCommand writes an INCREMENT_COLLECTION_ITEM command to the connection.
+// The delta is sent as a string so the command fits the generic payload layout used by the WAL.
+// Format: [CmdCollectionItemIncrement (1 byte)] [ColNameLength] [ColName] [KeyLength] [Key] [FieldLength] [Field] [DeltaLength] [Delta]
+func WriteCollectionItemIncrementCommand(w io.Writer, collectionName, key, field string, delta float64) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionItemIncrement)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, key); err != nil {
+		return fmt.Errorf("failed to write key: %w", err)
+	}
+	if err := WriteString(w, field); err != nil {
+		return fmt.Errorf("failed to write field: %w", err)
+	}
+	if err := WriteString(w, strconv.FormatFloat(delta, 'g', -1, 64)); err != nil {
+		return fmt.Errorf("failed to write delta: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionItemIncrementCommand reads an INCREMENT_COLLECTION_ITEM command from the connection.
+func ReadCollectionItemIncrementCommand(r io.Reader) (collectionName, key, field string, delta float64, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", "", "", 0, fmt.Errorf("failed to read collection name: %w", err)
+	}
+	key, err = ReadString(r)
+	if err != nil {
+		return "", "", "", 0, fmt.Errorf("failed to read key: %w", err)
+	}
+	field, err = ReadString(r)
+	if err != nil {
+		return "", "", "", 0, fmt.Errorf("failed to read field: %w", err)
+	}
+	deltaStr, err := ReadString(r)
+	if err != nil {
+		return "", "", "", 0, fmt.Errorf("failed to read delta: %w", err)
+	}
+	delta, err = strconv.ParseFloat(deltaStr, 64)
+	if err != nil {
+		return "", "", "", 0, fmt.Errorf("invalid delta '%s': %w", deltaStr, err)
+	}
+	return collectionName, key, field, delta, nil
+}
+
+// WriteCollectionItemReplaceCommand writes a REPLACE_COLLECTION_ITEM command to the connection.
+// Unlike an update, the value replaces the whole document instead of being merged into it.
+// Format: [CmdCollectionItemReplace (1 byte)] [ColNameLength] [ColName] [KeyLength] [Key] [ValueLength] [Value] [IfMatchLength] [IfMatch]
+func WriteCollectionItemReplaceCommand(w io.Writer, collectionName, key string, value []byte) error {
+	return writeCollectionItemReplaceCommand(w, collectionName, key, value, "")
+}
+
+// WriteCollectionItemReplaceIfMatchCommand writes a REPLACE_COLLECTION_ITEM command with an If-Match version.
+// The value only replaces the document if the stored document is at that version.
+func WriteCollectionItemReplaceIfMatchCommand(w io.Writer, collectionName, key string, value []byte, version uint64) error {
+	return writeCollectionItemReplaceCommand(w, collectionName, key, value, strconv.FormatUint(version, 10))
+}
+
+func writeCollectionItemReplaceCommand(w io.Writer, collectionName, key string, value []byte, ifMatch string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionItemReplace)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, key); err != nil {
+		return fmt.Errorf("failed to write key: %w", err)
+	}
+	if err := WriteBytes(w, value); err != nil {
+		return fmt.Errorf("failed to write value: %w", err)
+	}
+	return writeIfMatch(w, ifMatch)
+}
+
+// ReadCollectionItemReplaceCommand reads a REPLACE_COLLECTION_ITEM command from the connection,
+// up to its If-Match version, which is read with ReadIfMatch.
+func ReadCollectionItemReplaceCommand(r io.Reader) (collectionName, key string, value []byte, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to read collection name: %w", err)
+	}
+	key, err = ReadString(r)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	value, err = ReadBytes(r)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to read value: %w", err)
+	}
+	return collectionName, key, value, nil
+}
+
+// writeIfMatch writes the If-Match field that ends UPDATE_COLLECTION_ITEM and REPLACE_COLLECTION_ITEM:
+// the version the stored document must be at as a decimal string, or an empty string for an
+// unconditional write.
+func writeIfMatch(w io.Writer, ifMatch string) error {
+	if err := WriteString(w, ifMatch); err != nil {
+		return fmt.Errorf("failed to write If-Match version: %w", err)
+	}
+	return nil
+}
+
+// ReadIfMatch reads the If-Match field that ends UPDATE_COLLECTION_ITEM and REPLACE_COLLECTION_ITEM.
+// ok is false for an unconditional write, including one logged to the WAL before the field existed.
+func ReadIfMatch(r io.Reader) (version uint64, ok bool, err error) {
+	versionStr, err := ReadString(r)
+	if errors.Is(err, io.EOF) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read If-Match version: %w", err)
+	}
+	if versionStr == "" {
+		return 0, false, nil
+	}
+	version, err = strconv.ParseUint(versionStr, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid If-Match version '%s': %w", versionStr, err)
+	}
+	return version, true, nil
+}
+
+// WriteCollectionItemGetCommand writes a GET_COLLECTION_ITEM command to the connection.
+// fields is an optional mask of dot-separated paths; when empty the whole document is returned.
+// Format: [CmdCollectionItemGet (1 byte)] [ColNameLength] [ColName] [KeyLength] [Key] [NumFields] [FieldLength] [Field]...
+func WriteCollectionItemGetCommand(w io.Writer, collectionName, key string, fields []string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionItemGet)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, key); err != nil {
+		return fmt.Errorf("failed to write key: %w", err)
+	}
+	if err := binary.Write(w, ByteOrder, uint32(len(fields))); err != nil {
+		return fmt.Errorf("failed to write fields count: %w", err)
+	}
+	for _, field := range fields {
+		if err := WriteString(w, field); err != nil {
+			return fmt.Errorf("failed to write field '%s': %w", field, err)
+		}
+	}
+	return nil
+}
+
+// ReadCollectionItemGetCommand reads a GET_COLLECTION_ITEM command from the connection.
+func ReadCollectionItemGetCommand(r io.Reader) (collectionName, key string, fields []string, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to read collection name: %w", err)
+	}
+	key, err = ReadString(r)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	var fieldsCount uint32
+	if err = binary.Read(r, ByteOrder, &fieldsCount); err != nil {
+		return "", "", nil, fmt.Errorf("failed to read fields count: %w", err)
+	}
+	fields = make([]string, fieldsCount)
+	for i := 0; i < int(fieldsCount); i++ {
+		if fields[i], err = ReadString(r); err != nil {
+			return "", "", nil, fmt.Errorf("failed to read field %d: %w", i, err)
+		}
+	}
+	return collectionName, key, fields, nil
+}
+
+// WriteCollectionExportCommand writes an EXPORT_COLLECTION command to the connection.
+// fields lists the dot-separated paths to export; it sets the CSV columns and is optional for JSON.
+// Format: [CmdCollectionExport (1 byte)] [ColNameLength] [ColName] [FormatLength] [Format] [NumFields] [FieldLength] [Field]...
+func WriteCollectionExportCommand(w io.Writer, collectionName, format string, fields []string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionExport)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, format); err != nil {
+		return fmt.Errorf("failed to write format: %w", err)
+	}
+	if err := binary.Write(w, ByteOrder, uint32(len(fields))); err != nil {
+		return fmt.Errorf("failed to write fields count: %w", err)
+	}
+	for _, field := range fields {
+		if err := WriteString(w, field); err != nil {
+			return fmt.Errorf("failed to write field '%s': %w", field, err)
+		}
+	}
+	return nil
+}
+
+// ReadCollectionExportCommand reads an EXPORT_COLLECTION command from the connection.
+func ReadCollectionExportCommand(r io.Reader) (collectionName, format string, fields []string, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to read collection name: %w", err)
+	}
+	format, err = ReadString(r)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to read format: %w", err)
+	}
+	var fieldsCount uint32
+	if err = binary.Read(r, ByteOrder, &fieldsCount); err != nil {
+		return "", "", nil, fmt.Errorf("failed to read fields count: %w", err)
+	}
+	fields = make([]string, fieldsCount)
+	for i := 0; i < int(fieldsCount); i++ {
+		if fields[i], err = ReadString(r); err != nil {
+			return "", "", nil, fmt.Errorf("failed to read field %d: %w", i, err)
+		}
+	}
+	return collectionName, format, fields, nil
+}
+
+// WriteCollectionImportCommand writes the header of an IMPORT_COLLECTION command to the connection.
+// The documents follow, each written with WriteImportDocument, and WriteImportEnd closes the stream.
+// A batchSize of 0 lets the server pick the batch size.
+// Format: [CmdCollectionImport (1 byte)] [ColNameLength] [ColName] [BatchSize (uint32)] [DocLength] [Doc]... [0 (uint32)]
+func WriteCollectionImportCommand(w io.Writer, collectionName string, batchSize uint32) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionImport)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := binary.Write(w, ByteOrder, batchSize); err != nil {
+		return fmt.Errorf("failed to write batch size: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionImportCommand reads the header of an IMPORT_COLLECTION command from the connection.
+func ReadCollectionImportCommand(r io.Reader) (collectionName string, batchSize uint32, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read collection name: %w", err)
+	}
+	if err = binary.Read(r, ByteOrder, &batchSize); err != nil {
+		return "", 0, fmt.Errorf("failed to read batch size: %w", err)
+	}
+	return collectionName, batchSize, nil
+}
+
+// WriteImportDocument writes one JSON document of an IMPORT_COLLECTION stream.
+func WriteImportDocument(w io.Writer, doc []byte) error {
+	if len(doc) == 0 {
+		return fmt.Errorf("import document cannot be empty")
+	}
+	return WriteBytes(w, doc)
+}
+
+// WriteImportEnd ends an IMPORT_COLLECTION stream.
+func WriteImportEnd(w io.Writer) error {
+	return binary.Write(w, ByteOrder, uint32(0))
+}
+
+// ReadImportDocument reads the next document of an IMPORT_COLLECTION stream.
+// It returns nil at the end of the stream.
+func ReadImportDocument(r io.Reader) ([]byte, error) {
+	doc, err := ReadBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(doc) == 0 {
+		return nil, nil
+	}
+	return doc, nil
+}
+
+// WriteCollectionItemExistsCommand writes a COLLECTION_ITEM_EXISTS command to the connection.
+// Format: [CmdCollectionItemExists (1 byte)] [ColNameLength] [ColName] [KeyLength] [Key]
+func WriteCollectionItemExistsCommand(w io.Writer, collectionName, key string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionItemExists)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, key); err != nil {
+		return fmt.Errorf("failed to write key: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionItemExistsCommand reads a COLLECTION_ITEM_EXISTS command from the connection.
+func ReadCollectionItemExistsCommand(r io.Reader) (collectionName, key string, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read collection name: %w", err)
+	}
+	key, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read key: %w", err)
+	}
+	return collectionName, key, nil
+}
+
+// WriteCollectionItemTTLCommand writes a COLLECTION_ITEM_TTL command to the connection.
+// Format: [CmdCollectionItemTTL (1 byte)] [ColNameLength] [ColName] [KeyLength] [Key]
+func WriteCollectionItemTTLCommand(w io.Writer, collectionName, key string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionItemTTL)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, key); err != nil {
+		return fmt.Errorf("failed to write key: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionItemTTLCommand reads a COLLECTION_ITEM_TTL command from the connection.
+func ReadCollectionItemTTLCommand(r io.Reader) (collectionName, key string, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read collection name: %w", err)
+	}
+	key, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read key: %w", err)
+	}
+	return collectionName, key, nil
+}
+
+// WriteCollectionItemTouchCommand writes a TOUCH_COLLECTION_ITEM command to the connection.
+// A zero ttl removes the item's expiry.
+// Format: [CmdCollectionItemTouch (1 byte)] [ColNameLength] [ColName] [KeyLength] [Key] [TTLSeconds]
+func WriteCollectionItemTouchCommand(w io.Writer, collectionName, key string, ttl time.Duration) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionItemTouch)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, key); err != nil {
+		return fmt.Errorf("failed to write key: %w", err)
+	}
+	if err := binary.Write(w, ByteOrder, int64(ttl.Seconds())); err != nil {
+		return fmt.Errorf("failed to write TTL seconds: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionItemTouchCommand reads a TOUCH_COLLECTION_ITEM command from the connection.
+func ReadCollectionItemTouchCommand(r io.Reader) (collectionName, key string, ttl time.Duration, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to read collection name: %w", err)
+	}
+	key, err = ReadString(r)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to read key: %w", err)
+	}
+	var ttlSeconds int64
+	if err := binary.Read(r, ByteOrder, &ttlSeconds); err != nil {
+		return "", "", 0, fmt.Errorf("failed to read TTL seconds: %w", err)
+	}
+	return collectionName, key, time.Duration(ttlSeconds) * time.Second, nil
+}
+
+// WriteCollectionKeyScanCommand writes a SCAN_COLLECTION_KEYS command to the connection.
+// after is the next_cursor of the previous page, or empty for the first page; a zero limit
+// leaves the page size to the server.
+// Format: [CmdCollectionKeyScan (1 byte)] [ColNameLength] [ColName] [PrefixLength] [Prefix] [AfterLength] [After] [LimitLength] [Limit] [IncludeColdLength] [IncludeCold]
+func WriteCollectionKeyScanCommand(w io.Writer, collectionName, prefix, after string, limit int, includeCold bool) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionKeyScan)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, prefix); err != nil {
+		return fmt.Errorf("failed to write prefix: %w", err)
+	}
+	if err := WriteString(w, after); err != nil {
+		return fmt.Errorf("failed to write cursor: %w", err)
+	}
+	if err := WriteString(w, strconv.Itoa(limit)); err != nil {
+		return fmt.Errorf("failed to write limit: %w", err)
+	}
+	if err := WriteString(w, strconv.FormatBool(includeCold)); err != nil {
+		return fmt.Errorf("failed to write cold keys flag: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionKeyScanCommand reads a SCAN_COLLECTION_KEYS command from the connection.
+func ReadCollectionKeyScanCommand(r io.Reader) (collectionName, prefix, after string, limit int, includeCold bool, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", "", "", 0, false, fmt.Errorf("failed to read collection name: %w", err)
+	}
+	prefix, err = ReadString(r)
+	if err != nil {
+		return "", "", "", 0, false, fmt.Errorf("failed to read prefix: %w", err)
+	}
+	after, err = ReadString(r)
+	if err != nil {
+		return "", "", "", 0, false, fmt.Errorf("failed to read cursor: %w", err)
+	}
+	limitStr, err := ReadString(r)
+	if err != nil {
+		return "", "", "", 0, false, fmt.Errorf("failed to read limit: %w", err)
+	}
+	limit, err = strconv.Atoi(limitStr)
+	if err != nil {
+		return "", "", "", 0, false, fmt.Errorf("invalid limit '%s': %w", limitStr, err)
+	}
+	flag, err := ReadString(r)
+	if err != nil {
+		return "", "", "", 0, false, fmt.Errorf("failed to read cold keys flag: %w", err)
+	}
+	includeCold, err = strconv.ParseBool(flag)
+	if err != nil {
+		return "", "", "", 0, false, fmt.Errorf("invalid cold keys flag '%s': %w", flag, err)
+	}
+	return collectionName, prefix, after, limit, includeCold, nil
+}
+
+// WriteCollectionItemGetManyCommand writes a GET_COLLECTION_ITEMS_MANY command to the connection.
+// Format: [CmdCollectionItemGetMany (1 byte)] [ColNameLength] [ColName] [KeysArrayLength] [Key1Length] [Key1] [Key2Length] [Key2] ...
+func WriteCollectionItemGetManyCommand(w io.Writer, collectionName string, keys []string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionItemGetMany)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := binary.Write(w, ByteOrder, uint32(len(keys))); err != nil {
+		return fmt.Errorf("failed to write keys count: %w", err)
+	}
+	for _, key := range keys {
+		if err := WriteString(w, key); err != nil {
+			return fmt.Errorf("failed to write key '%s': %w", key, err)
+		}
+	}
+	return nil
+}
+
+// ReadCollectionItemGetManyCommand reads a GET_COLLECTION_ITEMS_MANY command from the connection.
+func ReadCollectionItemGetManyCommand(r io.Reader) (collectionName string, keys []string, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read collection name: %w", err)
+	}
+	var keysCount uint32
+	if err := binary.Read(r, ByteOrder, &keysCount); err != nil {
+		return "", nil, fmt.Errorf("failed to read keys count: %w", err)
+	}
+	keys = make([]string, keysCount)
+	for i := 0; i < int(keysCount); i++ {
+		if keys[i], err = ReadString(r); err != nil {
+			return "", nil, fmt.Errorf("failed to read key %d: %w", i, err)
+		}
+	}
+	return collectionName, keys, nil
+}
+
+// WriteCollectionItemDeleteCommand writes a DELETE_COLLECTION_ITEM command to the connection.
+// Format: [CmdCollectionItemDelete (1 byte)] [ColNameLength] [ColName] [KeyLength] [Key]
+func WriteCollectionItemDeleteCommand(w io.Writer, collectionName, key string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionItemDelete)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, key); err != nil {
+		return fmt.Errorf("failed to write key: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionItemDeleteCommand reads a DELETE_COLLECTION_ITEM command from the connection.
+func ReadCollectionItemDeleteCommand(r io.Reader) (collectionName, key string, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read collection name: %w", err)
+	}
+	key, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read key: %w", err)
+	}
+	return collectionName, key, nil
+}
+
+// WriteCollectionItemGetAndDeleteCommand writes a GET_AND_DELETE_COLLECTION_ITEM command to the connection.
+// Format: [CmdCollectionItemGetAndDelete (1 byte)] [ColNameLength] [ColName] [KeyLength] [Key]
+func WriteCollectionItemGetAndDeleteCommand(w io.Writer, collectionName, key string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionItemGetAndDelete)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, key); err != nil {
+		return fmt.Errorf("failed to write key: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionItemGetAndDeleteCommand reads a GET_AND_DELETE_COLLECTION_ITEM command from the connection.
+// Its payload has the same layout as DELETE_COLLECTION_ITEM.
+func ReadCollectionItemGetAndDeleteCommand(r io.Reader) (collectionName, key string, err error) {
+	return ReadCollectionItemDeleteCommand(r)
+}
+
+// WriteCollectionItemListCommand writes a LIST_COLLECTION_ITEMS command to the connection.
+// Format: [CmdCollectionItemList (1 byte)] [ColNameLength] [ColName]
+func WriteCollectionItemListCommand(w io.Writer, collectionName string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionItemList)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionItemListCommand reads a LIST_COLLECTION_ITEMS command from the connection.
+func ReadCollectionItemListCommand(r io.Reader) (collectionName string, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read collection name: %w", err)
+	}
+	return collectionName, nil
+}
+
+// WriteAuthenticateCommand writes an AUTH command to the connection.
+// Format: [CmdAuthenticate (1 byte)] [UsernameLength (4 bytes)] [Username] [PasswordLength (4 bytes)] [Password]
+func WriteAuthenticateCommand(w io.Writer, username, password string) error {
+	if _, err := w.Write([]byte{byte(CmdAuthenticate)}); err != nil {
+		return fmt.Errorf("failed to write command type (authenticate): %w", err)
+	}
+	if err := WriteString(w, username); err != nil {
+		return fmt.Errorf("failed to write username (authenticate): %w", err)
+	}
+	if err := WriteString(w, password); err != nil {
+		return fmt.Errorf("failed to write password (authenticate): %w", err)
+	}
+	return nil
+}
+
+// ReadAuthenticateCommand reads an AUTH command from the connection.
+func ReadAuthenticateCommand(r io.Reader) (username, password string, err error) {
+	username, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read username (authenticate): %w", err)
+	}
+	password, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read password (authenticate): %w", err)
+	}
+	return username, password, nil
+}
+
+// WriteChangeUserPasswordCommand writes a CHANGE_USER_PASSWORD command to the connection.
+// Format: [CmdChangeUserPassword (1 byte)] [TargetUsernameLength (4 bytes)] [TargetUsername] [NewPasswordLength (4 bytes)] [NewPassword]
+func WriteChangeUserPasswordCommand(w io.Writer, targetUsername, newPassword string) error {
+	if _, err := w.Write([]byte{byte(CmdChangeUserPassword)}); err != nil {
+		return fmt.Errorf("failed to write command type (change user password): %w", err)
+	}
+	if err := WriteString(w, targetUsername); err != nil {
+		return fmt.Errorf("failed to write target username (change user password): %w", err)
+	}
+	if err := WriteString(w, newPassword); err != nil {
+		return fmt.Errorf("failed to write new password (change user password): %w", err)
+	}
+	return nil
+}
+
+// ReadChangeUserPasswordCommand reads a CHANGE_USER_PASSWORD command from the connection.
+func ReadChangeUserPasswordCommand(r io.Reader) (targetUsername, newPassword string, err error) {
+	targetUsername, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read target username (change user password): %w", err)
+	}
+	newPassword, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read new password (change user password): %w", err)
+	}
+	return targetUsername, newPassword, nil
+}
+
+// WriteAuthenticateTokenCommand writes an AUTH_TOKEN command to the connection.
+// Format: [CmdAuthenticateToken (1 byte)] [APIKeyLength (4 bytes)] [APIKey]
+func WriteAuthenticateTokenCommand(w io.Writer, apiKey string) error {
+	if _, err := w.Write([]byte{byte(CmdAuthenticateToken)}); err != nil {
+		return fmt.Errorf("failed to write command type (authenticate token): %w", err)
+	}
+	if err := WriteString(w, apiKey); err != nil {
+		return fmt.Errorf("failed to write API key (authenticate token): %w", err)
+	}
+	return nil
+}
+
+// ReadAuthenticateTokenCommand reads an AUTH_TOKEN command from the connection.
+func ReadAuthenticateTokenCommand(r io.Reader) (apiKey string, err error) {
+	apiKey, err = ReadString(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read API key (authenticate token): %w", err)
+	}
+	return apiKey, nil
+}
+
+// WriteAPIKeyCreateCommand writes an API_KEY_CREATE command to the connection.
+// expiresAt is an RFC3339 time, or empty for a key that never expires. Empty permissionsJSON
+// gives the key the permissions of its user.
+// Format: [CmdAPIKeyCreate (1 byte)] [UsernameLength] [Username] [ExpiresAtLength] [ExpiresAt] [PermissionsJSONLength] [PermissionsJSON]
+func WriteAPIKeyCreateCommand(w io.Writer, username, expiresAt string, permissionsJSON []byte) error {
+	if _, err := w.Write([]byte{byte(CmdAPIKeyCreate)}); err != nil {
+		return fmt.Errorf("failed to write command type (api key create): %w", err)
+	}
+	if err := WriteString(w, username); err != nil {
+		return fmt.Errorf("failed to write username (api key create): %w", err)
+	}
+	if err := WriteString(w, expiresAt); err != nil {
+		return fmt.Errorf("failed to write expiry (api key create): %w", err)
+	}
+	if err := WriteBytes(w, permissionsJSON); err != nil {
+		return fmt.Errorf("failed to write permissions JSON (api key create): %w", err)
+	}
+	return nil
+}
+
+// ReadAPIKeyCreateCommand reads an API_KEY_CREATE command from the connection.
+func ReadAPIKeyCreateCommand(r io.Reader) (username, expiresAt string, permissionsJSON []byte, err error) {
+	username, err = ReadString(r)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to read username (api key create): %w", err)
+	}
+	expiresAt, err = ReadString(r)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to read expiry (api key create): %w", err)
+	}
+	permissionsJSON, err = ReadBytes(r)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to read permissions JSON (api key create): %w", err)
+	}
+	return username, expiresAt, permissionsJSON, nil
+}
+
+// WriteAPIKeyPutCommand writes an API_KEY_PUT command to the connection. It stores an API key
+// record as API_KEY_CREATE generated it, and is how creations are recorded in the WAL.
+// Format: [CmdAPIKeyPut (1 byte)] [KeyIDLength] [KeyID] [KeyJSONLength] [KeyJSON]
+func WriteAPIKeyPutCommand(w io.Writer, keyID string, keyJSON []byte) error {
+	if _, err := w.Write([]byte{byte(CmdAPIKeyPut)}); err != nil {
+		return fmt.Errorf("failed to write command type (api key put): %w", err)
+	}
+	if err := WriteString(w, keyID); err != nil {
+		return fmt.Errorf("failed to write key ID (api key put): %w", err)
+	}
+	if err := WriteBytes(w, keyJSON); err != nil {
+		return fmt.Errorf("failed to write key JSON (api key put): %w", err)
+	}
+	return nil
+}
+
+// ReadAPIKeyPutCommand reads an API_KEY_PUT command from the connection.
+func ReadAPIKeyPutCommand(r io.Reader) (keyID string, keyJSON []byte, err error) {
+	keyID, err = ReadString(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read key ID (api key put): %w", err)
+	}
+	keyJSON, err = ReadBytes(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read key JSON (api key put): %w", err)
+	}
+	return keyID, keyJSON, nil
+}
+
+// WriteAPIKeyRevokeCommand writes an API_KEY_REVOKE command to the connection.
+// Format: [CmdAPIKeyRevoke (1 byte)] [KeyIDLength (4 bytes)] [KeyID]
+func WriteAPIKeyRevokeCommand(w io.Writer, keyID string) error {
+	if _, err := w.Write([]byte{byte(CmdAPIKeyRevoke)}); err != nil {
+		return fmt.Errorf("failed to write command type (api key revoke): %w", err)
+	}
+	if err := WriteString(w, keyID); err != nil {
+		return fmt.Errorf("failed to write key ID (api key revoke): %w", err)
+	}
+	return nil
+}
+
+// ReadAPIKeyRevokeCommand reads an API_KEY_REVOKE command from the connection.
+func ReadAPIKeyRevokeCommand(r io.Reader) (keyID string, err error) {
+	keyID, err = ReadString(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read key ID (api key revoke): %w", err)
+	}
+	return keyID, nil
+}
+
+// WriteAPIKeyListCommand writes an API_KEY_LIST command to the connection.
+// Format: [CmdAPIKeyList (1 byte)]
+func WriteAPIKeyListCommand(w io.Writer) error {
+	if _, err := w.Write([]byte{byte(CmdAPIKeyList)}); err != nil {
+		return fmt.Errorf("failed to write command type (api key list): %w", err)
+	}
+	return nil
+}
+
+// WriteCollectionQueryCommand writes a QUERY_COLLECTION command to the connection.
+// Format: [CmdCollectionQuery (1 byte)] [CollectionNameLength (4 bytes)] [CollectionName] [QueryJSONLength (4 bytes)] [QueryJSON]
+func WriteCollectionQueryCommand(w io.Writer, collectionName string, queryJSON []byte) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionQuery)}); err != nil {
+		return fmt.Errorf("failed to write command type (collection query): %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name (collection query): %w", err)
+	}
+	if err := WriteBytes(w, queryJSON); err != nil {
+		return fmt.Errorf("failed to write query JSON (collection query): %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionQueryCommand reads a QUERY_COLLECTION command from the connection.
+func ReadCollectionQueryCommand(r io.Reader) (collectionName string, queryJSON []byte, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read collection name (collection query): %w", err)
+	}
+	queryJSON, err = ReadBytes(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read query JSON (collection query): %w", err)
+	}
+	return collectionName, queryJSON, nil
+}
+
+// WriteCollectionItemSetManyCommand writes a SET_COLLECTION_ITEMS_MANY command to the connection.
+// Format: [CmdCollectionItemSetMany (1 byte)] [ColNameLength] [ColName] [ValueLength] [Value_JSON_Array]
+func WriteCollectionItemSetManyCommand(w io.Writer, collectionName string, value []byte) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionItemSetMany)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteBytes(w, value); err != nil {
+		return fmt.Errorf("failed to write value: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionItemSetManyCommand reads a SET_COLLECTION_ITEMS_MANY command from the connection.
+func ReadCollectionItemSetManyCommand(r io.Reader) (collectionName string, value []byte, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read collection name: %w", err)
+	}
+	value, err = ReadBytes(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read value: %w", err)
+	}
+	return collectionName, value, nil
+}
+
+// WriteCollectionItemUpdateManyCommand writes a UPDATE_COLLECTION_ITEMS_MANY command to the connection.
+// Format: [CmdCollectionItemUpdateMany (1 byte)] [ColNameLength] [ColName] [ValueLength] [Value_JSON_Array]
+func WriteCollectionItemUpdateManyCommand(w io.Writer, collectionName string, value []byte) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionItemUpdateMany)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteBytes(w, value); err != nil {
+		return fmt.Errorf("failed to write value: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionItemUpdateManyCommand reads a UPDATE_COLLECTION_ITEMS_MANY command from the connection.
+func ReadCollectionItemUpdateManyCommand(r io.Reader) (collectionName string, value []byte, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read collection name: %w", err)
+	}
+	value, err = ReadBytes(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read value: %w", err)
+	}
+	return collectionName, value, nil
+}
+
+// WriteCollectionItemDeleteManyCommand writes a DELETE_COLLECTION_ITEMS_MANY command to the connection.
+// Format: [CmdCollectionItemDeleteMany (1 byte)] [ColNameLength] [ColName] [KeysArrayLength] [Key1Length] [Key1] [Key2Length] [Key2] ...
+func WriteCollectionItemDeleteManyCommand(w io.Writer, collectionName string, keys []string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionItemDeleteMany)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+
+	if err := binary.Write(w, ByteOrder, uint32(len(keys))); err != nil {
+		return fmt.Errorf("failed to write keys count: %w", err)
+	}
+
+	for _, key := range keys {
+		if err := WriteString(w, key); err != nil {
+			return fmt.Errorf("failed to write key '%s': %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// ReadCollectionItemDeleteManyCommand reads a DELETE_COLLECTION_ITEMS_MANY command from the connection.
+func ReadCollectionItemDeleteManyCommand(r io.Reader) (collectionName string, keys []string, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read collection name: %w", err)
+	}
+
+	var keysCount uint32
+	if err := binary.Read(r, ByteOrder, &keysCount); err != nil {
+		return "", nil, fmt.Errorf("failed to read keys count: %w", err)
+	}
+
+	keys = make([]string, keysCount)
+	for i := 0; i < int(keysCount); i++ {
+		key, err := ReadString(r)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read key %d: %w", i, err)
+		}
+		keys[i] = key
+	}
+
+	return collectionName, keys, nil
+}
+
+// WriteCollectionIndexCreateCommand writes a CREATE_COLLECTION_INDEX command.
+func WriteCollectionIndexCreateCommand(w io.Writer, collectionName, fieldName string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionIndexCreate)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, fieldName); err != nil {
+		return fmt.Errorf("failed to write field name: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionIndexCreateCommand reads a CREATE_COLLECTION_INDEX command.
+func ReadCollectionIndexCreateCommand(r io.Reader) (collectionName, fieldName string, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read collection name: %w", err)
+	}
+	fieldName, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read field name: %w", err)
+	}
+	return collectionName, fieldName, nil
+}
+
+// WriteCollectionIndexCreateWithOptionsCommand writes a CREATE_COLLECTION_INDEX_WITH_OPTIONS command.
+// Format: [Cmd (1 byte)] [ColNameLength] [ColName] [FieldNameLength] [FieldName] [OptionsLength] [OptionsJSON]
+func WriteCollectionIndexCreateWithOptionsCommand(w io.Writer, collectionName, fieldName string, optionsJSON []byte) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionIndexCreateWithOptions)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, fieldName); err != nil {
+		return fmt.Errorf("failed to write field name: %w", err)
+	}
+	if err := WriteBytes(w, optionsJSON); err != nil {
+		return fmt.Errorf("failed to write index options: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionIndexCreateWithOptionsCommand reads a CREATE_COLLECTION_INDEX_WITH_OPTIONS command.
+func ReadCollectionIndexCreateWithOptionsCommand(r io.Reader) (collectionName, fieldName string, optionsJSON []byte, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to read collection name: %w", err)
+	}
+	fieldName, err = ReadString(r)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to read field name: %w", err)
+	}
+	optionsJSON, err = ReadBytes(r)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to read index options: %w", err)
+	}
+	return collectionName, fieldName, optionsJSON, nil
+}
+
+// WriteCollectionIndexDeleteCommand writes a DELETE_COLLECTION_INDEX command.
+func WriteCollectionIndexDeleteCommand(w io.Writer, collectionName, fieldName string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionIndexDelete)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, fieldName); err != nil {
+		return fmt.Errorf("failed to write field name: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionIndexDeleteCommand reads a DELETE_COLLECTION_INDEX command.
+func ReadCollectionIndexDeleteCommand(r io.Reader) (collectionName, fieldName string, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read collection name: %w", err)
+	}
+	fieldName, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read field name: %w", err)
+	}
+	return collectionName, fieldName, nil
+}
+
+// WriteCollectionIndexDisableCommand writes a DISABLE_COLLECTION_INDEX command.
+func WriteCollectionIndexDisableCommand(w io.Writer, collectionName, fieldName string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionIndexDisable)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, fieldName); err != nil {
+		return fmt.Errorf("failed to write field name: %w", err)
+	}
+	return nil
+}
+
+// WriteCollectionIndexEnableCommand writes an ENABLE_COLLECTION_INDEX command.
+func WriteCollectionIndexEnableCommand(w io.Writer, collectionName, fieldName string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionIndexEnable)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, fieldName); err != nil {
+		return fmt.Errorf("failed to write field name: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionIndexStateCommand reads a DISABLE_COLLECTION_INDEX or ENABLE_COLLECTION_INDEX command.
+func ReadCollectionIndexStateCommand(r io.Reader) (collectionName, fieldName string, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read collection name: %w", err)
+	}
+	fieldName, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read field name: %w", err)
+	}
+	return collectionName, fieldName, nil
+}
+
+// WriteCollectionSetCompressionCommand writes a SET_COLLECTION_COMPRESSION command.
+// The flag is sent as a string so the command fits the generic payload layout used by the WAL.
+func WriteCollectionSetCompressionCommand(w io.Writer, collectionName string, enabled bool) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionSetCompression)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, strconv.FormatBool(enabled)); err != nil {
+		return fmt.Errorf("failed to write compression flag: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionSetCompressionCommand reads a SET_COLLECTION_COMPRESSION command.
+func ReadCollectionSetCompressionCommand(r io.Reader) (collectionName string, enabled bool, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read collection name: %w", err)
+	}
+	flag, err := ReadString(r)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read compression flag: %w", err)
+	}
+	enabled, err = strconv.ParseBool(flag)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid compression flag '%s': %w", flag, err)
+	}
+	return collectionName, enabled, nil
+}
+
+// WriteCollectionSetFileCompressionCommand writes a SET_COLLECTION_FILE_COMPRESSION command.
+func WriteCollectionSetFileCompressionCommand(w io.Writer, collectionName, codec string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionSetFileCompression)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, codec); err != nil {
+		return fmt.Errorf("failed to write codec: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionSetFileCompressionCommand reads a SET_COLLECTION_FILE_COMPRESSION command.
+func ReadCollectionSetFileCompressionCommand(r io.Reader) (collectionName, codec string, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read collection name: %w", err)
+	}
+	codec, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read codec: %w", err)
+	}
+	return collectionName, codec, nil
+}
+
+// WriteCollectionRenameCommand writes a RENAME_COLLECTION command.
+func WriteCollectionRenameCommand(w io.Writer, collectionName, newName string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionRename)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, newName); err != nil {
+		return fmt.Errorf("failed to write new collection name: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionRenameCommand reads a RENAME_COLLECTION command.
+func ReadCollectionRenameCommand(r io.Reader) (collectionName, newName string, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read collection name: %w", err)
+	}
+	newName, err = ReadString(r)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read new collection name: %w", err)
+	}
+	return collectionName, newName, nil
+}
+
+// WriteCollectionCopyCommand writes a COPY_COLLECTION command.
+// The force flag is sent as a string, like the flag of SET_COLLECTION_COMPRESSION.
+func WriteCollectionCopyCommand(w io.Writer, collectionName, newName string, force bool) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionCopy)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, newName); err != nil {
+		return fmt.Errorf("failed to write new collection name: %w", err)
+	}
+	if err := WriteString(w, strconv.FormatBool(force)); err != nil {
+		return fmt.Errorf("failed to write force flag: %w", err)
+	}
+	return nil
+}
+
+// ReadCollectionCopyCommand reads a COPY_COLLECTION command.
+func ReadCollectionCopyCommand(r io.Reader) (collectionName, newName string, force bool, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", "", false, fmt.Errorf("failed to read collection name: %w", err)
+	}
+	newName, err = ReadString(r)
+	if err != nil {
+		return "", "", false, fmt.Errorf("failed to read new collection name: %w", err)
+	}
+	flag, err := ReadString(r)
+	if err != nil {
+		return "", "", false, fmt.Errorf("failed to read force flag: %w", err)
+	}
+	force, err = strconv.ParseBool(flag)
+	if err != nil {
+		return "", "", false, fmt.Errorf("invalid force flag '%s': %w", flag, err)
+	}
+	return collectionName, newName, force, nil
+}
+
+// WriteCollectionTruncateCommand writes a TRUNCATE_COLLECTION command.
+func WriteCollectionTruncateCommand(w io.Writer, collectionName string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionTruncate)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	return nil
 }
 
-// ReadCollectionItemGetCommand reads a GET_COLLECTION_ITEM command from the connection.
-func ReadCollectionItemGetCommand(r io.Reader) (collectionName, key string, err error) {
+// ReadCollectionTruncateCommand reads a TRUNCATE_COLLECTION command.
+func ReadCollectionTruncateCommand(r io.Reader) (collectionName string, err error) {
 	collectionName, err = ReadString(r)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to read collection name: %w", err)
-	}
-	key, err = ReadString(r)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to read key: %w", err)
+		return "", fmt.Errorf("failed to read collection name: %w", err)
 	}
-	return collectionName, key, nil
+	return collectionName, nil
 }
 
-// WriteCollectionItemDeleteCommand writes a DELETE_COLLECTION_ITEM command to the connection.
-// Format: [CmdCollectionItemDelete (1 byte)] [ColNameLength] [ColName] [KeyLength] [Key]
-func WriteCollectionItemDeleteCommand(w io.Writer, collectionName, key string) error {
-	if _, err := w.Write([]byte{byte(CmdCollectionItemDelete)}); err != nil {
+// WriteCollectionSetSchemaCommand writes a SET_COLLECTION_SCHEMA command. An empty schema
+// removes the collection's schema.
+func WriteCollectionSetSchemaCommand(w io.Writer, collectionName, schema string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionSetSchema)}); err != nil {
 		return fmt.Errorf("failed to write command type: %w", err)
 	}
 	if err := WriteString(w, collectionName); err != nil {
 		return fmt.Errorf("failed to write collection name: %w", err)
 	}
-	if err := WriteString(w, key); err != nil {
-		return fmt.Errorf("failed to write key: %w", err)
+	if err := WriteString(w, schema); err != nil {
+		return fmt.Errorf("failed to write schema: %w", err)
 	}
 	return nil
 }
 
-// ReadCollectionItemDeleteCommand reads a DELETE_COLLECTION_ITEM command from the connection.
-func ReadCollectionItemDeleteCommand(r io.Reader) (collectionName, key string, err error) {
+// ReadCollectionSetSchemaCommand reads a SET_COLLECTION_SCHEMA command.
+func ReadCollectionSetSchemaCommand(r io.Reader) (collectionName, schema string, err error) {
 	collectionName, err = ReadString(r)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to read collection name: %w", err)
 	}
-	key, err = ReadString(r)
+	schema, err = ReadString(r)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to read key: %w", err)
+		return "", "", fmt.Errorf("failed to read schema: %w", err)
 	}
-	return collectionName, key, nil
+	return collectionName, schema, nil
 }
 
-// WriteCollectionItemListCommand writes a LIST_COLLECTION_ITEMS command to the connection.
-// Format: [CmdCollectionItemList (1 byte)] [ColNameLength] [ColName]
-func WriteCollectionItemListCommand(w io.Writer, collectionName string) error {
-	if _, err := w.Write([]byte{byte(CmdCollectionItemList)}); err != nil {
+// WriteCollectionSetDefaultTTLCommand writes a SET_COLLECTION_DEFAULT_TTL command. A zero ttl
+// removes the collection's default TTL.
+// Format: [CmdCollectionSetDefaultTTL (1 byte)] [ColNameLength] [ColName] [TTLSeconds]
+func WriteCollectionSetDefaultTTLCommand(w io.Writer, collectionName string, ttl time.Duration) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionSetDefaultTTL)}); err != nil {
 		return fmt.Errorf("failed to write command type: %w", err)
 	}
 	if err := WriteString(w, collectionName); err != nil {
 		return fmt.Errorf("failed to write collection name: %w", err)
 	}
+	if err := binary.Write(w, ByteOrder, int64(ttl.Seconds())); err != nil {
+		return fmt.Errorf("failed to write TTL seconds: %w", err)
+	}
 	return nil
 }
 
-// ReadCollectionItemListCommand reads a LIST_COLLECTION_ITEMS command from the connection.
-func ReadCollectionItemListCommand(r io.Reader) (collectionName string, err error) {
+// ReadCollectionSetDefaultTTLCommand reads a SET_COLLECTION_DEFAULT_TTL command.
+func ReadCollectionSetDefaultTTLCommand(r io.Reader) (collectionName string, ttl time.Duration, err error) {
 	collectionName, err = ReadString(r)
 	if err != nil {
-		return "", fmt.Errorf("failed to read collection name: %w", err)
+		return "", 0, fmt.Errorf("failed to read collection name: %w", err)
 	}
-	return collectionName, nil
+	var ttlSeconds int64
+	if err := binary.Read(r, ByteOrder, &ttlSeconds); err != nil {
+		return "", 0, fmt.Errorf("failed to read TTL seconds: %w", err)
+	}
+	return collectionName, time.Duration(ttlSeconds) * time.Second, nil
 }
 
-// WriteAuthenticateCommand writes an AUTH command to the connection.
-// Format: [CmdAuthenticate (1 byte)] [UsernameLength (4 bytes)] [Username] [PasswordLength (4 bytes)] [Password]
-func WriteAuthenticateCommand(w io.Writer, username, password string) error {
-	if _, err := w.Write([]byte{byte(CmdAuthenticate)}); err != nil {
-		return fmt.Errorf("failed to write command type (authenticate): %w", err)
-	}
-	if err := WriteString(w, username); err != nil {
-		return fmt.Errorf("failed to write username (authenticate): %w", err)
+// WriteSlowQueryListCommand writes a SLOW_QUERY_LIST command. A zero limit lists every entry.
+// Format: [CmdSlowQueryList (1 byte)] [Limit (uint32)]
+func WriteSlowQueryListCommand(w io.Writer, limit uint32) error {
+	if _, err := w.Write([]byte{byte(CmdSlowQueryList)}); err != nil {
+		return fmt.Errorf("failed to write command type (slow query list): %w", err)
 	}
-	if err := WriteString(w, password); err != nil {
-		return fmt.Errorf("failed to write password (authenticate): %w", err)
+	if err := binary.Write(w, ByteOrder, limit); err != nil {
+		return fmt.Errorf("failed to write limit (slow query list): %w", err)
 	}
 	return nil
 }
 
-// ReadAuthenticateCommand reads an AUTH command from the connection.
-func ReadAuthenticateCommand(r io.Reader) (username, password string, err error) {
-	username, err = ReadString(r)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to read username (authenticate): %w", err)
-	}
-	password, err = ReadString(r)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to read password (authenticate): %w", err)
+// ReadSlowQueryListCommand reads a SLOW_QUERY_LIST command.
+func ReadSlowQueryListCommand(r io.Reader) (limit uint32, err error) {
+	if err = binary.Read(r, ByteOrder, &limit); err != nil {
+		return 0, fmt.Errorf("failed to read limit (slow query list): %w", err)
 	}
-	return username, password, nil
+	return limit, nil
 }
 
-// WriteChangeUserPasswordCommand writes a CHANGE_USER_PASSWORD command to the connection.
-// Format: [CmdChangeUserPassword (1 byte)] [TargetUsernameLength (4 bytes)] [TargetUsername] [NewPasswordLength (4 bytes)] [NewPassword]
-func WriteChangeUserPasswordCommand(w io.Writer, targetUsername, newPassword string) error {
-	if _, err := w.Write([]byte{byte(CmdChangeUserPassword)}); err != nil {
-		return fmt.Errorf("failed to write command type (change user password): %w", err)
+// WriteSubscribeCommand writes a SUBSCRIBE command. An empty keyPrefix subscribes to every key.
+// Format: [CmdSubscribe (1 byte)] [ColNameLength] [ColName] [PrefixLength] [Prefix] [WithValuesLength] [WithValues]
+func WriteSubscribeCommand(w io.Writer, collectionName, keyPrefix string, withValues bool) error {
+	if _, err := w.Write([]byte{byte(CmdSubscribe)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
 	}
-	if err := WriteString(w, targetUsername); err != nil {
-		return fmt.Errorf("failed to write target username (change user password): %w", err)
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
 	}
-	if err := WriteString(w, newPassword); err != nil {
-		return fmt.Errorf("failed to write new password (change user password): %w", err)
+	if err := WriteString(w, keyPrefix); err != nil {
+		return fmt.Errorf("failed to write key prefix: %w", err)
+	}
+	if err := WriteString(w, strconv.FormatBool(withValues)); err != nil {
+		return fmt.Errorf("failed to write with-values flag: %w", err)
 	}
 	return nil
 }
 
-// ReadChangeUserPasswordCommand reads a CHANGE_USER_PASSWORD command from the connection.
-func ReadChangeUserPasswordCommand(r io.Reader) (targetUsername, newPassword string, err error) {
-	targetUsername, err = ReadString(r)
+// ReadSubscribeCommand reads a SUBSCRIBE command.
+func ReadSubscribeCommand(r io.Reader) (collectionName, keyPrefix string, withValues bool, err error) {
+	collectionName, err = ReadString(r)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to read target username (change user password): %w", err)
+		return "", "", false, fmt.Errorf("failed to read collection name: %w", err)
 	}
-	newPassword, err = ReadString(r)
+	keyPrefix, err = ReadString(r)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to read new password (change user password): %w", err)
+		return "", "", false, fmt.Errorf("failed to read key prefix: %w", err)
 	}
-	return targetUsername, newPassword, nil
+	flag, err := ReadString(r)
+	if err != nil {
+		return "", "", false, fmt.Errorf("failed to read with-values flag: %w", err)
+	}
+	withValues, err = strconv.ParseBool(flag)
+	if err != nil {
+		return "", "", false, fmt.Errorf("invalid with-values flag '%s': %w", flag, err)
+	}
+	return collectionName, keyPrefix, withValues, nil
 }
 
-// WriteCollectionQueryCommand writes a QUERY_COLLECTION command to the connection.
-// Format: [CmdCollectionQuery (1 byte)] [CollectionNameLength (4 bytes)] [CollectionName] [QueryJSONLength (4 bytes)] [QueryJSON]
-func WriteCollectionQueryCommand(w io.Writer, collectionName string, queryJSON []byte) error {
-	if _, err := w.Write([]byte{byte(CmdCollectionQuery)}); err != nil {
-		return fmt.Errorf("failed to write command type (collection query): %w", err)
+// WriteChangeFeedCommand writes a CHANGE_FEED command. An empty collectionName follows every
+// collection; an empty fromOffset resumes after the consumer's acknowledged offset.
+// Format: [CmdChangeFeed (1 byte)] [ConsumerLength] [Consumer] [ColNameLength] [ColName] [FromLength] [From]
+func WriteChangeFeedCommand(w io.Writer, consumerName, collectionName, fromOffset string) error {
+	if _, err := w.Write([]byte{byte(CmdChangeFeed)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
+	}
+	if err := WriteString(w, consumerName); err != nil {
+		return fmt.Errorf("failed to write consumer name: %w", err)
 	}
 	if err := WriteString(w, collectionName); err != nil {
-		return fmt.Errorf("failed to write collection name (collection query): %w", err)
+		return fmt.Errorf("failed to write collection name: %w", err)
 	}
-	if err := WriteBytes(w, queryJSON); err != nil {
-		return fmt.Errorf("failed to write query JSON (collection query): %w", err)
+	if err := WriteString(w, fromOffset); err != nil {
+		return fmt.Errorf("failed to write start offset: %w", err)
 	}
 	return nil
 }
 
-// ReadCollectionQueryCommand reads a QUERY_COLLECTION command from the connection.
-func ReadCollectionQueryCommand(r io.Reader) (collectionName string, queryJSON []byte, err error) {
+// ReadChangeFeedCommand reads a CHANGE_FEED command.
+func ReadChangeFeedCommand(r io.Reader) (consumerName, collectionName, fromOffset string, err error) {
+	consumerName, err = ReadString(r)
+	if err != nil {
+		return "", "", "", fmt.Errorf("failed to read consumer name: %w", err)
+	}
 	collectionName, err = ReadString(r)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to read collection name (collection query): %w", err)
+		return "", "", "", fmt.Errorf("failed to read collection name: %w", err)
 	}
-	queryJSON, err = ReadBytes(r)
+	fromOffset, err = ReadString(r)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to read query JSON (collection query): %w", err)
+		return "", "", "", fmt.Errorf("failed to read start offset: %w", err)
 	}
-	return collectionName, queryJSON, nil
+	return consumerName, collectionName, fromOffset, nil
 }
 
-// WriteCollectionItemSetManyCommand writes a SET_COLLECTION_ITEMS_MANY command to the connection.
-// Format: [CmdCollectionItemSetMany (1 byte)] [ColNameLength] [ColName] [ValueLength] [Value_JSON_Array]
-func WriteCollectionItemSetManyCommand(w io.Writer, collectionName string, value []byte) error {
-	if _, err := w.Write([]byte{byte(CmdCollectionItemSetMany)}); err != nil {
+// WriteChangeFeedAckCommand writes a CHANGE_FEED_ACK command.
+// Format: [CmdChangeFeedAck (1 byte)] [ConsumerLength] [Consumer] [OffsetLength] [Offset]
+func WriteChangeFeedAckCommand(w io.Writer, consumerName, offset string) error {
+	if _, err := w.Write([]byte{byte(CmdChangeFeedAck)}); err != nil {
 		return fmt.Errorf("failed to write command type: %w", err)
 	}
-	if err := WriteString(w, collectionName); err != nil {
-		return fmt.Errorf("failed to write collection name: %w", err)
+	if err := WriteString(w, consumerName); err != nil {
+		return fmt.Errorf("failed to write consumer name: %w", err)
 	}
-	if err := WriteBytes(w, value); err != nil {
-		return fmt.Errorf("failed to write value: %w", err)
+	if err := WriteString(w, offset); err != nil {
+		return fmt.Errorf("failed to write offset: %w", err)
 	}
 	return nil
 }
 
-// ReadCollectionItemSetManyCommand reads a SET_COLLECTION_ITEMS_MANY command from the connection.
-func ReadCollectionItemSetManyCommand(r io.Reader) (collectionName string, value []byte, err error) {
-	collectionName, err = ReadString(r)
+// ReadChangeFeedAckCommand reads a CHANGE_FEED_ACK command.
+func ReadChangeFeedAckCommand(r io.Reader) (consumerName, offset string, err error) {
+	consumerName, err = ReadString(r)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to read collection name: %w", err)
+		return "", "", fmt.Errorf("failed to read consumer name: %w", err)
 	}
-	value, err = ReadBytes(r)
+	offset, err = ReadString(r)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to read value: %w", err)
+		return "", "", fmt.Errorf("failed to read offset: %w", err)
 	}
-	return collectionName, value, nil
+	return consumerName, offset, nil
 }
 
-// WriteCollectionItemUpdateManyCommand writes a UPDATE_COLLECTION_ITEMS_MANY command to the connection.
-// Format: [CmdCollectionItemUpdateMany (1 byte)] [ColNameLength] [ColName] [ValueLength] [Value_JSON_Array]
-func WriteCollectionItemUpdateManyCommand(w io.Writer, collectionName string, value []byte) error {
-	if _, err := w.Write([]byte{byte(CmdCollectionItemUpdateMany)}); err != nil {
+// WriteProcedurePutCommand writes a PROCEDURE_PUT command, which creates or replaces a procedure.
+// Format: [CmdProcedurePut (1 byte)] [NameLength] [Name] [DefinitionLength] [Definition]
+func WriteProcedurePutCommand(w io.Writer, name string, definitionJSON []byte) error {
+	if _, err := w.Write([]byte{byte(CmdProcedurePut)}); err != nil {
 		return fmt.Errorf("failed to write command type: %w", err)
 	}
-	if err := WriteString(w, collectionName); err != nil {
-		return fmt.Errorf("failed to write collection name: %w", err)
+	if err := WriteString(w, name); err != nil {
+		return fmt.Errorf("failed to write procedure name: %w", err)
 	}
-	if err := WriteBytes(w, value); err != nil {
-		return fmt.Errorf("failed to write value: %w", err)
+	if err := WriteBytes(w, definitionJSON); err != nil {
+		return fmt.Errorf("failed to write procedure definition: %w", err)
 	}
 	return nil
 }
 
-// ReadCollectionItemUpdateManyCommand reads a UPDATE_COLLECTION_ITEMS_MANY command from the connection.
-func ReadCollectionItemUpdateManyCommand(r io.Reader) (collectionName string, value []byte, err error) {
-	collectionName, err = ReadString(r)
+// ReadProcedurePutCommand reads a PROCEDURE_PUT command.
+func ReadProcedurePutCommand(r io.Reader) (name string, definitionJSON []byte, err error) {
+	name, err = ReadString(r)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to read collection name: %w", err)
+		return "", nil, fmt.Errorf("failed to read procedure name: %w", err)
 	}
-	value, err = ReadBytes(r)
+	definitionJSON, err = ReadBytes(r)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to read value: %w", err)
+		return "", nil, fmt.Errorf("failed to read procedure definition: %w", err)
 	}
-	return collectionName, value, nil
+	return name, definitionJSON, nil
 }
 
-// WriteCollectionItemDeleteManyCommand writes a DELETE_COLLECTION_ITEMS_MANY command to the connection.
-// Format: [CmdCollectionItemDeleteMany (1 byte)] [ColNameLength] [ColName] [KeysArrayLength] [Key1Length] [Key1] [Key2Length] [Key2] ...
-func WriteCollectionItemDeleteManyCommand(w io.Writer, collectionName string, keys []string) error {
-	if _, err := w.Write([]byte{byte(CmdCollectionItemDeleteMany)}); err != nil {
+// WriteProcedureDeleteCommand writes a PROCEDURE_DELETE command.
+// Format: [CmdProcedureDelete (1 byte)] [NameLength] [Name]
+func WriteProcedureDeleteCommand(w io.Writer, name string) error {
+	if _, err := w.Write([]byte{byte(CmdProcedureDelete)}); err != nil {
 		return fmt.Errorf("failed to write command type: %w", err)
 	}
-	if err := WriteString(w, collectionName); err != nil {
-		return fmt.Errorf("failed to write collection name: %w", err)
+	if err := WriteString(w, name); err != nil {
+		return fmt.Errorf("failed to write procedure name: %w", err)
 	}
+	return nil
+}
 
-	if err := binary.Write(w, ByteOrder, uint32(len(keys))); err != nil {
-		return fmt.Errorf("failed to write keys count: %w", err)
+// ReadProcedureDeleteCommand reads a PROCEDURE_DELETE command.
+func ReadProcedureDeleteCommand(r io.Reader) (name string, err error) {
+	name, err = ReadString(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read procedure name: %w", err)
 	}
+	return name, nil
+}
 
-	for _, key := range keys {
-		if err := WriteString(w, key); err != nil {
-			return fmt.Errorf("failed to write key '%s': %w", key, err)
-		}
+// WriteProcedureListCommand writes a PROCEDURE_LIST command.
+// Format: [CmdProcedureList (1 byte)]
+func WriteProcedureListCommand(w io.Writer) error {
+	if _, err := w.Write([]byte{byte(CmdProcedureList)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
 	}
-
 	return nil
 }
 
-// ReadCollectionItemDeleteManyCommand reads a DELETE_COLLECTION_ITEMS_MANY command from the connection.
-func ReadCollectionItemDeleteManyCommand(r io.Reader) (collectionName string, keys []string, err error) {
-	collectionName, err = ReadString(r)
-	if err != nil {
-		return "", nil, fmt.Errorf("failed to read collection name: %w", err)
+// WriteCallProcedureCommand writes a CALL_PROCEDURE command. paramsJSON is an object giving a value
+// to each parameter of the procedure; it may be empty for a procedure without parameters.
+// Format: [CmdCallProcedure (1 byte)] [NameLength] [Name] [ParamsLength] [Params]
+func WriteCallProcedureCommand(w io.Writer, name string, paramsJSON []byte) error {
+	if _, err := w.Write([]byte{byte(CmdCallProcedure)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
 	}
-
-	var keysCount uint32
-	if err := binary.Read(r, ByteOrder, &keysCount); err != nil {
-		return "", nil, fmt.Errorf("failed to read keys count: %w", err)
+	if err := WriteString(w, name); err != nil {
+		return fmt.Errorf("failed to write procedure name: %w", err)
 	}
-
-	keys = make([]string, keysCount)
-	for i := 0; i < int(keysCount); i++ {
-		key, err := ReadString(r)
-		if err != nil {
-			return "", nil, fmt.Errorf("failed to read key %d: %w", i, err)
-		}
-		keys[i] = key
+	if err := WriteBytes(w, paramsJSON); err != nil {
+		return fmt.Errorf("failed to write procedure parameters: %w", err)
 	}
+	return nil
+}
 
-	return collectionName, keys, nil
+// ReadCallProcedureCommand reads a CALL_PROCEDURE command.
+func ReadCallProcedureCommand(r io.Reader) (name string, paramsJSON []byte, err error) {
+	name, err = ReadString(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read procedure name: %w", err)
+	}
+	paramsJSON, err = ReadBytes(r)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read procedure parameters: %w", err)
+	}
+	return name, paramsJSON, nil
 }
 
-// WriteCollectionIndexCreateCommand writes a CREATE_COLLECTION_INDEX command.
-func WriteCollectionIndexCreateCommand(w io.Writer, collectionName, fieldName string) error {
-	if _, err := w.Write([]byte{byte(CmdCollectionIndexCreate)}); err != nil {
+// WriteCollectionIndexListCommand writes a LIST_COLLECTION_INDEXES command.
+func WriteCollectionIndexListCommand(w io.Writer, collectionName string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionIndexList)}); err != nil {
 		return fmt.Errorf("failed to write command type: %w", err)
 	}
 	if err := WriteString(w, collectionName); err != nil {
 		return fmt.Errorf("failed to write collection name: %w", err)
 	}
-	if err := WriteString(w, fieldName); err != nil {
-		return fmt.Errorf("failed to write field name: %w", err)
-	}
 	return nil
 }
 
-// ReadCollectionIndexCreateCommand reads a CREATE_COLLECTION_INDEX command.
-func ReadCollectionIndexCreateCommand(r io.Reader) (collectionName, fieldName string, err error) {
+// ReadCollectionIndexListCommand reads a LIST_COLLECTION_INDEXES command.
+func ReadCollectionIndexListCommand(r io.Reader) (collectionName string, err error) {
 	collectionName, err = ReadString(r)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to read collection name: %w", err)
-	}
-	fieldName, err = ReadString(r)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to read field name: %w", err)
+		return "", fmt.Errorf("failed to read collection name: %w", err)
 	}
-	return collectionName, fieldName, nil
+	return collectionName, nil
 }
 
-// WriteCollectionIndexDeleteCommand writes a DELETE_COLLECTION_INDEX command.
-func WriteCollectionIndexDeleteCommand(w io.Writer, collectionName, fieldName string) error {
-	if _, err := w.Write([]byte{byte(CmdCollectionIndexDelete)}); err != nil {
+// WriteCollectionIndexAuditCommand writes an AUDIT_COLLECTION_INDEXES command.
+func WriteCollectionIndexAuditCommand(w io.Writer, collectionName string) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionIndexAudit)}); err != nil {
 		return fmt.Errorf("failed to write command type: %w", err)
 	}
 	if err := WriteString(w, collectionName); err != nil {
 		return fmt.Errorf("failed to write collection name: %w", err)
 	}
-	if err := WriteString(w, fieldName); err != nil {
-		return fmt.Errorf("failed to write field name: %w", err)
-	}
 	return nil
 }
 
-// ReadCollectionIndexDeleteCommand reads a DELETE_COLLECTION_INDEX command.
-func ReadCollectionIndexDeleteCommand(r io.Reader) (collectionName, fieldName string, err error) {
+// ReadCollectionIndexAuditCommand reads an AUDIT_COLLECTION_INDEXES command.
+func ReadCollectionIndexAuditCommand(r io.Reader) (collectionName string, err error) {
 	collectionName, err = ReadString(r)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to read collection name: %w", err)
+		return "", fmt.Errorf("failed to read collection name: %w", err)
 	}
-	fieldName, err = ReadString(r)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to read field name: %w", err)
+	return collectionName, nil
+}
+
+// WriteCollectionItemDiffCommand writes a DIFF_COLLECTION_ITEMS command to the connection.
+// If keyB is empty, the document for keyA is compared against the provided document instead.
+// Format: [CmdCollectionItemDiff (1 byte)] [ColNameLength] [ColName] [KeyALength] [KeyA] [KeyBLength] [KeyB] [DocumentLength] [Document]
+func WriteCollectionItemDiffCommand(w io.Writer, collectionName, keyA, keyB string, document []byte) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionItemDiff)}); err != nil {
+		return fmt.Errorf("failed to write command type: %w", err)
 	}
-	return collectionName, fieldName, nil
+	if err := WriteString(w, collectionName); err != nil {
+		return fmt.Errorf("failed to write collection name: %w", err)
+	}
+	if err := WriteString(w, keyA); err != nil {
+		return fmt.Errorf("failed to write first key: %w", err)
+	}
+	if err := WriteString(w, keyB); err != nil {
+		return fmt.Errorf("failed to write second key: %w", err)
+	}
+	if err := WriteBytes(w, document); err != nil {
+		return fmt.Errorf("failed to write document: %w", err)
+	}
+	return nil
 }
 
-// WriteCollectionIndexListCommand writes a LIST_COLLECTION_INDEXES command.
-func WriteCollectionIndexListCommand(w io.Writer, collectionName string) error {
-	if _, err := w.Write([]byte{byte(CmdCollectionIndexList)}); err != nil {
+// WriteCollectionTopLargestCommand writes a TOP_LARGEST_COLLECTION_ITEMS command to the connection.
+// Format: [CmdCollectionTopLargest (1 byte)] [ColNameLength] [ColName] [Count (4 bytes)]
+func WriteCollectionTopLargestCommand(w io.Writer, collectionName string, count uint32) error {
+	if _, err := w.Write([]byte{byte(CmdCollectionTopLargest)}); err != nil {
 		return fmt.Errorf("failed to write command type: %w", err)
 	}
 	if err := WriteString(w, collectionName); err != nil {
 		return fmt.Errorf("failed to write collection name: %w", err)
 	}
+	if err := binary.Write(w, ByteOrder, count); err != nil {
+		return fmt.Errorf("failed to write count: %w", err)
+	}
 	return nil
 }
 
-// ReadCollectionIndexListCommand reads a LIST_COLLECTION_INDEXES command.
-func ReadCollectionIndexListCommand(r io.Reader) (collectionName string, err error) {
+// ReadCollectionTopLargestCommand reads a TOP_LARGEST_COLLECTION_ITEMS command from the connection.
+func ReadCollectionTopLargestCommand(r io.Reader) (collectionName string, count uint32, err error) {
 	collectionName, err = ReadString(r)
 	if err != nil {
-		return "", fmt.Errorf("failed to read collection name: %w", err)
+		return "", 0, fmt.Errorf("failed to read collection name: %w", err)
 	}
-	return collectionName, nil
+	if err = binary.Read(r, ByteOrder, &count); err != nil {
+		return "", 0, fmt.Errorf("failed to read count: %w", err)
+	}
+	return collectionName, count, nil
+}
+
+// ReadCollectionItemDiffCommand reads a DIFF_COLLECTION_ITEMS command from the connection.
+func ReadCollectionItemDiffCommand(r io.Reader) (collectionName, keyA, keyB string, document []byte, err error) {
+	collectionName, err = ReadString(r)
+	if err != nil {
+		return "", "", "", nil, fmt.Errorf("failed to read collection name: %w", err)
+	}
+	keyA, err = ReadString(r)
+	if err != nil {
+		return "", "", "", nil, fmt.Errorf("failed to read first key: %w", err)
+	}
+	keyB, err = ReadString(r)
+	if err != nil {
+		return "", "", "", nil, fmt.Errorf("failed to read second key: %w", err)
+	}
+	document, err = ReadBytes(r)
+	if err != nil {
+		return "", "", "", nil, fmt.Errorf("failed to read document: %w", err)
+	}
+	return collectionName, keyA, keyB, document, nil
 }
 
 // ReadCommandPayload reads the payload for a given command type.
 func ReadCommandPayload(r io.Reader, cmdType CommandType) ([]byte, error) {
 	var buf bytes.Buffer
-	structure := map[CommandType]struct {
-		numStr, numBytes int
-		hasTTL, hasKeys  bool
-	}{
-		CmdSet:                      {1, 1, true, false},
-		CmdGet:                      {1, 0, false, false},
-		CmdCollectionCreate:         {1, 0, false, false},
-		CmdCollectionDelete:         {1, 0, false, false},
-		CmdCollectionList:           {0, 0, false, false},
-		CmdCollectionIndexCreate:    {2, 0, false, false},
-		CmdCollectionIndexDelete:    {2, 0, false, false},
-		CmdCollectionIndexList:      {1, 0, false, false},
-		CmdCollectionItemSet:        {2, 1, true, false},
-		CmdCollectionItemSetMany:    {1, 1, false, false},
-		CmdCollectionItemGet:        {2, 0, false, false},
-		CmdCollectionItemDelete:     {2, 0, false, false},
-		CmdCollectionItemList:       {1, 0, false, false},
-		CmdCollectionQuery:          {1, 1, false, false},
-		CmdCollectionItemDeleteMany: {1, 0, false, true},
-		CmdCollectionItemUpdate:     {2, 1, false, false},
-		CmdCollectionItemUpdateMany: {1, 1, false, false},
-		CmdAuthenticate:             {2, 0, false, false},
-		CmdChangeUserPassword:       {2, 0, false, false},
-		CmdUserCreate:               {2, 1, false, false},
-		CmdUserUpdate:               {1, 1, false, false},
-		CmdUserDelete:               {1, 0, false, false},
-		CmdBackup:                   {0, 0, false, false},
-		CmdRestore:                  {1, 0, false, false},
-		CmdBegin:                    {0, 0, false, false},
-		CmdCommit:                   {0, 0, false, false},
-		CmdRollback:                 {0, 0, false, false},
-	}
-
-	spec, ok := structure[cmdType]
+	if err := ReadCommandPayloadInto(r, cmdType, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// payloadStructures describes the fixed payload layout of every command whose payload can be
+// read without decoding it: the number of length-prefixed strings and byte fields, followed
+// by an optional TTL and an optional list of keys.
+var payloadStructures = map[CommandType]struct {
+	numStr, numBytes int
+	hasTTL, hasKeys  bool
+}{
+	CmdSet:                              {1, 1, true, false},
+	CmdGet:                              {1, 0, false, false},
+	CmdCollectionCreate:                 {1, 0, false, false},
+	CmdCollectionDelete:                 {1, 0, false, false},
+	CmdCollectionList:                   {1, 0, false, false},
+	CmdCollectionIndexCreate:            {2, 0, false, false},
+	CmdCollectionIndexDelete:            {2, 0, false, false},
+	CmdCollectionIndexList:              {1, 0, false, false},
+	CmdCollectionItemSet:                {2, 1, true, false},
+	CmdCollectionItemSetMany:            {1, 1, false, false},
+	CmdCollectionItemGet:                {2, 0, false, true},
+	CmdCollectionItemDelete:             {2, 0, false, false},
+	CmdCollectionItemList:               {1, 0, false, false},
+	CmdCollectionQuery:                  {1, 1, false, false},
+	CmdCollectionItemDeleteMany:         {1, 0, false, true},
+	CmdCollectionItemUpdate:             {3, 1, false, false},
+	CmdCollectionItemUpdateMany:         {1, 1, false, false},
+	CmdAuthenticate:                     {2, 0, false, false},
+	CmdChangeUserPassword:               {2, 0, false, false},
+	CmdUserCreate:                       {2, 1, false, false},
+	CmdUserUpdate:                       {1, 1, false, false},
+	CmdUserDelete:                       {1, 0, false, false},
+	CmdBackup:                           {0, 0, false, false},
+	CmdRestore:                          {1, 0, false, false},
+	CmdBegin:                            {0, 0, false, false},
+	CmdCommit:                           {0, 0, false, false},
+	CmdRollback:                         {0, 0, false, false},
+	CmdTransactionStatus:                {0, 0, false, false},
+	CmdCollectionReload:                 {1, 0, false, false},
+	CmdCollectionItemDiff:               {3, 1, false, false},
+	CmdCollectionItemUpsert:             {2, 1, false, false},
+	CmdCollectionIndexDisable:           {2, 0, false, false},
+	CmdCollectionIndexEnable:            {2, 0, false, false},
+	CmdCollectionItemUpdateIf:           {2, 2, false, false},
+	CmdCollectionSetCompression:         {2, 0, false, false},
+	CmdCollectionItemReplace:            {3, 1, false, false},
+	CmdCollectionItemGetAndDelete:       {2, 0, false, false},
+	CmdCompactAll:                       {0, 0, false, false},
+	CmdCompactStatus:                    {1, 0, false, false},
+	CmdCollectionStats:                  {1, 0, false, false},
+	CmdCollectionItemMergeByQuery:       {1, 2, false, false},
+	CmdPing:                             {0, 0, false, false},
+	CmdExportUsers:                      {0, 0, false, false},
+	CmdImportUsers:                      {1, 1, false, false},
+	CmdCollectionItemExists:             {2, 0, false, false},
+	CmdCollectionIndexAudit:             {1, 0, false, false},
+	CmdCollectionItemIncrement:          {4, 0, false, false},
+	CmdCollectionIndexCreateWithOptions: {2, 1, false, false},
+	CmdMemoryStats:                      {0, 0, false, false},
+	CmdStats:                            {0, 0, false, false},
+	CmdCollectionSetFileCompression:     {2, 0, false, false},
+	CmdRestoreCollection:                {2, 0, false, false},
+	CmdCollectionExport:                 {2, 0, false, true},
+	CmdCollectionItemTTL:                {2, 0, false, false},
+	CmdCollectionItemTouch:              {2, 0, true, false},
+	CmdCollectionKeyScan:                {5, 0, false, false},
+	CmdCollectionItemGetMany:            {1, 0, false, true},
+	CmdAuthenticateToken:                {1, 0, false, false},
+	CmdAPIKeyCreate:                     {2, 1, false, false},
+	CmdAPIKeyPut:                        {1, 1, false, false},
+	CmdAPIKeyRevoke:                     {1, 0, false, false},
+	CmdAPIKeyList:                       {0, 0, false, false},
+	CmdRoleCreate:                       {1, 1, false, false},
+	CmdRoleUpdate:                       {1, 1, false, false},
+	CmdRoleDelete:                       {1, 0, false, false},
+	CmdUserSetRoles:                     {1, 0, false, true},
+	CmdCollectionTopLargest:             {1, 0, false, false},
+	CmdBackupList:                       {0, 0, false, false},
+	CmdBackupDelete:                     {1, 0, false, false},
+	CmdRestoreValidate:                  {1, 0, false, false},
+	CmdCollectionRename:                 {2, 0, false, false},
+	CmdCollectionCopy:                   {3, 0, false, false},
+	CmdCollectionTruncate:               {1, 0, false, false},
+	CmdCollectionSetSchema:              {2, 0, false, false},
+	CmdCollectionSetDefaultTTL:          {1, 0, true, false},
+	CmdSlowQueryList:                    {0, 0, false, false},
+	CmdSubscribe:                        {3, 0, false, false},
+	CmdChangeFeed:                       {3, 0, false, false},
+	CmdChangeFeedAck:                    {2, 0, false, false},
+	CmdProcedurePut:                     {1, 1, false, false},
+	CmdProcedureDelete:                  {1, 0, false, false},
+	CmdProcedureList:                    {0, 0, false, false},
+	CmdCallProcedure:                    {1, 1, false, false},
+}
+
+// payloadUint32Fields counts the fixed uint32 fields that follow the length-prefixed fields of
+// the commands that have them, such as the limit and offset of COLLECTION_LIST.
+var payloadUint32Fields = map[CommandType]int{
+	CmdCollectionList:       2,
+	CmdCollectionTopLargest: 1,
+	CmdSlowQueryList:        1,
+}
+
+// HasFixedPayload reports whether ReadCommandPayloadInto can read the payload of a command.
+// Streamed commands, such as an import, have no fixed layout.
+func HasFixedPayload(cmdType CommandType) bool {
+	_, ok := payloadStructures[cmdType]
+	return ok
+}
+
+// ReadCommandPayloadInto reads the payload for a given command type into buf.
+// Length-prefixed fields are copied straight from the reader, so no intermediate
+// slices are allocated; buf is typically obtained from AcquirePayloadBuffer.
+func ReadCommandPayloadInto(r io.Reader, cmdType CommandType, buf *bytes.Buffer) error {
+	spec, ok := payloadStructures[cmdType]
 	if !ok {
-		return nil, fmt.Errorf("unknown command type for payload reading: %d", cmdType)
+		return fmt.Errorf("unknown command type for payload reading: %d", cmdType)
 	}
 
-	for i := 0; i < spec.numStr; i++ {
-		s, err := ReadString(r)
-		if err != nil {
-			return nil, err
+	for i := 0; i < spec.numStr+spec.numBytes; i++ {
+		if err := copyLengthPrefixed(r, buf); err != nil {
+			return err
 		}
-		WriteString(&buf, s)
 	}
 
-	for i := 0; i < spec.numBytes; i++ {
-		b, err := ReadBytes(r)
-		if err != nil {
-			return nil, err
+	if n := payloadUint32Fields[cmdType]; n > 0 {
+		if _, err := io.CopyN(buf, r, int64(n)*4); err != nil {
+			return fmt.Errorf("failed to read fixed fields: %w", err)
 		}
-		WriteBytes(&buf, b)
 	}
 
 	if spec.hasTTL {
 		var ttlSeconds int64
 		if err := binary.Read(r, ByteOrder, &ttlSeconds); err != nil {
-			return nil, err
+			return err
 		}
-		binary.Write(&buf, ByteOrder, ttlSeconds)
+		binary.Write(buf, ByteOrder, ttlSeconds)
 	}
 
 	if spec.hasKeys {
 		var keysCount uint32
 		if err := binary.Read(r, ByteOrder, &keysCount); err != nil {
-			return nil, err
+			return err
 		}
-		binary.Write(&buf, ByteOrder, keysCount)
+		binary.Write(buf, ByteOrder, keysCount)
 		for i := 0; i < int(keysCount); i++ {
-			key, err := ReadString(r)
-			if err != nil {
-				return nil, err
+			if err := copyLengthPrefixed(r, buf); err != nil {
+				return err
 			}
-			WriteString(&buf, key)
 		}
 	}
 
-	return buf.Bytes(), nil
+	return nil
+}
+
+// copyLengthPrefixed copies one length-prefixed field from r to buf unchanged.
+func copyLengthPrefixed(r io.Reader, buf *bytes.Buffer) error {
+	var fieldLen uint32
+	if err := binary.Read(r, ByteOrder, &fieldLen); err != nil {
+		return fmt.Errorf("failed to read field length: %w", err)
+	}
+	binary.Write(buf, ByteOrder, fieldLen)
+	if _, err := io.CopyN(buf, r, int64(fieldLen)); err != nil {
+		return fmt.Errorf("failed to read field bytes: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,71 @@
+package store
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// CopyCollection creates newName as a copy of a collection: its items, including those held only
+// on disk, its indexes and its settings. The copy replaces newName in one step once it is
+// complete, so newName never holds a partial copy. An existing newName that holds items is only
+// replaced if force is set. The caller is expected to enqueue a save of the returned store.
+func (cm *CollectionManager) CopyCollection(name, newName string, force bool) (DataStore, error) {
+	if name == newName {
+		return nil, fmt.Errorf("%w: '%s'", ErrCollectionExists, newName)
+	}
+	// Locking in name order keeps two opposite copies from deadlocking.
+	first, second := cm.GetFileLock(name), cm.GetFileLock(newName)
+	if newName < name {
+		first, second = second, first
+	}
+	first.Lock()
+	defer first.Unlock()
+	second.Lock()
+	defer second.Unlock()
+
+	cm.mu.RLock()
+	src, found := cm.collections[name]
+	dst, taken := cm.collections[newName]
+	cm.mu.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("%w: '%s'", ErrCollectionNotFound, name)
+	}
+	if taken && !force && (dst.Size() > 0 || len(dst.ColdKeys()) > 0) {
+		return nil, fmt.Errorf("%w: '%s'", ErrCollectionExists, newName)
+	}
+
+	newCol := cm.newCollectionStore()
+	newCol.SetCompression(src.IsCompressionEnabled())
+	newCol.SetFileCodec(src.FileCodec())
+	newCol.LoadData(src.GetAll())
+	copyIndexDefinitions(newCol, src)
+	coldKeys := src.ColdKeys()
+	newCol.MarkCold(coldKeys...)
+	// The items held only on disk stay in the copied file, which the copy's saves carry over.
+	if err := cm.persister.CopyCollectionFile(name, newName); err != nil {
+		return nil, err
+	}
+
+	cm.mu.Lock()
+	cm.collections[newName] = newCol
+	cm.mu.Unlock()
+
+	if err := cm.SaveCollectionMeta(newName, cm.GetCollectionMeta(name)); err != nil {
+		slog.Warn("Failed to copy collection settings", "collection", newName, "error", err)
+	}
+	slog.Info("Collection copied", "source", name, "destination", newName, "items", newCol.Size(), "cold_items", len(coldKeys))
+	return newCol, nil
+}
+
+// copyIndexDefinitions creates on dst the indexes of src, with their options, and disables those
+// disabled on src. Indexes are built from the items dst holds at the time.
+func copyIndexDefinitions(dst, src DataStore) {
+	for _, fieldName := range src.ListIndexes() {
+		options, _ := src.GetIndexOptions(fieldName)
+		dst.CreateIndexWithOptions(fieldName, options)
+	}
+	// ListIndexes includes disabled indexes, so keep them disabled in the copy.
+	for _, fieldName := range src.ListDisabledIndexes() {
+		dst.DisableIndex(fieldName)
+	}
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"log/slog"
+	"memory-tools/internal/globalconst"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+// CollectionMeta holds per-collection settings that are persisted in the system collection.
+type CollectionMeta struct {
+	CompressInMemory bool   `json:"compress_in_memory"`
+	FileCompression  string `json:"file_compression,omitempty"` // Codec of the values in the collection file; empty means none.
+	// Schema is the JSON of the CollectionSchema writes are validated against; empty means none.
+	Schema string `json:"schema,omitempty"`
+	// DefaultTTLSeconds is the expiry of items set without one; zero means they do not expire.
+	DefaultTTLSeconds int64 `json:"default_ttl_seconds,omitempty"`
+}
+
+// GetCollectionMeta returns the stored settings of a collection, or the zero value if none were saved.
+func (cm *CollectionManager) GetCollectionMeta(name string) CollectionMeta {
+	var meta CollectionMeta
+	sysCol := cm.GetCollection(globalconst.SystemCollectionName)
+	if raw, found := sysCol.Get(globalconst.CollectionMetaPrefix + name); found {
+		if err := jsoniter.Unmarshal(raw, &meta); err != nil {
+			slog.Warn("Ignoring malformed collection metadata", "collection", name, "error", err)
+		}
+	}
+	return meta
+}
+
+// DefaultTTL returns the expiry given to the items of a collection that are set without one.
+func (cm *CollectionManager) DefaultTTL(name string) time.Duration {
+	return time.Duration(cm.GetCollectionMeta(name).DefaultTTLSeconds) * time.Second
+}
+
+// SaveCollectionMeta stores the settings of a collection and schedules the system collection for persistence.
+// Saving the zero value removes the entry.
+func (cm *CollectionManager) SaveCollectionMeta(name string, meta CollectionMeta) error {
+	sysCol := cm.GetCollection(globalconst.SystemCollectionName)
+	key := globalconst.CollectionMetaPrefix + name
+	if meta == (CollectionMeta{}) {
+		sysCol.Delete(key)
+	} else {
+		raw, err := jsoniter.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		sysCol.Set(key, raw, 0)
+	}
+	cm.EnqueueSaveTask(globalconst.SystemCollectionName, sysCol)
+	return nil
+}
+
+// ApplyCollectionMeta applies the persisted settings to the loaded collections.
+// It should be called once after the collections have been loaded from disk.
+func (cm *CollectionManager) ApplyCollectionMeta() {
+	type pending struct {
+		name string
+		meta CollectionMeta
+	}
+	var toApply []pending
+	sysCol := cm.GetCollection(globalconst.SystemCollectionName)
+	sysCol.StreamAll(func(key string, value []byte) bool {
+		name, ok := strings.CutPrefix(key, globalconst.CollectionMetaPrefix)
+		if !ok {
+			return true
+		}
+		var meta CollectionMeta
+		if err := jsoniter.Unmarshal(value, &meta); err != nil {
+			slog.Warn("Ignoring malformed collection metadata", "collection", name, "error", err)
+			return true
+		}
+		toApply = append(toApply, pending{name: name, meta: meta})
+		return true
+	})
+
+	for _, p := range toApply {
+		if !cm.CollectionExists(p.name) {
+			continue
+		}
+		col := cm.GetCollection(p.name)
+		col.SetCompression(p.meta.CompressInMemory)
+		col.SetFileCodec(p.meta.FileCompression)
+	}
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+var (
+	// ErrCollectionNotFound is returned by RenameCollection when the collection to rename does not exist.
+	ErrCollectionNotFound = errors.New("collection does not exist")
+	// ErrCollectionExists is returned by RenameCollection when the new name is already taken.
+	ErrCollectionExists = errors.New("collection already exists")
+)
+
+// RenameCollection gives a collection a new name. Its in-memory store, with its items and indexes,
+// moves to the new name along with its data file and its settings. Both names' file locks are held
+// throughout, so no save, load or file deletion of either collection interleaves with the rename.
+func (cm *CollectionManager) RenameCollection(oldName, newName string) error {
+	if oldName == newName {
+		return fmt.Errorf("%w: '%s'", ErrCollectionExists, newName)
+	}
+	// Locking in name order keeps two opposite renames from deadlocking.
+	first, second := cm.GetFileLock(oldName), cm.GetFileLock(newName)
+	if newName < oldName {
+		first, second = second, first
+	}
+	first.Lock()
+	defer first.Unlock()
+	second.Lock()
+	defer second.Unlock()
+
+	cm.mu.Lock()
+	col, found := cm.collections[oldName]
+	if !found {
+		cm.mu.Unlock()
+		return fmt.Errorf("%w: '%s'", ErrCollectionNotFound, oldName)
+	}
+	if _, taken := cm.collections[newName]; taken {
+		cm.mu.Unlock()
+		return fmt.Errorf("%w: '%s'", ErrCollectionExists, newName)
+	}
+	if err := cm.persister.RenameCollectionFile(oldName, newName); err != nil {
+		cm.mu.Unlock()
+		return err
+	}
+	delete(cm.collections, oldName)
+	cm.collections[newName] = col
+	cm.mu.Unlock()
+
+	if meta := cm.GetCollectionMeta(oldName); meta != (CollectionMeta{}) {
+		if err := cm.SaveCollectionMeta(newName, meta); err != nil {
+			slog.Warn("Failed to move collection settings to the new name", "collection", newName, "error", err)
+		} else if err := cm.SaveCollectionMeta(oldName, CollectionMeta{}); err != nil {
+			slog.Warn("Failed to remove collection settings of the old name", "collection", oldName, "error", err)
+		}
+	}
+	slog.Info("Collection renamed", "old_name", oldName, "new_name", newName)
+	return nil
+}
+
+// deleteCollectionFile runs a queued deletion of a collection file under its file lock. The file
+// is kept if a collection of that name exists again, as after a rename to the deleted name:
+// its data file is then the live one.
+func (cm *CollectionManager) deleteCollectionFile(collectionName string) error {
+	fileLock := cm.GetFileLock(collectionName)
+	fileLock.Lock()
+	defer fileLock.Unlock()
+	if cm.CollectionExists(collectionName) {
+		slog.Debug("Skipping deletion of the file of a live collection", "collection", collectionName)
+		return nil
+	}
+	return cm.persister.DeleteCollectionFile(collectionName)
+}
@@ -0,0 +1,204 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"memory-tools/internal/globalconst"
+)
+
+// schemaTypes are the types a schema property may require.
+var schemaTypes = map[string]bool{
+	"string": true, "number": true, "integer": true, "boolean": true, "object": true, "array": true, "null": true,
+}
+
+// CollectionSchema holds the rules the documents written to a collection must follow. Field
+// names may be dot-separated paths into nested objects, as in queries.
+type CollectionSchema struct {
+	// Required lists the fields every document must have.
+	Required []string `json:"required,omitempty"`
+	// Properties gives the type of fields; a field missing from a document is not checked.
+	Properties map[string]SchemaProperty `json:"properties,omitempty"`
+	// AdditionalProperties, if false, rejects top-level fields not named by Properties or Required.
+	// Fields maintained by the server are always allowed.
+	AdditionalProperties *bool `json:"additional_properties,omitempty"`
+}
+
+// SchemaProperty describes one field of a CollectionSchema.
+type SchemaProperty struct {
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable,omitempty"`
+}
+
+// ParseCollectionSchema decodes and checks a schema. Unknown keys and types are rejected, so
+// a typo cannot silently disable a rule.
+func ParseCollectionSchema(raw []byte) (*CollectionSchema, error) {
+	var schema CollectionSchema
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&schema); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	for _, field := range schema.Required {
+		if field == "" {
+			return nil, fmt.Errorf("invalid schema: required field names cannot be empty")
+		}
+	}
+	for field, property := range schema.Properties {
+		if field == "" {
+			return nil, fmt.Errorf("invalid schema: property names cannot be empty")
+		}
+		if !schemaTypes[property.Type] {
+			return nil, fmt.Errorf("invalid schema: property '%s' has unknown type '%s'", field, property.Type)
+		}
+	}
+	return &schema, nil
+}
+
+// Constraints a SchemaViolation can report.
+const (
+	SchemaConstraintRequired             = "required"
+	SchemaConstraintType                 = "type"
+	SchemaConstraintAdditionalProperties = "additional_properties"
+)
+
+// SchemaViolation is one rule of a schema that a document breaks.
+type SchemaViolation struct {
+	Field      string `json:"field"`      // Dot-separated path of the field.
+	Constraint string `json:"constraint"` // "required", "type" or "additional_properties".
+	Message    string `json:"message"`
+}
+
+// SchemaError is returned for a document that breaks its schema. It lists every violation, in
+// the order of the schema's required fields, then of its sorted properties, then of the sorted
+// fields it does not allow.
+type SchemaError struct {
+	Violations []SchemaViolation
+}
+
+func (e *SchemaError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = violation.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validate checks a whole document against the schema. It returns a *SchemaError if the document
+// breaks any rule.
+func (s *CollectionSchema) Validate(doc map[string]any) error {
+	var violations []SchemaViolation
+	for _, field := range s.Required {
+		if _, found := NestedValue(doc, field); !found {
+			violations = append(violations, SchemaViolation{Field: field, Constraint: SchemaConstraintRequired, Message: fmt.Sprintf("required field '%s' is missing", field)})
+		}
+	}
+	return s.schemaError(append(violations, s.patchViolations(doc)...))
+}
+
+// ValidatePatch checks the fields a patch sets, leaving out the required fields, which the
+// document being patched already has or lacks whatever the patch holds. It returns a
+// *SchemaError if the patch breaks any rule.
+func (s *CollectionSchema) ValidatePatch(patch map[string]any) error {
+	return s.schemaError(s.patchViolations(patch))
+}
+
+func (s *CollectionSchema) schemaError(violations []SchemaViolation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	return &SchemaError{Violations: violations}
+}
+
+func (s *CollectionSchema) patchViolations(patch map[string]any) []SchemaViolation {
+	var violations []SchemaViolation
+	fields := make([]string, 0, len(s.Properties))
+	for field := range s.Properties {
+		fields = append(fields, field)
+	}
+	// Sorted so the same document always reports the same violations in the same order.
+	sort.Strings(fields)
+	for _, field := range fields {
+		value, found := NestedValue(patch, field)
+		if !found {
+			continue
+		}
+		if err := s.Properties[field].check(value); err != nil {
+			violations = append(violations, SchemaViolation{Field: field, Constraint: SchemaConstraintType, Message: fmt.Sprintf("field '%s' %v", field, err)})
+		}
+	}
+
+	if s.AdditionalProperties == nil || *s.AdditionalProperties {
+		return violations
+	}
+	var extra []string
+	for field := range patch {
+		if !IsManagedField(field) && field != globalconst.UPDATED_AT && !s.allows(field) {
+			extra = append(extra, field)
+		}
+	}
+	sort.Strings(extra)
+	for _, field := range extra {
+		violations = append(violations, SchemaViolation{Field: field, Constraint: SchemaConstraintAdditionalProperties, Message: fmt.Sprintf("field '%s' is not allowed by the schema", field)})
+	}
+	return violations
+}
+
+// allows reports whether the schema names a top-level field, alone or as the start of a path.
+func (s *CollectionSchema) allows(field string) bool {
+	named := func(path string) bool {
+		return path == field || strings.HasPrefix(path, field+".")
+	}
+	for path := range s.Properties {
+		if named(path) {
+			return true
+		}
+	}
+	for _, path := range s.Required {
+		if named(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// check reports an error if value is not of the property's type.
+func (p SchemaProperty) check(value any) error {
+	if value == nil {
+		if p.Nullable || p.Type == "null" {
+			return nil
+		}
+		return fmt.Errorf("must be of type %s, not null", p.Type)
+	}
+	if actual := schemaTypeOf(value); actual != p.Type && !(p.Type == "number" && actual == "integer") {
+		return fmt.Errorf("must be of type %s, not %s", p.Type, actual)
+	}
+	return nil
+}
+
+// schemaTypeOf returns the schema type of a decoded JSON value. Whole numbers are integers.
+func schemaTypeOf(value any) string {
+	switch v := value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case int, int64, int32, uint, uint64, uint32:
+		return "integer"
+	case float32:
+		return "number"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
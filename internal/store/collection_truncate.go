@@ -0,0 +1,51 @@
+package store
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// TruncateCollection removes every item of a collection, from RAM and from its data file, while
+// keeping the collection, its indexes and its settings. It returns the emptied store and the
+// number of items removed, counting those held only on disk.
+func (cm *CollectionManager) TruncateCollection(name string) (DataStore, int, error) {
+	cm.mu.RLock()
+	oldCol, found := cm.collections[name]
+	cm.mu.RUnlock()
+	if !found {
+		return nil, 0, fmt.Errorf("%w: '%s'", ErrCollectionNotFound, name)
+	}
+	removed := oldCol.Size() + len(oldCol.ColdKeys())
+	newCol := cm.emptyCollectionLike(oldCol)
+	if err := cm.replaceTruncatedCollection(name, newCol); err != nil {
+		return nil, 0, err
+	}
+	return newCol, removed, nil
+}
+
+// emptyCollectionLike returns a new empty store with the indexes and settings of col.
+func (cm *CollectionManager) emptyCollectionLike(col DataStore) *InMemStore {
+	newCol := cm.newCollectionStore()
+	newCol.SetCompression(col.IsCompressionEnabled())
+	newCol.SetFileCodec(col.FileCodec())
+	copyIndexDefinitions(newCol, col)
+	return newCol
+}
+
+// replaceTruncatedCollection empties a collection's data file and puts newCol in place of the
+// collection's store, under the collection's file lock so no save interleaves. The old store is
+// kept if the file cannot be emptied.
+func (cm *CollectionManager) replaceTruncatedCollection(name string, newCol DataStore) error {
+	fileLock := cm.GetFileLock(name)
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	if err := cm.persister.TruncateCollectionFile(name); err != nil {
+		return fmt.Errorf("failed to truncate the data file of collection '%s': %w", name, err)
+	}
+	cm.mu.Lock()
+	cm.collections[name] = newCol
+	cm.mu.Unlock()
+	slog.Info("Collection truncated", "name", name, "items", newCol.Size())
+	return nil
+}
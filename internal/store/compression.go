@@ -0,0 +1,120 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// gzipWriterPool reuses gzip writers, which are expensive to allocate.
+var gzipWriterPool = sync.Pool{
+	New: func() any {
+		w, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+		return w
+	},
+}
+
+// compressValue gzip-compresses a value. ok is false if compression fails or
+// does not make the value smaller, in which case the value should be kept as is.
+func compressValue(value []byte) ([]byte, bool) {
+	var buf bytes.Buffer
+	w := gzipWriterPool.Get().(*gzip.Writer)
+	defer gzipWriterPool.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(value); err != nil {
+		return nil, false
+	}
+	if err := w.Close(); err != nil {
+		return nil, false
+	}
+	if buf.Len() >= len(value) {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// decompressValue reverses compressValue.
+func decompressValue(value []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(value))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// newItem builds an Item, compressing its value when requested and worthwhile.
+func newItem(value []byte, createdAt time.Time, ttl time.Duration, compress bool) Item {
+	item := Item{Value: value, CreatedAt: createdAt, TTL: ttl}
+	if compress && value != nil {
+		if compressed, ok := compressValue(value); ok {
+			item.Value = compressed
+			item.Compressed = true
+		}
+	}
+	return item
+}
+
+// value returns the item's value, decompressing it if it is stored compressed.
+// For compressed items the returned slice is a fresh copy.
+func (it Item) value() []byte {
+	if !it.Compressed {
+		return it.Value
+	}
+	v, err := decompressValue(it.Value)
+	if err != nil {
+		slog.Error("Failed to decompress in-memory value", "error", err)
+		return nil
+	}
+	return v
+}
+
+// makeItem builds an Item using the store's compression setting.
+func (s *InMemStore) makeItem(value []byte, createdAt time.Time, ttl time.Duration) Item {
+	return newItem(value, createdAt, ttl, s.compress.Load())
+}
+
+// SetCompression enables or disables in-memory compression of values and
+// re-encodes the existing items accordingly. Compression trades CPU on every
+// read and write for lower RAM usage; indexes always see the decompressed form.
+func (s *InMemStore) SetCompression(enabled bool) {
+	if s.compress.Swap(enabled) == enabled {
+		return
+	}
+	count := 0
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		for k, item := range shard.data {
+			if item.Compressed == enabled {
+				continue
+			}
+			reencoded := newItem(item.value(), item.CreatedAt, item.TTL, enabled)
+			reencoded.access = item.access
+			shard.data[k] = reencoded
+			shard.bytes.Add(entrySize(k, reencoded) - entrySize(k, item))
+			count++
+		}
+		shard.mu.Unlock()
+	}
+	slog.Info("In-memory compression setting changed", "enabled", enabled, "items_reencoded", count)
+}
+
+// IsCompressionEnabled reports whether values are compressed in memory.
+func (s *InMemStore) IsCompressionEnabled() bool {
+	return s.compress.Load()
+}
+
+// SetFileCodec sets the codec used to compress the values of the collection's data file on disk.
+// The store only carries the name; the persistence layer validates and applies it on the next save.
+func (s *InMemStore) SetFileCodec(codec string) {
+	s.fileCodec.Store(codec)
+}
+
+// FileCodec returns the codec of the collection's data file, or "" if values are stored uncompressed.
+func (s *InMemStore) FileCodec() string {
+	codec, _ := s.fileCodec.Load().(string)
+	return codec
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"memory-tools/internal/globalconst"
+	"time"
+)
+
+// SetCreationTime stamps a new document with its creation time, both as the RFC3339
+// CREATED_AT string and as the numeric CREATED_TS epoch used for age-range queries.
+func SetCreationTime(doc map[string]any, t time.Time) {
+	doc[globalconst.CREATED_AT] = t.UTC().Format(time.RFC3339)
+	doc[globalconst.CREATED_TS] = t.Unix()
+}
+
+// CopyCreationTime carries the creation time of src over to dst, removing it from dst if src has none.
+func CopyCreationTime(dst, src map[string]any) {
+	for _, field := range []string{globalconst.CREATED_AT, globalconst.CREATED_TS} {
+		if v, ok := src[field]; ok {
+			dst[field] = v
+		} else {
+			delete(dst, field)
+		}
+	}
+}
+
+// IsCreationField reports whether a field records the creation time, which patches must not change.
+func IsCreationField(field string) bool {
+	return field == globalconst.CREATED_AT || field == globalconst.CREATED_TS
+}
+
+// BackfillCreatedTs derives CREATED_TS from CREATED_AT for documents written before the field existed.
+// It reports whether the document was changed.
+func BackfillCreatedTs(doc map[string]any) bool {
+	if _, ok := doc[globalconst.CREATED_TS]; ok {
+		return false
+	}
+	createdAtStr, ok := doc[globalconst.CREATED_AT].(string)
+	if !ok {
+		return false
+	}
+	createdAt, err := time.Parse(time.RFC3339, createdAtStr)
+	if err != nil {
+		return false
+	}
+	doc[globalconst.CREATED_TS] = createdAt.Unix()
+	return true
+}
+
+// SetCreatedTsIndex controls whether new collection stores index CREATED_TS automatically.
+// Call it before collections are loaded so that every collection gets the index.
+func (cm *CollectionManager) SetCreatedTsIndex(enabled bool) {
+	cm.indexCreatedTs.Store(enabled)
+}
@@ -0,0 +1,50 @@
+package store
+
+import (
+	"memory-tools/internal/globalconst"
+	"testing"
+	"time"
+)
+
+func TestSetCreationTimeWritesBothFields(t *testing.T) {
+	created := time.Date(2023, 5, 1, 8, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	doc := map[string]any{}
+	SetCreationTime(doc, created)
+	if doc[globalconst.CREATED_AT] != "2023-05-01T06:00:00Z" {
+		t.Errorf("created_at = %v, want the UTC time", doc[globalconst.CREATED_AT])
+	}
+	if doc[globalconst.CREATED_TS] != created.Unix() {
+		t.Errorf("_created_ts = %v, want %d", doc[globalconst.CREATED_TS], created.Unix())
+	}
+}
+
+func TestBackfillCreatedTs(t *testing.T) {
+	doc := map[string]any{globalconst.CREATED_AT: "2020-01-02T03:04:05Z"}
+	if !BackfillCreatedTs(doc) {
+		t.Fatal("document without _created_ts was not backfilled")
+	}
+	if want := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC).Unix(); doc[globalconst.CREATED_TS] != want {
+		t.Fatalf("_created_ts = %v, want %d", doc[globalconst.CREATED_TS], want)
+	}
+	if BackfillCreatedTs(doc) {
+		t.Error("an existing _created_ts was backfilled again")
+	}
+	for _, doc := range []map[string]any{{}, {globalconst.CREATED_AT: "yesterday"}} {
+		if BackfillCreatedTs(doc) {
+			t.Errorf("document %v without a valid created_at was backfilled", doc)
+		}
+	}
+}
+
+func TestCopyCreationTime(t *testing.T) {
+	src := map[string]any{globalconst.CREATED_AT: "2020-01-02T03:04:05Z", globalconst.CREATED_TS: int64(1577934245)}
+	dst := map[string]any{globalconst.CREATED_TS: int64(1)}
+	CopyCreationTime(dst, src)
+	if dst[globalconst.CREATED_AT] != src[globalconst.CREATED_AT] || dst[globalconst.CREATED_TS] != src[globalconst.CREATED_TS] {
+		t.Fatalf("dst = %v, want the creation time of src", dst)
+	}
+	CopyCreationTime(dst, map[string]any{})
+	if len(dst) != 0 {
+		t.Fatalf("dst = %v, want the creation fields removed", dst)
+	}
+}
@@ -0,0 +1,21 @@
+package store
+
+import "testing"
+
+// discardPersister is a CollectionPersister that keeps nothing on disk.
+type discardPersister struct{}
+
+func (discardPersister) SaveCollectionData(string, DataStore) error                { return nil }
+func (discardPersister) DeleteCollectionFile(string) error                         { return nil }
+func (discardPersister) RenameCollectionFile(string, string) error                 { return nil }
+func (discardPersister) CopyCollectionFile(string, string) error                   { return nil }
+func (discardPersister) TruncateCollectionFile(string) error                       { return nil }
+func (discardPersister) WriteColdItems(string, map[string][]byte) error            { return nil }
+func (discardPersister) ScanColdDocuments(string, func(map[string]any) bool) error { return nil }
+
+// newTestManagers returns a collection manager without persistence and its transaction manager.
+func newTestManagers(t *testing.T) (*CollectionManager, *TransactionManager) {
+	t.Helper()
+	cm := NewCollectionManager(discardPersister{}, 4)
+	return cm, NewTransactionManager(cm)
+}
@@ -0,0 +1,25 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrFieldNotNumeric is returned by IncrementField when the field holds a non-numeric value.
+var ErrFieldNotNumeric = errors.New("field is not numeric")
+
+// IncrementField adds delta to a numeric top-level field of doc in place and returns the new value.
+// A missing field is created with the value delta.
+func IncrementField(doc map[string]any, field string, delta float64) (float64, error) {
+	current, exists := doc[field]
+	if !exists {
+		doc[field] = delta
+		return delta, nil
+	}
+	number, ok := current.(float64)
+	if !ok {
+		return 0, fmt.Errorf("%w: '%s' holds %T", ErrFieldNotNumeric, field, current)
+	}
+	doc[field] = number + delta
+	return number + delta, nil
+}
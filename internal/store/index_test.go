@@ -0,0 +1,100 @@
+package store
+
+import (
+	"slices"
+	"testing"
+)
+
+// indexedStore returns a store with an index on "v" holding one document per value, keyed by
+// the position of the value.
+func indexedStore(values ...string) *InMemStore {
+	s := NewInMemStoreWithShards(2)
+	s.CreateIndex("v")
+	for i, value := range values {
+		s.Set(string(rune('a'+i)), []byte(`{"v":`+value+`}`), 0)
+	}
+	return s
+}
+
+func TestLookupRangeOnStringFieldWithNumericLookingBound(t *testing.T) {
+	s := indexedStore(`"050"`, `"100"`, `"20"`, `"abc"`)
+
+	keys, used := s.LookupRange("v", "100", nil, true, false)
+	if !used {
+		t.Fatal("index not used for a string field")
+	}
+	slices.Sort(keys)
+	// Strings are ordered lexicographically: "100" <= "20" <= "abc", but "050" < "100".
+	if want := []string{"b", "c", "d"}; !slices.Equal(keys, want) {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+}
+
+func TestLookupRangeOnNumericField(t *testing.T) {
+	s := indexedStore(`5`, `10`, `15`)
+
+	keys, used := s.LookupRange("v", 5, 15, false, true)
+	slices.Sort(keys)
+	if !used || !slices.Equal(keys, []string{"b", "c"}) {
+		t.Fatalf("keys = %v (used %v), want [b c]", keys, used)
+	}
+}
+
+func TestLookupRangeIsNotUsedOnMixedField(t *testing.T) {
+	for name, values := range map[string][]string{
+		"numbers and strings": {`1`, `2`, `"3"`},
+		"unindexed values":    {`1`, `2`, `true`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := indexedStore(values...)
+			if _, used := s.LookupRange("v", 0, nil, true, false); used {
+				t.Fatal("range lookup used an index that cannot hold every value of the field")
+			}
+		})
+	}
+}
+
+func TestEnableIndexReflectsWritesMadeWhileDisabled(t *testing.T) {
+	s := indexedStore(`"red"`, `"blue"`) // a: red, b: blue
+
+	if !s.DisableIndex("v") {
+		t.Fatal("DisableIndex did not find the index")
+	}
+	if _, used := s.Lookup("v", "red"); used {
+		t.Fatal("a disabled index answered a lookup")
+	}
+	s.Set("a", []byte(`{"v":"green"}`), 0) // Changed.
+	s.Delete("b")                          // Removed.
+	s.Set("c", []byte(`{"v":"red"}`), 0)   // Added.
+
+	if !s.EnableIndex("v") {
+		t.Fatal("EnableIndex did not find the index")
+	}
+	for value, want := range map[string][]string{"red": {"c"}, "green": {"a"}, "blue": nil} {
+		keys, used := s.Lookup("v", value)
+		if !used {
+			t.Fatal("re-enabled index not used")
+		}
+		if slices.Sort(keys); !slices.Equal(keys, want) {
+			t.Errorf("Lookup(%q) = %v, want %v", value, keys, want)
+		}
+	}
+}
+
+func TestLoadDataRebuildsExistingIndexes(t *testing.T) {
+	s := indexedStore(`1`, `2`)
+	s.CreateIndex("w")
+	s.DisableIndex("w")
+
+	s.LoadData(map[string][]byte{"x": []byte(`{"v":2,"w":1}`), "y": []byte(`{"v":3}`)})
+
+	if keys, used := s.Lookup("v", 2); !used || !slices.Equal(keys, []string{"x"}) {
+		t.Fatalf("lookup of 2 = %v (used %v), want only the loaded document", keys, used)
+	}
+	if keys, _ := s.Lookup("v", 1); len(keys) != 0 {
+		t.Fatalf("lookup of 1 = %v, want no documents replaced by the load", keys)
+	}
+	if disabled := s.ListDisabledIndexes(); !slices.Equal(disabled, []string{"w"}) {
+		t.Fatalf("disabled indexes = %v, want [w] kept disabled", disabled)
+	}
+}
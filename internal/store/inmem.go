@@ -2,13 +2,17 @@ package store
 
 import (
 	"bytes"
+	stdjson "encoding/json"
 	"fmt"
 	"hash/fnv"
 	"log/slog"
 	"maps"
 	"memory-tools/internal/globalconst"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/btree"
@@ -16,7 +20,8 @@ import (
 )
 
 // tryUnmarshal unmarshals a byte slice into a map.
-// It ensures that all numbers from JSON are converted to float64 for consistent indexing.
+// It ensures that all numbers from JSON, including those in nested objects and arrays,
+// are converted to float64 for consistent indexing.
 func tryUnmarshal(value []byte) map[string]any {
 	var data map[string]any
 
@@ -26,18 +31,34 @@ func tryUnmarshal(value []byte) map[string]any {
 		return nil
 	}
 
+	// UseNumber yields encoding/json numbers, which jsoniter.Number does not match.
 	for k, v := range data {
-		if num, ok := v.(jsoniter.Number); ok {
-			if f, err := num.Float64(); err == nil {
-				data[k] = f
-			} else {
-				data[k] = num.String()
-			}
-		}
+		data[k] = normalizeNumbers(v)
 	}
 	return data
 }
 
+// normalizeNumbers converts the JSON numbers in a decoded value to float64, descending into
+// objects and arrays so nested fields can be indexed too.
+func normalizeNumbers(v any) any {
+	switch val := v.(type) {
+	case stdjson.Number:
+		if f, err := val.Float64(); err == nil {
+			return f
+		}
+		return val.String()
+	case map[string]any:
+		for k, elem := range val {
+			val[k] = normalizeNumbers(elem)
+		}
+	case []any:
+		for i, elem := range val {
+			val[i] = normalizeNumbers(elem)
+		}
+	}
+	return v
+}
+
 // valueToFloat64 is a helper to safely convert various numeric types to float64.
 func valueToFloat64(v any) (float64, bool) {
 	switch val := v.(type) {
@@ -58,6 +79,9 @@ func valueToFloat64(v any) (float64, bool) {
 	case jsoniter.Number:
 		f, err := val.Float64()
 		return f, err == nil
+	case stdjson.Number:
+		f, err := val.Float64()
+		return f, err == nil
 	case string:
 		f, err := strconv.ParseFloat(val, 64)
 		return f, err == nil
@@ -93,19 +117,59 @@ func stringLess(a, b StringKey) bool {
 }
 
 // Index now contains two B-Trees, one for each supported data type.
+// It also counts how many documents are held in each tree, so range queries
+// can be routed to the tree matching the field's dominant type.
 type Index struct {
-	numericTree *btree.BTreeG[NumericKey]
-	stringTree  *btree.BTreeG[StringKey]
+	numericTree  *btree.BTreeG[NumericKey]
+	stringTree   *btree.BTreeG[StringKey]
+	numericCount int
+	stringCount  int
+	// disabled pauses maintenance of the index: writes skip it and the
+	// optimizer ignores it until it is re-enabled and backfilled.
+	disabled bool
+	// caseInsensitive stores string values lowercased, so lookups match regardless of case.
+	caseInsensitive bool
+	// unique rejects writes that would give two documents the same value.
+	unique bool
+	// arrayDocs holds the documents whose field is an array; each of its elements is indexed,
+	// so such a document can be found under several values.
+	arrayDocs map[string]struct{}
+	// unindexedDocs holds the documents whose field is present but holds no indexable value:
+	// null, a boolean or an object (or an array holding one).
+	unindexedDocs map[string]struct{}
+}
+
+// IndexOptions configures how an index stores its values.
+type IndexOptions struct {
+	// CaseInsensitive indexes strings by their lowercase form. Documents keep their original values.
+	CaseInsensitive bool `json:"case_insensitive,omitempty"`
+	// Unique rejects writes that would give a value of the field to a second document.
+	Unique bool `json:"unique,omitempty"`
+}
+
+// normalize returns the form in which a string value is stored in the index.
+func (idx *Index) normalize(sVal string) string {
+	if idx.caseInsensitive {
+		return strings.ToLower(sVal)
+	}
+	return sVal
 }
 
 // NewIndex creates a new index structure with initialized B-Trees.
 func NewIndex() *Index {
 	return &Index{
-		numericTree: btree.NewG[NumericKey](btreeDegree, numericLess),
-		stringTree:  btree.NewG[StringKey](btreeDegree, stringLess),
+		numericTree:   btree.NewG[NumericKey](btreeDegree, numericLess),
+		stringTree:    btree.NewG[StringKey](btreeDegree, stringLess),
+		arrayDocs:     make(map[string]struct{}),
+		unindexedDocs: make(map[string]struct{}),
 	}
 }
 
+// isStringDominant reports whether most indexed values of the field are strings.
+func (idx *Index) isStringDominant() bool {
+	return idx.stringCount > idx.numericCount
+}
+
 // --- IndexManager for B-Trees ---
 
 // IndexManager manages all indexes for a single InMemStore.
@@ -123,12 +187,32 @@ func NewIndexManager() *IndexManager {
 
 // CreateIndex initializes a new B-Tree index for a given field.
 func (im *IndexManager) CreateIndex(field string) {
+	im.CreateIndexWithOptions(field, IndexOptions{})
+}
+
+// CreateIndexWithOptions initializes a new B-Tree index for a given field with the given options.
+func (im *IndexManager) CreateIndexWithOptions(field string, options IndexOptions) {
 	im.mu.Lock()
 	defer im.mu.Unlock()
 	if _, exists := im.indexes[field]; !exists {
-		im.indexes[field] = NewIndex()
-		slog.Info("B-Tree Index created", "field", field)
+		index := NewIndex()
+		index.caseInsensitive = options.CaseInsensitive
+		index.unique = options.Unique
+		im.indexes[field] = index
+		slog.Info("B-Tree Index created", "field", field, "case_insensitive", options.CaseInsensitive, "unique", options.Unique)
+	}
+}
+
+// GetIndexOptions returns the options of the index on a field, whether active or disabled.
+// It returns false if no index exists for the field.
+func (im *IndexManager) GetIndexOptions(field string) (IndexOptions, bool) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	index, exists := im.indexes[field]
+	if !exists {
+		return IndexOptions{}, false
 	}
+	return IndexOptions{CaseInsensitive: index.caseInsensitive, Unique: index.unique}, true
 }
 
 // DeleteIndex removes an index for a given field.
@@ -141,7 +225,67 @@ func (im *IndexManager) DeleteIndex(field string) {
 	}
 }
 
-// ListIndexes returns the names of all indexed fields.
+// SetIndexDisabled flips the active flag of an index. Disabling an index releases
+// its B-Tree contents, since they go stale as soon as writes stop updating them.
+// It reports whether the index exists and whether its state actually changed.
+func (im *IndexManager) SetIndexDisabled(field string, disabled bool) (found, changed bool) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	index, exists := im.indexes[field]
+	if !exists {
+		return false, false
+	}
+	if index.disabled == disabled {
+		return true, false
+	}
+	if disabled {
+		caseInsensitive, unique := index.caseInsensitive, index.unique
+		index = NewIndex()
+		index.disabled = true
+		index.caseInsensitive = caseInsensitive
+		index.unique = unique
+		im.indexes[field] = index
+		slog.Info("Index disabled", "field", field)
+	} else {
+		index.disabled = false
+		slog.Info("Index enabled", "field", field)
+	}
+	return true, true
+}
+
+// clearIndexes empties every index, keeping its definition and options, and reports how many
+// active indexes there are to fill again.
+func (im *IndexManager) clearIndexes() int {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	active := 0
+	for field, index := range im.indexes {
+		cleared := NewIndex()
+		cleared.disabled = index.disabled
+		cleared.caseInsensitive = index.caseInsensitive
+		cleared.unique = index.unique
+		im.indexes[field] = cleared
+		if !index.disabled {
+			active++
+		}
+	}
+	return active
+}
+
+// ListDisabledIndexes returns the names of all indexed fields that are currently disabled.
+func (im *IndexManager) ListDisabledIndexes() []string {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	disabledFields := make([]string, 0)
+	for field, index := range im.indexes {
+		if index.disabled {
+			disabledFields = append(disabledFields, field)
+		}
+	}
+	return disabledFields
+}
+
+// ListIndexes returns the names of all indexed fields, including disabled ones.
 func (im *IndexManager) ListIndexes() []string {
 	im.mu.RLock()
 	defer im.mu.RUnlock()
@@ -153,48 +297,93 @@ func (im *IndexManager) ListIndexes() []string {
 }
 
 // addToIndex adds a document key to an index for a specific value.
+// Strings are always stored in the string tree, even if they look numeric,
+// so that string fields keep their lexicographic ordering. The document is
+// added under every string or numeric element of an array value.
 func (im *IndexManager) addToIndex(index *Index, docKey string, value any) {
-	if fVal, ok := valueToFloat64(value); ok {
-		key := NumericKey{Value: fVal}
-		item, found := index.numericTree.Get(key)
-		if !found {
-			item = NumericKey{Value: fVal, Keys: make(map[string]struct{})}
+	if elements, ok := value.([]any); ok {
+		index.arrayDocs[docKey] = struct{}{}
+		for _, element := range elements {
+			im.addToIndex(index, docKey, element)
 		}
-		item.Keys[docKey] = struct{}{}
-		index.numericTree.ReplaceOrInsert(item)
-	} else if sVal, ok := value.(string); ok {
+		return
+	}
+	if sVal, ok := value.(string); ok {
+		sVal = index.normalize(sVal)
 		key := StringKey{Value: sVal}
 		item, found := index.stringTree.Get(key)
 		if !found {
 			item = StringKey{Value: sVal, Keys: make(map[string]struct{})}
 		}
+		if _, exists := item.Keys[docKey]; !exists {
+			index.stringCount++
+		}
 		item.Keys[docKey] = struct{}{}
 		index.stringTree.ReplaceOrInsert(item)
+	} else if fVal, ok := valueToFloat64(value); ok {
+		key := NumericKey{Value: fVal}
+		item, found := index.numericTree.Get(key)
+		if !found {
+			item = NumericKey{Value: fVal, Keys: make(map[string]struct{})}
+		}
+		if _, exists := item.Keys[docKey]; !exists {
+			index.numericCount++
+		}
+		item.Keys[docKey] = struct{}{}
+		index.numericTree.ReplaceOrInsert(item)
+	} else {
+		index.unindexedDocs[docKey] = struct{}{}
+	}
+}
+
+// sameIndexedValue reports whether two field values are the same indexable value. Objects and
+// arrays are not compared (comparing them with == would panic), so arrays are always reindexed.
+func sameIndexedValue(a, b any) bool {
+	switch a.(type) {
+	case string, float64, bool, nil:
+		return a == b
+	default:
+		return false
 	}
 }
 
 // removeFromIndex removes a document key from an index.
 func (im *IndexManager) removeFromIndex(index *Index, docKey string, value any) {
-	if fVal, ok := valueToFloat64(value); ok {
-		key := NumericKey{Value: fVal}
-		if item, found := index.numericTree.Get(key); found {
+	if elements, ok := value.([]any); ok {
+		delete(index.arrayDocs, docKey)
+		for _, element := range elements {
+			im.removeFromIndex(index, docKey, element)
+		}
+		return
+	}
+	if sVal, ok := value.(string); ok {
+		key := StringKey{Value: index.normalize(sVal)}
+		if item, found := index.stringTree.Get(key); found {
+			if _, exists := item.Keys[docKey]; exists {
+				index.stringCount--
+			}
 			delete(item.Keys, docKey)
 			if len(item.Keys) == 0 {
-				index.numericTree.Delete(item)
+				index.stringTree.Delete(item)
 			} else {
-				index.numericTree.ReplaceOrInsert(item)
+				index.stringTree.ReplaceOrInsert(item)
 			}
 		}
-	} else if sVal, ok := value.(string); ok {
-		key := StringKey{Value: sVal}
-		if item, found := index.stringTree.Get(key); found {
+	} else if fVal, ok := valueToFloat64(value); ok {
+		key := NumericKey{Value: fVal}
+		if item, found := index.numericTree.Get(key); found {
+			if _, exists := item.Keys[docKey]; exists {
+				index.numericCount--
+			}
 			delete(item.Keys, docKey)
 			if len(item.Keys) == 0 {
-				index.stringTree.Delete(item)
+				index.numericTree.Delete(item)
 			} else {
-				index.stringTree.ReplaceOrInsert(item)
+				index.numericTree.ReplaceOrInsert(item)
 			}
 		}
+	} else {
+		delete(index.unindexedDocs, docKey)
 	}
 }
 
@@ -208,10 +397,13 @@ func (im *IndexManager) Update(docKey string, oldData, newData map[string]any) {
 	}
 
 	for field, index := range im.indexes {
-		oldVal, oldOk := oldData[field]
-		newVal, newOk := newData[field]
+		if index.disabled {
+			continue
+		}
+		oldVal, oldOk := NestedValue(oldData, field)
+		newVal, newOk := NestedValue(newData, field)
 
-		if oldOk && newOk && oldVal == newVal {
+		if oldOk && newOk && sameIndexedValue(oldVal, newVal) {
 			continue
 		}
 
@@ -232,35 +424,43 @@ func (im *IndexManager) Remove(docKey string, data map[string]any) {
 		return
 	}
 	for field, index := range im.indexes {
-		if val, ok := data[field]; ok {
+		if index.disabled {
+			continue
+		}
+		if val, ok := NestedValue(data, field); ok {
 			im.removeFromIndex(index, docKey, val)
 		}
 	}
 }
 
 // Lookup performs an equality lookup on an index.
+// Numeric-looking values are matched against both trees, mirroring the
+// numeric coercion applied by the query engine's equality comparison.
 func (im *IndexManager) Lookup(field string, value any) ([]string, bool) {
 	im.mu.RLock()
 	defer im.mu.RUnlock()
 
 	index, exists := im.indexes[field]
-	if !exists {
+	if !exists || index.disabled {
 		return nil, false
 	}
 
-	var foundKeys map[string]struct{}
+	foundKeys := make(map[string]struct{})
 	if fVal, ok := valueToFloat64(value); ok {
 		if item, found := index.numericTree.Get(NumericKey{Value: fVal}); found {
-			foundKeys = item.Keys
+			maps.Copy(foundKeys, item.Keys)
 		}
-	} else if sVal, ok := value.(string); ok {
-		if item, found := index.stringTree.Get(StringKey{Value: sVal}); found {
-			foundKeys = item.Keys
+	}
+	sVal, isString := value.(string)
+	if !isString {
+		if fVal, ok := valueToFloat64(value); ok {
+			sVal, isString = strconv.FormatFloat(fVal, 'f', -1, 64), true
 		}
 	}
-
-	if foundKeys == nil {
-		return []string{}, true
+	if isString {
+		if item, found := index.stringTree.Get(StringKey{Value: index.normalize(sVal)}); found {
+			maps.Copy(foundKeys, item.Keys)
+		}
 	}
 
 	keys := make([]string, 0, len(foundKeys))
@@ -276,19 +476,35 @@ func (im *IndexManager) LookupRange(field string, low, high any, lowInclusive, h
 	defer im.mu.RUnlock()
 
 	index, exists := im.indexes[field]
-	if !exists {
+	if !exists || index.disabled {
+		return nil, false
+	}
+	// A range is scanned in one tree only, so documents whose values are in the other tree or
+	// not indexed at all would be missed. The caller scans the documents instead.
+	if (index.numericCount > 0 && index.stringCount > 0) || len(index.unindexedDocs) > 0 {
 		return nil, false
 	}
 
 	unionKeys := make(map[string]struct{})
-	var isNumericQuery bool
-	if low != nil {
-		if _, ok := valueToFloat64(low); ok {
-			isNumericQuery = true
+
+	// The tree is chosen from the field's dominant indexed type rather than the
+	// type of the query bounds, so a string field queried with a numeric-looking
+	// bound (e.g. "100") is still scanned lexicographically.
+	isNumericQuery := !index.isStringDominant()
+	// A case-insensitive string tree is ordered by the lowercase values, not by the values themselves.
+	if !isNumericQuery && index.caseInsensitive {
+		return nil, false
+	}
+	if isNumericQuery {
+		if low != nil {
+			if _, ok := valueToFloat64(low); !ok {
+				return nil, false
+			}
 		}
-	} else if high != nil {
-		if _, ok := valueToFloat64(high); ok {
-			isNumericQuery = true
+		if high != nil {
+			if _, ok := valueToFloat64(high); !ok {
+				return nil, false
+			}
 		}
 	}
 
@@ -340,10 +556,10 @@ func (im *IndexManager) LookupRange(field string, low, high any, lowInclusive, h
 		var lowKey, highKey StringKey
 		hasLowBound, hasHighBound := low != nil, high != nil
 		if hasLowBound {
-			lowKey.Value, _ = low.(string)
+			lowKey.Value = boundToString(low)
 		}
 		if hasHighBound {
-			highKey.Value, _ = high.(string)
+			highKey.Value = boundToString(high)
 		}
 
 		iterator := func(item StringKey) bool {
@@ -388,8 +604,165 @@ func (im *IndexManager) LookupRange(field string, low, high any, lowInclusive, h
 	return finalKeys, true
 }
 
-// HasIndex checks if an index exists for a given field.
+// boundToString converts a range bound to its string form for string tree scans.
+func boundToString(v any) string {
+	if sVal, ok := v.(string); ok {
+		return sVal
+	}
+	if fVal, ok := valueToFloat64(v); ok {
+		return strconv.FormatFloat(fVal, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// LookupPrefix finds the documents whose string value for field starts with prefix, compared
+// in the index's normalized form. Numeric values never match.
+func (im *IndexManager) LookupPrefix(field, prefix string) ([]string, bool) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	index, exists := im.indexes[field]
+	if !exists || index.disabled {
+		return nil, false
+	}
+
+	prefix = index.normalize(prefix)
+	keys := make([]string, 0)
+	index.stringTree.AscendGreaterOrEqual(StringKey{Value: prefix}, func(item StringKey) bool {
+		if !strings.HasPrefix(item.Value, prefix) {
+			return false
+		}
+		for k := range item.Keys {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	return keys, true
+}
+
+// IndexPosition is a place in an index's order: a field value and, among the documents
+// sharing that value, a document key.
+type IndexPosition struct {
+	Value any
+	Key   string
+}
+
+// ScanOrder returns the positions of up to n documents in the order of the index on field,
+// by value and then by key, starting right after the given position, or at the start if it is nil.
+// It reports false when the index cannot order all totalDocs documents: it is missing or disabled,
+// it mixes numbers and strings, or some documents have no indexed value for the field.
+func (im *IndexManager) ScanOrder(field string, after *IndexPosition, descending bool, n, totalDocs int) ([]IndexPosition, bool) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	index, exists := im.indexes[field]
+	if !exists || index.disabled {
+		return nil, false
+	}
+	if index.numericCount+index.stringCount != totalDocs || (index.numericCount > 0 && index.stringCount > 0) {
+		return nil, false
+	}
+	if index.caseInsensitive && index.stringCount > 0 {
+		return nil, false
+	}
+	// A document with an array value would be visited once per element.
+	if len(index.arrayDocs) > 0 {
+		return nil, false
+	}
+
+	positions := make([]IndexPosition, 0, n)
+	visit := func(value any, keys map[string]struct{}) bool {
+		sortedKeys := slices.Sorted(maps.Keys(keys))
+		if descending {
+			slices.Reverse(sortedKeys)
+		}
+		for _, key := range sortedKeys {
+			// Documents sharing the value of the starting position are resumed after its key.
+			if after != nil && value == after.Value && (key == after.Key || (key < after.Key) != descending) {
+				continue
+			}
+			positions = append(positions, IndexPosition{Value: value, Key: key})
+			if len(positions) >= n {
+				return false
+			}
+		}
+		return true
+	}
+
+	if index.numericCount > 0 {
+		var pivot NumericKey
+		if after != nil {
+			fVal, ok := after.Value.(float64)
+			if !ok {
+				return nil, false
+			}
+			pivot.Value = fVal
+		}
+		iterator := func(item NumericKey) bool { return visit(item.Value, item.Keys) }
+		switch {
+		case after == nil && descending:
+			index.numericTree.Descend(iterator)
+		case after == nil:
+			index.numericTree.Ascend(iterator)
+		case descending:
+			index.numericTree.DescendLessOrEqual(pivot, iterator)
+		default:
+			index.numericTree.AscendGreaterOrEqual(pivot, iterator)
+		}
+		return positions, true
+	}
+
+	var pivot StringKey
+	if after != nil {
+		sVal, ok := after.Value.(string)
+		if !ok {
+			return nil, false
+		}
+		pivot.Value = sVal
+	}
+	iterator := func(item StringKey) bool { return visit(item.Value, item.Keys) }
+	switch {
+	case after == nil && descending:
+		index.stringTree.Descend(iterator)
+	case after == nil:
+		index.stringTree.Ascend(iterator)
+	case descending:
+		index.stringTree.DescendLessOrEqual(pivot, iterator)
+	default:
+		index.stringTree.AscendGreaterOrEqual(pivot, iterator)
+	}
+	return positions, true
+}
+
+// HasIndex checks if an active index exists for a given field.
+// Disabled indexes are reported as missing so the query optimizer ignores them.
 func (im *IndexManager) HasIndex(field string) bool {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	index, exists := im.indexes[field]
+	return exists && !index.disabled
+}
+
+// HasArrayValues reports whether some documents are indexed on a field by the elements of
+// an array. A lookup on such an index returns them when any element matches.
+func (im *IndexManager) HasArrayValues(field string) bool {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	index, exists := im.indexes[field]
+	return exists && len(index.arrayDocs) > 0
+}
+
+// HasUnindexedValues reports whether some documents have the field with a value the index on
+// it cannot hold, such as null or a boolean, so the index does not tell what their value is.
+func (im *IndexManager) HasUnindexedValues(field string) bool {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	index, exists := im.indexes[field]
+	return exists && len(index.unindexedDocs) > 0
+}
+
+// indexExists checks if an index exists for a given field, whether active or disabled.
+func (im *IndexManager) indexExists(field string) bool {
 	im.mu.RLock()
 	defer im.mu.RUnlock()
 	_, exists := im.indexes[field]
@@ -401,6 +774,18 @@ type Item struct {
 	Value     []byte
 	CreatedAt time.Time
 	TTL       time.Duration
+	// Compressed marks Value as gzip-compressed; use value() to read it.
+	Compressed bool
+	// access records the item's use when the store has a memory cap; nil otherwise.
+	access *itemAccess
+}
+
+// expiresAt returns the time the item expires, or the zero time if it has no TTL.
+func (it Item) expiresAt() time.Time {
+	if it.TTL <= 0 {
+		return time.Time{}
+	}
+	return it.CreatedAt.Add(it.TTL)
 }
 
 // Shard represents a segment of the in-memory store.
@@ -409,25 +794,60 @@ type Shard struct {
 	mu            sync.RWMutex
 	keyLocks      map[string]string
 	pendingWrites map[string]map[string]Item
+	// bytes approximates the memory held by data. It is only written under mu.
+	bytes atomic.Int64
+	// cold maps keys of this shard whose only copy is in the collection file to the time they
+	// expired when they were moved there, zero for items without a TTL.
+	cold map[string]time.Time
+	// trackAccess makes put attach access records to items, for eviction under a memory cap.
+	trackAccess bool
 }
 
 // DataStore defines the interface for data storage and retrieval.
 type DataStore interface {
 	Set(key string, value []byte, ttl time.Duration)
+	UpdateIf(key string, update func(current []byte) ([]byte, bool)) (found, applied bool, err error)
+	SetIfAbsent(key string, value []byte, ttl time.Duration) (set bool, err error)
 	Get(key string) ([]byte, bool)
 	GetMany(keys []string) map[string][]byte
 	Delete(key string)
+	GetAndDelete(key string) (value []byte, found bool, err error)
+	TTL(key string) (remaining time.Duration, hasExpiry, found bool)
+	Touch(key string, ttl time.Duration) (found bool, err error)
 	GetAll() map[string][]byte
 	StreamAll(callback func(key string, value []byte) bool)
+	ParallelStreamAll(callback func(shardIndex int, key string, value []byte) bool)
+	ShardCount() int
 	LoadData(data map[string][]byte)
 	CleanExpiredItems() bool
 	Size() int
 	CreateIndex(field string)
+	CreateIndexWithOptions(field string, options IndexOptions)
+	GetIndexOptions(field string) (IndexOptions, bool)
 	DeleteIndex(field string)
+	DisableIndex(field string) bool
+	EnableIndex(field string) bool
 	ListIndexes() []string
+	ListDisabledIndexes() []string
 	HasIndex(field string) bool
+	IndexHasArrayValues(field string) bool
+	IndexHasUnindexedValues(field string) bool
+	Keys() []string
+	KeysWithPrefix(prefix, after string) []string
 	Lookup(field string, value any) ([]string, bool)
 	LookupRange(field string, low, high any, lowInclusive, highInclusive bool) ([]string, bool)
+	LookupPrefix(field, prefix string) ([]string, bool)
+	ScanIndexOrder(field string, after *IndexPosition, descending bool, n int) ([]IndexPosition, bool)
+	SetCompression(enabled bool)
+	IsCompressionEnabled() bool
+	SetFileCodec(codec string)
+	FileCodec() string
+	MarkCold(keys ...string)
+	IsColdKey(key string) bool
+	PromoteCold(key string, value []byte) bool
+	ColdKeys() []string
+	ColdKeyCount() int
+	MemoryStats() MemoryStats
 }
 
 // InMemStore implements DataStore for in-memory storage, with sharding and indexing.
@@ -435,6 +855,9 @@ type InMemStore struct {
 	shards    []*Shard
 	numShards int
 	indexes   *IndexManager
+	compress  atomic.Bool
+	fileCodec atomic.Value // string
+	evicted   atomic.Int64
 }
 
 // NewInMemStoreWithShards creates a new InMemStore with a specified number of shards.
@@ -449,6 +872,7 @@ func NewInMemStoreWithShards(numShards int) *InMemStore {
 			data:          make(map[string]Item),
 			keyLocks:      make(map[string]string),
 			pendingWrites: make(map[string]map[string]Item),
+			cold:          make(map[string]time.Time),
 		}
 	}
 	slog.Info("InMemStore initialized", "num_shards", numShards)
@@ -487,19 +911,14 @@ func (s *InMemStore) Set(key string, value []byte, ttl time.Duration) {
 	if isUpdate {
 		createdAt = oldItem.CreatedAt
 	}
-	newItem := Item{
-		Value:     value,
-		CreatedAt: createdAt,
-		TTL:       ttl,
-	}
-
-	shard.data[key] = newItem
+	// Indexes are computed from the uncompressed value before it is stored.
+	shard.put(key, s.makeItem(value, createdAt, ttl))
 
 	var oldDataForIndex map[string]any
 	if isUpdate {
-		oldDataForIndex = tryUnmarshal(oldItem.Value)
+		oldDataForIndex = tryUnmarshal(oldItem.value())
 	}
-	newDataForIndex := tryUnmarshal(newItem.Value)
+	newDataForIndex := tryUnmarshal(value)
 
 	if oldDataForIndex != nil || newDataForIndex != nil {
 		s.indexes.Update(key, oldDataForIndex, newDataForIndex)
@@ -508,6 +927,69 @@ func (s *InMemStore) Set(key string, value []byte, ttl time.Duration) {
 	slog.Debug("Item set", "shard_id", s.getShardIndex(key), "key", key, "is_update", isUpdate)
 }
 
+// UpdateIf atomically replaces the value of an existing key.
+// The update function runs under the shard lock with the current value and returns
+// the new value and whether it should be applied, so check-and-set decisions cannot race
+// with other writers. found is false if the key is missing or expired.
+func (s *InMemStore) UpdateIf(key string, update func(current []byte) ([]byte, bool)) (found, applied bool, err error) {
+	shard := s.getShard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	oldItem, exists := shard.data[key]
+	if !exists || (oldItem.TTL > 0 && time.Since(oldItem.CreatedAt) > oldItem.TTL) {
+		return false, false, nil
+	}
+	if ownerTxID, isLocked := shard.keyLocks[key]; isLocked {
+		return true, false, fmt.Errorf("key '%s' is locked by an active transaction '%s'", key, ownerTxID)
+	}
+
+	current := oldItem.value()
+	newValue, ok := update(current)
+	if !ok {
+		return true, false, nil
+	}
+
+	shard.put(key, s.makeItem(newValue, oldItem.CreatedAt, oldItem.TTL))
+	oldDataForIndex := tryUnmarshal(current)
+	newDataForIndex := tryUnmarshal(newValue)
+	if oldDataForIndex != nil || newDataForIndex != nil {
+		s.indexes.Update(key, oldDataForIndex, newDataForIndex)
+	}
+
+	slog.Debug("Item conditionally updated", "shard_id", s.getShardIndex(key), "key", key)
+	return true, true, nil
+}
+
+// SetIfAbsent stores a value only if the key is missing or expired, checking and writing under the
+// shard lock so two writers cannot both create the key. set is false if the key exists.
+func (s *InMemStore) SetIfAbsent(key string, value []byte, ttl time.Duration) (set bool, err error) {
+	shard := s.getShard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if ownerTxID, isLocked := shard.keyLocks[key]; isLocked {
+		return false, fmt.Errorf("key '%s' is locked by an active transaction '%s'", key, ownerTxID)
+	}
+	oldItem, exists := shard.data[key]
+	if exists && (oldItem.TTL == 0 || time.Since(oldItem.CreatedAt) <= oldItem.TTL) {
+		return false, nil
+	}
+
+	shard.put(key, s.makeItem(value, time.Now(), ttl))
+	var oldDataForIndex map[string]any
+	if exists {
+		oldDataForIndex = tryUnmarshal(oldItem.value())
+	}
+	newDataForIndex := tryUnmarshal(value)
+	if oldDataForIndex != nil || newDataForIndex != nil {
+		s.indexes.Update(key, oldDataForIndex, newDataForIndex)
+	}
+
+	slog.Debug("Item set if absent", "shard_id", s.getShardIndex(key), "key", key)
+	return true, nil
+}
+
 // Get retrieves a value from the store by its key.
 func (s *InMemStore) Get(key string) ([]byte, bool) {
 	shard := s.getShard(key)
@@ -526,7 +1008,8 @@ func (s *InMemStore) Get(key string) ([]byte, bool) {
 	}
 
 	slog.Debug("Item get", "shard_id", s.getShardIndex(key), "key", key, "status", "found")
-	return item.Value, true
+	item.access.touch()
+	return item.value(), true
 }
 
 // GetMany retrieves multiple keys concurrently by grouping them by shard.
@@ -558,7 +1041,8 @@ func (s *InMemStore) GetMany(keys []string) map[string][]byte {
 				for _, key := range keysInShard {
 					if item, found := shard.data[key]; found {
 						if item.TTL == 0 || now.Before(item.CreatedAt.Add(item.TTL)) {
-							shardResults[key] = item.Value
+							item.access.touch()
+							shardResults[key] = item.value()
 						}
 					}
 				}
@@ -594,9 +1078,9 @@ func (s *InMemStore) Delete(key string) {
 
 	var data map[string]any
 	if item, exists := shard.data[key]; exists {
-		data = tryUnmarshal(item.Value)
+		data = tryUnmarshal(item.value())
 	}
-	delete(shard.data, key)
+	shard.remove(key)
 	shard.mu.Unlock()
 
 	if data != nil {
@@ -606,6 +1090,35 @@ func (s *InMemStore) Delete(key string) {
 	slog.Debug("Item deleted", "shard_id", s.getShardIndex(key), "key", key)
 }
 
+// GetAndDelete atomically removes a key and returns the value it held.
+// Reading and deleting happen under the same shard lock, so concurrent callers
+// can never both receive the same value. found is false if the key is missing or expired.
+func (s *InMemStore) GetAndDelete(key string) (value []byte, found bool, err error) {
+	shard := s.getShard(key)
+	shard.mu.Lock()
+
+	item, exists := shard.data[key]
+	if !exists || (item.TTL > 0 && time.Since(item.CreatedAt) > item.TTL) {
+		shard.mu.Unlock()
+		return nil, false, nil
+	}
+	if ownerTxID, isLocked := shard.keyLocks[key]; isLocked {
+		shard.mu.Unlock()
+		return nil, true, fmt.Errorf("key '%s' is locked by an active transaction '%s'", key, ownerTxID)
+	}
+
+	value = item.value()
+	shard.remove(key)
+	shard.mu.Unlock()
+
+	if data := tryUnmarshal(value); data != nil {
+		s.indexes.Remove(key, data)
+	}
+
+	slog.Debug("Item taken", "shard_id", s.getShardIndex(key), "key", key)
+	return value, true, nil
+}
+
 // GetAll returns a copy of all non-expired data from ALL shards for persistence.
 func (s *InMemStore) GetAll() map[string][]byte {
 	snapshotData := make(map[string][]byte)
@@ -615,6 +1128,10 @@ func (s *InMemStore) GetAll() map[string][]byte {
 		shard.mu.RLock()
 		for k, item := range shard.data {
 			if item.TTL == 0 || now.Before(item.CreatedAt.Add(item.TTL)) {
+				if item.Compressed {
+					snapshotData[k] = item.value()
+					continue
+				}
 				copyValue := make([]byte, len(item.Value))
 				copy(copyValue, item.Value)
 				snapshotData[k] = copyValue
@@ -631,6 +1148,8 @@ func (s *InMemStore) LoadData(data map[string][]byte) {
 	for _, shard := range s.shards {
 		shard.mu.Lock()
 		shard.data = make(map[string]Item)
+		shard.cold = make(map[string]time.Time)
+		shard.bytes.Store(0)
 		shard.mu.Unlock()
 	}
 	slog.Info("All shards cleared for data load")
@@ -638,14 +1157,20 @@ func (s *InMemStore) LoadData(data map[string][]byte) {
 	for k, v := range data {
 		shard := s.getShard(k)
 		shard.mu.Lock()
-		shard.data[k] = Item{
-			Value:     v,
-			CreatedAt: time.Now(),
-			TTL:       0,
-		}
+		shard.put(k, s.makeItem(v, time.Now(), 0))
 		shard.mu.Unlock()
 	}
 	slog.Info("Data loaded into shards", "num_shards", s.numShards, "total_keys", len(data))
+
+	// Indexes defined before the load, such as the defaults of a new collection, are rebuilt
+	// from the loaded data.
+	if s.indexes.clearIndexes() > 0 {
+		for k, v := range data {
+			if doc := tryUnmarshal(v); doc != nil {
+				s.indexes.Update(k, nil, doc)
+			}
+		}
+	}
 }
 
 // CleanExpiredItems iterates through each shard and physically deletes expired items.
@@ -659,11 +1184,11 @@ func (s *InMemStore) CleanExpiredItems() bool {
 		deletedInShard := 0
 		for key, item := range shard.data {
 			if item.TTL > 0 && now.After(item.CreatedAt.Add(item.TTL)) {
-				data := tryUnmarshal(item.Value)
+				data := tryUnmarshal(item.value())
 				if data != nil {
 					s.indexes.Remove(key, data)
 				}
-				delete(shard.data, key)
+				shard.remove(key)
 				deletedInShard++
 				wasModified = true
 			}
@@ -699,12 +1224,28 @@ func (s *InMemStore) Size() int {
 
 // CreateIndex creates an index on a field and backfills it with existing data.
 func (s *InMemStore) CreateIndex(field string) {
-	if s.HasIndex(field) {
+	s.CreateIndexWithOptions(field, IndexOptions{})
+}
+
+// CreateIndexWithOptions creates an index with the given options and backfills it with existing data.
+// The backfill goes through the regular index update, so existing values are normalized the same
+// way as new writes. Nothing happens if an index already exists for the field.
+func (s *InMemStore) CreateIndexWithOptions(field string, options IndexOptions) {
+	if s.indexes.indexExists(field) {
 		slog.Debug("Index creation skipped: already exists", "field", field)
 		return
 	}
-	s.indexes.CreateIndex(field)
+	s.indexes.CreateIndexWithOptions(field, options)
+	s.backfillIndex(field)
+}
 
+// GetIndexOptions returns the options of the index on a field.
+func (s *InMemStore) GetIndexOptions(field string) (IndexOptions, bool) {
+	return s.indexes.GetIndexOptions(field)
+}
+
+// backfillIndex populates an index with the existing hot data.
+func (s *InMemStore) backfillIndex(field string) {
 	slog.Info("Backfilling index", "field", field)
 	allData := s.GetAll()
 	count := 0
@@ -717,6 +1258,23 @@ func (s *InMemStore) CreateIndex(field string) {
 	slog.Info("Index backfill complete", "field", field, "item_count", count)
 }
 
+// DisableIndex stops maintaining an index without deleting its definition.
+// It returns false if no index exists for the field.
+func (s *InMemStore) DisableIndex(field string) bool {
+	found, _ := s.indexes.SetIndexDisabled(field, true)
+	return found
+}
+
+// EnableIndex re-activates a disabled index and backfills it with the current data,
+// so writes made while it was disabled are reflected. It returns false if no index exists for the field.
+func (s *InMemStore) EnableIndex(field string) bool {
+	found, changed := s.indexes.SetIndexDisabled(field, false)
+	if changed {
+		s.backfillIndex(field)
+	}
+	return found
+}
+
 // DeleteIndex removes an index from the store.
 func (s *InMemStore) DeleteIndex(field string) {
 	s.indexes.DeleteIndex(field)
@@ -727,11 +1285,26 @@ func (s *InMemStore) ListIndexes() []string {
 	return s.indexes.ListIndexes()
 }
 
-// HasIndex checks if an index exists on a field.
+// ListDisabledIndexes returns a list of indexed fields that are currently disabled.
+func (s *InMemStore) ListDisabledIndexes() []string {
+	return s.indexes.ListDisabledIndexes()
+}
+
+// HasIndex checks if an active index exists on a field.
 func (s *InMemStore) HasIndex(field string) bool {
 	return s.indexes.HasIndex(field)
 }
 
+// IndexHasArrayValues reports whether the index on a field holds documents by their array elements.
+func (s *InMemStore) IndexHasArrayValues(field string) bool {
+	return s.indexes.HasArrayValues(field)
+}
+
+// IndexHasUnindexedValues reports whether some documents hold a value the index on a field cannot hold.
+func (s *InMemStore) IndexHasUnindexedValues(field string) bool {
+	return s.indexes.HasUnindexedValues(field)
+}
+
 // Lookup uses the index manager to find document keys for an exact value.
 func (s *InMemStore) Lookup(field string, value any) ([]string, bool) {
 	return s.indexes.Lookup(field, value)
@@ -742,12 +1315,33 @@ func (s *InMemStore) LookupRange(field string, low, high any, lowInclusive, high
 	return s.indexes.LookupRange(field, low, high, lowInclusive, highInclusive)
 }
 
+// LookupPrefix uses the index manager to find document keys whose value starts with a prefix.
+func (s *InMemStore) LookupPrefix(field, prefix string) ([]string, bool) {
+	return s.indexes.LookupPrefix(field, prefix)
+}
+
+// ScanIndexOrder lists document positions in the order of the index on field.
+// See IndexManager.ScanOrder.
+func (s *InMemStore) ScanIndexOrder(field string, after *IndexPosition, descending bool, n int) ([]IndexPosition, bool) {
+	return s.indexes.ScanOrder(field, after, descending, n, s.Size())
+}
+
 // --- The rest of the file (CollectionManager, etc.) does not need changes ---
 
 // CollectionPersister defines the interface for persistence operations specific to collections.
 type CollectionPersister interface {
 	SaveCollectionData(collectionName string, s DataStore) error
 	DeleteCollectionFile(collectionName string) error
+	// RenameCollectionFile moves a collection's data file to a new collection name.
+	RenameCollectionFile(oldName, newName string) error
+	// CopyCollectionFile replaces the data file of a collection with a copy of another's.
+	CopyCollectionFile(srcName, dstName string) error
+	// TruncateCollectionFile removes every record from a collection's data file, keeping its header.
+	TruncateCollectionFile(collectionName string) error
+	// WriteColdItems writes items to the collection file, replacing any records with the same keys.
+	WriteColdItems(collectionName string, items map[string][]byte) error
+	// ScanColdDocuments passes every live document of the collection file to visit, until it returns false.
+	ScanColdDocuments(collectionName string, visit func(doc map[string]any) bool) error
 }
 
 // saveTask encapsulates a request to save a collection.
@@ -773,6 +1367,16 @@ type CollectionManager struct {
 	numShards   int
 	fileLocks   map[string]*sync.Mutex
 	fileLocksMu sync.RWMutex
+	// uniqueLocks serializes the writes to collections with unique indexes, see LockUniqueIndexes.
+	uniqueLocks sync.Map
+	health      saveHealth
+	// indexCreatedTs makes every collection index CREATED_TS alongside _id.
+	indexCreatedTs atomic.Bool
+	// memoryCap is the approximate number of bytes each collection may hold in RAM; 0 disables it.
+	memoryCap       atomic.Int64
+	evictionPolicy  atomic.Value
+	memoryCapSignal chan string
+	memoryCapWorker sync.Once
 }
 
 // NewCollectionManager creates a new instance of CollectionManager.
@@ -785,6 +1389,9 @@ func NewCollectionManager(persister CollectionPersister, numShards int) *Collect
 		quit:        make(chan struct{}),
 		numShards:   numShards,
 		fileLocks:   make(map[string]*sync.Mutex),
+		health:      saveHealth{limit: DefaultSaveFailureLimit},
+
+		memoryCapSignal: make(chan string, 1),
 	}
 	cm.StartAsyncWorker()
 	return cm
@@ -796,6 +1403,8 @@ func (cm *CollectionManager) StartAsyncWorker() {
 	go func() {
 		defer cm.wg.Done()
 		slog.Info("Async collection worker started.")
+		retryTicker := time.NewTicker(saveRetryInterval)
+		defer retryTicker.Stop()
 		for {
 			select {
 			case task, ok := <-cm.saveQueue:
@@ -803,44 +1412,37 @@ func (cm *CollectionManager) StartAsyncWorker() {
 					slog.Info("Async save queue closed, stopping worker.")
 					return
 				}
-				fileLock := cm.GetFileLock(task.collectionName)
-				fileLock.Lock()
-				if err := cm.persister.SaveCollectionData(task.collectionName, task.collection); err != nil {
+				if err := cm.saveCollection(task); err != nil {
 					slog.Error("Error saving collection from async task", "collection", task.collectionName, "error", err)
 				}
-				fileLock.Unlock()
+
+			case <-retryTicker.C:
+				if cm.IsReadOnly() {
+					cm.retryFailedSaves()
+				}
 
 			case task, ok := <-cm.deleteQueue:
 				if !ok {
 					slog.Info("Async delete queue closed, stopping worker.")
 					return
 				}
-				fileLock := cm.GetFileLock(task.collectionName)
-				fileLock.Lock()
-				if err := cm.persister.DeleteCollectionFile(task.collectionName); err != nil {
+				if err := cm.deleteCollectionFile(task.collectionName); err != nil {
 					slog.Error("Error deleting collection file from async task", "collection", task.collectionName, "error", err)
 				}
-				fileLock.Unlock()
 
 			case <-cm.quit:
 				slog.Info("Async worker received quit signal. Draining queues...")
 				for len(cm.saveQueue) > 0 {
 					task := <-cm.saveQueue
-					fileLock := cm.GetFileLock(task.collectionName)
-					fileLock.Lock()
-					if err := cm.persister.SaveCollectionData(task.collectionName, task.collection); err != nil {
+					if err := cm.saveCollection(task); err != nil {
 						slog.Error("Error saving collection while draining save queue", "collection", task.collectionName, "error", err)
 					}
-					fileLock.Unlock()
 				}
 				for len(cm.deleteQueue) > 0 {
 					task := <-cm.deleteQueue
-					fileLock := cm.GetFileLock(task.collectionName)
-					fileLock.Lock()
-					if err := cm.persister.DeleteCollectionFile(task.collectionName); err != nil {
+					if err := cm.deleteCollectionFile(task.collectionName); err != nil {
 						slog.Error("Error deleting collection file while draining delete queue", "collection", task.collectionName, "error", err)
 					}
-					fileLock.Unlock()
 				}
 				slog.Info("Async collection worker stopped.")
 				return
@@ -859,14 +1461,9 @@ func (cm *CollectionManager) Wait() {
 func (cm *CollectionManager) EnqueueSaveTask(collectionName string, col DataStore) {
 	tempStore := NewInMemStoreWithShards(cm.numShards)
 	tempStore.LoadData(col.GetAll())
-
-	originalIndexes := col.ListIndexes()
-	if len(originalIndexes) > 0 {
-
-		for _, fieldName := range originalIndexes {
-			tempStore.CreateIndex(fieldName)
-		}
-	}
+	copyIndexDefinitions(tempStore, col)
+	tempStore.MarkCold(col.ColdKeys()...)
+	tempStore.SetFileCodec(col.FileCodec())
 
 	task := saveTask{
 		collectionName: collectionName,
@@ -878,6 +1475,7 @@ func (cm *CollectionManager) EnqueueSaveTask(collectionName string, col DataStor
 	default:
 		slog.Warn("Save queue is full, dropping task", "collection", collectionName)
 	}
+	cm.signalMemoryCap(collectionName, col)
 }
 
 // EnqueueDeleteTask adds a collection delete request to the asynchronous queue.
@@ -910,13 +1508,52 @@ func (cm *CollectionManager) GetCollection(name string) DataStore {
 		return col
 	}
 
-	newCol := NewInMemStoreWithShards(cm.numShards)
-	newCol.CreateIndex(globalconst.ID)
+	newCol := cm.newCollectionStore()
 	cm.collections[name] = newCol
 	slog.Info("Collection created", "name", name, "num_shards", cm.numShards)
 	return newCol
 }
 
+// newCollectionStore creates an empty collection store with the default indexes.
+func (cm *CollectionManager) newCollectionStore() *InMemStore {
+	col := NewInMemStoreWithShards(cm.numShards)
+	col.setAccessTracking(cm.memoryCap.Load() > 0)
+	col.CreateIndex(globalconst.ID)
+	if cm.indexCreatedTs.Load() {
+		col.CreateIndex(globalconst.CREATED_TS)
+	}
+	return col
+}
+
+// ReloadCollection replaces the in-memory store of an existing collection with a fresh one
+// filled by load. It holds the collection's file lock so no save can interleave with the load.
+// The old store is kept if load fails. The compression settings carry over to the new store.
+func (cm *CollectionManager) ReloadCollection(name string, load func(col DataStore) error) (DataStore, error) {
+	fileLock := cm.GetFileLock(name)
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	cm.mu.RLock()
+	oldCol, found := cm.collections[name]
+	cm.mu.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("collection '%s' does not exist", name)
+	}
+
+	newCol := cm.newCollectionStore()
+	newCol.SetCompression(oldCol.IsCompressionEnabled())
+	newCol.SetFileCodec(oldCol.FileCodec())
+	if err := load(newCol); err != nil {
+		return nil, err
+	}
+
+	cm.mu.Lock()
+	cm.collections[name] = newCol
+	cm.mu.Unlock()
+	slog.Info("Collection reloaded", "name", name, "items", newCol.Size())
+	return newCol, nil
+}
+
 // DeleteCollection removes a collection entirely from the manager.
 func (cm *CollectionManager) DeleteCollection(name string) {
 	cm.mu.Lock()
@@ -1000,7 +1637,7 @@ func (s *InMemStore) EvictColdData(collectionName string, threshold time.Time) {
 		evictedInShard := 0
 		for key, item := range shard.data {
 			var doc map[string]any
-			if err := jsoniter.Unmarshal(item.Value, &doc); err != nil {
+			if err := jsoniter.Unmarshal(item.value(), &doc); err != nil {
 				continue
 			}
 
@@ -1016,7 +1653,8 @@ func (s *InMemStore) EvictColdData(collectionName string, threshold time.Time) {
 
 			if createdAt.Before(threshold) {
 				s.indexes.Remove(key, doc)
-				delete(shard.data, key)
+				shard.remove(key)
+				shard.cold[key] = item.expiresAt()
 				evictedInShard++
 			}
 		}
@@ -1071,7 +1709,8 @@ func (s *Shard) lockKeys(txID string, keys []string) error {
 }
 
 // prepareWrite stores changes in the "pendingWrites" area.
-func (s *Shard) prepareWrite(txID string, op WriteOperation) error {
+// compress reports whether the owning collection stores values compressed.
+func (s *Shard) prepareWrite(txID string, op WriteOperation, compress bool) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -1079,6 +1718,16 @@ func (s *Shard) prepareWrite(txID string, op WriteOperation) error {
 		return fmt.Errorf("cannot prepare write for key '%s': not locked by transaction '%s'", op.Key, txID)
 	}
 
+	if op.Precondition != nil {
+		var current []byte
+		if existingItem, exists := s.data[op.Key]; exists {
+			current = existingItem.value()
+		}
+		if !op.Precondition(current) {
+			return fmt.Errorf("precondition no longer holds for key '%s'", op.Key)
+		}
+	}
+
 	if _, exists := s.pendingWrites[txID]; !exists {
 		s.pendingWrites[txID] = make(map[string]Item)
 	}
@@ -1091,11 +1740,7 @@ func (s *Shard) prepareWrite(txID string, op WriteOperation) error {
 		if existingItem, exists := s.data[op.Key]; exists {
 			createdAt = existingItem.CreatedAt
 		}
-		pendingItem = Item{
-			Value:     op.Value,
-			CreatedAt: createdAt,
-			TTL:       0,
-		}
+		pendingItem = newItem(op.Value, createdAt, 0, compress)
 	}
 
 	s.pendingWrites[txID][op.Key] = pendingItem
@@ -1120,17 +1765,17 @@ func (s *Shard) commitAppliedChanges(txID string, indexManager *IndexManager) {
 	for key, newItem := range pendingOps {
 		var oldDataForIndex map[string]any
 		if oldItem, exists := s.data[key]; exists && oldItem.Value != nil {
-			oldDataForIndex = tryUnmarshal(oldItem.Value)
+			oldDataForIndex = tryUnmarshal(oldItem.value())
 		}
 
 		if newItem.Value == nil {
-			delete(s.data, key)
+			s.remove(key)
 			if oldDataForIndex != nil {
 				indexManager.Remove(key, oldDataForIndex)
 			}
 		} else {
-			s.data[key] = newItem
-			newDataForIndex := tryUnmarshal(newItem.Value)
+			s.put(key, newItem)
+			newDataForIndex := tryUnmarshal(newItem.value())
 			indexManager.Update(key, oldDataForIndex, newDataForIndex)
 		}
 
@@ -1140,22 +1785,17 @@ func (s *Shard) commitAppliedChanges(txID string, indexManager *IndexManager) {
 	delete(s.pendingWrites, txID)
 }
 
-// rollbackChanges discards pending changes and releases locks.
+// rollbackChanges discards pending changes and releases locks. Every lock the transaction holds
+// is released, including those of keys whose write was never prepared.
 func (s *Shard) rollbackChanges(txID string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if pendingOps, ok := s.pendingWrites[txID]; ok {
-		for key := range pendingOps {
+	delete(s.pendingWrites, txID)
+	for key, owner := range s.keyLocks {
+		if owner == txID {
 			delete(s.keyLocks, key)
 		}
-		delete(s.pendingWrites, txID)
-	} else {
-		for key, owner := range s.keyLocks {
-			if owner == txID {
-				delete(s.keyLocks, key)
-			}
-		}
 	}
 }
 
@@ -1168,7 +1808,7 @@ func (s *InMemStore) StreamAll(callback func(key string, value []byte) bool) {
 		shard.mu.RLock()
 		for k, item := range shard.data {
 			if item.TTL == 0 || now.Before(item.CreatedAt.Add(item.TTL)) {
-				if !callback(k, item.Value) {
+				if !callback(k, item.value()) {
 					keepGoing = false
 					break
 				}
@@ -1180,3 +1820,68 @@ func (s *InMemStore) StreamAll(callback func(key string, value []byte) bool) {
 		}
 	}
 }
+
+// Keys returns the keys of all non-expired items without reading their values.
+func (s *InMemStore) Keys() []string {
+	now := time.Now()
+	keys := make([]string, 0, s.Size())
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		for k, item := range shard.data {
+			if item.TTL == 0 || now.Before(item.CreatedAt.Add(item.TTL)) {
+				keys = append(keys, k)
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	return keys
+}
+
+// KeysWithPrefix returns the non-expired keys in memory that start with prefix and sort after
+// after, in ascending order. An empty after starts from the first key.
+func (s *InMemStore) KeysWithPrefix(prefix, after string) []string {
+	now := time.Now()
+	var keys []string
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		for k, item := range shard.data {
+			if strings.HasPrefix(k, prefix) && k > after && (item.TTL == 0 || now.Before(item.CreatedAt.Add(item.TTL))) {
+				keys = append(keys, k)
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// ParallelStreamAll iterates through all non-expired items using one goroutine per shard.
+// The callback receives the index of the shard being scanned, so callers can collect
+// results into per-shard buffers without locking. Returning false stops only that shard.
+// The value slice must not be retained after the callback returns.
+func (s *InMemStore) ParallelStreamAll(callback func(shardIndex int, key string, value []byte) bool) {
+	now := time.Now()
+	var wg sync.WaitGroup
+
+	for i, shard := range s.shards {
+		wg.Add(1)
+		go func(shardIndex int, shard *Shard) {
+			defer wg.Done()
+			shard.mu.RLock()
+			defer shard.mu.RUnlock()
+			for k, item := range shard.data {
+				if item.TTL == 0 || now.Before(item.CreatedAt.Add(item.TTL)) {
+					if !callback(shardIndex, k, item.value()) {
+						return
+					}
+				}
+			}
+		}(i, shard)
+	}
+	wg.Wait()
+}
+
+// ShardCount returns the number of shards in the store.
+func (s *InMemStore) ShardCount() int {
+	return s.numShards
+}
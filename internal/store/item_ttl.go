@@ -0,0 +1,50 @@
+package store
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// TTL returns the time an item has left before it expires. hasExpiry is false for items stored
+// without a TTL. found is false if the key is missing or already expired.
+func (s *InMemStore) TTL(key string) (remaining time.Duration, hasExpiry, found bool) {
+	shard := s.getShard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	item, exists := shard.data[key]
+	if !exists {
+		return 0, false, false
+	}
+	if item.TTL == 0 {
+		return 0, false, true
+	}
+	remaining = time.Until(item.CreatedAt.Add(item.TTL))
+	if remaining <= 0 {
+		return 0, false, false
+	}
+	return remaining, true, true
+}
+
+// Touch restarts an item's time to live without rewriting its value: the item expires ttl from
+// now, or never if ttl is zero. found is false if the key is missing or already expired.
+func (s *InMemStore) Touch(key string, ttl time.Duration) (found bool, err error) {
+	shard := s.getShard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	item, exists := shard.data[key]
+	if !exists || (item.TTL > 0 && time.Since(item.CreatedAt) > item.TTL) {
+		return false, nil
+	}
+	if ownerTxID, isLocked := shard.keyLocks[key]; isLocked {
+		return true, fmt.Errorf("key '%s' is locked by an active transaction '%s'", key, ownerTxID)
+	}
+	item.CreatedAt = time.Now()
+	item.TTL = ttl
+	shard.put(key, item)
+
+	slog.Debug("Item TTL touched", "shard_id", s.getShardIndex(key), "key", key, "ttl", ttl)
+	return true, nil
+}
@@ -0,0 +1,387 @@
+package store
+
+import (
+	"bytes"
+	"log/slog"
+	"maps"
+	"slices"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// EvictionPolicy selects which items leave RAM first when a collection exceeds its memory cap.
+type EvictionPolicy string
+
+const (
+	// EvictionLRU evicts the items that were read or written least recently.
+	EvictionLRU EvictionPolicy = "lru"
+	// EvictionLFU evicts the items that were used least often. Use counts are halved on every
+	// eviction run, so items that were popular long ago do not stay in RAM forever.
+	EvictionLFU EvictionPolicy = "lfu"
+)
+
+const (
+	// entryOverhead approximates the bytes an entry costs beyond its key and value:
+	// the map slot, the Item struct and its access record.
+	entryOverhead = 96
+	// memoryCapCheckInterval is how often the memory cap worker checks every collection.
+	memoryCapCheckInterval = time.Second
+	// evictionHeadroom is the fraction of the cap freed below it by an eviction run, so a
+	// collection growing at the cap is not evicted again on every write.
+	evictionHeadroom = 10
+)
+
+// itemAccess records how an item is used, to choose eviction victims. It is shared by the
+// successive versions of an item, so updates keep its history.
+type itemAccess struct {
+	lastUsed atomic.Int64 // Unix nanoseconds of the latest read or write.
+	hits     atomic.Uint32
+}
+
+// touch records one use of an item. It is safe on a nil record, which untracked stores use.
+func (a *itemAccess) touch() {
+	if a == nil {
+		return
+	}
+	a.lastUsed.Store(time.Now().UnixNano())
+	a.hits.Add(1)
+}
+
+// entrySize approximates the memory held by an entry.
+func entrySize(key string, item Item) int64 {
+	return int64(len(key)+len(item.Value)) + entryOverhead
+}
+
+// put stores an item, keeping the shard's byte count and the item's access record up to date.
+// A key written to memory is no longer cold. The shard lock must be held.
+func (sh *Shard) put(key string, item Item) {
+	old, exists := sh.data[key]
+	if exists {
+		sh.bytes.Add(-entrySize(key, old))
+	}
+	if sh.trackAccess {
+		item.access = old.access
+		if item.access == nil {
+			item.access = &itemAccess{}
+		}
+		item.access.touch()
+	}
+	delete(sh.cold, key)
+	sh.data[key] = item
+	sh.bytes.Add(entrySize(key, item))
+}
+
+// remove deletes an item, keeping the shard's byte count up to date. The shard lock must be held.
+func (sh *Shard) remove(key string) {
+	if old, exists := sh.data[key]; exists {
+		sh.bytes.Add(-entrySize(key, old))
+		delete(sh.data, key)
+	}
+}
+
+// MemoryStats describes the memory held by a store.
+type MemoryStats struct {
+	Items     int   `json:"items"`
+	Bytes     int64 `json:"bytes"`      // Approximate: keys, stored values and a fixed per-entry overhead.
+	ColdItems int   `json:"cold_items"` // Items held only in the collection file.
+	Evicted   int64 `json:"evicted"`    // Items moved to disk by the memory cap since startup.
+}
+
+// MemoryStats returns the approximate memory usage of the store.
+func (s *InMemStore) MemoryStats() MemoryStats {
+	stats := MemoryStats{Bytes: s.memoryUsage(), Evicted: s.evicted.Load()}
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		stats.Items += len(shard.data)
+		stats.ColdItems += len(shard.cold)
+		shard.mu.RUnlock()
+	}
+	return stats
+}
+
+// memoryUsage returns the approximate number of bytes held by the store's items.
+func (s *InMemStore) memoryUsage() int64 {
+	var total int64
+	for _, shard := range s.shards {
+		total += shard.bytes.Load()
+	}
+	return total
+}
+
+// setAccessTracking makes the store record when and how often each item is used.
+// Items already in the store get a record on their next use.
+func (s *InMemStore) setAccessTracking(enabled bool) {
+	for _, shard := range s.shards {
+		shard.mu.Lock()
+		shard.trackAccess = enabled
+		shard.mu.Unlock()
+	}
+}
+
+// --- Cold key tracking ---
+
+// MarkCold records keys whose only copy is in the collection file, so saves carry them over.
+func (s *InMemStore) MarkCold(keys ...string) {
+	for _, key := range keys {
+		shard := s.getShard(key)
+		shard.mu.Lock()
+		shard.cold[key] = time.Time{}
+		shard.mu.Unlock()
+	}
+}
+
+// PromoteCold loads the value of a cold key back into memory, with the expiry the item had when it
+// was moved to disk. It returns false, leaving the key cold, if the key is in memory already, is
+// locked by a transaction or has expired since.
+func (s *InMemStore) PromoteCold(key string, value []byte) bool {
+	shard := s.getShard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if _, inHot := shard.data[key]; inHot {
+		return false
+	}
+	if _, locked := shard.keyLocks[key]; locked {
+		return false
+	}
+	now := time.Now()
+	var ttl time.Duration
+	if expiry := shard.cold[key]; !expiry.IsZero() {
+		if ttl = expiry.Sub(now); ttl <= 0 {
+			return false
+		}
+	}
+	shard.put(key, s.makeItem(value, now, ttl))
+	if data := tryUnmarshal(value); data != nil {
+		s.indexes.Update(key, nil, data)
+	}
+	return true
+}
+
+// IsColdKey reports whether a key is held only in the collection file.
+func (s *InMemStore) IsColdKey(key string) bool {
+	shard := s.getShard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	_, cold := shard.cold[key]
+	return cold
+}
+
+// ColdKeys returns the keys held only in the collection file.
+func (s *InMemStore) ColdKeys() []string {
+	var keys []string
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		keys = slices.AppendSeq(keys, maps.Keys(shard.cold))
+		shard.mu.RUnlock()
+	}
+	return keys
+}
+
+// ColdKeyCount returns the number of keys held only in the collection file.
+func (s *InMemStore) ColdKeyCount() int {
+	count := 0
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		count += len(shard.cold)
+		shard.mu.RUnlock()
+	}
+	return count
+}
+
+// --- Eviction ---
+
+// evictionCandidate is an item considered for eviction, as it was when candidates were collected.
+type evictionCandidate struct {
+	key      string
+	shard    *Shard
+	item     Item
+	lastUsed int64
+	hits     uint32
+}
+
+// selectEvictionVictims picks the items to evict to free at least need bytes, coldest first
+// according to the policy. Items locked by a transaction or already expired are never picked.
+func (s *InMemStore) selectEvictionVictims(need int64, policy EvictionPolicy) []evictionCandidate {
+	now := time.Now()
+	var candidates []evictionCandidate
+	for _, shard := range s.shards {
+		shard.mu.RLock()
+		for key, item := range shard.data {
+			if _, locked := shard.keyLocks[key]; locked {
+				continue
+			}
+			if item.TTL > 0 && now.After(item.CreatedAt.Add(item.TTL)) {
+				continue
+			}
+			candidate := evictionCandidate{key: key, shard: shard, item: item}
+			if item.access != nil {
+				candidate.lastUsed = item.access.lastUsed.Load()
+				candidate.hits = item.access.hits.Load()
+				if policy == EvictionLFU {
+					item.access.hits.Store(candidate.hits / 2)
+				}
+			}
+			candidates = append(candidates, candidate)
+		}
+		shard.mu.RUnlock()
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if policy == EvictionLFU && a.hits != b.hits {
+			return a.hits < b.hits
+		}
+		return a.lastUsed < b.lastUsed
+	})
+
+	var freed int64
+	for i, candidate := range candidates {
+		if freed >= need {
+			return candidates[:i]
+		}
+		freed += entrySize(candidate.key, candidate.item)
+	}
+	return candidates
+}
+
+// dropEvicted removes victims that were written to the collection file from memory and marks them
+// cold. A victim changed or locked since it was selected stays in memory: the copy on disk is stale,
+// and the next save replaces it. It returns the number of items removed.
+func (s *InMemStore) dropEvicted(victims []evictionCandidate) int {
+	dropped := 0
+	for _, victim := range victims {
+		shard := victim.shard
+		shard.mu.Lock()
+		current, exists := shard.data[victim.key]
+		_, locked := shard.keyLocks[victim.key]
+		if !exists || locked || current.Compressed != victim.item.Compressed || !bytes.Equal(current.Value, victim.item.Value) {
+			shard.mu.Unlock()
+			continue
+		}
+		if data := tryUnmarshal(current.value()); data != nil {
+			s.indexes.Remove(victim.key, data)
+		}
+		shard.remove(victim.key)
+		shard.cold[victim.key] = current.expiresAt()
+		shard.mu.Unlock()
+		dropped++
+	}
+	s.evicted.Add(int64(dropped))
+	return dropped
+}
+
+// --- CollectionManager integration ---
+
+// SetMemoryCap limits the approximate bytes each collection may hold in RAM. When a collection
+// goes over the cap, its coldest items according to policy are written to the collection file and
+// removed from memory; they are then served from disk like cold data. A cap of 0 disables eviction.
+// Call it before collections are loaded so that every item is tracked from the start.
+func (cm *CollectionManager) SetMemoryCap(maxBytes int64, policy EvictionPolicy) {
+	cm.memoryCap.Store(max(maxBytes, 0))
+	cm.evictionPolicy.Store(policy)
+
+	cm.mu.RLock()
+	for _, col := range cm.collections {
+		if inMemStore, ok := col.(*InMemStore); ok {
+			inMemStore.setAccessTracking(maxBytes > 0)
+		}
+	}
+	cm.mu.RUnlock()
+
+	if maxBytes > 0 {
+		cm.memoryCapWorker.Do(cm.startMemoryCapWorker)
+		slog.Info("Collection memory cap enabled", "max_bytes", maxBytes, "policy", policy)
+	}
+}
+
+// MemoryCap returns the per-collection memory cap and the eviction policy. A cap of 0 means disabled.
+func (cm *CollectionManager) MemoryCap() (int64, EvictionPolicy) {
+	policy, _ := cm.evictionPolicy.Load().(EvictionPolicy)
+	if policy == "" {
+		policy = EvictionLRU
+	}
+	return cm.memoryCap.Load(), policy
+}
+
+// signalMemoryCap wakes the memory cap worker if a collection has grown past the cap.
+func (cm *CollectionManager) signalMemoryCap(collectionName string, col DataStore) {
+	limit := cm.memoryCap.Load()
+	if limit <= 0 {
+		return
+	}
+	inMemStore, ok := col.(*InMemStore)
+	if !ok || inMemStore.memoryUsage() <= limit {
+		return
+	}
+	select {
+	case cm.memoryCapSignal <- collectionName:
+	default:
+		// The worker is busy or already signalled; its periodic check will catch up.
+	}
+}
+
+// startMemoryCapWorker launches the goroutine that enforces the memory cap, both when a write
+// signals that a collection went over it and periodically for every collection.
+func (cm *CollectionManager) startMemoryCapWorker() {
+	cm.wg.Add(1)
+	go func() {
+		defer cm.wg.Done()
+		ticker := time.NewTicker(memoryCapCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case name := <-cm.memoryCapSignal:
+				cm.enforceMemoryCap(name)
+			case <-ticker.C:
+				for _, name := range cm.ListCollections() {
+					cm.enforceMemoryCap(name)
+				}
+			case <-cm.quit:
+				return
+			}
+		}
+	}()
+}
+
+// enforceMemoryCap evicts items from a collection until it is back under the cap with some
+// headroom. Victims are written to the collection file before they leave memory, under the file
+// lock so no save can interleave.
+func (cm *CollectionManager) enforceMemoryCap(collectionName string) {
+	limit, policy := cm.MemoryCap()
+	if limit <= 0 {
+		return
+	}
+	cm.mu.RLock()
+	col, found := cm.collections[collectionName]
+	cm.mu.RUnlock()
+	inMemStore, ok := col.(*InMemStore)
+	if !found || !ok {
+		return
+	}
+	used := inMemStore.memoryUsage()
+	if used <= limit {
+		return
+	}
+
+	fileLock := cm.GetFileLock(collectionName)
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	victims := inMemStore.selectEvictionVictims(used-(limit-limit/evictionHeadroom), policy)
+	if len(victims) == 0 {
+		return
+	}
+	values := make(map[string][]byte, len(victims))
+	for _, victim := range victims {
+		values[victim.key] = victim.item.value()
+	}
+	if err := cm.persister.WriteColdItems(collectionName, values); err != nil {
+		slog.Error("Failed to write evicted items to disk, keeping them in memory", "collection", collectionName, "error", err)
+		return
+	}
+	dropped := inMemStore.dropEvicted(victims)
+	slog.Info("Evicted items to enforce the collection memory cap", "collection", collectionName, "policy", policy,
+		"evicted", dropped, "bytes_before", used, "bytes_after", inMemStore.memoryUsage(), "max_bytes", limit)
+}
@@ -0,0 +1,69 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+// evictedStore returns a store whose only item, set with ttl, has been moved to disk.
+func evictedStore(t *testing.T, ttl time.Duration) (*InMemStore, []byte) {
+	t.Helper()
+	s := NewInMemStoreWithShards(1)
+	value := []byte(`{"_id":"k","created_at":"2000-01-01T00:00:00Z"}`)
+	s.Set("k", value, ttl)
+	s.EvictColdData("items", time.Now())
+	if !s.IsColdKey("k") {
+		t.Fatal("item was not evicted")
+	}
+	return s, value
+}
+
+func TestPromoteColdKeepsExpiry(t *testing.T) {
+	s, value := evictedStore(t, time.Hour)
+
+	if !s.PromoteCold("k", value) {
+		t.Fatal("item was not promoted")
+	}
+	if s.IsColdKey("k") {
+		t.Fatal("promoted item is still cold")
+	}
+	item := s.shards[0].data["k"]
+	if item.TTL <= 59*time.Minute || item.TTL > time.Hour {
+		t.Fatalf("TTL after promotion = %s, want what was left of an hour", item.TTL)
+	}
+}
+
+func TestPromoteColdWithoutTTLNeverExpires(t *testing.T) {
+	s, value := evictedStore(t, 0)
+
+	if !s.PromoteCold("k", value) {
+		t.Fatal("item was not promoted")
+	}
+	if ttl := s.shards[0].data["k"].TTL; ttl != 0 {
+		t.Fatalf("TTL after promotion = %s, want none", ttl)
+	}
+}
+
+func TestPromoteColdSkipsExpiredItem(t *testing.T) {
+	s, value := evictedStore(t, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	if s.PromoteCold("k", value) {
+		t.Fatal("expired item was promoted")
+	}
+	if _, found := s.Get("k"); found {
+		t.Fatal("expired item is in memory")
+	}
+}
+
+func TestPromoteColdLeavesHotItem(t *testing.T) {
+	s := NewInMemStoreWithShards(1)
+	s.Set("k", []byte(`{"v":2}`), 0)
+
+	if s.PromoteCold("k", []byte(`{"v":1}`)) {
+		t.Fatal("a hot item was replaced by its copy on disk")
+	}
+	if value, _ := s.Get("k"); string(value) != `{"v":2}` {
+		t.Fatalf("value = %s, want the hot one", value)
+	}
+}
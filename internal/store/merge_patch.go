@@ -0,0 +1,34 @@
+package store
+
+// MergePatch applies an RFC 7386 JSON merge patch to doc in place: nested objects are merged
+// recursively, any other value replaces the field, and null removes it. The top-level _id,
+// version and creation time fields are never changed.
+func MergePatch(doc map[string]any, patch map[string]any) {
+	for k, v := range patch {
+		if IsManagedField(k) {
+			continue
+		}
+		mergeField(doc, k, v)
+	}
+}
+
+func mergeField(target map[string]any, field string, value any) {
+	if value == nil {
+		delete(target, field)
+		return
+	}
+	patchObj, ok := value.(map[string]any)
+	if !ok {
+		target[field] = value
+		return
+	}
+	// A non-object target is replaced by an empty object before merging, as the RFC specifies.
+	targetObj, ok := target[field].(map[string]any)
+	if !ok {
+		targetObj = make(map[string]any, len(patchObj))
+	}
+	for k, v := range patchObj {
+		mergeField(targetObj, k, v)
+	}
+	target[field] = targetObj
+}
@@ -0,0 +1,33 @@
+package store
+
+import "strings"
+
+// NestedValue returns the value at a dot-separated path of a document, e.g. "address.city".
+// An array holding a single object is stepped through as if it were the object; a path
+// through any other array has no value. Queries and indexes resolve fields the same way,
+// so an index on a nested field finds exactly the documents a filter on it matches.
+func NestedValue(data map[string]any, path string) (any, bool) {
+	if !strings.Contains(path, ".") {
+		value, found := data[path]
+		return value, found
+	}
+	var current any = data
+	for part := range strings.SplitSeq(path, ".") {
+		if currentSlice, ok := current.([]any); ok && len(currentSlice) == 1 {
+			current = currentSlice[0]
+		} else if currentMapSlice, ok := current.([]map[string]any); ok && len(currentMapSlice) == 1 {
+			current = currentMapSlice[0]
+		}
+
+		currentMap, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		value, found := currentMap[part]
+		if !found {
+			return nil, false
+		}
+		current = value
+	}
+	return current, true
+}
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DefaultSaveFailureLimit is the number of consecutive failed saves after which the
+// collection manager switches the server to read-only mode.
+const DefaultSaveFailureLimit = 5
+
+// saveRetryInterval is how often saves that failed are retried while in read-only mode.
+const saveRetryInterval = 10 * time.Second
+
+// saveHealth tracks consecutive persistence failures of the async worker.
+// Once the limit is reached the manager becomes read-only, so RAM cannot drift
+// further away from disk, until a retried save succeeds again.
+type saveHealth struct {
+	mu                  sync.Mutex
+	limit               int
+	consecutiveFailures int
+	readOnly            bool
+	lastError           error
+	// failed holds the most recent snapshot of every collection whose save failed.
+	failed map[string]saveTask
+}
+
+// SetSaveFailureLimit sets how many consecutive save failures trigger read-only mode.
+// A limit of 0 disables the automatic switch.
+func (cm *CollectionManager) SetSaveFailureLimit(limit int) {
+	cm.health.mu.Lock()
+	defer cm.health.mu.Unlock()
+	cm.health.limit = limit
+}
+
+// IsReadOnly reports whether writes are currently rejected because saves keep failing.
+func (cm *CollectionManager) IsReadOnly() bool {
+	cm.health.mu.Lock()
+	defer cm.health.mu.Unlock()
+	return cm.health.readOnly
+}
+
+// ReadOnlyReason returns the last persistence error that caused read-only mode, if any.
+func (cm *CollectionManager) ReadOnlyReason() error {
+	cm.health.mu.Lock()
+	defer cm.health.mu.Unlock()
+	if !cm.health.readOnly {
+		return nil
+	}
+	return cm.health.lastError
+}
+
+// saveCollection persists a snapshot under its collection file lock and records the outcome.
+// Items evicted to disk after the snapshot was taken are saved from the file, where their
+// latest version is, rather than from the snapshot. A snapshot of a collection deleted or
+// renamed since it was queued is dropped, as writing it would bring the old name back.
+func (cm *CollectionManager) saveCollection(task saveTask) error {
+	fileLock := cm.GetFileLock(task.collectionName)
+	fileLock.Lock()
+	cm.mu.RLock()
+	live, found := cm.collections[task.collectionName]
+	cm.mu.RUnlock()
+	if !found {
+		fileLock.Unlock()
+		slog.Debug("Dropping save of a collection that no longer exists", "collection", task.collectionName)
+		return nil
+	}
+	if live != task.collection {
+		task.collection.MarkCold(live.ColdKeys()...)
+	}
+	err := cm.persister.SaveCollectionData(task.collectionName, task.collection)
+	fileLock.Unlock()
+	cm.recordSaveResult(task, err)
+	return err
+}
+
+// recordSaveResult updates the failure counter and enters or leaves read-only mode.
+func (cm *CollectionManager) recordSaveResult(task saveTask, err error) {
+	h := &cm.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err != nil {
+		h.consecutiveFailures++
+		h.lastError = err
+		if h.failed == nil {
+			h.failed = make(map[string]saveTask)
+		}
+		h.failed[task.collectionName] = task
+		if !h.readOnly && h.limit > 0 && h.consecutiveFailures >= h.limit {
+			h.readOnly = true
+			slog.Error("CRITICAL: Persistence keeps failing, entering read-only mode. Writes will be rejected until saves succeed again.",
+				"consecutive_failures", h.consecutiveFailures, "error", err)
+		}
+		return
+	}
+
+	h.consecutiveFailures = 0
+	delete(h.failed, task.collectionName)
+	if h.readOnly && len(h.failed) == 0 {
+		h.readOnly = false
+		h.lastError = nil
+		slog.Info("Persistence recovered, leaving read-only mode.")
+	}
+}
+
+// retryFailedSaves saves again every collection whose last save failed.
+func (cm *CollectionManager) retryFailedSaves() {
+	cm.health.mu.Lock()
+	tasks := make([]saveTask, 0, len(cm.health.failed))
+	for _, task := range cm.health.failed {
+		tasks = append(tasks, task)
+	}
+	cm.health.mu.Unlock()
+
+	for _, task := range tasks {
+		if !cm.CollectionExists(task.collectionName) {
+			// The collection was deleted meanwhile, so there is nothing left to persist.
+			cm.recordSaveResult(task, nil)
+			continue
+		}
+		if err := cm.saveCollection(task); err != nil {
+			slog.Warn("Retry of failed collection save did not succeed", "collection", task.collectionName, "error", err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package store
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingPersister is a discardPersister whose saves fail while failing is set.
+type failingPersister struct {
+	discardPersister
+	failing atomic.Bool
+	saves   atomic.Int64
+}
+
+func (p *failingPersister) SaveCollectionData(string, DataStore) error {
+	p.saves.Add(1)
+	if p.failing.Load() {
+		return errors.New("disk is read-only")
+	}
+	return nil
+}
+
+func TestRepeatedSaveFailuresEnterReadOnlyMode(t *testing.T) {
+	persister := &failingPersister{}
+	persister.failing.Store(true)
+	cm := NewCollectionManager(persister, 4)
+	cm.SetSaveFailureLimit(3)
+	cm.StartAsyncWorker()
+	defer cm.Wait()
+
+	col := cm.GetCollection("items")
+	save := func(n int64) {
+		t.Helper()
+		want := persister.saves.Load() + n
+		for range n {
+			cm.EnqueueSaveTask("items", col)
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for persister.saves.Load() < want {
+			if time.Now().After(deadline) {
+				t.Fatalf("only %d of %d saves ran", persister.saves.Load(), want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	save(2)
+	if cm.IsReadOnly() {
+		t.Fatal("read-only after 2 failed saves, want the limit of 3 first")
+	}
+	save(1)
+	if !cm.IsReadOnly() {
+		t.Fatal("not read-only after 3 consecutive failed saves")
+	}
+	if err := cm.ReadOnlyReason(); err == nil || err.Error() != "disk is read-only" {
+		t.Fatalf("read-only reason = %v, want the save error", err)
+	}
+
+	persister.failing.Store(false)
+	cm.retryFailedSaves()
+	if cm.IsReadOnly() || cm.ReadOnlyReason() != nil {
+		t.Fatal("still read-only after the failed save was retried successfully")
+	}
+}
+
+func TestSuccessfulSaveResetsFailureCount(t *testing.T) {
+	cm := NewCollectionManager(discardPersister{}, 4)
+	cm.SetSaveFailureLimit(3)
+	task := saveTask{collectionName: "items"}
+	failure := errors.New("disk full")
+
+	cm.recordSaveResult(task, failure)
+	cm.recordSaveResult(task, failure)
+	cm.recordSaveResult(task, nil)
+	cm.recordSaveResult(task, failure)
+	cm.recordSaveResult(task, failure)
+	if cm.IsReadOnly() {
+		t.Fatal("read-only although the failures were not consecutive")
+	}
+	cm.recordSaveResult(task, failure)
+	if !cm.IsReadOnly() {
+		t.Fatal("not read-only after 3 consecutive failures")
+	}
+}
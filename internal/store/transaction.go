@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"memory-tools/internal/globalconst"
@@ -23,6 +24,22 @@ const (
 	StateAborted
 )
 
+// String returns the lowercase name of the transaction state.
+func (s TransactionState) String() string {
+	switch s {
+	case StateActive:
+		return "active"
+	case StatePreparing:
+		return "preparing"
+	case StateCommitted:
+		return "committed"
+	case StateAborted:
+		return "aborted"
+	default:
+		return "unknown"
+	}
+}
+
 // TransactionOpType is an enum for the type of operation in a transaction.
 type TransactionOpType int
 
@@ -30,14 +47,35 @@ const (
 	OpTypeSet TransactionOpType = iota
 	OpTypeUpdate
 	OpTypeDelete
+	// OpTypeTruncate removes every item of Collection; it has no Key.
+	OpTypeTruncate
 )
 
+// String returns the lowercase name of the operation type.
+func (t TransactionOpType) String() string {
+	switch t {
+	case OpTypeSet:
+		return "set"
+	case OpTypeUpdate:
+		return "update"
+	case OpTypeDelete:
+		return "delete"
+	case OpTypeTruncate:
+		return "truncate"
+	default:
+		return "unknown"
+	}
+}
+
 // WriteOperation represents a single write action within a transaction.
 type WriteOperation struct {
 	Collection string
 	Key        string
 	Value      []byte
 	OpType     TransactionOpType
+	// Precondition, if set, is re-evaluated against the committed value during the
+	// prepare phase; the transaction fails if it no longer holds.
+	Precondition func(current []byte) bool
 }
 
 // Transaction holds the state and operations for a single transaction.
@@ -56,6 +94,12 @@ type TransactionManager struct {
 	cm           *CollectionManager
 	gcQuitChan   chan struct{}
 	wg           sync.WaitGroup
+	// gcAborted remembers recently GC-aborted transactions so that later
+	// commands on them report the timeout instead of a generic "not found".
+	gcAborted      map[string]time.Time
+	gcAbortedCount atomic.Int64
+	// timeout is how long a transaction may stay active before the garbage collector aborts it.
+	timeout time.Duration
 }
 
 // NewTransactionManager creates a new instance of the transaction manager.
@@ -64,16 +108,39 @@ func NewTransactionManager(cm *CollectionManager) *TransactionManager {
 		transactions: make(map[string]*Transaction),
 		cm:           cm,
 		gcQuitChan:   make(chan struct{}),
+		gcAborted:    make(map[string]time.Time),
 	}
 }
 
+// GCAbortedCount returns the total number of transactions aborted by the garbage collector.
+func (tm *TransactionManager) GCAbortedCount() int64 {
+	return tm.gcAbortedCount.Load()
+}
+
 // StartGC starts the garbage collector goroutine.
 func (tm *TransactionManager) StartGC(timeout, interval time.Duration) {
+	tm.timeout = timeout
 	tm.wg.Add(1)
 	go tm.runGC(timeout, interval)
 	slog.Info("Transaction garbage collector started", "timeout", timeout, "interval", interval)
 }
 
+// Timeout returns how long a transaction may stay active before it is aborted, or 0 if the
+// garbage collector is not running.
+func (tm *TransactionManager) Timeout() time.Duration {
+	return tm.timeout
+}
+
+// Expired reports whether a transaction held by a connection is gone because the garbage
+// collector aborted it. A connection forgets its transaction on commit and rollback, so an ID
+// it still holds can only disappear through the garbage collector.
+func (tm *TransactionManager) Expired(txID string) bool {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	_, exists := tm.transactions[txID]
+	return !exists
+}
+
 // StopGC stops the garbage collector and waits for it to finish.
 func (tm *TransactionManager) StopGC() {
 	close(tm.gcQuitChan)
@@ -104,12 +171,26 @@ func (tm *TransactionManager) runGC(timeout, interval time.Duration) {
 			if len(txIDsToRollback) > 0 {
 				slog.Warn("Found abandoned transactions to roll back", "count", len(txIDsToRollback))
 				for _, txID := range txIDsToRollback {
-					slog.Info("Rolling back abandoned transaction", "txID", txID)
 					if err := tm.Rollback(txID); err != nil {
 						slog.Error("Error rolling back abandoned transaction", "txID", txID, "error", err)
+						continue
 					}
+					total := tm.gcAbortedCount.Add(1)
+					tm.mu.Lock()
+					tm.gcAborted[txID] = time.Now()
+					tm.mu.Unlock()
+					slog.Warn("Transaction aborted by garbage collector", "txID", txID, "timeout", timeout, "gc_aborted_total", total)
+				}
+			}
+
+			// Forget GC-aborted transactions once they are older than the timeout.
+			tm.mu.Lock()
+			for txID, abortedAt := range tm.gcAborted {
+				if time.Since(abortedAt) > timeout {
+					delete(tm.gcAborted, txID)
 				}
 			}
+			tm.mu.Unlock()
 		case <-tm.gcQuitChan:
 			return
 		}
@@ -152,6 +233,114 @@ func (tm *TransactionManager) RecordWrite(txID string, op WriteOperation) error
 	return nil
 }
 
+// WriteCount returns the number of operations buffered by a transaction, or 0 if it is not
+// registered.
+func (tm *TransactionManager) WriteCount(txID string) int {
+	tx, err := tm.getTransaction(txID)
+	if err != nil {
+		return 0
+	}
+
+	tx.mu.RLock()
+	defer tx.mu.RUnlock()
+	return len(tx.WriteSet)
+}
+
+// Inspect returns the state, start time, and a copy of the buffered operations of a transaction.
+func (tm *TransactionManager) Inspect(txID string) (TransactionState, time.Time, []WriteOperation, error) {
+	tx, err := tm.getTransaction(txID)
+	if err != nil {
+		return 0, time.Time{}, nil, err
+	}
+
+	tx.mu.RLock()
+	defer tx.mu.RUnlock()
+	ops := make([]WriteOperation, len(tx.WriteSet))
+	copy(ops, tx.WriteSet)
+	return tx.State, tx.startTime, ops, nil
+}
+
+// PendingWrite returns the last buffered operation of a transaction on a key, which decides
+// what a read of that key inside the transaction sees. The second result is false if the
+// transaction has not written the key. A key not written since the transaction truncated its
+// collection reads as deleted.
+func (tm *TransactionManager) PendingWrite(txID, collection, key string) (WriteOperation, bool, error) {
+	tx, err := tm.getTransaction(txID)
+	if err != nil {
+		return WriteOperation{}, false, err
+	}
+
+	tx.mu.RLock()
+	defer tx.mu.RUnlock()
+	for i := len(tx.WriteSet) - 1; i >= 0; i-- {
+		op := tx.WriteSet[i]
+		if op.Collection != collection {
+			continue
+		}
+		if op.OpType == OpTypeTruncate {
+			return WriteOperation{Collection: collection, Key: key, OpType: OpTypeDelete}, true, nil
+		}
+		if op.Key == key {
+			return op, true, nil
+		}
+	}
+	return WriteOperation{}, false, nil
+}
+
+// PendingWrites returns the last buffered operation of a transaction on every key it has
+// written in a collection. If the transaction truncated the collection, truncated is set and
+// only the writes made since the last truncation are returned.
+func (tm *TransactionManager) PendingWrites(txID, collection string) (ops map[string]WriteOperation, truncated bool, err error) {
+	tx, err := tm.getTransaction(txID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	tx.mu.RLock()
+	defer tx.mu.RUnlock()
+	ops = make(map[string]WriteOperation)
+	for _, op := range tx.WriteSet {
+		if op.Collection != collection {
+			continue
+		}
+		if op.OpType == OpTypeTruncate {
+			clear(ops)
+			truncated = true
+			continue
+		}
+		ops[op.Key] = op
+	}
+	return ops, truncated, nil
+}
+
+// splitTruncates removes the truncations from a write set, along with the writes they make moot:
+// those made to the same collection before its last truncation. It returns the remaining writes,
+// which all apply after any truncation of their collection, and the truncated collections.
+func splitTruncates(writeSet []WriteOperation) ([]WriteOperation, map[string]bool) {
+	lastTruncate := make(map[string]int)
+	for i, op := range writeSet {
+		if op.OpType == OpTypeTruncate {
+			lastTruncate[op.Collection] = i
+		}
+	}
+	if len(lastTruncate) == 0 {
+		return writeSet, nil
+	}
+	truncated := make(map[string]bool, len(lastTruncate))
+	remaining := make([]WriteOperation, 0, len(writeSet))
+	for i, op := range writeSet {
+		last, isTruncated := lastTruncate[op.Collection]
+		if isTruncated {
+			truncated[op.Collection] = true
+		}
+		if op.OpType == OpTypeTruncate || (isTruncated && i < last) {
+			continue
+		}
+		remaining = append(remaining, op)
+	}
+	return remaining, truncated
+}
+
 // getTransaction is an internal helper to safely get a transaction.
 func (tm *TransactionManager) getTransaction(txID string) (*Transaction, error) {
 	tm.mu.RLock()
@@ -159,6 +348,9 @@ func (tm *TransactionManager) getTransaction(txID string) (*Transaction, error)
 
 	tx, exists := tm.transactions[txID]
 	if !exists {
+		if _, aborted := tm.gcAborted[txID]; aborted {
+			return nil, fmt.Errorf("transaction with ID %s was aborted by the garbage collector after exceeding its timeout", txID)
+		}
 		return nil, fmt.Errorf("transaction with ID %s not found", txID)
 	}
 	return tx, nil
@@ -186,12 +378,16 @@ func (tm *TransactionManager) Commit(txID string) error {
 	writeSetToProcess := tx.WriteSet
 	tx.State = StatePreparing
 	tx.mu.Unlock()
+	// Writes after a truncation apply to the emptied collection, so they see none of its items.
+	writeSetToProcess, truncated := splitTruncates(writeSetToProcess)
 
 	// --- START OF KEY MODIFICATION: PRE-VALIDATION SWEEP ---
 	slog.Debug("TransactionManager: starting pre-commit validation", "txID", txID)
 	for _, op := range writeSetToProcess {
-		col := tm.cm.GetCollection(op.Collection)
-		_, keyExists := col.Get(op.Key)
+		keyExists := false
+		if !truncated[op.Collection] {
+			_, keyExists = tm.cm.GetCollection(op.Collection).Get(op.Key)
+		}
 
 		// Rule 1: If the operation is a SET, the key must NOT exist.
 		if op.OpType == OpTypeSet && keyExists {
@@ -210,12 +406,45 @@ func (tm *TransactionManager) Commit(txID string) error {
 	slog.Debug("TransactionManager: pre-commit validation successful", "txID", txID)
 	// --- END OF KEY MODIFICATION ---
 
+	// Unique indexes are checked against the state the writes apply to, and stay locked until
+	// they are applied so no other write takes one of their values in between.
+	writesByCollection := make(map[string]map[string]map[string]any)
+	for name := range truncated {
+		writesByCollection[name] = make(map[string]map[string]any)
+	}
+	for _, op := range writeSetToProcess {
+		writes, ok := writesByCollection[op.Collection]
+		if !ok {
+			writes = make(map[string]map[string]any)
+			writesByCollection[op.Collection] = writes
+		}
+		var doc map[string]any
+		if op.OpType != OpTypeDelete {
+			json.Unmarshal(op.Value, &doc)
+		}
+		writes[op.Key] = doc
+	}
+	collectionNames := make([]string, 0, len(writesByCollection))
+	for name := range writesByCollection {
+		collectionNames = append(collectionNames, name)
+	}
+	unlockUnique := tm.cm.LockUniqueIndexes(collectionNames...)
+	defer unlockUnique()
+	for name, writes := range writesByCollection {
+		if err := tm.cm.CheckUniqueIndexes(name, writes, false, truncated[name]); err != nil {
+			slog.Warn("Commit failed: unique index violation", "txID", txID, "collection", name, "error", err)
+			tm.Rollback(txID)
+			return fmt.Errorf("commit failed: %w", err)
+		}
+	}
+
 	tx.mu.Lock()
 	tx.WriteSet = nil
 	tx.mu.Unlock()
 
 	slog.Debug("TransactionManager: enriching WriteSet with timestamps", "txID", txID)
-	now := time.Now().UTC().Format(time.RFC3339)
+	commitTime := time.Now()
+	now := commitTime.UTC().Format(time.RFC3339)
 
 	enrichedWriteSet := make([]WriteOperation, 0, len(writeSetToProcess))
 	for _, op := range writeSetToProcess {
@@ -233,9 +462,19 @@ func (tm *TransactionManager) Commit(txID string) error {
 		}
 
 		data[globalconst.UPDATED_AT] = now
-		if _, found := col.Get(op.Key); !found {
-			data[globalconst.CREATED_AT] = now
+		var current []byte
+		found := false
+		if !truncated[op.Collection] {
+			current, found = col.Get(op.Key)
+		}
+		var currentData map[string]any
+		if found {
+			json.Unmarshal(current, &currentData)
+		} else {
+			SetCreationTime(data, commitTime)
 		}
+		// The version follows the committed document, whatever version the queued value was built from.
+		SetVersion(data, VersionOf(currentData)+1)
 
 		enrichedValue, err := json.Marshal(data)
 		if err != nil {
@@ -251,32 +490,63 @@ func (tm *TransactionManager) Commit(txID string) error {
 	slog.Debug("TransactionManager: entering Prepare Phase", "txID", txID, "op_count", len(enrichedWriteSet))
 	opsByShard := make(map[*Shard][]WriteOperation)
 	keysByShard := make(map[*Shard][]string)
+	compressByShard := make(map[*Shard]bool)
+
+	// A truncated collection is replaced by an empty store holding its writes, which only becomes
+	// visible once every other write is prepared.
+	replacements := make(map[string]*InMemStore, len(truncated))
+	for name := range truncated {
+		replacements[name] = tm.cm.emptyCollectionLike(tm.cm.GetCollection(name))
+	}
 
 	for _, op := range enrichedWriteSet {
+		if newCol, ok := replacements[op.Collection]; ok {
+			// Deletes after a truncation never pass validation, so only sets remain.
+			newCol.Set(op.Key, op.Value, 0)
+			continue
+		}
 		col := tm.cm.GetCollection(op.Collection).(*InMemStore)
 		shard := col.getShard(op.Key)
 		opsByShard[shard] = append(opsByShard[shard], op)
 		keysByShard[shard] = append(keysByShard[shard], op.Key)
+		compressByShard[shard] = col.IsCompressionEnabled()
+	}
+
+	// The WriteSet was cleared above, so Rollback no longer knows the shards of the writes: a
+	// failed prepare releases their locks and pending writes itself.
+	abortPrepare := func() {
+		for shard := range keysByShard {
+			shard.rollbackChanges(txID)
+		}
+		tm.Rollback(txID)
 	}
 
 	for shard, keys := range keysByShard {
 		if err := shard.lockKeys(txID, keys); err != nil {
 			slog.Warn("TransactionManager: lock failed during Prepare Phase, initiating rollback", "txID", txID, "error", err)
-			tm.Rollback(txID)
+			abortPrepare()
 			return fmt.Errorf("prepare failed: %w", err)
 		}
 	}
 
 	for shard, ops := range opsByShard {
 		for _, op := range ops {
-			if err := shard.prepareWrite(txID, op); err != nil {
+			if err := shard.prepareWrite(txID, op, compressByShard[shard]); err != nil {
 				slog.Warn("TransactionManager: prepareWrite failed, initiating rollback", "txID", txID, "error", err)
-				tm.Rollback(txID)
+				abortPrepare()
 				return fmt.Errorf("prepare failed: %w", err)
 			}
 		}
 	}
 
+	for name, newCol := range replacements {
+		if err := tm.cm.replaceTruncatedCollection(name, newCol); err != nil {
+			slog.Warn("TransactionManager: truncation failed during Prepare Phase, initiating rollback", "txID", txID, "error", err)
+			abortPrepare()
+			return fmt.Errorf("prepare failed: %w", err)
+		}
+	}
+
 	slog.Debug("TransactionManager: Prepare Phase successful. Entering Commit Phase.", "txID", txID)
 
 	tx.mu.Lock()
@@ -294,6 +564,9 @@ func (tm *TransactionManager) Commit(txID string) error {
 	}
 
 	collectionsToSave := make(map[string]DataStore)
+	for name := range truncated {
+		collectionsToSave[name] = tm.cm.GetCollection(name)
+	}
 	for _, op := range enrichedWriteSet {
 		if _, exists := collectionsToSave[op.Collection]; !exists {
 			collectionsToSave[op.Collection] = tm.cm.GetCollection(op.Collection)
@@ -0,0 +1,127 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func recordWrites(t *testing.T, tm *TransactionManager, ops ...WriteOperation) string {
+	t.Helper()
+	txID, err := tm.Begin()
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	for _, op := range ops {
+		if err := tm.RecordWrite(txID, op); err != nil {
+			t.Fatalf("RecordWrite %s: %v", op.Key, err)
+		}
+	}
+	return txID
+}
+
+func TestCommitFailedPreconditionReleasesLocks(t *testing.T) {
+	cm, tm := newTestManagers(t)
+	col := cm.GetCollection("accounts")
+	keys := make([]string, 16) // Enough keys to spread over every shard.
+	for i := range keys {
+		keys[i] = fmt.Sprintf("acc-%d", i)
+		col.Set(keys[i], []byte(`{"balance":1}`), 0)
+	}
+
+	var ops []WriteOperation
+	for i, key := range keys {
+		op := WriteOperation{Collection: "accounts", Key: key, Value: []byte(`{"balance":2}`), OpType: OpTypeUpdate}
+		if i == len(keys)-1 {
+			op.Precondition = func([]byte) bool { return false }
+		}
+		ops = append(ops, op)
+	}
+	err := tm.Commit(recordWrites(t, tm, ops...))
+	if err == nil || !strings.Contains(err.Error(), "precondition") {
+		t.Fatalf("Commit error = %v, want a failed precondition", err)
+	}
+	for _, key := range keys {
+		if value, _ := col.Get(key); !strings.Contains(string(value), `"balance":1`) {
+			t.Fatalf("key %s was written by a failed commit: %s", key, value)
+		}
+	}
+
+	ops = ops[:0]
+	for _, key := range keys {
+		ops = append(ops, WriteOperation{Collection: "accounts", Key: key, Value: []byte(`{"balance":3}`), OpType: OpTypeUpdate})
+	}
+	if err := tm.Commit(recordWrites(t, tm, ops...)); err != nil {
+		t.Fatalf("second Commit on the same keys: %v", err)
+	}
+	for _, key := range keys {
+		if value, _ := col.Get(key); !strings.Contains(string(value), `"balance":3`) {
+			t.Fatalf("key %s = %s after the second commit", key, value)
+		}
+	}
+}
+
+func TestCommitChecksKeyExistence(t *testing.T) {
+	cm, tm := newTestManagers(t)
+	cm.GetCollection("items").Set("present", []byte(`{"n":1}`), 0)
+
+	if err := tm.Commit(recordWrites(t, tm, WriteOperation{Collection: "items", Key: "present", Value: []byte(`{"n":2}`), OpType: OpTypeSet})); err == nil {
+		t.Fatal("SET of an existing key committed")
+	}
+	if err := tm.Commit(recordWrites(t, tm, WriteOperation{Collection: "items", Key: "missing", Value: []byte(`{"n":2}`), OpType: OpTypeUpdate})); err == nil {
+		t.Fatal("UPDATE of a missing key committed")
+	}
+	if err := tm.Commit(recordWrites(t, tm, WriteOperation{Collection: "items", Key: "present", OpType: OpTypeDelete})); err != nil {
+		t.Fatalf("DELETE of an existing key: %v", err)
+	}
+	if _, found := cm.GetCollection("items").Get("present"); found {
+		t.Fatal("deleted key is still present")
+	}
+}
+
+func TestRollbackDiscardsWrites(t *testing.T) {
+	cm, tm := newTestManagers(t)
+	txID := recordWrites(t, tm, WriteOperation{Collection: "items", Key: "k", Value: []byte(`{"n":1}`), OpType: OpTypeSet})
+	if err := tm.Rollback(txID); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if err := tm.Commit(txID); err == nil {
+		t.Fatal("a rolled back transaction committed")
+	}
+	if _, found := cm.GetCollection("items").Get("k"); found {
+		t.Fatal("rolled back write is visible")
+	}
+}
+
+func TestGCAbortsStaleTransactionOnSchedule(t *testing.T) {
+	_, tm := newTestManagers(t)
+	const timeout, interval = 100 * time.Millisecond, 5 * time.Millisecond
+	tm.StartGC(timeout, interval)
+	t.Cleanup(tm.StopGC)
+
+	stale := recordWrites(t, tm, WriteOperation{Collection: "items", Key: "k", Value: []byte(`{}`), OpType: OpTypeSet})
+	started := time.Now()
+	for !tm.Expired(stale) {
+		if time.Since(started) > 2*time.Second {
+			t.Fatal("stale transaction was never aborted")
+		}
+		time.Sleep(interval)
+	}
+	if elapsed := time.Since(started); elapsed < timeout {
+		t.Fatalf("transaction aborted after %s, before its %s timeout", elapsed, timeout)
+	}
+	if n := tm.GCAbortedCount(); n != 1 {
+		t.Fatalf("GC aborted count = %d, want 1", n)
+	}
+	err := tm.Commit(stale)
+	if err == nil || !strings.Contains(err.Error(), "garbage collector") {
+		t.Fatalf("Commit of an aborted transaction = %v, want an abort by the garbage collector", err)
+	}
+
+	fresh := recordWrites(t, tm)
+	time.Sleep(timeout / 2)
+	if tm.Expired(fresh) {
+		t.Fatal("transaction aborted before its timeout")
+	}
+}
@@ -0,0 +1,283 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+
+	"memory-tools/internal/globalconst"
+)
+
+// UniqueViolationError is returned when a write would give a value of a unique index to a second
+// document.
+type UniqueViolationError struct {
+	Collection  string
+	Field       string
+	Value       any
+	Key         string // The document being written.
+	ExistingKey string // The document already holding the value.
+}
+
+func (e *UniqueViolationError) Error() string {
+	value, err := json.Marshal(e.Value)
+	if err != nil {
+		value = []byte(fmt.Sprint(e.Value))
+	}
+	return fmt.Sprintf("duplicate value %s for unique field '%s' in collection '%s': document '%s' already holds it", value, e.Field, e.Collection, e.ExistingKey)
+}
+
+// uniqueIndexFields returns the sorted fields of a store's active unique indexes, with whether
+// each one ignores case. Disabled indexes are not enforced.
+func uniqueIndexFields(s DataStore) ([]string, map[string]bool) {
+	disabled := make(map[string]struct{})
+	for _, field := range s.ListDisabledIndexes() {
+		disabled[field] = struct{}{}
+	}
+	var fields []string
+	caseInsensitive := make(map[string]bool)
+	for _, field := range s.ListIndexes() {
+		options, _ := s.GetIndexOptions(field)
+		if _, off := disabled[field]; !options.Unique || off {
+			continue
+		}
+		fields = append(fields, field)
+		caseInsensitive[field] = options.CaseInsensitive
+	}
+	sort.Strings(fields)
+	return fields, caseInsensitive
+}
+
+// uniqueValues returns the forms under which a field value is claimed in a unique index, each with
+// the element it comes from. Like the index itself, it keeps strings and numbers apart, claims
+// every element of an array, and leaves out values it does not index, such as null.
+func uniqueValues(value any, caseInsensitive bool) map[string]any {
+	claims := make(map[string]any)
+	var add func(value any)
+	add = func(value any) {
+		switch v := value.(type) {
+		case []any:
+			for _, element := range v {
+				add(element)
+			}
+		case string:
+			normalized := (&Index{caseInsensitive: caseInsensitive}).normalize(v)
+			claims["s:"+normalized] = v
+		default:
+			if f, ok := valueToFloat64(v); ok {
+				claims["n:"+strconv.FormatFloat(f, 'g', -1, 64)] = v
+			}
+		}
+	}
+	add(value)
+	return claims
+}
+
+// hasUniqueIndexes reports whether a collection has an active unique index.
+func (cm *CollectionManager) hasUniqueIndexes(collectionName string) bool {
+	if !cm.CollectionExists(collectionName) {
+		return false
+	}
+	fields, _ := uniqueIndexFields(cm.GetCollection(collectionName))
+	return len(fields) > 0
+}
+
+// LockUniqueIndexes serializes the writes to collections with unique indexes, so two writes cannot
+// both pass CheckUniqueIndexes with the same value before either is applied. It returns the
+// function that releases the locks. Collections without unique indexes are not locked.
+func (cm *CollectionManager) LockUniqueIndexes(collectionNames ...string) (unlock func()) {
+	names := make([]string, 0, len(collectionNames))
+	for _, name := range collectionNames {
+		if cm.hasUniqueIndexes(name) {
+			names = append(names, name)
+		}
+	}
+	// Locking in name order keeps two transactions on the same collections from deadlocking.
+	sort.Strings(names)
+	names = slices.Compact(names)
+	locks := make([]*sync.Mutex, 0, len(names))
+	for _, name := range names {
+		lock, _ := cm.uniqueLocks.LoadOrStore(name, &sync.Mutex{})
+		locks = append(locks, lock.(*sync.Mutex))
+		lock.(*sync.Mutex).Lock()
+	}
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].Unlock()
+		}
+	}
+}
+
+// CheckUniqueIndexes returns a *UniqueViolationError for the first write that would give a value
+// of one of the collection's unique indexes to a second document. writes maps the keys written to
+// their new documents, or to the fields they set if patches is true; a nil document stands for a
+// deleted key. The writes are compared with each other, with the documents in memory through the
+// indexes, and with the documents held only on disk by one scan of the collection file. If
+// truncated is set, the collection is emptied before the writes, so they are only compared with
+// each other. Callers hold LockUniqueIndexes until the writes are applied.
+func (cm *CollectionManager) CheckUniqueIndexes(collectionName string, writes map[string]map[string]any, patches, truncated bool) error {
+	if !cm.CollectionExists(collectionName) {
+		return nil
+	}
+	col := cm.GetCollection(collectionName)
+	fields, caseInsensitive := uniqueIndexFields(col)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	type claim struct{ field, value string }
+	claims := make(map[claim]string)
+	claimedValues := make(map[claim]any)
+	keys := make([]string, 0, len(writes))
+	for key := range writes {
+		keys = append(keys, key)
+	}
+	// Sorted so the same batch always reports the same violation.
+	sort.Strings(keys)
+	for _, key := range keys {
+		doc := writes[key]
+		if doc == nil {
+			continue
+		}
+		for _, field := range fields {
+			value, found := NestedValue(doc, field)
+			if !found {
+				continue
+			}
+			for normalized, element := range uniqueValues(value, caseInsensitive[field]) {
+				c := claim{field, normalized}
+				if other, taken := claims[c]; taken && other != key {
+					return &UniqueViolationError{Collection: collectionName, Field: field, Value: element, Key: key, ExistingKey: other}
+				}
+				claims[c] = key
+				claimedValues[c] = element
+			}
+		}
+	}
+	if len(claims) == 0 || truncated {
+		return nil
+	}
+
+	// keeps reports whether a stored document still holds its value of field once the writes are
+	// applied: deleted and replaced documents do not, patched ones unless the patch sets the field.
+	keeps := func(key, field string) bool {
+		doc, written := writes[key]
+		if !written {
+			return true
+		}
+		if doc == nil || !patches {
+			return false
+		}
+		_, overwritten := NestedValue(doc, field)
+		return !overwritten
+	}
+	// violation checks the stored document key against the claims.
+	violation := func(key string, stored map[string]any) error {
+		for _, field := range fields {
+			if !keeps(key, field) {
+				continue
+			}
+			value, found := NestedValue(stored, field)
+			if !found {
+				continue
+			}
+			for normalized := range uniqueValues(value, caseInsensitive[field]) {
+				c := claim{field, normalized}
+				if other, taken := claims[c]; taken && other != key {
+					return &UniqueViolationError{Collection: collectionName, Field: field, Value: claimedValues[c], Key: other, ExistingKey: key}
+				}
+			}
+		}
+		return nil
+	}
+
+	for c := range claims {
+		candidates, _ := col.Lookup(c.field, claimedValues[c])
+		sort.Strings(candidates)
+		for _, candidate := range candidates {
+			current, found := col.Get(candidate)
+			if !found {
+				continue
+			}
+			var stored map[string]any
+			if err := json.Unmarshal(current, &stored); err != nil {
+				continue
+			}
+			if err := violation(candidate, stored); err != nil {
+				return err
+			}
+		}
+	}
+
+	if col.ColdKeyCount() == 0 {
+		return nil
+	}
+	var coldErr error
+	err := cm.persister.ScanColdDocuments(collectionName, func(doc map[string]any) bool {
+		key, _ := doc[globalconst.ID].(string)
+		// The file also holds stale copies of documents in memory; only cold keys are live there.
+		if !col.IsColdKey(key) {
+			return true
+		}
+		coldErr = violation(key, doc)
+		return coldErr == nil
+	})
+	if coldErr != nil {
+		return coldErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check the unique indexes of collection '%s' on disk: %w", collectionName, err)
+	}
+	return nil
+}
+
+// FindUniqueViolation returns a *UniqueViolationError if two documents of a collection, in memory
+// or on disk, share a value of field, which keeps a unique index from being created on it.
+func (cm *CollectionManager) FindUniqueViolation(collectionName, field string, caseInsensitive bool) error {
+	col := cm.GetCollection(collectionName)
+	owners := make(map[string]string)
+	var violation *UniqueViolationError
+	check := func(key string, doc map[string]any) bool {
+		value, found := NestedValue(doc, field)
+		if !found {
+			return true
+		}
+		for normalized, element := range uniqueValues(value, caseInsensitive) {
+			if other, taken := owners[normalized]; taken && other != key {
+				violation = &UniqueViolationError{Collection: collectionName, Field: field, Value: element, Key: key, ExistingKey: other}
+				return false
+			}
+			owners[normalized] = key
+		}
+		return true
+	}
+
+	col.StreamAll(func(key string, value []byte) bool {
+		var doc map[string]any
+		if err := json.Unmarshal(value, &doc); err != nil {
+			return true
+		}
+		return check(key, doc)
+	})
+	if violation != nil {
+		return violation
+	}
+	if col.ColdKeyCount() == 0 {
+		return nil
+	}
+	err := cm.persister.ScanColdDocuments(collectionName, func(doc map[string]any) bool {
+		key, _ := doc[globalconst.ID].(string)
+		if !col.IsColdKey(key) {
+			return true
+		}
+		return check(key, doc)
+	})
+	if violation != nil {
+		return violation
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the documents of collection '%s' on disk: %w", collectionName, err)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"memory-tools/internal/globalconst"
+	"strconv"
+)
+
+// VersionOf returns the version of a document. Documents written before versions existed, and
+// missing documents (nil), have version 0.
+func VersionOf(doc map[string]any) uint64 {
+	switch v := doc[globalconst.VERSION].(type) {
+	case float64:
+		if v > 0 {
+			return uint64(v)
+		}
+	case uint64:
+		return v
+	case int64:
+		if v > 0 {
+			return uint64(v)
+		}
+	case int:
+		if v > 0 {
+			return uint64(v)
+		}
+	case string:
+		n, _ := strconv.ParseUint(v, 10, 64)
+		return n
+	}
+	return 0
+}
+
+// SetVersion stamps a document with its version. New documents start at version 1.
+func SetVersion(doc map[string]any, version uint64) {
+	doc[globalconst.VERSION] = version
+}
+
+// BumpVersion increments the version of a document that is being written in place.
+func BumpVersion(doc map[string]any) {
+	SetVersion(doc, VersionOf(doc)+1)
+}
+
+// IsManagedField reports whether a field is maintained by the server, so patches must not change it.
+func IsManagedField(field string) bool {
+	return field == globalconst.ID || field == globalconst.VERSION || IsCreationField(field)
+}
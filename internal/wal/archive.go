@@ -0,0 +1,240 @@
+package wal
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archiveSuffix is the extension of the segments copied to the archive directory.
+const archiveSuffix = ".wal"
+
+// archiveHeadFile names the file, in the archive directory, holding the name of the backup the
+// archived segments continue from.
+const archiveHeadFile = "HEAD"
+
+// ArchivedFile describes a WAL file moved out of the archive by CollectArchive.
+type ArchivedFile struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	CRC32 uint32 `json:"crc32"`
+}
+
+// SetArchiveDir keeps a copy of every segment sealed from now on in dir, for incremental backups.
+// The copy is a hard link where possible, so it costs no space until a checkpoint removes the
+// segment itself.
+func (w *WAL) SetArchiveDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create WAL archive directory: %w", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.archiveDir = dir
+	return nil
+}
+
+// archiveSegment copies a segment that was just sealed into the archive directory. Names start
+// with the time of archiving, so they sort in the order of the entries even when segment numbers
+// start over after a restart. If the copy fails, the archive has a gap: its head is dropped so
+// the next backup is a full one. It must be called with mu held.
+func (w *WAL) archiveSegment(path string) {
+	if w.archiveDir == "" {
+		return
+	}
+	archivePath := filepath.Join(w.archiveDir, fmt.Sprintf("%020d%s", time.Now().UnixNano(), archiveSuffix))
+	if err := os.Link(path, archivePath); err != nil {
+		if err := copyFile(path, archivePath); err != nil {
+			os.Remove(archivePath)
+			slog.Error("Failed to archive WAL segment; the next backup will be a full one", "path", path, "error", err)
+			w.clearArchiveHead()
+		}
+	}
+}
+
+// ArchiveHead returns the name of the backup that the archived segments continue from, or ""
+// if there is none and the next backup has to be a full one.
+func (w *WAL) ArchiveHead() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.archiveDir == "" {
+		return ""
+	}
+	head, err := os.ReadFile(filepath.Join(w.archiveDir, archiveHeadFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(head))
+}
+
+// SetArchiveHead records the backup that the segments archived from now on continue from.
+func (w *WAL) SetArchiveHead(backupName string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.archiveDir == "" {
+		return fmt.Errorf("WAL archiving is not enabled")
+	}
+	if err := os.WriteFile(filepath.Join(w.archiveDir, archiveHeadFile), []byte(backupName+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write WAL archive head: %w", err)
+	}
+	return nil
+}
+
+// ClearArchiveHead breaks the chain of the archived segments, so the next backup is a full one.
+// It is called whenever the data stops following from the archived entries, as after a restore.
+func (w *WAL) ClearArchiveHead() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.clearArchiveHead()
+}
+
+// clearArchiveHead must be called with mu held.
+func (w *WAL) clearArchiveHead() {
+	if w.archiveDir == "" {
+		return
+	}
+	if err := os.Remove(filepath.Join(w.archiveDir, archiveHeadFile)); err != nil && !os.IsNotExist(err) {
+		slog.Error("Failed to clear WAL archive head", "error", err)
+	}
+}
+
+// ResetArchive seals the WAL and discards the archived segments and the archive head, ahead of a
+// full backup that covers every entry they hold.
+func (w *WAL) ResetArchive() error {
+	if _, err := w.Seal(); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.clearArchiveHead()
+	files, err := w.archivedFiles()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := os.Remove(filepath.Join(w.archiveDir, file)); err != nil {
+			return fmt.Errorf("failed to remove archived WAL segment '%s': %w", file, err)
+		}
+	}
+	return nil
+}
+
+// CollectArchive seals the WAL and moves every archived segment to destDir, oldest first,
+// returning what it moved. The archive is left empty for the entries written from then on.
+func (w *WAL) CollectArchive(destDir string) ([]ArchivedFile, error) {
+	if _, err := w.Seal(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create WAL backup directory: %w", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	files, err := w.archivedFiles()
+	if err != nil {
+		return nil, err
+	}
+	collected := make([]ArchivedFile, 0, len(files))
+	for _, name := range files {
+		src, dst := filepath.Join(w.archiveDir, name), filepath.Join(destDir, name)
+		if err := os.Rename(src, dst); err != nil {
+			// The archive may be on another file system than the backups.
+			if err := copyFile(src, dst); err != nil {
+				return nil, fmt.Errorf("failed to collect archived WAL segment '%s': %w", name, err)
+			}
+			os.Remove(src)
+		}
+		info, err := DescribeFile(dst)
+		if err != nil {
+			return nil, err
+		}
+		collected = append(collected, info)
+	}
+	return collected, nil
+}
+
+// archivedFiles returns the names of the archived segments, oldest first. It must be called
+// with mu held.
+func (w *WAL) archivedFiles() ([]string, error) {
+	if w.archiveDir == "" {
+		return nil, fmt.Errorf("WAL archiving is not enabled")
+	}
+	entries, err := os.ReadDir(w.archiveDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WAL archive directory: %w", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), archiveSuffix) {
+			files = append(files, entry.Name())
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// DescribeFile returns the name, size and CRC-32 of a WAL file, so a copy can be checked later.
+func DescribeFile(path string) (ArchivedFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ArchivedFile{}, fmt.Errorf("failed to open WAL file '%s': %w", path, err)
+	}
+	defer file.Close()
+	hash := crc32.NewIEEE()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return ArchivedFile{}, fmt.Errorf("failed to read WAL file '%s': %w", path, err)
+	}
+	return ArchivedFile{Name: filepath.Base(path), Size: size, CRC32: hash.Sum32()}, nil
+}
+
+// ReplayFiles sends the entries of WAL files copied elsewhere, such as those of a backup, to
+// apply in order. Unlike Replay it never modifies the files: replay stops with an error at a
+// damaged entry. It returns the number of entries applied.
+func ReplayFiles(paths []string, apply func(WalEntry)) (int, error) {
+	applied := 0
+	for _, path := range paths {
+		entries := make(chan WalEntry, 100)
+		result := make(chan bool, 1)
+		go func() {
+			defer close(entries)
+			_, complete, _ := replayFile(path, entries, false)
+			result <- complete
+		}()
+		for entry := range entries {
+			apply(entry)
+			applied++
+		}
+		if !<-result {
+			return applied, fmt.Errorf("WAL file '%s' is damaged", path)
+		}
+	}
+	return applied, nil
+}
+
+// copyFile copies src to dst and syncs it.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,195 @@
+package wal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ErrPositionUnavailable is returned for a position whose file is no longer in the WAL, as a
+// checkpoint removes the segments whose entries it has saved, or that never existed.
+var ErrPositionUnavailable = errors.New("WAL position is not available")
+
+// Position is the boundary between two entries of the WAL: a byte offset in a numbered file.
+// The current file is numbered as the segment it becomes once sealed, so a position stays valid
+// across seals and restarts for as long as its file is kept.
+type Position struct {
+	File   uint64
+	Offset int64
+}
+
+// String formats a position as "<file>:<offset>", which ParsePosition reads back.
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.File, p.Offset)
+}
+
+// ParsePosition parses a position formatted by Position.String.
+func ParsePosition(s string) (Position, error) {
+	fileStr, offsetStr, ok := strings.Cut(s, ":")
+	if !ok {
+		return Position{}, fmt.Errorf("invalid WAL position '%s': expected <file>:<offset>", s)
+	}
+	file, err := strconv.ParseUint(fileStr, 10, 64)
+	if err != nil || file == 0 {
+		return Position{}, fmt.Errorf("invalid WAL position '%s': bad file number", s)
+	}
+	offset, err := strconv.ParseInt(offsetStr, 10, 64)
+	if err != nil || offset < 0 {
+		return Position{}, fmt.Errorf("invalid WAL position '%s': bad offset", s)
+	}
+	return Position{File: file, Offset: offset}, nil
+}
+
+// Start returns the position of the oldest entry still in the WAL.
+func (w *WAL) Start() Position {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if segments, err := listSegments(w.path); err == nil && len(segments) > 0 {
+		return Position{File: segments[0].number}
+	}
+	return Position{File: w.lastSegment + 1}
+}
+
+// End returns the position after the last appended entry.
+func (w *WAL) End() Position {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return Position{File: w.lastSegment + 1, Offset: w.size}
+}
+
+// CheckPosition returns an error wrapping ErrPositionUnavailable if the file of pos is no longer
+// in the WAL or pos is past its end.
+func (w *WAL) CheckPosition(pos Position) error {
+	file, err := w.openFile(pos.File)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat WAL file: %w", err)
+	}
+	if pos.Offset > info.Size() {
+		return fmt.Errorf("%w: %s is past the end of its file", ErrPositionUnavailable, pos)
+	}
+	return nil
+}
+
+// openFile opens the WAL file numbered number: a sealed segment, or the current file. It is
+// opened under mu so a seal cannot rename the current file in between.
+func (w *WAL) openFile(number uint64) (*os.File, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	path := w.path
+	if number > w.lastSegment+1 {
+		return nil, fmt.Errorf("%w: WAL file %d does not exist yet", ErrPositionUnavailable, number)
+	}
+	if number <= w.lastSegment {
+		path = segmentPath(w.path, number)
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: WAL file %d was removed by a checkpoint", ErrPositionUnavailable, number)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open WAL file %d: %w", number, err)
+	}
+	return file, nil
+}
+
+// appendSignal returns a channel closed at the next append.
+func (w *WAL) appendSignal() <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.appendedCh == nil {
+		w.appendedCh = make(chan struct{})
+	}
+	return w.appendedCh
+}
+
+// sealed reports whether the file numbered number has been sealed, so nothing is appended to it anymore.
+func (w *WAL) sealed(number uint64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return number <= w.lastSegment
+}
+
+// Follow is a tailable Replay: it calls apply with each entry from position from on, in order,
+// along with the position after the entry, moving on to the next file as files are sealed. Once
+// it has read every entry it waits for new ones, until stop is closed, when it returns nil. It
+// returns the error of apply, which stops it, or an error wrapping ErrPositionUnavailable if
+// the file it has to read next has been removed by a checkpoint.
+func (w *WAL) Follow(from Position, stop <-chan struct{}, apply func(WalEntry, Position) error) error {
+	pos := from
+	for {
+		file, err := w.openFile(pos.File)
+		if err != nil {
+			return err
+		}
+		pos, err = w.followFile(file, pos, stop, apply)
+		file.Close()
+		if err != nil || pos == (Position{}) {
+			return err
+		}
+	}
+}
+
+// followFile reads the entries of file from pos on, waiting for more while the file is the current
+// one. It returns the start of the next file once the file is sealed and read to its end, or the
+// zero Position when stop is closed.
+func (w *WAL) followFile(file *os.File, pos Position, stop <-chan struct{}, apply func(WalEntry, Position) error) (Position, error) {
+	reader := bufio.NewReader(file)
+	for {
+		select {
+		case <-stop:
+			return Position{}, nil
+		default:
+		}
+		// The signal is taken before reading, so an entry appended after the read wakes the wait.
+		appended := w.appendSignal()
+		// A file sealed before its size is read has all its entries in that size.
+		sealed := w.sealed(pos.File)
+		info, err := file.Stat()
+		if err != nil {
+			return pos, fmt.Errorf("failed to stat WAL file %d: %w", pos.File, err)
+		}
+		if pos.Offset > info.Size() {
+			return pos, fmt.Errorf("%w: %s is past the end of its file", ErrPositionUnavailable, pos)
+		}
+		if _, err := file.Seek(pos.Offset, io.SeekStart); err != nil {
+			return pos, fmt.Errorf("failed to seek WAL file %d: %w", pos.File, err)
+		}
+		reader.Reset(file)
+
+		for {
+			entry, length, err := readEntry(reader, pos.Offset, info.Size())
+			if err == io.EOF {
+				break
+			}
+			var damaged *damagedEntryError
+			if errors.As(err, &damaged) && damaged.truncated && !sealed {
+				break // The entry is still being appended.
+			}
+			if err != nil {
+				return pos, fmt.Errorf("failed to read WAL entry at %s: %w", pos, err)
+			}
+			pos.Offset += length
+			if err := apply(entry, pos); err != nil {
+				return pos, err
+			}
+		}
+		if sealed {
+			return Position{File: pos.File + 1}, nil
+		}
+
+		select {
+		case <-appended:
+		case <-stop:
+			return Position{}, nil
+		}
+	}
+}
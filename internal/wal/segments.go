@@ -0,0 +1,119 @@
+package wal
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// segmentSuffix separates the path of the WAL from the number of a sealed segment.
+const segmentSuffix = ".seg-"
+
+// lastSegmentSuffix names the file, next to the WAL, holding the number of the last sealed
+// segment. Segment numbers go on from it after a restart, even once a checkpoint has removed
+// every segment, so a Position never refers to two different files.
+const lastSegmentSuffix = ".last-segment"
+
+// segment is a sealed WAL file waiting for a checkpoint to cover its entries.
+type segment struct {
+	path   string
+	number uint64
+}
+
+// segmentPath returns the path of sealed segment number of the WAL at path.
+func segmentPath(path string, number uint64) string {
+	return fmt.Sprintf("%s%s%06d", path, segmentSuffix, number)
+}
+
+// listSegments returns the sealed segments of the WAL at path, oldest first.
+func listSegments(path string) ([]segment, error) {
+	matches, err := filepath.Glob(path + segmentSuffix + "*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list WAL segments: %w", err)
+	}
+	segments := make([]segment, 0, len(matches))
+	for _, match := range matches {
+		number, err := strconv.ParseUint(strings.TrimPrefix(match, path+segmentSuffix), 10, 64)
+		if err != nil {
+			continue // Not a segment, e.g. a segment set aside as corrupt.
+		}
+		segments = append(segments, segment{path: match, number: number})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].number < segments[j].number })
+	return segments, nil
+}
+
+// Seal closes the current WAL file as a numbered segment and continues in a new, empty file,
+// returning the segment's number. Sealed segments are replayed before the current file until
+// RemoveSegmentsUpTo drops them, so a checkpoint can seal the WAL before saving the data and
+// drop the entries the save covers only once it has succeeded, while writers keep appending.
+// If nothing was appended since the last seal, no segment is created.
+func (w *WAL) Seal() (uint64, error) {
+	w.mu.Lock()
+	empty := w.size == 0
+	number := w.lastSegment
+	w.mu.Unlock()
+	if empty {
+		return number, nil
+	}
+
+	err := w.replaceFile(func() error {
+		number = w.lastSegment + 1
+		if err := os.Rename(w.path, segmentPath(w.path, number)); err != nil {
+			return fmt.Errorf("failed to seal WAL segment: %w", err)
+		}
+		w.lastSegment = number
+		w.saveLastSegment()
+		w.archiveSegment(segmentPath(w.path, number))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	slog.Info("WAL segment sealed.", "segment", number)
+	return number, nil
+}
+
+// readLastSegment returns the number of the last segment sealed from the WAL at path, or 0 if
+// none was recorded.
+func readLastSegment(path string) uint64 {
+	data, err := os.ReadFile(path + lastSegmentSuffix)
+	if err != nil {
+		return 0
+	}
+	number, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		slog.Warn("Ignoring unreadable WAL segment counter", "path", path+lastSegmentSuffix, "error", err)
+		return 0
+	}
+	return number
+}
+
+// saveLastSegment records the number of the last sealed segment. It must be called with mu held.
+func (w *WAL) saveLastSegment() {
+	if err := os.WriteFile(w.path+lastSegmentSuffix, []byte(strconv.FormatUint(w.lastSegment, 10)+"\n"), 0644); err != nil {
+		slog.Error("Failed to record the last WAL segment number", "error", err)
+	}
+}
+
+// RemoveSegmentsUpTo deletes the sealed segments numbered up to and including number, once a
+// checkpoint has saved the data their entries produced.
+func (w *WAL) RemoveSegmentsUpTo(number uint64) error {
+	segments, err := listSegments(w.path)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		if segment.number > number {
+			break
+		}
+		if err := os.Remove(segment.path); err != nil {
+			return fmt.Errorf("failed to remove WAL segment '%s': %w", segment.path, err)
+		}
+	}
+	return nil
+}
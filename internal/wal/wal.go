@@ -3,26 +3,84 @@ package wal
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"log/slog"
+	"memory-tools/internal/encryption"
 	"memory-tools/internal/protocol"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// timestampFlag is set on the command type byte of entries that carry a timestamp.
+// Entries written by older versions lack it and are replayed with a zero Timestamp.
+const timestampFlag byte = 0x80
+
+// encryptedEntryMarker is the first byte of encrypted entries, in place of the command type,
+// which is never zero. It is followed by the key ID and the sealed body of the entry.
+const encryptedEntryMarker byte = 0x00
+
+// checksumFlag is set on the length of entries followed by a CRC-32 of their body. Entries
+// written by older versions lack it and are replayed unchecked.
+const checksumFlag uint32 = 1 << 31
+
+var keyring atomic.Pointer[encryption.Keyring]
+
+// SyncPolicy decides when appended entries are fsynced, trading durability for write throughput.
+type SyncPolicy string
+
+const (
+	// SyncAlways fsyncs before a write returns, so acknowledged writes survive a power loss.
+	// Concurrent writers share fsyncs.
+	SyncAlways SyncPolicy = "always"
+	// SyncEverySec fsyncs in the background once a second; a power loss can lose up to the
+	// last second of acknowledged writes.
+	SyncEverySec SyncPolicy = "everysec"
+	// SyncNo leaves flushing to the operating system. Writes survive a crash of the server but
+	// a power loss can lose whatever the OS had not yet written.
+	SyncNo SyncPolicy = "no"
+)
+
+// SetKeyring sets the keys of WAL encryption. New entries are encrypted with the keyring's
+// current key, if it has one; entries encrypted with any key of the ring can be replayed.
+func SetKeyring(kr *encryption.Keyring) {
+	keyring.Store(kr)
+}
+
 // WalEntry represents a single operation recorded in the log.
 type WalEntry struct {
 	CommandType protocol.CommandType
 	Payload     []byte
+	Timestamp   time.Time // Set by Write when zero; zero on replay for legacy entries.
 }
 
 // WAL (Write-Ahead Log) manages the writing and reading of the durability log.
+// Appends are serialized by mu, while fsyncs are serialized by syncMu so that writers
+// waiting on a sync in progress share the next one instead of issuing one each (group commit).
 type WAL struct {
 	file   *os.File
 	writer *bufio.Writer
 	mu     sync.Mutex
 	path   string
+	policy SyncPolicy
+
+	syncMu   sync.Mutex
+	appended uint64 // Sequence number of the last appended entry, guarded by mu.
+	synced   uint64 // Sequence number of the last entry known to be on disk, guarded by syncMu.
+
+	stopSync chan struct{} // Closed to stop the background sync of SyncEverySec.
+	syncDone sync.WaitGroup
+
+	size        int64         // Bytes in the current file, guarded by mu.
+	maxSize     int64         // Size of the current file that triggers a notification on full; zero disables it.
+	full        chan struct{} // Receives a value when the current file grows past maxSize.
+	lastSegment uint64        // Number of the last sealed segment, guarded by mu.
+	archiveDir  string        // Where sealed segments are copied for incremental backups, if set; guarded by mu.
+	appendedCh  chan struct{} // Closed at the next append to wake up followers, if any wait; guarded by mu.
 }
 
 // New creates and initializes a new WAL instance at the specified path.
@@ -32,44 +90,197 @@ func New(path string) (*WAL, error) {
 		return nil, fmt.Errorf("failed to open WAL file: %w", err)
 	}
 
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat WAL file: %w", err)
+	}
+	segments, err := listSegments(path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	lastSegment := readLastSegment(path)
+	if len(segments) > 0 {
+		lastSegment = max(lastSegment, segments[len(segments)-1].number)
+	}
+
 	return &WAL{
-		file:   file,
-		writer: bufio.NewWriter(file),
-		path:   path,
+		file:        file,
+		writer:      bufio.NewWriter(file),
+		path:        path,
+		policy:      SyncAlways,
+		size:        info.Size(),
+		full:        make(chan struct{}, 1),
+		lastSegment: lastSegment,
 	}, nil
 }
 
-// Write writes a log entry to the file synchronously.
-// This is the critical operation that ensures durability.
-func (w *WAL) Write(entry WalEntry) error {
+// SetMaxSize sets the size in bytes past which the current file asks for a checkpoint through
+// Full, whatever the snapshot interval. Zero, the default, disables it.
+func (w *WAL) SetMaxSize(maxBytes int64) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	w.maxSize = maxBytes
+}
 
-	payloadLen := len(entry.Payload)
-	// Format: [Total Length (4 bytes)] [Command Type (1 byte)] [Payload]
-	totalLen := 1 + payloadLen
+// Full returns a channel that receives a value when the current file has grown past the size
+// set with SetMaxSize. The owner is expected to checkpoint and seal the WAL, which starts a
+// new, empty file.
+func (w *WAL) Full() <-chan struct{} {
+	return w.full
+}
 
-	if err := binary.Write(w.writer, binary.LittleEndian, uint32(totalLen)); err != nil {
-		return fmt.Errorf("failed to write WAL entry length: %w", err)
+// SetSyncPolicy sets when entries are fsynced. It must be called before the WAL is written to;
+// the default is SyncAlways.
+func (w *WAL) SetSyncPolicy(policy SyncPolicy) {
+	w.policy = policy
+	if policy == SyncEverySec && w.stopSync == nil {
+		w.stopSync = make(chan struct{})
+		w.syncDone.Add(1)
+		go w.syncPeriodically(time.Second)
 	}
+}
+
+// syncPeriodically fsyncs the entries appended since the previous tick until the WAL is closed.
+func (w *WAL) syncPeriodically(interval time.Duration) {
+	defer w.syncDone.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stopSync:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			seq := w.appended
+			w.mu.Unlock()
+			if err := w.syncUpTo(seq); err != nil {
+				slog.Error("Background WAL sync failed", "error", err)
+			}
+		}
+	}
+}
 
-	if err := w.writer.WriteByte(byte(entry.CommandType)); err != nil {
-		return fmt.Errorf("failed to write WAL command type: %w", err)
+// Write writes a log entry to the file.
+// This is the critical operation that ensures durability. With SyncAlways it only returns once
+// the entry has been fsynced, possibly by a sync that covers the entries of other concurrent
+// writers too; with the other policies it returns once the entry is handed to the OS.
+func (w *WAL) Write(entry WalEntry) error {
+	return w.WriteBatch([]WalEntry{entry})
+}
+
+// WriteBatch writes entries to the file one after the other, with no entry of another writer
+// between them, as for the writes of a committed transaction. It is as durable as Write.
+func (w *WAL) WriteBatch(entries []WalEntry) error {
+	seq, err := w.append(entries)
+	if err != nil {
+		return err
 	}
+	if w.policy != SyncAlways {
+		return nil
+	}
+	return w.syncUpTo(seq)
+}
+
+// append writes and flushes entries to the file, returning the sequence number of the last one.
+func (w *WAL) append(entries []WalEntry) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	if _, err := w.writer.Write(entry.Payload); err != nil {
-		return fmt.Errorf("failed to write WAL payload: %w", err)
+	for _, entry := range entries {
+		if err := w.encode(entry); err != nil {
+			return 0, err
+		}
 	}
 
 	if err := w.writer.Flush(); err != nil {
-		return fmt.Errorf("failed to flush WAL writer: %w", err)
+		return 0, fmt.Errorf("failed to flush WAL writer: %w", err)
+	}
+
+	if w.appendedCh != nil {
+		close(w.appendedCh)
+		w.appendedCh = nil
+	}
+	if w.maxSize > 0 && w.size >= w.maxSize {
+		select {
+		case w.full <- struct{}{}:
+		default: // A checkpoint is already pending.
+		}
+	}
+	return w.appended, nil
+}
+
+// encode writes an entry to the buffered writer. w.mu must be held.
+func (w *WAL) encode(entry WalEntry) error {
+	timestamp := entry.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	// Format: [Total Length | checksumFlag (4 bytes)] [CRC-32 (4 bytes)] [Command Type | timestampFlag (1 byte)] [Unix Nano Timestamp (8 bytes)] [Payload]
+	// Encrypted: [Total Length | checksumFlag (4 bytes)] [CRC-32 (4 bytes)] [0x00 (1 byte)] [Key ID (8 bytes)] [Sealed Body]
+	// The CRC-32 covers the body as stored, so a torn write is detected before decrypting.
+	body := make([]byte, 0, 1+8+len(entry.Payload))
+	body = append(body, byte(entry.CommandType)|timestampFlag)
+	body = binary.LittleEndian.AppendUint64(body, uint64(timestamp.UnixNano()))
+	body = append(body, entry.Payload...)
+	if key := keyring.Load().Current(); key != nil {
+		sealed, err := key.Seal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt WAL entry: %w", err)
+		}
+		id := key.ID()
+		body = append(append([]byte{encryptedEntryMarker}, id[:]...), sealed...)
 	}
 
-	return w.file.Sync()
+	var header [8]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(body))|checksumFlag)
+	binary.LittleEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(body))
+	if _, err := w.writer.Write(header[:]); err != nil {
+		return fmt.Errorf("failed to write WAL entry header: %w", err)
+	}
+
+	if _, err := w.writer.Write(body); err != nil {
+		return fmt.Errorf("failed to write WAL entry: %w", err)
+	}
+
+	w.appended++
+	w.size += int64(len(header) + len(body))
+	return nil
 }
 
-// Close closes the WAL file safely.
+// syncUpTo makes sure every entry up to seq is on disk. If a sync that started after seq was
+// appended has already completed, no new fsync is issued.
+func (w *WAL) syncUpTo(seq uint64) error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+
+	if w.synced >= seq {
+		return nil
+	}
+
+	w.mu.Lock()
+	target := w.appended
+	file := w.file
+	w.mu.Unlock()
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync WAL file: %w", err)
+	}
+	w.synced = target
+	return nil
+}
+
+// Close closes the WAL file safely. Entries not yet fsynced under a relaxed sync policy are
+// synced first.
 func (w *WAL) Close() error {
+	if w.stopSync != nil {
+		close(w.stopSync)
+		w.syncDone.Wait()
+	}
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
@@ -77,64 +288,323 @@ func (w *WAL) Close() error {
 		w.file.Close()
 		return fmt.Errorf("failed to flush WAL on close: %w", err)
 	}
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("failed to sync WAL on close: %w", err)
+	}
 	return w.file.Close()
 }
 
-// Replay reads all entries from the WAL file and sends them to a channel.
-// This function is used during startup to recover state.
+// Replay reads all entries from the WAL and sends them to a channel: first the sealed segments,
+// oldest first, and then the current file.
+// This function is used during startup to recover state. A crash during a write can leave a
+// partial entry at the end of the file; replay stops cleanly at the first entry that is cut
+// short or fails its checksum, and the rest of the log is moved aside so that entries appended
+// after recovery are not written behind it.
 func Replay(path string) (<-chan WalEntry, error) {
-	file, err := os.Open(path)
+	segments, err := listSegments(path)
 	if err != nil {
-		if os.IsNotExist(err) {
-			slog.Info("WAL file not found, skipping replay.", "path", path)
-			closeChan := make(chan WalEntry)
-			close(closeChan)
-			return closeChan, nil
-		}
+		return nil, err
+	}
+	files := make([]string, 0, len(segments)+1)
+	for _, segment := range segments {
+		files = append(files, segment.path)
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	} else if !os.IsNotExist(err) {
 		return nil, fmt.Errorf("failed to open WAL file for replay: %w", err)
 	}
+	if len(files) == 0 {
+		slog.Info("WAL file not found, skipping replay.", "path", path)
+		closeChan := make(chan WalEntry)
+		close(closeChan)
+		return closeChan, nil
+	}
 
 	entriesChan := make(chan WalEntry, 100)
 
 	go func() {
-		defer file.Close()
 		defer close(entriesChan)
 
-		reader := bufio.NewReader(file)
-		for {
-			var totalLen uint32
-			if err := binary.Read(reader, binary.LittleEndian, &totalLen); err != nil {
-				if err != io.EOF {
-					slog.Error("Failed to read WAL entry length during replay", "error", err)
-				}
-				break
-			}
-
-			entryData := make([]byte, totalLen)
-			if _, err := io.ReadFull(reader, entryData); err != nil {
-				slog.Error("Failed to read full WAL entry during replay", "error", err)
-				break
+		validEntries := 0
+		for i, file := range files {
+			valid, complete, damaged := replayFile(file, entriesChan, true)
+			validEntries += valid
+			if complete {
+				continue
 			}
-
-			entry := WalEntry{
-				CommandType: protocol.CommandType(entryData[0]),
-				Payload:     entryData[1:],
+			if damaged {
+				// Later files continue from entries that are lost, so they cannot be applied either.
+				for _, later := range files[i+1:] {
+					setAsidePath := fmt.Sprintf("%s.corrupt-%d", later, time.Now().Unix())
+					if err := os.Rename(later, setAsidePath); err != nil {
+						slog.Error("Failed to set aside WAL file after a damaged entry", "path", later, "error", err)
+					} else {
+						slog.Warn("WAL file after a damaged entry moved aside", "path", setAsidePath)
+					}
+				}
 			}
-			entriesChan <- entry
+			break
 		}
-		slog.Info("WAL replay finished.", "path", path)
+		slog.Info("WAL replay finished.", "path", path, "files", len(files), "valid_entries", validEntries)
 	}()
 
 	return entriesChan, nil
 }
 
+// replayFile sends the entries of one WAL file to entries. complete is false if replay has to stop
+// before the end of the file; damaged reports that it stopped at a truncated or corrupt entry,
+// in which case the rest of the file has been set aside if repair is set.
+func replayFile(path string, entries chan<- WalEntry, repair bool) (validEntries int, complete, damaged bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		slog.Error("Failed to open WAL file for replay", "path", path, "error", err)
+		return 0, false, false
+	}
+	defer file.Close()
+
+	var fileSize int64
+	if info, err := file.Stat(); err == nil {
+		fileSize = info.Size()
+	}
+
+	reader := bufio.NewReader(file)
+	var offset int64 // End of the last valid entry.
+	corruption := ""
+	for {
+		entry, length, err := readEntry(reader, offset, fileSize)
+		if err == io.EOF {
+			break
+		}
+		var damaged *damagedEntryError
+		if errors.As(err, &damaged) {
+			corruption = damaged.reason
+			break
+		}
+		if err != nil {
+			slog.Error("Failed to decode WAL entry during replay", "path", path, "error", err, "valid_entries", validEntries)
+			return validEntries, false, false
+		}
+		entries <- entry
+		offset += length
+		validEntries++
+	}
+
+	if corruption == "" {
+		return validEntries, true, false
+	}
+	if !repair {
+		slog.Error("WAL file has a damaged entry", "path", path, "reason", corruption, "valid_entries", validEntries, "offset", offset)
+		return validEntries, false, true
+	}
+	slog.Warn("WAL replay stopped at a damaged entry, likely left by a crash during a write",
+		"path", path, "reason", corruption, "valid_entries", validEntries, "offset", offset, "discarded_bytes", fileSize-offset)
+	if err := setAsideTail(path, offset); err != nil {
+		slog.Error("Failed to set aside the damaged end of the WAL", "path", path, "error", err)
+	}
+	return validEntries, false, true
+}
+
+// damagedEntryError reports a WAL entry that is cut short or fails its checksum.
+type damagedEntryError struct {
+	reason    string
+	truncated bool // The file ends before the entry does; the rest of it may still be written.
+}
+
+func (e *damagedEntryError) Error() string {
+	return "damaged WAL entry: " + e.reason
+}
+
+// readEntry reads the entry at offset of a file of fileSize bytes from reader, returning it with
+// the number of bytes it takes in the file. It returns io.EOF at the end of the file, a
+// *damagedEntryError if the entry is cut short or fails its checksum, and any other error if
+// the entry cannot be decoded.
+func readEntry(reader *bufio.Reader, offset, fileSize int64) (WalEntry, int64, error) {
+	var totalLen uint32
+	if err := binary.Read(reader, binary.LittleEndian, &totalLen); err != nil {
+		if err == io.EOF {
+			return WalEntry{}, 0, io.EOF
+		}
+		return WalEntry{}, 0, &damagedEntryError{reason: "truncated entry length", truncated: true}
+	}
+	headerLen := int64(4)
+	var checksum uint32
+	hasChecksum := totalLen&checksumFlag != 0
+	if hasChecksum {
+		totalLen &^= checksumFlag
+		if err := binary.Read(reader, binary.LittleEndian, &checksum); err != nil {
+			return WalEntry{}, 0, &damagedEntryError{reason: "truncated entry checksum", truncated: true}
+		}
+		headerLen += 4
+	}
+	if offset+headerLen+int64(totalLen) > fileSize {
+		return WalEntry{}, 0, &damagedEntryError{reason: "truncated entry", truncated: true}
+	}
+
+	entryData := make([]byte, totalLen)
+	if _, err := io.ReadFull(reader, entryData); err != nil {
+		return WalEntry{}, 0, &damagedEntryError{reason: "truncated entry", truncated: true}
+	}
+	if hasChecksum && crc32.ChecksumIEEE(entryData) != checksum {
+		return WalEntry{}, 0, &damagedEntryError{reason: "checksum mismatch"}
+	}
+
+	entry, err := decodeEntry(entryData)
+	if err != nil {
+		return WalEntry{}, 0, err
+	}
+	return entry, headerLen + int64(totalLen), nil
+}
+
+// setAsideTail copies the WAL from offset on to a ".corrupt-<unix time>" file next to it and
+// truncates the WAL at offset, leaving only complete entries.
+func setAsideTail(path string, offset int64) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	tailPath := fmt.Sprintf("%s.corrupt-%d", path, time.Now().Unix())
+	dst, err := os.Create(tailPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := os.Truncate(path, offset); err != nil {
+		return err
+	}
+	slog.Info("Damaged end of the WAL moved aside", "path", tailPath)
+	return nil
+}
+
+// decodeEntry parses the body of a WAL record, accepting encrypted, timestamped and legacy entries.
+func decodeEntry(entryData []byte) (WalEntry, error) {
+	if len(entryData) == 0 {
+		return WalEntry{}, fmt.Errorf("empty WAL entry")
+	}
+	cmdByte := entryData[0]
+	if cmdByte == encryptedEntryMarker {
+		if len(entryData) < 1+encryption.KeyIDSize {
+			return WalEntry{}, fmt.Errorf("encrypted WAL entry too short: %d bytes", len(entryData))
+		}
+		key, err := keyring.Load().Key([encryption.KeyIDSize]byte(entryData[1 : 1+encryption.KeyIDSize]))
+		if err != nil {
+			return WalEntry{}, err
+		}
+		body, err := key.Open(entryData[1+encryption.KeyIDSize:])
+		if err != nil {
+			return WalEntry{}, fmt.Errorf("failed to decrypt WAL entry: %w", err)
+		}
+		if len(body) == 0 || body[0] == encryptedEntryMarker {
+			return WalEntry{}, fmt.Errorf("malformed encrypted WAL entry")
+		}
+		return decodeEntry(body)
+	}
+	if cmdByte&timestampFlag == 0 {
+		return WalEntry{CommandType: protocol.CommandType(cmdByte), Payload: entryData[1:]}, nil
+	}
+	if len(entryData) < 9 {
+		return WalEntry{}, fmt.Errorf("WAL entry too short for timestamp: %d bytes", len(entryData))
+	}
+	nanos := int64(binary.LittleEndian.Uint64(entryData[1:9]))
+	return WalEntry{
+		CommandType: protocol.CommandType(cmdByte &^ timestampFlag),
+		Payload:     entryData[9:],
+		Timestamp:   time.Unix(0, nanos),
+	}, nil
+}
+
+// Size returns the size of the WAL in bytes, including appended entries not yet flushed to the file
+// and sealed segments not yet removed by a checkpoint.
+func (w *WAL) Size() (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	info, err := w.file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat WAL file: %w", err)
+	}
+	size := info.Size() + int64(w.writer.Buffered())
+	segments, err := listSegments(w.path)
+	if err != nil {
+		return 0, err
+	}
+	for _, segment := range segments {
+		if info, err := os.Stat(segment.path); err == nil {
+			size += info.Size()
+		}
+	}
+	return size, nil
+}
+
 // Path returns the file path of the WAL.
 func (w *WAL) Path() string {
 	return w.path
 }
 
-// Rotate closes the current WAL file, deletes it, and opens a new one in its place.
+// Rotate closes the current WAL file, deletes it and every sealed segment, and opens a new one in its place.
 func (w *WAL) Rotate() error {
+	return w.rotate("")
+}
+
+// RotateArchive is like Rotate but moves the current WAL file to archivePath instead of deleting it.
+// Sealed segments are moved next to it, as archivePath with their segment suffix.
+func (w *WAL) RotateArchive(archivePath string) error {
+	return w.rotate(archivePath)
+}
+
+func (w *WAL) rotate(archivePath string) error {
+	err := w.replaceFile(func() error {
+		segments, err := listSegments(w.path)
+		if err != nil {
+			return err
+		}
+		for _, segment := range segments {
+			if archivePath != "" {
+				err = os.Rename(segment.path, segmentPath(archivePath, segment.number))
+			} else {
+				err = os.Remove(segment.path)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to rotate WAL segment '%s': %w", segment.path, err)
+			}
+		}
+		// The entries dropped here were never sealed, so the archive no longer holds them all.
+		w.clearArchiveHead()
+		// The new file gets a number of its own, so positions in the old one are not taken for positions in it.
+		w.lastSegment++
+		w.saveLastSegment()
+		if archivePath != "" {
+			if err := os.Rename(w.path, archivePath); err != nil {
+				return fmt.Errorf("failed to archive old WAL file: %w", err)
+			}
+		} else if err := os.Remove(w.path); err != nil {
+			return fmt.Errorf("failed to remove old WAL file: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("WAL file rotated successfully.")
+	return nil
+}
+
+// replaceFile syncs and closes the current WAL file, lets retire move or remove it, and opens a
+// new, empty file in its place. Appends wait until the new file is open.
+func (w *WAL) replaceFile(retire func() error) error {
+	// syncMu is taken first so no group sync is using the file while it is replaced.
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
@@ -142,22 +612,29 @@ func (w *WAL) Rotate() error {
 		w.file.Close()
 		return fmt.Errorf("failed to flush WAL before rotation: %w", err)
 	}
+	// Writers that appended before the rotation may still be waiting for their sync.
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("failed to sync WAL before rotation: %w", err)
+	}
+	w.synced = w.appended
 	if err := w.file.Close(); err != nil {
 		return fmt.Errorf("failed to close current WAL file for rotation: %w", err)
 	}
 
-	if err := os.Remove(w.path); err != nil {
-		return fmt.Errorf("failed to remove old WAL file: %w", err)
-	}
+	retireErr := retire()
 
+	// A new file is opened even if retiring the old one failed, so appends can go on.
 	file, err := os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to open new WAL file after rotation: %w", err)
 	}
-
 	w.file = file
 	w.writer.Reset(file)
-
-	slog.Info("WAL file rotated successfully.")
-	return nil
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat new WAL file after rotation: %w", err)
+	}
+	w.size = info.Size()
+	return retireErr
 }
@@ -0,0 +1,158 @@
+package wal
+
+import (
+	"bytes"
+	"fmt"
+	"memory-tools/internal/encryption"
+	"memory-tools/internal/protocol"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentWritesAreAllReplayed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+	w, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const writers, perWriter = 8, 50
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range perWriter {
+				entry := WalEntry{CommandType: protocol.CmdCollectionItemSet, Payload: fmt.Appendf(nil, "%d-%d", i, j)}
+				if err := w.Write(entry); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := Replay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for entry := range entries {
+		seen[string(entry.Payload)] = true
+	}
+	if len(seen) != writers*perWriter {
+		t.Fatalf("replayed %d distinct entries, want %d", len(seen), writers*perWriter)
+	}
+}
+
+func TestEncryptedSegmentsRoundTrip(t *testing.T) {
+	kr, err := encryption.NewKeyring(strings.Repeat("11", encryption.KeySize), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeyring(kr)
+	t.Cleanup(func() { SetKeyring(nil) })
+
+	path := filepath.Join(t.TempDir(), "test.wal")
+	w, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []string{"s3cr3t one", "s3cr3t two"} {
+		if err := w.Write(WalEntry{CommandType: protocol.CmdCollectionItemSet, Payload: []byte(payload)}); err != nil {
+			t.Fatal(err)
+		}
+		if payload == "s3cr3t one" {
+			// The first entry goes to a sealed segment, the second stays in the current file.
+			if _, err := w.Seal(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := listSegments(path)
+	if err != nil || len(segments) == 0 {
+		t.Fatalf("segments = %v, err %v", segments, err)
+	}
+	var files []string
+	for _, segment := range segments {
+		files = append(files, segment.path)
+	}
+	files = append(files, path)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("s3cr3t")) {
+			t.Fatalf("%s holds a plaintext payload", file)
+		}
+	}
+
+	entries, err := Replay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payloads []string
+	for entry := range entries {
+		payloads = append(payloads, string(entry.Payload))
+	}
+	if !slices.Equal(payloads, []string{"s3cr3t one", "s3cr3t two"}) {
+		t.Fatalf("replayed %q", payloads)
+	}
+
+	other, err := encryption.NewKeyring(strings.Repeat("22", encryption.KeySize), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetKeyring(other)
+	applied, err := ReplayFiles(files, func(WalEntry) {})
+	if err == nil || applied != 0 {
+		t.Fatalf("replay with another key applied %d entries, err %v; want it to fail", applied, err)
+	}
+}
+
+// BenchmarkSetManyWal compares logging a batch of documents as one entry per document with
+// logging it as the single SET_MANY entry written for the whole batch.
+func BenchmarkSetManyWal(b *testing.B) {
+	const batchSize = 100
+	doc := bytes.Repeat([]byte("x"), 200)
+	batch := bytes.Repeat(doc, batchSize)
+
+	b.Run("per-entry", func(b *testing.B) {
+		w, err := New(filepath.Join(b.TempDir(), "test.wal"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer w.Close()
+		for b.Loop() {
+			for range batchSize {
+				if err := w.Write(WalEntry{CommandType: protocol.CmdCollectionItemSet, Payload: doc}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("batched", func(b *testing.B) {
+		w, err := New(filepath.Join(b.TempDir(), "test.wal"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer w.Close()
+		for b.Loop() {
+			if err := w.Write(WalEntry{CommandType: protocol.CmdCollectionItemSetMany, Payload: batch}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
-	"bytes"
 	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
 	"io"
 	"log/slog"
+	"memory-tools/internal/certs"
 	"memory-tools/internal/config"
+	"memory-tools/internal/encryption"
 	"memory-tools/internal/globalconst"
 	"memory-tools/internal/handler"
 	"memory-tools/internal/persistence"
@@ -38,8 +42,38 @@ func (f updateActivityFunc) UpdateActivity() {
 	lastActivity.Store(time.Now())
 }
 
+// replayWalUntil applies the replayed entries up to until, or all of them if until is zero, and
+// drains the rest. It returns the number of entries applied and skipped.
+func replayWalUntil(entries <-chan wal.WalEntry, until time.Time, apply func(wal.WalEntry)) (replayed, skipped int) {
+	for entry := range entries {
+		// Legacy entries have no timestamp and always predate timestamped ones, so they are replayed.
+		if !until.IsZero() && entry.Timestamp.After(until) {
+			skipped = 1
+			for range entries {
+				skipped++
+			}
+			break
+		}
+		apply(entry)
+		replayed++
+	}
+	return replayed, skipped
+}
+
 func main() {
 	// --- Configuration and Initialization ---
+	replayUntilFlag := flag.String("replay-until", "", "Point-in-time recovery: stop WAL replay at the first entry after this RFC3339 time")
+	flag.Parse()
+	var replayUntil time.Time
+	if *replayUntilFlag != "" {
+		parsed, err := time.Parse(time.RFC3339, *replayUntilFlag)
+		if err != nil {
+			slog.Error("Fatal: invalid --replay-until value, expected RFC3339", "value", *replayUntilFlag, "error", err)
+			os.Exit(1)
+		}
+		replayUntil = parsed
+	}
+
 	if err := godotenv.Load(); err != nil {
 		slog.Info("No .env file found, proceeding with existing environment")
 	}
@@ -65,6 +99,28 @@ func main() {
 	slog.Info("Logger configured successfully")
 
 	cfg := config.LoadConfig()
+	protocol.SetReadBufferSize(cfg.ReadBufferSize)
+	handler.SetCollectionListDefaultLimit(cfg.CollectionListLimit)
+	handler.SetMaxQueryResponseBytes(cfg.MaxQueryResponseBytes)
+	handler.SetQueryConcurrencyLimit(cfg.MaxConcurrentQueries, cfg.QueryQueueSize)
+	handler.SetSlowQueryLog(cfg.SlowQueryThreshold, cfg.SlowQueryLogSize)
+	handler.SetRateLimit(cfg.RateLimit, cfg.RateLimitBurst, cfg.RateLimitPerCollection)
+	handler.SetMaxConnections(cfg.MaxConnections)
+	handler.SetColdStorageMonths(cfg.ColdStorageMonths)
+	handler.SetConnIdleTimeout(cfg.ConnIdleTimeout)
+	handler.SetColdPromotionThreshold(cfg.ColdPromotionThreshold)
+	handler.SetClientCertLogin(cfg.ClientCertLogin)
+
+	keyring, err := encryption.NewKeyring(cfg.EncryptionKey, cfg.EncryptionPreviousKeys)
+	if err != nil {
+		slog.Error("Fatal: invalid encryption key configuration", "error", err)
+		os.Exit(1)
+	}
+	persistence.SetEncryptionKeyring(keyring)
+	wal.SetKeyring(keyring)
+	if keyring.Current() != nil {
+		slog.Info("Encryption at rest is enabled.", "key_id", keyring.Current().IDString(), "previous_keys", len(cfg.EncryptionPreviousKeys))
+	}
 
 	var walInstance *wal.WAL
 	if cfg.EnableWal {
@@ -78,17 +134,46 @@ func main() {
 			slog.Error("Fatal: failed to initialize WAL", "error", err)
 			os.Exit(1)
 		}
+		walInstance.SetSyncPolicy(wal.SyncPolicy(cfg.WalSyncPolicy))
+		walInstance.SetMaxSize(cfg.WalMaxBytes)
+		if cfg.BackupIncremental {
+			if err := walInstance.SetArchiveDir(filepath.Join("data", "wal_archive")); err != nil {
+				slog.Error("Fatal: failed to enable WAL archiving for incremental backups", "error", err)
+				os.Exit(1)
+			}
+		}
 		defer walInstance.Close()
-		slog.Info("Write-Ahead Log (WAL) is enabled.", "path", walPath)
+		slog.Info("Write-Ahead Log (WAL) is enabled.", "path", walPath, "sync", cfg.WalSyncPolicy)
 	} else {
 		slog.Info("Write-Ahead Log (WAL) is disabled.")
 	}
 
+	persistence.SetRecoveryMode(persistence.RecoveryMode(cfg.RecoveryMode))
+	if cfg.BackupDestination == "s3" {
+		destination, err := persistence.NewS3BackupDestination(persistence.S3Config{
+			Endpoint:  cfg.BackupS3Endpoint,
+			Bucket:    cfg.BackupS3Bucket,
+			Region:    cfg.BackupS3Region,
+			AccessKey: cfg.BackupS3AccessKey,
+			SecretKey: cfg.BackupS3SecretKey,
+			Prefix:    cfg.BackupS3Prefix,
+		})
+		if err != nil {
+			slog.Error("Fatal: invalid S3 backup destination", "error", err)
+			os.Exit(1)
+		}
+		persistence.SetBackupDestination(destination)
+		slog.Info("Backups are stored in S3-compatible object storage.", "destination", destination.String(), "endpoint", cfg.BackupS3Endpoint)
+	}
+
 	mainInMemStore := store.NewInMemStoreWithShards(cfg.NumShards)
 	collectionPersister := &persistence.CollectionPersisterImpl{}
 	collectionManager := store.NewCollectionManager(collectionPersister, cfg.NumShards)
+	collectionManager.SetSaveFailureLimit(cfg.SaveFailureLimit)
+	collectionManager.SetCreatedTsIndex(cfg.IndexCreatedTs)
+	collectionManager.SetMemoryCap(cfg.CollectionMaxBytes, store.EvictionPolicy(cfg.EvictionPolicy))
 	transactionManager := store.NewTransactionManager(collectionManager)
-	transactionManager.StartGC(5*time.Minute, 10*time.Minute)
+	transactionManager.StartGC(cfg.TxTimeout, cfg.TxGCInterval)
 
 	// --- Data Loading and WAL Recovery ---
 	slog.Info("Loading data from snapshots...")
@@ -100,6 +185,7 @@ func main() {
 		slog.Error("Fatal error loading persistent collections data", "error", err)
 		os.Exit(1)
 	}
+	collectionManager.ApplyCollectionMeta()
 	slog.Info("Finished loading data from snapshots.")
 
 	if walInstance != nil {
@@ -115,49 +201,31 @@ func main() {
 		)
 		recoveryHandler.IsAuthenticated = true
 		recoveryHandler.IsRoot = true
-		replayedCount := 0
-		for entry := range entriesChan {
-			payloadReader := bytes.NewReader(entry.Payload)
-			switch entry.CommandType {
-			case protocol.CmdSet:
-				recoveryHandler.HandleMainStoreSet(payloadReader, nil)
-			case protocol.CmdCollectionCreate:
-				recoveryHandler.HandleCollectionCreate(payloadReader, nil)
-			case protocol.CmdCollectionDelete:
-				recoveryHandler.HandleCollectionDelete(payloadReader, nil)
-			case protocol.CmdCollectionIndexCreate:
-				recoveryHandler.HandleCollectionIndexCreate(payloadReader, nil)
-			case protocol.CmdCollectionIndexDelete:
-				recoveryHandler.HandleCollectionIndexDelete(payloadReader, nil)
-			case protocol.CmdCollectionItemSet:
-				recoveryHandler.HandleCollectionItemSet(payloadReader, nil)
-			case protocol.CmdCollectionItemSetMany:
-				recoveryHandler.HandleCollectionItemSetMany(payloadReader, nil)
-			case protocol.CmdCollectionItemDelete:
-				recoveryHandler.HandleCollectionItemDelete(payloadReader, nil)
-			case protocol.CmdCollectionItemDeleteMany:
-				recoveryHandler.HandleCollectionItemDeleteMany(payloadReader, nil)
-			case protocol.CmdCollectionItemUpdate:
-				recoveryHandler.HandleCollectionItemUpdate(payloadReader, nil)
-			case protocol.CmdCollectionItemUpdateMany:
-				recoveryHandler.HandleCollectionItemUpdateMany(payloadReader, nil)
-			case protocol.CmdChangeUserPassword:
-				recoveryHandler.HandleChangeUserPassword(payloadReader, nil)
-			case protocol.CmdUserCreate:
-				recoveryHandler.HandleUserCreate(payloadReader, nil)
-			case protocol.CmdUserUpdate:
-				recoveryHandler.HandleUserUpdate(payloadReader, nil)
-			case protocol.CmdUserDelete:
-				recoveryHandler.HandleUserDelete(payloadReader, nil)
-			case protocol.CmdCommit:
-				recoveryHandler.HandleCommit(payloadReader, nil)
-			case protocol.CmdRestore:
-				recoveryHandler.HandleRestore(payloadReader, nil)
-			}
-			replayedCount++
-		}
+		replayedCount, skippedCount := replayWalUntil(entriesChan, replayUntil, recoveryHandler.ApplyWalEntry)
 		handler.PutConnectionHandlerToPool(recoveryHandler)
 		slog.Info("WAL replay complete.", "replayed_entries", replayedCount)
+
+		if skippedCount > 0 {
+			// The recovered state becomes the new baseline: snapshot it and move the WAL aside,
+			// otherwise the skipped entries would be replayed on top of new writes on the next start.
+			slog.Warn("Point-in-time recovery stopped WAL replay early", "replay_until", replayUntil.Format(time.RFC3339), "skipped_entries", skippedCount)
+			if err := persistence.SaveData(mainInMemStore); err != nil {
+				slog.Error("Fatal: failed to snapshot main store after point-in-time recovery", "error", err)
+				os.Exit(1)
+			}
+			if err := persistence.SaveAllCollectionsFromManager(collectionManager); err != nil {
+				slog.Error("Fatal: failed to snapshot collections after point-in-time recovery", "error", err)
+				os.Exit(1)
+			}
+			archivePath := fmt.Sprintf("%s.pitr-%d", walInstance.Path(), time.Now().Unix())
+			if err := walInstance.RotateArchive(archivePath); err != nil {
+				slog.Error("Fatal: failed to archive WAL after point-in-time recovery", "error", err)
+				os.Exit(1)
+			}
+			slog.Info("Original WAL archived after point-in-time recovery", "archive_path", archivePath)
+		}
+	} else if !replayUntil.IsZero() {
+		slog.Warn("--replay-until has no effect because the WAL is disabled")
 	}
 
 	// --- Default User Creation ---
@@ -190,12 +258,42 @@ func main() {
 	}
 
 	// --- Server Startup and Workers ---
-	cert, err := tls.LoadX509KeyPair("certificates/server.crt", "certificates/server.key")
+	if cfg.GenerateSelfSignedCert {
+		generated, err := certs.EnsureSelfSigned(cfg.CertFile, cfg.KeyFile, cfg.CertHosts, cfg.CertValidity)
+		if err != nil {
+			slog.Error("Failed to generate self-signed certificate", "error", err)
+			os.Exit(1)
+		}
+		if generated {
+			slog.Warn("Using a generated self-signed TLS certificate. It is meant for development only; use a certificate from a trusted CA in production.", "cert", cfg.CertFile)
+		}
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
 	if err != nil {
 		slog.Error("Failed to load server certificate or key", "error", err)
 		os.Exit(1)
 	}
 	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	// Client certificates are checked against the configured CA. "require" rejects clients without
+	// one, while "optional" verifies a certificate when presented and otherwise falls back to AUTH.
+	if cfg.ClientAuth != "none" {
+		caPEM, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			slog.Error("Failed to read client CA file", "path", cfg.ClientCAFile, "error", err)
+			os.Exit(1)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caPEM) {
+			slog.Error("Client CA file contains no valid PEM certificates", "path", cfg.ClientCAFile)
+			os.Exit(1)
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.ClientAuth == "require" {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		slog.Info("TLS client certificate authentication enabled", "mode", cfg.ClientAuth, "client_ca", cfg.ClientCAFile, "cert_login", cfg.ClientCertLogin)
+	}
 	listener, err := tls.Listen("tcp", cfg.Port, tlsConfig)
 	if err != nil {
 		slog.Error("Fatal error starting TLS TCP server", "port", cfg.Port, "error", err)
@@ -205,6 +303,14 @@ func main() {
 	slog.Info("TLS TCP server listening securely", "port", cfg.Port)
 
 	backupManager := persistence.NewBackupManager(mainInMemStore, collectionManager, cfg.BackupInterval, cfg.BackupRetention)
+	if cfg.BackupIncremental {
+		if walInstance != nil {
+			backupManager.EnableIncremental(walInstance, cfg.BackupFullEvery)
+			slog.Info("Incremental backups enabled.", "full_every", cfg.BackupFullEvery)
+		} else {
+			slog.Warn("Incremental backups need the WAL; every backup will be a full one")
+		}
+	}
 	backupManager.Start()
 	defer backupManager.Stop()
 
@@ -218,6 +324,7 @@ func main() {
 				)
 				h.HandleConnection(conn)
 				handler.PutConnectionHandlerToPool(h)
+				handler.ReleaseConnection()
 			}
 		}(w)
 	}
@@ -234,6 +341,9 @@ func main() {
 				}
 				return
 			}
+			if !handler.AcquireConnection(conn) {
+				continue
+			}
 			jobs <- conn
 		}
 	}()
@@ -242,25 +352,52 @@ func main() {
 	shutdownChan := make(chan struct{})
 
 	// Global Checkpoint Worker
-	if cfg.EnableSnapshots {
+	// The WAL is sealed before the snapshots are taken, so writes that arrive during the checkpoint
+	// go to a new file; the sealed entries are only removed once the snapshots covering them are saved.
+	checkpoint := func() {
+		slog.Info("Performing global checkpoint...")
+		var segment uint64
+		sealed := false
+		if walInstance != nil {
+			var err error
+			if segment, err = walInstance.Seal(); err != nil {
+				slog.Error("Failed to seal WAL before checkpoint", "error", err)
+			} else {
+				sealed = true
+			}
+		}
+		err1 := persistence.SaveData(mainInMemStore)
+		err2 := persistence.SaveAllCollectionsFromManager(collectionManager)
+		if err1 != nil || err2 != nil {
+			slog.Error("Error during checkpoint snapshots", "main_store_error", err1, "collections_error", err2)
+			return
+		}
+		if sealed {
+			if err := walInstance.RemoveSegmentsUpTo(segment); err != nil {
+				slog.Error("CRITICAL: Failed to remove WAL segments after checkpoint", "error", err)
+			}
+		}
+	}
+	var walFull <-chan struct{}
+	if walInstance != nil && cfg.WalMaxBytes > 0 {
+		walFull = walInstance.Full()
+	}
+	if cfg.EnableSnapshots || walFull != nil {
 		go func() {
-			ticker := time.NewTicker(cfg.SnapshotInterval)
-			defer ticker.Stop()
-			slog.Info("Global Checkpoint Worker started", "interval", cfg.SnapshotInterval.String())
+			var tick <-chan time.Time
+			if cfg.EnableSnapshots {
+				ticker := time.NewTicker(cfg.SnapshotInterval)
+				defer ticker.Stop()
+				tick = ticker.C
+			}
+			slog.Info("Global Checkpoint Worker started", "interval", cfg.SnapshotInterval.String(), "snapshots", cfg.EnableSnapshots, "wal_max_bytes", cfg.WalMaxBytes)
 			for {
 				select {
-				case <-ticker.C:
-					slog.Info("Performing global checkpoint...")
-					err1 := persistence.SaveData(mainInMemStore)
-					err2 := persistence.SaveAllCollectionsFromManager(collectionManager)
-					if err1 != nil || err2 != nil {
-						slog.Error("Error during checkpoint snapshots", "main_store_error", err1, "collections_error", err2)
-					}
-					if err1 == nil && err2 == nil && walInstance != nil {
-						if err := walInstance.Rotate(); err != nil {
-							slog.Error("CRITICAL: Failed to rotate WAL file after checkpoint", "error", err)
-						}
-					}
+				case <-tick:
+					checkpoint()
+				case <-walFull:
+					slog.Info("WAL reached its maximum size, forcing a checkpoint", "wal_max_bytes", cfg.WalMaxBytes)
+					checkpoint()
 				case <-shutdownChan:
 					slog.Info("Global Checkpoint Worker stopped.")
 					return
@@ -322,7 +459,7 @@ func main() {
 						continue
 					}
 					for _, name := range collectionNames {
-						if err := persistence.CompactCollectionFile(name); err != nil {
+						if _, err := persistence.CompactCollectionFile(name); err != nil {
 							slog.Error("Failed to compact collection file", "collection", name, "error", err)
 						}
 					}
@@ -370,6 +507,16 @@ func main() {
 		slog.Info("TCP listener closed.")
 	}
 
+	// Connections finish the command they are running before the final save, so no write is
+	// cut off halfway; the ones still open after the shutdown timeout are closed.
+	slog.Info("Draining client connections...", "timeout", cfg.ShutdownTimeout.String())
+	drained, forced := handler.DrainConnections(cfg.ShutdownTimeout)
+	if forced > 0 {
+		slog.Warn("Client connections drained with some forcibly closed", "drained", drained, "forcibly_closed", forced)
+	} else {
+		slog.Info("Client connections drained", "drained", drained, "forcibly_closed", forced)
+	}
+
 	close(shutdownChan)
 	transactionManager.StopGC()
 
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"memory-tools/internal/protocol"
+	"memory-tools/internal/wal"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplayWalUntilStopsAtCutoff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+	w, err := wal.New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, payload := range []string{"a", "b", "c", "d"} {
+		entry := wal.WalEntry{CommandType: protocol.CmdSet, Payload: []byte(payload), Timestamp: base.Add(time.Duration(i) * time.Minute)}
+		if err := w.Write(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		until time.Time
+		want  string
+	}{
+		{"no cutoff", time.Time{}, "abcd"},
+		{"cutoff on an entry", base.Add(time.Minute), "ab"},
+		{"cutoff between entries", base.Add(150 * time.Second), "abc"},
+		{"cutoff before all", base.Add(-time.Second), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := wal.Replay(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var applied string
+			replayed, skipped := replayWalUntil(entries, tt.until, func(e wal.WalEntry) { applied += string(e.Payload) })
+			if applied != tt.want {
+				t.Errorf("applied %q, want %q", applied, tt.want)
+			}
+			if replayed != len(tt.want) || skipped != 4-len(tt.want) {
+				t.Errorf("replayed %d and skipped %d, want %d and %d", replayed, skipped, len(tt.want), 4-len(tt.want))
+			}
+		})
+	}
+}